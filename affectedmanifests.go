@@ -14,6 +14,11 @@ type AffectedManifests struct {
 	// map associating a list of vulnerability ids keyed by the
 	// manifest hash they affect.
 	VulnerableManifests map[string][]string `json:"vulnerable_manifests"`
+	// map associating a list of vulnerability ids keyed by the
+	// image index hash they affect, through any of the index's
+	// manifests. Only populated if image indexes have been
+	// recorded.
+	VulnerableIndexes map[string][]string `json:"vulnerable_indexes,omitempty"`
 }
 
 // NewAffectedManifests initializes a new AffectedManifests struct.
@@ -38,19 +43,54 @@ func (a *AffectedManifests) Add(v *Vulnerability, digests ...Digest) {
 	a.mu.Unlock()
 }
 
-// Sort will sort each array in the VulnerableManifests map
-// by Vulnerability.NormalizedSeverity in Desc order.
+// AddIndexes records that the image indexes provided contain the
+// manifest, and so are affected by all the Vulnerabilities affecting
+// the manifest. It must be called after all the manifest's
+// Vulnerabilities have been added.
+//
+// AddIndexes is safe to use by multiple goroutines.
+func (a *AffectedManifests) AddIndexes(manifest Digest, indexes ...Digest) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ids := a.VulnerableManifests[manifest.String()]
+	if len(ids) == 0 || len(indexes) == 0 {
+		return
+	}
+	if a.VulnerableIndexes == nil {
+		a.VulnerableIndexes = make(map[string][]string)
+	}
+	for _, d := range indexes {
+		hash := d.String()
+		seen := make(map[string]struct{}, len(a.VulnerableIndexes[hash]))
+		for _, id := range a.VulnerableIndexes[hash] {
+			seen[id] = struct{}{}
+		}
+		for _, id := range ids {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			a.VulnerableIndexes[hash] = append(a.VulnerableIndexes[hash], id)
+		}
+	}
+}
+
+// Sort will sort each array in the VulnerableManifests and
+// VulnerableIndexes maps by Vulnerability.NormalizedSeverity in Desc
+// order.
 //
 // Sort is safe to use by multiple goroutines.
 func (a *AffectedManifests) Sort() {
 	a.mu.Lock()
-	for _, ids := range a.VulnerableManifests {
-		sort.Slice(ids, func(i, j int) bool {
-			id1, id2 := ids[i], ids[j]
-			v1, v2 := a.Vulnerabilities[id1], a.Vulnerabilities[id2]
-			// reverse this since we want descending sort
-			return v1.NormalizedSeverity > v2.NormalizedSeverity
-		})
+	for _, m := range []map[string][]string{a.VulnerableManifests, a.VulnerableIndexes} {
+		for _, ids := range m {
+			sort.Slice(ids, func(i, j int) bool {
+				id1, id2 := ids[i], ids[j]
+				v1, v2 := a.Vulnerabilities[id1], a.Vulnerabilities[id2]
+				// reverse this since we want descending sort
+				return v1.NormalizedSeverity > v2.NormalizedSeverity
+			})
+		}
 	}
 	a.mu.Unlock()
 }
@@ -46,3 +46,34 @@ func TestAffectedManifestsAddAndSort(t *testing.T) {
 		t.Fatalf("got: %v, want: %v", v1.NormalizedSeverity, claircore.Unknown)
 	}
 }
+
+// TestAffectedManifestsAddIndexes confirms image indexes are reported as
+// affected by the vulnerabilities of their manifests.
+func TestAffectedManifestsAddIndexes(t *testing.T) {
+	vulns := test.GenUniqueVulnerabilities(3, "test-updater")
+	amd64 := claircore.MustParseDigest(`sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef`)
+	arm64 := claircore.MustParseDigest(`sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad`)
+	clean := claircore.MustParseDigest(`sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855`)
+	index := claircore.MustParseDigest(`sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae`)
+	affected := claircore.NewAffectedManifests()
+
+	vulns[2].NormalizedSeverity = claircore.Critical
+	affected.Add(vulns[0], amd64, arm64)
+	affected.Add(vulns[1], arm64)
+	affected.Add(vulns[2], arm64)
+	affected.AddIndexes(amd64, index)
+	affected.AddIndexes(arm64, index)
+	affected.AddIndexes(clean, index)
+	affected.Sort()
+
+	if got, want := len(affected.VulnerableIndexes), 1; got != want {
+		t.Fatalf("got: %d, want: %d", got, want)
+	}
+	ids := affected.VulnerableIndexes[index.String()]
+	if got, want := len(ids), 3; got != want {
+		t.Fatalf("got: %v, want: %v", ids, want)
+	}
+	if got, want := affected.Vulnerabilities[ids[0]].NormalizedSeverity, claircore.Critical; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
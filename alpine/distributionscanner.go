@@ -37,6 +37,7 @@ const (
 var (
 	_ indexer.DistributionScanner = (*DistributionScanner)(nil)
 	_ indexer.VersionedScanner    = (*DistributionScanner)(nil)
+	_ indexer.FileInterest        = (*DistributionScanner)(nil)
 
 	issueRegexp = regexp.MustCompile(`Alpine Linux ([[:digit:]]+\.[[:digit:]]+)`)
 )
@@ -54,6 +55,11 @@ func (*DistributionScanner) Version() string { return scannerVersion }
 // Kind implements scanner.VersionedScanner.
 func (*DistributionScanner) Kind() string { return scannerKind }
 
+// FilesOfInterest implements indexer.FileInterest.
+func (*DistributionScanner) FilesOfInterest() []string {
+	return []string{osrelease.Path, issuePath}
+}
+
 // Scan will inspect the layer for an os-release or lsb-release file
 // and perform a regex match for keywords indicating the associated alpine release
 //
@@ -3,9 +3,9 @@ package alpine
 import (
 	"context"
 
-	version "github.com/knqyf263/go-apk-version"
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/pkg/apkver"
 )
 
 // Matcher implements driver.Matcher for Alpine containers.
@@ -44,28 +44,23 @@ func (*Matcher) Query() []driver.MatchConstraint {
 }
 
 // Vulnerable implements driver.Matcher.
+//
+// Versions are compared with apk's algorithm. A FixedInVersion of "0" means
+// the package was never affected. A missing package version, or a version
+// that isn't a valid apk version, can't be compared, so the package isn't
+// reported as vulnerable.
 func (*Matcher) Vulnerable(ctx context.Context, record *claircore.IndexRecord, vuln *claircore.Vulnerability) (bool, error) {
-	v1, err := version.NewVersion(record.Package.Version)
-	if err != nil {
+	if v := record.Package.Version; v == "" || !apkver.Valid(v) {
 		return false, nil
 	}
-
-	v2, err := version.NewVersion(vuln.FixedInVersion)
-	if err != nil {
-		return false, nil
-	}
-
-	if vuln.FixedInVersion == "" {
+	switch vuln.FixedInVersion {
+	case "":
 		return true, nil
-	}
-
-	if vuln.FixedInVersion == "0" {
+	case "0":
 		return false, nil
 	}
-
-	if v1.LessThan(v2) {
-		return true, nil
+	if !apkver.Valid(vuln.FixedInVersion) {
+		return false, nil
 	}
-
-	return false, nil
+	return apkver.Compare(record.Package.Version, vuln.FixedInVersion) < 0, nil
 }
@@ -0,0 +1,48 @@
+package alpine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestVulnerable(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	tt := []struct {
+		Package string
+		Fixed   string
+		Want    bool
+	}{
+		{Package: "1.1.1k-r0", Fixed: "1.1.1k-r1", Want: true},
+		{Package: "1.1.1k-r1", Fixed: "1.1.1k-r1", Want: false},
+		{Package: "1.1.1l-r0", Fixed: "1.1.1k-r1", Want: false},
+		{Package: "2.35-r17", Fixed: "2.35-r9", Want: false},
+		{Package: "2.35-r9", Fixed: "2.35-r17", Want: true},
+		{Package: "3.1_rc2-r0", Fixed: "3.1-r0", Want: true},
+		{Package: "3.1_p1-r0", Fixed: "3.1-r1", Want: false},
+		{Package: "1.2.3-r0", Fixed: "", Want: true},
+		{Package: "1.2.3-r0", Fixed: "0", Want: false},
+		{Package: "", Fixed: "1.2.3-r0", Want: false},
+		{Package: "", Fixed: "", Want: false},
+		{Package: "not a version", Fixed: "1.2.3-r0", Want: false},
+		{Package: "1.2.3-r0", Fixed: "1.2.4-bad", Want: false},
+	}
+	var m Matcher
+	for _, tc := range tt {
+		r := &claircore.IndexRecord{
+			Package: &claircore.Package{Version: tc.Package},
+		}
+		v := &claircore.Vulnerability{FixedInVersion: tc.Fixed}
+		got, err := m.Vulnerable(ctx, r, v)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if want := tc.Want; got != want {
+			t.Errorf("%q fixed in %q: got: %v, want: %v", tc.Package, tc.Fixed, got, want)
+		}
+	}
+}
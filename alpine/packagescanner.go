@@ -23,6 +23,7 @@ const (
 var (
 	_ indexer.VersionedScanner = (*Scanner)(nil)
 	_ indexer.PackageScanner   = (*Scanner)(nil)
+	_ indexer.FileInterest     = (*Scanner)(nil)
 )
 
 // Scanner scans for packages in an apk database.
@@ -39,6 +40,11 @@ func (*Scanner) Version() string { return pkgVersion }
 // Kind implements indexer.VersionedScanner.
 func (*Scanner) Kind() string { return pkgKind }
 
+// FilesOfInterest implements indexer.FileInterest.
+func (*Scanner) FilesOfInterest() []string {
+	return []string{installedFile}
+}
+
 const installedFile = "lib/apk/db/installed"
 
 // Scan examines a layer for an apk installation database, and extracts
@@ -7,6 +7,7 @@ import (
 
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/pkg/kmod"
 )
 
 type Matcher struct{}
@@ -40,7 +41,7 @@ func (*Matcher) Query() []driver.MatchConstraint {
 }
 
 func (*Matcher) Vulnerable(ctx context.Context, record *claircore.IndexRecord, vuln *claircore.Vulnerability) (bool, error) {
-	v1 := version.NewVersion(record.Package.Version)
+	v1 := version.NewVersion(kmod.MatchVersion(record.Package, vuln.FixedInVersion))
 	v2 := version.NewVersion(vuln.FixedInVersion)
 
 	if vuln.FixedInVersion == "" {
@@ -0,0 +1,22 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quay/claircore/libvuln/driver/drivertest"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	u, err := NewUpdater(Linux1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := drivertest.Golden{
+		Updater: u,
+		Fixture: "testdata/updateinfo_linux1.xml",
+	}
+	t.Run(g.Fixture, g.Run(ctx))
+}
@@ -146,6 +146,7 @@ func (e *affectedE2E) Run(t *testing.T) {
 		{"IndexArtifacts", e.IndexArtifacts},
 		{"IndexManifest", e.IndexManifest},
 		{"AffectedManifests", e.AffectedManifests},
+		{"AffectedManifestsPage", e.AffectedManifestsPage},
 	}
 	for _, subtest := range subtests {
 		if !t.Run(subtest.name, subtest.do) {
@@ -288,3 +289,32 @@ func (e *affectedE2E) AffectedManifests(t *testing.T) {
 		}
 	}
 }
+
+// AffectedManifestsPage confirms each vulnerability reports the associated
+// manifest on the first page, and nothing after it.
+func (e *affectedE2E) AffectedManifestsPage(t *testing.T) {
+	ctx := zlog.Test(e.ctx, t)
+	om := omnimatcher.New(nil)
+	s := e.store.(indexer.AffectedManifestsPager)
+	wanted := e.ir.Hash.String()
+	for _, vuln := range e.vr.Vulnerabilities {
+		hashes, err := s.AffectedManifestsPage(ctx, *vuln, om.Vulnerable, "", 1)
+		if err != nil {
+			t.Fatalf("failed to retrieve affected manifest for vuln %s: %v", vuln.ID, err)
+		}
+		if len(hashes) != 1 {
+			t.Fatalf("got: len(hashes)==%d, want: len(hashes)==1", len(hashes))
+		}
+		if got := hashes[0].String(); got != wanted {
+			t.Fatalf("got: %v, want: %v", got, wanted)
+		}
+
+		hashes, err = s.AffectedManifestsPage(ctx, *vuln, om.Vulnerable, wanted, 1)
+		if err != nil {
+			t.Fatalf("failed to retrieve affected manifest for vuln %s: %v", vuln.ID, err)
+		}
+		if len(hashes) != 0 {
+			t.Fatalf("got: len(hashes)==%d, want: len(hashes)==0", len(hashes))
+		}
+	}
+}
@@ -69,21 +69,6 @@ var (
 // artifacts.
 func (s *IndexerStore) AffectedManifests(ctx context.Context, v claircore.Vulnerability, vulnFunc claircore.CheckVulnernableFunc) ([]claircore.Digest, error) {
 	const (
-		selectPackages = `
-SELECT
-	id,
-	name,
-	version,
-	kind,
-	norm_kind,
-	norm_version,
-	module,
-	arch
-FROM
-	package
-WHERE
-	name = $1;
-`
 		selectAffected = `
 SELECT
 	manifest.hash
@@ -109,6 +94,173 @@ WHERE
 	)
 	ctx = zlog.ContextWithValues(ctx, "component", "datastore/postgres/affectedManifests")
 
+	filteredRecords, err := s.vulnerableRecords(ctx, v, vulnFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Query the manifest index for manifests containing the vulnerable
+	// IndexRecords and create a set containing each unique manifest.
+	set := map[string]struct{}{}
+	out := []claircore.Digest{}
+	for _, record := range filteredRecords {
+		v, err := toValues(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve record %+v to sql values for query: %w", record, err)
+		}
+
+		err = func() error {
+			tctx, done := context.WithTimeout(ctx, 30*time.Second)
+			defer done()
+			start := time.Now()
+			rows, err := s.pool.Query(tctx,
+				selectAffected,
+				record.Package.ID,
+				v[2],
+				v[3],
+			)
+			switch {
+			case errors.Is(err, nil):
+			case errors.Is(err, pgx.ErrNoRows):
+				err = fmt.Errorf("failed to query the manifest index: %w", err)
+				fallthrough
+			default:
+				return err
+			}
+			defer rows.Close()
+			affectedManifestsCounter.WithLabelValues("selectAffected").Add(1)
+			affectedManifestsDuration.WithLabelValues("selectAffected").Observe(time.Since(start).Seconds())
+
+			for rows.Next() {
+				var hash claircore.Digest
+				err := rows.Scan(&hash)
+				if err != nil {
+					return fmt.Errorf("failed scanning manifest hash into digest: %w", err)
+				}
+				if _, ok := set[hash.String()]; !ok {
+					set[hash.String()] = struct{}{}
+					out = append(out, hash)
+				}
+			}
+			return rows.Err()
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	zlog.Debug(ctx).Int("count", len(out)).Msg("affected manifests")
+	return out, nil
+}
+
+// AffectedManifestsPage implements indexer.AffectedManifestsPager.
+//
+// Manifests are found with one query for all the vulnerable packages, so the
+// page can be cut in the database.
+func (s *IndexerStore) AffectedManifestsPage(ctx context.Context, v claircore.Vulnerability, vulnFunc claircore.CheckVulnernableFunc, after string, limit int) ([]claircore.Digest, error) {
+	// The hashes are compared bytewise, as the cursor is, whatever the
+	// database's collation.
+	const selectAffectedPage = `
+SELECT DISTINCT
+	manifest.hash COLLATE "C"
+FROM
+	manifest_index
+	JOIN manifest ON
+			manifest_index.manifest_id = manifest.id
+WHERE
+	package_id = ANY($1::INT8[])
+	AND (
+			CASE
+			WHEN $2::INT8 IS NULL THEN dist_id IS NULL
+			ELSE dist_id = $2
+			END
+		)
+	AND (
+			CASE
+			WHEN $3::INT8 IS NULL THEN repo_id IS NULL
+			ELSE repo_id = $3
+			END
+		)
+	AND manifest.hash COLLATE "C" > $4
+ORDER BY
+	1
+LIMIT $5;
+`
+	ctx = zlog.ContextWithValues(ctx, "component", "datastore/postgres/affectedManifestsPage")
+	if limit < 1 {
+		return nil, fmt.Errorf("invalid page size: %d", limit)
+	}
+
+	records, err := s.vulnerableRecords(ctx, v, vulnFunc)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return []claircore.Digest{}, nil
+	}
+	ids := make([]int64, len(records))
+	for i, r := range records {
+		if ids[i], err = strconv.ParseInt(r.Package.ID, 10, 64); err != nil {
+			return nil, fmt.Errorf("package id %v: %w", r.Package.ID, err)
+		}
+	}
+	// The records share a distribution and repository.
+	vs, err := toValues(records[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve record %+v to sql values for query: %w", records[0], err)
+	}
+
+	tctx, done := context.WithTimeout(ctx, 30*time.Second)
+	defer done()
+	start := time.Now()
+	rows, err := s.pool.Query(tctx, selectAffectedPage, ids, vs[2], vs[3], after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the manifest index: %w", err)
+	}
+	defer rows.Close()
+	affectedManifestsCounter.WithLabelValues("selectAffectedPage").Add(1)
+	affectedManifestsDuration.WithLabelValues("selectAffectedPage").Observe(time.Since(start).Seconds())
+
+	out := make([]claircore.Digest, 0, limit)
+	for rows.Next() {
+		var hash claircore.Digest
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed scanning manifest hash into digest: %w", err)
+		}
+		out = append(out, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	zlog.Debug(ctx).Int("count", len(out)).Msg("affected manifests")
+	return out, nil
+}
+
+// VulnerableRecords returns the IndexRecords for the indexed packages with the
+// vulnerability's package name that "vulnFunc" reports as vulnerable. The
+// records all share the Distribution and Repository the vulnerability
+// resolves to.
+//
+// If the vulnerability can't apply to anything indexed, no records are
+// returned.
+func (s *IndexerStore) vulnerableRecords(ctx context.Context, v claircore.Vulnerability, vulnFunc claircore.CheckVulnernableFunc) ([]claircore.IndexRecord, error) {
+	const (
+		selectPackages = `
+SELECT
+	id,
+	name,
+	version,
+	kind,
+	norm_kind,
+	norm_version,
+	module,
+	arch
+FROM
+	package
+WHERE
+	name = $1;
+`
+	)
+
 	// confirm the incoming vuln can be
 	// resolved into a prototype index record
 	pr, err := protoRecord(ctx, s.pool, v)
@@ -134,7 +286,7 @@ WHERE
 	switch {
 	case errors.Is(err, nil):
 	case errors.Is(err, pgx.ErrNoRows):
-		return []claircore.Digest{}, nil
+		return nil, nil
 	default:
 		return nil, fmt.Errorf("failed to query packages associated with vulnerability %q: %w", v.ID, err)
 	}
@@ -195,57 +347,7 @@ WHERE
 	}
 	zlog.Debug(ctx).Int("count", len(filteredRecords)).Msg("vulnerable index records")
 
-	// Query the manifest index for manifests containing the vulnerable
-	// IndexRecords and create a set containing each unique manifest.
-	set := map[string]struct{}{}
-	out := []claircore.Digest{}
-	for _, record := range filteredRecords {
-		v, err := toValues(record)
-		if err != nil {
-			return nil, fmt.Errorf("failed to resolve record %+v to sql values for query: %w", record, err)
-		}
-
-		err = func() error {
-			tctx, done := context.WithTimeout(ctx, 30*time.Second)
-			defer done()
-			start := time.Now()
-			rows, err := s.pool.Query(tctx,
-				selectAffected,
-				record.Package.ID,
-				v[2],
-				v[3],
-			)
-			switch {
-			case errors.Is(err, nil):
-			case errors.Is(err, pgx.ErrNoRows):
-				err = fmt.Errorf("failed to query the manifest index: %w", err)
-				fallthrough
-			default:
-				return err
-			}
-			defer rows.Close()
-			affectedManifestsCounter.WithLabelValues("selectAffected").Add(1)
-			affectedManifestsDuration.WithLabelValues("selectAffected").Observe(time.Since(start).Seconds())
-
-			for rows.Next() {
-				var hash claircore.Digest
-				err := rows.Scan(&hash)
-				if err != nil {
-					return fmt.Errorf("failed scanning manifest hash into digest: %w", err)
-				}
-				if _, ok := set[hash.String()]; !ok {
-					set[hash.String()] = struct{}{}
-					out = append(out, hash)
-				}
-			}
-			return rows.Err()
-		}()
-		if err != nil {
-			return nil, err
-		}
-	}
-	zlog.Debug(ctx).Int("count", len(out)).Msg("affected manifests")
-	return out, nil
+	return filteredRecords, nil
 }
 
 // protoRecord is a helper method which resolves a Vulnerability to an IndexRecord with no Package defined.
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -31,13 +32,69 @@ var (
 	)
 )
 
+// DeleteManifests implements indexer.Setter.
+//
+// Once the manifests are gone, the layers, packages, distributions, and
+// repositories they referred to are removed as well, if nothing else refers to
+// them. Only those rows are looked at, so rows no manifest has ever referred
+// to, such as a squashed layer's, are left alone.
 func (s *IndexerStore) DeleteManifests(ctx context.Context, d ...claircore.Digest) ([]claircore.Digest, error) {
 	ctx = zlog.ContextWithValues(ctx, "component", "datastore/postgres/DeleteManifests")
+	refs, err := s.manifestRefs(ctx, d)
+	if err != nil {
+		return nil, err
+	}
 	rm, err := s.deleteManifests(ctx, d)
 	if err != nil {
 		return nil, err
 	}
-	return rm, s.layerCleanup(ctx)
+	if len(rm) == 0 {
+		return rm, nil
+	}
+	if err := s.layerCleanup(ctx, refs.layers); err != nil {
+		return rm, err
+	}
+	return rm, s.artifactCleanup(ctx, refs)
+}
+
+// ManifestRefs holds the IDs of the rows some manifests refer to, either
+// directly or through their layers.
+type manifestRefs struct {
+	layers, packages, dists, repos []int64
+}
+
+// ManifestRefs reports the rows the named manifests refer to. It must be
+// called before the manifests are deleted.
+func (s *IndexerStore) manifestRefs(ctx context.Context, d []claircore.Digest) (*manifestRefs, error) {
+	const query = `
+WITH
+	m AS (SELECT id FROM manifest WHERE hash = ANY($1::TEXT[])),
+	l AS (SELECT DISTINCT layer_id AS id FROM manifest_layer WHERE manifest_id IN (SELECT id FROM m)),
+	i AS (SELECT package_id, dist_id, repo_id FROM manifest_index WHERE manifest_id IN (SELECT id FROM m))
+SELECT
+	ARRAY(SELECT id FROM l),
+	ARRAY(
+		SELECT package_id FROM i WHERE package_id IS NOT NULL
+		UNION SELECT package_id FROM package_scanartifact WHERE layer_id IN (SELECT id FROM l)
+		UNION SELECT source_id FROM package_scanartifact WHERE layer_id IN (SELECT id FROM l)),
+	ARRAY(
+		SELECT dist_id FROM i WHERE dist_id IS NOT NULL
+		UNION SELECT dist_id FROM dist_scanartifact WHERE layer_id IN (SELECT id FROM l)),
+	ARRAY(
+		SELECT repo_id FROM i WHERE repo_id IS NOT NULL
+		UNION SELECT repo_id FROM repo_scanartifact WHERE layer_id IN (SELECT id FROM l));`
+	var err error
+	defer promTimer(deleteManifestsDuration, "manifestRefs", &err)()
+	defer func(e *error) {
+		deleteManifestsCounter.WithLabelValues("manifestRefs", success(*e)).Inc()
+	}(&err)
+	var refs manifestRefs
+	err = s.pool.QueryRow(ctx, query, digestSlice(d)).
+		Scan(&refs.layers, &refs.packages, &refs.dists, &refs.repos)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find manifest references: %w", err)
+	}
+	return &refs, nil
 }
 
 func (s *IndexerStore) deleteManifests(ctx context.Context, d []claircore.Digest) ([]claircore.Digest, error) {
@@ -72,10 +129,11 @@ func (s *IndexerStore) deleteManifests(ctx context.Context, d []claircore.Digest
 	return rm, nil
 }
 
-func (s *IndexerStore) layerCleanup(ctx context.Context) (err error) {
-	const layerCleanup = `DELETE FROM layer WHERE NOT EXISTS (SELECT FROM manifest_layer WHERE manifest_layer.layer_id = layer.id);`
+// LayerCleanup removes the layers in "ids" that no manifest refers to.
+func (s *IndexerStore) layerCleanup(ctx context.Context, ids []int64) (err error) {
+	const layerCleanup = `DELETE FROM layer WHERE id = ANY($1::BIGINT[]) AND NOT EXISTS (SELECT FROM manifest_layer WHERE manifest_layer.layer_id = layer.id);`
 	defer promTimer(deleteManifestsDuration, "layerCleanup", &err)()
-	tag, err := s.pool.Exec(ctx, layerCleanup)
+	tag, err := s.pool.Exec(ctx, layerCleanup, ids)
 	deleteManifestsCounter.WithLabelValues("layerCleanup", success(err)).Inc()
 	if err != nil {
 		return err
@@ -85,3 +143,43 @@ func (s *IndexerStore) layerCleanup(ctx context.Context) (err error) {
 		Msg("deleted layers")
 	return nil
 }
+
+// ArtifactCleanup removes the packages, distributions, and repositories in
+// "refs" that aren't referenced by any layer's scan artifacts or any
+// manifest's index.
+//
+// No table locks are taken: each DELETE only locks the rows it removes, so
+// indexing carries on alongside it. Indexing inserts an artifact and then
+// refers to it in a separate statement, so an index that loses a race with
+// the cleanup fails, and succeeds when the manifest is next indexed.
+func (s *IndexerStore) artifactCleanup(ctx context.Context, refs *manifestRefs) (err error) {
+	const (
+		packageCleanup = `DELETE FROM package WHERE id = ANY($1::BIGINT[]) AND NOT EXISTS (SELECT FROM package_scanartifact WHERE package_scanartifact.package_id = package.id) AND NOT EXISTS (SELECT FROM package_scanartifact WHERE package_scanartifact.source_id = package.id) AND NOT EXISTS (SELECT FROM manifest_index WHERE manifest_index.package_id = package.id);`
+		distCleanup    = `DELETE FROM dist WHERE id = ANY($1::BIGINT[]) AND NOT EXISTS (SELECT FROM dist_scanartifact WHERE dist_scanartifact.dist_id = dist.id) AND NOT EXISTS (SELECT FROM manifest_index WHERE manifest_index.dist_id = dist.id);`
+		repoCleanup    = `DELETE FROM repo WHERE id = ANY($1::BIGINT[]) AND NOT EXISTS (SELECT FROM repo_scanartifact WHERE repo_scanartifact.repo_id = repo.id) AND NOT EXISTS (SELECT FROM manifest_index WHERE manifest_index.repo_id = repo.id);`
+	)
+	defer promTimer(deleteManifestsDuration, "artifactCleanup", &err)()
+	defer func() {
+		deleteManifestsCounter.WithLabelValues("artifactCleanup", success(err)).Inc()
+	}()
+	for _, q := range []struct {
+		name, sql string
+		ids       []int64
+	}{
+		{"packages", packageCleanup, refs.packages},
+		{"distributions", distCleanup, refs.dists},
+		{"repositories", repoCleanup, refs.repos},
+	} {
+		if len(q.ids) == 0 {
+			continue
+		}
+		tag, err := s.pool.Exec(ctx, q.sql, q.ids)
+		if err != nil {
+			return fmt.Errorf("unable to remove unreferenced %s: %w", q.name, err)
+		}
+		zlog.Debug(ctx).
+			Int64("count", tag.RowsAffected()).
+			Msgf("deleted %s", q.name)
+	}
+	return nil
+}
@@ -156,6 +156,98 @@ INSERT INTO manifest_layer (i, manifest_id, layer_id)
 			t.Errorf("left overlayers: got: %d, want %d", got, want)
 		}
 	})
+	t.Run("Artifacts", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		vscnrs := test.GenUniquePackageScanners(1)
+		if err := pgtest.InsertUniqueScanners(ctx, pool, vscnrs); err != nil {
+			t.Fatal(err)
+		}
+		// Manifest "a" has a layer of its own and one shared with "b".
+		ms := []claircore.Digest{test.RandomSHA256Digest(t), test.RandomSHA256Digest(t)}
+		own, shared := test.RandomSHA256Digest(t), test.RandomSHA256Digest(t)
+		if _, err := pool.Exec(ctx, insertManifest, digestSlice(ms)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, insertLayers, digestSlice([]claircore.Digest{own, shared})); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, assoc, digestSlice([]claircore.Digest{own, shared}), ms[0]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, assoc, digestSlice([]claircore.Digest{shared}), ms[1]); err != nil {
+			t.Fatal(err)
+		}
+		pkgs := test.GenUniquePackages(4)
+		if err := store.IndexPackages(ctx, pkgs[:2], &claircore.Layer{Hash: own}, vscnrs[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.IndexPackages(ctx, pkgs[2:], &claircore.Layer{Hash: shared}, vscnrs[0]); err != nil {
+			t.Fatal(err)
+		}
+		// Each package comes with a source package.
+		count := func() (n int) {
+			const q = `SELECT COUNT(*) FROM package WHERE name LIKE 'package-%' OR name LIKE 'source-package-%';`
+			if err := pool.QueryRow(ctx, q).Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+		if got, want := count(), 8; got != want {
+			t.Fatalf("packages: got: %d, want: %d", got, want)
+		}
+
+		for _, tc := range []struct {
+			manifest claircore.Digest
+			left     int
+		}{
+			{manifest: ms[0], left: 4},
+			{manifest: ms[1], left: 0},
+		} {
+			if _, err := store.DeleteManifests(ctx, tc.manifest); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := count(), tc.left; got != want {
+				t.Errorf("packages left: got: %d, want: %d", got, want)
+			}
+		}
+	})
+	t.Run("Unrelated", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		// Rows no manifest refers to, like a squashed layer, aren't the
+		// deleted manifest's to clean up.
+		m, l, orphan := test.RandomSHA256Digest(t), test.RandomSHA256Digest(t), test.RandomSHA256Digest(t)
+		if _, err := pool.Exec(ctx, insertManifest, digestSlice([]claircore.Digest{m})); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, insertLayers, digestSlice([]claircore.Digest{l, orphan})); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, assoc, digestSlice([]claircore.Digest{l}), m); err != nil {
+			t.Fatal(err)
+		}
+		const insertPackage = `INSERT INTO package (name) VALUES ($1);`
+		name := "orphan-" + orphan.String()
+		if _, err := pool.Exec(ctx, insertPackage, name); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.DeleteManifests(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM layer WHERE hash = ANY($1::TEXT[]);`, digestSlice([]claircore.Digest{l, orphan})).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := n, 1; got != want {
+			t.Errorf("layers left: got: %d, want: %d", got, want)
+		}
+		if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM package WHERE name = $1;`, name).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := n, 1; got != want {
+			t.Errorf("packages left: got: %d, want: %d", got, want)
+		}
+	})
 }
 
 var cmpOpts cmp.Option = cmp.Transformer("DigestTransformer", func(d claircore.Digest) string { return d.String() })
@@ -89,7 +89,7 @@ func (s *MatcherStore) Get(ctx context.Context, records []*claircore.IndexRecord
 			}
 
 			var id int64
-			err := rows.Scan(
+			dst := []interface{}{
 				&id,
 				&v.Name,
 				&v.Description,
@@ -116,7 +116,11 @@ func (s *MatcherStore) Get(ctx context.Context, records []*claircore.IndexRecord
 				&v.Repo.URI,
 				&v.FixedInVersion,
 				&v.Updater,
-			)
+			}
+			if opts.UpdateRefs {
+				dst = append(dst, &v.UpdateRef)
+			}
+			err := rows.Scan(dst...)
 			v.ID = strconv.FormatInt(id, 10)
 			if err != nil {
 				res.Close()
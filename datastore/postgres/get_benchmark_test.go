@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/datastore"
+	"github.com/quay/claircore/test/integration"
+	pgtest "github.com/quay/claircore/test/postgres"
+)
+
+// Benchmark_Get measures Get against a synthetic store of language package
+// vulnerabilities, with and without ecosystem name normalization in play.
+//
+// The store has a million rows, or ten thousand in short mode.
+func Benchmark_Get(b *testing.B) {
+	integration.NeedDB(b)
+	ctx := zlog.Test(context.Background(), b)
+	pool := pgtest.TestMatcherDB(ctx, b)
+	store := NewMatcherStore(pool)
+
+	rows := 1_000_000
+	if testing.Short() {
+		rows = 10_000
+	}
+	// A quarter of the rows are PyPI vulnerabilities with names in a mix of
+	// spellings, the rest are spread over other ecosystems.
+	const populate = `
+INSERT INTO vuln (
+	hash_kind, hash, updater, name, description, issued, links, severity, normalized_severity,
+	package_name, package_version, package_module, package_arch, package_kind,
+	dist_id, dist_name, dist_version, dist_version_code_name, dist_version_id, dist_arch, dist_cpe, dist_pretty_name,
+	repo_name, repo_key, repo_uri, fixed_in_version, arch_operation, version_kind,
+	normalized_package_name
+)
+SELECT
+	'md5', decode(md5(i::text), 'hex'), 'benchmark', 'VULN-' || i, '', now(), '', '', 'Unknown',
+	n.name, '', '', '', 'binary',
+	'', '', '', '', '', '', '', '',
+	n.repo, '', '', '', '', '',
+	CASE n.repo WHEN 'pypi' THEN lower(replace(n.name, '_', '-')) ELSE n.name END
+FROM generate_series(1, $1) AS i,
+LATERAL (SELECT
+	CASE i % 4 WHEN 0 THEN 'pypi' WHEN 1 THEN 'npm' WHEN 2 THEN 'maven' ELSE 'rhel' END AS repo,
+	CASE i % 3 WHEN 0 THEN 'Package_' ELSE 'package-' END || (i % ($1 / 10)) AS name
+) AS n;`
+	if _, err := pool.Exec(ctx, populate, rows); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := pool.Exec(ctx, `ANALYZE vuln;`); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, n := range []int{100, 1000} {
+		for _, bench := range []struct {
+			name string
+			repo *claircore.Repository
+		}{
+			{name: "Exact"},
+			{name: "Normalized", repo: &claircore.Repository{Name: "pypi"}},
+		} {
+			b.Run(fmt.Sprintf("%s/%d", bench.name, n), func(b *testing.B) {
+				ctx := zlog.Test(ctx, b)
+				rs := make([]*claircore.IndexRecord, n)
+				for i := range rs {
+					rs[i] = &claircore.IndexRecord{
+						Package: &claircore.Package{
+							ID:     fmt.Sprint(i),
+							Name:   fmt.Sprintf("package-%d", i*4),
+							Kind:   claircore.BINARY,
+							Source: &claircore.Package{},
+						},
+						Repository: bench.repo,
+					}
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := store.Get(ctx, rs, datastore.GetOpts{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
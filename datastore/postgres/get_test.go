@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/datastore"
+	"github.com/quay/claircore/test/integration"
+	pgtest "github.com/quay/claircore/test/postgres"
+)
+
+// TestGetNormalizedName checks that ecosystem name normalization only ever
+// adds results: everything matched by the exact package name is still
+// returned.
+func TestGetNormalizedName(t *testing.T) {
+	integration.NeedDB(t)
+	ctx := zlog.Test(context.Background(), t)
+	pool := pgtest.TestMatcherDB(ctx, t)
+	store := NewMatcherStore(pool)
+
+	pypi := &claircore.Repository{Name: "pypi"}
+	rhel := &claircore.Repository{Name: "rhel"}
+	vs := []*claircore.Vulnerability{
+		{Name: "PYPI-EXACT", Package: &claircore.Package{Name: "Flask_SQLAlchemy", Kind: claircore.BINARY}, Repo: pypi},
+		{Name: "PYPI-NORMAL", Package: &claircore.Package{Name: "flask-sqlalchemy", Kind: claircore.BINARY}, Repo: pypi},
+		{Name: "PYPI-DOTTED", Package: &claircore.Package{Name: "Flask.SQLAlchemy", Kind: claircore.BINARY}, Repo: pypi},
+		{Name: "OTHER-EXACT", Package: &claircore.Package{Name: "Flask_SQLAlchemy", Kind: claircore.BINARY}, Repo: rhel},
+		{Name: "OTHER-LOWER", Package: &claircore.Package{Name: "flask_sqlalchemy", Kind: claircore.BINARY}, Repo: rhel},
+		{Name: "PYPI-LEGACY", Package: &claircore.Package{Name: "Flask_SQLAlchemy", Kind: claircore.BINARY}, Repo: pypi},
+		{Name: "NPM-SCOPED", Package: &claircore.Package{Name: "@Babel/Core", Kind: claircore.BINARY}, Repo: &claircore.Repository{Name: "npm"}},
+	}
+	for _, v := range vs {
+		v.Updater = "test-normalized"
+	}
+	if _, err := store.UpdateVulnerabilities(ctx, "test-normalized", "", vs); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a row written before the normalized name was recorded.
+	if _, err := pool.Exec(ctx, `UPDATE vuln SET normalized_package_name = NULL WHERE name = 'PYPI-LEGACY';`); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name   string
+		record *claircore.IndexRecord
+		exact  []string
+		want   []string
+	}{
+		{
+			name: "PyPIExact",
+			record: &claircore.IndexRecord{
+				Package:    &claircore.Package{ID: "0", Name: "Flask_SQLAlchemy", Kind: claircore.BINARY, Source: &claircore.Package{}},
+				Repository: pypi,
+			},
+			exact: []string{"OTHER-EXACT", "PYPI-EXACT", "PYPI-LEGACY"},
+			want:  []string{"OTHER-EXACT", "PYPI-DOTTED", "PYPI-EXACT", "PYPI-LEGACY", "PYPI-NORMAL"},
+		},
+		{
+			name: "PyPINormal",
+			record: &claircore.IndexRecord{
+				Package:    &claircore.Package{ID: "0", Name: "flask-sqlalchemy", Kind: claircore.BINARY, Source: &claircore.Package{}},
+				Repository: pypi,
+			},
+			exact: []string{"PYPI-NORMAL"},
+			want:  []string{"PYPI-DOTTED", "PYPI-EXACT", "PYPI-NORMAL"},
+		},
+		{
+			name: "NoEcosystem",
+			record: &claircore.IndexRecord{
+				Package: &claircore.Package{ID: "0", Name: "flask_sqlalchemy", Kind: claircore.BINARY, Source: &claircore.Package{}},
+			},
+			exact: []string{"OTHER-LOWER"},
+			want:  []string{"OTHER-LOWER"},
+		},
+		{
+			// An ecosystem without normalization rules matches on the exact,
+			// case-sensitive name only.
+			name: "OtherMixedCase",
+			record: &claircore.IndexRecord{
+				Package:    &claircore.Package{ID: "0", Name: "Flask_SQLAlchemy", Kind: claircore.BINARY, Source: &claircore.Package{}},
+				Repository: rhel,
+			},
+			exact: []string{"OTHER-EXACT", "PYPI-EXACT", "PYPI-LEGACY"},
+			want:  []string{"OTHER-EXACT", "PYPI-EXACT", "PYPI-LEGACY"},
+		},
+		{
+			name: "NPM",
+			record: &claircore.IndexRecord{
+				Package:    &claircore.Package{ID: "0", Name: "@babel/core", Kind: claircore.BINARY, Source: &claircore.Package{}},
+				Repository: &claircore.Repository{Name: "npm"},
+			},
+			exact: []string{},
+			want:  []string{"NPM-SCOPED"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			res, err := store.Get(ctx, []*claircore.IndexRecord{tc.record}, datastore.GetOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(res["0"]))
+			for _, v := range res["0"] {
+				got = append(got, v.Name)
+			}
+			sort.Strings(got)
+			if !cmp.Equal(got, tc.want) {
+				t.Error(cmp.Diff(got, tc.want))
+			}
+			seen := make(map[string]bool, len(got))
+			for _, n := range got {
+				seen[n] = true
+			}
+			for _, n := range tc.exact {
+				if !seen[n] {
+					t.Errorf("lost exact-name match %q", n)
+				}
+			}
+		})
+	}
+}
@@ -203,9 +203,9 @@ func getLatestRefs(ctx context.Context, pool *pgxpool.Pool) (map[string][]driver
 
 func (s *MatcherStore) GetUpdateOperations(ctx context.Context, kind driver.UpdateKind, updater ...string) (map[string][]driver.UpdateOperation, error) {
 	const (
-		query              = `SELECT ref, updater, fingerprint, date FROM update_operation WHERE updater = ANY($1) ORDER BY id DESC;`
-		queryVulnerability = `SELECT ref, updater, fingerprint, date FROM update_operation WHERE updater = ANY($1) AND kind = 'vulnerability' ORDER BY id DESC;`
-		queryEnrichment    = `SELECT ref, updater, fingerprint, date FROM update_operation WHERE updater = ANY($1) AND kind = 'enrichment' ORDER BY id DESC;`
+		query              = `SELECT ref, updater, fingerprint, date, provenance FROM update_operation WHERE updater = ANY($1) ORDER BY id DESC;`
+		queryVulnerability = `SELECT ref, updater, fingerprint, date, provenance FROM update_operation WHERE updater = ANY($1) AND kind = 'vulnerability' ORDER BY id DESC;`
+		queryEnrichment    = `SELECT ref, updater, fingerprint, date, provenance FROM update_operation WHERE updater = ANY($1) AND kind = 'enrichment' ORDER BY id DESC;`
 		getUpdaters        = `SELECT DISTINCT(updater) FROM update_operation;`
 	)
 	ctx = zlog.ContextWithValues(ctx, "component", "internal/vulnstore/postgres/getUpdateOperations")
@@ -284,6 +284,7 @@ func (s *MatcherStore) GetUpdateOperations(ctx context.Context, kind driver.Upda
 			&uo.Updater,
 			&uo.Fingerprint,
 			&uo.Date,
+			&uo.Provenance,
 		)
 		if err != nil {
 			rows.Close()
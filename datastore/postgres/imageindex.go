@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+var _ indexer.ImageIndexStore = (*IndexerStore)(nil)
+
+var (
+	imageIndexCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore",
+			Subsystem: "indexer",
+			Name:      "imageindex_total",
+			Help:      "Total number of database queries issued in the PersistImageIndex and ImageIndexes methods.",
+		},
+		[]string{"query", "success"},
+	)
+	imageIndexDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "claircore",
+			Subsystem: "indexer",
+			Name:      "imageindex_duration_seconds",
+			Help:      "The duration of all queries issued in the PersistImageIndex and ImageIndexes methods.",
+		},
+		[]string{"query", "success"},
+	)
+)
+
+// PersistImageIndex implements indexer.ImageIndexStore.
+//
+// The manifests recorded for the image index are replaced, so persisting an
+// image index again with different contents updates it.
+func (s *IndexerStore) PersistImageIndex(ctx context.Context, idx *claircore.ImageIndex) (err error) {
+	const (
+		insertIndex = `
+INSERT INTO image_index (hash) VALUES ($1)
+ON CONFLICT (hash) DO UPDATE SET hash = excluded.hash
+RETURNING id;`
+		deleteManifests = `DELETE FROM image_index_manifest WHERE index_id = $1;`
+		insertManifest  = `
+INSERT INTO image_index_manifest (index_id, manifest_id, platform)
+SELECT $1, id, $3 FROM manifest WHERE hash = $2;`
+	)
+	defer promTimer(imageIndexDuration, "persistImageIndex", &err)()
+	defer func() {
+		imageIndexCounter.WithLabelValues("persistImageIndex", success(err)).Inc()
+	}()
+
+	err = s.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var id int64
+		if err := tx.QueryRow(ctx, insertIndex, idx.Hash).Scan(&id); err != nil {
+			return fmt.Errorf("failed to insert image index: %w", err)
+		}
+		if _, err := tx.Exec(ctx, deleteManifests, id); err != nil {
+			return fmt.Errorf("failed to remove image index manifests: %w", err)
+		}
+		for _, pm := range idx.Manifests {
+			tag, err := tx.Exec(ctx, insertManifest, id, pm.Manifest.Hash, pm.Platform.String())
+			if err != nil {
+				return fmt.Errorf("failed to insert image index -> manifest link: %w", err)
+			}
+			if tag.RowsAffected() == 0 {
+				return fmt.Errorf("manifest %v not persisted", pm.Manifest.Hash)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// ImageIndexes implements indexer.ImageIndexStore.
+func (s *IndexerStore) ImageIndexes(ctx context.Context, manifests []claircore.Digest) (_ map[string][]claircore.Digest, err error) {
+	const query = `
+SELECT
+	manifest.hash, image_index.hash
+FROM
+	image_index_manifest
+	JOIN manifest ON manifest.id = image_index_manifest.manifest_id
+	JOIN image_index ON image_index.id = image_index_manifest.index_id
+WHERE
+	manifest.hash = ANY($1::TEXT[]);`
+	defer promTimer(imageIndexDuration, "imageIndexes", &err)()
+	defer func() {
+		imageIndexCounter.WithLabelValues("imageIndexes", success(err)).Inc()
+	}()
+
+	rows, err := s.pool.Query(ctx, query, digestSlice(manifests))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string][]claircore.Digest)
+	for rows.Next() {
+		var m, idx claircore.Digest
+		if err = rows.Scan(&m, &idx); err != nil {
+			return nil, err
+		}
+		out[m.String()] = append(out[m.String()], idx)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/test"
+	"github.com/quay/claircore/test/integration"
+	pgtest "github.com/quay/claircore/test/postgres"
+)
+
+func TestImageIndex(t *testing.T) {
+	integration.NeedDB(t)
+	ctx := zlog.Test(context.Background(), t)
+	pool := pgtest.TestIndexerDB(ctx, t)
+	store := NewIndexerStore(pool)
+	defer store.Close(ctx)
+
+	amd64 := &claircore.Manifest{Hash: test.RandomSHA256Digest(t)}
+	arm64 := &claircore.Manifest{Hash: test.RandomSHA256Digest(t)}
+	other := &claircore.Manifest{Hash: test.RandomSHA256Digest(t)}
+	for _, m := range []*claircore.Manifest{amd64, arm64, other} {
+		if err := store.PersistManifest(ctx, *m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx := &claircore.ImageIndex{
+		Hash: test.RandomSHA256Digest(t),
+		Manifests: []claircore.PlatformManifest{
+			{Platform: claircore.Platform{OS: "linux", Architecture: "amd64"}, Manifest: amd64},
+			{Platform: claircore.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, Manifest: arm64},
+		},
+	}
+	if err := store.PersistImageIndex(ctx, idx); err != nil {
+		t.Fatal(err)
+	}
+	// Persisting again is not an error.
+	if err := store.PersistImageIndex(ctx, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.ImageIndexes(ctx, []claircore.Digest{amd64.Hash, arm64.Hash, other.Hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]claircore.Digest{
+		amd64.Hash.String(): {idx.Hash},
+		arm64.Hash.String(): {idx.Hash},
+	}
+	if !cmp.Equal(got, want, cmpOpts) {
+		t.Error(cmp.Diff(got, want, cmpOpts))
+	}
+
+	t.Run("Unpersisted", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		bad := &claircore.ImageIndex{
+			Hash: test.RandomSHA256Digest(t),
+			Manifests: []claircore.PlatformManifest{
+				{Platform: claircore.Platform{OS: "linux", Architecture: "amd64"}, Manifest: &claircore.Manifest{Hash: test.RandomSHA256Digest(t)}},
+			},
+		}
+		if err := store.PersistImageIndex(ctx, bad); err == nil {
+			t.Error("expected error for unknown manifest")
+		}
+	})
+
+	t.Run("DeleteManifest", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		if _, err := store.DeleteManifests(ctx, arm64.Hash); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.ImageIndexes(ctx, []claircore.Digest{amd64.Hash, arm64.Hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]claircore.Digest{
+			amd64.Hash.String(): {idx.Hash},
+		}
+		if !cmp.Equal(got, want, cmpOpts) {
+			t.Error(cmp.Diff(got, want, cmpOpts))
+		}
+	})
+}
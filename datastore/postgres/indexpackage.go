@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -49,9 +50,11 @@ var (
 func (s *IndexerStore) IndexPackages(ctx context.Context, pkgs []*claircore.Package, layer *claircore.Layer, scnr indexer.VersionedScanner) error {
 	const (
 		insert = ` 
-		INSERT INTO package (name, kind, version, norm_kind, norm_version, module, arch)
-		VALUES ($1, $2, $3, $4, $5::int[], $6, $7)
-		ON CONFLICT (name, kind, version, module, arch) DO NOTHING;
+		INSERT INTO package (name, kind, version, norm_kind, norm_version, module, arch, kernel_module)
+		VALUES ($1, $2, $3, $4, $5::int[], $6, $7, $8::JSONB)
+		ON CONFLICT (name, kind, version, module, arch) DO UPDATE
+		SET kernel_module = excluded.kernel_module
+		WHERE package.kernel_module IS NULL AND excluded.kernel_module IS NOT NULL;
 		`
 
 		insertWith = `
@@ -212,8 +215,17 @@ func queueInsert(ctx context.Context, b *microbatch.Insert, stmt string, pkg *cl
 		vKind = &pkg.NormalizedVersion.Kind
 		vNorm = pkg.NormalizedVersion.V[:]
 	}
+	var km *string
+	if pkg.KernelModule != nil {
+		b, err := json.Marshal(pkg.KernelModule)
+		if err != nil {
+			return fmt.Errorf("failed to marshal kernel module for package %q: %w", pkg.Name, err)
+		}
+		s := string(b)
+		km = &s
+	}
 	err := b.Queue(ctx, stmt,
-		pkg.Name, pkg.Kind, pkg.Version, vKind, vNorm, pkg.Module, pkg.Arch,
+		pkg.Name, pkg.Kind, pkg.Version, vKind, vNorm, pkg.Module, pkg.Arch, km,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to queue insert for package %q: %w", pkg.Name, err)
@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/test"
+	"github.com/quay/claircore/test/integration"
+	pgtest "github.com/quay/claircore/test/postgres"
+)
+
+// TestKernelModule checks that the split recorded for kernel module packages
+// survives the round trip through the package table, including for packages
+// stored before the split was recorded.
+func TestKernelModule(t *testing.T) {
+	integration.NeedDB(t)
+	ctx := zlog.Test(context.Background(), t)
+	pool := pgtest.TestIndexerDB(ctx, t)
+	store := NewIndexerStore(pool)
+	defer store.Close(ctx)
+
+	old := indexer.NewPackageScannerMock("rpm", "5", "package")
+	cur := indexer.NewPackageScannerMock("rpm", "6", "package")
+	if err := store.RegisterScanners(ctx, indexer.VersionedScanners{old, cur}); err != nil {
+		t.Fatal(err)
+	}
+	l := &claircore.Layer{Hash: test.RandomSHA256Digest(t)}
+	if err := store.PersistManifest(ctx, claircore.Manifest{
+		Hash:   test.RandomSHA256Digest(t),
+		Layers: []*claircore.Layer{l},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := &claircore.KernelModule{
+		Name:    "kmod-ice",
+		Version: "1.9.11-1",
+		Kernel:  "5.14.0-70.13.1",
+	}
+	pkg := func(km *claircore.KernelModule) *claircore.Package {
+		return &claircore.Package{
+			Name:         "kmod-ice",
+			Version:      "1.9.11_5.14.0_70.13.1-1",
+			Kind:         claircore.BINARY,
+			KernelModule: km,
+		}
+	}
+
+	// Stored by a scanner that didn't record the split, then again by one that
+	// did.
+	if err := store.IndexPackages(ctx, []*claircore.Package{pkg(nil)}, l, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.IndexPackages(ctx, []*claircore.Package{pkg(want)}, l, cur); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []indexer.VersionedScanner{old, cur} {
+		got, err := store.PackagesByLayer(ctx, l.Hash, indexer.VersionedScanners{s})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("got %d packages, want 1", len(got))
+		}
+		if !cmp.Equal(got[0].KernelModule, want) {
+			t.Error(cmp.Diff(got[0].KernelModule, want))
+		}
+	}
+}
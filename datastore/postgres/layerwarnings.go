@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+var _ indexer.WarningStore = (*IndexerStore)(nil)
+
+var (
+	layerWarningsCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore",
+			Subsystem: "indexer",
+			Name:      "layerwarnings_total",
+			Help:      "Total number of database queries issued in the SetLayerWarnings and LayerWarnings methods.",
+		},
+		[]string{"query", "success"},
+	)
+	layerWarningsDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "claircore",
+			Subsystem: "indexer",
+			Name:      "layerwarnings_duration_seconds",
+			Help:      "The duration of all queries issued in the SetLayerWarnings and LayerWarnings methods.",
+		},
+		[]string{"query", "success"},
+	)
+)
+
+// SetLayerWarnings implements indexer.WarningStore.
+func (s *IndexerStore) SetLayerWarnings(ctx context.Context, layer claircore.Digest, scnr indexer.VersionedScanner, ws []claircore.IndexWarning) (err error) {
+	const query = `
+INSERT INTO layer_warning (layer_id, scanner_id, warnings)
+SELECT layer.id, scanner.id, $5::JSONB
+FROM layer, scanner
+WHERE
+	layer.hash = $1
+	AND scanner.name = $2 AND scanner.version = $3 AND scanner.kind = $4
+ON CONFLICT (layer_id, scanner_id) DO UPDATE SET warnings = excluded.warnings;`
+	defer promTimer(layerWarningsDuration, "setLayerWarnings", &err)()
+	defer func() {
+		layerWarningsCounter.WithLabelValues("setLayerWarnings", success(err)).Inc()
+	}()
+
+	if ws == nil {
+		ws = []claircore.IndexWarning{}
+	}
+	b, err := json.Marshal(ws)
+	if err != nil {
+		return err
+	}
+	tag, err := s.pool.Exec(ctx, query, layer, scnr.Name(), scnr.Version(), scnr.Kind(), string(b))
+	if err != nil {
+		return fmt.Errorf("failed to insert layer warnings: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("layer %v or scanner %q not persisted", layer, scnr.Name())
+	}
+	return nil
+}
+
+// LayerWarnings implements indexer.WarningStore.
+func (s *IndexerStore) LayerWarnings(ctx context.Context, layers []claircore.Digest, scnrs indexer.VersionedScanners) (_ []claircore.IndexWarning, err error) {
+	const query = `
+SELECT
+	layer_warning.warnings
+FROM
+	layer_warning
+	JOIN layer ON layer.id = layer_warning.layer_id
+	JOIN scanner ON scanner.id = layer_warning.scanner_id
+	JOIN UNNEST($2::TEXT[], $3::TEXT[], $4::TEXT[]) AS want (name, version, kind) ON
+		scanner.name = want.name
+		AND scanner.version = want.version
+		AND scanner.kind = want.kind
+WHERE
+	layer.hash = ANY($1::TEXT[])
+ORDER BY
+	layer.hash, scanner.name;`
+	defer promTimer(layerWarningsDuration, "layerWarnings", &err)()
+	defer func() {
+		layerWarningsCounter.WithLabelValues("layerWarnings", success(err)).Inc()
+	}()
+
+	if len(layers) == 0 || len(scnrs) == 0 {
+		return nil, nil
+	}
+	names := make([]string, len(scnrs))
+	versions := make([]string, len(scnrs))
+	kinds := make([]string, len(scnrs))
+	for i, v := range scnrs {
+		names[i], versions[i], kinds[i] = v.Name(), v.Version(), v.Kind()
+	}
+	rows, err := s.pool.Query(ctx, query, digestSlice(layers), names, versions, kinds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []claircore.IndexWarning
+	for rows.Next() {
+		var b []byte
+		if err = rows.Scan(&b); err != nil {
+			return nil, err
+		}
+		var ws []claircore.IndexWarning
+		if err = json.Unmarshal(b, &ws); err != nil {
+			return nil, err
+		}
+		out = append(out, ws...)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/test"
+	"github.com/quay/claircore/test/integration"
+	pgtest "github.com/quay/claircore/test/postgres"
+)
+
+func TestLayerWarnings(t *testing.T) {
+	integration.NeedDB(t)
+	ctx := zlog.Test(context.Background(), t)
+	pool := pgtest.TestIndexerDB(ctx, t)
+	store := NewIndexerStore(pool)
+	defer store.Close(ctx)
+
+	l := &claircore.Layer{Hash: test.RandomSHA256Digest(t)}
+	m := claircore.Manifest{
+		Hash:   test.RandomSHA256Digest(t),
+		Layers: []*claircore.Layer{l},
+	}
+	if err := store.PersistManifest(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	a := indexer.NewPackageScannerMock("a", "1", "package")
+	b := indexer.NewPackageScannerMock("b", "1", "package")
+	if err := store.RegisterScanners(ctx, indexer.VersionedScanners{a, b}); err != nil {
+		t.Fatal(err)
+	}
+	ws := []claircore.IndexWarning{
+		{Scanner: "a", Layer: l.Hash, Path: "var/lib/dpkg/status", Message: "bad stanza"},
+	}
+	if err := store.SetLayerWarnings(ctx, l.Hash, a, ws); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetLayerWarnings(ctx, l.Hash, b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.LayerWarnings(ctx, []claircore.Digest{l.Hash}, indexer.VersionedScanners{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, ws, cmpOpts) {
+		t.Error(cmp.Diff(got, ws, cmpOpts))
+	}
+
+	t.Run("OtherScanner", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		got, err := store.LayerWarnings(ctx, []claircore.Digest{l.Hash}, indexer.VersionedScanners{b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Errorf("unexpected warnings: %v", got)
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		if err := store.SetLayerWarnings(ctx, l.Hash, a, nil); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.LayerWarnings(ctx, []claircore.Digest{l.Hash}, indexer.VersionedScanners{a})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Errorf("unexpected warnings: %v", got)
+		}
+	})
+
+	t.Run("Unpersisted", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		if err := store.SetLayerWarnings(ctx, test.RandomSHA256Digest(t), a, ws); err == nil {
+			t.Error("expected error for unknown layer")
+		}
+	})
+}
@@ -50,8 +50,9 @@ func NewMatcherStore(pool *pgxpool.Pool) *MatcherStore {
 }
 
 var (
-	_ datastore.Updater       = (*MatcherStore)(nil)
-	_ datastore.Vulnerability = (*MatcherStore)(nil)
+	_ datastore.Updater            = (*MatcherStore)(nil)
+	_ datastore.Vulnerability      = (*MatcherStore)(nil)
+	_ datastore.ProvenanceRecorder = (*MatcherStore)(nil)
 )
 
 // UpdateVulnerabilities implements vulnstore.Updater.
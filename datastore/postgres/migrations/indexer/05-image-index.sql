@@ -0,0 +1,17 @@
+-- ImageIndex
+-- an identity table consisting of a content addressable image index hash
+CREATE TABLE IF NOT EXISTS image_index (
+	id BIGSERIAL PRIMARY KEY,
+	hash TEXT NOT NULL UNIQUE
+);
+
+-- ImageIndexManifest
+-- a many to many link table identifying the manifests which comprise an image
+-- index, and the platform each is for
+CREATE TABLE IF NOT EXISTS image_index_manifest (
+	index_id BIGINT NOT NULL REFERENCES image_index(id) ON DELETE CASCADE,
+	manifest_id BIGINT NOT NULL REFERENCES manifest(id) ON DELETE CASCADE,
+	platform TEXT NOT NULL,
+	PRIMARY KEY (index_id, platform)
+);
+CREATE INDEX IF NOT EXISTS image_index_manifest_manifest_id_idx ON image_index_manifest (manifest_id);
@@ -0,0 +1,9 @@
+-- Indexes for finding the packages, distributions, and repositories no longer
+-- referenced by any layer or manifest, so they can be garbage collected when
+-- manifests are deleted.
+CREATE INDEX IF NOT EXISTS package_scanartifact_package_id_idx ON package_scanartifact (package_id);
+CREATE INDEX IF NOT EXISTS package_scanartifact_source_id_idx ON package_scanartifact (source_id);
+CREATE INDEX IF NOT EXISTS dist_scanartifact_dist_id_idx ON dist_scanartifact (dist_id);
+CREATE INDEX IF NOT EXISTS repo_scanartifact_repo_id_idx ON repo_scanartifact (repo_id);
+CREATE INDEX IF NOT EXISTS manifest_index_dist_id_idx ON manifest_index (dist_id);
+CREATE INDEX IF NOT EXISTS manifest_index_repo_id_idx ON manifest_index (repo_id);
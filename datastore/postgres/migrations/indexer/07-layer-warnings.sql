@@ -0,0 +1,9 @@
+-- LayerWarning
+-- the warnings a scanner reported while scanning a layer, so they can be
+-- reported for every manifest containing the layer
+CREATE TABLE IF NOT EXISTS layer_warning (
+	layer_id BIGINT NOT NULL REFERENCES layer(id) ON DELETE CASCADE,
+	scanner_id BIGINT NOT NULL REFERENCES scanner(id) ON DELETE CASCADE,
+	warnings JSONB NOT NULL,
+	PRIMARY KEY (layer_id, scanner_id)
+);
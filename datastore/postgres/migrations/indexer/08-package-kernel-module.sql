@@ -0,0 +1,3 @@
+-- the split of a kernel module package into the driver and the kernel it's
+-- built for, recorded by the package scanner
+ALTER TABLE package ADD COLUMN IF NOT EXISTS kernel_module JSONB;
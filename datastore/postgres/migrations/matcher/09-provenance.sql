@@ -0,0 +1,4 @@
+-- Provenance records the documents an update operation was built from. It's
+-- nullable, as operations from before this migration and from updaters that
+-- don't fetch anything have none.
+ALTER TABLE update_operation ADD COLUMN IF NOT EXISTS provenance JSONB;
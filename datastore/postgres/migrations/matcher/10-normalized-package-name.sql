@@ -0,0 +1,19 @@
+-- Normalized_package_name holds the package name normalized according to the
+-- rules of its ecosystem, as identified by the repository name. See the
+-- pkgname package for the rules; the backfill below must agree with them.
+ALTER TABLE vuln ADD COLUMN IF NOT EXISTS normalized_package_name TEXT;
+UPDATE vuln SET normalized_package_name = CASE repo_name
+	WHEN 'pypi' THEN
+		lower(regexp_replace(btrim(package_name, E' \t\n\r\f\v'), '[-_.]+', '-', 'g'))
+	WHEN 'npm' THEN
+		lower(btrim(package_name, E' \t\n\r\f\v'))
+	WHEN 'maven' THEN
+		CASE WHEN strpos(btrim(package_name, E' \t\n\r\f\v'), ':') = 0 THEN
+			regexp_replace(btrim(package_name, E' \t\n\r\f\v'), '/([^/]*)$', ':\1')
+		ELSE
+			btrim(package_name, E' \t\n\r\f\v')
+		END
+	ELSE package_name
+	END
+WHERE normalized_package_name IS NULL;
+CREATE INDEX IF NOT EXISTS vuln_normalized_package_name_idx ON vuln (normalized_package_name, package_kind);
@@ -45,6 +45,22 @@ var IndexerMigrations = []migrate.Migration{
 		ID: 4,
 		Up: runFile("indexer/04-foreign-key-cascades.sql"),
 	},
+	{
+		ID: 5,
+		Up: runFile("indexer/05-image-index.sql"),
+	},
+	{
+		ID: 6,
+		Up: runFile("indexer/06-artifact-gc-indexes.sql"),
+	},
+	{
+		ID: 7,
+		Up: runFile("indexer/07-layer-warnings.sql"),
+	},
+	{
+		ID: 8,
+		Up: runFile("indexer/08-package-kernel-module.sql"),
+	},
 }
 
 var MatcherMigrations = []migrate.Migration{
@@ -80,4 +96,12 @@ var MatcherMigrations = []migrate.Migration{
 		ID: 8,
 		Up: runFile("matcher/08-updater-status.sql"),
 	},
+	{
+		ID: 9,
+		Up: runFile("matcher/09-provenance.sql"),
+	},
+	{
+		ID: 10,
+		Up: runFile("matcher/10-normalized-package-name.sql"),
+	},
 }
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -58,6 +59,7 @@ SELECT
 	package.norm_version,
 	package.module,
 	package.arch,
+	package.kernel_module,
 	source_package.id,
 	source_package.name,
 	source_package.kind,
@@ -121,6 +123,7 @@ WHERE
 		var id, srcID int64
 		var nKind *string
 		var nVer pgtype.Int4Array
+		var km []byte
 		err := rows.Scan(
 			&id,
 			&pkg.Name,
@@ -130,6 +133,7 @@ WHERE
 			&nVer,
 			&pkg.Module,
 			&pkg.Arch,
+			&km,
 
 			&srcID,
 			&spkg.Name,
@@ -152,6 +156,12 @@ WHERE
 				pkg.NormalizedVersion.V[i] = n.Int
 			}
 		}
+		if km != nil {
+			pkg.KernelModule = new(claircore.KernelModule)
+			if err := json.Unmarshal(km, pkg.KernelModule); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal kernel module: %w", err)
+			}
+		}
 		// nest source package
 		pkg.Source = &spkg
 
@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+var _ indexer.LayerPersister = (*IndexerStore)(nil)
+
+var (
+	persistLayersCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore",
+			Subsystem: "indexer",
+			Name:      "persistlayers_total",
+			Help:      "Total number of database queries issued in the PersistLayers method.",
+		},
+		[]string{"query"},
+	)
+
+	persistLayersDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "claircore",
+			Subsystem: "indexer",
+			Name:      "persistlayers_duration_seconds",
+			Help:      "The duration of all queries issued in the PersistLayers method",
+		},
+		[]string{"query"},
+	)
+)
+
+// PersistLayers implements indexer.LayerPersister.
+func (s *IndexerStore) PersistLayers(ctx context.Context, layers []claircore.Digest) error {
+	const insertLayer = `
+		INSERT INTO layer (hash)
+		VALUES ($1)
+		ON CONFLICT DO NOTHING;
+		`
+
+	tctx, done := context.WithTimeout(ctx, 5*time.Second)
+	tx, err := s.pool.Begin(tctx)
+	done()
+	if err != nil {
+		return fmt.Errorf("failed to create transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, d := range layers {
+		tctx, done = context.WithTimeout(ctx, 5*time.Second)
+		start := time.Now()
+		_, err = tx.Exec(tctx, insertLayer, d)
+		done()
+		if err != nil {
+			return fmt.Errorf("failed to insert layer: %w", err)
+		}
+		persistLayersCounter.WithLabelValues("insertLayer").Add(1)
+		persistLayersDuration.WithLabelValues("insertLayer").Observe(time.Since(start).Seconds())
+	}
+
+	tctx, done = context.WithTimeout(ctx, 15*time.Second)
+	err = tx.Commit(tctx)
+	done()
+	if err != nil {
+		return fmt.Errorf("failed to commit tx: %w", err)
+	}
+	return nil
+}
@@ -11,8 +11,14 @@ import (
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/datastore"
 	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/pkg/kmod"
+	"github.com/quay/claircore/pkg/pkgname"
 )
 
+// UpdateRefColumn selects the latest update operation a vulnerability belongs
+// to, as vulnerabilities are shared between operations.
+const updateRefColumn = `COALESCE((SELECT uo.ref::text FROM uo_vuln JOIN update_operation uo ON uo.id = uo_vuln.uo WHERE uo_vuln.vuln = vuln.id ORDER BY uo.id DESC LIMIT 1), '')`
+
 // getQueryBuilder validates a IndexRecord and creates a query string for vulnerability matching
 func buildGetQuery(record *claircore.IndexRecord, opts *datastore.GetOpts) (string, error) {
 	matchers := opts.Matchers
@@ -23,8 +29,12 @@ func buildGetQuery(record *claircore.IndexRecord, opts *datastore.GetOpts) (stri
 	if record.Package.Name == "" {
 		return "", fmt.Errorf("IndexRecord must provide a Package.Name")
 	}
+	var ecosystem string
+	if record.Repository != nil {
+		ecosystem = record.Repository.Name
+	}
 	packageQuery := goqu.And(
-		goqu.Ex{"package_name": record.Package.Name},
+		packageName(ecosystem, record.Package),
 		goqu.Ex{"package_kind": record.Package.Kind},
 	)
 	exps = append(exps, packageQuery)
@@ -32,7 +42,7 @@ func buildGetQuery(record *claircore.IndexRecord, opts *datastore.GetOpts) (stri
 	// If the package has a source, convert the first expression to an OR.
 	if record.Package.Source.Name != "" {
 		sourcePackageQuery := goqu.And(
-			goqu.Ex{"package_name": record.Package.Source.Name},
+			packageName(ecosystem, record.Package.Source),
 			goqu.Ex{"package_kind": record.Package.Source.Kind},
 		)
 		or := goqu.Or(
@@ -94,7 +104,7 @@ func buildGetQuery(record *claircore.IndexRecord, opts *datastore.GetOpts) (stri
 		))
 	}
 
-	query := psql.Select(
+	cols := []interface{}{
 		"id",
 		"name",
 		"description",
@@ -121,7 +131,11 @@ func buildGetQuery(record *claircore.IndexRecord, opts *datastore.GetOpts) (stri
 		"repo_uri",
 		"fixed_in_version",
 		"updater",
-	).From("vuln").Where(exps...)
+	}
+	if opts.UpdateRefs {
+		cols = append(cols, goqu.L(updateRefColumn))
+	}
+	query := psql.Select(cols...).From("vuln").Where(exps...)
 
 	sql, _, err := query.ToSQL()
 	if err != nil {
@@ -129,3 +143,29 @@ func buildGetQuery(record *claircore.IndexRecord, opts *datastore.GetOpts) (stri
 	}
 	return sql, nil
 }
+
+// PackageName returns the expression matching a package's name.
+//
+// For ecosystems with name normalization rules, rows whose normalized name
+// matches are returned along with exact matches, so the normalized column
+// only ever adds results. Rows from other ecosystems have their exact name in
+// the normalized column, so they're only matched by exact name. Rows written
+// before the normalized column existed have it unset, and are also only
+// matched by exact name.
+//
+// Kernel module packages that carry a kernel release in their name also
+// match advisories for the driver's base name.
+func packageName(ecosystem string, p *claircore.Package) goqu.Expression {
+	name := p.Name
+	exact := goqu.Ex{"package_name": name}
+	if base, ok := kmod.BaseName(p); ok {
+		exact = goqu.Ex{"package_name": []string{name, base}}
+	}
+	if !pkgname.Supported(ecosystem) {
+		return exact
+	}
+	return goqu.Or(
+		exact,
+		goqu.Ex{"normalized_package_name": pkgname.Normalize(ecosystem, name)},
+	)
+}
@@ -221,3 +221,68 @@ func TestGetQueryBuilderDeterministicArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestGetQueryBuilderNormalizedName(t *testing.T) {
+	const preamble = `SELECT
+		"id", "name", "description", "issued", "links", "severity", "normalized_severity", "package_name", "package_version",
+		"package_module", "package_arch", "package_kind", "dist_id", "dist_name", "dist_version", "dist_version_code_name",
+		"dist_version_id", "dist_arch", "dist_cpe", "dist_pretty_name", "arch_operation", "repo_name", "repo_key",
+		"repo_uri", "fixed_in_version", "updater"
+		FROM "vuln"
+		WHERE `
+	normalizeWhitespace := cmpopts.AcyclicTransformer("normalizeWhitespace", strings.Fields)
+	table := []struct {
+		name   string
+		record *claircore.IndexRecord
+		want   string
+	}{
+		{
+			name: "PyPI",
+			record: &claircore.IndexRecord{
+				Package:    &claircore.Package{Name: "Flask_SQLAlchemy", Kind: "binary", Source: &claircore.Package{}},
+				Repository: &claircore.Repository{Name: "pypi"},
+			},
+			want: preamble + `((("package_name" = 'Flask_SQLAlchemy') OR ("normalized_package_name" = 'flask-sqlalchemy')) AND ("package_kind" = 'binary'))`,
+		},
+		{
+			name: "NPM",
+			record: &claircore.IndexRecord{
+				Package:    &claircore.Package{Name: "@Babel/Core", Kind: "binary", Source: &claircore.Package{}},
+				Repository: &claircore.Repository{Name: "npm"},
+			},
+			want: preamble + `((("package_name" = '@Babel/Core') OR ("normalized_package_name" = '@babel/core')) AND ("package_kind" = 'binary'))`,
+		},
+		{
+			name: "Unknown",
+			record: &claircore.IndexRecord{
+				Package:    &claircore.Package{Name: "Flask_SQLAlchemy", Kind: "binary", Source: &claircore.Package{}},
+				Repository: &claircore.Repository{Name: "rhel"},
+			},
+			want: preamble + `(("package_name" = 'Flask_SQLAlchemy') AND ("package_kind" = 'binary'))`,
+		},
+		{
+			name: "KernelModule",
+			record: &claircore.IndexRecord{
+				Package: &claircore.Package{
+					Name:         "kmod-nvidia-535.104.05-5.14.0-284.30.1",
+					Kind:         "binary",
+					Source:       &claircore.Package{},
+					KernelModule: &claircore.KernelModule{Name: "kmod-nvidia", Kernel: "5.14.0-284.30.1"},
+				},
+				Repository: &claircore.Repository{Name: "rhel"},
+			},
+			want: preamble + `(("package_name" IN ('kmod-nvidia-535.104.05-5.14.0-284.30.1', 'kmod-nvidia')) AND ("package_kind" = 'binary'))`,
+		},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := buildGetQuery(tc.record, &datastore.GetOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got, tc.want, normalizeWhitespace) {
+				t.Error(cmp.Diff(tc.want, got, normalizeWhitespace))
+			}
+		})
+	}
+}
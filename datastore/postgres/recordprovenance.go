@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore/libvuln/driver"
+)
+
+var (
+	recordProvenanceCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore",
+			Subsystem: "vulnstore",
+			Name:      "recordprovenance_total",
+			Help:      "Total number of database queries issued in the RecordProvenance method.",
+		},
+		[]string{"query"},
+	)
+	recordProvenanceDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "claircore",
+			Subsystem: "vulnstore",
+			Name:      "recordprovenance_duration_seconds",
+			Help:      "The duration of all queries issued in the RecordProvenance method",
+		},
+		[]string{"query"},
+	)
+)
+
+// RecordProvenance implements datastore.ProvenanceRecorder.
+func (s *MatcherStore) RecordProvenance(ctx context.Context, ref uuid.UUID, p *driver.Provenance) error {
+	const query = `UPDATE update_operation SET provenance = $2 WHERE ref = $1;`
+	ctx = zlog.ContextWithValues(ctx, "component", "internal/vulnstore/postgres/RecordProvenance")
+
+	start := time.Now()
+	tag, err := s.pool.Exec(ctx, query, ref, p)
+	if err != nil {
+		return fmt.Errorf("failed to record provenance: %w", err)
+	}
+	recordProvenanceCounter.WithLabelValues("query").Add(1)
+	recordProvenanceDuration.WithLabelValues("query").Observe(time.Since(start).Seconds())
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to record provenance: no update operation %v", ref)
+	}
+	zlog.Debug(ctx).
+		Stringer("ref", ref).
+		Int("sources", len(p.Sources)).
+		Msg("recorded provenance")
+	return nil
+}
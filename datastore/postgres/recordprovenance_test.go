@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/datastore"
+	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/test/integration"
+	pgtest "github.com/quay/claircore/test/postgres"
+)
+
+func TestRecordProvenance(t *testing.T) {
+	integration.NeedDB(t)
+	ctx := zlog.Test(context.Background(), t)
+	pool := pgtest.TestMatcherDB(ctx, t)
+	store := NewMatcherStore(pool)
+
+	const name = "test-provenance"
+	vs := []*claircore.Vulnerability{{
+		Updater: name,
+		Name:    "CVE-0000-0000",
+		Package: &claircore.Package{Name: "vi"},
+	}}
+	ref, err := store.UpdateVulnerabilities(ctx, name, "fp", vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &driver.Provenance{
+		UpdaterVersion: "v0.0.1",
+		Sources: []driver.Source{{
+			URL:     "https://example.com/feed.json",
+			SHA256:  "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			Fetched: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		}},
+	}
+	if err := store.RecordProvenance(ctx, ref, want); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := store.GetUpdateOperations(ctx, driver.VulnerabilityKind, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops[name]) != 1 {
+		t.Fatalf("unexpected update operations: %v", ops)
+	}
+	if got := ops[name][0].Provenance; !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+
+	rs := []*claircore.IndexRecord{{Package: &claircore.Package{ID: "1", Name: "vi"}}}
+	for _, tc := range []struct {
+		opts datastore.GetOpts
+		want string
+	}{
+		{opts: datastore.GetOpts{}, want: ""},
+		{opts: datastore.GetOpts{UpdateRefs: true}, want: ref.String()},
+	} {
+		res, err := store.Get(ctx, rs, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res["1"]) != 1 {
+			t.Fatalf("unexpected results: %v", res)
+		}
+		if got := res["1"][0].UpdateRef; got != tc.want {
+			t.Errorf("UpdateRefs %v: got: %q, want: %q", tc.opts.UpdateRefs, got, tc.want)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/libvuln/driver"
 	"github.com/quay/claircore/pkg/microbatch"
+	"github.com/quay/claircore/pkg/pkgname"
 )
 
 var (
@@ -61,14 +62,16 @@ func updateVulnerabilites(ctx context.Context, pool *pgxpool.Pool, updater strin
 			package_name, package_version, package_module, package_arch, package_kind,
 			dist_id, dist_name, dist_version, dist_version_code_name, dist_version_id, dist_arch, dist_cpe, dist_pretty_name,
 			repo_name, repo_key, repo_uri,
-			fixed_in_version, arch_operation, version_kind, vulnerable_range
+			fixed_in_version, arch_operation, version_kind, vulnerable_range,
+			normalized_package_name
 		) VALUES (
 		  $1, $2,
 		  $3, $4, $5, $6, $7, $8, $9,
 		  $10, $11, $12, $13, $14,
 		  $15, $16, $17, $18, $19, $20, $21, $22,
 		  $23, $24, $25,
-		  $26, $27, $28, VersionRange($29, $30)
+		  $26, $27, $28, VersionRange($29, $30),
+		  $31
 		)
 		ON CONFLICT (hash_kind, hash) DO NOTHING;`
 		// Assoc associates an update operation and a vulnerability. It fails
@@ -134,6 +137,7 @@ func updateVulnerabilites(ctx context.Context, pool *pgxpool.Pool, updater strin
 			dist.DID, dist.Name, dist.Version, dist.VersionCodeName, dist.VersionID, dist.Arch, dist.CPE, dist.PrettyName,
 			repo.Name, repo.Key, repo.URI,
 			vuln.FixedInVersion, vuln.ArchOperation, vKind, vrLower, vrUpper,
+			pkgname.Normalize(repo.Name, pkg.Name),
 		)
 		if err != nil {
 			return uuid.Nil, fmt.Errorf("failed to queue vulnerability: %w", err)
@@ -61,3 +61,13 @@ type Updater interface {
 	// RecordUpdaterSetStatus records that all updaters from an updater set are up to date with vulnerabilities at this time
 	RecordUpdaterSetStatus(ctx context.Context, updaterSet string, updateTime time.Time) error
 }
+
+// ProvenanceRecorder is an optional interface an Updater store can implement
+// to persist the provenance of an update operation. Stores that implement it
+// should report the recorded provenance in UpdateOperations returned by
+// GetUpdateOperations.
+type ProvenanceRecorder interface {
+	// RecordProvenance attaches the provenance to the referenced update
+	// operation.
+	RecordProvenance(ctx context.Context, ref uuid.UUID, p *driver.Provenance) error
+}
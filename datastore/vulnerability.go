@@ -18,6 +18,9 @@ type GetOpts struct {
 	// VersionFiltering enables filtering based on the normalized versions in
 	// the database.
 	VersionFiltering bool
+	// UpdateRefs asks for each vulnerability's UpdateRef to be populated
+	// with the latest update operation that contains it.
+	UpdateRefs bool
 }
 
 type Vulnerability interface {
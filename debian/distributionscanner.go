@@ -21,6 +21,7 @@ import (
 var (
 	_ indexer.DistributionScanner = (*DistributionScanner)(nil)
 	_ indexer.VersionedScanner    = (*DistributionScanner)(nil)
+	_ indexer.FileInterest        = (*DistributionScanner)(nil)
 )
 
 // DistributionScanner attempts to discover if a layer
@@ -36,6 +37,11 @@ func (*DistributionScanner) Version() string { return "2" }
 // Kind implements [indexer.VersionedScanner].
 func (*DistributionScanner) Kind() string { return "distribution" }
 
+// FilesOfInterest implements [indexer.FileInterest].
+func (*DistributionScanner) FilesOfInterest() []string {
+	return []string{osrelease.Path}
+}
+
 // Scan implements [indexer.DistributionScanner].
 func (ds *DistributionScanner) Scan(ctx context.Context, l *claircore.Layer) ([]*claircore.Distribution, error) {
 	defer trace.StartRegion(ctx, "Scanner.Scan").End()
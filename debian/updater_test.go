@@ -0,0 +1,36 @@
+package debian
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quay/claircore/libvuln/driver/drivertest"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mkDist("bullseye", 11)
+	// Populate the source to binary mapping by hand, so that parsing doesn't
+	// need to reach out to a mirror.
+	sm := newSourcesMap(nil, nil)
+	for src, bins := range map[string][]string{
+		"aalib":       {"libaa1", "libaa1-dev", "libaa-bin"},
+		"389-ds-base": {"389-ds", "389-ds-base"},
+	} {
+		sm.sourceMap[src] = make(map[string]struct{})
+		for _, b := range bins {
+			sm.sourceMap[src][b] = struct{}{}
+		}
+	}
+	g := drivertest.Golden{
+		Updater: &updater{
+			url:   "https://www.debian.org/security/oval/oval-definitions-bullseye.xml",
+			dists: "https://deb.debian.org/debian/dists/bullseye/",
+			name:  "bullseye",
+			sm:    sm,
+		},
+		Fixture: "testdata/oval-definitions-bullseye.xml",
+	}
+	t.Run(g.Fixture, g.Run(ctx))
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"hash"
+	"sync"
 )
 
 const (
@@ -15,6 +16,56 @@ const (
 	SHA512 = "sha512"
 )
 
+// DigestAlgorithm is a hash function usable in a Digest.
+type digestAlgorithm struct {
+	new  func() hash.Hash
+	size int
+}
+
+var (
+	algosMu sync.RWMutex
+	algos   = map[string]digestAlgorithm{
+		SHA256: {new: sha256.New, size: sha256.Size},
+		SHA512: {new: sha512.New, size: sha512.Size},
+	}
+)
+
+// RegisterDigestAlgorithm makes the named hash function available for use in
+// Digests, in addition to sha256 and sha512. Names follow the OCI image spec's
+// rules for digest algorithms, like "blake3" or "sha256+b64u".
+//
+// This is meant to be called from an init function. It panics if the name is
+// already registered or empty, or contains a colon.
+func RegisterDigestAlgorithm(name string, new func() hash.Hash) {
+	if name == "" || bytes.IndexByte([]byte(name), ':') != -1 {
+		panic(fmt.Sprintf("claircore: invalid digest algorithm name %q", name))
+	}
+	algosMu.Lock()
+	defer algosMu.Unlock()
+	if _, ok := algos[name]; ok {
+		panic(fmt.Sprintf("claircore: digest algorithm %q already registered", name))
+	}
+	algos[name] = digestAlgorithm{new: new, size: new().Size()}
+}
+
+// DigestAlgorithms returns the names of the usable digest algorithms.
+func DigestAlgorithms() []string {
+	algosMu.RLock()
+	defer algosMu.RUnlock()
+	out := make([]string, 0, len(algos))
+	for n := range algos {
+		out = append(out, n)
+	}
+	return out
+}
+
+func lookupAlgorithm(name string) (digestAlgorithm, bool) {
+	algosMu.RLock()
+	defer algosMu.RUnlock()
+	a, ok := algos[name]
+	return a, ok
+}
+
 // Digest is a type representing the hash of some data.
 //
 // It's used throughout claircore packages as an attempt to remain independent
@@ -34,14 +85,11 @@ func (d Digest) Algorithm() string { return d.algo }
 
 // Hash returns an instance of the hashing algorithm used for this Digest.
 func (d Digest) Hash() hash.Hash {
-	switch d.algo {
-	case "sha256":
-		return sha256.New()
-	case "sha512":
-		return sha512.New()
-	default:
+	a, ok := lookupAlgorithm(d.algo)
+	if !ok {
 		panic("Hash() called on an invalid Digest")
 	}
+	return a.new()
 }
 
 func (d Digest) String() string {
@@ -91,15 +139,11 @@ func (e *DigestError) Unwrap() error {
 }
 
 func (d *Digest) setChecksum(b []byte) error {
-	var sz int
-	switch d.algo {
-	case "sha256":
-		sz = sha256.Size
-	case "sha512":
-		sz = sha512.Size
-	default:
-		return &DigestError{msg: fmt.Sprintf("unknown algorthm %q", d.algo)}
+	a, ok := lookupAlgorithm(d.algo)
+	if !ok {
+		return &DigestError{msg: fmt.Sprintf("unknown algorithm %q", d.algo)}
 	}
+	sz := a.size
 	if l := len(b); l != sz {
 		return &DigestError{msg: fmt.Sprintf("bad checksum length: %d", l)}
 	}
@@ -118,13 +162,24 @@ func (d *Digest) setChecksum(b []byte) error {
 }
 
 // Scan implements sql.Scanner.
+//
+// A NULL or empty value leaves the Digest as it is. Any other value must be a
+// well-formed digest, as text or bytes; a malformed value is reported as an
+// error rather than leaving the Digest half filled in.
 func (d *Digest) Scan(i interface{}) error {
 	switch v := i.(type) {
 	case nil:
 		return nil
 	case string:
-		d.UnmarshalText([]byte(v))
-		return nil
+		if v == "" {
+			return nil
+		}
+		return d.UnmarshalText([]byte(v))
+	case []byte:
+		if len(v) == 0 {
+			return nil
+		}
+		return d.UnmarshalText(v)
 	default:
 		return &DigestError{msg: fmt.Sprintf("invalid digest type: %T", v)}
 	}
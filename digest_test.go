@@ -0,0 +1,139 @@
+package claircore
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		for _, in := range []string{
+			"sha256:" + strings.Repeat("a", 64),
+			"sha512:" + strings.Repeat("b", 128),
+		} {
+			d, err := ParseDigest(in)
+			if err != nil {
+				t.Errorf("%q: %v", in, err)
+				continue
+			}
+			if got := d.String(); got != in {
+				t.Errorf("got: %q, want: %q", got, in)
+			}
+			if got, want := d.Hash().Size(), len(d.Checksum()); got != want {
+				t.Errorf("%q: hash size: got: %d, want: %d", in, got, want)
+			}
+		}
+		for _, in := range []string{
+			"",
+			"sha256",
+			"sha256:" + strings.Repeat("a", 128),
+			"sha512:" + strings.Repeat("b", 64),
+			"md5:" + strings.Repeat("c", 32),
+			"sha256:" + strings.Repeat("z", 64),
+		} {
+			if d, err := ParseDigest(in); err == nil {
+				t.Errorf("%q: expected error, got: %v", in, d)
+			}
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		type doc struct {
+			D Digest `json:"d"`
+		}
+		sum := sha512.Sum512([]byte("test"))
+		d512, err := NewDigest(SHA512, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(doc{D: d512})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out doc
+		if err := json.Unmarshal(b, &out); err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+		if got, want := out.D.String(), d512.String(); got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+		if err := json.Unmarshal([]byte(`{"d":""}`), &out); err == nil {
+			t.Error("expected error for empty digest")
+		}
+		// Optional digests are pointers, omitted when unset.
+		b, err = json.Marshal(&Layer{Hash: d512})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var l Layer
+		if err := json.Unmarshal(b, &l); err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+		if l.ExpectedDiffID != nil {
+			t.Errorf("%s: unexpected expected_diff_id", b)
+		}
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		want := "sha512:" + strings.Repeat("b", 128)
+		for _, in := range []interface{}{want, []byte(want)} {
+			var d Digest
+			if err := d.Scan(in); err != nil {
+				t.Fatal(err)
+			}
+			if got := d.String(); got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		}
+		var d Digest
+		if err := d.Scan("sha512:beef"); err == nil {
+			t.Error("expected error")
+		}
+		for _, in := range []interface{}{nil, "", []byte{}} {
+			var d Digest
+			if err := d.Scan(in); err != nil {
+				t.Errorf("%#v: %v", in, err)
+			}
+			if d.Checksum() != nil {
+				t.Errorf("%#v: got: %v, want zero Digest", in, d)
+			}
+		}
+	})
+
+	t.Run("Register", func(t *testing.T) {
+		const name = "test-sha224"
+		RegisterDigestAlgorithm(name, sha256.New224)
+		sum := sha256.Sum224([]byte("test"))
+		d, err := NewDigest(name, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParseDigest(d.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := p.Hash()
+		h.Write([]byte("test"))
+		if got, want := string(h.Sum(nil)), string(sum[:]); got != want {
+			t.Error("hash mismatch")
+		}
+		found := false
+		for _, a := range DigestAlgorithms() {
+			found = found || a == name
+		}
+		if !found {
+			t.Errorf("%q missing from DigestAlgorithms", name)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic on duplicate registration")
+				}
+			}()
+			RegisterDigestAlgorithm(SHA256, sha256.New)
+		}()
+	})
+}
@@ -0,0 +1,175 @@
+// Package dotnet contains components for interrogating .NET applications'
+// NuGet packages in container layers.
+package dotnet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"runtime/trace"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/pkg/tarfs"
+)
+
+var (
+	_ indexer.VersionedScanner = (*Scanner)(nil)
+	_ indexer.PackageScanner   = (*Scanner)(nil)
+	_ indexer.PortableScanner  = (*Scanner)(nil)
+)
+
+// Scanner implements the scanner.PackageScanner interface.
+//
+// It looks for the dependency manifests (".deps.json" files) the .NET SDK
+// writes next to published applications, and reports the NuGet packages
+// recorded there.
+//
+// The zero value is ready to use.
+type Scanner struct{}
+
+// Name implements scanner.VersionedScanner.
+func (*Scanner) Name() string { return "nuget" }
+
+// Version implements scanner.VersionedScanner.
+func (*Scanner) Version() string { return "0.0.1" }
+
+// Kind implements scanner.VersionedScanner.
+func (*Scanner) Kind() string { return "package" }
+
+// Portable implements indexer.PortableScanner.
+func (*Scanner) Portable() {}
+
+// Scan attempts to find .NET dependency manifests and record the NuGet
+// packages listed there.
+//
+// A return of (nil, nil) is expected if there's nothing found.
+func (ps *Scanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*claircore.Package, error) {
+	defer trace.StartRegion(ctx, "Scanner.Scan").End()
+	trace.Log(ctx, "layer", layer.Hash.String())
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "dotnet/Scanner.Scan",
+		"version", ps.Version(),
+		"layer", layer.Hash.String())
+	zlog.Debug(ctx).Msg("start")
+	defer zlog.Debug(ctx).Msg("done")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r, err := layer.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	sys, err := tarfs.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("dotnet: unable to open tar: %w", err)
+	}
+
+	ms, err := findDepsFiles(ctx, sys)
+	if err != nil {
+		return nil, fmt.Errorf("dotnet: failed to find dependency manifests: %w", err)
+	}
+	var ret []*claircore.Package
+	for _, n := range ms {
+		b, err := fs.ReadFile(sys, n)
+		if err != nil {
+			return nil, fmt.Errorf("dotnet: unable to read file: %w", err)
+		}
+		var deps depsFile
+		if err := json.Unmarshal(b, &deps); err != nil {
+			zlog.Warn(ctx).
+				Err(err).
+				Str("path", n).
+				Msg("unable to read dependency manifest, skipping")
+			indexer.Warn(ctx, n, "unable to read dependency manifest: %v", err)
+			continue
+		}
+		for key, lib := range deps.Libraries {
+			// Projects are the application itself and its other parts, and
+			// references are assemblies without a package.
+			if lib.Type != "package" {
+				continue
+			}
+			i := strings.LastIndexByte(key, '/')
+			if i <= 0 || i == len(key)-1 {
+				indexer.Warn(ctx, n, "malformed library %q", key)
+				continue
+			}
+			ret = append(ret, &claircore.Package{
+				Name:           key[:i],
+				Version:        key[i+1:],
+				PackageDB:      "nuget:" + n,
+				Kind:           claircore.BINARY,
+				RepositoryHint: Repository.URI,
+			})
+		}
+	}
+	return ret, nil
+}
+
+// DepsFile is the part of a .NET dependency manifest the Scanner uses.
+type depsFile struct {
+	Libraries map[string]struct {
+		Type string `json:"type"`
+	} `json:"libraries"`
+}
+
+// MaxCandidates is the most dependency manifests findDepsFiles will report for
+// a single layer. Past this, the layer is assumed to be pathological.
+const maxCandidates = 8192
+
+// SkipRoots are top-level directories that are usually mount points for
+// pseudo-filesystems, and have no business being in a layer.
+var skipRoots = map[string]struct{}{
+	"proc": {},
+	"sys":  {},
+	"dev":  {},
+}
+
+// FindDepsFiles finds .NET dependency manifests.
+//
+// The whole layer is searched, as applications may be published anywhere. In
+// Windows layers, that includes the "Files" tree.
+func findDepsFiles(ctx context.Context, sys fs.FS) (out []string, err error) {
+	err = fs.WalkDir(sys, ".", func(p string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case d.IsDir():
+			if _, ok := skipRoots[p]; ok {
+				return fs.SkipDir
+			}
+			return nil
+		case !d.Type().IsRegular():
+			return nil
+		case strings.HasSuffix(path.Base(p), ".deps.json"):
+			zlog.Debug(ctx).Str("file", p).Msg("found dependency manifest")
+		default:
+			return nil
+		}
+		if len(out) == maxCandidates {
+			return errTooMany
+		}
+		out = append(out, p)
+		return nil
+	})
+	if errors.Is(err, errTooMany) {
+		zlog.Warn(ctx).
+			Int("limit", maxCandidates).
+			Msg("too many dependency manifests, ignoring the rest")
+		indexer.Warn(ctx, "", "more than %d .NET dependency manifests found, ignoring the rest", maxCandidates)
+		err = nil
+	}
+	return out, err
+}
+
+// ErrTooMany is used to stop findDepsFiles' walk early.
+var errTooMany = errors.New("too many candidates")
@@ -0,0 +1,80 @@
+package dotnet_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/dotnet"
+	"github.com/quay/claircore/indexer"
+)
+
+func TestScanLocal(t *testing.T) {
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+
+	table := []struct {
+		name      string
+		want      []*claircore.Package
+		warnings  int
+		layerPath string
+	}{
+		{
+			// Synthetic Windows layer: the application is under "Files", next
+			// to a "UtilityVM" tree. Only the "package" libraries should be
+			// reported, not the project or the reference.
+			name: "windows layout",
+			want: []*claircore.Package{
+				{
+					Name:           "Newtonsoft.Json",
+					Version:        "13.0.1",
+					Kind:           claircore.BINARY,
+					PackageDB:      "nuget:Files/app/app.deps.json",
+					RepositoryHint: "https://api.nuget.org/v3/index.json",
+				},
+				{
+					Name:           "System.Text.Encodings.Web",
+					Version:        "4.7.1",
+					Kind:           claircore.BINARY,
+					PackageDB:      "nuget:Files/app/app.deps.json",
+					RepositoryHint: "https://api.nuget.org/v3/index.json",
+				},
+			},
+			layerPath: "testdata/layer-windows.tar",
+		},
+		{
+			name:      "bad manifest",
+			want:      nil,
+			warnings:  1,
+			layerPath: "testdata/layer-bad.tar",
+		},
+	}
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			var ws []claircore.IndexWarning
+			ctx = indexer.WithWarningFunc(ctx, func(w claircore.IndexWarning) {
+				ws = append(ws, w)
+			})
+			scanner := &dotnet.Scanner{}
+			l := &claircore.Layer{}
+			l.SetLocal(tt.layerPath)
+
+			got, err := scanner.Scan(ctx, l)
+			if err != nil {
+				t.Error(err)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].Name < got[j].Name })
+			if !cmp.Equal(got, tt.want) {
+				t.Error(cmp.Diff(got, tt.want))
+			}
+			if got, want := len(ws), tt.warnings; got != want {
+				t.Errorf("warnings: got: %d, want: %d (%v)", got, want, ws)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package dotnet
+
+import (
+	"context"
+	"fmt"
+	"runtime/trace"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/pkg/tarfs"
+)
+
+var (
+	_ indexer.VersionedScanner  = (*RepoScanner)(nil)
+	_ indexer.RepositoryScanner = (*RepoScanner)(nil)
+	_ indexer.PortableScanner   = (*RepoScanner)(nil)
+
+	// Repository is the NuGet gallery, which packages are assumed to have
+	// come from.
+	Repository = claircore.Repository{
+		Name: "nuget",
+		URI:  "https://api.nuget.org/v3/index.json",
+	}
+)
+
+// RepoScanner reports the NuGet repository for layers containing .NET
+// dependency manifests.
+type RepoScanner struct{}
+
+// Name implements scanner.VersionedScanner.
+func (*RepoScanner) Name() string { return "nuget" }
+
+// Version implements scanner.VersionedScanner.
+func (*RepoScanner) Version() string { return "0.0.1" }
+
+// Kind implements scanner.VersionedScanner.
+func (*RepoScanner) Kind() string { return "repository" }
+
+// Portable implements indexer.PortableScanner.
+func (*RepoScanner) Portable() {}
+
+// Scan attempts to find .NET dependency manifests.
+//
+// A return of (nil, nil) is expected if there's nothing found.
+func (rs *RepoScanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*claircore.Repository, error) {
+	defer trace.StartRegion(ctx, "RepoScanner.Scan").End()
+	trace.Log(ctx, "layer", layer.Hash.String())
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "dotnet/RepoScanner.Scan",
+		"version", rs.Version(),
+		"layer", layer.Hash.String())
+	zlog.Debug(ctx).Msg("start")
+	defer zlog.Debug(ctx).Msg("done")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r, err := layer.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	sys, err := tarfs.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("dotnet: unable to open tar: %w", err)
+	}
+
+	ms, err := findDepsFiles(ctx, sys)
+	if err != nil {
+		return nil, fmt.Errorf("dotnet: failed to find dependency manifests: %w", err)
+	}
+	if len(ms) != 0 {
+		return []*claircore.Repository{&Repository}, nil
+	}
+	return nil, nil
+}
@@ -31,6 +31,7 @@ const (
 var (
 	_ indexer.VersionedScanner = (*Scanner)(nil)
 	_ indexer.PackageScanner   = (*Scanner)(nil)
+	_ indexer.FileInterest     = (*Scanner)(nil)
 )
 
 // Scanner implements the scanner.PackageScanner interface.
@@ -50,6 +51,13 @@ func (ps *Scanner) Version() string { return version }
 // Kind implements scanner.VersionedScanner.
 func (ps *Scanner) Kind() string { return kind }
 
+// FilesOfInterest implements indexer.FileInterest.
+//
+// Databases may be anywhere in the layer, so these match by name.
+func (ps *Scanner) FilesOfInterest() []string {
+	return []string{"status", "*.md5sums"}
+}
+
 // Scan attempts to find a dpkg database within the layer and read all of the
 // installed packages it can find in the "status" file.
 //
@@ -162,6 +170,7 @@ func (ps *Scanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*clairco
 		case errors.Is(err, io.EOF):
 		default:
 			zlog.Warn(ctx).Err(err).Msg("unable to read entry")
+			indexer.Warn(ctx, fn, "unable to read entry: %v", err)
 			goto Restart
 		}
 
@@ -196,6 +205,7 @@ func (ps *Scanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*clairco
 					Err(err).
 					Str("package", n).
 					Msg("unable to read package metadata")
+				indexer.Warn(ctx, n, "unable to read package metadata: %v", err)
 				continue
 			}
 			p.RepositoryHint = hex.EncodeToString(hash.Sum(nil))
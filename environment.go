@@ -8,6 +8,11 @@ package claircore
 // IndexReport or VulnerabilityReport.
 type Environment struct {
 	// the package database the associated package was discovered in
+	//
+	// This is the path of the database inside the layer, such as
+	// "var/lib/dpkg/status", possibly prefixed by the kind of database and
+	// a colon, such as "sqlite:var/lib/rpm" or "jar:app/lib/x.jar". See
+	// Provenance for a parsed form.
 	PackageDB string `json:"package_db"`
 	// the layer in which the associated package was introduced
 	IntroducedIn Digest `json:"introduced_in"`
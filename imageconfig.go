@@ -0,0 +1,49 @@
+package claircore
+
+import "time"
+
+// ImageConfig is the part of an OCI image configuration claircore makes use
+// of. The configuration blob's JSON can be unmarshaled into it directly.
+type ImageConfig struct {
+	// Architecture is the CPU architecture the image's binaries are built
+	// for, e.g. "amd64" or "arm64".
+	Architecture string `json:"architecture,omitempty"`
+	// OS is the operating system the image is built for, e.g. "linux".
+	OS string `json:"os,omitempty"`
+	// Variant is the variant of the CPU architecture, e.g. "v8".
+	Variant string `json:"variant,omitempty"`
+	// Created is when the image was created.
+	Created *time.Time `json:"created,omitempty"`
+	// Config is the execution parameters for the image.
+	Config struct {
+		Labels map[string]string `json:"Labels,omitempty"`
+	} `json:"config"`
+	// RootFS describes the layers making up the image.
+	RootFS struct {
+		// DiffIDs are the digests of the layers' uncompressed tar streams,
+		// in order.
+		DiffIDs []Digest `json:"diff_ids,omitempty"`
+	} `json:"rootfs"`
+}
+
+// ConfigRef locates an image configuration blob, for libindex to fetch.
+type ConfigRef struct {
+	// Hash is the digest of the configuration blob. The fetched blob is
+	// verified against it.
+	Hash    Digest              `json:"hash"`
+	URI     string              `json:"uri"`
+	Headers map[string][]string `json:"headers,omitempty"`
+}
+
+// ImageMetadata is information from an image's configuration reported
+// alongside the contents of the image.
+type ImageMetadata struct {
+	Architecture string     `json:"architecture,omitempty"`
+	OS           string     `json:"os,omitempty"`
+	Variant      string     `json:"variant,omitempty"`
+	Created      *time.Time `json:"created,omitempty"`
+	// Labels are the image's labels. Labels are limited in total size; if
+	// some were left out, LabelsTruncated is set.
+	Labels          map[string]string `json:"labels,omitempty"`
+	LabelsTruncated bool              `json:"labels_truncated,omitempty"`
+}
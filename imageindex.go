@@ -0,0 +1,50 @@
+package claircore
+
+import "strings"
+
+// Platform identifies what an image is built for, using the values of an OCI
+// image index's "platform" object.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// String returns the platform in the "os/architecture[/variant]" form used by
+// container tooling, e.g. "linux/arm64/v8".
+func (p Platform) String() string {
+	var b strings.Builder
+	b.WriteString(p.OS)
+	b.WriteByte('/')
+	b.WriteString(p.Architecture)
+	if p.Variant != "" {
+		b.WriteByte('/')
+		b.WriteString(p.Variant)
+	}
+	return b.String()
+}
+
+// ImageIndex is analogous to an OCI image index: a set of manifests of the
+// same image, one for each platform it's built for.
+type ImageIndex struct {
+	// Hash is the digest of the image index.
+	Hash Digest `json:"hash"`
+	// Manifests are the image's manifests, each for a different platform.
+	Manifests []PlatformManifest `json:"manifests"`
+}
+
+// PlatformManifest is a Manifest in an ImageIndex.
+type PlatformManifest struct {
+	Platform Platform  `json:"platform"`
+	Manifest *Manifest `json:"manifest"`
+}
+
+// ImageIndexReport is the result of indexing every manifest in an ImageIndex.
+type ImageIndexReport struct {
+	// the image index hash this report is describing
+	Hash Digest `json:"index_hash"`
+	// the manifest for each platform, keyed by the platform's String form
+	Manifests map[string]Digest `json:"manifests"`
+	// the IndexReport for each platform, keyed by the platform's String form
+	Reports map[string]*IndexReport `json:"reports"`
+}
@@ -0,0 +1,79 @@
+package claircore
+
+import "sort"
+
+// IndexDiff describes what changed between two IndexReports.
+//
+// Like an update diff, A is the base and B is the report being compared
+// against it: "Added" is what's in B and not A, and "Removed" is what's in A
+// and not B. A package whose version changed shows up as one removed and one
+// added package.
+type IndexDiff struct {
+	// the manifest hash of the base IndexReport
+	A Digest `json:"a"`
+	// the manifest hash of the IndexReport compared against the base
+	B       Digest       `json:"b"`
+	Added   IndexChanges `json:"added"`
+	Removed IndexChanges `json:"removed"`
+}
+
+// IndexChanges is one side of an IndexDiff.
+type IndexChanges struct {
+	Packages      []*Package      `json:"packages"`
+	Distributions []*Distribution `json:"distributions"`
+	Repositories  []*Repository   `json:"repositories"`
+}
+
+// Empty reports whether there are no changes.
+func (c *IndexChanges) Empty() bool {
+	return len(c.Packages) == 0 && len(c.Distributions) == 0 && len(c.Repositories) == 0
+}
+
+// DiffIndexReports returns the differences between the IndexReports "a" and
+// "b".
+//
+// Artifacts are matched up by their IDs, so the reports should come from the
+// same indexer. The returned slices are sorted by ID.
+func DiffIndexReports(a, b *IndexReport) *IndexDiff {
+	d := IndexDiff{A: a.Hash, B: b.Hash}
+	for id, p := range b.Packages {
+		if _, ok := a.Packages[id]; !ok {
+			d.Added.Packages = append(d.Added.Packages, p)
+		}
+	}
+	for id, p := range a.Packages {
+		if _, ok := b.Packages[id]; !ok {
+			d.Removed.Packages = append(d.Removed.Packages, p)
+		}
+	}
+	for id, dist := range b.Distributions {
+		if _, ok := a.Distributions[id]; !ok {
+			d.Added.Distributions = append(d.Added.Distributions, dist)
+		}
+	}
+	for id, dist := range a.Distributions {
+		if _, ok := b.Distributions[id]; !ok {
+			d.Removed.Distributions = append(d.Removed.Distributions, dist)
+		}
+	}
+	for id, r := range b.Repositories {
+		if _, ok := a.Repositories[id]; !ok {
+			d.Added.Repositories = append(d.Added.Repositories, r)
+		}
+	}
+	for id, r := range a.Repositories {
+		if _, ok := b.Repositories[id]; !ok {
+			d.Removed.Repositories = append(d.Removed.Repositories, r)
+		}
+	}
+	d.Added.sort()
+	d.Removed.sort()
+	return &d
+}
+
+// Sort puts the changes in ID order, so diffs are stable.
+func (c *IndexChanges) sort() {
+	sort.Slice(c.Packages, func(i, j int) bool { return c.Packages[i].ID < c.Packages[j].ID })
+	sort.Slice(c.Distributions, func(i, j int) bool { return c.Distributions[i].ID < c.Distributions[j].ID })
+	sort.Slice(c.Repositories, func(i, j int) bool { return c.Repositories[i].ID < c.Repositories[j].ID })
+}
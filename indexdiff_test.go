@@ -0,0 +1,68 @@
+package claircore_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/quay/claircore"
+)
+
+func TestDiffIndexReports(t *testing.T) {
+	pkg := func(id, name, version string) *claircore.Package {
+		return &claircore.Package{ID: id, Name: name, Version: version}
+	}
+	a := &claircore.IndexReport{
+		Hash: claircore.MustParseDigest(`sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef`),
+		Packages: map[string]*claircore.Package{
+			"1": pkg("1", "bash", "5.0"),
+			"2": pkg("2", "openssl", "1.1.1k"),
+		},
+		Distributions: map[string]*claircore.Distribution{
+			"1": {ID: "1", DID: "rhel", VersionID: "8"},
+		},
+		Repositories: map[string]*claircore.Repository{
+			"1": {ID: "1", Name: "baseos"},
+		},
+	}
+	b := &claircore.IndexReport{
+		Hash: claircore.MustParseDigest(`sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855`),
+		Packages: map[string]*claircore.Package{
+			"1": pkg("1", "bash", "5.0"),
+			"3": pkg("3", "openssl", "1.1.1l"),
+			"4": pkg("4", "curl", "7.61"),
+		},
+		Distributions: map[string]*claircore.Distribution{
+			"1": {ID: "1", DID: "rhel", VersionID: "8"},
+		},
+		Repositories: map[string]*claircore.Repository{
+			"1": {ID: "1", Name: "baseos"},
+			"2": {ID: "2", Name: "appstream"},
+		},
+	}
+
+	d := claircore.DiffIndexReports(a, b)
+	if got, want := d.A.String(), a.Hash.String(); got != want {
+		t.Errorf("a: got: %q, want: %q", got, want)
+	}
+	if got, want := d.B.String(), b.Hash.String(); got != want {
+		t.Errorf("b: got: %q, want: %q", got, want)
+	}
+	want := claircore.IndexChanges{
+		Packages:     []*claircore.Package{b.Packages["3"], b.Packages["4"]},
+		Repositories: []*claircore.Repository{b.Repositories["2"]},
+	}
+	if got := d.Added; !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+	want = claircore.IndexChanges{
+		Packages: []*claircore.Package{a.Packages["2"]},
+	}
+	if got := d.Removed; !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+
+	if d := claircore.DiffIndexReports(a, a); !d.Added.Empty() || !d.Removed.Empty() {
+		t.Errorf("unexpected changes: %+v", d)
+	}
+}
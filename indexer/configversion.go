@@ -0,0 +1,259 @@
+package indexer
+
+import (
+	"context"
+)
+
+// ConfigVersionSep separates a scanner's version from its configuration
+// version in the version of a scanner returned by VersionEcosystems.
+const configVersionSep = "+config."
+
+// ConfigVersions holds versions for scanners' configurations, broken out by
+// kind and keyed by scanner name like Opts.ScannerConfig.
+type ConfigVersions struct {
+	Package, Dist, Repo map[string]string
+}
+
+// VersionEcosystems returns copies of the provided Ecosystems whose scanners
+// have their configuration version, if any, appended to their version. The
+// versioned scanners implement the same optional interfaces as the scanners
+// they wrap, as described by FilterPackageScanner.
+//
+// Layers are only scanned once per scanner name, kind, and version, and the
+// results are shared between all manifests containing the layer. Changing a
+// configuration version has layers scanned again by the scanner, instead of
+// reusing results produced with the old configuration.
+func VersionEcosystems(es []*Ecosystem, v ConfigVersions) []*Ecosystem {
+	out := make([]*Ecosystem, len(es))
+	for i, e := range es {
+		e := *e
+		ps, ds, rs := e.PackageScanners, e.DistributionScanners, e.RepositoryScanners
+		if ps != nil {
+			e.PackageScanners = func(ctx context.Context) ([]PackageScanner, error) {
+				ss, err := ps(ctx)
+				if err != nil {
+					return nil, err
+				}
+				for i, s := range ss {
+					if cv, ok := v.Package[s.Name()]; ok {
+						ss[i] = versionPackageScanner(s, cv)
+					}
+				}
+				return ss, nil
+			}
+		}
+		if ds != nil {
+			e.DistributionScanners = func(ctx context.Context) ([]DistributionScanner, error) {
+				ss, err := ds(ctx)
+				if err != nil {
+					return nil, err
+				}
+				for i, s := range ss {
+					if cv, ok := v.Dist[s.Name()]; ok {
+						ss[i] = versionDistributionScanner(s, cv)
+					}
+				}
+				return ss, nil
+			}
+		}
+		if rs != nil {
+			e.RepositoryScanners = func(ctx context.Context) ([]RepositoryScanner, error) {
+				ss, err := rs(ctx)
+				if err != nil {
+					return nil, err
+				}
+				for i, s := range ss {
+					if cv, ok := v.Repo[s.Name()]; ok {
+						ss[i] = versionRepositoryScanner(s, cv)
+					}
+				}
+				return ss, nil
+			}
+		}
+		out[i] = &e
+	}
+	return out
+}
+
+// VersionPackageScanner returns a PackageScanner reporting the provided
+// scanner's version with the configuration version appended.
+//
+// Like FilterPackageScanner, the returned scanner implements the same optional
+// interfaces as the provided one.
+func versionPackageScanner(s PackageScanner, cv string) PackageScanner {
+	vs := &versionedPackage{PackageScanner: s, cv: cv}
+	_, portable := s.(PortableScanner)
+	cs, csOK := s.(ConfigurableScanner)
+	rs, rsOK := s.(RPCScanner)
+	switch {
+	case rsOK && portable:
+		return &portableRPCVersionedPackage{rpcVersionedPackage{vs, rs}}
+	case rsOK:
+		return &rpcVersionedPackage{vs, rs}
+	case csOK && portable:
+		return &portableConfigurableVersionedPackage{configurableVersionedPackage{vs, cs}}
+	case csOK:
+		return &configurableVersionedPackage{vs, cs}
+	case portable:
+		return &portableVersionedPackage{vs}
+	}
+	return vs
+}
+
+// VersionDistributionScanner is versionPackageScanner for
+// DistributionScanners.
+func versionDistributionScanner(s DistributionScanner, cv string) DistributionScanner {
+	vs := &versionedDistribution{DistributionScanner: s, cv: cv}
+	_, portable := s.(PortableScanner)
+	cs, csOK := s.(ConfigurableScanner)
+	rs, rsOK := s.(RPCScanner)
+	switch {
+	case rsOK && portable:
+		return &portableRPCVersionedDistribution{rpcVersionedDistribution{vs, rs}}
+	case rsOK:
+		return &rpcVersionedDistribution{vs, rs}
+	case csOK && portable:
+		return &portableConfigurableVersionedDistribution{configurableVersionedDistribution{vs, cs}}
+	case csOK:
+		return &configurableVersionedDistribution{vs, cs}
+	case portable:
+		return &portableVersionedDistribution{vs}
+	}
+	return vs
+}
+
+// VersionRepositoryScanner is versionPackageScanner for RepositoryScanners.
+func versionRepositoryScanner(s RepositoryScanner, cv string) RepositoryScanner {
+	vs := &versionedRepository{RepositoryScanner: s, cv: cv}
+	_, portable := s.(PortableScanner)
+	cs, csOK := s.(ConfigurableScanner)
+	rs, rsOK := s.(RPCScanner)
+	switch {
+	case rsOK && portable:
+		return &portableRPCVersionedRepository{rpcVersionedRepository{vs, rs}}
+	case rsOK:
+		return &rpcVersionedRepository{vs, rs}
+	case csOK && portable:
+		return &portableConfigurableVersionedRepository{configurableVersionedRepository{vs, cs}}
+	case csOK:
+		return &configurableVersionedRepository{vs, cs}
+	case portable:
+		return &portableVersionedRepository{vs}
+	}
+	return vs
+}
+
+// VersionedPackage is the PackageScanner returned by versionPackageScanner.
+// The other *VersionedPackage types add the optional interfaces the wrapped
+// scanner implements.
+type versionedPackage struct {
+	PackageScanner
+	cv string
+}
+
+func (s *versionedPackage) unwrap() VersionedScanner { return s.PackageScanner }
+
+// Version implements VersionedScanner.
+func (s *versionedPackage) Version() string {
+	return s.PackageScanner.Version() + configVersionSep + s.cv
+}
+
+type configurableVersionedPackage struct {
+	*versionedPackage
+	ConfigurableScanner
+}
+
+type rpcVersionedPackage struct {
+	*versionedPackage
+	RPCScanner
+}
+
+type portableVersionedPackage struct{ *versionedPackage }
+
+func (portableVersionedPackage) Portable() {}
+
+type portableConfigurableVersionedPackage struct{ configurableVersionedPackage }
+
+func (portableConfigurableVersionedPackage) Portable() {}
+
+type portableRPCVersionedPackage struct{ rpcVersionedPackage }
+
+func (portableRPCVersionedPackage) Portable() {}
+
+// VersionedDistribution is the DistributionScanner returned by
+// versionDistributionScanner. The other *VersionedDistribution types add the
+// optional interfaces the wrapped scanner implements.
+type versionedDistribution struct {
+	DistributionScanner
+	cv string
+}
+
+func (s *versionedDistribution) unwrap() VersionedScanner { return s.DistributionScanner }
+
+// Version implements VersionedScanner.
+func (s *versionedDistribution) Version() string {
+	return s.DistributionScanner.Version() + configVersionSep + s.cv
+}
+
+type configurableVersionedDistribution struct {
+	*versionedDistribution
+	ConfigurableScanner
+}
+
+type rpcVersionedDistribution struct {
+	*versionedDistribution
+	RPCScanner
+}
+
+type portableVersionedDistribution struct{ *versionedDistribution }
+
+func (portableVersionedDistribution) Portable() {}
+
+type portableConfigurableVersionedDistribution struct {
+	configurableVersionedDistribution
+}
+
+func (portableConfigurableVersionedDistribution) Portable() {}
+
+type portableRPCVersionedDistribution struct{ rpcVersionedDistribution }
+
+func (portableRPCVersionedDistribution) Portable() {}
+
+// VersionedRepository is the RepositoryScanner returned by
+// versionRepositoryScanner. The other *VersionedRepository types add the
+// optional interfaces the wrapped scanner implements.
+type versionedRepository struct {
+	RepositoryScanner
+	cv string
+}
+
+func (s *versionedRepository) unwrap() VersionedScanner { return s.RepositoryScanner }
+
+// Version implements VersionedScanner.
+func (s *versionedRepository) Version() string {
+	return s.RepositoryScanner.Version() + configVersionSep + s.cv
+}
+
+type configurableVersionedRepository struct {
+	*versionedRepository
+	ConfigurableScanner
+}
+
+type rpcVersionedRepository struct {
+	*versionedRepository
+	RPCScanner
+}
+
+type portableVersionedRepository struct{ *versionedRepository }
+
+func (portableVersionedRepository) Portable() {}
+
+type portableConfigurableVersionedRepository struct {
+	configurableVersionedRepository
+}
+
+func (portableConfigurableVersionedRepository) Portable() {}
+
+type portableRPCVersionedRepository struct{ rpcVersionedRepository }
+
+func (portableRPCVersionedRepository) Portable() {}
@@ -0,0 +1,77 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quay/claircore"
+)
+
+// ConfigScanner is a portable, configurable package scanner.
+type configScanner struct {
+	mockPackageScanner
+	configured bool
+}
+
+func (*configScanner) Portable() {}
+func (s *configScanner) Configure(context.Context, ConfigDeserializer) error {
+	s.configured = true
+	return nil
+}
+
+func TestVersionEcosystems(t *testing.T) {
+	ctx := context.Background()
+	inner := &configScanner{mockPackageScanner: mockPackageScanner{name: "pkg", version: "1", kind: Package}}
+	other := NewPackageScannerMock("other", "1", Package)
+	es := FilterEcosystems([]*Ecosystem{{
+		Name: "test",
+		PackageScanners: func(context.Context) ([]PackageScanner, error) {
+			return []PackageScanner{inner, other}, nil
+		},
+	}}, func(*claircore.Package, *claircore.Layer) bool { return true }, "f")
+	es = VersionEcosystems(es, ConfigVersions{
+		Package: map[string]string{"pkg": "c", "other": "d"},
+	})
+	ps, err := es[0].PackageScanners(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := ps[0].Version(), "1+filter.f+config.c"; got != want {
+		t.Errorf("version: got: %q, want: %q", got, want)
+	}
+	if got, want := ps[1].Version(), "1+filter.f+config.d"; got != want {
+		t.Errorf("version: got: %q, want: %q", got, want)
+	}
+	if _, ok := ps[0].(PortableScanner); !ok {
+		t.Errorf("%T should implement PortableScanner", ps[0])
+	}
+	if _, ok := ps[1].(PortableScanner); ok {
+		t.Errorf("%T shouldn't implement PortableScanner", ps[1])
+	}
+	// Configuration interfaces are passed through as-is: scanners don't gain
+	// ones they didn't implement.
+	if _, ok := ps[0].(RPCScanner); ok {
+		t.Errorf("%T shouldn't implement RPCScanner", ps[0])
+	}
+	if _, ok := ps[1].(RPCScanner); ok {
+		t.Errorf("%T shouldn't implement RPCScanner", ps[1])
+	}
+	if _, ok := ps[1].(ConfigurableScanner); ok {
+		t.Errorf("%T shouldn't implement ConfigurableScanner", ps[1])
+	}
+	cs, ok := ps[0].(ConfigurableScanner)
+	if !ok {
+		t.Fatalf("%T should implement ConfigurableScanner", ps[0])
+	}
+	if err := cs.Configure(ctx, func(interface{}) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !inner.configured {
+		t.Error("wrapped scanner not configured")
+	}
+	// Ecosystems without a constructor are left alone.
+	if es[0].DistributionScanners != nil {
+		t.Error("unexpected DistributionScanners")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/quay/zlog"
 
+	"github.com/quay/claircore"
 	"github.com/quay/claircore/indexer"
 )
 
@@ -32,14 +33,37 @@ func checkManifest(ctx context.Context, s *Controller) (State, error) {
 
 	scannedManifestCounter.WithLabelValues(strconv.FormatBool(ok)).Add(1)
 
+	// we have seen this manifest before and it's been been processed with the
+	// desired scanners. if it was indexed in the desired mode, retrieve the
+	// existing report and transition to Terminal.
+	if ok {
+		zlog.Info(ctx).Msg("manifest already scanned")
+		sr, ok, err := s.Store.IndexReport(ctx, s.manifest.Hash)
+		if err != nil {
+			return Terminal, fmt.Errorf("failed to retrieve manifest: %w", err)
+		}
+		if !ok {
+			return Terminal, fmt.Errorf("failed to retrieve manifest: %w", err)
+		}
+		if sameMode(sr.IndexMode, s.report.IndexMode) {
+			s.report = sr
+			return Terminal, nil
+		}
+		zlog.Info(ctx).
+			Str("previous", sr.IndexMode).
+			Str("mode", s.report.IndexMode).
+			Msg("manifest indexed in another mode, indexing again")
+	}
+
 	// if we haven't seen this manifest, determine which scanners to use, persist it
 	// and transition to FetchLayer state.
-	if !ok {
-		zlog.Info(ctx).Msg("manifest to be scanned")
+	zlog.Info(ctx).Msg("manifest to be scanned")
 
-		// if a manifest was analyzed by a particular scanner we can
-		// omit it from this index, as all its comprising layers were analyzed
-		// by the particular scanner as well.
+	// if a manifest was analyzed by a particular scanner we can
+	// omit it from this index, as all its comprising layers were analyzed
+	// by the particular scanner as well. this doesn't hold for a manifest
+	// indexed in another mode, which needs every scanner.
+	if !ok {
 		filtered := make(indexer.VersionedScanners, 0, len(s.Vscnrs))
 		for i := range s.Vscnrs {
 			ok, err := s.Store.ManifestScanned(ctx, s.manifest.Hash, s.Vscnrs[i:i+1]) // slice this to avoid allocations
@@ -51,25 +75,101 @@ func checkManifest(ctx context.Context, s *Controller) (State, error) {
 			}
 		}
 		s.Vscnrs = filtered
+	}
 
-		err := s.Store.PersistManifest(ctx, *s.manifest)
-		if err != nil {
-			return Terminal, fmt.Errorf("failed to persist manifest: %w", err)
+	err = s.Store.PersistManifest(ctx, *s.manifest)
+	if err != nil {
+		return Terminal, fmt.Errorf("failed to persist manifest: %w", err)
+	}
+	next, err := resume(ctx, s)
+	if err != nil {
+		return Terminal, err
+	}
+	// A resumed report already has the image details, if any.
+	if next == FetchLayers {
+		if err := s.setImage(ctx); err != nil {
+			return Terminal, err
 		}
-		return FetchLayers, nil
 	}
+	return next, nil
+}
+
+// SameMode reports whether the two IndexReport modes are the same, treating
+// the empty mode of older reports as layered.
+func sameMode(a, b string) bool {
+	if a == "" {
+		a = claircore.IndexLayered
+	}
+	if b == "" {
+		b = claircore.IndexLayered
+	}
+	return a == b
+}
 
-	// we have seen this manifest before and it's been been processed with the desired scanners
-	// retrieve the existing one and transition to Terminal.
-	zlog.Info(ctx).Msg("manifest already scanned")
-	sr, ok, err := s.Store.IndexReport(ctx, s.manifest.Hash)
+// Resume reports the state an index of the manifest should start in, picking
+// up the report left behind by an earlier attempt that didn't finish.
+//
+// The controller persists every transition before the state's work begins, so
+// a report left by a process that died mid-index names the state that was in
+// progress. Work that lives entirely in the store (scan artifacts, the
+// coalesced report) isn't redone. Anything that needs layer contents on disk
+// starts over from FetchLayers, which only fetches and scans the layers that
+// aren't already recorded as scanned.
+func resume(ctx context.Context, s *Controller) (State, error) {
+	prev, ok, err := s.Store.IndexReport(ctx, s.manifest.Hash)
 	if err != nil {
-		return Terminal, fmt.Errorf("failed to retrieve manifest: %w", err)
+		return Terminal, fmt.Errorf("failed to retrieve previous report: %w", err)
 	}
-	if !ok {
-		return Terminal, fmt.Errorf("failed to retrieve manifest: %w", err)
+	// Only a report from an attempt with the same scanners and mode that
+	// stopped without recording an error can be picked up.
+	if !ok || prev.Err != "" || !sameScanners(prev.Scanners, s.report.Scanners) ||
+		!sameMode(prev.IndexMode, s.report.IndexMode) {
+		return FetchLayers, nil
+	}
+	var st State
+	st.FromString(prev.State)
+	switch st {
+	case Coalesce:
+		if s.wholeImage() {
+			// Coalescing with whiteouts or a squashed layer, and verification
+			// after it, need the layers fetched.
+			return FetchLayers, nil
+		}
+	case IndexManifest, IndexFinished:
+	default:
+		return FetchLayers, nil
 	}
-	s.report = sr
+	zlog.Info(ctx).
+		Stringer("state", st).
+		Msg("resuming interrupted index")
+	if prev.Packages == nil {
+		prev.Packages = map[string]*claircore.Package{}
+	}
+	if prev.Environments == nil {
+		prev.Environments = map[string][]*claircore.Environment{}
+	}
+	if prev.Distributions == nil {
+		prev.Distributions = map[string]*claircore.Distribution{}
+	}
+	if prev.Repositories == nil {
+		prev.Repositories = map[string]*claircore.Repository{}
+	}
+	prev.Hash = s.manifest.Hash
+	prev.Success = false
+	s.report = prev
+	return st, nil
+}
 
-	return Terminal, nil
+// SameScanners reports whether the two sorted lists of scanner records are
+// identical.
+func sameScanners(a, b []claircore.ScannerRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
@@ -36,6 +36,23 @@ func TestCheckManifest(t *testing.T) {
 				return m
 			},
 		},
+		{
+			name:          "OtherMode",
+			expectedState: FetchLayers,
+			mock: func(t *testing.T) *indexer.MockStore {
+				ctrl := gomock.NewController(t)
+				m := indexer.NewMockStore(ctrl)
+				// Indexed with every scanner, but squashed: only the first
+				// check is made, and the finished report isn't resumed.
+				m.EXPECT().ManifestScanned(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).Return(true, nil)
+				m.EXPECT().PersistManifest(gomock.Any(), gomock.Any()).Return(nil)
+				m.EXPECT().IndexReport(gomock.Any(), gomock.Any()).Times(2).Return(&claircore.IndexReport{
+					State:     IndexFinished.String(),
+					IndexMode: claircore.IndexSquashed,
+				}, true, nil)
+				return m
+			},
+		},
 		{
 			name:          "Unseen",
 			expectedState: FetchLayers,
@@ -44,6 +61,50 @@ func TestCheckManifest(t *testing.T) {
 				m := indexer.NewMockStore(ctrl)
 				m.EXPECT().ManifestScanned(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil)
 				m.EXPECT().PersistManifest(gomock.Any(), gomock.Any()).Return(nil)
+				m.EXPECT().IndexReport(gomock.Any(), gomock.Any()).Return(nil, false, nil)
+				return m
+			},
+		},
+		{
+			name:          "Interrupted",
+			expectedState: IndexManifest,
+			mock: func(t *testing.T) *indexer.MockStore {
+				ctrl := gomock.NewController(t)
+				m := indexer.NewMockStore(ctrl)
+				m.EXPECT().ManifestScanned(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil)
+				m.EXPECT().PersistManifest(gomock.Any(), gomock.Any()).Return(nil)
+				m.EXPECT().IndexReport(gomock.Any(), gomock.Any()).Return(&claircore.IndexReport{
+					State: IndexManifest.String(),
+				}, true, nil)
+				return m
+			},
+		},
+		{
+			name:          "InterruptedScan",
+			expectedState: FetchLayers,
+			mock: func(t *testing.T) *indexer.MockStore {
+				ctrl := gomock.NewController(t)
+				m := indexer.NewMockStore(ctrl)
+				m.EXPECT().ManifestScanned(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil)
+				m.EXPECT().PersistManifest(gomock.Any(), gomock.Any()).Return(nil)
+				m.EXPECT().IndexReport(gomock.Any(), gomock.Any()).Return(&claircore.IndexReport{
+					State: ScanLayers.String(),
+				}, true, nil)
+				return m
+			},
+		},
+		{
+			name:          "Errored",
+			expectedState: FetchLayers,
+			mock: func(t *testing.T) *indexer.MockStore {
+				ctrl := gomock.NewController(t)
+				m := indexer.NewMockStore(ctrl)
+				m.EXPECT().ManifestScanned(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil)
+				m.EXPECT().PersistManifest(gomock.Any(), gomock.Any()).Return(nil)
+				m.EXPECT().IndexReport(gomock.Any(), gomock.Any()).Return(&claircore.IndexReport{
+					State: IndexError.String(),
+					Err:   "oops",
+				}, true, nil)
 				return m
 			},
 		},
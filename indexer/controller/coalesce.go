@@ -3,72 +3,52 @@ package controller
 import (
 	"context"
 	"fmt"
-	"sync"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/pkg/squash"
 )
 
 // coalesce calls each ecosystem's coalescer and merges the returned IndexReports
 func coalesce(ctx context.Context, s *Controller) (State, error) {
-	cctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	mu := sync.Mutex{}
-	reports := []*claircore.IndexReport{}
-	g := errgroup.Group{}
-	// dispatch a coalescer go routine for each ecosystem
-	for _, ecosystem := range s.Ecosystems {
-		artifacts := []*indexer.LayerArtifacts{}
-		pkgScanners, _ := ecosystem.PackageScanners(cctx)
-		distScanners, _ := ecosystem.DistributionScanners(cctx)
-		repoScanners, _ := ecosystem.RepositoryScanners(cctx)
-		// pack artifacts var
-		for _, layer := range s.manifest.Layers {
-			la := &indexer.LayerArtifacts{
-				Hash: layer.Hash,
-			}
-			var vscnrs indexer.VersionedScanners
-			vscnrs.PStoVS(pkgScanners)
-			// get packages from layer
-			pkgs, err := s.Store.PackagesByLayer(cctx, layer.Hash, vscnrs)
-			if err != nil {
-				// on an early return cctx is canceled, and all inflight coalescers are canceled as well
-				return Terminal, fmt.Errorf("failed to retrieve packages for %v: %w", layer.Hash, err)
-			}
-			la.Pkgs = append(la.Pkgs, pkgs...)
-			// get distributions from layer
-			vscnrs.DStoVS(distScanners) // method allocates new vscnr underlying array, clearing old contents
-			dists, err := s.Store.DistributionsByLayer(cctx, layer.Hash, vscnrs)
+	if pr, ok := indexer.ProgressFrom(ctx); ok {
+		pr.Coalescing(ctx, s.manifest.Hash)
+	}
+	// Each ecosystem reads its own artifacts and coalesces them independently,
+	// so every ecosystem is handled in its own goroutine. The reports are
+	// collected by position so the merge is done in ecosystem order no matter
+	// which coalescer finishes first.
+	reports := make([]*claircore.IndexReport, len(s.Ecosystems))
+	var sq *squash.FS
+	if s.ApplyWhiteouts {
+		var err error
+		sq, err = squash.Open(s.manifest.Layers)
+		if err != nil {
+			return Terminal, fmt.Errorf("failed to open image filesystem: %w", err)
+		}
+		defer sq.Close()
+		ctx = indexer.WithImageFS(ctx, sq)
+	}
+	// on an early return cctx is canceled, and all inflight coalescers are canceled as well
+	g, cctx := errgroup.WithContext(ctx)
+	for i, ecosystem := range s.Ecosystems {
+		i, ecosystem := i, ecosystem
+		g.Go(func() error {
+			artifacts, err := layerArtifacts(cctx, s, ecosystem)
 			if err != nil {
-				return Terminal, fmt.Errorf("failed to retrieve distributions for %v: %w", layer.Hash, err)
+				return err
 			}
-			la.Dist = append(la.Dist, dists...)
-			// get repositories from layer
-			vscnrs.RStoVS(repoScanners)
-			repos, err := s.Store.RepositoriesByLayer(cctx, layer.Hash, vscnrs)
+			coalescer, err := ecosystem.Coalescer(cctx)
 			if err != nil {
-				return Terminal, fmt.Errorf("failed to retrieve repositories for %v: %w", layer.Hash, err)
+				return fmt.Errorf("failed to get coalescer from ecosystem: %v", err)
 			}
-			la.Repos = append(la.Repos, repos...)
-			// pack artifacts array in layer order
-			artifacts = append(artifacts, la)
-		}
-		coalescer, err := ecosystem.Coalescer(cctx)
-		if err != nil {
-			return Terminal, fmt.Errorf("failed to get coalescer from ecosystem: %v", err)
-		}
-		// dispatch coalescer
-		g.Go(func() error {
 			sr, err := coalescer.Coalesce(cctx, artifacts)
 			if err != nil {
 				return err
 			}
-
-			mu.Lock()
-			defer mu.Unlock()
-			reports = append(reports, sr)
+			reports[i] = sr
 			return nil
 		})
 	}
@@ -76,15 +56,92 @@ func coalesce(ctx context.Context, s *Controller) (State, error) {
 		return Terminal, err
 	}
 	s.report = MergeSR(s.report, reports)
+	// A squashed image's scan results already have whiteouts applied.
+	if sq != nil && s.squashed == nil {
+		applyWhiteouts(ctx, s.report, s.manifest.Layers, sq)
+	}
+	if len(s.VerifyPackages) != 0 {
+		return VerifyFiles, nil
+	}
 	return IndexManifest, nil
 }
 
+// LayerArtifacts collects the artifacts the ecosystem's scanners found in each
+// layer of the manifest, in layer order. A squashed image has the one
+// synthetic layer.
+func layerArtifacts(ctx context.Context, s *Controller, ecosystem *indexer.Ecosystem) ([]*indexer.LayerArtifacts, error) {
+	layers := s.indexLayers()
+	artifacts := make([]*indexer.LayerArtifacts, 0, len(layers))
+	pkgScanners, _ := ecosystem.PackageScanners(ctx)
+	distScanners, _ := ecosystem.DistributionScanners(ctx)
+	repoScanners, _ := ecosystem.RepositoryScanners(ctx)
+	var pkgVS, distVS, repoVS indexer.VersionedScanners
+	pkgVS.PStoVS(pkgScanners)
+	distVS.DStoVS(distScanners)
+	repoVS.RStoVS(repoScanners)
+	for _, layer := range layers {
+		la := &indexer.LayerArtifacts{
+			Hash: layer.Hash,
+		}
+		// get packages from layer
+		pkgs, err := s.Store.PackagesByLayer(ctx, layer.Hash, pkgVS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve packages for %v: %w", layer.Hash, err)
+		}
+		la.Pkgs = append(la.Pkgs, pkgs...)
+		// get distributions from layer
+		dists, err := s.Store.DistributionsByLayer(ctx, layer.Hash, distVS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve distributions for %v: %w", layer.Hash, err)
+		}
+		la.Dist = append(la.Dist, dists...)
+		// get repositories from layer
+		repos, err := s.Store.RepositoriesByLayer(ctx, layer.Hash, repoVS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve repositories for %v: %w", layer.Hash, err)
+		}
+		la.Repos = append(la.Repos, repos...)
+		// pack artifacts array in layer order
+		artifacts = append(artifacts, la)
+	}
+	return artifacts, nil
+}
+
 // MergeSR merges IndexReports.
 //
 // source is the IndexReport that the indexer is working on.
 // merge is an array IndexReports returned from coalescers
 func MergeSR(source *claircore.IndexReport, merge []*claircore.IndexReport) *claircore.IndexReport {
+	// Size the maps up front when starting from an empty report, which is
+	// always the case for the controller, rather than growing them as the
+	// reports are merged in. This is an upper bound: reports may share keys.
+	var envs, pkgs, dists, repos int
 	for _, ir := range merge {
+		if ir == nil {
+			continue
+		}
+		envs += len(ir.Environments)
+		pkgs += len(ir.Packages)
+		dists += len(ir.Distributions)
+		repos += len(ir.Repositories)
+	}
+	if len(source.Environments) == 0 {
+		source.Environments = make(map[string][]*claircore.Environment, envs)
+	}
+	if len(source.Packages) == 0 {
+		source.Packages = make(map[string]*claircore.Package, pkgs)
+	}
+	if len(source.Distributions) == 0 {
+		source.Distributions = make(map[string]*claircore.Distribution, dists)
+	}
+	if len(source.Repositories) == 0 {
+		source.Repositories = make(map[string]*claircore.Repository, repos)
+	}
+
+	for _, ir := range merge {
+		if ir == nil {
+			continue
+		}
 		for k, v := range ir.Environments {
 			source.Environments[k] = append(source.Environments[k], v...)
 		}
@@ -2,9 +2,21 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"testing"
+	"testing/fstest"
 
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
 	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/indexer/linux"
+	"github.com/quay/claircore/pkg/squash"
+	"github.com/quay/claircore/test"
+	mock_indexer "github.com/quay/claircore/test/mock/indexer"
 )
 
 // TestCoalesce confirms when no error is encountered
@@ -18,19 +30,25 @@ func TestCoalesce(t *testing.T) {
 	defer done()
 	var tt = []struct {
 		name          string
+		verify        []string
 		expectedState State
 	}{
 		{
 			name:          "Success",
 			expectedState: IndexManifest,
 		},
+		{
+			name:          "Verify",
+			verify:        []string{"bash"},
+			expectedState: VerifyFiles,
+		},
 	}
 
 	for _, table := range tt {
 		t.Run(table.name, func(t *testing.T) {
 			ctx, done := context.WithCancel(ctx)
 			defer done()
-			indexer := New(&indexer.Opts{})
+			indexer := New(&indexer.Opts{VerifyPackages: table.verify})
 
 			state, err := coalesce(ctx, indexer)
 			if err != nil {
@@ -42,3 +60,187 @@ func TestCoalesce(t *testing.T) {
 		})
 	}
 }
+
+// TestCoalesceWide runs coalesce over a wide manifest with several
+// ecosystems and compares the result to calling each coalescer in turn and
+// merging the reports in ecosystem order.
+func TestCoalesceWide(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	c, data := wideController(t, 3, 30, 1500)
+	if _, err := coalesce(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	want := New(c.Opts).report
+	for _, eco := range c.Ecosystems {
+		ir, err := linux.NewCoalescer().Coalesce(ctx, data[eco.Name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range ir.Environments {
+			want.Environments[k] = append(want.Environments[k], v...)
+		}
+		for k, v := range ir.Packages {
+			want.Packages[k] = v
+		}
+		for k, v := range ir.Distributions {
+			want.Distributions[k] = v
+		}
+		for k, v := range ir.Repositories {
+			want.Repositories[k] = v
+		}
+	}
+	if len(want.Packages) == 0 {
+		t.Fatal("no packages")
+	}
+	if got := c.report; !cmp.Equal(got, want, cmpDigest) {
+		t.Error(cmp.Diff(got, want, cmpDigest))
+	}
+}
+
+func BenchmarkCoalesce(b *testing.B) {
+	ctx := zlog.Test(context.Background(), b)
+	c, _ := wideController(b, 4, 300, 40000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.report = New(c.Opts).report
+		if _, err := coalesce(ctx, c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// WideController returns a Controller for a manifest of the given size, with
+// the given number of ecosystems. The store returns artifacts generated by
+// test.GenWideArtifacts for each ecosystem, keyed by the ecosystem's name.
+func wideController(t testing.TB, ecosystems, layers, pkgs int) (*Controller, map[string][]*indexer.LayerArtifacts) {
+	ctrl := gomock.NewController(t)
+	store := mock_indexer.NewMockStore(ctrl)
+	m := &claircore.Manifest{Hash: test.RandomSHA256Digest(t)}
+	data := make(map[string][]*indexer.LayerArtifacts, ecosystems)
+	byLayer := make(map[string]map[string]*indexer.LayerArtifacts, ecosystems)
+	var ecos []*indexer.Ecosystem
+	for i := 0; i < ecosystems; i++ {
+		name := fmt.Sprintf("ecosystem-%d", i)
+		as := test.GenWideArtifacts(t, layers, pkgs)
+		// Every ecosystem reports on the same layers.
+		if i == 0 {
+			for _, a := range as {
+				m.Layers = append(m.Layers, &claircore.Layer{Hash: a.Hash})
+			}
+		} else {
+			for j, a := range as {
+				a.Hash = m.Layers[j].Hash
+			}
+		}
+		data[name] = as
+		byLayer[name] = make(map[string]*indexer.LayerArtifacts, layers)
+		for _, a := range as {
+			byLayer[name][a.Hash.String()] = a
+		}
+
+		ps := mock_indexer.NewMockPackageScanner(ctrl)
+		ps.EXPECT().Name().Return(name).AnyTimes()
+		ds := mock_indexer.NewMockDistributionScanner(ctrl)
+		ds.EXPECT().Name().Return(name).AnyTimes()
+		rs := mock_indexer.NewMockRepositoryScanner(ctrl)
+		rs.EXPECT().Name().Return(name).AnyTimes()
+		ecos = append(ecos, &indexer.Ecosystem{
+			Name: name,
+			PackageScanners: func(context.Context) ([]indexer.PackageScanner, error) {
+				return []indexer.PackageScanner{ps}, nil
+			},
+			DistributionScanners: func(context.Context) ([]indexer.DistributionScanner, error) {
+				return []indexer.DistributionScanner{ds}, nil
+			},
+			RepositoryScanners: func(context.Context) ([]indexer.RepositoryScanner, error) {
+				return []indexer.RepositoryScanner{rs}, nil
+			},
+			Coalescer: func(context.Context) (indexer.Coalescer, error) {
+				return linux.NewCoalescer(), nil
+			},
+		})
+	}
+	lookup := func(vs indexer.VersionedScanners, d claircore.Digest) *indexer.LayerArtifacts {
+		return byLayer[vs[0].Name()][d.String()]
+	}
+	store.EXPECT().PackagesByLayer(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, d claircore.Digest, vs indexer.VersionedScanners) ([]*claircore.Package, error) {
+			return lookup(vs, d).Pkgs, nil
+		}).AnyTimes()
+	store.EXPECT().DistributionsByLayer(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, d claircore.Digest, vs indexer.VersionedScanners) ([]*claircore.Distribution, error) {
+			return lookup(vs, d).Dist, nil
+		}).AnyTimes()
+	store.EXPECT().RepositoriesByLayer(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, d claircore.Digest, vs indexer.VersionedScanners) ([]*claircore.Repository, error) {
+			return lookup(vs, d).Repos, nil
+		}).AnyTimes()
+
+	c := New(&indexer.Opts{
+		Store:      store,
+		Ecosystems: ecos,
+	})
+	c.manifest = m
+	return c, data
+}
+
+// TestApplyWhiteouts confirms packages removed or replaced in later layers are
+// dropped from the report.
+func TestApplyWhiteouts(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	ls := []*claircore.Layer{
+		{Hash: test.RandomSHA256Digest(t)},
+		{Hash: test.RandomSHA256Digest(t)},
+	}
+	sq := squash.New(
+		fstest.MapFS{
+			"var/lib/dpkg/status": &fstest.MapFile{},
+			"app/a.jar":           &fstest.MapFile{},
+			"app/b.jar":           &fstest.MapFile{},
+			"app/c.jar":           &fstest.MapFile{},
+		},
+		fstest.MapFS{
+			"var/lib/dpkg/status": &fstest.MapFile{},
+			"app/.wh.a.jar":       &fstest.MapFile{},
+			"app/b.jar":           &fstest.MapFile{},
+		},
+	)
+	env := func(db string, l int) *claircore.Environment {
+		return &claircore.Environment{PackageDB: db, IntroducedIn: ls[l].Hash}
+	}
+	report := &claircore.IndexReport{
+		Packages: map[string]*claircore.Package{
+			"bash": {ID: "bash"}, "a": {ID: "a"}, "b-old": {ID: "b-old"},
+			"b-new": {ID: "b-new"}, "c": {ID: "c"}, "shared": {ID: "shared"},
+		},
+		Environments: map[string][]*claircore.Environment{
+			// The rewritten database doesn't remove its packages.
+			"bash":  {env("var/lib/dpkg/status", 0)},
+			"a":     {env("jar:app/a.jar", 0)},
+			"b-old": {env("jar:app/b.jar", 0)},
+			"b-new": {env("jar:app/b.jar", 1)},
+			"c":     {env("jar:app/c.jar", 0)},
+			// Only the removed copy is dropped.
+			"shared": {env("maven:app/a.jar", 0), env("maven:app/c.jar", 0)},
+		},
+	}
+	applyWhiteouts(ctx, report, ls, sq)
+
+	var got []string
+	for id := range report.Packages {
+		got = append(got, id)
+	}
+	sort.Strings(got)
+	want := []string{"b-new", "bash", "c", "shared"}
+	if !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+	if got, want := len(report.Environments), len(want); got != want {
+		t.Errorf("environments: got: %d, want: %d", got, want)
+	}
+	if got, want := report.Environments["shared"][0].PackageDB, "maven:app/c.jar"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
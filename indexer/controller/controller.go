@@ -26,6 +26,14 @@ type Controller struct {
 	err error
 	// the current state of the controller
 	currentState State
+	// the layers left to scan, as worked out by fetchLayers. layers already
+	// scanned by every scanner are left out. if nil, every layer is scanned.
+	toScan []*claircore.Layer
+	// the synthetic layer holding the image's merged filesystem, when
+	// squashing. see squashLayers.
+	squashed *claircore.Layer
+	// the file backing squashed, removed once the index is done.
+	squashedFile string
 }
 
 // New constructs a controller given an Opts struct
@@ -36,6 +44,13 @@ func New(opts *indexer.Opts) *Controller {
 		Environments:  map[string][]*claircore.Environment{},
 		Distributions: map[string]*claircore.Distribution{},
 		Repositories:  map[string]*claircore.Repository{},
+		// Record the full set of scanners now: checkManifest may narrow
+		// Vscnrs to only the scanners that haven't seen the manifest.
+		Scanners:  opts.Vscnrs.Records(),
+		IndexMode: claircore.IndexLayered,
+	}
+	if opts.Squash {
+		scanRes.IndexMode = claircore.IndexSquashed
 	}
 
 	s := &Controller{
@@ -48,6 +63,21 @@ func New(opts *indexer.Opts) *Controller {
 	return s
 }
 
+// WholeImage reports whether every layer of the manifest needs to be fetched,
+// not just the ones left to scan.
+func (s *Controller) wholeImage() bool {
+	return len(s.VerifyPackages) != 0 || s.ApplyWhiteouts || s.Squash
+}
+
+// IndexLayers returns the layers whose scan results make up the report: the
+// manifest's, or the synthetic squashed layer.
+func (s *Controller) indexLayers() []*claircore.Layer {
+	if s.squashed != nil {
+		return []*claircore.Layer{s.squashed}
+	}
+	return s.manifest.Layers
+}
+
 // Index kicks off an index of a particular manifest.
 // Initial state set in constructor.
 func (s *Controller) Index(ctx context.Context, manifest *claircore.Manifest) (*claircore.IndexReport, error) {
@@ -61,8 +91,11 @@ func (s *Controller) Index(ctx context.Context, manifest *claircore.Manifest) (*
 		"component", "indexer/controller/Controller.Index",
 		"manifest", s.manifest.Hash.String())
 	defer s.Realizer.Close()
+	defer s.removeSquashed(ctx)
 	zlog.Info(ctx).Msg("starting scan")
-	return s.report, s.run(ctx)
+	// States may replace the report, so only look at it once they're done.
+	err := s.run(ctx)
+	return s.report, err
 }
 
 // Run executes each stateFunc and blocks until either an error occurs or a
@@ -98,12 +131,27 @@ func (s *Controller) run(ctx context.Context) (err error) {
 			// Continuing the loop should drop execution out of it.
 			continue
 		default:
+			failed := s.currentState
 			s.setState(IndexError)
 			zlog.Error(ctx).
 				Err(err).
 				Msg("error during scan")
 			s.report.Success = false
 			s.report.Err = err.Error()
+			s.report.Errors = append(s.report.Errors, indexError(failed, err))
+			if s.PartialReports {
+				s.partial(ctx, failed)
+			}
+		}
+		// Record the transition before the next state does any work, so that
+		// the persisted report always names the state an interrupted index
+		// needs to pick up from. See resume.
+		//
+		// This preserves current behaviour of not setting currentState to
+		// Terminal when it's returned. This should be an internal detail, but
+		// is codified in the tests (for now).
+		if next != Terminal && (err == nil || retry) {
+			s.setState(next)
 		}
 		if err := s.Store.SetIndexReport(ctx, s.report); !errors.Is(err, nil) {
 			zlog.Info(ctx).
@@ -126,13 +174,9 @@ func (s *Controller) run(ctx context.Context) (err error) {
 			// be a retry.
 			err = nil
 		}
-		// This if statement preserves current behaviour of not setting
-		// currentState to Terminal when it's returned. This should be an
-		// internal detail, but is codified in the tests (for now).
 		if next == Terminal {
 			break
 		}
-		s.setState(next)
 	}
 	if err != nil {
 		return err
@@ -10,10 +10,21 @@ import (
 func fetchLayers(ctx context.Context, s *Controller) (State, error) {
 	zlog.Info(ctx).Msg("layers fetch start")
 	defer zlog.Info(ctx).Msg("layers fetch done")
+	// Layers already scanned by every scanner, such as ones shared with
+	// manifests indexed earlier, are neither fetched nor scanned.
 	toFetch, err := reduce(ctx, s.Store, s.Vscnrs, s.manifest.Layers)
 	if err != nil {
 		return Terminal, fmt.Errorf("failed to determine layers to fetch: %w", err)
 	}
+	s.toScan = toFetch
+	zlog.Debug(ctx).
+		Int("skipped", len(s.manifest.Layers)-len(toFetch)).
+		Msg("skipping layers already scanned")
+	if s.wholeImage() {
+		// Verification, whiteouts, and squashing need the whole image, not
+		// just the new layers.
+		toFetch = s.manifest.Layers
+	}
 	zlog.Debug(ctx).
 		Int("count", len(toFetch)).
 		Msg("fetching layers")
@@ -24,5 +35,10 @@ func fetchLayers(ctx context.Context, s *Controller) (State, error) {
 		return Terminal, fmt.Errorf("failed to fetch layers: %w", err)
 	}
 	zlog.Info(ctx).Msg("layers fetch success")
+	if s.Squash {
+		if err := squashLayers(ctx, s); err != nil {
+			return Terminal, err
+		}
+	}
 	return ScanLayers, nil
 }
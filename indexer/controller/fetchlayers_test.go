@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/test"
+	indexer "github.com/quay/claircore/test/mock/indexer"
+)
+
+func TestFetchLayersSkipsScanned(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	ctrl := gomock.NewController(t)
+	m := &claircore.Manifest{Hash: test.RandomSHA256Digest(t)}
+	for i := 0; i < 3; i++ {
+		m.Layers = append(m.Layers, &claircore.Layer{Hash: test.RandomSHA256Digest(t)})
+	}
+	// The middle layer was scanned as part of another manifest.
+	scanned := m.Layers[1].Hash.String()
+	s := indexer.NewMockStore(ctrl)
+	s.EXPECT().LayerScanned(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(_ context.Context, h claircore.Digest, _ ccindexer.VersionedScanner) (bool, error) {
+			return h.String() == scanned, nil
+		})
+	want := hashes([]*claircore.Layer{m.Layers[0], m.Layers[2]})
+	r := indexer.NewMockRealizer(ctrl)
+	r.EXPECT().Realize(gomock.Any(), gomock.Any()).Times(1).DoAndReturn(
+		func(_ context.Context, ls []*claircore.Layer) error {
+			if got := hashes(ls); !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+			return nil
+		})
+	ls := indexer.NewMockLayerScanner(ctrl)
+	ls.EXPECT().Scan(gomock.Any(), m.Hash, gomock.Any()).Times(1).DoAndReturn(
+		func(_ context.Context, _ claircore.Digest, ls []*claircore.Layer) error {
+			if got := hashes(ls); !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+			return nil
+		})
+
+	c := New(&ccindexer.Opts{
+		Store:        s,
+		Realizer:     r,
+		LayerScanner: ls,
+		Vscnrs:       ccindexer.VersionedScanners{ccindexer.NewPackageScannerMock("test", "1", "package")},
+	})
+	c.manifest = m
+	st, err := fetchLayers(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st, ScanLayers; got != want {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+	if _, err := scanLayers(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Hashes returns the digests of the layers, as strings.
+func hashes(ls []*claircore.Layer) []string {
+	out := make([]string, len(ls))
+	for i, l := range ls {
+		out[i] = l.Hash.String()
+	}
+	return out
+}
+
+// TestFetchLayersSquash confirms squashing scans only a synthetic layer
+// holding the merged filesystem.
+func TestFetchLayersSquash(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	ctrl := gomock.NewController(t)
+	m := &claircore.Manifest{Hash: test.RandomSHA256Digest(t)}
+	for _, files := range [][]string{
+		{"etc/os-release", "app/a.jar"},
+		{"app/.wh.a.jar", "app/b.jar"},
+	} {
+		l := &claircore.Layer{Hash: test.RandomSHA256Digest(t)}
+		l.SetBuffer(tarball(t, files...))
+		m.Layers = append(m.Layers, l)
+	}
+	// The synthetic layer is recorded on its own: PersistManifest isn't
+	// expected, so it's never linked to the manifest.
+	ms := indexer.NewMockStore(ctrl)
+	ms.EXPECT().LayerScanned(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+	var persisted []claircore.Digest
+	s := &layerStore{
+		MockStore: ms,
+		persist: func(ds []claircore.Digest) error {
+			persisted = append(persisted, ds...)
+			return nil
+		},
+	}
+	mr := indexer.NewMockRealizer(ctrl)
+	mr.EXPECT().Realize(gomock.Any(), gomock.Any()).Times(1).Return(nil)
+	r := &tempRealizer{MockRealizer: mr, dir: t.TempDir()}
+	ls := indexer.NewMockLayerScanner(ctrl)
+	ls.EXPECT().Scan(gomock.Any(), m.Hash, gomock.Any()).Times(1).DoAndReturn(
+		func(_ context.Context, _ claircore.Digest, ls []*claircore.Layer) error {
+			if len(ls) != 1 {
+				t.Fatalf("got: %d layers, want: 1", len(ls))
+			}
+			rd, err := ls[0].Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rd.Close()
+			var got []string
+			tr := tar.NewReader(io.NewSectionReader(rd, 0, 1<<20))
+			for {
+				h, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, h.Name)
+			}
+			want := []string{"app/", "app/b.jar", "etc/", "etc/os-release"}
+			if !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+			return nil
+		})
+
+	c := New(&ccindexer.Opts{
+		Store:        s,
+		Realizer:     r,
+		LayerScanner: ls,
+		Vscnrs:       ccindexer.VersionedScanners{ccindexer.NewPackageScannerMock("test", "1", "package")},
+		Squash:       true,
+	})
+	c.manifest = m
+	if got, want := c.report.IndexMode, claircore.IndexSquashed; got != want {
+		t.Errorf("mode: got: %q, want: %q", got, want)
+	}
+	if _, err := fetchLayers(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted) != 1 || persisted[0].String() != c.squashed.Hash.String() {
+		t.Errorf("persisted layers: got: %v, want: [%v]", persisted, c.squashed.Hash)
+	}
+	f := c.squashedFile
+	if got, want := filepath.Dir(f), r.dir; got != want {
+		t.Errorf("squashed layer in %q, want %q", got, want)
+	}
+	if _, err := scanLayers(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	c.removeSquashed(ctx)
+	if _, err := os.Stat(f); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("squashed layer not removed: %v", err)
+	}
+}
+
+// LayerStore is a Store that also implements indexer.LayerPersister.
+type layerStore struct {
+	*indexer.MockStore
+	persist func([]claircore.Digest) error
+}
+
+func (s *layerStore) PersistLayers(_ context.Context, ds []claircore.Digest) error {
+	return s.persist(ds)
+}
+
+// TempRealizer is a Realizer that also implements indexer.TempFiler, creating
+// files in "dir".
+type tempRealizer struct {
+	*indexer.MockRealizer
+	dir string
+}
+
+func (r *tempRealizer) TempFile(pattern string) (*os.File, error) {
+	return os.CreateTemp(r.dir, pattern)
+}
+
+// Tarball returns a tar archive of empty files with the given names.
+func tarball(t *testing.T, names ...string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, n := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: n, Typeflag: tar.TypeReg, Mode: 0o644}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
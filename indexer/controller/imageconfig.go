@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+const (
+	// MaxConfigSize is the largest configuration blob that will be fetched.
+	maxConfigSize = 4 << 20
+	// MaxLabelBytes is the total size of label keys and values kept in the
+	// report.
+	maxLabelBytes = 16 << 10
+)
+
+// ConfigWarning is the name image configuration warnings are reported under.
+const configWarning = "image-config"
+
+// SetImage fills in the report's Image from the manifest's configuration, if
+// there is one, and warns about any disagreement between the configuration
+// and the manifest.
+//
+// Problems with the configuration are reported as warnings, not errors: the
+// configuration is informational and the index can go ahead without it.
+func (s *Controller) setImage(ctx context.Context) error {
+	cfg := s.manifest.Config
+	if cfg == nil && s.manifest.ConfigRef != nil {
+		var err error
+		cfg, err = fetchConfig(ctx, s.Client, s.manifest.ConfigRef)
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			return ctx.Err()
+		default:
+			zlog.Info(ctx).
+				Err(err).
+				Stringer("config", s.manifest.ConfigRef.Hash).
+				Msg("unable to fetch image config")
+			s.warn(0, fmt.Sprintf("unable to fetch image config %v: %v", s.manifest.ConfigRef.Hash, err))
+			return nil
+		}
+	}
+	if cfg == nil {
+		return nil
+	}
+	s.report.Image = imageMetadata(cfg)
+	if s.report.Image.LabelsTruncated {
+		s.warn(0, fmt.Sprintf("image labels truncated to %d bytes", maxLabelBytes))
+	}
+	s.checkConfig(cfg)
+	return nil
+}
+
+// Warn records a warning about the image configuration against the layer at
+// index "i". Warnings are attributed to a layer because every warning must
+// name one; a manifest without layers gets no warnings.
+func (s *Controller) warn(i int, msg string) {
+	if i >= len(s.manifest.Layers) {
+		return
+	}
+	s.report.Warnings = append(s.report.Warnings, claircore.IndexWarning{
+		Scanner: configWarning,
+		Layer:   s.manifest.Layers[i].Hash,
+		Message: msg,
+	})
+}
+
+// CheckConfig warns if the configuration doesn't look like it describes the
+// manifest's layers, as happens when the configuration for one platform of a
+// multi-platform image is submitted with another platform's layers.
+func (s *Controller) checkConfig(cfg *claircore.ImageConfig) {
+	if cfg.OS != "" {
+		for i, l := range s.manifest.Layers {
+			os := l.OS
+			if os == "" {
+				os = s.manifest.OS
+			}
+			if os != "" && os != cfg.OS {
+				s.warn(i, fmt.Sprintf("layer is for os %q, image config is for %q", os, cfg.OS))
+			}
+		}
+	}
+	if n, ct := len(cfg.RootFS.DiffIDs), len(s.manifest.Layers); n != 0 && n != ct {
+		i := n
+		if i > ct {
+			i = ct - 1
+		}
+		s.warn(i, fmt.Sprintf("image config lists %d layers, manifest has %d", n, ct))
+	}
+	if s.ValidateDiffIDs {
+		s.expectDiffIDs(cfg)
+	}
+}
+
+// ExpectDiffIDs sets each layer's ExpectedDiffID from the configuration, so
+// the Realizer checks the uncompressed contents. Layers that already have one
+// are left alone.
+func (s *Controller) expectDiffIDs(cfg *claircore.ImageConfig) {
+	if len(cfg.RootFS.DiffIDs) != len(s.manifest.Layers) {
+		return
+	}
+	for i, l := range s.manifest.Layers {
+		if l.ExpectedDiffID == nil {
+			d := cfg.RootFS.DiffIDs[i]
+			l.ExpectedDiffID = &d
+		}
+	}
+}
+
+// ImageMetadata copies the reported fields out of the configuration, keeping
+// labels up to maxLabelBytes in key order.
+func imageMetadata(cfg *claircore.ImageConfig) *claircore.ImageMetadata {
+	m := &claircore.ImageMetadata{
+		Architecture: cfg.Architecture,
+		OS:           cfg.OS,
+		Variant:      cfg.Variant,
+		Created:      cfg.Created,
+	}
+	if len(cfg.Config.Labels) == 0 {
+		return m
+	}
+	ks := make([]string, 0, len(cfg.Config.Labels))
+	for k := range cfg.Config.Labels {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	m.Labels = make(map[string]string, len(ks))
+	sz := 0
+	for _, k := range ks {
+		v := cfg.Config.Labels[k]
+		if sz+len(k)+len(v) > maxLabelBytes {
+			m.LabelsTruncated = true
+			continue
+		}
+		sz += len(k) + len(v)
+		m.Labels[k] = v
+	}
+	return m
+}
+
+var errConfigTooLarge = errors.New("image config too large")
+
+// FetchConfig fetches and verifies the configuration blob.
+func fetchConfig(ctx context.Context, c *http.Client, ref *claircore.ConfigRef) (*claircore.ImageConfig, error) {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	if ref.Hash.Checksum() == nil {
+		return nil, errors.New("config reference has no digest")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref.URI, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range ref.Headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	h := ref.Hash.Hash()
+	b, err := io.ReadAll(io.TeeReader(io.LimitReader(res.Body, maxConfigSize+1), h))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxConfigSize {
+		return nil, errConfigTooLarge
+	}
+	got, err := claircore.NewDigest(ref.Hash.Algorithm(), h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	if got.String() != ref.Hash.String() {
+		return nil, fmt.Errorf("digest mismatch: got %v", got)
+	}
+	var cfg claircore.ImageConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse image config: %w", err)
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+	mock_indexer "github.com/quay/claircore/test/mock/indexer"
+)
+
+func TestImageConfig(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	created := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	blob := []byte(`{
+	"architecture": "arm64",
+	"os": "linux",
+	"variant": "v8",
+	"created": "2023-04-05T06:07:08Z",
+	"config": {"Labels": {"vendor": "Example", "version": "1.0"}},
+	"rootfs": {"type": "layers", "diff_ids": [
+		"sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		"sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	]}
+}`)
+	sum := sha256.Sum256(blob)
+	blobDigest, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(blob)
+	}))
+	defer srv.Close()
+	var parsed claircore.ImageConfig
+	if err := json.Unmarshal(blob, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	want := &claircore.ImageMetadata{
+		Architecture: "arm64",
+		OS:           "linux",
+		Variant:      "v8",
+		Created:      &created,
+		Labels:       map[string]string{"vendor": "Example", "version": "1.0"},
+	}
+	layers := func(n int, os string) []*claircore.Layer {
+		ls := make([]*claircore.Layer, n)
+		for i := range ls {
+			ls[i] = &claircore.Layer{
+				Hash: claircore.MustParseDigest("sha256:" + strings.Repeat(string(rune('a'+i)), 64)),
+				OS:   os,
+			}
+		}
+		return ls
+	}
+	ref := func(h claircore.Digest) *claircore.ConfigRef {
+		return &claircore.ConfigRef{
+			Hash:    h,
+			URI:     srv.URL,
+			Headers: map[string][]string{"Authorization": {"Bearer token"}},
+		}
+	}
+
+	tt := []struct {
+		name     string
+		manifest claircore.Manifest
+		want     *claircore.ImageMetadata
+		warnings []string
+	}{
+		{
+			name:     "None",
+			manifest: claircore.Manifest{Layers: layers(2, "")},
+		},
+		{
+			name:     "Parsed",
+			manifest: claircore.Manifest{Layers: layers(2, ""), Config: &parsed},
+			want:     want,
+		},
+		{
+			name:     "Fetched",
+			manifest: claircore.Manifest{Layers: layers(2, "linux"), ConfigRef: ref(blobDigest)},
+			want:     want,
+		},
+		{
+			name: "BadDigest",
+			manifest: claircore.Manifest{
+				Layers:    layers(2, ""),
+				ConfigRef: ref(claircore.MustParseDigest("sha256:" + strings.Repeat("f", 64))),
+			},
+			warnings: []string{"digest mismatch"},
+		},
+		{
+			name:     "OSMismatch",
+			manifest: claircore.Manifest{Layers: layers(2, ""), OS: "windows", Config: &parsed},
+			want:     want,
+			warnings: []string{`layer is for os "windows"`, `layer is for os "windows"`},
+		},
+		{
+			name:     "LayerMismatch",
+			manifest: claircore.Manifest{Layers: layers(3, ""), Config: &parsed},
+			want:     want,
+			warnings: []string{"image config lists 2 layers, manifest has 3"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			ctrl := gomock.NewController(t)
+			store := mock_indexer.NewMockStore(ctrl)
+			store.EXPECT().ManifestScanned(gomock.Any(), gomock.Any(), gomock.Any()).Return(false, nil)
+			store.EXPECT().PersistManifest(gomock.Any(), gomock.Any()).Return(nil)
+			store.EXPECT().IndexReport(gomock.Any(), gomock.Any()).Return(nil, false, nil)
+			c := New(&indexer.Opts{Store: store, Client: srv.Client()})
+			m := tc.manifest
+			m.Hash = claircore.MustParseDigest("sha256:" + strings.Repeat("9", 64))
+			c.manifest = &m
+			c.report.Hash = m.Hash
+
+			st, err := checkManifest(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := st, FetchLayers; got != want {
+				t.Errorf("state: got: %v, want: %v", got, want)
+			}
+			if got, want := c.report.Image, tc.want; !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+			if got, want := len(c.report.Warnings), len(tc.warnings); got != want {
+				t.Fatalf("warnings: got: %v, want: %q", c.report.Warnings, tc.warnings)
+			}
+			for i, w := range c.report.Warnings {
+				if !strings.Contains(w.Message, tc.warnings[i]) {
+					t.Errorf("warning: got: %q, want: %q", w.Message, tc.warnings[i])
+				}
+			}
+
+			// The report round-trips through JSON, as it does in the store,
+			// and is unchanged by the new field when there's no config.
+			b, err := json.Marshal(c.report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.want == nil && strings.Contains(string(b), `"image"`) {
+				t.Errorf("unexpected image metadata: %s", b)
+			}
+			var got claircore.IndexReport
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got.Image, tc.want) {
+				t.Error(cmp.Diff(got.Image, tc.want))
+			}
+		})
+	}
+
+	t.Run("LabelCap", func(t *testing.T) {
+		cfg := &claircore.ImageConfig{}
+		cfg.Config.Labels = map[string]string{
+			"a": strings.Repeat("x", maxLabelBytes/2),
+			"b": strings.Repeat("x", maxLabelBytes/2),
+			"c": "small",
+		}
+		m := imageMetadata(cfg)
+		if !m.LabelsTruncated {
+			t.Error("labels not marked truncated")
+		}
+		if _, ok := m.Labels["b"]; ok {
+			t.Error("label over the cap kept")
+		}
+		if _, ok := m.Labels["c"]; !ok {
+			t.Error("label under the cap dropped")
+		}
+	})
+}
+
+func TestExpectDiffIDs(t *testing.T) {
+	d := func(c string) claircore.Digest {
+		return claircore.MustParseDigest("sha256:" + strings.Repeat(c, 64))
+	}
+	cfg := &claircore.ImageConfig{}
+	cfg.RootFS.DiffIDs = []claircore.Digest{d("0"), d("1")}
+	f := d("f")
+	tt := []struct {
+		name     string
+		validate bool
+		layers   []*claircore.Layer
+		want     []string
+	}{
+		{
+			name:   "Disabled",
+			layers: []*claircore.Layer{{Hash: d("a")}, {Hash: d("b")}},
+			want:   []string{"", ""},
+		},
+		{
+			name:     "Set",
+			validate: true,
+			layers:   []*claircore.Layer{{Hash: d("a")}, {Hash: d("b")}},
+			want:     []string{d("0").String(), d("1").String()},
+		},
+		{
+			name:     "Preset",
+			validate: true,
+			layers:   []*claircore.Layer{{Hash: d("a"), ExpectedDiffID: &f}, {Hash: d("b")}},
+			want:     []string{d("f").String(), d("1").String()},
+		},
+		{
+			name:     "CountMismatch",
+			validate: true,
+			layers:   []*claircore.Layer{{Hash: d("a")}, {Hash: d("b")}, {Hash: d("c")}},
+			want:     []string{"", "", ""},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New(&indexer.Opts{ValidateDiffIDs: tc.validate})
+			c.manifest = &claircore.Manifest{Layers: tc.layers}
+			c.checkConfig(cfg)
+			for i, l := range tc.layers {
+				var got string
+				if l.ExpectedDiffID != nil {
+					got = l.ExpectedDiffID.String()
+				}
+				if want := tc.want[i]; got != want {
+					t.Errorf("layer %d: got: %q, want: %q", i, got, want)
+				}
+			}
+		})
+	}
+}
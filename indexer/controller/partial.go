@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+// IndexError describes the error that made the index fail in the given state.
+func indexError(st State, err error) claircore.IndexError {
+	out := claircore.IndexError{
+		State: st.String(),
+		Err:   err.Error(),
+	}
+	var se *indexer.ScanError
+	if errors.As(err, &se) {
+		out.Scanner = se.Scanner.Name()
+		out.Kind = se.Scanner.Kind()
+		h := se.Layer.Hash
+		out.Layer = &h
+		out.Err = se.Err.Error()
+	}
+	return out
+}
+
+// Partial fills in the report with what was found before the index failed in
+// the given state, and marks it as partial.
+//
+// Artifacts are only in the store once layers have been scanned, so there's
+// nothing to report from a failure before then. A failure after Coalesce
+// leaves a report that's already filled in.
+func (s *Controller) partial(ctx context.Context, failed State) {
+	switch failed {
+	case ScanLayers:
+		// The layerscanner stores every scanner's results for a layer as
+		// it finishes, so coalescing picks up whatever was done.
+		if _, err := coalesce(ctx, s); err != nil {
+			zlog.Warn(ctx).
+				Err(err).
+				Msg("unable to coalesce partial report")
+			s.report.Errors = append(s.report.Errors, indexError(Coalesce, err))
+			return
+		}
+	case VerifyFiles, IndexManifest:
+	default:
+		return
+	}
+	s.report.Partial = true
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+	mock_indexer "github.com/quay/claircore/test/mock/indexer"
+)
+
+// TestPartialReport confirms a failed scan names the failing scanner and
+// layer, and reports what was found if configured to.
+func TestPartialReport(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	for _, partial := range []bool{false, true} {
+		name := "Full"
+		if partial {
+			name = "Partial"
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			ctrl := gomock.NewController(t)
+			c, _ := wideController(t, 1, 2, 10)
+			m := c.manifest
+			s := indexer.NewPackageScannerMock("broken", "1", indexer.Package)
+			boom := errors.New("boom")
+			ls := mock_indexer.NewMockLayerScanner(ctrl)
+			ls.EXPECT().Scan(gomock.Any(), m.Hash, gomock.Any()).Return(
+				&indexer.ScanError{Scanner: s, Layer: m.Layers[1], Err: boom})
+			r := mock_indexer.NewMockRealizer(ctrl)
+			r.EXPECT().Close()
+			c.Store.(*mock_indexer.MockStore).EXPECT().
+				SetIndexReport(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+			c.LayerScanner = ls
+			c.Realizer = r
+			c.PartialReports = partial
+			c.setState(ScanLayers)
+
+			ir, err := c.Index(ctx, m)
+			if !errors.Is(err, boom) {
+				t.Fatalf("got: %v, want: %v", err, boom)
+			}
+			if ir.Success || ir.State != IndexError.String() {
+				t.Errorf("unexpected report state: %q, success: %v", ir.State, ir.Success)
+			}
+			if len(ir.Errors) != 1 {
+				t.Fatalf("got: %d errors, want: 1", len(ir.Errors))
+			}
+			want := claircore.IndexError{
+				State:   ScanLayers.String(),
+				Scanner: "broken",
+				Kind:    indexer.Package,
+				Layer:   &m.Layers[1].Hash,
+				Err:     "boom",
+			}
+			if got := ir.Errors[0]; got.State != want.State || got.Scanner != want.Scanner ||
+				got.Kind != want.Kind || got.Layer == nil || got.Layer.String() != want.Layer.String() || got.Err != want.Err {
+				t.Errorf("got: %+v, want: %+v", got, want)
+			}
+			if got, want := ir.Partial, partial; got != want {
+				t.Errorf("partial: got: %v, want: %v", got, want)
+			}
+			if got, want := len(ir.Packages) != 0, partial; got != want {
+				t.Errorf("packages reported: got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,460 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/indexer/layerscanner"
+	"github.com/quay/claircore/test"
+)
+
+// TestResume kills the controller at every write it makes to the store and
+// checks that a subsequent Index picks up where the first one stopped: the
+// report is the same as an uninterrupted run and no work that made it into
+// the store is done again.
+func TestResume(t *testing.T) {
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	ctx = zlog.Test(ctx, t)
+	m := &claircore.Manifest{Hash: test.RandomSHA256Digest(t)}
+	for i := 0; i < 3; i++ {
+		m.Layers = append(m.Layers, &claircore.Layer{
+			Hash: test.RandomSHA256Digest(t),
+			OS:   "linux",
+		})
+	}
+
+	// Do an uninterrupted run to find out how many writes there are and what
+	// the result should be.
+	ref := newCrashStore()
+	want, err := resumeIndex(ctx, t, ref, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d writes, %d artifact writes", ref.writes, ref.indexCalls)
+
+	for n := 1; n <= ref.writes; n++ {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			s := newCrashStore()
+			s.crashAt = n
+			// The error isn't checked: a failure to persist the report is
+			// only logged, so the first run may well "succeed" if the last
+			// write is the one that fails.
+			resumeIndex(ctx, t, s, m)
+			if ir, ok := s.reports[m.Hash.String()]; ok {
+				t.Logf("crashed in state %q", ir.State)
+			}
+			s.revive()
+
+			got, err := resumeIndex(ctx, t, s, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Success {
+				t.Errorf("unsuccessful report: %q", got.Err)
+			}
+			if !cmp.Equal(got.Packages, want.Packages) {
+				t.Error(cmp.Diff(got.Packages, want.Packages))
+			}
+			if got, want := introduced(got), introduced(want); !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+			if !cmp.Equal(s.pkgs, ref.pkgs) {
+				t.Error(cmp.Diff(s.pkgs, ref.pkgs))
+			}
+			// The only artifacts written twice should be the ones in flight
+			// when the process died.
+			if got, want := s.indexCalls, ref.indexCalls+1; got > want {
+				t.Errorf("artifact writes: got: %d, want: <=%d", got, want)
+			}
+			ok, err := s.ManifestScanned(ctx, m.Hash, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Error("manifest not recorded as scanned")
+			}
+		})
+	}
+}
+
+// Introduced maps package IDs to the layers the report says they were
+// introduced in.
+func introduced(ir *claircore.IndexReport) map[string][]string {
+	out := make(map[string][]string)
+	for id, envs := range ir.Environments {
+		for _, e := range envs {
+			out[id] = append(out[id], e.IntroducedIn.String())
+		}
+	}
+	return out
+}
+
+// TestResumeMidScan kills a run partway through scanning layers and checks
+// that the layers it finished aren't fetched or scanned again.
+func TestResumeMidScan(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	m := &claircore.Manifest{Hash: test.RandomSHA256Digest(t)}
+	for i := 0; i < 3; i++ {
+		m.Layers = append(m.Layers, &claircore.Layer{
+			Hash: test.RandomSHA256Digest(t),
+			OS:   "linux",
+		})
+	}
+	s := newCrashStore()
+
+	// The process dies while scanning the second layer.
+	var n int
+	kill := &hookScanner{hook: func(*claircore.Layer) error {
+		n++
+		if n == 2 {
+			s.mu.Lock()
+			s.dead = true
+			s.mu.Unlock()
+			return errCrash
+		}
+		return nil
+	}}
+	if _, err := resumeIndexWith(ctx, t, s, m, kill, nopRealizer{}); err == nil {
+		t.Fatal("expected the first run to fail")
+	}
+	s.revive()
+	finished := make(map[string]bool)
+	for _, l := range m.Layers {
+		if ok, _ := s.LayerScanned(ctx, l.Hash, kill); ok {
+			finished[l.Hash.String()] = true
+		}
+	}
+	if len(finished) == 0 {
+		t.Fatal("no layers finished before the crash")
+	}
+
+	var scanned, fetched []string
+	rec := &hookScanner{hook: func(l *claircore.Layer) error {
+		scanned = append(scanned, l.Hash.String())
+		return nil
+	}}
+	r := realizerFunc(func(ls []*claircore.Layer) {
+		for _, l := range ls {
+			fetched = append(fetched, l.Hash.String())
+		}
+	})
+	ir, err := resumeIndexWith(ctx, t, s, m, rec, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ir.Success {
+		t.Errorf("unsuccessful report: %q", ir.Err)
+	}
+	if got, want := len(ir.Packages), len(m.Layers); got != want {
+		t.Errorf("packages: got: %d, want: %d", got, want)
+	}
+	if got, want := len(scanned), len(m.Layers)-len(finished); got != want {
+		t.Errorf("layers scanned: got: %d, want: %d", got, want)
+	}
+	for _, h := range scanned {
+		if finished[h] {
+			t.Errorf("finished layer %s scanned again", h)
+		}
+	}
+	for _, h := range fetched {
+		if finished[h] {
+			t.Errorf("finished layer %s fetched again", h)
+		}
+	}
+}
+
+// ResumeIndex runs a fresh Controller, as a restarted process would, against
+// the provided store.
+func resumeIndex(ctx context.Context, t *testing.T, s *crashStore, m *claircore.Manifest) (*claircore.IndexReport, error) {
+	t.Helper()
+	return resumeIndexWith(ctx, t, s, m, &resumeScanner{}, nopRealizer{})
+}
+
+// ResumeIndexWith is resumeIndex with the provided scanner and Realizer.
+func resumeIndexWith(ctx context.Context, t *testing.T, s *crashStore, m *claircore.Manifest, scnr indexer.PackageScanner, r indexer.Realizer) (*claircore.IndexReport, error) {
+	t.Helper()
+	opts := &indexer.Opts{
+		Store:    s,
+		Realizer: r,
+		Ecosystems: []*indexer.Ecosystem{{
+			Name: "resume",
+			PackageScanners: func(context.Context) ([]indexer.PackageScanner, error) {
+				return []indexer.PackageScanner{scnr}, nil
+			},
+			DistributionScanners: func(context.Context) ([]indexer.DistributionScanner, error) { return nil, nil },
+			RepositoryScanners:   func(context.Context) ([]indexer.RepositoryScanner, error) { return nil, nil },
+			Coalescer: func(context.Context) (indexer.Coalescer, error) {
+				return resumeCoalescer{}, nil
+			},
+		}},
+		Vscnrs: indexer.VersionedScanners{scnr},
+	}
+	// Scan one layer at a time, so that a single artifact write is in flight
+	// when the store "crashes".
+	ls, err := layerscanner.New(ctx, 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.LayerScanner = ls
+	// Copy the layers, as the controller fills in details on them.
+	cm := *m
+	cm.Layers = make([]*claircore.Layer, len(m.Layers))
+	for i, l := range m.Layers {
+		cl := *l
+		cm.Layers[i] = &cl
+	}
+	return New(opts).Index(ctx, &cm)
+}
+
+var errCrash = errors.New("process died")
+
+// CrashStore is an in-memory indexer.Store that fails its nth write, and every
+// write after that, as if the process had died.
+//
+// Writes are idempotent in the same way as the database implementation.
+type crashStore struct {
+	indexer.Store
+
+	mu         sync.Mutex
+	crashAt    int
+	writes     int
+	dead       bool
+	indexCalls int
+
+	scanned  map[string]bool
+	pkgs     map[string][]string
+	reports  map[string]*claircore.IndexReport
+	finished map[string]bool
+	indexed  map[string]bool
+}
+
+func newCrashStore() *crashStore {
+	return &crashStore{
+		scanned:  make(map[string]bool),
+		pkgs:     make(map[string][]string),
+		reports:  make(map[string]*claircore.IndexReport),
+		finished: make(map[string]bool),
+		indexed:  make(map[string]bool),
+	}
+}
+
+// Revive lets writes succeed again.
+func (s *crashStore) revive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dead = false
+	s.crashAt = 0
+}
+
+// Write must be called with the lock held.
+func (s *crashStore) write() error {
+	if s.dead {
+		return errCrash
+	}
+	s.writes++
+	if s.writes == s.crashAt {
+		s.dead = true
+		return errCrash
+	}
+	return nil
+}
+
+func (s *crashStore) PersistManifest(_ context.Context, _ claircore.Manifest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write()
+}
+
+func (s *crashStore) ManifestScanned(_ context.Context, hash claircore.Digest, _ indexer.VersionedScanners) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finished[hash.String()], nil
+}
+
+func (s *crashStore) LayerScanned(_ context.Context, hash claircore.Digest, scnr indexer.VersionedScanner) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scanned[hash.String()+scnr.Name()], nil
+}
+
+func (s *crashStore) SetLayerScanned(_ context.Context, hash claircore.Digest, scnr indexer.VersionedScanner) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(); err != nil {
+		return err
+	}
+	s.scanned[hash.String()+scnr.Name()] = true
+	return nil
+}
+
+func (s *crashStore) IndexPackages(_ context.Context, pkgs []*claircore.Package, l *claircore.Layer, _ indexer.VersionedScanner) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(); err != nil {
+		return err
+	}
+	s.indexCalls++
+	k := l.Hash.String()
+	seen := make(map[string]bool)
+	for _, n := range s.pkgs[k] {
+		seen[n] = true
+	}
+	for _, p := range pkgs {
+		if !seen[p.Name] {
+			s.pkgs[k] = append(s.pkgs[k], p.Name)
+		}
+	}
+	sort.Strings(s.pkgs[k])
+	return nil
+}
+
+func (s *crashStore) PackagesByLayer(_ context.Context, hash claircore.Digest, _ indexer.VersionedScanners) ([]*claircore.Package, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*claircore.Package
+	for _, n := range s.pkgs[hash.String()] {
+		out = append(out, &claircore.Package{ID: n, Name: n, Version: "1"})
+	}
+	return out, nil
+}
+
+func (s *crashStore) DistributionsByLayer(context.Context, claircore.Digest, indexer.VersionedScanners) ([]*claircore.Distribution, error) {
+	return nil, nil
+}
+
+func (s *crashStore) RepositoriesByLayer(context.Context, claircore.Digest, indexer.VersionedScanners) ([]*claircore.Repository, error) {
+	return nil, nil
+}
+
+func (s *crashStore) IndexManifest(_ context.Context, ir *claircore.IndexReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(); err != nil {
+		return err
+	}
+	s.indexed[ir.Hash.String()] = true
+	return nil
+}
+
+func (s *crashStore) SetIndexReport(_ context.Context, ir *claircore.IndexReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(); err != nil {
+		return err
+	}
+	return s.save(ir)
+}
+
+// Save stores a copy of the report. It must be called with the lock held.
+func (s *crashStore) save(ir *claircore.IndexReport) error {
+	// Round-trip the report, as the database would.
+	b, err := json.Marshal(ir)
+	if err != nil {
+		return err
+	}
+	var stored claircore.IndexReport
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return err
+	}
+	s.reports[ir.Hash.String()] = &stored
+	return nil
+}
+
+func (s *crashStore) SetIndexFinished(_ context.Context, ir *claircore.IndexReport, _ indexer.VersionedScanners) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write(); err != nil {
+		return err
+	}
+	if !s.indexed[ir.Hash.String()] {
+		return fmt.Errorf("manifest %v finished without being indexed", ir.Hash)
+	}
+	s.finished[ir.Hash.String()] = true
+	return s.save(ir)
+}
+
+func (s *crashStore) IndexReport(_ context.Context, hash claircore.Digest) (*claircore.IndexReport, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ir, ok := s.reports[hash.String()]
+	if !ok {
+		return nil, false, nil
+	}
+	cp := *ir
+	return &cp, true, nil
+}
+
+// ResumeScanner reports one package per layer.
+type resumeScanner struct{}
+
+var _ indexer.PackageScanner = (*resumeScanner)(nil)
+
+func (*resumeScanner) Name() string    { return "resume" }
+func (*resumeScanner) Version() string { return "1" }
+func (*resumeScanner) Kind() string    { return "package" }
+func (*resumeScanner) Scan(_ context.Context, l *claircore.Layer) ([]*claircore.Package, error) {
+	return []*claircore.Package{{Name: l.Hash.String(), Version: "1"}}, nil
+}
+
+// HookScanner is a resumeScanner that calls "hook" before scanning a layer,
+// failing if it does.
+type hookScanner struct {
+	resumeScanner
+	hook func(*claircore.Layer) error
+}
+
+func (s *hookScanner) Scan(ctx context.Context, l *claircore.Layer) ([]*claircore.Package, error) {
+	if err := s.hook(l); err != nil {
+		return nil, err
+	}
+	return s.resumeScanner.Scan(ctx, l)
+}
+
+// ResumeCoalescer reports every package as introduced in the layer it's found
+// in.
+type resumeCoalescer struct{}
+
+func (resumeCoalescer) Coalesce(_ context.Context, ls []*indexer.LayerArtifacts) (*claircore.IndexReport, error) {
+	ir := &claircore.IndexReport{
+		Packages:     map[string]*claircore.Package{},
+		Environments: map[string][]*claircore.Environment{},
+	}
+	for _, la := range ls {
+		for _, p := range la.Pkgs {
+			ir.Packages[p.ID] = p
+			ir.Environments[p.ID] = append(ir.Environments[p.ID], &claircore.Environment{
+				PackageDB:    "resume",
+				IntroducedIn: la.Hash,
+			})
+		}
+	}
+	return ir, nil
+}
+
+// NopRealizer leaves layers as they are.
+type nopRealizer struct{}
+
+func (nopRealizer) Realize(context.Context, []*claircore.Layer) error { return nil }
+func (nopRealizer) Close() error                                      { return nil }
+
+// RealizerFunc is a Realizer that reports the layers it's asked for.
+type realizerFunc func([]*claircore.Layer)
+
+func (f realizerFunc) Realize(_ context.Context, ls []*claircore.Layer) error {
+	f(ls)
+	return nil
+}
+func (realizerFunc) Close() error { return nil }
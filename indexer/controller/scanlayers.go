@@ -3,8 +3,12 @@ package controller
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
 )
 
 // scanLayers will run all scanner types against all layers if deemed necessary
@@ -12,10 +16,81 @@ import (
 func scanLayers(ctx context.Context, c *Controller) (State, error) {
 	zlog.Info(ctx).Msg("layers scan start")
 	defer zlog.Info(ctx).Msg("layers scan done")
-	err := c.LayerScanner.Scan(ctx, c.manifest.Hash, c.manifest.Layers)
+	// Layers are scanned concurrently, so guard the report.
+	var mu sync.Mutex
+	// Seen records the (layer, scanner) pairs that reported warnings during
+	// this scan.
+	seen := make(map[string]struct{})
+	wctx := indexer.WithWarningFunc(ctx, func(w claircore.IndexWarning) {
+		mu.Lock()
+		defer mu.Unlock()
+		c.report.Warnings = append(c.report.Warnings, w)
+		seen[warningKey(w)] = struct{}{}
+	})
+	wctx = indexer.WithScannerErrorFunc(wctx, func(e claircore.ScannerError) {
+		mu.Lock()
+		defer mu.Unlock()
+		c.report.ScannerErrors = append(c.report.ScannerErrors, e)
+	})
+	wctx = indexer.WithFilteredFunc(wctx, func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		c.report.FilteredPackages += n
+	})
+	// Layers without an OS hint of their own get the manifest's. The hint
+	// goes in the Context: the Layers belong to the caller.
+	if os := c.manifest.OS; os != "" {
+		wctx = indexer.WithOSHint(wctx, os)
+	}
+	layers := c.toScan
+	if layers == nil {
+		layers = c.indexLayers()
+	}
+	err := c.LayerScanner.Scan(wctx, c.manifest.Hash, layers)
 	if err != nil {
 		return Terminal, fmt.Errorf("failed to scan all layer contents: %w", err)
 	}
-	zlog.Debug(ctx).Msg("layers scan ok")
+	if err := loadWarnings(ctx, c, seen); err != nil {
+		return Terminal, err
+	}
+	mu.Lock()
+	n, filtered := len(c.report.Warnings), c.report.FilteredPackages
+	mu.Unlock()
+	zlog.Debug(ctx).
+		Int("warnings", n).
+		Int("filtered", filtered).
+		Msg("layers scan ok")
 	return Coalesce, nil
 }
+
+// LoadWarnings adds the warnings recorded when the manifest's layers were
+// scanned to the report, if the Store keeps them. Layers scanned for another
+// manifest weren't scanned again, so their warnings weren't reported live.
+// Pairs in "seen" were, and are left alone.
+func loadWarnings(ctx context.Context, c *Controller, seen map[string]struct{}) error {
+	wst, ok := c.Store.(indexer.WarningStore)
+	if !ok {
+		return nil
+	}
+	ls := c.indexLayers()
+	ds := make([]claircore.Digest, len(ls))
+	for i, l := range ls {
+		ds[i] = l.Hash
+	}
+	ws, err := wst.LayerWarnings(ctx, ds, c.Vscnrs)
+	if err != nil {
+		return fmt.Errorf("failed to load layer warnings: %w", err)
+	}
+	for _, w := range ws {
+		if _, ok := seen[warningKey(w)]; ok {
+			continue
+		}
+		c.report.Warnings = append(c.report.Warnings, w)
+	}
+	return nil
+}
+
+// WarningKey identifies the (layer, scanner) pair a warning came from.
+func warningKey(w claircore.IndexWarning) string {
+	return w.Layer.String() + "/" + w.Scanner
+}
@@ -2,14 +2,23 @@ package controller
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
 	"github.com/quay/zlog"
 
+	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
 	indexer "github.com/quay/claircore/test/mock/indexer"
 )
 
+var cmpDigest = cmp.Comparer(func(a, b claircore.Digest) bool { return a.String() == b.String() })
+
+// LayerDigest is the digest of the layer warnings are attributed to.
+var layerDigest = claircore.MustParseDigest(`sha256:` + strings.Repeat(`a`, 64))
+
 func TestScanLayers(t *testing.T) {
 	ctx, done := context.WithCancel(context.Background())
 	defer done()
@@ -17,6 +26,9 @@ func TestScanLayers(t *testing.T) {
 		mock          func(t *testing.T) (indexer.LayerScanner, indexer.Store)
 		name          string
 		expectedState State
+		warnings      []claircore.IndexWarning
+		os            string
+		layers        []*claircore.Layer
 	}{
 		{
 			name:          "Success",
@@ -31,6 +43,56 @@ func TestScanLayers(t *testing.T) {
 				return ls, s
 			},
 		},
+		{
+			name:          "Warnings",
+			expectedState: Coalesce,
+			warnings: []claircore.IndexWarning{
+				{Scanner: "test", Layer: layerDigest, Path: "var/lib/dpkg/status", Message: "bad stanza"},
+			},
+			mock: func(t *testing.T) (indexer.LayerScanner, indexer.Store) {
+				ctrl := gomock.NewController(t)
+				ls := indexer.NewMockLayerScanner(ctrl)
+				s := indexer.NewMockStore(ctrl)
+
+				ls.EXPECT().Scan(gomock.Any(), gomock.Any(), gomock.Any()).MaxTimes(1).MinTimes(1).DoAndReturn(
+					func(ctx context.Context, _ claircore.Digest, _ []*claircore.Layer) error {
+						scnr := ccindexer.NewPackageScannerMock("test", "1", "package")
+						ctx = ccindexer.WithScanner(ctx, scnr, &claircore.Layer{Hash: layerDigest})
+						ccindexer.Warn(ctx, "var/lib/dpkg/status", "bad %s", "stanza")
+						return nil
+					})
+				return ls, s
+			},
+		},
+		{
+			name:          "OSHint",
+			expectedState: Coalesce,
+			os:            "windows",
+			layers:        []*claircore.Layer{{Hash: layerDigest}},
+			mock: func(t *testing.T) (indexer.LayerScanner, indexer.Store) {
+				ctrl := gomock.NewController(t)
+				ls := indexer.NewMockLayerScanner(ctrl)
+				s := indexer.NewMockStore(ctrl)
+
+				ls.EXPECT().Scan(gomock.Any(), gomock.Any(), gomock.Any()).MaxTimes(1).MinTimes(1).DoAndReturn(
+					func(ctx context.Context, _ claircore.Digest, ls []*claircore.Layer) error {
+						if len(ls) == 0 {
+							t.Error("no layers")
+						}
+						for _, l := range ls {
+							if got, want := ccindexer.LayerOS(ctx, l), "windows"; got != want {
+								t.Errorf("layer os: got: %q, want: %q", got, want)
+							}
+							// The caller's Layer is left alone.
+							if l.OS != "" {
+								t.Errorf("layer os set: %q", l.OS)
+							}
+						}
+						return nil
+					})
+				return ls, s
+			},
+		},
 	}
 
 	for _, table := range tt {
@@ -43,6 +105,7 @@ func TestScanLayers(t *testing.T) {
 				LayerScanner: ls,
 				Store:        s,
 			})
+			scnr.manifest = &claircore.Manifest{OS: table.os, Layers: table.layers}
 
 			state, err := scanLayers(ctx, scnr)
 			if err != nil {
@@ -51,6 +114,9 @@ func TestScanLayers(t *testing.T) {
 			if got, want := state, table.expectedState; got != want {
 				t.Errorf("got: %v, want: %v", got, want)
 			}
+			if got, want := scnr.report.Warnings, table.warnings; !cmp.Equal(got, want, cmpDigest) {
+				t.Error(cmp.Diff(got, want, cmpDigest))
+			}
 		})
 	}
 }
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/pkg/squash"
+)
+
+// SquashLayers writes the merged filesystem of the manifest's fetched layers
+// to a temporary file and makes it the only layer to scan.
+//
+// The synthetic layer is named by the digest of the tar stream. It's recorded
+// in the store so its scan results can be stored and read back like any other
+// layer's, but isn't linked to the manifest: it's not one of the image's
+// layers, and lookups of a manifest's layers mustn't find it.
+//
+// The file is created by the Realizer if it implements indexer.TempFiler, so
+// it lives with the fetched layers.
+func squashLayers(ctx context.Context, s *Controller) error {
+	sq, err := squash.Open(s.manifest.Layers)
+	if err != nil {
+		return fmt.Errorf("failed to open image filesystem: %w", err)
+	}
+	defer sq.Close()
+	lp, ok := s.Store.(indexer.LayerPersister)
+	if !ok {
+		return errors.New("store unable to record squashed layer")
+	}
+	var f *os.File
+	if tf, ok := s.Realizer.(indexer.TempFiler); ok {
+		f, err = tf.TempFile("squashed.*.tar")
+	} else {
+		f, err = os.CreateTemp("", "squashed.*.tar")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create squashed layer: %w", err)
+	}
+	name := f.Name()
+	h := sha256.New()
+	err = sq.WriteTar(io.MultiWriter(f, h))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name)
+		return fmt.Errorf("failed to write squashed layer: %w", err)
+	}
+	s.squashedFile = name
+	d, err := claircore.NewDigest("sha256", h.Sum(nil))
+	if err != nil {
+		return err
+	}
+	l := &claircore.Layer{Hash: d}
+	l.SetLocal(name)
+	l.SetVerified(d)
+	l.SetDiffID(d)
+	s.squashed = l
+
+	if err := lp.PersistLayers(ctx, []claircore.Digest{d}); err != nil {
+		return fmt.Errorf("failed to persist squashed layer: %w", err)
+	}
+	s.toScan, err = reduce(ctx, s.Store, s.Vscnrs, s.indexLayers())
+	if err != nil {
+		return fmt.Errorf("failed to determine layers to scan: %w", err)
+	}
+	zlog.Debug(ctx).
+		Stringer("layer", d).
+		Msg("squashed image")
+	return nil
+}
+
+// RemoveSquashed removes the file backing the squashed layer, if any.
+func (s *Controller) removeSquashed(ctx context.Context) {
+	if s.squashedFile == "" {
+		return
+	}
+	if err := os.Remove(s.squashedFile); err != nil {
+		zlog.Warn(ctx).
+			Err(err).
+			Str("file", s.squashedFile).
+			Msg("unable to remove squashed layer")
+	}
+	s.squashedFile = ""
+}
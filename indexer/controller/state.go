@@ -15,7 +15,8 @@ const (
 	Terminal State = iota
 	// CheckManifest determines if the manifest should be scanned.
 	// if no Terminal is returned and we return the existing IndexReport.
-	// Transitions: FetchLayers, Terminal
+	// An index that was interrupted resumes from the state it was in.
+	// Transitions: FetchLayers, Coalesce, IndexManifest, IndexFinished, Terminal
 	CheckManifest
 	// FetchLayers retrieves all the layers in a manifest and stacks them the same obtain the file image contents.
 	// creates the "image" layer
@@ -25,7 +26,7 @@ const (
 	// Transitions: BuildLayerResult
 	ScanLayers
 	// Coalesce runs each provided ecosystem's coalescer and merges their scan results
-	// Transitions: IndexManifest
+	// Transitions: VerifyFiles, IndexManifest
 	Coalesce
 	// IndexManifest evaluates a coalesced IndexReport and writes it's contents
 	// to the the persistence layer where it maybe searched.
@@ -39,6 +40,10 @@ const (
 	// to the caller of Scan()
 	// Transitions: Terminal
 	IndexFinished
+	// VerifyFiles checks the files of the configured packages against their
+	// package databases and records any modifications.
+	// Transitions: IndexManifest
+	VerifyFiles
 )
 
 func (ss State) String() string {
@@ -51,6 +56,7 @@ func (ss State) String() string {
 		"IndexManifest",
 		"IndexError",
 		"IndexFinished",
+		"VerifyFiles",
 	}
 	return names[ss]
 }
@@ -73,6 +79,8 @@ func (ss *State) FromString(state string) {
 		*ss = IndexError
 	case "IndexFinished":
 		*ss = IndexFinished
+	case "VerifyFiles":
+		*ss = VerifyFiles
 	}
 }
 
@@ -103,4 +111,5 @@ var stateToStateFunc = map[State]stateFunc{
 	Coalesce:      coalesce,
 	IndexManifest: indexManifest,
 	IndexFinished: indexFinished,
+	VerifyFiles:   verifyFiles,
 }
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore/internal/pkgverify"
+)
+
+// verifyFiles checks the files owned by the configured packages against the
+// package databases and records any that were modified.
+func verifyFiles(ctx context.Context, s *Controller) (State, error) {
+	zlog.Info(ctx).Msg("package file verification start")
+	defer zlog.Info(ctx).Msg("package file verification done")
+	mfs, err := pkgverify.Verify(ctx, s.manifest.Layers, s.VerifyPackages)
+	if err != nil {
+		return Terminal, fmt.Errorf("failed to verify package files: %w", err)
+	}
+	s.report.ModifiedFiles = mfs
+	if len(mfs) != 0 {
+		zlog.Info(ctx).
+			Int("count", len(mfs)).
+			Msg("found modified package files")
+	}
+	return IndexManifest, nil
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/pkg/squash"
+)
+
+// ApplyWhiteouts drops the environments of packages whose files were removed
+// or replaced in a layer above the one that introduced them, and then the
+// packages left with no environments.
+//
+// Packages from a shared package database, like dpkg's status file, are only
+// dropped if the database was removed: a later layer rewriting the database
+// doesn't remove the packages still listed in it, and their coalescers
+// already use the topmost copy.
+func applyWhiteouts(ctx context.Context, report *claircore.IndexReport, layers []*claircore.Layer, sq *squash.FS) {
+	idx := make(map[string]int, len(layers))
+	for i, l := range layers {
+		idx[l.Hash.String()] = i
+	}
+	var n int
+	for id, envs := range report.Environments {
+		keep := envs[:0]
+		for _, env := range envs {
+			if env == nil || !whitedOut(sq, idx, env) {
+				keep = append(keep, env)
+			}
+		}
+		if len(keep) != 0 {
+			report.Environments[id] = keep
+			continue
+		}
+		delete(report.Environments, id)
+		delete(report.Packages, id)
+		n++
+	}
+	if n != 0 {
+		zlog.Debug(ctx).
+			Int("count", n).
+			Msg("removed packages deleted in later layers")
+	}
+}
+
+// WhitedOut reports whether the environment's file was removed, or replaced
+// if it's one of the per-package kinds, above the layer that introduced it.
+func whitedOut(sq *squash.FS, idx map[string]int, env *claircore.Environment) bool {
+	pv := env.Provenance()
+	at, ok := idx[pv.Layer.String()]
+	if !ok || pv.Path == "" {
+		return false
+	}
+	p := path.Clean(strings.TrimPrefix(pv.Path, "/"))
+	i, st := sq.Lookup(p)
+	if i <= at {
+		return false
+	}
+	switch st {
+	case squash.Removed:
+		return true
+	case squash.Present:
+		return perPackage[pv.Kind]
+	}
+	return false
+}
+
+// PerPackage is the set of package database kinds that name a file holding
+// a single package, so that replacing the file replaces the package. Python
+// packages name the shared site-packages directory, so aren't included.
+var perPackage = map[string]bool{
+	"jar":   true,
+	"maven": true,
+	"file":  true,
+}
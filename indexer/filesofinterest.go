@@ -0,0 +1,96 @@
+package indexer
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FileInterest is implemented by scanners that only read a known set of files
+// from a layer, such as a package database.
+//
+// A Realizer may use this to fetch only part of a layer: the named files, plus
+// the layer's directories and links. A scanner that implements this must not
+// depend on any other file's presence.
+type FileInterest interface {
+	// FilesOfInterest returns patterns in the syntax of path.Match. A pattern
+	// containing a slash is matched against a file's whole path relative to
+	// the root of the layer, like "etc/os-release". One without is matched
+	// against the file's base name, so "status" matches a file named
+	// "status" in any directory.
+	FilesOfInterest() []string
+}
+
+// MatchFile reports whether the file at path "name", relative to the root of
+// the layer, matches any of the patterns as described by FileInterest.
+func MatchFile(pats []string, name string) bool {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	base := path.Base(name)
+	for _, pat := range pats {
+		tgt := name
+		if !strings.Contains(pat, "/") {
+			tgt = base
+		}
+		if ok, _ := path.Match(pat, tgt); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ScannersFiles returns the union of the files of interest of all the
+// provided scanners. It reports false if any scanner doesn't implement
+// FileInterest, in which case whole layers are needed.
+func ScannersFiles(ps []PackageScanner, ds []DistributionScanner, rs []RepositoryScanner) ([]string, bool) {
+	seen := make(map[string]struct{})
+	add := func(s VersionedScanner) bool {
+		// Package filters and configuration versions don't change what's
+		// read.
+		fi, ok := unwrapScanner(s).(FileInterest)
+		if !ok {
+			return false
+		}
+		for _, p := range fi.FilesOfInterest() {
+			seen[p] = struct{}{}
+		}
+		return true
+	}
+	for _, s := range ps {
+		if !add(s) {
+			return nil, false
+		}
+	}
+	for _, s := range ds {
+		if !add(s) {
+			return nil, false
+		}
+	}
+	for _, s := range rs {
+		if !add(s) {
+			return nil, false
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for p := range seen {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out, true
+}
+
+type filesKey struct{}
+
+// WithFilesOfInterest returns a Context telling Realizers that only files
+// matching the patterns, as described by FileInterest, will be read from the
+// layers they're asked for.
+func WithFilesOfInterest(ctx context.Context, pats []string) context.Context {
+	return context.WithValue(ctx, filesKey{}, pats)
+}
+
+// FilesOfInterest reports the patterns set by WithFilesOfInterest. If it
+// reports false, whole layers must be realized.
+func FilesOfInterest(ctx context.Context) ([]string, bool) {
+	pats, ok := ctx.Value(filesKey{}).([]string)
+	return pats, ok
+}
@@ -0,0 +1,25 @@
+package indexer
+
+import (
+	"context"
+	"io/fs"
+)
+
+type imageFSKey struct{}
+
+// WithImageFS returns a Context that carries the merged filesystem of the
+// image being indexed, with whiteouts applied.
+func WithImageFS(ctx context.Context, sys fs.FS) context.Context {
+	return context.WithValue(ctx, imageFSKey{}, sys)
+}
+
+// ImageFS reports the merged filesystem of the image being indexed, if any.
+//
+// It's only available to Coalescers, and only when the indexer is configured
+// to apply whiteouts, as that has every layer of the image fetched. Scanners
+// never see it: a layer's scan results are shared by every image containing
+// the layer, so they can't depend on what's in other layers.
+func ImageFS(ctx context.Context) (fs.FS, bool) {
+	sys, ok := ctx.Value(imageFSKey{}).(fs.FS)
+	return sys, ok && sys != nil
+}
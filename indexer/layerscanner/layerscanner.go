@@ -2,8 +2,11 @@ package layerscanner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime"
+	"sync"
+	"time"
 
 	"github.com/quay/zlog"
 	"golang.org/x/sync/errgroup"
@@ -19,6 +22,10 @@ type layerScanner struct {
 
 	// Maximum allowed in-flight scanners per Scan call
 	inflight int64
+	// How long a scanner may take on a layer, if non-zero.
+	timeout time.Duration
+	// What a scanner failing on a layer does to the Scan call.
+	failure indexer.ScannerFailurePolicy
 
 	// Pre-constructed and configured scanners.
 	ps []indexer.PackageScanner
@@ -76,6 +83,8 @@ func New(ctx context.Context, concurrent int, opts *indexer.Opts) (indexer.Layer
 	return &layerScanner{
 		store:    opts.Store,
 		inflight: int64(concurrent),
+		timeout:  opts.ScannerTimeout,
+		failure:  opts.ScannerFailure,
 		ps:       ps,
 		ds:       ds,
 		rs:       rs,
@@ -138,49 +147,159 @@ func configAndFilter(ctx context.Context, opts *indexer.Opts, s indexer.Versione
 // Scan performs a concurrency controlled scan of each layer by each configured
 // scanner, indexing the results on successful completion.
 //
-// Scan will launch all layer scan goroutines immediately and then only allow
-// the configured limit to proceed.
+// Scan runs a pool of workers, sized by the configured limit, over every
+// (layer, scanner) pair, so independent layers are scanned at once. A
+// SerialScanner is only ever running against one layer at a time.
 //
 // The provided Context controls cancellation for all scanners. The first error
-// reported halts all work and is returned from Scan.
+// reported halts all work and is returned from Scan, unless it's a scanner
+// failing and the failure policy is to skip the scanner.
 func (ls *layerScanner) Scan(ctx context.Context, manifest claircore.Digest, layers []*claircore.Layer) error {
 	ctx = zlog.ContextWithValues(ctx,
 		"component", "datastore/layerscannner/layerScanner.Scan",
 		"manifest", manifest.String())
 
-	sem := semaphore.NewWeighted(ls.inflight)
-	g, ctx := errgroup.WithContext(ctx)
-	// Launch is a closure to capture the loop variables and then call the
-	// scanLayer method.
-	launch := func(l *claircore.Layer, s indexer.VersionedScanner) func() error {
-		return func() error {
-			if err := sem.Acquire(ctx, 1); err != nil {
-				return err
-			}
-			defer sem.Release(1)
-			return ls.scanLayer(ctx, l, s)
-		}
-	}
+	var jobs []job
+	serial := make(map[string]*semaphore.Weighted)
 	dedupe := make(map[string]struct{})
 	for _, l := range layers {
 		if _, ok := dedupe[l.Hash.String()]; ok {
 			continue
 		}
 		dedupe[l.Hash.String()] = struct{}{}
+		layerOS := indexer.LayerOS(ctx, l)
+		add := func(s indexer.VersionedScanner) {
+			if ls.skip(ctx, l, layerOS, s) {
+				return
+			}
+			j := job{l: l, s: s}
+			if indexer.IsSerial(s) {
+				k := s.Kind() + "/" + s.Name()
+				if serial[k] == nil {
+					serial[k] = semaphore.NewWeighted(1)
+				}
+				j.serial = serial[k]
+			}
+			jobs = append(jobs, j)
+		}
 		for _, s := range ls.ps {
-			g.Go(launch(l, s))
+			add(s)
 		}
 		for _, s := range ls.ds {
-			g.Go(launch(l, s))
+			add(s)
 		}
 		for _, s := range ls.rs {
-			g.Go(launch(l, s))
+			add(s)
 		}
 	}
 
+	g, ctx := errgroup.WithContext(ctx)
+	ch := make(chan job)
+	g.Go(func() error {
+		defer close(ch)
+		for _, j := range jobs {
+			select {
+			case ch <- j:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	workers := int(ls.inflight)
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	for i := 0; i < workers; i++ {
+		g.Go(func() error {
+			for j := range ch {
+				if err := ls.run(ctx, j); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
 	return g.Wait()
 }
 
+// Job is a (layer, scanner) pair to scan.
+type job struct {
+	l *claircore.Layer
+	s indexer.VersionedScanner
+	// Serial is held while scanning, if the scanner is a SerialScanner.
+	serial *semaphore.Weighted
+}
+
+// Run scans the job's layer with its scanner, applying the failure policy to
+// the result.
+func (ls *layerScanner) run(ctx context.Context, j job) error {
+	l, s := j.l, j.s
+	if j.serial != nil {
+		if err := j.serial.Acquire(ctx, 1); err != nil {
+			return err
+		}
+		defer j.serial.Release(1)
+	}
+	release, err := indexer.AcquireLayerSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	err = ls.scanLayer(ctx, l, s)
+	if pr, ok := indexer.ProgressFrom(ctx); ok {
+		pr.ScannerDone(ctx, l.Hash, s, err)
+	}
+	// Errors after the Context is done are fallout from whatever
+	// finished it, not the scanner's doing.
+	var se *scanError
+	if !errors.As(err, &se) || ctx.Err() != nil {
+		return err
+	}
+	indexer.ScannerFailed(ctx, s, l, se.err)
+	if ls.failure != indexer.SkipScanner {
+		return &indexer.ScanError{Scanner: s, Layer: l, Err: se.err}
+	}
+	zlog.Warn(ctx).
+		Str("layer", l.Hash.String()).
+		Str("scanner", s.Name()).
+		Err(se.err).
+		Msg("skipping failed scanner")
+	// The warning is kept with the layer's artifacts like any other, and is
+	// replaced when the scanner is next tried on the layer.
+	var ws []claircore.IndexWarning
+	wctx := indexer.WithWarningFunc(indexer.WithScanner(ctx, s, l), func(w claircore.IndexWarning) {
+		ws = append(ws, w)
+	})
+	indexer.Warn(wctx, "", "skipped: scanner failed: %v", se.err)
+	if wst, ok := ls.store.(indexer.WarningStore); ok {
+		if err := wst.SetLayerWarnings(ctx, l.Hash, s, ws); err != nil {
+			return fmt.Errorf("could not set layer warnings: %w", err)
+		}
+	}
+	return nil
+}
+
+// Skip reports whether the scanner should be skipped for a layer for the
+// named operating system. Only PortableScanners are run against layers for
+// operating systems other than Linux; a warning is reported for every other
+// scanner, so that the report notes what wasn't looked at.
+func (ls *layerScanner) skip(ctx context.Context, l *claircore.Layer, layerOS string, s indexer.VersionedScanner) bool {
+	if layerOS == "linux" {
+		return false
+	}
+	if _, ok := s.(indexer.PortableScanner); ok {
+		return false
+	}
+	zlog.Debug(ctx).
+		Str("layer", l.Hash.String()).
+		Str("os", layerOS).
+		Str("scanner", s.Name()).
+		Msg("skipping scanner for non-linux layer")
+	indexer.Warn(indexer.WithScanner(ctx, s, l), "", "skipped: layer is for %q, which the scanner doesn't understand", layerOS)
+	return true
+}
+
 // ScanLayer (along with the result type) handles an individual (scanner, layer)
 // pair.
 func (ls *layerScanner) scanLayer(ctx context.Context, l *claircore.Layer, s indexer.VersionedScanner) error {
@@ -201,16 +320,87 @@ func (ls *layerScanner) scanLayer(ctx context.Context, l *claircore.Layer, s ind
 		return nil
 	}
 
-	var result result
-	if err := result.Do(ctx, s, l); err != nil {
+	// Warnings are kept with the layer's artifacts, so manifests that reuse
+	// the layer's results report them too.
+	var mu sync.Mutex
+	ws := []claircore.IndexWarning{}
+	sctx := indexer.WithWarningFunc(indexer.WithScanner(ctx, s, l), func(w claircore.IndexWarning) {
+		mu.Lock()
+		defer mu.Unlock()
+		ws = append(ws, w)
+	})
+	result, err := ls.do(sctx, s, l)
+	if err != nil {
 		return err
 	}
 
-	if err = ls.store.SetLayerScanned(ctx, l.Hash, s); err != nil {
+	// Store the artifacts before marking the layer scanned: if the process
+	// dies in between, the layer gets scanned again rather than being
+	// recorded as having nothing in it.
+	if err := result.Store(ctx, ls.store, s, l); err != nil {
+		return err
+	}
+	if wst, ok := ls.store.(indexer.WarningStore); ok {
+		mu.Lock()
+		err := wst.SetLayerWarnings(ctx, l.Hash, s, ws)
+		mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("could not set layer warnings: %w", err)
+		}
+	}
+	if err := ls.store.SetLayerScanned(ctx, l.Hash, s); err != nil {
 		return fmt.Errorf("could not set layer scanned: %v", l)
 	}
+	return nil
+}
+
+// ScanError is a failure of the scanner itself, as opposed to of the Store.
+type scanError struct {
+	scanner string
+	err     error
+}
+
+func (e *scanError) Error() string {
+	return fmt.Sprintf("scanner %q: %v", e.scanner, e.err)
+}
+
+func (e *scanError) Unwrap() error {
+	return e.err
+}
 
-	return result.Store(ctx, ls.store, s, l)
+// Do runs the scanner against the layer, bounded by the configured timeout.
+// Errors from the scanner are returned as a *scanError.
+//
+// A scanner still running at the deadline is abandoned: its goroutine is left
+// to finish on its own and its results are discarded.
+func (ls *layerScanner) do(ctx context.Context, s indexer.VersionedScanner, l *claircore.Layer) (*result, error) {
+	if ls.timeout <= 0 {
+		var r result
+		if err := r.Do(ctx, s, l); err != nil {
+			return nil, &scanError{scanner: s.Name(), err: err}
+		}
+		return &r, nil
+	}
+	sctx, cancel := context.WithTimeout(ctx, ls.timeout)
+	defer cancel()
+	var r result
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Do(sctx, s, l)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, &scanError{scanner: s.Name(), err: err}
+		}
+		return &r, nil
+	case <-sctx.Done():
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	err := fmt.Errorf("timed out after %v: %w", ls.timeout, sctx.Err())
+	return nil, &scanError{scanner: s.Name(), err: err}
 }
 
 // Result is a type that handles the kind-specific bits of the scan process.
@@ -3,6 +3,9 @@ package layerscanner
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -10,6 +13,7 @@ import (
 	"github.com/quay/zlog"
 
 	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
 	"github.com/quay/claircore/test"
 	indexer "github.com/quay/claircore/test/mock/indexer"
 )
@@ -90,6 +94,8 @@ func TestScanNoErrors(t *testing.T) {
 
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
+	var p scanProgress
+	ctx = ccindexer.WithProgress(ctx, &p)
 	d, err := claircore.NewDigest("sha256", make([]byte, sha256.Size))
 	if err != nil {
 		t.Fatal(err)
@@ -97,4 +103,331 @@ func TestScanNoErrors(t *testing.T) {
 	if err := layerscanner.Scan(ctx, d, layers); err != nil {
 		t.Fatalf("failed to scan test layers: %v", err)
 	}
+	// Every scanner reports being done with every layer.
+	if got, want := p.done, 6; got != want {
+		t.Errorf("progress: got: %d, want: %d", got, want)
+	}
+}
+
+// ScanProgress counts the scans reported to an indexer.Progress.
+type scanProgress struct {
+	mu   sync.Mutex
+	done int
+}
+
+func (p *scanProgress) LayerFetched(context.Context, claircore.Digest, error) {}
+func (p *scanProgress) Coalescing(context.Context, claircore.Digest)          {}
+func (p *scanProgress) ScannerDone(_ context.Context, _ claircore.Digest, _ ccindexer.VersionedScanner, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		p.done++
+	}
+}
+
+// TestScannerFailure confirms a hung scanner is abandoned at its deadline,
+// and that the failure policy decides the outcome.
+func TestScannerFailure(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	_, layers := test.ServeLayers(t, 1)
+	d, err := claircore.NewDigest("sha256", make([]byte, sha256.Size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The hung scanner ignores its Context, and is let go at the end.
+	hung := make(chan struct{})
+	t.Cleanup(func() { close(hung) })
+
+	tt := []struct {
+		name   string
+		policy ccindexer.ScannerFailurePolicy
+		ok     bool
+	}{
+		{name: "FailManifest", policy: ccindexer.FailManifest},
+		{name: "SkipScanner", policy: ccindexer.SkipScanner, ok: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			ctrl := gomock.NewController(t)
+			mock_ps := indexer.NewMockPackageScanner(ctrl)
+			mock_ds := indexer.NewMockDistributionScanner(ctrl)
+			mock_store := indexer.NewMockStore(ctrl)
+
+			mock_ps.EXPECT().Scan(gomock.Any(), layers[0]).DoAndReturn(
+				func(context.Context, *claircore.Layer) ([]*claircore.Package, error) {
+					<-hung
+					return nil, nil
+				})
+			mock_ps.EXPECT().Kind().AnyTimes().Return("package")
+			mock_ps.EXPECT().Name().AnyTimes().Return("package")
+			mock_ps.EXPECT().Version().AnyTimes().Return("1")
+			mock_store.EXPECT().LayerScanned(gomock.Any(), layers[0].Hash, mock_ps).Return(false, nil)
+
+			mock_ds.EXPECT().Scan(gomock.Any(), layers[0]).AnyTimes().Return([]*claircore.Distribution{}, nil)
+			mock_ds.EXPECT().Kind().AnyTimes().Return("distribution")
+			mock_ds.EXPECT().Name().AnyTimes().Return("distribution")
+			mock_ds.EXPECT().Version().AnyTimes().Return("1")
+			mock_store.EXPECT().LayerScanned(gomock.Any(), layers[0].Hash, mock_ds).AnyTimes().Return(false, nil)
+			mock_store.EXPECT().IndexDistributions(gomock.Any(), gomock.Any(), layers[0], mock_ds).AnyTimes().Return(nil)
+			mock_store.EXPECT().SetLayerScanned(gomock.Any(), layers[0].Hash, mock_ds).AnyTimes().Return(nil)
+
+			ecosystem := &indexer.Ecosystem{
+				Name: "test-ecosystem",
+				PackageScanners: func(ctx context.Context) ([]indexer.PackageScanner, error) {
+					return []indexer.PackageScanner{mock_ps}, nil
+				},
+				DistributionScanners: func(ctx context.Context) ([]indexer.DistributionScanner, error) {
+					return []indexer.DistributionScanner{mock_ds}, nil
+				},
+				RepositoryScanners: func(ctx context.Context) ([]indexer.RepositoryScanner, error) {
+					return nil, nil
+				},
+			}
+			store := &warningStore{Store: mock_store}
+			ls, err := New(ctx, 1, &indexer.Opts{
+				Store:          store,
+				Ecosystems:     []*indexer.Ecosystem{ecosystem},
+				ScannerTimeout: 100 * time.Millisecond,
+				ScannerFailure: tc.policy,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mu sync.Mutex
+			var errs []claircore.ScannerError
+			var warns []claircore.IndexWarning
+			ctx = ccindexer.WithScannerErrorFunc(ctx, func(e claircore.ScannerError) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, e)
+			})
+			ctx = ccindexer.WithWarningFunc(ctx, func(w claircore.IndexWarning) {
+				mu.Lock()
+				defer mu.Unlock()
+				warns = append(warns, w)
+			})
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			err = ls.Scan(ctx, d, layers)
+			t.Log(err)
+			switch {
+			case tc.ok && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case !tc.ok && !errors.Is(err, context.DeadlineExceeded):
+				t.Fatalf("got: %v, want: %v", err, context.DeadlineExceeded)
+			}
+			var se *ccindexer.ScanError
+			if got, want := errors.As(err, &se), !tc.ok; got != want {
+				t.Errorf("ScanError: got: %v, want: %v", got, want)
+			}
+			if se != nil && se.Layer != layers[0] {
+				t.Errorf("ScanError layer: got: %v, want: %v", se.Layer.Hash, layers[0].Hash)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if got, want := len(errs), 1; got != want {
+				t.Fatalf("scanner errors: got: %d, want: %d", got, want)
+			}
+			if got, want := errs[0].Scanner, "package"; got != want {
+				t.Errorf("scanner: got: %q, want: %q", got, want)
+			}
+			if got, want := errs[0].Layer.String(), layers[0].Hash.String(); got != want {
+				t.Errorf("layer: got: %q, want: %q", got, want)
+			}
+			if got, want := len(warns) == 1, tc.ok; got != want {
+				t.Errorf("warned: got: %v, want: %v", got, want)
+			}
+			// The skip is recorded with the layer, for other manifests
+			// containing it.
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			stored := store.ws[layers[0].Hash.String()+"package"]
+			if got, want := len(stored) == 1, tc.ok; got != want {
+				t.Errorf("stored: got: %v, want: %v", got, want)
+			}
+			if tc.ok && len(warns) == 1 && len(stored) == 1 && stored[0].Message != warns[0].Message {
+				t.Errorf("stored: got: %+v, want: %+v", stored[0], warns[0])
+			}
+		})
+	}
+}
+
+// WarningStore adds indexer.WarningStore to a Store.
+type warningStore struct {
+	indexer.Store
+	mu sync.Mutex
+	ws map[string][]claircore.IndexWarning
+}
+
+func (s *warningStore) SetLayerWarnings(_ context.Context, l claircore.Digest, scnr indexer.VersionedScanner, ws []claircore.IndexWarning) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ws == nil {
+		s.ws = make(map[string][]claircore.IndexWarning)
+	}
+	s.ws[l.String()+scnr.Name()] = ws
+	return nil
 }
+
+func (s *warningStore) LayerWarnings(context.Context, []claircore.Digest, ccindexer.VersionedScanners) ([]claircore.IndexWarning, error) {
+	panic("unused")
+}
+
+// TestSerialScanner confirms layers are scanned concurrently, except by a
+// SerialScanner.
+func TestSerialScanner(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	ctrl := gomock.NewController(t)
+	mock_store := indexer.NewMockStore(ctrl)
+	mock_store.EXPECT().LayerScanned(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(false, nil)
+	mock_store.EXPECT().IndexPackages(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	mock_store.EXPECT().SetLayerScanned(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
+	_, layers := test.ServeLayers(t, 4)
+	d, err := claircore.NewDigest("sha256", make([]byte, sha256.Size))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The wrappers added by Opts.PackageFilter and Opts.ScannerConfigVersions
+	// mustn't hide that a scanner is serial.
+	filter := func(es []*indexer.Ecosystem) []*indexer.Ecosystem {
+		keep := func(*claircore.Package, *claircore.Layer) bool { return true }
+		return ccindexer.FilterEcosystems(es, keep, "test")
+	}
+	version := func(es []*indexer.Ecosystem) []*indexer.Ecosystem {
+		return ccindexer.VersionEcosystems(es, ccindexer.ConfigVersions{
+			Package: map[string]string{"counting": "1"},
+		})
+	}
+	tt := []struct {
+		name   string
+		serial bool
+		wrap   []func([]*indexer.Ecosystem) []*indexer.Ecosystem
+		want   func(int) bool
+	}{
+		{name: "Concurrent", want: func(n int) bool { return n > 1 }},
+		{name: "Serial", serial: true, want: func(n int) bool { return n == 1 }},
+		{
+			name:   "Filtered",
+			serial: true,
+			wrap:   []func([]*indexer.Ecosystem) []*indexer.Ecosystem{filter},
+			want:   func(n int) bool { return n == 1 },
+		},
+		{
+			name:   "Versioned",
+			serial: true,
+			wrap:   []func([]*indexer.Ecosystem) []*indexer.Ecosystem{version},
+			want:   func(n int) bool { return n == 1 },
+		},
+		{
+			name:   "FilteredVersioned",
+			serial: true,
+			wrap:   []func([]*indexer.Ecosystem) []*indexer.Ecosystem{filter, version},
+			want:   func(n int) bool { return n == 1 },
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c := &countingScanner{
+				started: make(chan struct{}, len(layers)),
+				gate:    make(chan struct{}),
+			}
+			var s indexer.PackageScanner = c
+			if tc.serial {
+				s = &serialScanner{c}
+			}
+			ecosystem := &indexer.Ecosystem{
+				Name: "test-ecosystem",
+				PackageScanners: func(ctx context.Context) ([]indexer.PackageScanner, error) {
+					return []indexer.PackageScanner{s}, nil
+				},
+				DistributionScanners: func(ctx context.Context) ([]indexer.DistributionScanner, error) {
+					return nil, nil
+				},
+				RepositoryScanners: func(ctx context.Context) ([]indexer.RepositoryScanner, error) {
+					return nil, nil
+				},
+			}
+			es := []*indexer.Ecosystem{ecosystem}
+			for _, w := range tc.wrap {
+				es = w(es)
+			}
+			ls, err := New(ctx, len(layers), &indexer.Opts{
+				Store:      mock_store,
+				Ecosystems: es,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			errc := make(chan error, 1)
+			go func() { errc <- ls.Scan(ctx, d, layers) }()
+			if tc.serial {
+				// Let the scans through one at a time, giving the other
+				// workers a chance to start a scan they shouldn't.
+				for range layers {
+					<-c.started
+					for i := 0; i < 100; i++ {
+						runtime.Gosched()
+					}
+					c.gate <- struct{}{}
+				}
+			} else {
+				// Two scans can only both start if they run at once.
+				<-c.started
+				<-c.started
+				close(c.gate)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			t.Logf("max concurrent: %d", c.max)
+			if got, want := c.calls, len(layers); got != want {
+				t.Errorf("calls: got: %d, want: %d", got, want)
+			}
+			if !tc.want(c.max) {
+				t.Errorf("unexpected max concurrent scans: %d", c.max)
+			}
+		})
+	}
+}
+
+// CountingScanner records how many of its Scan calls overlap. Each Scan call
+// sends on "started", then waits to receive from "gate".
+type countingScanner struct {
+	mu       sync.Mutex
+	cur, max int
+	calls    int
+	started  chan struct{}
+	gate     chan struct{}
+}
+
+func (*countingScanner) Name() string    { return "counting" }
+func (*countingScanner) Version() string { return "1" }
+func (*countingScanner) Kind() string    { return "package" }
+func (s *countingScanner) Scan(context.Context, *claircore.Layer) ([]*claircore.Package, error) {
+	s.mu.Lock()
+	s.cur++
+	s.calls++
+	if s.cur > s.max {
+		s.max = s.cur
+	}
+	s.mu.Unlock()
+	s.started <- struct{}{}
+	<-s.gate
+	s.mu.Lock()
+	s.cur--
+	s.mu.Unlock()
+	return []*claircore.Package{}, nil
+}
+
+type serialScanner struct{ *countingScanner }
+
+func (serialScanner) Serial() {}
@@ -0,0 +1,118 @@
+package layerscanner
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
+	indexer "github.com/quay/claircore/test/mock/indexer"
+)
+
+// PortableScanner adds the PortableScanner marker to a mock.
+type portableScanner struct {
+	*indexer.MockPackageScanner
+}
+
+func (portableScanner) Portable() {}
+
+// TestScanWindows checks that only portable scanners are run against
+// Windows layers, whether detected from the layout or hinted.
+func TestScanWindows(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	ctrl := gomock.NewController(t)
+
+	mkLayer := func(os string, names ...string) *claircore.Layer {
+		var b bytes.Buffer
+		tw := tar.NewWriter(&b)
+		for _, n := range names {
+			if err := tw.WriteHeader(&tar.Header{Name: n, Size: 1, Mode: 0o644}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte{'x'}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(b.Bytes())
+		d, err := claircore.NewDigest("sha256", sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := &claircore.Layer{Hash: d, OS: os}
+		l.SetBuffer(b.Bytes())
+		return l
+	}
+	layers := []*claircore.Layer{
+		mkLayer("", "Files/Windows/win.ini", "UtilityVM/Files/bootmgr"),
+		mkLayer("windows", "Files/app/app.dll"),
+	}
+
+	linux := indexer.NewMockPackageScanner(ctrl)
+	linux.EXPECT().Kind().AnyTimes().Return("package")
+	linux.EXPECT().Name().AnyTimes().Return("linux")
+	linux.EXPECT().Version().AnyTimes().Return("1")
+
+	mock := indexer.NewMockPackageScanner(ctrl)
+	lang := portableScanner{mock}
+	mock.EXPECT().Kind().AnyTimes().Return("package")
+	mock.EXPECT().Name().AnyTimes().Return("lang")
+	mock.EXPECT().Version().AnyTimes().Return("1")
+
+	store := indexer.NewMockStore(ctrl)
+	for _, l := range layers {
+		mock.EXPECT().Scan(gomock.Any(), l).Return([]*claircore.Package{}, nil)
+		store.EXPECT().LayerScanned(gomock.Any(), l.Hash, lang).Return(false, nil)
+		store.EXPECT().SetLayerScanned(gomock.Any(), l.Hash, lang).Return(nil)
+		store.EXPECT().IndexPackages(gomock.Any(), gomock.Any(), l, lang).Return(nil)
+	}
+
+	ls, err := New(ctx, 1, &indexer.Opts{
+		Store: store,
+		Ecosystems: []*indexer.Ecosystem{{
+			Name: "test-ecosystem",
+			PackageScanners: func(context.Context) ([]indexer.PackageScanner, error) {
+				return []indexer.PackageScanner{linux, lang}, nil
+			},
+			DistributionScanners: func(context.Context) ([]indexer.DistributionScanner, error) { return nil, nil },
+			RepositoryScanners:   func(context.Context) ([]indexer.RepositoryScanner, error) { return nil, nil },
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var ws []claircore.IndexWarning
+	wctx := ccindexer.WithWarningFunc(ctx, func(w claircore.IndexWarning) {
+		mu.Lock()
+		defer mu.Unlock()
+		ws = append(ws, w)
+	})
+	d, err := claircore.NewDigest("sha256", make([]byte, sha256.Size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.Scan(wctx, d, layers); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(ws), len(layers); got != want {
+		t.Fatalf("got: %d warnings, want: %d", got, want)
+	}
+	for _, w := range ws {
+		t.Logf("%+v", w)
+		if w.Scanner != "linux" {
+			t.Errorf("unexpected warning: %+v", w)
+		}
+	}
+}
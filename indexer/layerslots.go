@@ -0,0 +1,40 @@
+package indexer
+
+import (
+	"context"
+
+	"github.com/quay/claircore"
+)
+
+// LayerSlots shares a fixed number of concurrent layer operations, such as
+// fetching or scanning a layer, between the manifests being indexed.
+type LayerSlots interface {
+	// Acquire blocks until the named manifest may start a layer operation or
+	// the Context is canceled. The returned function must be called when the
+	// operation is finished.
+	Acquire(ctx context.Context, manifest claircore.Digest) (release func(), err error)
+}
+
+type layerSlotsKey struct{}
+
+type layerSlots struct {
+	s        LayerSlots
+	manifest claircore.Digest
+}
+
+// WithLayerSlots returns a Context that causes AcquireLayerSlot to draw from
+// the provided LayerSlots on behalf of the named manifest.
+func WithLayerSlots(ctx context.Context, s LayerSlots, manifest claircore.Digest) context.Context {
+	return context.WithValue(ctx, layerSlotsKey{}, &layerSlots{s: s, manifest: manifest})
+}
+
+// AcquireLayerSlot should be called by components before doing work on a
+// single layer. It returns immediately if the Context was not returned from
+// WithLayerSlots.
+func AcquireLayerSlot(ctx context.Context) (release func(), err error) {
+	v, ok := ctx.Value(layerSlotsKey{}).(*layerSlots)
+	if !ok {
+		return func() {}, nil
+	}
+	return v.s.Acquire(ctx, v.manifest)
+}
@@ -60,6 +60,15 @@ func (c *Coalescer) Coalesce(ctx context.Context, layerArtifacts []*indexer.Laye
 		}
 	}
 
+	var n int
+	for _, packages := range dbs {
+		n += len(packages)
+	}
+	if len(c.ir.Packages) == 0 {
+		c.ir.Packages = make(map[string]*claircore.Package, n)
+		c.ir.Environments = make(map[string][]*claircore.Environment, n)
+	}
+
 	for db, packages := range dbs {
 		for _, pkg := range packages {
 			// create our environment
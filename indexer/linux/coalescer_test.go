@@ -2,6 +2,7 @@ package linux
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"testing"
 
@@ -86,3 +87,71 @@ func TestCoalescer(t *testing.T) {
 		}
 	}
 }
+
+// TestDistSearcher checks every layer of every arrangement of distributions
+// over a few layers against a walk of the layers.
+func TestDistSearcher(t *testing.T) {
+	const layers = 5
+	dists := test.GenUniqueDistributions(layers + 1)[1:]
+	for mask := 0; mask < 1<<layers; mask++ {
+		artifacts := make([]*indexer.LayerArtifacts, layers)
+		for i := range artifacts {
+			artifacts[i] = &indexer.LayerArtifacts{}
+			if mask&(1<<i) != 0 {
+				artifacts[i].Dist = dists[i : i+1]
+			}
+		}
+		ds := NewDistSearcher(artifacts)
+		for n := 0; n < layers; n++ {
+			got, err := ds.Search(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := walkDists(artifacts, n); got != want {
+				t.Errorf("%05b: layer %d: got: %v, want: %v", mask, n, got, want)
+			}
+		}
+	}
+	if _, err := NewDistSearcher(nil).Search(0); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// WalkDists looks at the layer, then backwards, then forwards for a
+// distribution.
+func walkDists(artifacts []*indexer.LayerArtifacts, n int) *claircore.Distribution {
+	if len(artifacts[n].Dist) != 0 {
+		return artifacts[n].Dist[0]
+	}
+	for i := n - 1; i >= 0; i-- {
+		if len(artifacts[i].Dist) != 0 {
+			return artifacts[i].Dist[0]
+		}
+	}
+	for i := n + 1; i < len(artifacts); i++ {
+		if len(artifacts[i].Dist) != 0 {
+			return artifacts[i].Dist[0]
+		}
+	}
+	return nil
+}
+
+func BenchmarkCoalescer(b *testing.B) {
+	ctx := zlog.Test(context.Background(), b)
+	for _, sz := range []struct{ layers, pkgs int }{
+		{30, 4000},
+		{300, 40000},
+	} {
+		b.Run(fmt.Sprintf("%dx%d", sz.layers, sz.pkgs), func(b *testing.B) {
+			ctx := zlog.Test(ctx, b)
+			artifacts := test.GenWideArtifacts(b, sz.layers, sz.pkgs)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := NewCoalescer().Coalesce(ctx, artifacts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
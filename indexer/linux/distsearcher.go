@@ -15,6 +15,9 @@ import (
 // Finally the searcher will look forward in the layer history as a final effort to find distribution info.
 type DistSearcher struct {
 	dists []*claircore.Distribution
+	// Nearest holds the result of the search for every layer, so that a
+	// Search is a lookup rather than a walk of the layers.
+	nearest []*claircore.Distribution
 }
 
 func NewDistSearcher(artifacts []*indexer.LayerArtifacts) DistSearcher {
@@ -26,7 +29,26 @@ func NewDistSearcher(artifacts []*indexer.LayerArtifacts) DistSearcher {
 			dists[i] = artifact.Dist[0] // we dont support multiple dists found in a layer
 		}
 	}
-	return DistSearcher{dists}
+	// Fill in the nearest distribution looking backwards, then fill in the
+	// remaining gaps at the start looking forwards.
+	nearest := make([]*claircore.Distribution, len(dists))
+	var cur *claircore.Distribution
+	for i, d := range dists {
+		if d != nil {
+			cur = d
+		}
+		nearest[i] = cur
+	}
+	cur = nil
+	for i := len(dists) - 1; i >= 0; i-- {
+		if dists[i] != nil {
+			cur = dists[i]
+		}
+		if nearest[i] == nil {
+			nearest[i] = cur
+		}
+	}
+	return DistSearcher{dists: dists, nearest: nearest}
 }
 
 func (ds DistSearcher) Search(n int) (*claircore.Distribution, error) {
@@ -34,24 +56,7 @@ func (ds DistSearcher) Search(n int) (*claircore.Distribution, error) {
 		return nil, fmt.Errorf("provided manifest contains %d layers. %d is out of bounds", len(ds.dists), n)
 	}
 
-	if found := ds.dists[n]; found != nil {
-		return found, nil
-	}
-
-	// first search backwards
-	for i := n - 1; i >= 0; i-- {
-		if ds.dists[i] != nil {
-			return ds.dists[i], nil
-		}
-	}
-
-	// now search forward
-	for i := n + 1; i < len(ds.dists); i++ {
-		if ds.dists[i] != nil {
-			return ds.dists[i], nil
-		}
-	}
-	return nil, nil
+	return ds.nearest[n], nil
 }
 
 func (ds DistSearcher) Dists() []*claircore.Distribution {
@@ -8,7 +8,7 @@ import (
 // PackageSearcher tracks the layer's hash and index a package
 // was introduced in.
 type PackageSearcher struct {
-	m map[string]entry
+	m map[packageKey]entry
 }
 
 // an entry mapped to a package's key.
@@ -18,15 +18,28 @@ type entry struct {
 	index  int
 }
 
+// packageKey is a unique key in the package searcher's map.
+type packageKey struct {
+	name, db, version string
+}
+
 // creates a unique key in the package searcher's map
-func keyify(pkg *claircore.Package) string {
-	return pkg.Name + pkg.PackageDB + pkg.Version
+func keyify(pkg *claircore.Package) packageKey {
+	return packageKey{name: pkg.Name, db: pkg.PackageDB, version: pkg.Version}
 }
 
 // NewPackageSearcher contructs a PackageSearcher ready for its Search method
 // to be called
 func NewPackageSearcher(layerArtifacts []*indexer.LayerArtifacts) PackageSearcher {
-	m := make(map[string]entry, 0)
+	// Layers list the whole contents of their package databases, so the
+	// largest layer is a better guess at the number of packages than the sum.
+	var n int
+	for _, artifacts := range layerArtifacts {
+		if len(artifacts.Pkgs) > n {
+			n = len(artifacts.Pkgs)
+		}
+	}
+	m := make(map[packageKey]entry, n)
 	for i, artifacts := range layerArtifacts {
 		if len(artifacts.Pkgs) == 0 {
 			continue
@@ -2,6 +2,7 @@ package indexer
 
 import (
 	"net/http"
+	"time"
 )
 
 // Opts are options to instantiate a indexer
@@ -16,4 +17,26 @@ type Opts struct {
 	Ecosystems   []*Ecosystem
 	Vscnrs       VersionedScanners
 	Airgap       bool
+	// VerifyPackages names OS packages whose files are checked against the
+	// package database. See libindex.Options.VerifyPackages.
+	VerifyPackages []string
+	// ValidateDiffIDs has layers' uncompressed contents checked against the
+	// image configuration. See libindex.Options.ValidateDiffIDs.
+	ValidateDiffIDs bool
+	// ApplyWhiteouts has packages whose files were removed or replaced in a
+	// later layer left out of the IndexReport. See
+	// libindex.Options.ApplyWhiteouts.
+	ApplyWhiteouts bool
+	// Squash has the scanners run over the image's merged filesystem rather
+	// than each layer. See libindex.Options.Squash.
+	Squash bool
+	// PartialReports has a failed index report what was found before it
+	// failed. See libindex.Options.PartialReports.
+	PartialReports bool
+	// ScannerTimeout, if non-zero, bounds how long a scanner may take on a
+	// layer. See libindex.Options.ScannerTimeout.
+	ScannerTimeout time.Duration
+	// ScannerFailure decides what a scanner failing on a layer does to the
+	// index.
+	ScannerFailure ScannerFailurePolicy
 }
@@ -0,0 +1,150 @@
+package indexer
+
+import (
+	"context"
+
+	"github.com/quay/claircore"
+)
+
+// PackageFilter reports whether a package found in a layer should be kept.
+//
+// It may be called concurrently.
+type PackageFilter func(*claircore.Package, *claircore.Layer) bool
+
+// FilterVersionSep separates a scanner's own version from the filter
+// identifier in the version of a filtered scanner.
+const filterVersionSep = "+filter."
+
+// FilterEcosystems returns copies of the provided Ecosystems whose package
+// scanners drop the packages the filter rejects, before they're persisted.
+//
+// The "id" argument identifies the filter and is appended to the version of
+// every package scanner. Changing the filter without changing the identifier
+// means layers that have already been scanned keep their old results.
+func FilterEcosystems(es []*Ecosystem, f PackageFilter, id string) []*Ecosystem {
+	out := make([]*Ecosystem, len(es))
+	for i, e := range es {
+		e := *e
+		inner := e.PackageScanners
+		e.PackageScanners = func(ctx context.Context) ([]PackageScanner, error) {
+			ps, err := inner(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for i, s := range ps {
+				ps[i] = FilterPackageScanner(s, f, id)
+			}
+			return ps, nil
+		}
+		out[i] = &e
+	}
+	return out
+}
+
+// FilterPackageScanner returns a PackageScanner that drops the packages the
+// filter rejects from the results of the provided PackageScanner.
+//
+// The returned scanner implements PortableScanner, ConfigurableScanner, and
+// RPCScanner if the provided one does. Marker interfaces like SerialScanner
+// and FileInterest are found by seeing through the wrapper; see IsSerial. See
+// FilterEcosystems for the "id" argument. Filtering an
+// already filtered scanner replaces its filter.
+func FilterPackageScanner(s PackageScanner, f PackageFilter, id string) PackageScanner {
+	if fs, ok := s.(interface{ unwrap() PackageScanner }); ok {
+		s = fs.unwrap()
+	}
+	fs := &filteredScanner{PackageScanner: s, filter: f, id: id}
+	_, portable := s.(PortableScanner)
+	cs, csOK := s.(ConfigurableScanner)
+	rs, rsOK := s.(RPCScanner)
+	switch {
+	case rsOK && portable:
+		return &portableRPCFiltered{rpcFiltered{fs, rs}}
+	case rsOK:
+		return &rpcFiltered{fs, rs}
+	case csOK && portable:
+		return &portableConfigurableFiltered{configurableFiltered{fs, cs}}
+	case csOK:
+		return &configurableFiltered{fs, cs}
+	case portable:
+		return &portableFiltered{fs}
+	}
+	return fs
+}
+
+// FilteredScanner is the PackageScanner returned by FilterPackageScanner. The
+// other *Filtered types add the optional interfaces the wrapped scanner
+// implements.
+type filteredScanner struct {
+	PackageScanner
+	filter PackageFilter
+	id     string
+}
+
+func (s *filteredScanner) unwrap() PackageScanner { return s.PackageScanner }
+
+// Version implements VersionedScanner.
+func (s *filteredScanner) Version() string {
+	return s.PackageScanner.Version() + filterVersionSep + s.id
+}
+
+// Scan implements PackageScanner.
+func (s *filteredScanner) Scan(ctx context.Context, l *claircore.Layer) ([]*claircore.Package, error) {
+	ps, err := s.PackageScanner.Scan(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+	out := ps[:0]
+	for _, p := range ps {
+		if s.filter(p, l) {
+			out = append(out, p)
+		}
+	}
+	if n := len(ps) - len(out); n != 0 {
+		// Clear the tail, so the dropped packages can be collected.
+		for i := len(out); i < len(ps); i++ {
+			ps[i] = nil
+		}
+		if c, ok := ctx.Value(filteredKey{}).(FilteredFunc); ok {
+			c(n)
+		}
+	}
+	return out, nil
+}
+
+type configurableFiltered struct {
+	*filteredScanner
+	ConfigurableScanner
+}
+
+type rpcFiltered struct {
+	*filteredScanner
+	RPCScanner
+}
+
+type portableFiltered struct{ *filteredScanner }
+
+func (portableFiltered) Portable() {}
+
+type portableConfigurableFiltered struct{ configurableFiltered }
+
+func (portableConfigurableFiltered) Portable() {}
+
+type portableRPCFiltered struct{ rpcFiltered }
+
+func (portableRPCFiltered) Portable() {}
+
+// FilteredFunc is called with the number of packages a filtered scanner
+// dropped from a layer.
+//
+// It may be called concurrently.
+type FilteredFunc func(int)
+
+type filteredKey struct{}
+
+// WithFilteredFunc returns a Context that delivers the number of packages
+// dropped by scanners returned from FilterPackageScanner to the provided
+// function.
+func WithFilteredFunc(ctx context.Context, f FilteredFunc) context.Context {
+	return context.WithValue(ctx, filteredKey{}, f)
+}
@@ -0,0 +1,69 @@
+package indexer
+
+import (
+	"context"
+	"io/fs"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/pkg/tarfs"
+)
+
+// PortableScanner is implemented by scanners that understand layers for any
+// operating system, such as language package scanners.
+//
+// Scanners that don't implement it are assumed to only understand Linux
+// layers, and aren't run against layers for other operating systems.
+type PortableScanner interface {
+	VersionedScanner
+	// Portable is a marker method.
+	Portable()
+}
+
+type osHintKey struct{}
+
+// WithOSHint returns a Context telling LayerOS the operating system to assume
+// for layers without an OS of their own, such as the one the manifest being
+// indexed is for.
+func WithOSHint(ctx context.Context, os string) context.Context {
+	return context.WithValue(ctx, osHintKey{}, os)
+}
+
+// LayerOS reports the operating system the layer is for, using the values of
+// the "os" field of an OCI image configuration.
+//
+// The Layer's OS is used if set, then any hint set with WithOSHint. Otherwise,
+// the layer is examined for the layout used by Windows layers: a "Files"
+// directory alongside a "Hives" or "UtilityVM" directory. Any other layer,
+// including one that can't be read, is reported as "linux".
+func LayerOS(ctx context.Context, l *claircore.Layer) string {
+	if l.OS != "" {
+		return l.OS
+	}
+	if os, ok := ctx.Value(osHintKey{}).(string); ok && os != "" {
+		return os
+	}
+	const linux = "linux"
+	r, err := l.Reader()
+	if err != nil {
+		return linux
+	}
+	defer r.Close()
+	sys, err := tarfs.New(r)
+	if err != nil {
+		zlog.Debug(ctx).
+			Err(err).
+			Str("layer", l.Hash.String()).
+			Msg("unable to open layer, assuming linux")
+		return linux
+	}
+	isDir := func(n string) bool {
+		fi, err := fs.Stat(sys, n)
+		return err == nil && fi.IsDir()
+	}
+	if isDir("Files") && (isDir("Hives") || isDir("UtilityVM")) {
+		return "windows"
+	}
+	return linux
+}
@@ -0,0 +1,163 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ScannerAPIVersion is the version of the scanner interfaces in this package,
+// as far as out-of-tree scanners are concerned. It's incremented whenever a
+// change would break them, so that a Plugin written against an older version
+// is refused rather than misbehaving.
+const ScannerAPIVersion = 1
+
+// Plugin is an Ecosystem contributed from outside this module. Plugins are
+// registered with Register, usually from an init function, so that importing
+// the plugin's package for its side effects is enough to use it, as with
+// database/sql drivers.
+//
+// The scanners a Plugin provides are recorded in the Store by name, kind, and
+// version like any other, so their names must be unique among all configured
+// scanners; prefixing them with the Plugin's name is a good way to ensure it.
+// A scanner's version should change whenever its output would.
+type Plugin struct {
+	// Name identifies the plugin. It must be unique.
+	Name string
+	// APIVersion is the ScannerAPIVersion the plugin was written against.
+	APIVersion int
+	// Ecosystem returns the plugin's Ecosystem.
+	Ecosystem func(context.Context) (*Ecosystem, error)
+}
+
+var plugins = struct {
+	sync.Mutex
+	m map[string]Plugin
+}{
+	m: make(map[string]Plugin),
+}
+
+// Register makes a Plugin available to indexers that haven't been given an
+// explicit list of Ecosystems.
+//
+// Register panics if the Plugin has no name or Ecosystem, uses a name already
+// registered, or was written against a different ScannerAPIVersion.
+func Register(p Plugin) {
+	switch {
+	case p.Name == "":
+		panic("indexer: Register called with an unnamed Plugin")
+	case p.Ecosystem == nil:
+		panic(fmt.Sprintf("indexer: Register called with a nil Ecosystem for plugin %q", p.Name))
+	case p.APIVersion != ScannerAPIVersion:
+		panic(fmt.Sprintf("indexer: plugin %q targets scanner API version %d, have %d", p.Name, p.APIVersion, ScannerAPIVersion))
+	}
+	plugins.Lock()
+	defer plugins.Unlock()
+	if _, ok := plugins.m[p.Name]; ok {
+		panic(fmt.Sprintf("indexer: Register called twice for plugin %q", p.Name))
+	}
+	plugins.m[p.Name] = p
+}
+
+// Plugins returns the registered Plugins, sorted by name.
+func Plugins() []Plugin {
+	plugins.Lock()
+	defer plugins.Unlock()
+	out := make([]Plugin, 0, len(plugins.m))
+	for _, p := range plugins.m {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// PluginEcosystems returns the Ecosystems of the registered Plugins, sorted
+// by the Plugins' names.
+//
+// The scanners the Ecosystems return are checked to have a name, a version,
+// and the kind matching the interface they implement, as the Store relies on
+// these to keep track of what's been scanned.
+func PluginEcosystems(ctx context.Context) ([]*Ecosystem, error) {
+	ps := Plugins()
+	out := make([]*Ecosystem, 0, len(ps))
+	for _, p := range ps {
+		e, err := p.Ecosystem(ctx)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("indexer: plugin %q: %w", p.Name, err)
+		case e == nil || e.Coalescer == nil:
+			return nil, fmt.Errorf("indexer: plugin %q: no Coalescer", p.Name)
+		}
+		out = append(out, checkedEcosystem(p.Name, e))
+	}
+	return out, nil
+}
+
+// CheckedEcosystem wraps the Ecosystem's scanner constructors to check the
+// scanners they return. Missing constructors are filled in.
+func checkedEcosystem(plugin string, e *Ecosystem) *Ecosystem {
+	out := *e
+	if out.Name == "" {
+		out.Name = plugin
+	}
+	out.PackageScanners = func(ctx context.Context) ([]PackageScanner, error) {
+		if e.PackageScanners == nil {
+			return nil, nil
+		}
+		ss, err := e.PackageScanners(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range ss {
+			if err := checkScanner(plugin, s, Package); err != nil {
+				return nil, err
+			}
+		}
+		return ss, nil
+	}
+	out.DistributionScanners = func(ctx context.Context) ([]DistributionScanner, error) {
+		if e.DistributionScanners == nil {
+			return nil, nil
+		}
+		ss, err := e.DistributionScanners(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range ss {
+			if err := checkScanner(plugin, s, "distribution"); err != nil {
+				return nil, err
+			}
+		}
+		return ss, nil
+	}
+	out.RepositoryScanners = func(ctx context.Context) ([]RepositoryScanner, error) {
+		if e.RepositoryScanners == nil {
+			return nil, nil
+		}
+		ss, err := e.RepositoryScanners(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range ss {
+			if err := checkScanner(plugin, s, "repository"); err != nil {
+				return nil, err
+			}
+		}
+		return ss, nil
+	}
+	return &out
+}
+
+// CheckScanner reports an error if the scanner can't be tracked in a Store.
+func checkScanner(plugin string, s VersionedScanner, kind string) error {
+	switch {
+	case s.Name() == "":
+		return fmt.Errorf("indexer: plugin %q: %s scanner has no name", plugin, kind)
+	case s.Version() == "":
+		return fmt.Errorf("indexer: plugin %q: scanner %q has no version", plugin, s.Name())
+	case s.Kind() != kind:
+		return fmt.Errorf("indexer: plugin %q: scanner %q has kind %q, want %q", plugin, s.Name(), s.Kind(), kind)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+)
+
+// ResetPlugins empties the registry for the duration of the test.
+func resetPlugins(t *testing.T) {
+	plugins.Lock()
+	prev := plugins.m
+	plugins.m = make(map[string]Plugin)
+	plugins.Unlock()
+	t.Cleanup(func() {
+		plugins.Lock()
+		plugins.m = prev
+		plugins.Unlock()
+	})
+}
+
+func pluginEcosystem(ss ...PackageScanner) func(context.Context) (*Ecosystem, error) {
+	return func(context.Context) (*Ecosystem, error) {
+		return &Ecosystem{
+			PackageScanners: func(context.Context) ([]PackageScanner, error) { return ss, nil },
+			Coalescer:       func(context.Context) (Coalescer, error) { return nil, nil },
+		}, nil
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	resetPlugins(t)
+	Register(Plugin{Name: "dup", APIVersion: ScannerAPIVersion, Ecosystem: pluginEcosystem()})
+	tt := []struct {
+		name string
+		p    Plugin
+	}{
+		{name: "Unnamed", p: Plugin{APIVersion: ScannerAPIVersion, Ecosystem: pluginEcosystem()}},
+		{name: "NoEcosystem", p: Plugin{Name: "x", APIVersion: ScannerAPIVersion}},
+		{name: "APIVersion", p: Plugin{Name: "x", APIVersion: ScannerAPIVersion + 1, Ecosystem: pluginEcosystem()}},
+		{name: "Duplicate", p: Plugin{Name: "dup", APIVersion: ScannerAPIVersion, Ecosystem: pluginEcosystem()}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Error("expected panic")
+				} else {
+					t.Log(r)
+				}
+			}()
+			Register(tc.p)
+		})
+	}
+}
+
+func TestPluginEcosystems(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Order", func(t *testing.T) {
+		resetPlugins(t)
+		for _, n := range []string{"b", "c", "a"} {
+			Register(Plugin{Name: n, APIVersion: ScannerAPIVersion, Ecosystem: pluginEcosystem()})
+		}
+		es, err := PluginEcosystems(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		for _, e := range es {
+			got += e.Name
+		}
+		if want := "abc"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
+	t.Run("NoCoalescer", func(t *testing.T) {
+		resetPlugins(t)
+		Register(Plugin{Name: "x", APIVersion: ScannerAPIVersion, Ecosystem: func(context.Context) (*Ecosystem, error) {
+			return &Ecosystem{}, nil
+		}})
+		if _, err := PluginEcosystems(ctx); err == nil {
+			t.Error("expected error")
+		}
+	})
+	t.Run("Scanners", func(t *testing.T) {
+		tt := []struct {
+			name string
+			s    PackageScanner
+			ok   bool
+		}{
+			{name: "OK", s: NewPackageScannerMock("x-pkg", "1", Package), ok: true},
+			{name: "NoName", s: NewPackageScannerMock("", "1", Package)},
+			{name: "NoVersion", s: NewPackageScannerMock("x-pkg", "", Package)},
+			{name: "Kind", s: NewPackageScannerMock("x-pkg", "1", "repository")},
+		}
+		for _, tc := range tt {
+			t.Run(tc.name, func(t *testing.T) {
+				resetPlugins(t)
+				Register(Plugin{Name: "x", APIVersion: ScannerAPIVersion, Ecosystem: pluginEcosystem(tc.s)})
+				es, err := PluginEcosystems(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = es[0].PackageScanners(ctx)
+				t.Log(err)
+				if got, want := err == nil, tc.ok; got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+				// Missing constructors are filled in.
+				if _, err := es[0].RepositoryScanners(ctx); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package indexer
+
+import (
+	"context"
+
+	"github.com/quay/claircore"
+)
+
+// Progress is told about an index as it happens, so that a caller can show
+// what's been done before the IndexReport is ready.
+//
+// The methods may be called from multiple goroutines at once, and are called
+// inline: a slow Progress slows the index. Canceling the Context passed to
+// the index from a method is the way to stop an index partway.
+type Progress interface {
+	// LayerFetched is called when the Realizer is done with a layer, with
+	// the error fetching it, if any. Layers already scanned by every scanner
+	// aren't fetched, and aren't reported.
+	LayerFetched(ctx context.Context, layer claircore.Digest, err error)
+	// ScannerDone is called when a scanner is done with a layer, with the
+	// error scanning it, if any.
+	ScannerDone(ctx context.Context, layer claircore.Digest, s VersionedScanner, err error)
+	// Coalescing is called when the scanned layers start being combined
+	// into the IndexReport for the manifest.
+	Coalescing(ctx context.Context, manifest claircore.Digest)
+}
+
+type progressKey struct{}
+
+// WithProgress returns a Context that has an index reporting to "p".
+func WithProgress(ctx context.Context, p Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// ProgressFrom reports the Progress set by WithProgress, if any.
+func ProgressFrom(ctx context.Context) (Progress, bool) {
+	p, ok := ctx.Value(progressKey{}).(Progress)
+	return p, ok && p != nil
+}
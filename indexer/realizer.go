@@ -2,6 +2,9 @@ package indexer
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/quay/claircore"
 )
@@ -13,3 +16,101 @@ type Realizer interface {
 	Realize(context.Context, []*claircore.Layer) error
 	Close() error
 }
+
+// Capabilities is a set of optional features a Realizer supports.
+type Capabilities uint
+
+// These are the defined Capabilities.
+const (
+	// CapSeekable means realized layers can be read at arbitrary offsets
+	// without reading what comes before.
+	CapSeekable Capabilities = 1 << iota
+	// CapStreaming means a layer's contents can be read while it's still
+	// being fetched.
+	CapStreaming
+	// CapResumable means an interrupted fetch continues from where it
+	// stopped instead of starting over.
+	CapResumable
+	// CapUnixSocket means layers may be fetched over HTTP on a Unix domain
+	// socket.
+	CapUnixSocket
+	// CapEntryIndex means individual files in a realized layer can be
+	// looked up without walking the whole layer.
+	CapEntryIndex
+	// CapCompressedBlob means a copy of the layer exactly as fetched is
+	// kept alongside the uncompressed contents.
+	CapCompressedBlob
+	// CapPartial means a layer may be realized with only the files named by
+	// WithFilesOfInterest, instead of all of them.
+	CapPartial
+	// CapLocalFile means layers may be read from local files named by
+	// "file" URIs.
+	CapLocalFile
+
+	capEnd
+)
+
+var capNames = [...]string{
+	"seekable",
+	"streaming",
+	"resumable",
+	"unix-socket",
+	"entry-index",
+	"compressed-blob",
+	"partial",
+	"local-file",
+}
+
+// Has reports whether all the Capabilities in "want" are present.
+func (c Capabilities) Has(want Capabilities) bool {
+	return c&want == want
+}
+
+// String returns the names of the Capabilities, separated by "|".
+func (c Capabilities) String() string {
+	if c == 0 {
+		return "none"
+	}
+	var b strings.Builder
+	for i, n := range capNames {
+		if c&(1<<i) == 0 {
+			continue
+		}
+		if b.Len() != 0 {
+			b.WriteByte('|')
+		}
+		b.WriteString(n)
+	}
+	if rest := c &^ (capEnd - 1); rest != 0 {
+		if b.Len() != 0 {
+			b.WriteByte('|')
+		}
+		fmt.Fprintf(&b, "%#x", uint(rest))
+	}
+	return b.String()
+}
+
+// CapabilityReporter is implemented by Realizers that can describe which
+// optional features they support.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// RealizerCapabilities reports the Capabilities of the Realizer. A Realizer
+// that doesn't implement CapabilityReporter is assumed to support none.
+func RealizerCapabilities(r Realizer) Capabilities {
+	if cr, ok := r.(CapabilityReporter); ok {
+		return cr.Capabilities()
+	}
+	return 0
+}
+
+// TempFiler is implemented by Realizers that can create scratch files for
+// data derived from the layers they realize, such as a squashed image, so
+// they're kept alongside the layers instead of in the system's temporary
+// directory.
+type TempFiler interface {
+	// TempFile creates a new file as os.CreateTemp does with "pattern". The
+	// caller is responsible for removing it.
+	TempFile(pattern string) (*os.File, error)
+}
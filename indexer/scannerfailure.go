@@ -0,0 +1,73 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quay/claircore"
+)
+
+// ScannerFailurePolicy decides what becomes of an index when a scanner fails
+// on a layer, including by running past its deadline.
+type ScannerFailurePolicy int
+
+// These are the defined ScannerFailurePolicies.
+const (
+	// FailManifest fails the index with the scanner's error. This is the
+	// default.
+	FailManifest ScannerFailurePolicy = iota
+	// SkipScanner leaves out what the scanner would have found in the layer
+	// and carries on. The failure is recorded in the IndexReport's
+	// ScannerErrors, along with a warning, so the report is marked as
+	// best-effort. The warning is recorded with the layer's artifacts if the
+	// Store implements WarningStore. The layer isn't recorded as scanned by
+	// the scanner, so it's tried again when indexing another manifest
+	// containing the layer.
+	SkipScanner
+)
+
+// ScannerErrorFunc is called with every scanner failure.
+//
+// It may be called concurrently.
+type ScannerErrorFunc func(claircore.ScannerError)
+
+type scannerErrorKey struct{}
+
+// WithScannerErrorFunc returns a Context that delivers scanner failures
+// reported by ScannerFailed to the provided function.
+func WithScannerErrorFunc(ctx context.Context, f ScannerErrorFunc) context.Context {
+	return context.WithValue(ctx, scannerErrorKey{}, f)
+}
+
+// ScannerFailed reports that the scanner failed on the layer, to be recorded
+// in the IndexReport being produced.
+func ScannerFailed(ctx context.Context, s VersionedScanner, l *claircore.Layer, err error) {
+	f, ok := ctx.Value(scannerErrorKey{}).(ScannerErrorFunc)
+	if !ok {
+		return
+	}
+	f(claircore.ScannerError{
+		Scanner: s.Name(),
+		Kind:    s.Kind(),
+		Layer:   l.Hash,
+		Err:     err.Error(),
+	})
+}
+
+// ScanError is returned by a LayerScanner when a scanner fails on a layer and
+// the failure policy is FailManifest.
+type ScanError struct {
+	Scanner VersionedScanner
+	Layer   *claircore.Layer
+	Err     error
+}
+
+// Error implements error.
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("layer %v: scanner %q: %v", e.Layer.Hash, e.Scanner.Name(), e.Err)
+}
+
+// Unwrap returns the scanner's error.
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
@@ -82,3 +82,47 @@ type Indexer interface {
 	// IndexManifest should index the coalesced manifest's content given an IndexReport.
 	IndexManifest(ctx context.Context, ir *claircore.IndexReport) error
 }
+
+// LayerPersister is an optional interface a Store can implement to record
+// layers independently of any manifest, so that scan artifacts can be indexed
+// for them ahead of time.
+type LayerPersister interface {
+	// PersistLayers must store the presence of the provided layers.
+	PersistLayers(ctx context.Context, layers []claircore.Digest) error
+}
+
+// AffectedManifestsPager is an optional interface a Store can implement to
+// return affected manifests a page at a time, without finding all of them.
+type AffectedManifestsPager interface {
+	// AffectedManifestsPage returns up to "limit" digests of the manifests
+	// the vulnerability affects, in order of their string forms, starting
+	// after the manifest with the digest "after". An empty "after" starts
+	// from the beginning. A page shorter than "limit" is the last.
+	AffectedManifestsPage(ctx context.Context, v claircore.Vulnerability, f claircore.CheckVulnernableFunc, after string, limit int) ([]claircore.Digest, error)
+}
+
+// ImageIndexStore is an optional interface a Store can implement to record
+// which manifests make up an image index, so that affected manifests can be
+// reported for image indexes as well.
+type ImageIndexStore interface {
+	// PersistImageIndex must store the image index and the platform of each
+	// of its manifests. The manifests have already been persisted.
+	PersistImageIndex(ctx context.Context, idx *claircore.ImageIndex) error
+	// ImageIndexes returns the digests of the image indexes containing each
+	// of the provided manifests, keyed by manifest digest. Manifests not in
+	// any image index are left out.
+	ImageIndexes(ctx context.Context, manifests []claircore.Digest) (map[string][]claircore.Digest, error)
+}
+
+// WarningStore is an optional interface a Store can implement to keep the
+// warnings reported while scanning a layer, so they're included in the
+// IndexReport of every manifest containing the layer and not just the one it
+// was scanned for.
+type WarningStore interface {
+	// SetLayerWarnings replaces the warnings recorded for the layer and
+	// scanner. It's called before the layer is marked scanned.
+	SetLayerWarnings(ctx context.Context, layer claircore.Digest, scnr VersionedScanner, ws []claircore.IndexWarning) error
+	// LayerWarnings returns the warnings recorded for the provided layers by
+	// any of the provided scanners.
+	LayerWarnings(ctx context.Context, layers []claircore.Digest, scnrs VersionedScanners) ([]claircore.IndexWarning, error)
+}
@@ -3,6 +3,9 @@ package indexer
 import (
 	"context"
 	"net/http"
+	"sort"
+
+	"github.com/quay/claircore"
 )
 
 const (
@@ -39,6 +42,41 @@ type ConfigurableScanner interface {
 	Configure(context.Context, ConfigDeserializer) error
 }
 
+// SerialScanner is implemented by scanners whose Scan method isn't safe to
+// call concurrently, such as ones that reuse a buffer or shell out to a tool
+// that keeps state on disk.
+//
+// Scanners that don't implement it are assumed to be safe to run against
+// several layers at once, and are.
+type SerialScanner interface {
+	VersionedScanner
+	// Serial is a marker method.
+	Serial()
+}
+
+// IsSerial reports whether the scanner is a SerialScanner, seeing through the
+// wrappers returned by FilterPackageScanner and VersionEcosystems.
+func IsSerial(s VersionedScanner) bool {
+	_, ok := unwrapScanner(s).(SerialScanner)
+	return ok
+}
+
+// UnwrapScanner returns the scanner inside any package filters and
+// configuration versions wrapped around "s". Optional interfaces the wrappers
+// don't pass through can be checked for on the result.
+func unwrapScanner(s VersionedScanner) VersionedScanner {
+	for {
+		switch u := s.(type) {
+		case interface{ unwrap() PackageScanner }:
+			s = u.unwrap()
+		case interface{ unwrap() VersionedScanner }:
+			s = u.unwrap()
+		default:
+			return s
+		}
+	}
+}
+
 // VersionedScanners implements a list with construction methods
 // not concurrency safe
 type VersionedScanners []VersionedScanner
@@ -124,3 +162,27 @@ func MergeVS(pscnr []PackageScanner, dscnr []DistributionScanner, rscnr []Reposi
 	}
 	return out
 }
+
+// Records returns a description of every scanner in the list, sorted by name,
+// kind, and version.
+func (vs VersionedScanners) Records() []claircore.ScannerRecord {
+	out := make([]claircore.ScannerRecord, len(vs))
+	for i, s := range vs {
+		out[i] = claircore.ScannerRecord{
+			Name:    s.Name(),
+			Version: s.Version(),
+			Kind:    s.Kind(),
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		switch {
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		case a.Kind != b.Kind:
+			return a.Kind < b.Kind
+		}
+		return a.Version < b.Version
+	})
+	return out
+}
@@ -0,0 +1,73 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quay/claircore"
+)
+
+// WarningFunc is called with every warning reported by a scanner.
+//
+// It may be called concurrently.
+type WarningFunc func(claircore.IndexWarning)
+
+type warningKey struct{}
+
+// WarningSink is the value stored in a Context by WithWarningFunc and
+// annotated by WithScanner.
+type warningSink struct {
+	f       WarningFunc
+	scanner string
+	layer   claircore.Digest
+}
+
+// WithWarningFunc returns a Context that delivers warnings reported by Warn to
+// the provided function. If the Context was already returned from
+// WithWarningFunc, warnings are delivered to the earlier function as well.
+func WithWarningFunc(ctx context.Context, f WarningFunc) context.Context {
+	w := &warningSink{f: f}
+	if outer, ok := ctx.Value(warningKey{}).(*warningSink); ok {
+		w.scanner, w.layer = outer.scanner, outer.layer
+		w.f = func(iw claircore.IndexWarning) {
+			f(iw)
+			outer.f(iw)
+		}
+	}
+	return context.WithValue(ctx, warningKey{}, w)
+}
+
+// WithScanner returns a Context that attributes warnings reported by Warn to
+// the provided scanner and layer.
+//
+// It's a no-op if the Context was not returned from WithWarningFunc.
+func WithScanner(ctx context.Context, s VersionedScanner, l *claircore.Layer) context.Context {
+	w, ok := ctx.Value(warningKey{}).(*warningSink)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, warningKey{}, &warningSink{
+		f:       w.f,
+		scanner: s.Name(),
+		layer:   l.Hash,
+	})
+}
+
+// Warn reports a recoverable problem to be attached to the IndexReport being
+// produced. Scanners should call this when skipping data they can't make sense
+// of, so that the report is marked as best-effort.
+//
+// The "path" argument is the path inside the layer the warning concerns, and
+// may be empty. Warn does no logging of its own.
+func Warn(ctx context.Context, path string, format string, args ...interface{}) {
+	w, ok := ctx.Value(warningKey{}).(*warningSink)
+	if !ok {
+		return
+	}
+	w.f(claircore.IndexWarning{
+		Scanner: w.scanner,
+		Layer:   w.layer,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
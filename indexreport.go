@@ -16,12 +16,19 @@ type IndexRecord struct {
 //
 // IndexReports make heavy usage of lookup maps to associate information
 // without repetition.
+//
+// See IndexReportVersion for how the JSON form is versioned.
 type IndexReport struct {
+	// the version of the JSON form; set to IndexReportVersion when encoded
+	SchemaVersion int `json:"schema_version"`
 	// the manifest hash this IndexReport is describing
 	Hash Digest `json:"manifest_hash"`
 	// the current state of the index operation
 	State string `json:"state"`
 	// all discovered packages in this manifest key'd by package id
+	//
+	// Where each package was found is recorded in its Environments; see
+	// the Provenance method.
 	Packages map[string]*Package `json:"packages"`
 	// all discovered distributions in this manifest key'd by distribution id
 	Distributions map[string]*Distribution `json:"distributions"`
@@ -30,9 +37,127 @@ type IndexReport struct {
 	// a list of environment details a package was discovered in key'd by package id
 	Environments map[string][]*Environment `json:"environments"`
 	// whether the index operation finished successfully
+	//
+	// A successful IndexReport may still have Warnings, in which case the
+	// contents are best-effort: scanners ran to completion, but skipped
+	// some data they couldn't make sense of.
 	Success bool `json:"success"`
 	// an error string in the case the index did not succeed
 	Err string `json:"err"`
+	// the scanners configured when this IndexReport was produced
+	Scanners []ScannerRecord `json:"scanners,omitempty"`
+	// recoverable problems scanners encountered in the manifest's layers
+	//
+	// Warnings from layers scanned for a different manifest are only
+	// included if the indexer's store keeps them.
+	Warnings []IndexWarning `json:"warnings,omitempty"`
+	// scanners that failed on a layer, when the indexer is configured to
+	// skip failing scanners rather than fail the index
+	ScannerErrors []ScannerError `json:"scanner_errors,omitempty"`
+	// the number of packages dropped by the indexer's package filter
+	//
+	// Only layers scanned while producing this IndexReport are counted;
+	// packages dropped when a layer was first scanned for a different
+	// manifest aren't.
+	FilteredPackages int `json:"filtered_packages,omitempty"`
+	// files owned by OS packages that don't match the package database,
+	// found when package verification is enabled
+	ModifiedFiles []ModifiedFile `json:"modified_files,omitempty"`
+	// details from the image's configuration, if the Manifest provided one
+	Image *ImageMetadata `json:"image,omitempty"`
+	// how the image was indexed: IndexLayered or IndexSquashed
+	//
+	// Reports from before this was recorded are empty, meaning layered.
+	IndexMode string `json:"index_mode,omitempty"`
+	// what made the index fail, if it did
+	Errors []IndexError `json:"errors,omitempty"`
+	// whether a failed index's contents are what was found before it failed,
+	// when the indexer is configured to report partial results
+	//
+	// A partial report leaves out anything in layers or from scanners that
+	// weren't done; see Errors for what failed.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// These are the ways an image can be indexed.
+const (
+	// IndexLayered has every layer scanned on its own and the results
+	// combined by each ecosystem's coalescer. Results from a layer are shared
+	// with every image containing it.
+	IndexLayered = "layered"
+	// IndexSquashed has the scanners run once over the image's merged
+	// filesystem, with whiteouts applied. Packages are reported as introduced
+	// in a synthetic layer named by the digest of the merged filesystem, so
+	// results aren't shared with other images and per-layer provenance is
+	// lost.
+	IndexSquashed = "squashed"
+)
+
+// IndexWarning describes a recoverable problem a scanner encountered, such as
+// a corrupt database entry that was skipped.
+type IndexWarning struct {
+	// the name of the scanner that reported the warning
+	Scanner string `json:"scanner"`
+	// the layer being scanned
+	Layer Digest `json:"layer"`
+	// the path inside the layer the warning concerns, if any
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// IndexError describes what made an index fail. Errors from a scanner name
+// the scanner and the layer it was scanning.
+type IndexError struct {
+	// the state the index failed in
+	State string `json:"state"`
+	// the name of the scanner that failed, if any
+	Scanner string `json:"scanner,omitempty"`
+	// the kind of the scanner that failed, if any
+	Kind string `json:"kind,omitempty"`
+	// the layer being scanned, if any
+	Layer *Digest `json:"layer,omitempty"`
+	// the error
+	Err string `json:"err"`
+}
+
+// ScannerError describes a scanner that failed on a layer, and so contributed
+// nothing from it to the IndexReport.
+type ScannerError struct {
+	// the name of the scanner that failed
+	Scanner string `json:"scanner"`
+	// the kind of the scanner that failed
+	Kind string `json:"kind"`
+	// the layer being scanned
+	Layer Digest `json:"layer"`
+	// the error the scanner reported
+	Err string `json:"err"`
+}
+
+// ModifiedFile describes a file owned by an OS package whose contents in the
+// image don't match the digest recorded in the package database.
+type ModifiedFile struct {
+	// the name of the package owning the file
+	Package string `json:"package"`
+	// the package database recording the file
+	PackageDB string `json:"package_db"`
+	// the file's path, relative to the root of the image
+	Path string `json:"path"`
+	// the layer that replaced or removed the file
+	Layer Digest `json:"layer"`
+	// the recorded digest, as "algorithm:hex"
+	Expected string `json:"expected"`
+	// the digest of the file in the image, as "algorithm:hex"; empty if the
+	// file was removed or replaced by something other than a regular file
+	Actual string `json:"actual,omitempty"`
+	// whether the file was removed by a whiteout
+	Removed bool `json:"removed,omitempty"`
+}
+
+// ScannerRecord identifies a scanner that contributed to an IndexReport.
+type ScannerRecord struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
 }
 
 // IndexRecords returns a list of IndexRecords derived from the IndexReport
@@ -0,0 +1,99 @@
+// Package lifecycle tracks in-flight operations so that a library can shut
+// down without pulling resources out from under them.
+package lifecycle
+
+import (
+	"context"
+	"sync"
+)
+
+// Tracker counts in-flight operations. The zero value is ready to use.
+//
+// Once Shutdown is called, Begin refuses new operations.
+type Tracker struct {
+	mu     sync.Mutex
+	closed bool
+	n      int
+	// Idle is closed once the count drops to zero after Shutdown is called.
+	idle chan struct{}
+	// Abort is closed when Shutdown gives up waiting.
+	abort chan struct{}
+}
+
+func (t *Tracker) init() {
+	if t.abort == nil {
+		t.abort = make(chan struct{})
+	}
+}
+
+// Begin registers a new operation, reporting false if the Tracker is shutting
+// down.
+//
+// The returned Context is canceled if Shutdown stops waiting for the
+// operation. The returned function must be called when the operation is
+// finished.
+func (t *Tracker) Begin(ctx context.Context) (context.Context, func(), bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.init()
+	if t.closed {
+		return ctx, func() {}, false
+	}
+	t.n++
+	ctx, cancel := context.WithCancel(ctx)
+	go func(abort <-chan struct{}) {
+		select {
+		case <-abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}(t.abort)
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			cancel()
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.n--
+			if t.n == 0 && t.idle != nil {
+				close(t.idle)
+				t.idle = nil
+			}
+		})
+	}, true
+}
+
+// Shutdown refuses new operations and waits for in-flight ones to finish.
+//
+// If the Context is done first, the Contexts of the remaining operations are
+// canceled and Shutdown waits for them to return before reporting the
+// Context's error. Operations are expected to respect cancellation.
+func (t *Tracker) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.init()
+	t.closed = true
+	if t.n == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+	t.mu.Lock()
+	select {
+	case <-t.abort:
+	default:
+		close(t.abort)
+	}
+	t.mu.Unlock()
+	<-idle
+	return ctx.Err()
+}
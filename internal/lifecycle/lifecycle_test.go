@@ -0,0 +1,56 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	t.Run("Drain", func(t *testing.T) {
+		var tr Tracker
+		_, done, ok := tr.Begin(context.Background())
+		if !ok {
+			t.Fatal("operation refused")
+		}
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			done()
+		}()
+		if err := tr.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+		if _, _, ok := tr.Begin(context.Background()); ok {
+			t.Error("operation allowed after Shutdown")
+		}
+	})
+	t.Run("Abort", func(t *testing.T) {
+		var tr Tracker
+		opCtx, done, ok := tr.Begin(context.Background())
+		if !ok {
+			t.Fatal("operation refused")
+		}
+		go func() {
+			<-opCtx.Done()
+			done()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := tr.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if opCtx.Err() == nil {
+			t.Error("operation not canceled")
+		}
+	})
+	t.Run("Idle", func(t *testing.T) {
+		var tr Tracker
+		_, done, _ := tr.Begin(context.Background())
+		done()
+		done() // Must be safe to call twice.
+		if err := tr.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+}
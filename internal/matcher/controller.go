@@ -28,7 +28,32 @@ func NewController(m driver.Matcher, store datastore.Vulnerability) *Controller
 	}
 }
 
-func (mc *Controller) Match(ctx context.Context, records []*claircore.IndexRecord) (map[string][]*claircore.Vulnerability, error) {
+// Matches is the result of a Controller's Match.
+type Matches struct {
+	// Vulnerabilities is the vulnerabilities affecting each package, keyed by
+	// package ID.
+	Vulnerabilities map[string][]*claircore.Vulnerability
+	// Details is how each of the vulnerabilities was matched, keyed by package
+	// ID.
+	Details map[string][]*claircore.MatchDetail
+}
+
+func newMatches() *Matches {
+	return &Matches{
+		Vulnerabilities: map[string][]*claircore.Vulnerability{},
+		Details:         map[string][]*claircore.MatchDetail{},
+	}
+}
+
+// Add records a match, filling in the detail's Vulnerability and Matcher.
+func (m *Matches) add(matcher, pkgID string, v *claircore.Vulnerability, d *claircore.MatchDetail) {
+	d.Vulnerability = v.ID
+	d.Matcher = matcher
+	m.Vulnerabilities[pkgID] = append(m.Vulnerabilities[pkgID], v)
+	m.Details[pkgID] = append(m.Details[pkgID], d)
+}
+
+func (mc *Controller) Match(ctx context.Context, records []*claircore.IndexRecord) (*Matches, error) {
 	ctx = zlog.ContextWithValues(ctx,
 		"component", "internal/matcher/Controller.Match",
 		"matcher", mc.m.Name())
@@ -41,16 +66,22 @@ func (mc *Controller) Match(ctx context.Context, records []*claircore.IndexRecor
 
 	// early return; do not call db at all
 	if len(interested) == 0 {
-		return map[string][]*claircore.Vulnerability{}, nil
+		return newMatches(), nil
 	}
 
 	remoteMatcher, matchedVulns, err := mc.queryRemoteMatcher(ctx, interested)
 	if remoteMatcher {
+		out := newMatches()
 		if err != nil {
 			zlog.Error(ctx).Err(err).Msg("remote matcher error, returning empty results")
-			return map[string][]*claircore.Vulnerability{}, nil
+			return out, nil
+		}
+		for id, vs := range matchedVulns {
+			for _, v := range vs {
+				out.add(mc.m.Name(), id, v, &claircore.MatchDetail{Method: claircore.MatchRemote})
+			}
 		}
-		return matchedVulns, nil
+		return out, nil
 	}
 
 	dbSide, authoritative := mc.dbFilter()
@@ -69,7 +100,18 @@ func (mc *Controller) Match(ctx context.Context, records []*claircore.IndexRecor
 		Msg("query")
 
 	if authoritative {
-		return vulns, nil
+		out := newMatches()
+		for _, r := range interested {
+			for _, v := range vulns[r.Package.ID] {
+				d := recordDetail(r, v)
+				if v.Range != nil {
+					// The database filtered on the range.
+					d.Method = claircore.MatchRange
+				}
+				out.add(mc.m.Name(), r.Package.ID, v, d)
+			}
+		}
+		return out, nil
 	}
 	// filter the vulns
 	filteredVulns, err := mc.filter(ctx, interested, vulns)
@@ -77,7 +119,7 @@ func (mc *Controller) Match(ctx context.Context, records []*claircore.IndexRecor
 		return nil, err
 	}
 	zlog.Debug(ctx).
-		Int("filtered", len(filteredVulns)).
+		Int("filtered", len(filteredVulns.Vulnerabilities)).
 		Msg("filtered")
 	return filteredVulns, nil
 }
@@ -134,29 +176,63 @@ func (mc *Controller) query(ctx context.Context, interested []*claircore.IndexRe
 
 // Filter method asks the matcher if the given package is affected by the returned vulnerability. if so; its added to a result map where the key is the package ID
 // and the value is a Vulnerability. if not it is not added to the result.
-func (mc *Controller) filter(ctx context.Context, interested []*claircore.IndexRecord, vulns map[string][]*claircore.Vulnerability) (map[string][]*claircore.Vulnerability, error) {
-	filtered := map[string][]*claircore.Vulnerability{}
+func (mc *Controller) filter(ctx context.Context, interested []*claircore.IndexRecord, vulns map[string][]*claircore.Vulnerability) (*Matches, error) {
+	filtered := newMatches()
 	for _, record := range interested {
-		match, err := filterVulns(ctx, mc.m, record, vulns[record.Package.ID])
-		if err != nil {
+		// Every interested record gets an entry, even if empty.
+		filtered.Vulnerabilities[record.Package.ID] = []*claircore.Vulnerability{}
+		if err := filterVulns(ctx, mc.m, record, vulns[record.Package.ID], filtered); err != nil {
 			return nil, err
 		}
-		filtered[record.Package.ID] = match
 	}
 	return filtered, nil
 }
 
-// filter returns only the vulnerabilities affected by the provided package.
-func filterVulns(ctx context.Context, m driver.Matcher, record *claircore.IndexRecord, vulns []*claircore.Vulnerability) ([]*claircore.Vulnerability, error) {
-	filtered := []*claircore.Vulnerability{}
+// filter adds only the vulnerabilities affected by the provided package to
+// "out".
+func filterVulns(ctx context.Context, m driver.Matcher, record *claircore.IndexRecord, vulns []*claircore.Vulnerability, out *Matches) error {
+	dm, detailed := m.(driver.MatchDetailer)
 	for _, vuln := range vulns {
-		match, err := m.Vulnerable(ctx, record, vuln)
+		var match bool
+		var d *claircore.MatchDetail
+		var err error
+		if detailed {
+			match, d, err = dm.VulnerableDetail(ctx, record, vuln)
+		} else {
+			match, err = m.Vulnerable(ctx, record, vuln)
+		}
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if !match {
+			continue
 		}
-		if match {
-			filtered = append(filtered, vuln)
+		if d == nil {
+			d = recordDetail(record, vuln)
 		}
+		out.add(m.Name(), record.Package.ID, vuln, d)
 	}
-	return filtered, nil
+	return nil
+}
+
+// RecordDetail is the MatchDetail for a Matcher that doesn't report one: the
+// record's distribution and repository are assumed to have been used, and the
+// method is inferred from the vulnerability.
+func recordDetail(r *claircore.IndexRecord, v *claircore.Vulnerability) *claircore.MatchDetail {
+	var d claircore.MatchDetail
+	if r.Distribution != nil {
+		d.Distribution = r.Distribution.ID
+	}
+	if r.Repository != nil {
+		d.Repository = r.Repository.ID
+	}
+	switch {
+	case v.FixedInVersion != "":
+		d.Method = claircore.MatchFixedVersion
+	case v.Range != nil:
+		d.Method = claircore.MatchRange
+	default:
+		d.Method = claircore.MatchUnfixed
+	}
+	return &d
 }
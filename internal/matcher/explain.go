@@ -0,0 +1,329 @@
+package matcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/pkg/cpe"
+	"github.com/quay/claircore/pkg/kmod"
+	"github.com/quay/claircore/pkg/pkgname"
+)
+
+// Decision is the record of how a single Matcher handled a set of
+// IndexRecords.
+type Decision struct {
+	// Matcher is the name of the Matcher.
+	Matcher string `json:"matcher"`
+	// Interested reports whether the Matcher's Filter method accepted any of
+	// the records. If false, nothing else in the Decision is populated.
+	Interested bool `json:"interested"`
+	// Records is the package ID of every record the Matcher was interested
+	// in.
+	Records []string `json:"records,omitempty"`
+	// Constraints is how the Matcher asked the store to narrow down
+	// vulnerabilities, as returned by its Query method.
+	Constraints []string `json:"constraints,omitempty"`
+	// Remote reports whether the Matcher is a driver.RemoteMatcher, in which
+	// case the Candidates and Filtered fields are not populated.
+	Remote bool `json:"remote,omitempty"`
+	// VersionFiltering reports whether the database was asked to filter
+	// vulnerabilities by version. Vulnerabilities removed by the database do
+	// not appear in Candidates.
+	VersionFiltering bool `json:"version_filtering,omitempty"`
+	// Authoritative reports whether the database filtering was used as-is,
+	// without consulting the Matcher's Vulnerable method.
+	Authoritative bool `json:"authoritative,omitempty"`
+	// Candidates is every vulnerability returned by the store.
+	Candidates []*claircore.Vulnerability `json:"candidates,omitempty"`
+	// Matched is every vulnerability the Matcher reported.
+	Matched []*claircore.Vulnerability `json:"matched,omitempty"`
+	// Filtered is every candidate the Matcher rejected, and why.
+	Filtered []Filtered `json:"filtered,omitempty"`
+	// VersionFiltered is every vulnerability the database removed when
+	// filtering by version. Only populated when VersionFiltering is set.
+	VersionFiltered []Filtered `json:"version_filtered,omitempty"`
+}
+
+// Filtered is a vulnerability that was returned by the store but not reported,
+// along with the reason.
+type Filtered struct {
+	Vulnerability *claircore.Vulnerability `json:"vulnerability"`
+	Reason        string                   `json:"reason"`
+	// PackageID is the ID of the record the vulnerability was rejected for.
+	PackageID string `json:"package_id,omitempty"`
+	// Comparison lays out the values that were compared.
+	Comparison *Comparison `json:"comparison,omitempty"`
+}
+
+// Comparison puts the fields of an IndexRecord next to the corresponding
+// fields of a Vulnerability.
+//
+// Matchers don't report why they reject a vulnerability, so this is every
+// value a Matcher would reasonably look at, not necessarily the one that made
+// the difference.
+type Comparison struct {
+	Package      Field `json:"package"`
+	Version      Field `json:"version"`
+	Distribution Field `json:"distribution"`
+	Repository   Field `json:"repository"`
+	CPE          Field `json:"cpe"`
+}
+
+// Field is a pair of compared values. An empty Vulnerability value means the
+// vulnerability doesn't constrain the field.
+type Field struct {
+	Record        string `json:"record"`
+	Vulnerability string `json:"vulnerability"`
+	// Normalized is set for package names that differ as-is, but are the
+	// same once normalized for the record's ecosystem, or once the kernel is
+	// removed from a kernel module package's name.
+	Normalized bool `json:"normalized,omitempty"`
+}
+
+// Differs reports whether the vulnerability constrains the field to a value
+// the record doesn't have.
+func (f Field) differs() bool {
+	return f.Vulnerability != "" && f.Record != f.Vulnerability
+}
+
+// Compare builds the Comparison for a record and vulnerability.
+func compare(r *claircore.IndexRecord, v *claircore.Vulnerability) *Comparison {
+	var c Comparison
+	if r.Package != nil {
+		c.Package.Record = r.Package.Name
+		c.Version.Record = r.Package.Version
+	}
+	if v.Package != nil {
+		c.Package.Vulnerability = v.Package.Name
+	}
+	if c.Package.differs() && r.Repository != nil {
+		eco := r.Repository.Name
+		c.Package.Normalized = pkgname.Supported(eco) &&
+			pkgname.Normalize(eco, c.Package.Record) == pkgname.Normalize(eco, c.Package.Vulnerability)
+	}
+	if r.Package != nil {
+		if base, ok := kmod.BaseName(r.Package); ok && base == c.Package.Vulnerability {
+			c.Package.Normalized = true
+		}
+	}
+	c.Version.Vulnerability = v.FixedInVersion
+	if v.Range != nil {
+		rng := fmt.Sprintf("[%s, %s)", v.Range.Lower.String(), v.Range.Upper.String())
+		c.Version.Vulnerability = strings.TrimSpace(c.Version.Vulnerability + " " + rng)
+	}
+	c.Distribution.Record = distString(r.Distribution)
+	c.Distribution.Vulnerability = distString(v.Dist)
+	if r.Repository != nil {
+		c.Repository.Record = r.Repository.Name
+		c.CPE.Record = cpeString(r.Repository.CPE)
+	}
+	if c.CPE.Record == "" && r.Distribution != nil {
+		c.CPE.Record = cpeString(r.Distribution.CPE)
+	}
+	if v.Repo != nil {
+		c.Repository.Vulnerability = v.Repo.Name
+		c.CPE.Vulnerability = cpeString(v.Repo.CPE)
+	}
+	if c.CPE.Vulnerability == "" && v.Dist != nil {
+		c.CPE.Vulnerability = cpeString(v.Dist.CPE)
+	}
+	return &c
+}
+
+// Reason describes a rejection in terms of the Comparison: the fields that
+// differ, or the versions if nothing else does.
+func (c *Comparison) reason(prefix string) string {
+	var diff []string
+	for _, f := range []struct {
+		name string
+		Field
+	}{
+		{"package name", c.Package},
+		{"distribution", c.Distribution},
+		{"repository", c.Repository},
+		{"cpe", c.CPE},
+	} {
+		switch {
+		case !f.differs():
+		case f.Normalized:
+			diff = append(diff, fmt.Sprintf("%s %q matches %q only after normalization", f.name, f.Record, f.Vulnerability))
+		default:
+			diff = append(diff, fmt.Sprintf("%s %q does not match %q", f.name, f.Record, f.Vulnerability))
+		}
+	}
+	if len(diff) == 0 {
+		diff = append(diff, fmt.Sprintf("version %q is not affected (vulnerability: %q)", c.Version.Record, c.Version.Vulnerability))
+	}
+	return prefix + ": " + strings.Join(diff, "; ")
+}
+
+func distString(d *claircore.Distribution) string {
+	if d == nil {
+		return ""
+	}
+	id := d.DID
+	if id == "" {
+		id = d.Name
+	}
+	return strings.TrimSpace(id + " " + d.VersionID)
+}
+
+func cpeString(w cpe.WFN) string {
+	if errors.Is(w.Valid(), cpe.ErrUnset) {
+		return ""
+	}
+	return w.String()
+}
+
+// ConstraintNames are the names of the driver.MatchConstraint values.
+var constraintNames = map[driver.MatchConstraint]string{
+	driver.PackageSourceName:           "PackageSourceName",
+	driver.PackageName:                 "PackageName",
+	driver.PackageModule:               "PackageModule",
+	driver.DistributionDID:             "DistributionDID",
+	driver.DistributionName:            "DistributionName",
+	driver.DistributionVersion:         "DistributionVersion",
+	driver.DistributionVersionCodeName: "DistributionVersionCodeName",
+	driver.DistributionVersionID:       "DistributionVersionID",
+	driver.DistributionArch:            "DistributionArch",
+	driver.DistributionCPE:             "DistributionCPE",
+	driver.DistributionPrettyName:      "DistributionPrettyName",
+	driver.RepositoryName:              "RepositoryName",
+}
+
+func constraintName(c driver.MatchConstraint) string {
+	if n, ok := constraintNames[c]; ok {
+		return n
+	}
+	return fmt.Sprintf("MatchConstraint(%d)", int(c))
+}
+
+// Select returns a copy of the Decision with only the vulnerabilities with
+// the provided name.
+func (d *Decision) Select(name string) *Decision {
+	out := *d
+	pick := func(vs []*claircore.Vulnerability) []*claircore.Vulnerability {
+		var r []*claircore.Vulnerability
+		for _, v := range vs {
+			if v.Name == name {
+				r = append(r, v)
+			}
+		}
+		return r
+	}
+	pickF := func(fs []Filtered) []Filtered {
+		var r []Filtered
+		for _, f := range fs {
+			if f.Vulnerability.Name == name {
+				r = append(r, f)
+			}
+		}
+		return r
+	}
+	out.Candidates = pick(d.Candidates)
+	out.Matched = pick(d.Matched)
+	out.Filtered = pickF(d.Filtered)
+	out.VersionFiltered = pickF(d.VersionFiltered)
+	return &out
+}
+
+// Explain runs the records through the Matcher the same way Match does,
+// returning a Decision recording each step.
+//
+// Unlike Match, errors from a remote matcher are returned.
+func (mc *Controller) Explain(ctx context.Context, records []*claircore.IndexRecord) (*Decision, error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "internal/matcher/Controller.Explain",
+		"matcher", mc.m.Name())
+	d := Decision{Matcher: mc.m.Name()}
+	interested := mc.findInterested(records)
+	if len(interested) == 0 {
+		return &d, nil
+	}
+	d.Interested = true
+	for _, r := range interested {
+		d.Records = append(d.Records, r.Package.ID)
+	}
+
+	remoteMatcher, matchedVulns, err := mc.queryRemoteMatcher(ctx, interested)
+	if remoteMatcher {
+		d.Remote = true
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range interested {
+			d.Matched = append(d.Matched, matchedVulns[r.Package.ID]...)
+		}
+		return &d, nil
+	}
+
+	for _, c := range mc.m.Query() {
+		d.Constraints = append(d.Constraints, constraintName(c))
+	}
+	d.VersionFiltering, d.Authoritative = mc.dbFilter()
+	vulns, err := mc.query(ctx, interested, d.VersionFiltering)
+	if err != nil {
+		return nil, err
+	}
+	if d.VersionFiltering {
+		// Ask again without the version filtering, to find out what the
+		// database removed.
+		all, err := mc.query(ctx, interested, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range interested {
+			kept := make(map[string]struct{}, len(vulns[r.Package.ID]))
+			for _, v := range vulns[r.Package.ID] {
+				kept[v.ID] = struct{}{}
+			}
+			for _, v := range all[r.Package.ID] {
+				if _, ok := kept[v.ID]; ok {
+					continue
+				}
+				c := compare(r, v)
+				d.VersionFiltered = append(d.VersionFiltered, Filtered{
+					Vulnerability: v,
+					Reason:        c.reason("removed by database version filtering"),
+					PackageID:     r.Package.ID,
+					Comparison:    c,
+				})
+			}
+		}
+	}
+	for _, r := range interested {
+		for _, v := range vulns[r.Package.ID] {
+			d.Candidates = append(d.Candidates, v)
+			if d.Authoritative {
+				d.Matched = append(d.Matched, v)
+				continue
+			}
+			ok, err := mc.m.Vulnerable(ctx, r, v)
+			switch {
+			case err != nil:
+				return nil, err
+			case ok:
+				d.Matched = append(d.Matched, v)
+			default:
+				c := compare(r, v)
+				d.Filtered = append(d.Filtered, Filtered{
+					Vulnerability: v,
+					Reason:        c.reason("matcher reported package not vulnerable"),
+					PackageID:     r.Package.ID,
+					Comparison:    c,
+				})
+			}
+		}
+	}
+	zlog.Debug(ctx).
+		Int("candidates", len(d.Candidates)).
+		Int("matched", len(d.Matched)).
+		Msg("explained")
+	return &d, nil
+}
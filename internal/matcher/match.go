@@ -26,13 +26,13 @@ func Match(ctx context.Context, ir *claircore.IndexReport, matchers []driver.Mat
 		Repositories:           ir.Repositories,
 		Vulnerabilities:        map[string]*claircore.Vulnerability{},
 		PackageVulnerabilities: map[string][]string{},
+		MatchDetails:           map[string][]*claircore.MatchDetail{},
 	}
 
 	// extract IndexRecords from the IndexReport
 	records := ir.IndexRecords()
 	// a channel where concurrent controllers will deliver vulnerabilities affecting a package.
-	// maps a package id to a list of vulnerabilities.
-	ctrlC := make(chan map[string][]*claircore.Vulnerability, 1024)
+	ctrlC := make(chan *Matches, 1024)
 	// a channel where controller errors will be reported
 	errorC := make(chan error, 1024)
 	// fan out all controllers, write their output to ctrlC, close ctrlC once all writers finish
@@ -57,13 +57,8 @@ func Match(ctx context.Context, ir *claircore.IndexReport, matchers []driver.Mat
 		}
 	}()
 	// loop ranges until ctrlC is closed and fully drained, ctrlC is guaranteed to close
-	for vulnsByPackage := range ctrlC {
-		for pkgID, vulns := range vulnsByPackage {
-			for _, vuln := range vulns {
-				vr.Vulnerabilities[vuln.ID] = vuln
-				vr.PackageVulnerabilities[pkgID] = append(vr.PackageVulnerabilities[pkgID], vuln.ID)
-			}
-		}
+	for ms := range ctrlC {
+		addMatches(vr, ms)
 	}
 	select {
 	case err := <-errorC:
@@ -73,6 +68,20 @@ func Match(ctx context.Context, ir *claircore.IndexReport, matchers []driver.Mat
 	return vr, nil
 }
 
+// AddMatches adds the vulnerabilities a Controller found, and how they were
+// matched, to the report.
+func addMatches(vr *claircore.VulnerabilityReport, ms *Matches) {
+	for pkgID, vulns := range ms.Vulnerabilities {
+		for _, vuln := range vulns {
+			vr.Vulnerabilities[vuln.ID] = vuln
+			vr.PackageVulnerabilities[pkgID] = append(vr.PackageVulnerabilities[pkgID], vuln.ID)
+		}
+	}
+	for pkgID, ds := range ms.Details {
+		vr.MatchDetails[pkgID] = append(vr.MatchDetails[pkgID], ds...)
+	}
+}
+
 // Store is the interface that can retrieve Enrichments and Vulnerabilities.
 type Store interface {
 	datastore.Vulnerability
@@ -91,6 +100,7 @@ func EnrichedMatch(ctx context.Context, ir *claircore.IndexReport, ms []driver.M
 		Repositories:           ir.Repositories,
 		Vulnerabilities:        map[string]*claircore.Vulnerability{},
 		PackageVulnerabilities: map[string][]string{},
+		MatchDetails:           map[string][]*claircore.MatchDetail{},
 		// The Enrichments member isn't constructed here because it's
 		// constructed separately and then added.
 	}
@@ -100,7 +110,7 @@ func EnrichedMatch(ctx context.Context, ir *claircore.IndexReport, ms []driver.M
 
 	// Set up a pool to run matchers
 	mCh := make(chan driver.Matcher)
-	vCh := make(chan map[string][]*claircore.Vulnerability, lim)
+	vCh := make(chan *Matches, lim)
 	mg, mctx := errgroup.WithContext(ctx) // match group, match context
 	for i := 0; i < lim; i++ {
 		mg.Go(func() error { // Worker
@@ -139,13 +149,8 @@ func EnrichedMatch(ctx context.Context, ir *claircore.IndexReport, ms []driver.M
 		return nil
 	})
 	vg.Go(func() error { // Collector
-		for pkgVuln := range vCh {
-			for pkg, vs := range pkgVuln {
-				for _, v := range vs {
-					vr.Vulnerabilities[v.ID] = v
-					vr.PackageVulnerabilities[pkg] = append(vr.PackageVulnerabilities[pkg], v.ID)
-				}
-			}
+		for ms := range vCh {
+			addMatches(vr, ms)
 		}
 		return nil
 	})
@@ -0,0 +1,382 @@
+// Package pkgverify checks files owned by OS packages against the digests
+// recorded in the package databases.
+//
+// Only dpkg and SQLite RPM databases are supported, as those are the formats
+// that can be read without pulling in the whole database machinery.
+package pkgverify
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/pkg/squash"
+	"github.com/quay/claircore/rpm/sqlite"
+)
+
+// Verify reports files belonging to the named packages whose contents in the
+// image don't match the package database.
+//
+// The layers must be fetched and in image order. A change is attributed to
+// the topmost layer that replaced or whited out the file. Files listed in the
+// database but absent from every layer are ignored, as images commonly
+// exclude documentation and the like at install time.
+func Verify(ctx context.Context, layers []*claircore.Layer, names []string) ([]claircore.ModifiedFile, error) {
+	ctx = zlog.ContextWithValues(ctx, "component", "internal/pkgverify/Verify")
+	if len(names) == 0 || len(layers) == 0 {
+		return nil, nil
+	}
+	img, err := openImage(layers)
+	if err != nil {
+		return nil, err
+	}
+	defer img.Close()
+
+	want, err := dpkgFiles(ctx, img, names)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := rpmFiles(ctx, img, names)
+	if err != nil {
+		return nil, err
+	}
+	for p, e := range rs {
+		want[p] = e
+	}
+	ps := make([]string, 0, len(want))
+	for p := range want {
+		ps = append(ps, p)
+	}
+	sort.Strings(ps)
+
+	var out []claircore.ModifiedFile
+	for _, p := range ps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		e := want[p]
+		i, st := img.Lookup(p)
+		mf := claircore.ModifiedFile{
+			Package:   e.Package,
+			PackageDB: e.DB,
+			Path:      p,
+			Expected:  e.Algo + ":" + e.Digest,
+		}
+		switch st {
+		case missing:
+			continue
+		case removed:
+			mf.Removed = true
+		case other:
+		case regular:
+			got, err := img.Hash(i, p, e.Algo)
+			if err != nil {
+				return nil, fmt.Errorf("pkgverify: unable to hash %q: %w", p, err)
+			}
+			switch got {
+			case e.Digest:
+				continue
+			case "":
+			default:
+				mf.Actual = e.Algo + ":" + got
+			}
+		}
+		mf.Layer = img.layers[i].Hash
+		out = append(out, mf)
+	}
+	zlog.Debug(ctx).
+		Int("checked", len(ps)).
+		Int("modified", len(out)).
+		Msg("verified package files")
+	return out, nil
+}
+
+// Expect is a recorded digest for a file.
+type expect struct {
+	Package string
+	DB      string
+	Algo    string
+	Digest  string
+}
+
+// DpkgFiles collects the recorded digests for the named packages from the
+// "md5sums" files in the dpkg database.
+func dpkgFiles(ctx context.Context, img *image, names []string) (map[string]expect, error) {
+	const (
+		info   = `var/lib/dpkg/info`
+		db     = `var/lib/dpkg/status`
+		suffix = `.md5sums`
+	)
+	out := make(map[string]expect)
+	for _, n := range names {
+		// Multi-arch packages have their architecture in the file name.
+		ms, err := img.Glob(path.Join(info, n+suffix), path.Join(info, n+":*"+suffix))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range ms {
+			i, st := img.Lookup(m)
+			if st != regular {
+				continue
+			}
+			b, err := fs.ReadFile(img.sq.Layer(i), m)
+			if err != nil {
+				return nil, fmt.Errorf("pkgverify: unable to read %q: %w", m, err)
+			}
+			s := bufio.NewScanner(bytes.NewReader(b))
+			for s.Scan() {
+				l := s.Text()
+				sum, p, ok := cutSpace(l)
+				if !ok {
+					zlog.Debug(ctx).
+						Str("file", m).
+						Str("line", l).
+						Msg("malformed md5sums line")
+					continue
+				}
+				out[path.Clean(strings.TrimPrefix(p, "/"))] = expect{
+					Package: n,
+					DB:      db,
+					Algo:    "md5",
+					Digest:  strings.ToLower(sum),
+				}
+			}
+			if err := s.Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// CutSpace splits an "md5sums" line into the digest and path.
+func cutSpace(l string) (string, string, bool) {
+	i := strings.IndexByte(l, ' ')
+	if i == -1 {
+		return "", "", false
+	}
+	p := strings.TrimLeft(l[i:], " ")
+	if p == "" {
+		return "", "", false
+	}
+	return l[:i], p, true
+}
+
+// RpmFiles collects the recorded digests for the named packages from any
+// SQLite RPM database in the image.
+func rpmFiles(ctx context.Context, img *image, names []string) (map[string]expect, error) {
+	out := make(map[string]expect)
+	for _, dir := range []string{`var/lib/rpm`, `usr/lib/sysimage/rpm`} {
+		p := path.Join(dir, `rpmdb.sqlite`)
+		i, st := img.Lookup(p)
+		if st != regular {
+			continue
+		}
+		pfs, err := readRPM(ctx, img.sq.Layer(i), p, names)
+		if err != nil {
+			return nil, fmt.Errorf("pkgverify: unable to read %q: %w", p, err)
+		}
+		for _, pf := range pfs {
+			algo, ok := rpmAlgo[pf.DigestAlgo]
+			if !ok {
+				zlog.Debug(ctx).
+					Str("package", pf.Name).
+					Int("algo", pf.DigestAlgo).
+					Msg("unsupported digest algorithm")
+				continue
+			}
+			for _, f := range pf.Files {
+				out[strings.TrimPrefix(f.Path, "/")] = expect{
+					Package: pf.Name,
+					DB:      "sqlite:" + dir,
+					Algo:    algo,
+					Digest:  strings.ToLower(f.Digest),
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// ReadRPM copies the database out of the layer, as the SQLite library needs
+// a file on disk.
+func readRPM(ctx context.Context, sys fs.FS, p string, names []string) ([]sqlite.PackageFiles, error) {
+	r, err := sys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	f, err := os.CreateTemp(os.TempDir(), `rpmdb.sqlite.*`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.Remove(f.Name()); err != nil {
+			zlog.Error(ctx).Err(err).Msg("unable to unlink sqlite db")
+		}
+	}()
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	db, err := sqlite.Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return db.Files(ctx, names...)
+}
+
+// RpmAlgo maps RPM hash algorithm numbers to names.
+var rpmAlgo = map[int]string{
+	1:  "md5",
+	2:  "sha1",
+	8:  "sha256",
+	9:  "sha384",
+	10: "sha512",
+	11: "sha224",
+}
+
+// NewHash returns a hash for the named algorithm.
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha224":
+		return sha256.New224(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unknown algorithm %q", algo)
+}
+
+// Image is the stack of layer filesystems.
+type image struct {
+	layers []*claircore.Layer
+	sq     *squash.FS
+}
+
+func openImage(ls []*claircore.Layer) (*image, error) {
+	sq, err := squash.Open(ls)
+	if err != nil {
+		return nil, fmt.Errorf("pkgverify: %w", err)
+	}
+	return &image{layers: ls, sq: sq}, nil
+}
+
+// Close releases the layer readers.
+func (img *image) Close() error {
+	return img.sq.Close()
+}
+
+// Glob reports the union of the patterns' matches across all layers.
+func (img *image) Glob(pats ...string) ([]string, error) {
+	seen := make(map[string]struct{})
+	var out []string
+	for i := 0; i < img.sq.Layers(); i++ {
+		sys := img.sq.Layer(i)
+		for _, pat := range pats {
+			ms, err := fs.Glob(sys, pat)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range ms {
+				if _, ok := seen[m]; ok {
+					continue
+				}
+				seen[m] = struct{}{}
+				out = append(out, m)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Status is the result of looking up a path in the merged view.
+type status int
+
+const (
+	missing status = iota
+	regular
+	// Other means the path is something other than a regular file, like a
+	// directory or symlink.
+	other
+	removed
+)
+
+// Lookup finds the topmost layer with an opinion about the path: either it
+// contains the path, or it whites out the path or a parent directory.
+func (img *image) Lookup(p string) (int, status) {
+	i, st := img.sq.Lookup(p)
+	switch st {
+	case squash.Removed:
+		return i, removed
+	case squash.Missing:
+		return i, missing
+	}
+	fi, err := fs.Stat(img.sq.Layer(i), p)
+	if err == nil && fi.Mode().IsRegular() {
+		return i, regular
+	}
+	return i, other
+}
+
+// Hash reports the hex digest of the path in the specified layer.
+func (img *image) Hash(i int, p, algo string) (string, error) {
+	h, err := newHash(algo)
+	if err != nil {
+		return "", err
+	}
+	sys := img.sq.Layer(i)
+	// Hard links have no contents of their own.
+	fi, err := fs.Stat(sys, p)
+	if err != nil {
+		return "", err
+	}
+	if th, ok := fi.Sys().(*tar.Header); ok && th.Typeflag == tar.TypeLink {
+		p = path.Clean(strings.TrimPrefix(th.Linkname, "/"))
+	}
+	f, err := sys.Open(p)
+	switch {
+	case errors.Is(err, nil):
+	case errors.Is(err, fs.ErrNotExist):
+		// A hard link into a lower layer isn't valid, so count this as
+		// modified.
+		return "", nil
+	default:
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,151 @@
+package pkgverify
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/test"
+)
+
+// Entry is a tar entry to put in a test layer.
+type entry struct {
+	Name     string
+	Contents string
+	Link     string
+}
+
+func mkLayer(t *testing.T, es ...entry) *claircore.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range es {
+		h := tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     e.Name,
+			Size:     int64(len(e.Contents)),
+			Mode:     0o644,
+		}
+		if e.Link != "" {
+			h.Typeflag = tar.TypeLink
+			h.Linkname = e.Link
+			h.Size = 0
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.Contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l := &claircore.Layer{Hash: test.RandomSHA256Digest(t)}
+	if err := l.SetBuffer(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func md5sum(s string) string {
+	b := md5.Sum([]byte(s))
+	return hex.EncodeToString(b[:])
+}
+
+func md5sums(files ...string) string {
+	var b bytes.Buffer
+	for _, f := range files {
+		fmt.Fprintf(&b, "%s  %s\n", md5sum(f), f)
+	}
+	return b.String()
+}
+
+func TestVerify(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	// The contents of each file are its name, to make the md5sums files easy
+	// to write.
+	ls := []*claircore.Layer{
+		mkLayer(t,
+			entry{Name: "var/lib/dpkg/info/bash.md5sums", Contents: md5sums("bin/bash", "usr/share/doc/bash/README")},
+			entry{Name: "var/lib/dpkg/info/libc6:amd64.md5sums", Contents: md5sums("lib/libc.so.6", "lib/ld.so")},
+			entry{Name: "var/lib/dpkg/info/tzdata.md5sums", Contents: md5sums("usr/share/zoneinfo/UTC")},
+			entry{Name: "var/lib/dpkg/info/coreutils.md5sums", Contents: md5sums("usr/bin/ls")},
+			entry{Name: "bin/bash", Contents: "bin/bash"},
+			entry{Name: "lib/libc.so.6", Contents: "lib/libc.so.6"},
+			entry{Name: "lib/ld.so", Contents: "lib/ld.so"},
+			entry{Name: "usr/share/zoneinfo/UTC", Contents: "usr/share/zoneinfo/UTC"},
+			entry{Name: "usr/bin/ls", Contents: "usr/bin/ls"},
+		),
+		// Replaces a file in a selected package and one in an unselected
+		// package.
+		mkLayer(t,
+			entry{Name: "bin/bash", Contents: "#!/bin/evil"},
+			entry{Name: "usr/bin/ls", Contents: "#!/bin/evil"},
+		),
+		mkLayer(t,
+			entry{Name: "lib/.wh.ld.so"},
+		),
+		// Hides the zoneinfo directory and reinstalls libc with a hard link.
+		mkLayer(t,
+			entry{Name: "usr/share/zoneinfo/.wh..wh..opq"},
+			entry{Name: "usr/share/zoneinfo/Other", Contents: "Other"},
+			entry{Name: "lib/libc-2.31.so", Contents: "lib/libc.so.6"},
+			entry{Name: "lib/libc.so.6", Link: "lib/libc-2.31.so"},
+		),
+	}
+
+	got, err := Verify(ctx, ls, []string{"bash", "libc6", "tzdata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const db = `var/lib/dpkg/status`
+	want := []claircore.ModifiedFile{
+		{
+			Package:   "bash",
+			PackageDB: db,
+			Path:      "bin/bash",
+			Layer:     ls[1].Hash,
+			Expected:  "md5:" + md5sum("bin/bash"),
+			Actual:    "md5:" + md5sum("#!/bin/evil"),
+		},
+		{
+			Package:   "libc6",
+			PackageDB: db,
+			Path:      "lib/ld.so",
+			Layer:     ls[2].Hash,
+			Expected:  "md5:" + md5sum("lib/ld.so"),
+			Removed:   true,
+		},
+		{
+			Package:   "tzdata",
+			PackageDB: db,
+			Path:      "usr/share/zoneinfo/UTC",
+			Layer:     ls[3].Hash,
+			Expected:  "md5:" + md5sum("usr/share/zoneinfo/UTC"),
+			Removed:   true,
+		},
+	}
+	if !cmp.Equal(got, want, cmp.Comparer(func(a, b claircore.Digest) bool { return a.String() == b.String() })) {
+		t.Error(cmp.Diff(got, want))
+	}
+
+	t.Run("None", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		got, err := Verify(ctx, ls, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Errorf("got findings with no packages selected: %v", got)
+		}
+	})
+}
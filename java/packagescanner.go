@@ -28,6 +28,7 @@ import (
 var (
 	_ indexer.VersionedScanner = (*Scanner)(nil)
 	_ indexer.PackageScanner   = (*Scanner)(nil)
+	_ indexer.PortableScanner  = (*Scanner)(nil)
 	_ indexer.RPCScanner       = (*Scanner)(nil)
 )
 
@@ -60,6 +61,9 @@ func (*Scanner) Version() string { return "3" }
 // Kind implements scanner.VersionedScanner.
 func (*Scanner) Kind() string { return "package" }
 
+// Portable implements indexer.PortableScanner.
+func (*Scanner) Portable() {}
+
 // Configure implements indexer.RPCScanner.
 func (s *Scanner) Configure(ctx context.Context, f indexer.ConfigDeserializer, c *http.Client) error {
 	ctx = zlog.ContextWithValues(ctx,
@@ -151,6 +155,7 @@ func (s *Scanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*claircor
 			zlog.Info(ctx).
 				Err(err).
 				Msg("not actually a jar: invalid zip")
+			indexer.Warn(ctx, n, "unable to read jar: %v", err)
 			continue
 		default:
 			return nil, err
@@ -19,6 +19,7 @@ import (
 var (
 	_ indexer.VersionedScanner = (*Scanner)(nil)
 	_ indexer.PackageScanner   = (*Scanner)(nil)
+	_ indexer.PortableScanner  = (*RepoScanner)(nil)
 
 	Repository = claircore.Repository{
 		Name: "maven",
@@ -37,6 +38,9 @@ func (*RepoScanner) Version() string { return "0.0.1" }
 // Kind implements scanner.VersionedScanner.
 func (*RepoScanner) Kind() string { return "repository" }
 
+// Portable implements indexer.PortableScanner.
+func (*RepoScanner) Portable() {}
+
 // Scan attempts to find jar, war or ear and record the package
 // information there.
 //
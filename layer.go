@@ -20,17 +20,126 @@ type Layer struct {
 	Hash    Digest              `json:"hash"`
 	URI     string              `json:"uri"`
 	Headers map[string][]string `json:"headers"`
+	// Repository, if set and URI isn't, is the registry repository to pull
+	// the layer from, like "quay.io/projectquay/clair". The blob URL is found
+	// from it and Hash using the OCI distribution spec. Repositories without
+	// a registry host are on Docker Hub.
+	Repository string `json:"repository,omitempty"`
+	// MediaType, if set, is the media type from the layer's OCI descriptor.
+	// It's used to decode the layer instead of the Content-Type the server
+	// reports.
+	MediaType string `json:"media_type,omitempty"`
+	// Size, if non-zero, is the size of the blob from the layer's OCI
+	// descriptor.
+	Size int64 `json:"size,omitempty"`
+	// Mirrors, if not empty, are other places to fetch the layer from. They're
+	// tried before URI (or Repository), falling back to the next on failure.
+	// See LayerMirror for the order they're tried in.
+	Mirrors []LayerMirror `json:"mirrors,omitempty"`
+	// UncompressedSize, if non-zero, is the expected size of the layer's
+	// tar stream after any decompression.
+	UncompressedSize int64 `json:"uncompressed_size,omitempty"`
+	// ExpectedDiffID, if set, is the digest the layer's uncompressed tar
+	// stream must have, as listed in the image configuration's
+	// "rootfs.diff_ids". Fetching a layer that decompresses to something
+	// else fails, even if the compressed blob matched Hash.
+	ExpectedDiffID *Digest `json:"expected_diff_id,omitempty"`
+	// AcceptableDigests, if not empty, are additional digests the layer's
+	// contents may be verified against. A fetch is valid if it matches Hash
+	// or any of these. This is meant for migrating between digest algorithms.
+	AcceptableDigests []Digest `json:"acceptable_digests,omitempty"`
+	// OS, if set, is the operating system the layer is for, using the values
+	// of the "os" field of an OCI image configuration: "linux", "windows",
+	// etc. If unset, the Manifest's OS is used, and failing that the layer's
+	// contents are examined.
+	OS string `json:"os,omitempty"`
 
+	// digest the fetched contents were verified against
+	verified Digest
+	// digest of the uncompressed tar stream, if computed
+	diffID Digest
 	// path to local file containing uncompressed tar archive of the layer's content
 	localPath string
+	// in-memory uncompressed tar archive of the layer's content, used
+	// instead of localPath if not nil
+	buf []byte
+	// opens the uncompressed tar archive of the layer's content as a stream,
+	// used instead of localPath if not nil
+	open func() (io.ReadCloser, error)
+}
+
+// LayerMirror is another place a Layer can be fetched from, such as a
+// pull-through cache.
+//
+// Mirrors are tried in order of Priority, lowest first. Mirrors with the same
+// Priority are tried in a random order, each one's chance of going first being
+// proportional to its Weight. Weights less than one count as one.
+type LayerMirror struct {
+	URI string `json:"uri"`
+	// Headers are sent instead of the Layer's, which are usually meant for
+	// the upstream registry.
+	Headers  map[string][]string `json:"headers,omitempty"`
+	Priority int                 `json:"priority,omitempty"`
+	Weight   int                 `json:"weight,omitempty"`
 }
 
 func (l *Layer) SetLocal(f string) error {
 	l.localPath = f
+	l.buf = nil
+	l.open = nil
+	return nil
+}
+
+// SetBuffer sets the layer's content to the provided uncompressed tar archive,
+// held in memory. It's an alternative to SetLocal for small layers.
+//
+// The slice must not be modified afterwards.
+func (l *Layer) SetBuffer(b []byte) error {
+	l.buf = b
+	l.localPath = ""
+	l.open = nil
+	return nil
+}
+
+// SetStream sets the layer's content to the uncompressed tar archive returned
+// by "open", which is called every time the content is read. It's an
+// alternative to SetLocal for layers stored compressed.
+func (l *Layer) SetStream(open func() (io.ReadCloser, error)) error {
+	l.open = open
+	l.localPath = ""
+	l.buf = nil
 	return nil
 }
 
+// SetVerified records the digest the layer's contents were verified against.
+func (l *Layer) SetVerified(d Digest) {
+	l.verified = d
+}
+
+// Verified reports the digest the layer's contents were verified against, if
+// known. This is Hash unless one of the AcceptableDigests matched instead.
+func (l *Layer) Verified() (Digest, bool) {
+	return l.verified, l.verified.Checksum() != nil
+}
+
+// SetDiffID records the digest of the layer's uncompressed tar stream.
+func (l *Layer) SetDiffID(d Digest) {
+	l.diffID = d
+}
+
+// DiffID reports the digest of the layer's uncompressed tar stream, if known.
+// This is the layer's entry in an image configuration's "rootfs.diff_ids".
+//
+// Verified reports the digest of the layer as fetched, which for a compressed
+// layer is the digest of the compressed bytes.
+func (l *Layer) DiffID() (Digest, bool) {
+	return l.diffID, l.diffID.Checksum() != nil
+}
+
 func (l *Layer) Fetched() bool {
+	if l.buf != nil || l.open != nil {
+		return true
+	}
 	_, err := os.Stat(l.localPath)
 	return err == nil
 }
@@ -38,7 +147,17 @@ func (l *Layer) Fetched() bool {
 // Reader returns a ReadAtCloser of the layer.
 //
 // It should also implement io.Seeker, and should be a tar stream.
+//
+// For a layer set with SetStream, the stream is copied into a temporary file
+// that's removed on Close. Callers that read the layer from start to end
+// should use Stream instead.
 func (l *Layer) Reader() (ReadAtCloser, error) {
+	if l.buf != nil {
+		return bufferReader{bytes.NewReader(l.buf)}, nil
+	}
+	if l.open != nil {
+		return l.spool()
+	}
 	if l.localPath == "" {
 		return nil, fmt.Errorf("claircore: Layer not fetched")
 	}
@@ -49,12 +168,70 @@ func (l *Layer) Reader() (ReadAtCloser, error) {
 	return f, nil
 }
 
+// Stream returns a ReadCloser of the layer's tar stream.
+//
+// Unlike Reader, this doesn't need the whole layer to be at hand, so it's the
+// cheaper way to read a layer set with SetStream.
+func (l *Layer) Stream() (io.ReadCloser, error) {
+	if l.open == nil {
+		return l.Reader()
+	}
+	rc, err := l.open()
+	if err != nil {
+		return nil, fmt.Errorf("claircore: unable to open layer: %w", err)
+	}
+	return rc, nil
+}
+
+// Spool copies the layer's stream into a temporary file.
+func (l *Layer) spool() (ReadAtCloser, error) {
+	rc, err := l.Stream()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	f, err := os.CreateTemp("", "layer.*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("claircore: unable to create file: %w", err)
+	}
+	tf := &tempFile{f}
+	if _, err := io.Copy(f, rc); err != nil {
+		tf.Close()
+		return nil, fmt.Errorf("claircore: unable to read layer: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		tf.Close()
+		return nil, err
+	}
+	return tf, nil
+}
+
+// TempFile is a file that's removed on Close.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
 // ReadAtCloser is an io.ReadCloser and also an io.ReaderAt
 type ReadAtCloser interface {
 	io.ReadCloser
 	io.ReaderAt
 }
 
+// BufferReader adds a no-op Close method to a bytes.Reader.
+type bufferReader struct {
+	*bytes.Reader
+}
+
+func (bufferReader) Close() error { return nil }
+
 // NormalizeIn is used to make sure paths are tar-root relative.
 func normalizeIn(in, p string) string {
 	p = filepath.Clean(p)
@@ -88,7 +265,7 @@ func (l *Layer) Files(paths ...string) (map[string]*bytes.Buffer, error) {
 		return nil, err
 	}
 	defer r.Close()
-	sys, err := tarfs.New(r.(*os.File))
+	sys, err := tarfs.New(r)
 	if err != nil {
 		return nil, err
 	}
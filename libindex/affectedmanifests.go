@@ -0,0 +1,230 @@
+package libindex
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/quay/zlog"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/pkg/omnimatcher"
+)
+
+// DefaultAffectedPageSize is the number of manifests in a page of affected
+// manifests if AffectedOptions.Limit isn't set.
+const DefaultAffectedPageSize = 1000
+
+// AffectedOptions narrows and pages the manifests reported by
+// AffectedManifestsPage and AffectedManifestsFunc.
+type AffectedOptions struct {
+	// Packages, if not empty, limits the vulnerabilities looked at to the
+	// ones for packages with these names.
+	Packages []string
+	// After is where to pick up from: the cursor returned with the previous
+	// page. If empty, the first page is returned.
+	After string
+	// Limit is the most manifests to return in a page. If 0,
+	// DefaultAffectedPageSize is used.
+	Limit int
+}
+
+// AffectedManifestsPage is AffectedManifests a page at a time, for callers
+// that can't hold every affected manifest in memory at once.
+//
+// Manifests are reported in order of their digests' string forms. Along with
+// the page, the cursor to pass as AffectedOptions.After for the next page is
+// returned; it's empty once there are no more pages. If the Store implements
+// indexer.AffectedManifestsPager, pages are cut by the Store; otherwise every
+// affected manifest is found and the page is cut from them.
+func (l *Libindex) AffectedManifestsPage(ctx context.Context, vulns []claircore.Vulnerability, opts AffectedOptions) (*claircore.AffectedManifests, string, error) {
+	ctx = zlog.ContextWithValues(ctx, "component", "libindex/Libindex.AffectedManifestsPage")
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer done()
+	affected, next, err := l.affectedPage(ctx, vulns, &opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if s, ok := l.store.(indexer.ImageIndexStore); ok && len(affected.VulnerableManifests) != 0 {
+		if err := addIndexes(ctx, s, affected); err != nil {
+			return nil, "", fmt.Errorf("received error retrieving affected image indexes: %v", err)
+		}
+	}
+	affected.Sort()
+	return affected, next, nil
+}
+
+// AffectedManifestsFunc calls "f" with each manifest affected by the
+// vulnerabilities, along with the vulnerabilities affecting it, in the order
+// AffectedManifestsPage reports them. Only a page of manifests is held in
+// memory at once; AffectedOptions.Limit sets the size of the pages.
+//
+// If "f" reports an error, AffectedManifestsFunc stops and returns it.
+func (l *Libindex) AffectedManifestsFunc(ctx context.Context, vulns []claircore.Vulnerability, opts AffectedOptions, f func(claircore.Digest, []*claircore.Vulnerability) error) error {
+	ctx = zlog.ContextWithValues(ctx, "component", "libindex/Libindex.AffectedManifestsFunc")
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	for {
+		affected, next, err := l.affectedPage(ctx, vulns, &opts)
+		if err != nil {
+			return err
+		}
+		affected.Sort()
+		hashes := make([]string, 0, len(affected.VulnerableManifests))
+		for h := range affected.VulnerableManifests {
+			hashes = append(hashes, h)
+		}
+		sort.Strings(hashes)
+		for _, h := range hashes {
+			d, err := claircore.ParseDigest(h)
+			if err != nil {
+				return err
+			}
+			ids := affected.VulnerableManifests[h]
+			vs := make([]*claircore.Vulnerability, len(ids))
+			for i, id := range ids {
+				vs[i] = affected.Vulnerabilities[id]
+			}
+			if err := f(d, vs); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		opts.After = next
+	}
+}
+
+// AffectedPage finds a page of affected manifests, without image indexes,
+// and the cursor for the next page.
+func (l *Libindex) affectedPage(ctx context.Context, vulns []claircore.Vulnerability, opts *AffectedOptions) (*claircore.AffectedManifests, string, error) {
+	limit := opts.Limit
+	if limit < 1 {
+		limit = DefaultAffectedPageSize
+	}
+	var names map[string]bool
+	if len(opts.Packages) != 0 {
+		names = make(map[string]bool, len(opts.Packages))
+		for _, n := range opts.Packages {
+			names[n] = true
+		}
+	}
+	om := omnimatcher.New(nil)
+	pager, paged := l.store.(indexer.AffectedManifestsPager)
+
+	// Each vulnerability gets a page of its own; the page returned is cut
+	// from all of them.
+	pages := make([][]claircore.Digest, len(vulns))
+	sem := semaphore.NewWeighted(20)
+	g, gctx := errgroup.WithContext(ctx)
+	for i := range vulns {
+		i := i
+		v := &vulns[i]
+		if names != nil && (v.Package == nil || !names[v.Package.Name]) {
+			continue
+		}
+		if err := sem.Acquire(gctx, 1); err != nil {
+			break
+		}
+		g.Go(func() error {
+			defer sem.Release(1)
+			var err error
+			if paged {
+				pages[i], err = pager.AffectedManifestsPage(gctx, *v, om.Vulnerable, opts.After, limit)
+				return err
+			}
+			ds, err := l.store.AffectedManifests(gctx, *v, om.Vulnerable)
+			if err != nil {
+				return err
+			}
+			pages[i] = cutPage(ds, opts.After, limit)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, "", fmt.Errorf("received error retrieving affected manifests: %v", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	// A full page may have left out manifests sorting after its last one,
+	// so nothing past the earliest such manifest can be reported yet.
+	var cutoff string
+	byHash := make(map[string][]int)
+	for i, p := range pages {
+		if len(p) == limit {
+			if last := p[len(p)-1].String(); cutoff == "" || last < cutoff {
+				cutoff = last
+			}
+		}
+		for _, d := range p {
+			byHash[d.String()] = append(byHash[d.String()], i)
+		}
+	}
+	hashes := make([]string, 0, len(byHash))
+	for h := range byHash {
+		if cutoff == "" || h <= cutoff {
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Strings(hashes)
+	more := cutoff != ""
+	if len(hashes) > limit {
+		hashes = hashes[:limit]
+		more = true
+	}
+
+	affected := claircore.NewAffectedManifests()
+	for _, h := range hashes {
+		d, err := claircore.ParseDigest(h)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, i := range byHash[h] {
+			affected.Add(&vulns[i], d)
+		}
+	}
+	var next string
+	if more && len(hashes) != 0 {
+		next = hashes[len(hashes)-1]
+	}
+	zlog.Debug(ctx).
+		Int("count", len(hashes)).
+		Str("next", next).
+		Msg("affected manifests page")
+	return &affected, next, nil
+}
+
+// CutPage returns up to "limit" of the distinct digests sorting after
+// "after", in order.
+func cutPage(ds []claircore.Digest, after string, limit int) []claircore.Digest {
+	hs := make([]string, 0, len(ds))
+	for _, d := range ds {
+		if h := d.String(); h > after {
+			hs = append(hs, h)
+		}
+	}
+	sort.Strings(hs)
+	var out []claircore.Digest
+	for i, h := range hs {
+		if len(out) == limit {
+			break
+		}
+		if i != 0 && h == hs[i-1] {
+			continue
+		}
+		out = append(out, claircore.MustParseDigest(h))
+	}
+	return out
+}
@@ -0,0 +1,183 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+// AffectedStore reports the manifests affected by each vulnerability, by ID.
+type affectedStore struct {
+	indexer.Store
+	affected map[string][]claircore.Digest
+}
+
+func (s *affectedStore) AffectedManifests(_ context.Context, v claircore.Vulnerability, _ claircore.CheckVulnernableFunc) ([]claircore.Digest, error) {
+	return s.affected[v.ID], nil
+}
+
+// PagedStore is an affectedStore that cuts pages itself.
+type pagedStore struct {
+	*affectedStore
+}
+
+var _ indexer.AffectedManifestsPager = pagedStore{}
+
+func (s pagedStore) AffectedManifestsPage(ctx context.Context, v claircore.Vulnerability, f claircore.CheckVulnernableFunc, after string, limit int) ([]claircore.Digest, error) {
+	ds, err := s.AffectedManifests(ctx, v, f)
+	if err != nil {
+		return nil, err
+	}
+	return cutPage(ds, after, limit), nil
+}
+
+func TestAffectedManifestsPage(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	vulns := createTestVulns(3)
+	vulns[2].Package = &claircore.Package{Name: "openssl", Kind: claircore.BINARY}
+	ms := make([]claircore.Digest, 8)
+	for i := range ms {
+		ms[i] = digest(fmt.Sprint("manifest ", i))
+	}
+	as := &affectedStore{affected: map[string][]claircore.Digest{
+		// Each vulnerability affects an overlapping set of manifests, with
+		// a duplicate thrown in.
+		vulns[0].ID: {ms[0], ms[1], ms[2], ms[3], ms[0]},
+		vulns[1].ID: {ms[3], ms[4], ms[5]},
+		vulns[2].ID: {ms[5], ms[6], ms[7], ms[1]},
+	}}
+	// Want is every affected manifest, in order, with the IDs of the
+	// vulnerabilities affecting it.
+	want := make(map[string][]string)
+	for _, v := range vulns {
+		seen := make(map[string]bool)
+		for _, d := range as.affected[v.ID] {
+			if !seen[d.String()] {
+				seen[d.String()] = true
+				want[d.String()] = append(want[d.String()], v.ID)
+			}
+		}
+	}
+	for _, ids := range want {
+		sort.Strings(ids)
+	}
+
+	stores := []struct {
+		name  string
+		store indexer.Store
+	}{
+		{name: "Unpaged", store: as},
+		{name: "Paged", store: pagedStore{as}},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			l := &Libindex{store: s.store}
+			for _, limit := range []int{1, 2, 3, 100} {
+				t.Run(fmt.Sprint(limit), func(t *testing.T) {
+					ctx := zlog.Test(ctx, t)
+					got := make(map[string][]string)
+					var order []string
+					opts := AffectedOptions{Limit: limit}
+					for pages := 0; ; pages++ {
+						if pages > len(ms) {
+							t.Fatal("too many pages")
+						}
+						affected, next, err := l.AffectedManifestsPage(ctx, vulns, opts)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if n := len(affected.VulnerableManifests); n > limit {
+							t.Errorf("page of %d, want at most %d", n, limit)
+						}
+						var page []string
+						for h, ids := range affected.VulnerableManifests {
+							if _, ok := got[h]; ok {
+								t.Errorf("%s: reported twice", h)
+							}
+							ids = append([]string(nil), ids...)
+							sort.Strings(ids)
+							got[h] = ids
+							page = append(page, h)
+						}
+						sort.Strings(page)
+						order = append(order, page...)
+						if next == "" {
+							break
+						}
+						opts.After = next
+					}
+					if !cmp.Equal(got, want) {
+						t.Error(cmp.Diff(got, want))
+					}
+					if !sort.StringsAreSorted(order) {
+						t.Errorf("out of order: %v", order)
+					}
+				})
+			}
+		})
+	}
+
+	t.Run("Packages", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		l := &Libindex{store: as}
+		affected, next, err := l.AffectedManifestsPage(ctx, vulns, AffectedOptions{Packages: []string{"openssl"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != "" {
+			t.Errorf("unexpected cursor: %q", next)
+		}
+		if got, want := len(affected.VulnerableManifests), 4; got != want {
+			t.Errorf("got: %d manifests, want: %d", got, want)
+		}
+		for h, ids := range affected.VulnerableManifests {
+			if !cmp.Equal(ids, []string{vulns[2].ID}) {
+				t.Errorf("%s: got: %v", h, ids)
+			}
+		}
+	})
+
+	t.Run("Func", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		l := &Libindex{store: pagedStore{as}}
+		got := make(map[string][]string)
+		var order []string
+		err := l.AffectedManifestsFunc(ctx, vulns, AffectedOptions{Limit: 2}, func(d claircore.Digest, vs []*claircore.Vulnerability) error {
+			var ids []string
+			for _, v := range vs {
+				ids = append(ids, v.ID)
+			}
+			sort.Strings(ids)
+			got[d.String()] = ids
+			order = append(order, d.String())
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+		if len(order) != len(want) || !sort.StringsAreSorted(order) {
+			t.Errorf("got: %v", order)
+		}
+
+		stop := errors.New("stop")
+		var calls int
+		err = l.AffectedManifestsFunc(ctx, vulns, AffectedOptions{Limit: 2}, func(claircore.Digest, []*claircore.Vulnerability) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("got: %v after %d calls, want: %v after 1", err, calls, stop)
+		}
+	})
+}
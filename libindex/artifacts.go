@@ -0,0 +1,320 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+// ArtifactVersion is the version of the archive format written by
+// ExportLayers. ImportLayers refuses any other version.
+const artifactVersion = 1
+
+// ArtifactHeaderName is the name of the first entry in an artifact archive.
+const artifactHeaderName = "header.json"
+
+// ArtifactHeader describes the contents of an artifact archive.
+type artifactHeader struct {
+	Version int             `json:"version"`
+	Created time.Time       `json:"created"`
+	Entries []artifactEntry `json:"entries"`
+}
+
+// ArtifactEntry describes one file in an artifact archive: the artifacts found
+// in one layer by one scanner.
+type artifactEntry struct {
+	Name    string                  `json:"name"`
+	Layer   claircore.Digest        `json:"layer"`
+	Scanner claircore.ScannerRecord `json:"scanner"`
+	SHA256  string                  `json:"sha256"`
+}
+
+// ArtifactBody is the contents of one entry in an artifact archive.
+type artifactBody struct {
+	Packages      []artifactPackage         `json:"packages,omitempty"`
+	Distributions []*claircore.Distribution `json:"distributions,omitempty"`
+	Repositories  []*claircore.Repository   `json:"repositories,omitempty"`
+}
+
+// ArtifactPackage carries the Package fields that are persisted but not
+// normally serialized.
+type artifactPackage struct {
+	*claircore.Package
+	PackageDB      string `json:"package_db,omitempty"`
+	RepositoryHint string `json:"repository_hint,omitempty"`
+}
+
+// ExportLayers writes the scan artifacts for the provided layers to "w" as a
+// portable archive, for use with ImportLayers in another deployment.
+//
+// Only artifacts from the currently configured scanners are exported. Layers
+// or scanners with no record of a scan are skipped.
+func (l *Libindex) ExportLayers(ctx context.Context, w io.Writer, layers ...claircore.Digest) error {
+	ctx = zlog.ContextWithValues(ctx, "component", "libindex/Libindex.ExportLayers")
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	h := artifactHeader{
+		Version: artifactVersion,
+		Created: time.Now().UTC(),
+	}
+	var bodies [][]byte
+	for _, d := range layers {
+		for _, v := range l.vscnrs {
+			ok, err := l.store.LayerScanned(ctx, d, v)
+			if err != nil {
+				return fmt.Errorf("libindex: unable to check layer %v: %w", d, err)
+			}
+			if !ok {
+				continue
+			}
+			b, err := l.exportArtifacts(ctx, d, v)
+			if err != nil {
+				return fmt.Errorf("libindex: unable to export layer %v: %w", d, err)
+			}
+			sum := sha256.Sum256(b)
+			h.Entries = append(h.Entries, artifactEntry{
+				Name:  fmt.Sprintf("%d.json", len(h.Entries)),
+				Layer: d,
+				Scanner: claircore.ScannerRecord{
+					Name:    v.Name(),
+					Version: v.Version(),
+					Kind:    v.Kind(),
+				},
+				SHA256: hex.EncodeToString(sum[:]),
+			})
+			bodies = append(bodies, b)
+		}
+	}
+	hb, err := json.Marshal(&h)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	write := func(name string, b []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     int64(len(b)),
+			Mode:     0o644,
+			ModTime:  h.Created,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(b)
+		return err
+	}
+	if err := write(artifactHeaderName, hb); err != nil {
+		return err
+	}
+	for i, e := range h.Entries {
+		if err := write(e.Name, bodies[i]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	zlog.Info(ctx).
+		Int("layers", len(layers)).
+		Int("entries", len(h.Entries)).
+		Msg("exported layer artifacts")
+	return nil
+}
+
+// ExportArtifacts returns the serialized artifacts for the layer found by the
+// scanner.
+func (l *Libindex) exportArtifacts(ctx context.Context, d claircore.Digest, v indexer.VersionedScanner) ([]byte, error) {
+	var b artifactBody
+	vs := indexer.VersionedScanners{v}
+	switch v.(type) {
+	case indexer.PackageScanner:
+		ps, err := l.store.PackagesByLayer(ctx, d, vs)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range ps {
+			b.Packages = append(b.Packages, artifactPackage{
+				Package:        p,
+				PackageDB:      p.PackageDB,
+				RepositoryHint: p.RepositoryHint,
+			})
+		}
+	case indexer.DistributionScanner:
+		ds, err := l.store.DistributionsByLayer(ctx, d, vs)
+		if err != nil {
+			return nil, err
+		}
+		b.Distributions = ds
+	case indexer.RepositoryScanner:
+		rs, err := l.store.RepositoriesByLayer(ctx, d, vs)
+		if err != nil {
+			return nil, err
+		}
+		b.Repositories = rs
+	}
+	return json.Marshal(&b)
+}
+
+// ImportLayers loads an archive written by ExportLayers into the store, so
+// that the layers it describes are treated as already scanned.
+//
+// The archive is validated completely before anything is written: an
+// unknown archive version, any checksum failure, or any entry from a scanner
+// that isn't configured with the same version and kind causes an error and
+// nothing is imported. Scanner mismatches are reported with
+// ErrScannerMismatch.
+//
+// The Store must implement indexer.LayerPersister.
+func (l *Libindex) ImportLayers(ctx context.Context, r io.Reader) error {
+	ctx = zlog.ContextWithValues(ctx, "component", "libindex/Libindex.ImportLayers")
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	lp, ok := l.store.(indexer.LayerPersister)
+	if !ok {
+		return fmt.Errorf("libindex: store %T does not support importing layers", l.store)
+	}
+	vs := make(map[string]indexer.VersionedScanner, len(l.vscnrs))
+	for _, v := range l.vscnrs {
+		vs[v.Kind()+"/"+v.Name()] = v
+	}
+
+	tr := tar.NewReader(r)
+	th, err := tr.Next()
+	switch {
+	case errors.Is(err, nil):
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("libindex: %w: empty archive", ErrInvalidArtifact)
+	default:
+		return fmt.Errorf("libindex: %w: %v", ErrInvalidArtifact, err)
+	}
+	if th.Name != artifactHeaderName {
+		return fmt.Errorf("libindex: %w: unexpected first entry %q", ErrInvalidArtifact, th.Name)
+	}
+	var h artifactHeader
+	if err := json.NewDecoder(tr).Decode(&h); err != nil {
+		return fmt.Errorf("libindex: %w: bad header: %v", ErrInvalidArtifact, err)
+	}
+	if h.Version != artifactVersion {
+		return fmt.Errorf("libindex: %w: unsupported version %d", ErrInvalidArtifact, h.Version)
+	}
+	want := make(map[string]*artifactEntry, len(h.Entries))
+	scnrs := make([]indexer.VersionedScanner, len(h.Entries))
+	for i := range h.Entries {
+		e := &h.Entries[i]
+		v, ok := vs[e.Scanner.Kind+"/"+e.Scanner.Name]
+		if !ok {
+			return fmt.Errorf("libindex: %w: %s scanner %q is not configured",
+				ErrScannerMismatch, e.Scanner.Kind, e.Scanner.Name)
+		}
+		if got := v.Version(); got != e.Scanner.Version {
+			return fmt.Errorf("libindex: %w: %s scanner %q: archive has version %q, configured version is %q",
+				ErrScannerMismatch, e.Scanner.Kind, e.Scanner.Name, e.Scanner.Version, got)
+		}
+		if _, ok := want[e.Name]; ok {
+			return fmt.Errorf("libindex: %w: duplicate entry %q", ErrInvalidArtifact, e.Name)
+		}
+		want[e.Name] = e
+		scnrs[i] = v
+	}
+
+	bodies := make(map[string]*artifactBody, len(h.Entries))
+	for {
+		th, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("libindex: %w: %v", ErrInvalidArtifact, err)
+		}
+		e, ok := want[th.Name]
+		if !ok {
+			return fmt.Errorf("libindex: %w: unexpected entry %q", ErrInvalidArtifact, th.Name)
+		}
+		if _, ok := bodies[th.Name]; ok {
+			return fmt.Errorf("libindex: %w: duplicate entry %q", ErrInvalidArtifact, th.Name)
+		}
+		var buf bytes.Buffer
+		sum := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(&buf, sum), tr); err != nil {
+			return fmt.Errorf("libindex: %w: %v", ErrInvalidArtifact, err)
+		}
+		if got := hex.EncodeToString(sum.Sum(nil)); got != e.SHA256 {
+			return fmt.Errorf("libindex: %w: entry %q: checksum mismatch: got %s, expected %s",
+				ErrInvalidArtifact, th.Name, got, e.SHA256)
+		}
+		var b artifactBody
+		if err := json.Unmarshal(buf.Bytes(), &b); err != nil {
+			return fmt.Errorf("libindex: %w: entry %q: %v", ErrInvalidArtifact, th.Name, err)
+		}
+		bodies[th.Name] = &b
+	}
+	if got, want := len(bodies), len(h.Entries); got != want {
+		return fmt.Errorf("libindex: %w: found %d entries, expected %d", ErrInvalidArtifact, got, want)
+	}
+
+	// Everything checks out, so load it.
+	seen := make(map[string]struct{})
+	var ds []claircore.Digest
+	for _, e := range h.Entries {
+		if _, ok := seen[e.Layer.String()]; ok {
+			continue
+		}
+		seen[e.Layer.String()] = struct{}{}
+		ds = append(ds, e.Layer)
+	}
+	if err := lp.PersistLayers(ctx, ds); err != nil {
+		return fmt.Errorf("libindex: unable to persist layers: %w", err)
+	}
+	for i, e := range h.Entries {
+		b, v := bodies[e.Name], scnrs[i]
+		layer := &claircore.Layer{Hash: e.Layer}
+		if len(b.Packages) != 0 {
+			ps := make([]*claircore.Package, len(b.Packages))
+			for i, p := range b.Packages {
+				p.Package.PackageDB = p.PackageDB
+				p.Package.RepositoryHint = p.RepositoryHint
+				ps[i] = p.Package
+			}
+			if err := l.store.IndexPackages(ctx, ps, layer, v); err != nil {
+				return fmt.Errorf("libindex: unable to index packages: %w", err)
+			}
+		}
+		if len(b.Distributions) != 0 {
+			if err := l.store.IndexDistributions(ctx, b.Distributions, layer, v); err != nil {
+				return fmt.Errorf("libindex: unable to index distributions: %w", err)
+			}
+		}
+		if len(b.Repositories) != 0 {
+			if err := l.store.IndexRepositories(ctx, b.Repositories, layer, v); err != nil {
+				return fmt.Errorf("libindex: unable to index repositories: %w", err)
+			}
+		}
+		if err := l.store.SetLayerScanned(ctx, e.Layer, v); err != nil {
+			return fmt.Errorf("libindex: unable to mark layer scanned: %w", err)
+		}
+	}
+	zlog.Info(ctx).
+		Int("layers", len(ds)).
+		Int("entries", len(h.Entries)).
+		Msg("imported layer artifacts")
+	return nil
+}
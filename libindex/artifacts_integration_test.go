@@ -0,0 +1,238 @@
+package libindex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/log/testingadapter"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/quay/zlog"
+	"github.com/remind101/migrate"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/datastore/postgres"
+	"github.com/quay/claircore/datastore/postgres/migrations"
+	"github.com/quay/claircore/indexer/linux"
+	"github.com/quay/claircore/pkg/ctxlock"
+	"github.com/quay/claircore/test"
+	"github.com/quay/claircore/test/integration"
+	indexer "github.com/quay/claircore/test/mock/indexer"
+)
+
+// NewArtifactLibindex creates a Libindex backed by a fresh database, using the
+// provided scanners.
+func newArtifactLibindex(ctx context.Context, t *testing.T, c *http.Client, ms []*indexer.MockPackageScanner) *Libindex {
+	t.Helper()
+	db, err := integration.NewDB(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close(ctx, t) })
+	cfg := db.Config().ConnConfig.Copy()
+	cfg.LogLevel = pgx.LogLevelError
+	cfg.Logger = testingadapter.NewLogger(t)
+	mdb := stdlib.OpenDB(*cfg)
+	migrator := migrate.NewPostgresMigrator(mdb)
+	migrator.Table = migrations.IndexerMigrationTable
+	if err := migrator.Exec(migrate.Up, migrations.IndexerMigrations...); err != nil {
+		t.Fatalf("failed to perform migrations: %v", err)
+	}
+	if err := mdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := postgres.Connect(ctx, db.String(), "libindex")
+	if err != nil {
+		t.Fatalf("failed to create postgres connection: %v", err)
+	}
+	store, err := postgres.InitPostgresIndexerStore(ctx, pool, false)
+	if err != nil {
+		t.Fatalf("failed to create postgres connection: %v", err)
+	}
+	ctxLocker, err := ctxlock.New(ctx, pool)
+	if err != nil {
+		t.Fatalf("failed to create context locker: %v", err)
+	}
+	opts := &Options{
+		Store:                store,
+		Locker:               ctxLocker,
+		FetchArena:           NewRemoteFetchArena(c, t.TempDir()),
+		ScanLockRetry:        2 * time.Second,
+		LayerScanConcurrency: 1,
+		Ecosystems: []*indexer.Ecosystem{
+			{
+				PackageScanners: func(_ context.Context) ([]indexer.PackageScanner, error) {
+					ps := make([]indexer.PackageScanner, len(ms))
+					for i := range ms {
+						ps[i] = ms[i]
+					}
+					return ps, nil
+				},
+				DistributionScanners: func(_ context.Context) ([]indexer.DistributionScanner, error) {
+					return nil, nil
+				},
+				RepositoryScanners: func(_ context.Context) ([]indexer.RepositoryScanner, error) {
+					return nil, nil
+				},
+				Coalescer: func(_ context.Context) (indexer.Coalescer, error) {
+					return linux.NewCoalescer(), nil
+				},
+				Name: "test",
+			},
+		},
+	}
+	lib, err := New(ctx, opts, c)
+	if err != nil {
+		t.Fatalf("failed to create libindex instance: %v", err)
+	}
+	t.Cleanup(func() { lib.Close(ctx) })
+	return lib
+}
+
+// MockScanners creates "n" mock package scanners reporting the provided
+// version. No Scan calls are expected.
+func mockScanners(ctrl *gomock.Controller, n int, version string) []*indexer.MockPackageScanner {
+	ms := make([]*indexer.MockPackageScanner, n)
+	for i := range ms {
+		m := indexer.NewMockPackageScanner(ctrl)
+		m.EXPECT().Name().AnyTimes().Return(fmt.Sprintf("test-scanner-%d", i))
+		m.EXPECT().Version().AnyTimes().Return(version)
+		m.EXPECT().Kind().AnyTimes().Return("package")
+		ms[i] = m
+	}
+	return ms
+}
+
+// NoFetch is an http.RoundTripper that fails any request.
+type noFetch struct{ t *testing.T }
+
+func (n noFetch) RoundTrip(r *http.Request) (*http.Response, error) {
+	n.t.Errorf("unexpected request: %v", r.URL)
+	return nil, errors.New("no requests allowed")
+}
+
+// ReportSummary reduces an IndexReport to a form that doesn't depend on
+// database-assigned IDs.
+func reportSummary(ir *claircore.IndexReport) []string {
+	var out []string
+	for id, p := range ir.Packages {
+		for _, env := range ir.Environments[id] {
+			out = append(out, fmt.Sprintf("%s %s %s %s %s",
+				p.Name, p.Version, p.Kind, env.IntroducedIn, env.PackageDB))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestArtifactExportImport(t *testing.T) {
+	integration.NeedDB(t)
+	ctx := zlog.Test(context.Background(), t)
+	const (
+		layers   = 3
+		scanners = 2
+	)
+	ctrl := gomock.NewController(t)
+	src := mockScanners(ctrl, scanners, "v0.0.1")
+	for i := 0; i < layers; i++ {
+		pkgs := test.GenUniquePackages(i + 1)
+		for _, m := range src {
+			m.EXPECT().Scan(gomock.Any(), gomock.Any()).Return(pkgs, nil)
+		}
+	}
+	c, ls := test.ServeLayers(t, layers)
+	m := &claircore.Manifest{
+		Hash:   test.RandomSHA256Digest(t),
+		Layers: ls,
+	}
+	digests := make([]claircore.Digest, len(ls))
+	for i, l := range ls {
+		digests[i] = l.Hash
+	}
+
+	a := newArtifactLibindex(ctx, t, c, src)
+	want, err := a.Index(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := a.ExportLayers(ctx, &buf, digests...); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+	t.Logf("archive is %d bytes", len(archive))
+
+	t.Run("Import", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		// These scanners have no Scan expectations and the client can't
+		// fetch, so any attempt to index the layers fails the test.
+		b := newArtifactLibindex(ctx, t, &http.Client{Transport: noFetch{t}},
+			mockScanners(gomock.NewController(t), scanners, "v0.0.1"))
+		if err := b.ImportLayers(ctx, bytes.NewReader(archive)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := b.Index(ctx, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Success {
+			t.Errorf("index failed: %v", got.Err)
+		}
+		if got, want := reportSummary(got), reportSummary(want); !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+		if got, want := got.Scanners, want.Scanners; !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+
+	t.Run("VersionMismatch", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		b := newArtifactLibindex(ctx, t, &http.Client{Transport: noFetch{t}},
+			mockScanners(gomock.NewController(t), scanners, "v0.0.2"))
+		err := b.ImportLayers(ctx, bytes.NewReader(archive))
+		t.Log(err)
+		if !errors.Is(err, ErrScannerMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrScannerMismatch)
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		b := newArtifactLibindex(ctx, t, &http.Client{Transport: noFetch{t}},
+			mockScanners(gomock.NewController(t), scanners, "v0.0.1"))
+		// Flip a byte in the final entry's contents, which is just ahead of
+		// the two trailing zero blocks and the entry's padding.
+		bad := append([]byte(nil), archive...)
+		i := bytes.LastIndex(bad, []byte(`"name"`))
+		if i == -1 {
+			t.Fatal("unable to find a package in the archive")
+		}
+		bad[i+1] = 'N'
+		err := b.ImportLayers(ctx, bytes.NewReader(bad))
+		t.Log(err)
+		if !errors.Is(err, ErrInvalidArtifact) {
+			t.Errorf("got: %v, want: %v", err, ErrInvalidArtifact)
+		}
+		for _, d := range digests {
+			for _, v := range b.vscnrs {
+				ok, err := b.store.LayerScanned(ctx, d, v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok {
+					t.Errorf("layer %v marked scanned after failed import", d)
+				}
+			}
+		}
+	})
+}
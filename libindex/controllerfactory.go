@@ -15,12 +15,19 @@ type ControllerFactory func(_ context.Context, lib *Libindex, opts *Options) (*c
 func controllerFactory(ctx context.Context, lib *Libindex, opts *Options) (*controller.Controller, error) {
 	// convert libindex.Opts to indexer.Opts
 	sOpts := &indexer.Opts{
-		Store:         lib.store,
-		Realizer:      lib.fa.Realizer(ctx),
-		Ecosystems:    opts.Ecosystems,
-		Vscnrs:        lib.vscnrs,
-		Client:        lib.client,
-		ScannerConfig: opts.ScannerConfig,
+		Store:           lib.store,
+		Realizer:        lib.fa.Realizer(ctx),
+		Ecosystems:      lib.ecosystems,
+		Vscnrs:          lib.vscnrs,
+		Client:          lib.client,
+		ScannerConfig:   opts.ScannerConfig,
+		VerifyPackages:  opts.VerifyPackages,
+		ValidateDiffIDs: opts.ValidateDiffIDs,
+		ApplyWhiteouts:  opts.ApplyWhiteouts,
+		Squash:          opts.Squash,
+		PartialReports:  opts.PartialReports,
+		ScannerTimeout:  opts.ScannerTimeout,
+		ScannerFailure:  opts.ScannerFailure,
 	}
 	var err error
 	sOpts.LayerScanner, err = layerscanner.New(ctx, opts.LayerScanConcurrency, sOpts)
@@ -0,0 +1,521 @@
+package libindex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/quay/claircore"
+)
+
+// These are sentinel errors that can be used with errors.Is.
+var (
+	// ErrNoSpace is returned when the arena's filesystem fills up while a
+	// layer is being written.
+	ErrNoSpace = errors.New("no space left in arena")
+	// ErrSizeMismatch is returned when a layer decompresses to a different
+	// size than the Layer's UncompressedSize, or a response body is a
+	// different length than its Content-Length.
+	ErrSizeMismatch = errors.New("uncompressed size mismatch")
+	// ErrDigestMismatch is returned when a layer's contents don't match its
+	// digest or any of its acceptable digests.
+	ErrDigestMismatch = errors.New("digest mismatch")
+	// ErrScannerMismatch is returned when an artifact archive was produced by
+	// scanners that differ from the configured ones.
+	ErrScannerMismatch = errors.New("scanner mismatch")
+	// ErrInvalidArtifact is returned when an artifact archive is malformed or
+	// fails an integrity check.
+	ErrInvalidArtifact = errors.New("invalid artifact archive")
+	// ErrInsecureRedirect is returned when fetching a layer is redirected
+	// from https to http and the arena isn't configured to allow it.
+	ErrInsecureRedirect = errors.New("insecure redirect")
+	// ErrTooSlow is returned when a layer is read slower than the arena's
+	// minimum throughput.
+	ErrTooSlow = errors.New("layer fetch too slow")
+	// ErrTrailingData is returned when a compressed layer is followed by
+	// something other than NUL padding.
+	ErrTrailingData = errors.New("trailing data after compressed stream")
+	// ErrInvalidHeader is returned when a Layer's headers have an invalid
+	// name, a value with control characters such as CR or LF, or are too
+	// large to send.
+	ErrInvalidHeader = errors.New("invalid layer header")
+	// ErrPinMismatch is returned when a host configured in a
+	// PinnedTransport serves a certificate chain without a pinned key.
+	ErrPinMismatch = errors.New("certificate pin mismatch")
+	// ErrSuspiciousRatio is returned when the start of a compressed layer
+	// decompresses to far more than its size. See WithRatioCheck.
+	ErrSuspiciousRatio = errors.New("suspicious compression ratio")
+	// ErrQueueTimeout is returned when a layer waits longer than the
+	// arena's queue timeout for a concurrency slot. See WithFetchTimeout.
+	ErrQueueTimeout = errors.New("timed out waiting for a fetch slot")
+	// ErrFetchTimeout is returned when a layer takes longer than the
+	// arena's fetch timeout once it's started. See WithFetchTimeout.
+	ErrFetchTimeout = errors.New("layer fetch timed out")
+	// ErrRealizerClosed is returned when Realize is called on a FetchProxy
+	// that's been closed.
+	ErrRealizerClosed = errors.New("realizer closed")
+	// ErrShutdown is returned when work is requested of a Libindex or
+	// RemoteFetchArena that's shutting down.
+	ErrShutdown = errors.New("shutting down")
+	// ErrQuotaExceeded is returned when writing a layer would take the
+	// arena's disk usage past its quota. See WithDiskQuota.
+	ErrQuotaExceeded = errors.New("arena disk quota exceeded")
+	// ErrDigestAlgorithm is returned when none of a layer's digests use an
+	// algorithm the arena allows. See WithDigestAlgorithms.
+	ErrDigestAlgorithm = errors.New("digest algorithm not allowed")
+	// ErrDiffIDMismatch is returned when a layer's uncompressed contents
+	// don't match its ExpectedDiffID.
+	ErrDiffIDMismatch = errors.New("diffID mismatch")
+	// ErrMediaTypeMismatch is returned when a layer isn't what its declared
+	// media type says it is. See WithStrictMediaType.
+	ErrMediaTypeMismatch = errors.New("media type mismatch")
+	// ErrLayerTooLarge is returned when a layer decompresses to more than
+	// the arena allows. Errors satisfying it satisfy ErrTooLarge as well. See
+	// WithMaxLayerSize.
+	ErrLayerTooLarge = errors.New("layer too large")
+	// ErrNotRetained is returned when layers are prefetched into an arena
+	// that wouldn't keep them. See RemoteFetchArena.Prefetch.
+	ErrNotRetained = errors.New("arena doesn't retain layers")
+	// ErrNotIndexed is returned when a manifest has no successful
+	// IndexReport. See Libindex.Compare.
+	ErrNotIndexed = errors.New("manifest not indexed")
+	// ErrQueueFull is returned when an Index call is turned away because too
+	// many are waiting to start. See Options.ManifestQueueLength.
+	ErrQueueFull = errors.New("index queue full")
+)
+
+// These sentinel errors classify failures, so callers can decide what to do
+// about them without inspecting error text. A failure satisfies at most one
+// of them, and may satisfy none, in which case it's best treated as
+// permanent.
+var (
+	// ErrNotFound is returned when a server reports that a layer, manifest,
+	// or blob doesn't exist. Trying again won't help.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is returned when a server refuses a request for want of
+	// credentials, or because of the credentials it was sent. Trying again
+	// may help once credentials are refreshed.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrChecksumMismatch is returned when fetched contents don't match a
+	// digest they're expected to. Errors satisfying ErrDigestMismatch or
+	// ErrDiffIDMismatch satisfy it as well.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrTooLarge is returned when something is larger than the arena
+	// allows, like a layer that can never fit in its disk quota. Trying again
+	// won't help.
+	ErrTooLarge = errors.New("too large")
+	// ErrTransient is returned for failures that may go away on their own,
+	// like a server error, a connection reset, or a timeout. Trying again
+	// later may help.
+	ErrTransient = errors.New("transient failure")
+)
+
+type errNoSpace struct {
+	inner error
+}
+
+// NoSpace wraps "err" if it reports that the device is out of space, and
+// returns it unmodified otherwise.
+func noSpace(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return &errNoSpace{inner: err}
+	}
+	return err
+}
+
+func (e *errNoSpace) Error() string {
+	return fmt.Sprintf("fetcher: %v: %v", ErrNoSpace, e.inner)
+}
+
+func (e *errNoSpace) Unwrap() error {
+	return e.inner
+}
+
+func (e *errNoSpace) Is(target error) bool {
+	return target == ErrNoSpace || target == ErrTransient || target == e
+}
+
+// ErrQuota reports that the arena's disk quota was, or would have been,
+// exceeded. Need, if non-zero, is the least the layer being written needs.
+type errQuota struct {
+	used, max, need int64
+}
+
+func (e *errQuota) Error() string {
+	if e.need > 0 {
+		return fmt.Sprintf("fetcher: %v: %d bytes in use of %d, layer needs at least %d",
+			ErrQuotaExceeded, e.used, e.max, e.need)
+	}
+	return fmt.Sprintf("fetcher: %v: %d bytes in use of %d", ErrQuotaExceeded, e.used, e.max)
+}
+
+// Is reports a layer that can never fit in the quota as ErrTooLarge, and
+// other quota failures as ErrTransient.
+func (e *errQuota) Is(target error) bool {
+	switch target {
+	case ErrQuotaExceeded, e:
+		return true
+	case ErrTooLarge:
+		return e.need >= e.max
+	case ErrTransient:
+		return e.need < e.max
+	}
+	return false
+}
+
+type errSizeMismatch struct {
+	got, want int64
+}
+
+func (e *errSizeMismatch) Error() string {
+	return fmt.Sprintf("fetcher: %v: got %d bytes, expected %d", ErrSizeMismatch, e.got, e.want)
+}
+
+func (e *errSizeMismatch) Is(target error) bool {
+	return target == ErrSizeMismatch || target == e
+}
+
+type errDigestMismatch struct {
+	got, want []string
+}
+
+func (e *errDigestMismatch) Error() string {
+	if len(e.want) == 1 {
+		return fmt.Sprintf("fetcher: %v: got %q, expected %q", ErrDigestMismatch, e.got[0], e.want[0])
+	}
+	return fmt.Sprintf("fetcher: %v: got %q, expected one of %q", ErrDigestMismatch, e.got, e.want)
+}
+
+func (e *errDigestMismatch) Is(target error) bool {
+	return target == ErrDigestMismatch || target == ErrChecksumMismatch || target == e
+}
+
+type errTrailingData struct {
+	c compression
+}
+
+func (e *errTrailingData) Error() string {
+	return fmt.Sprintf("fetcher: %v: garbage after %v stream", ErrTrailingData, e.c)
+}
+
+func (e *errTrailingData) Is(target error) bool {
+	return target == ErrTrailingData || target == e
+}
+
+// ErrDecompress reports a layer that couldn't be decompressed.
+type errDecompress struct {
+	c   compression
+	err error
+}
+
+func (e *errDecompress) Error() string {
+	return fmt.Sprintf("fetcher: %v decompression failed: %v", e.c, e.err)
+}
+
+func (e *errDecompress) Unwrap() error {
+	return e.err
+}
+
+type errSuspiciousRatio struct {
+	read, limit int64
+	max         int
+}
+
+func (e *errSuspiciousRatio) Error() string {
+	return fmt.Sprintf("fetcher: %v: first %d bytes decompress to more than %d bytes (limit %d:1)",
+		ErrSuspiciousRatio, e.read, e.limit, e.max)
+}
+
+func (e *errSuspiciousRatio) Is(target error) bool {
+	return target == ErrSuspiciousRatio || target == e
+}
+
+// ErrTimeout reports that one phase of a fetch ran past its timeout. The
+// "phase" member is ErrQueueTimeout or ErrFetchTimeout.
+type errTimeout struct {
+	phase error
+	d     time.Duration
+	inner error
+}
+
+func (e *errTimeout) Error() string {
+	return fmt.Sprintf("fetcher: %v after %v: %v", e.phase, e.d, e.inner)
+}
+
+func (e *errTimeout) Unwrap() error {
+	return e.inner
+}
+
+func (e *errTimeout) Is(target error) bool {
+	return target == e.phase || target == ErrTransient || target == e
+}
+
+// ErrBodyRead reports a failure reading a response body off the network, such
+// as the connection being reset. Unlike most failures partway through a fetch,
+// these are worth starting over for. See RemoteFetchArena.realize.
+type errBodyRead struct {
+	inner error
+}
+
+func (e *errBodyRead) Error() string {
+	return fmt.Sprintf("fetcher: reading response body: %v", e.inner)
+}
+
+func (e *errBodyRead) Unwrap() error {
+	return e.inner
+}
+
+func (e *errBodyRead) Is(target error) bool {
+	return target == ErrTransient || target == e
+}
+
+// BodyReader marks errors reading "r", other than io.EOF, as errBodyRead.
+type bodyReader struct {
+	io.ReadCloser
+}
+
+func (b bodyReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = &errBodyRead{inner: err}
+	}
+	return n, err
+}
+
+type errInvalidHeader struct {
+	name, reason string
+}
+
+func (e *errInvalidHeader) Error() string {
+	return fmt.Sprintf("fetcher: %v %q: %s", ErrInvalidHeader, e.name, e.reason)
+}
+
+func (e *errInvalidHeader) Is(target error) bool {
+	return target == ErrInvalidHeader || target == e
+}
+
+type errDiffID struct {
+	got, want claircore.Digest
+}
+
+func (e *errDiffID) Error() string {
+	return fmt.Sprintf("fetcher: %v: uncompressed layer is %v, expected %v", ErrDiffIDMismatch, e.got, e.want)
+}
+
+func (e *errDiffID) Is(target error) bool {
+	return target == ErrDiffIDMismatch || target == ErrChecksumMismatch || target == e
+}
+
+// ErrLayerTooLarge reports a layer over the arena's size limit. Size, if
+// non-zero, is the layer's declared size; otherwise, it was caught being
+// written.
+type errLayerTooLarge struct {
+	max, size int64
+}
+
+func (e *errLayerTooLarge) Error() string {
+	if e.size > 0 {
+		return fmt.Sprintf("fetcher: %v: declared size %d is over the limit of %d bytes", ErrLayerTooLarge, e.size, e.max)
+	}
+	return fmt.Sprintf("fetcher: %v: decompressed past the limit of %d bytes", ErrLayerTooLarge, e.max)
+}
+
+func (e *errLayerTooLarge) Is(target error) bool {
+	return target == ErrLayerTooLarge || target == ErrTooLarge || target == e
+}
+
+type errTooSlow struct {
+	n, min int64
+	window time.Duration
+}
+
+func (e *errTooSlow) Error() string {
+	return fmt.Sprintf("fetcher: %v: %d bytes in the last %v, want at least %d", ErrTooSlow, e.n, e.window, e.min)
+}
+
+func (e *errTooSlow) Is(target error) bool {
+	return target == ErrTooSlow || target == ErrTransient || target == e
+}
+
+// ErrRequest reports a request that couldn't be made or got no response. It
+// satisfies ErrTransient if the failure is one worth retrying.
+type errRequest struct {
+	what  string
+	inner error
+}
+
+func (e *errRequest) Error() string {
+	return fmt.Sprintf("fetcher: %s failed: %v", e.what, e.inner)
+}
+
+func (e *errRequest) Unwrap() error {
+	return e.inner
+}
+
+func (e *errRequest) Is(target error) bool {
+	return (target == ErrTransient && retryable(nil, e.inner)) || target == e
+}
+
+// ErrLocal is returned when a layer's local file can't be opened.
+type errLocal struct {
+	name  string
+	inner error
+}
+
+func (e *errLocal) Error() string {
+	return fmt.Sprintf("fetcher: unable to open local layer %q: %v", e.name, e.inner)
+}
+
+func (e *errLocal) Unwrap() error {
+	return e.inner
+}
+
+func (e *errLocal) Is(target error) bool {
+	return (target == ErrNotFound && errors.Is(e.inner, fs.ErrNotExist)) || target == e
+}
+
+// StatusError is returned when a server responds to a request with an
+// unexpected status. Depending on the status, it satisfies ErrNotFound,
+// ErrUnauthorized, ErrTooLarge, or ErrTransient.
+type StatusError struct {
+	// URL, if not empty, is the URL requested, without any credentials.
+	URL string
+	// StatusCode and Status are from the response.
+	StatusCode int
+	Status     string
+	// Body is the start of the response body, if it could be read.
+	Body []byte
+}
+
+// NewStatusError returns a StatusError for the response, reading the start of
+// its body. The body isn't closed.
+func newStatusError(resp *http.Response) *StatusError {
+	e := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	// Especially for 4xx errors, the response body may indicate what's going
+	// on, so include some of it in the error message. Capped at 256 bytes in
+	// order to not flood the log.
+	if b, err := io.ReadAll(io.LimitReader(resp.Body, 256)); err == nil && len(b) != 0 {
+		e.Body = b
+	}
+	return e
+}
+
+func (e *StatusError) Error() string {
+	var b strings.Builder
+	b.WriteString("fetcher: ")
+	if e.URL != "" {
+		b.WriteString(e.URL)
+		b.WriteString(": ")
+	}
+	fmt.Fprintf(&b, "unexpected status code: %s", e.Status)
+	if e.Body != nil {
+		fmt.Fprintf(&b, " (body starts: %q)", e.Body)
+	}
+	return b.String()
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case e:
+		return true
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrTooLarge:
+		return e.StatusCode == http.StatusRequestEntityTooLarge
+	case ErrTransient:
+		return retryable(&http.Response{StatusCode: e.StatusCode}, nil)
+	}
+	return false
+}
+
+// MediaTypeError is returned when a layer's declared media type is unknown or
+// disagrees with the layer as fetched. See WithStrictMediaType.
+type MediaTypeError struct {
+	// Declared is the Layer's MediaType.
+	Declared string
+	// Reported is the content-type the server reported.
+	Reported string
+	// Detected, if not empty, is the compression found by examining the
+	// layer, like "gzip" or "none".
+	Detected string
+}
+
+func (e *MediaTypeError) Error() string {
+	switch {
+	case e.Detected != "":
+		return fmt.Sprintf("fetcher: %v: declared %q, but layer compression is %s", ErrMediaTypeMismatch, e.Declared, e.Detected)
+	case e.Reported == "" || e.Reported == e.Declared:
+		return fmt.Sprintf("fetcher: %v: unknown declared media type %q", ErrMediaTypeMismatch, e.Declared)
+	}
+	return fmt.Sprintf("fetcher: %v: declared %q, server reported %q", ErrMediaTypeMismatch, e.Declared, e.Reported)
+}
+
+func (e *MediaTypeError) Is(target error) bool {
+	return target == ErrMediaTypeMismatch || target == e
+}
+
+// LayerError is returned by FetchProxy.Realize when fetching a layer fails,
+// and identifies the layer.
+type LayerError struct {
+	Layer claircore.Digest
+	URI   string
+	Err   error
+}
+
+func (e *LayerError) Error() string {
+	return fmt.Sprintf("layer %v (%s): %v", e.Layer, e.URI, e.Err)
+}
+
+func (e *LayerError) Unwrap() error {
+	return e.Err
+}
+
+// FetchError is returned by FetchProxy.Realize in best-effort mode when one
+// or more layers couldn't be fetched. Every member of Errs is a *LayerError.
+//
+// Is and As consult every member, so errors.Is and errors.As work as expected
+// on Go versions that don't understand "Unwrap() []error".
+type FetchError struct {
+	Errs []error
+}
+
+func (e *FetchError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d layers failed: ", len(e.Errs))
+	for i, err := range e.Errs {
+		if i != 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e *FetchError) Unwrap() []error {
+	return e.Errs
+}
+
+func (e *FetchError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *FetchError) As(target interface{}) bool {
+	for _, err := range e.Errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
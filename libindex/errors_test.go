@@ -0,0 +1,142 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchErrorClasses(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	classes := []error{ErrNotFound, ErrUnauthorized, ErrChecksumMismatch, ErrTooLarge, ErrTransient}
+	status := func(code int) *claircore.Layer {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nope", code)
+		}))
+		t.Cleanup(srv.Close)
+		return &claircore.Layer{
+			URI:  srv.URL + "/blob",
+			Hash: claircore.MustParseDigest("sha256:" + strings.Repeat("a", 64)),
+		}
+	}
+	b := tarball(t, "errors")
+	tt := []struct {
+		name  string
+		layer func(t *testing.T) (*http.Client, *claircore.Layer)
+		opts  []ArenaOption
+		want  error
+	}{
+		{
+			name: "NotFound",
+			layer: func(t *testing.T) (*http.Client, *claircore.Layer) {
+				return nil, status(http.StatusNotFound)
+			},
+			want: ErrNotFound,
+		},
+		{
+			name: "Unauthorized",
+			layer: func(t *testing.T) (*http.Client, *claircore.Layer) {
+				return nil, status(http.StatusUnauthorized)
+			},
+			want: ErrUnauthorized,
+		},
+		{
+			name: "Forbidden",
+			layer: func(t *testing.T) (*http.Client, *claircore.Layer) {
+				return nil, status(http.StatusForbidden)
+			},
+			want: ErrUnauthorized,
+		},
+		{
+			name: "Unavailable",
+			layer: func(t *testing.T) (*http.Client, *claircore.Layer) {
+				return nil, status(http.StatusServiceUnavailable)
+			},
+			want: ErrTransient,
+		},
+		{
+			name: "BadRequest",
+			layer: func(t *testing.T) (*http.Client, *claircore.Layer) {
+				return nil, status(http.StatusBadRequest)
+			},
+		},
+		{
+			name: "Refused",
+			layer: func(t *testing.T) (*http.Client, *claircore.Layer) {
+				srv := httptest.NewServer(http.NotFoundHandler())
+				srv.Close()
+				return nil, &claircore.Layer{
+					URI:  srv.URL + "/blob",
+					Hash: claircore.MustParseDigest("sha256:" + strings.Repeat("a", 64)),
+				}
+			},
+			want: ErrTransient,
+		},
+		{
+			name: "Checksum",
+			layer: func(t *testing.T) (*http.Client, *claircore.Layer) {
+				c, l := serveBlob(t, "application/x-tar", b)
+				l.Hash = claircore.MustParseDigest("sha256:" + strings.Repeat("b", 64))
+				return c, l
+			},
+			want: ErrChecksumMismatch,
+		},
+		{
+			name: "TooLarge",
+			layer: func(t *testing.T) (*http.Client, *claircore.Layer) {
+				return serveBlob(t, "application/x-tar", b)
+			},
+			opts: []ArenaOption{WithDiskQuota(int64(len(b)/2), false)},
+			want: ErrTooLarge,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := tc.layer(t)
+			if c == nil {
+				c = http.DefaultClient
+			}
+			a := NewRemoteFetchArena(c, t.TempDir(), tc.opts...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			t.Log(err)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, class := range classes {
+				if got, want := errors.Is(err, class), class == tc.want; got != want {
+					t.Errorf("%v: got: %v, want: %v", class, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("StatusError", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(http.DefaultClient, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, []*claircore.Layer{status(http.StatusNotFound)})
+		var se *StatusError
+		if !errors.As(err, &se) {
+			t.Fatalf("got: %v, want a *StatusError", err)
+		}
+		if got, want := se.StatusCode, http.StatusNotFound; got != want {
+			t.Errorf("status: got: %d, want: %d", got, want)
+		}
+		if got, want := string(se.Body), "nope\n"; got != want {
+			t.Errorf("body: got: %q, want: %q", got, want)
+		}
+	})
+}
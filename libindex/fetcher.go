@@ -4,25 +4,30 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/klauspost/compress/gzip"
-	"github.com/klauspost/compress/zstd"
 	"github.com/quay/claircore/indexer"
 	"github.com/quay/zlog"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 	"golang.org/x/sync/singleflight"
 
 	"github.com/quay/claircore"
+	"github.com/quay/claircore/internal/lifecycle"
 	"github.com/quay/claircore/pkg/tarfs"
 )
 
@@ -42,28 +47,243 @@ var (
 //
 // Exported for use in cctool. If cctool goes away, this can get unexported. It is
 // remote in the sense that it pulls layers from the internet.
+//
+// The arena reports Prometheus metrics named "claircore_fetcher_*", labeled
+// with its root directory: how long fetches wait and take, bytes fetched,
+// decompression ratios, where requested layers came from, the references held
+// on layers, and the disk space used by its files. They're removed on Close.
 type RemoteFetchArena struct {
 	wc *http.Client
 	sf *singleflight.Group
+	// Sem, if not nil, bounds the number of in-flight fetches across all
+	// FetchProxies.
+	sem *semaphore.Weighted
+	// RealizeLimit, if non-zero, is the number of layers a single Realize
+	// call fetches at once. See WithRealizeConcurrency.
+	realizeLimit int
+	// Evict, if not nil, is called to free space when a fetch runs out of
+	// room in the arena.
+	evict EvictFunc
+	// Auth, if not nil, handles registry authentication challenges.
+	auth *registryAuth
+	// StoreCompressed, if set, keeps a copy of every layer exactly as
+	// fetched alongside the decompressed tar.
+	storeCompressed bool
+	// LayerFile, if not nil, supplies the files layers are written into.
+	layerFile LayerFileFunc
+	// MemThreshold, if non-zero, is the size under which layers are kept in
+	// memory instead of written to disk.
+	memThreshold int64
+	// HeaderFilter, if not nil, is applied to the headers of every outgoing
+	// request, including redirects.
+	headerFilter HeaderFilter
+	// RetainIdle, if set, keeps layers on disk after their refcount drops to
+	// zero, so they can be reused by later fetches.
+	retainIdle bool
+	// BestEffort, if set, has Realize attempt every layer instead of giving
+	// up at the first failure.
+	bestEffort bool
+	// InsecureRedirects, if set, allows redirects from https to http.
+	insecureRedirects bool
+	// MinThroughput, if non-zero, is the number of bytes per second a layer
+	// must be read at, measured over ThroughputWindow.
+	minThroughput    int64
+	throughputWindow time.Duration
+	// StrictMediaType, if set, has layers' declared media types checked
+	// against what's fetched. See WithStrictMediaType.
+	strictMediaType bool
+	// Throttle, if not nil, limits the rate layers are read at. See
+	// WithBandwidthLimit.
+	throttle *throttle
+	// SpoolDir, if not empty, is where layers are written while in use. It's
+	// always different from the root.
+	spoolDir string
+	// Shared, if set, has layer files in the root shared with other
+	// processes using the same root. See WithSharedRoot.
+	shared bool
+	// TarIndex, if set, has an index of tar entries written alongside every
+	// layer stored on disk.
+	tarIndex bool
+	// IdleTTL, if non-zero, is how long after being fetched a layer may be
+	// retained. SweepInterval is how often expired layers are looked for.
+	idleTTL       time.Duration
+	sweepInterval time.Duration
+	// Clock is what the TTL and the sweep go by. Tests replace it.
+	clock clock
+	// ReadAhead, if non-zero, is the size of the buffer response bodies are
+	// read through. See defaultReadAhead.
+	readAhead int
+	// Retry, if not nil, decides whether failed layer requests are retried.
+	retry RetryPolicy
+	// MaxHeaderBytes, if non-zero, is the limit on the size of a Layer's
+	// headers. See defaultMaxHeaderBytes.
+	maxHeaderBytes int
+	// DiffID, if set, has the digest of every layer's uncompressed tar
+	// stream computed. See WithDiffID.
+	diffID bool
+	// MaxDecoderWindow, if non-zero, is the largest zstd window a layer may
+	// use. See defaultMaxDecoderWindow.
+	maxDecoderWindow uint64
+	// MaxRatio, if non-zero, is the limit on the compression ratio of the
+	// start of a layer. See WithRatioCheck.
+	maxRatio int
+	// MaxLayerSize, if non-zero, is the limit on the decompressed size of a
+	// layer. See WithMaxLayerSize.
+	maxLayerSize int64
+	// FetchTimeout and QueueTimeout, if non-zero, bound the time a fetch
+	// may take once started and the time it may wait for slots beforehand.
+	// See WithFetchTimeout.
+	fetchTimeout time.Duration
+	queueTimeout time.Duration
+	// Cache, if not nil, holds layers across restarts and arenas. See
+	// WithLayerStore.
+	cache LayerStore
+	// Canonical, if non-zero, is the format stored copies of layers are
+	// re-encoded into. See WithCanonicalFormat.
+	canonical BlobFormat
+	// Lazy, if set, has only the files of interest fetched from layers that
+	// allow it. See WithLazyFetch.
+	lazy bool
+	// QuotaMax and quotaFailFast configure the disk quota, which is set up
+	// once the options are applied. See WithDiskQuota.
+	quotaMax      int64
+	quotaFailFast bool
+	quota         *diskQuota
+	// DigestAlgos, if not nil, are the digest algorithms layers may be
+	// verified with. See WithDigestAlgorithms.
+	digestAlgos map[string]bool
+	// Trace, if not nil, has a FetchTrace written for every fetch. See
+	// WithTraceFile.
+	trace *traceWriter
+	// Decoders holds decompressors for reuse.
+	decoders *decoderPool
+	// Pool, if not nil, decompresses layers once they've been fetched. See
+	// WithDecompressWorkers.
+	pool *decompressPool
+	// LocalFS, if not nil, is where layers with file URIs are read from. See
+	// WithLocalLayers.
+	localFS fs.FS
+	// Decompress decides which layers are stored compressed. See
+	// WithDecompressPolicy.
+	decompress DecompressPolicy
+	// WrapWriter, if not nil, wraps the writer layer contents are copied
+	// into. Used for testing.
+	wrapWriter func(io.Writer) io.Writer
 
 	mu sync.Mutex
 	// Rc is a map of digest to refcount.
 	rc map[string]int
+	// Formats is a map of digest to the detected encoding of the fetched
+	// blob. Only populated when storing compressed layers.
+	formats map[string]compression
+	// Supplied is a map of digest to the path of a caller-supplied file
+	// holding the layer. These files are never renamed or removed.
+	supplied map[string]string
+	// Mem is a map of digest to the contents of layers held in memory.
+	mem map[string][]byte
+	// Verified is a map of digest to the digest the layer's contents were
+	// actually verified against.
+	verified map[string]claircore.Digest
+	// DiffIDs is a map of digest to the digest of the layer's uncompressed
+	// tar stream. Only populated when computing them.
+	diffIDs map[string]claircore.Digest
+	// Compressed is a map of digest to the compression of layers stored as
+	// fetched, to be decompressed as they're read. Only populated with a
+	// DecompressPolicy other than DecompressOnFetch.
+	compressed map[string]compression
+	// Fetched is a map of digest to the time the layer was fetched. Only
+	// populated when retained layers expire.
+	fetched map[string]time.Time
+	// Idle is a map of digest to layers retained on disk with a zero
+	// refcount. Only populated when retaining idle layers.
+	idle map[string]*idleLayer
+	// Spooled is the set of digests of layers in use that are stored in the
+	// spool directory rather than the root.
+	spooled map[string]struct{}
+	// Entries is a map of digest to the tar index of a layer in use, loaded
+	// on first lookup.
+	entries map[string]map[string]TarEntry
+	// Busy is a map of digest to a channel that's closed once an idle layer
+	// is done being compacted or expanded.
+	busy map[string]chan struct{}
+	// Closing is set once Shutdown is called. No new layers are fetched
+	// after that.
+	closing bool
+	// Drained, if not nil, is closed once the last reference is released
+	// during a Shutdown.
+	drained chan struct{}
+	// Sockets is a map of Unix socket path to the client used to dial it.
+	sockets map[string]*http.Client
+	// SweepStop, if not nil, is closed to stop the goroutine sweeping
+	// expired layers. See WithIdleTTL.
+	sweepStop chan struct{}
+	// RootLock, if not nil, holds a shared lock on the root for as long as
+	// the arena is open. See Clean.
+	rootLock *os.File
+	// LayerLocks is a map of digest to the locked layer file of a layer in
+	// use. Only populated when the root is shared.
+	layerLocks map[string]*os.File
 
 	root string
 }
 
 // NewRemoteFetchArena initializes the RemoteFetchArena.
 //
+// The arena fetches layers with "wc", or http.DefaultClient if it's nil. To
+// use a distinct client for fetching, construct the arena with it.
+//
 // This method is provided instead of a constructor function to make embedding
 // easier.
-func NewRemoteFetchArena(wc *http.Client, root string) *RemoteFetchArena {
-	return &RemoteFetchArena{
-		wc:   wc,
-		root: root,
-		sf:   &singleflight.Group{},
-		rc:   make(map[string]int),
+func NewRemoteFetchArena(wc *http.Client, root string, opts ...ArenaOption) *RemoteFetchArena {
+	if wc == nil {
+		wc = http.DefaultClient
+	}
+	a := &RemoteFetchArena{
+		wc:         wc,
+		root:       root,
+		sf:         &singleflight.Group{},
+		rc:         make(map[string]int),
+		formats:    make(map[string]compression),
+		supplied:   make(map[string]string),
+		mem:        make(map[string][]byte),
+		verified:   make(map[string]claircore.Digest),
+		diffIDs:    make(map[string]claircore.Digest),
+		compressed: make(map[string]compression),
+		idle:       make(map[string]*idleLayer),
+		busy:       make(map[string]chan struct{}),
+		entries:    make(map[string]map[string]TarEntry),
+		spooled:    make(map[string]struct{}),
+		fetched:    make(map[string]time.Time),
+		clock:      realClock{},
+	}
+	for _, o := range opts {
+		o(a)
+	}
+	if a.shared && sharedRootSupported {
+		// Other processes only know to look for layers themselves.
+		a.storeCompressed = false
+		a.tarIndex = false
+		a.retainIdle = false
+		a.spoolDir = ""
+		a.decompress = DecompressOnFetch
+		a.layerLocks = make(map[string]*os.File)
+	} else {
+		a.shared = false
+	}
+	// Caller-supplied files are expected to hold tars, and a kept copy of
+	// the compressed layer would be a second one.
+	if a.layerFile != nil || a.storeCompressed {
+		a.decompress = DecompressOnFetch
+	}
+	a.decoders = newDecoderPool(a.maxDecoderWindow)
+	if a.quotaMax > 0 {
+		a.quota = newDiskQuota(root, a.quotaMax, a.quotaFailFast, a.dirs()...)
+	}
+	// If the root can't be locked now, Clean tries again.
+	if lf, err := lockRoot(root); err == nil {
+		a.rootLock = lf
 	}
+	return a
 }
 
 func (a *RemoteFetchArena) forget(digest string) error {
@@ -73,11 +293,45 @@ func (a *RemoteFetchArena) forget(digest string) error {
 	if !ok {
 		return nil
 	}
+	defer a.refsChanged()
 	ct--
 	if ct == 0 {
+		defer a.reportUsage()
 		delete(a.rc, digest)
+		delete(a.entries, digest)
 		defer a.sf.Forget(digest)
-		return os.Remove(filepath.Join(a.root, digest))
+		if a.drained != nil && len(a.rc) == 0 {
+			defer func() {
+				close(a.drained)
+				a.drained = nil
+			}()
+		}
+		now := a.clock.Now()
+		if a.retainIdle && !a.closing && !a.expired(digest, now) && a.onDisk(digest) && a.unspool(digest) {
+			a.idle[digest] = &idleLayer{since: now}
+			return nil
+		}
+		delete(a.verified, digest)
+		delete(a.diffIDs, digest)
+		delete(a.compressed, digest)
+		delete(a.fetched, digest)
+		if err := a.removeBlob(digest); err != nil {
+			return err
+		}
+		if err := a.removeTarIndex(digest); err != nil {
+			return err
+		}
+		if _, ok := a.supplied[digest]; ok {
+			delete(a.supplied, digest)
+			return nil
+		}
+		if _, ok := a.mem[digest]; ok {
+			delete(a.mem, digest)
+			return nil
+		}
+		err := a.removeLayer(digest)
+		delete(a.spooled, digest)
+		return err
 	}
 	a.rc[digest] = ct
 	return nil
@@ -87,12 +341,42 @@ func (a *RemoteFetchArena) forget(digest string) error {
 // the file to the permanent place if applicable.
 func (a *RemoteFetchArena) fetchOne(ctx context.Context, l *claircore.Layer) (do func() error) {
 	do = func() error {
+		if err := a.checkClosing(); err != nil {
+			return err
+		}
 		h := l.Hash.String()
 		tgt := filepath.Join(a.root, h)
 		var ff string
+		// Source is set if this call's function is the one run; otherwise
+		// the result is shared with a concurrent fetch.
+		src := sourceShared
 		select {
 		case res := <-a.sf.DoChan(h, func() (interface{}, error) {
-			return a.realizeLayer(ctx, l)
+			switch p, ok, err := a.reuse(ctx, h); {
+			case err != nil:
+				return nil, err
+			case ok:
+				src = sourceRetained
+				return p, nil
+			}
+			switch p, ok, err := a.fromPeer(l); {
+			case err != nil:
+				return nil, err
+			case ok:
+				src = sourcePeer
+				return p, nil
+			}
+			if p, ok := a.fromCache(ctx, l); ok {
+				src = sourceCache
+				return p, nil
+			}
+			src = sourceFetch
+			p, err := a.realize(ctx, l)
+			if err != nil {
+				return nil, err
+			}
+			a.toCache(ctx, l, p)
+			return p, nil
 		}):
 			if err := res.Err; err != nil {
 				return err
@@ -102,22 +386,102 @@ func (a *RemoteFetchArena) fetchOne(ctx context.Context, l *claircore.Layer) (do
 			return ctx.Err()
 		}
 		a.mu.Lock()
+		if ff == "" {
+			// Realized into memory.
+			b, ok := a.mem[h]
+			if !ok {
+				// Released while we were waiting on the lock.
+				a.mu.Unlock()
+				return do()
+			}
+			defer a.mu.Unlock()
+			if err := checkDiffID(l, a.diffIDs[h]); err != nil {
+				return err
+			}
+			a.rc[h]++
+			a.refsChanged()
+			a.countRequest(src)
+			l.SetBuffer(b)
+			l.SetVerified(a.verified[h])
+			l.SetDiffID(a.diffIDs[h])
+			return nil
+		}
 		ct, ok := a.rc[h]
+		// A layer already in use was fetched for another Layer, which may
+		// have expected something else.
+		if ok {
+			if err := checkDiffID(l, a.diffIDs[h]); err != nil {
+				a.mu.Unlock()
+				return err
+			}
+		}
 		if !ok {
 			// Did the file get removed while we were waiting on the lock?
 			if _, err := os.Stat(ff); errors.Is(err, os.ErrNotExist) {
 				a.mu.Unlock()
 				return do()
 			}
-			if err := os.Rename(ff, tgt); err != nil {
-				a.mu.Unlock()
-				return err
+			switch {
+			case a.layerFile != nil:
+				// Caller-supplied files stay where they are.
+				a.supplied[h] = ff
+			case a.shared:
+				lf, err := publishLayer(ff, tgt)
+				if errors.Is(err, os.ErrNotExist) {
+					// Removed by the last process using it before it
+					// could be locked.
+					a.mu.Unlock()
+					return do()
+				}
+				if err != nil {
+					a.mu.Unlock()
+					return err
+				}
+				a.layerLocks[h] = lf
+			default:
+				spooled := a.spoolDir != "" && filepath.Dir(ff) == a.spoolDir
+				if spooled {
+					tgt = filepath.Join(a.spoolDir, h)
+				}
+				if err := os.Rename(ff, tgt); err != nil {
+					a.mu.Unlock()
+					return err
+				}
+				if spooled {
+					a.spooled[h] = struct{}{}
+				}
+			}
+			if a.storeCompressed {
+				if err := os.Rename(ff+blobSuffix, tgt+blobSuffix); err != nil {
+					a.mu.Unlock()
+					return err
+				}
+			}
+			if a.tarIndex && a.layerFile == nil {
+				// The index is missing if building it failed.
+				err := os.Rename(ff+tarIndexSuffix, tgt+tarIndexSuffix)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					a.mu.Unlock()
+					return err
+				}
 			}
 		}
 		defer a.mu.Unlock()
 		ct++
 		a.rc[h] = ct
-		l.SetLocal(tgt)
+		a.refsChanged()
+		a.countRequest(src)
+		tgt = filepath.Join(a.dir(h), h)
+		if p, ok := a.supplied[h]; ok {
+			tgt = p
+		}
+		if c, ok := a.compressed[h]; ok {
+			l.SetStream(a.decompressor(tgt, c))
+		} else {
+			l.SetLocal(tgt)
+		}
+		l.SetVerified(a.verified[h])
+		l.SetDiffID(a.diffIDs[h])
 		return nil
 	}
 	return do
@@ -126,13 +490,18 @@ func (a *RemoteFetchArena) fetchOne(ctx context.Context, l *claircore.Layer) (do
 // Close removes all files left in the arena.
 //
 // It's not an error to have active fetchers, but may cause errors to have files
-// unlinked underneath their users.
+// unlinked underneath their users. Use Shutdown to wait for them instead.
 func (a *RemoteFetchArena) Close(ctx context.Context) error {
 	ctx = zlog.ContextWithValues(ctx,
 		"component", "libindex/fetchArena.Close",
 		"arena", a.root)
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	a.stopSweep()
+	defer a.closeMetrics()
+	if a.quota != nil {
+		defer a.quota.close()
+	}
 	if len(a.rc) != 0 {
 		zlog.Warn(ctx).
 			Int("count", len(a.rc)).
@@ -144,7 +513,35 @@ func (a *RemoteFetchArena) Close(ctx context.Context) error {
 	for d := range a.rc {
 		delete(a.rc, d)
 		a.sf.Forget(d)
-		if e := os.Remove(filepath.Join(a.root, d)); e != nil {
+		delete(a.verified, d)
+		delete(a.diffIDs, d)
+		delete(a.compressed, d)
+		delete(a.fetched, d)
+		if e := a.removeBlob(d); e != nil {
+			if err == nil {
+				err = e
+			} else {
+				err = fmt.Errorf("%v; %v", err, e)
+			}
+		}
+		if e := a.removeTarIndex(d); e != nil {
+			if err == nil {
+				err = e
+			} else {
+				err = fmt.Errorf("%v; %v", err, e)
+			}
+		}
+		if _, ok := a.supplied[d]; ok {
+			delete(a.supplied, d)
+			continue
+		}
+		if _, ok := a.mem[d]; ok {
+			delete(a.mem, d)
+			continue
+		}
+		e := a.removeLayer(d)
+		delete(a.spooled, d)
+		if e != nil {
 			if err == nil {
 				err = e
 				continue
@@ -152,240 +549,1506 @@ func (a *RemoteFetchArena) Close(ctx context.Context) error {
 			err = fmt.Errorf("%v; %v", err, e)
 		}
 	}
+	if e := a.clearIdle(); e != nil {
+		if err == nil {
+			err = e
+		} else {
+			err = fmt.Errorf("%v; %v", err, e)
+		}
+	}
+	if a.rootLock != nil {
+		unlockRoot(a.rootLock)
+		a.rootLock = nil
+	}
+	if a.trace != nil {
+		if e := a.trace.Close(); e != nil {
+			if err == nil {
+				err = e
+			} else {
+				err = fmt.Errorf("%v; %v", err, e)
+			}
+		}
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// RealizeLayer is the inner function used inside the singleflight.
+// BlobSuffix is appended to a layer's file name to name the copy of the layer
+// as fetched.
+const blobSuffix = ".blob"
+
+// RemoveBlob removes the stored copy of the layer as fetched, if any.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) removeBlob(digest string) error {
+	if _, ok := a.formats[digest]; !ok {
+		return nil
+	}
+	delete(a.formats, digest)
+	err := os.Remove(filepath.Join(a.dir(digest), digest) + blobSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Blob reports the location of the layer with the provided digest exactly as
+// it was fetched, along with a description of its encoding: one of "tar",
+// "gzip", "zstd", "estargz", "zstd:seekable", or "zstd:chunked".
+//
+// This only reports true for layers currently held by the arena that were
+// fetched with WithStoreCompressed. Seekable formats keep their footers
+// intact, so the file is suitable for tooling that does lazy or partial
+// reads.
+func (a *RemoteFetchArena) Blob(d claircore.Digest) (path, format string, ok bool) {
+	h := d.String()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.formats[h]
+	if !ok {
+		return "", "", false
+	}
+	if _, ok := a.rc[h]; !ok {
+		return "", "", false
+	}
+	return filepath.Join(a.dir(h), h) + blobSuffix, c.String(), true
+}
+
+// Realize is the function used inside the singleflight.
+//
+// It calls realizeLayer by way of realizeMirrors and, if that runs out of
+// space and the arena has an EvictFunc configured, makes one eviction pass and
+// retries once.
+func (a *RemoteFetchArena) realize(ctx context.Context, l *claircore.Layer) (string, error) {
+	name, err := a.realizeMirrors(ctx, l)
+	full := errors.Is(err, ErrNoSpace) || errors.Is(err, ErrQuotaExceeded)
+	if !full || a.evict == nil {
+		return name, err
+	}
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/fetchArena.realize",
+		"arena", a.root,
+		"layer", l.Hash.String())
+	zlog.Info(ctx).
+		Err(err).
+		Msg("arena full, attempting eviction")
+	freed, eerr := a.evict(ctx)
+	if eerr != nil {
+		zlog.Warn(ctx).
+			Err(eerr).
+			Msg("eviction failed")
+	}
+	if freed <= 0 {
+		return "", err
+	}
+	zlog.Debug(ctx).
+		Int64("freed", freed).
+		Msg("eviction freed space, retrying")
+	return a.realizeMirrors(ctx, l)
+}
+
+// RealizeRetry is realizeLayer, starting over if the connection fails partway
+// through the response body and the arena's RetryPolicy allows it.
+//
+// Failed requests are retried by doRetry; this handles what it can't, as a
+// partial layer can't be resumed. Each attempt waits for slots and runs under
+// the fetch timeout anew, and the RetryPolicy sees attempts counted from 1
+// separately from any retried requests within them.
+//
+// A layer that runs into a blocking disk quota partway through is started
+// over as well, once there's room for more than it managed to write. That
+// doesn't count as an attempt.
+func (a *RemoteFetchArena) realizeRetry(ctx context.Context, l *claircore.Layer) (string, error) {
+	var need int64
+	for attempt := 1; ; {
+		name, err := a.realizeLayer(ctx, l, need)
+		var qe *errQuota
+		if errors.As(err, &qe) && qe.need > 0 && qe.need < a.quota.max && !a.quota.failFast && ctx.Err() == nil {
+			zlog.Debug(ctx).
+				Str("layer", l.Hash.String()).
+				Int64("need", qe.need).
+				Msg("layer fetch ran out of quota, retrying")
+			need = qe.need
+			continue
+		}
+		var be *errBodyRead
+		if a.retry == nil || !errors.As(err, &be) {
+			return name, err
+		}
+		d, ok := a.retry.NextDelay(attempt, nil, err)
+		if !ok {
+			return name, err
+		}
+		zlog.Info(ctx).
+			Str("layer", l.Hash.String()).
+			Int("attempt", attempt).
+			Dur("delay", d).
+			Err(err).
+			Msg("layer fetch interrupted, retrying")
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return "", err
+		case <-t.C:
+		}
+		attempt++
+	}
+}
+
+// RealizeLayer does the actual fetching and validation of a layer. If the
+// arena has a disk quota, it waits for "need" bytes of room before starting.
 //
-// The returned value is a temporary filename in the arena.
-func (a *RemoteFetchArena) realizeLayer(ctx context.Context, l *claircore.Layer) (string, error) {
+// The returned value is a temporary filename in the arena, or the name of the
+// caller-supplied file. If the layer was kept in memory, the returned value is
+// the empty string and the contents are in the "mem" map.
+//
+// Once the slots are held, the work is done in stages by a layerFetch; see
+// its methods.
+func (a *RemoteFetchArena) realizeLayer(ctx context.Context, l *claircore.Layer, need int64) (_ string, err error) {
 	ctx = zlog.ContextWithValues(ctx,
 		"component", "libindex/fetchArena.realizeLayer",
 		"arena", a.root,
 		"layer", l.Hash.String(),
 		"uri", l.URI)
 	zlog.Debug(ctx).Msg("layer fetch start")
+	f := &layerFetch{a: a, l: l, tr: a.newTrace(l), rm: true}
+	start := time.Now()
+	defer func() {
+		f.tr.finish(f.st, f.n, err)
+		a.observeFetch(start, f.sent, f.st, f.n, err)
+		a.reportUsage()
+	}()
 
-	// Validate the layer input.
-	if l.URI == "" {
-		return "", fmt.Errorf("empty uri for layer %v", l.Hash)
+	if err := a.checkClosing(); err != nil {
+		return "", err
 	}
-	url, err := url.ParseRequestURI(l.URI)
+	url, vh, err := checkLayer(l, a.digestAlgos)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse remote path uri: %v", err)
+		return "", err
 	}
-	if l.Hash.Checksum() == nil {
-		return "", fmt.Errorf("digest is empty")
+	f.vh = vh
+	if max := a.maxLayerSize; max > 0 && l.UncompressedSize > max {
+		return "", &errLayerTooLarge{max: max, size: l.UncompressedSize}
 	}
-	vh := l.Hash.Hash()
-	want := l.Hash.Checksum()
 
-	// Open our target file before hitting the network.
-	rm := true
-	fd, err := os.CreateTemp(a.root, "fetch.*")
-	if err != nil {
-		return "", fmt.Errorf("fetcher: unable to create file: %w", err)
+	// Time spent waiting for slots is bounded by the queue timeout, if
+	// any, and doesn't count against the fetch timeout.
+	qctx := ctx
+	if a.queueTimeout > 0 {
+		var cancel context.CancelFunc
+		qctx, cancel = context.WithTimeout(ctx, a.queueTimeout)
+		defer cancel()
 	}
-	name := fd.Name()
-	defer func() {
-		if err := fd.Close(); err != nil {
-			zlog.Warn(ctx).Err(err).Msg("unable to close layer file")
+	queued := func(err error) error {
+		if ctx.Err() == nil && errors.Is(qctx.Err(), context.DeadlineExceeded) {
+			return &errTimeout{phase: ErrQueueTimeout, d: a.queueTimeout, inner: err}
 		}
-		if rm {
-			if err := os.Remove(name); err != nil {
-				zlog.Warn(ctx).Err(err).Msg("unable to remove unsuccessful layer fetch")
-			}
+		return err
+	}
+	// Waiting for disk space happens before taking any slots, so it
+	// doesn't hold up fetches of layers that are already on disk.
+	if a.quota != nil && a.layerFile == nil {
+		if err := a.quota.admit(qctx, need); err != nil {
+			return "", queued(err)
 		}
-	}()
-	// It'd be nice to be able to pre-allocate our file on disk, but we can't
-	// because of decompression.
-
-	req := &http.Request{
-		ProtoMajor: 1,
-		ProtoMinor: 1,
-		Method:     http.MethodGet,
-		URL:        url,
-		Header:     l.Headers,
 	}
-	req = req.WithContext(ctx)
-	resp, err := a.wc.Do(req)
+	// Take a slot shared with other manifests, if configured, before one
+	// of the arena's: waiting on the former while holding the latter would
+	// defeat the point.
+	release, err := indexer.AcquireLayerSlot(qctx)
 	if err != nil {
-		return "", fmt.Errorf("fetcher: request failed: %w", err)
-	}
-	defer resp.Body.Close()
-	switch resp.StatusCode {
-	case http.StatusOK:
-	default:
-		// Especially for 4xx errors, the response body may indicate what's going
-		// on, so include some of it in the error message. Capped at 256 bytes in
-		// order to not flood the log.
-		bodyStart, err := io.ReadAll(io.LimitReader(resp.Body, 256))
-		if err == nil {
-			return "", fmt.Errorf("fetcher: unexpected status code: %s (body starts: %q)",
-				resp.Status, bodyStart)
+		return "", fmt.Errorf("fetcher: unable to acquire layer slot: %w", queued(err))
+	}
+	defer release()
+	// The fetch slot may be given up before returning, once the layer is
+	// off the network.
+	releaseFetch := func() {}
+	if a.sem != nil {
+		zlog.Debug(ctx).Msg("waiting for arena fetch slot")
+		if err := a.sem.Acquire(qctx, 1); err != nil {
+			return "", fmt.Errorf("fetcher: unable to acquire fetch slot: %w", queued(err))
 		}
-		return "", fmt.Errorf("fetcher: unexpected status code: %s", resp.Status)
+		var once sync.Once
+		releaseFetch = func() { once.Do(func() { a.sem.Release(1) }) }
+	}
+	defer releaseFetch()
+	// The fetch timeout starts once the slots are held.
+	if a.fetchTimeout > 0 {
+		parent := ctx
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.fetchTimeout)
+		defer cancel()
+		fctx := ctx
+		defer func() {
+			if err != nil && parent.Err() == nil && errors.Is(fctx.Err(), context.DeadlineExceeded) {
+				err = &errTimeout{phase: ErrFetchTimeout, d: a.fetchTimeout, inner: err}
+			}
+		}()
 	}
-	tr := io.TeeReader(resp.Body, vh)
 
-	br := bufio.NewReader(tr)
-	// Look at the content-type and optionally fix it up.
-	ct := resp.Header.Get("content-type")
-	zlog.Debug(ctx).
-		Str("content-type", ct).
-		Msg("reported content-type")
-	if ct == "" || ct == "text/plain" || ct == "binary/octet-stream" || ct == "application/octet-stream" {
-		zlog.Debug(ctx).
-			Str("content-type", ct).
-			Msg("guessing compression")
-		b, err := br.Peek(4)
-		if err != nil {
+	defer f.close()
+	if err := f.spool(ctx); err != nil {
+		return "", err
+	}
+	if err := f.fetch(ctx, url); err != nil {
+		return "", err
+	}
+	// With a decompression pool, the blob is checked before any time is
+	// spent decompressing it, and the fetch slot isn't held while waiting
+	// for a worker. Otherwise, it's decompressed as it's read off the
+	// network, and can only be checked at the end.
+	if f.pooled {
+		if err := f.verify(ctx); err != nil {
 			return "", err
 		}
-		switch detectCompression(b) {
-		case cmpGzip:
-			ct = "application/gzip"
-		case cmpZstd:
-			ct = "application/zstd"
-		case cmpNone:
-			ct = "application/x-tar"
+		releaseFetch()
+		if err := f.decompress(ctx); err != nil {
+			return "", err
 		}
-		zlog.Debug(ctx).
-			Str("format", ct).
-			Msg("guessed compression")
-	}
-
-	var r io.Reader
-	switch {
-	case ct == "application/vnd.docker.image.rootfs.diff.tar.gzip":
-		// Catch the old docker media type.
-		fallthrough
-	case ct == "application/gzip" || ct == "application/x-gzip":
-		// GHCR reports gzipped layers as the latter.
-		fallthrough
-	case strings.HasSuffix(ct, ".tar+gzip"):
-		g, err := gzip.NewReader(br)
-		if err != nil {
+	} else {
+		if err := f.decompress(ctx); err != nil {
 			return "", err
 		}
-		defer g.Close()
-		r = g
-	case ct == "application/zstd":
-		fallthrough
-	case strings.HasSuffix(ct, ".tar+zstd"):
-		s, err := zstd.NewReader(br)
-		if err != nil {
+		if err := f.verify(ctx); err != nil {
 			return "", err
 		}
-		defer s.Close()
-		r = s
-	case ct == "application/x-tar":
-		fallthrough
-	case strings.HasSuffix(ct, ".tar"):
-		r = br
-	default:
-		return "", fmt.Errorf("fetcher: unknown content-type %q", ct)
 	}
+	return f.finish(ctx)
+}
 
-	buf := bufio.NewWriter(fd)
-	n, err := io.Copy(buf, r)
-	zlog.Debug(ctx).Int64("size", n).Msg("wrote file")
-	if err != nil {
-		return "", err
-	}
-	if err := buf.Flush(); err != nil {
-		return "", err
-	}
-	if got := vh.Sum(nil); !bytes.Equal(got, want) {
-		err := fmt.Errorf("fetcher: validation failed: got %q, expected %q",
-			hex.EncodeToString(got),
-			hex.EncodeToString(want))
-		return "", err
-	}
+// LayerFetch is the state of a single run of realizeLayer.
+//
+// The stages are run in order: spool, fetch, then decompress and verify in
+// whichever order the decompression calls for, then finish. Close cleans up
+// after all of them, and removes the layer's files unless finish succeeded.
+type layerFetch struct {
+	a  *RemoteFetchArena
+	l  *claircore.Layer
+	tr *fetchTrace
+	vh *digestVerifier
+	// Cleanup holds the functions close calls, in reverse.
+	cleanup []func()
+	// Rm is cleared once the layer's files are to be kept.
+	rm bool
 
-	zlog.Debug(ctx).
-		Msg("checking if layer is a valid tar")
-	// TODO(hank) Need media types somewhere in here.
-	switch _, err := tarfs.New(fd); {
-	case errors.Is(err, nil):
-	case errors.Is(err, tarfs.ErrFormat):
-		fallthrough
-	default:
-		return "", err
-	}
+	// Set by spool.
+	name      string
+	fd        *os.File
+	fw        io.Writer
+	hw        io.Writer
+	bf        *os.File
+	blob      *bufio.Writer
+	tail      *tailBuffer
+	held      *heldWriter
+	rawBlob   *os.File
+	rawWriter *bufio.Writer
 
-	zlog.Debug(ctx).Msg("layer fetch ok")
-	rm = false
-	return name, nil
+	// Set by fetch.
+	sent   time.Time
+	st     *layerStream
+	onRead bool
+	pooled bool
+	raw    string
+
+	// Set by decompress.
+	sw       *spillWriter
+	dh       hash.Hash
+	diffAlgo string
+	enc      io.WriteCloser
+	n        int64
+
+	// Set by verify.
+	matched claircore.Digest
 }
 
-// Fetcher returns an indexer.Fetcher.
-func (a *RemoteFetchArena) Realizer(_ context.Context) indexer.Realizer {
-	return &FetchProxy{a: a}
+// OnClose arranges for "fn" to be called by close.
+func (f *layerFetch) onClose(fn func()) {
+	f.cleanup = append(f.cleanup, fn)
 }
 
-// FetchProxy tracks the files fetched for layers.
-//
-// This can be unexported if FetchArena gets unexported.
-type FetchProxy struct {
-	a     *RemoteFetchArena
-	clean []string
+// Close runs the cleanup functions, most recently added first.
+func (f *layerFetch) close() {
+	for i := len(f.cleanup) - 1; i >= 0; i-- {
+		f.cleanup[i]()
+	}
+	f.cleanup = nil
 }
 
-// Realize populates all the layers locally.
-func (p *FetchProxy) Realize(ctx context.Context, ls []*claircore.Layer) error {
-	g, ctx := errgroup.WithContext(ctx)
-	p.clean = make([]string, len(ls))
-	for i, l := range ls {
-		p.clean[i] = l.Hash.String()
-		g.Go(p.a.fetchOne(ctx, l))
+// Spool opens the file the layer is written to, and the file the blob is
+// stored in if the arena stores compressed layers, and sets up the writer the
+// bytes off the wire go to.
+func (f *layerFetch) spool(ctx context.Context) (err error) {
+	a, l := f.a, f.l
+	// Open our target file before hitting the network.
+	if a.layerFile != nil {
+		f.fd, err = a.layerFile(ctx, l)
+		if err != nil {
+			return fmt.Errorf("fetcher: unable to obtain layer file: %w", err)
+		}
+	} else {
+		dir := a.root
+		if a.spoolDir != "" {
+			dir = a.spoolDir
+		}
+		f.fd, err = os.CreateTemp(dir, "fetch.*")
+		if err != nil {
+			return fmt.Errorf("fetcher: unable to create file: %w", err)
+		}
 	}
-	if err := g.Wait(); err != nil {
-		return fmt.Errorf("encountered error while fetching a layer: %w", err)
+	fd := f.fd
+	f.name = fd.Name()
+	f.fw = fd
+	if a.layerFile == nil {
+		// Added first, so it runs once the file is closed or removed.
+		var done func()
+		f.fw, done = a.quotaWriter(f.name, fd)
+		f.onClose(done)
+	}
+	f.onClose(func() {
+		if f.rm && a.layerFile != nil {
+			// Not ours to remove, but don't leave a partial layer behind.
+			if err := fd.Truncate(0); err != nil {
+				zlog.Warn(ctx).Err(err).Msg("unable to truncate unsuccessful layer fetch")
+			}
+		}
+		if err := fd.Close(); err != nil {
+			zlog.Warn(ctx).Err(err).Msg("unable to close layer file")
+		}
+		if f.rm && a.layerFile == nil {
+			if err := os.Remove(f.name); err != nil {
+				zlog.Warn(ctx).Err(err).Msg("unable to remove unsuccessful layer fetch")
+			}
+		}
+	})
+	// If storing compressed layers, the bytes off the wire are copied
+	// verbatim into a second file, unless they're being re-encoded into
+	// the canonical format.
+	f.hw = f.vh
+	if a.storeCompressed {
+		f.bf, err = os.OpenFile(f.name+blobSuffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return fmt.Errorf("fetcher: unable to create file: %w", err)
+		}
+		bf := f.bf
+		bw, done := a.quotaWriter(bf.Name(), bf)
+		f.onClose(done)
+		f.onClose(func() {
+			if err := bf.Close(); err != nil {
+				zlog.Warn(ctx).Err(err).Msg("unable to close blob file")
+			}
+			if f.rm {
+				if err := os.Remove(bf.Name()); err != nil {
+					zlog.Warn(ctx).Err(err).Msg("unable to remove unsuccessful blob fetch")
+				}
+			}
+		})
+		f.blob = bufio.NewWriter(bw)
+		if a.canonical == 0 {
+			f.tail = &tailBuffer{size: footerSize}
+			f.hw = io.MultiWriter(f.vh, f.blob, f.tail)
+		}
+	}
+	// Whether the bytes off the wire are stored as the layer depends on how
+	// it turns out to be compressed, so they're held until that's known.
+	if a.decompress != DecompressOnFetch {
+		f.held = &heldWriter{}
+		f.hw = io.MultiWriter(f.hw, f.held)
+	}
+	// The blob file holds the verbatim response body in the usual case;
+	// "raw" is what's handed to download.
+	f.rawBlob, f.rawWriter = f.bf, f.blob
+	if a.canonical != 0 {
+		f.rawBlob, f.rawWriter = nil, nil
 	}
 	return nil
 }
 
-// Close marks all the layers' backing files as unused.
-//
-// This method may actually delete the backing files.
-func (p *FetchProxy) Close() error {
-	var err error
-	for _, digest := range p.clean {
-		e := p.a.forget(digest)
-		if e != nil {
-			if err == nil {
-				err = e
-			} else {
-				err = fmt.Errorf("%v; %v", err, e)
-			}
+// Fetch makes the request for the layer and works out how it's to be
+// decompressed. With a decompression pool, compressed layers are downloaded
+// in full here; otherwise, the response body is set up to be decompressed as
+// it's read.
+func (f *layerFetch) fetch(ctx context.Context, url *url.URL) error {
+	a := f.a
+	f.tr.mark(traceRequest)
+	f.sent = time.Now()
+	st, err := a.open(ctx, f.l, url, f.hw)
+	if err != nil {
+		return err
+	}
+	f.st = st
+	f.onClose(func() { st.Close() })
+	f.tr.mark(traceFirstByte)
+	f.tr.stream(st)
+	if a.maxRatio > 0 {
+		if err := st.checkRatio(a.maxRatio); err != nil {
+			return err
+		}
+	}
+	f.onRead = f.held != nil && a.decompress.onRead(st.c)
+	if f.held != nil {
+		var w io.Writer
+		if f.onRead {
+			w = f.fw
+		}
+		if err := f.held.release(w); err != nil {
+			return noSpace(err)
+		}
+	}
+	// Layers stored compressed are only decompressed to check them, which
+	// may as well happen as they're read.
+	f.pooled = a.pool != nil && st.c != cmpNone && !f.onRead
+	if !f.pooled {
+		f.tr.mark(traceDecompress)
+		if err := st.decompress(); err != nil {
+			return err
+		}
+	}
+	// The stored copy is exactly the response body, so its space can be
+	// claimed up front if the length is known. Chunked responses don't
+	// have one.
+	if f.rawBlob != nil && st.contentLength > 0 {
+		if err := preallocate(f.bf, st.contentLength); err != nil {
+			return err
+		}
+	}
+	if f.onRead && st.contentLength > 0 {
+		if err := preallocate(f.fd, st.contentLength); err != nil {
+			return err
 		}
 	}
+	if !f.pooled {
+		return nil
+	}
+	raw, err := a.download(ctx, st, f.rawBlob, f.rawWriter)
 	if err != nil {
 		return err
 	}
+	f.raw = raw
+	if f.rawBlob == nil {
+		f.onClose(func() {
+			if err := os.Remove(raw); err != nil {
+				zlog.Warn(ctx).Err(err).Msg("unable to remove downloaded blob")
+			}
+		})
+	}
 	return nil
 }
 
-type compression int
+// Decompress writes out the decompressed layer, from the response body or,
+// with a decompression pool, the downloaded blob.
+func (f *layerFetch) decompress(ctx context.Context) (err error) {
+	a, l, st := f.a, f.l, f.st
+	w := f.fw
+	if f.onRead {
+		// The decompressed layer is only checked.
+		w = io.Discard
+	}
+	if a.wrapWriter != nil {
+		w = a.wrapWriter(w)
+	}
+	// Small layers are kept in memory. The size estimate may be wrong, so
+	// the file is kept around to spill into.
+	if a.memThreshold > 0 && a.layerFile == nil && !a.storeCompressed && !f.onRead {
+		sz, known := l.UncompressedSize, true
+		if sz == 0 {
+			sz, known = estimateSize(st.contentLength, st.c)
+		}
+		zlog.Debug(ctx).
+			Int64("estimate", sz).
+			Bool("known", known).
+			Int64("threshold", a.memThreshold).
+			Msg("layer size")
+		// A layer of unknown size, such as one sent chunked, starts out in
+		// memory as well: spilling bounds the cost of guessing wrong.
+		if !known || sz < a.memThreshold {
+			f.sw = &spillWriter{limit: a.memThreshold, w: w}
+			w = f.sw
+		}
+	}
+	// Unlike the response, the decompressed layer's size is only known if
+	// the Layer says so.
+	if f.sw == nil && a.layerFile == nil && !f.onRead && l.UncompressedSize > 0 {
+		if err := preallocate(f.fd, l.UncompressedSize); err != nil {
+			return err
+		}
+	}
+	// The uncompressed digest is taken of what's written out, using the
+	// expected DiffID's algorithm if there is one.
+	f.diffAlgo = "sha256"
+	switch want := l.ExpectedDiffID; {
+	case want != nil:
+		f.diffAlgo = want.Algorithm()
+		f.dh = want.Hash()
+	case a.diffID:
+		f.dh = sha256.New()
+	}
+	if f.dh != nil {
+		w = io.MultiWriter(w, f.dh)
+	}
+	// The canonical copy is encoded from the decompressed layer, after the
+	// original bytes have gone through the verifier.
+	if a.canonical != 0 {
+		enc, err := a.canonical.encoder(f.blob)
+		if err != nil {
+			return err
+		}
+		f.enc = enc
+		f.onClose(func() { enc.Close() })
+		w = io.MultiWriter(w, enc)
+	}
+	// Checked last, so nothing past the limit is written anywhere.
+	if a.maxLayerSize > 0 {
+		w = &sizeLimitWriter{w: w, max: a.maxLayerSize}
+	}
+	buf := bufio.NewWriter(w)
+	if f.pooled {
+		zlog.Debug(ctx).Msg("waiting for decompression worker")
+		err = a.pool.do(ctx, func() error {
+			f.tr.mark(traceDecompress)
+			var err error
+			f.n, err = decompressFile(ctx, a.decoders, f.raw, st.c, a.readAheadSize(), buf)
+			return err
+		})
+		zlog.Debug(ctx).Int64("size", f.n).Msg("wrote file")
+		if err != nil {
+			return err
+		}
+		f.tr.mark(traceCopy)
+		return nil
+	}
+	if f.onRead {
+		f.n, err = copyTar(buf, st.r)
+	} else {
+		f.n, err = io.Copy(buf, st.r)
+	}
+	zlog.Debug(ctx).Int64("size", f.n).Msg("wrote file")
+	if err != nil {
+		return noSpace(err)
+	}
+	if err := buf.Flush(); err != nil {
+		return noSpace(err)
+	}
+	f.tr.mark(traceCopy)
+	return nil
+}
+
+// Verify checks the blob against the layer's digests. Unless the blob was
+// downloaded in full, the rest of the response body is read first.
+func (f *layerFetch) verify(ctx context.Context) (err error) {
+	if !f.pooled {
+		// Make sure anything after the end of the compressed stream is
+		// read, so that it's included in the digest and any stored copy.
+		if _, err := io.Copy(io.Discard, f.st.raw); err != nil {
+			return err
+		}
+		if err := f.st.checkLength(); err != nil {
+			return err
+		}
+	}
+	if f.matched, err = f.vh.Verify(); err != nil {
+		return err
+	}
+	f.tr.mark(traceVerify)
+	if f.matched.String() != f.l.Hash.String() {
+		zlog.Info(ctx).
+			Stringer("digest", f.matched).
+			Msg("layer verified by alternate digest")
+	}
+	return nil
+}
+
+// Finish checks the decompressed layer, completes any stored blob, and
+// records the layer in the arena. Its return values are realizeLayer's.
+func (f *layerFetch) finish(ctx context.Context) (string, error) {
+	a, l := f.a, f.l
+	if want := l.UncompressedSize; want != 0 && f.n != want {
+		return "", &errSizeMismatch{got: f.n, want: want}
+	}
+
+	inMem := f.sw != nil && !f.sw.spilled
+	var tf io.ReaderAt = f.fd
+	if inMem {
+		tf = bytes.NewReader(f.sw.buf.Bytes())
+	}
+	// Layers stored compressed were checked by copyTar.
+	if !f.onRead {
+		zlog.Debug(ctx).
+			Bool("memory", inMem).
+			Msg("checking if layer is a valid tar")
+		// TODO(hank) Need media types somewhere in here.
+		switch _, err := tarfs.New(tf); {
+		case errors.Is(err, nil):
+		case errors.Is(err, tarfs.ErrFormat):
+			fallthrough
+		default:
+			return "", err
+		}
+	}
+
+	c := f.st.c
+	if a.storeCompressed {
+		if f.enc != nil {
+			if err := f.enc.Close(); err != nil {
+				return "", noSpace(err)
+			}
+		}
+		if err := f.blob.Flush(); err != nil {
+			return "", noSpace(err)
+		}
+		if a.canonical != 0 {
+			c = a.canonical.compression()
+		} else {
+			c = detectSeekable(c, f.tail.Bytes())
+		}
+		zlog.Debug(ctx).
+			Stringer("format", c).
+			Msg("stored blob")
+		a.mu.Lock()
+		a.formats[l.Hash.String()] = c
+		a.mu.Unlock()
+	}
+	if a.tarIndex && !inMem && a.layerFile == nil && !f.onRead {
+		// The index is only an optimization, so a layer without one is
+		// still usable.
+		if err := writeTarIndex(f.fd, f.n); err != nil {
+			zlog.Warn(ctx).
+				Err(err).
+				Msg("unable to build tar index")
+		}
+	}
+
+	var diffID claircore.Digest
+	if f.dh != nil {
+		var err error
+		if diffID, err = claircore.NewDigest(f.diffAlgo, f.dh.Sum(nil)); err != nil {
+			return "", err
+		}
+		if err := checkDiffID(l, diffID); err != nil {
+			return "", err
+		}
+	}
+
+	zlog.Debug(ctx).Msg("layer fetch ok")
+	a.mu.Lock()
+	a.verified[l.Hash.String()] = f.matched
+	if f.dh != nil {
+		a.diffIDs[l.Hash.String()] = diffID
+	}
+	if f.onRead {
+		a.compressed[l.Hash.String()] = c
+	}
+	if a.idleTTL > 0 {
+		a.fetched[l.Hash.String()] = a.clock.Now()
+	}
+	a.mu.Unlock()
+	if inMem {
+		// Leave rm set, so the unused file is cleaned up.
+		a.mu.Lock()
+		a.mem[l.Hash.String()] = f.sw.buf.Bytes()
+		a.mu.Unlock()
+		return "", nil
+	}
+	f.rm = false
+	return f.name, nil
+}
+
+// CheckDiffID reports an error if the layer has an ExpectedDiffID that
+// disagrees with "got", the DiffID found for its contents. A "got" that's
+// unknown or uses a different algorithm can't disagree.
+func checkDiffID(l *claircore.Layer, got claircore.Digest) error {
+	want := l.ExpectedDiffID
+	if want == nil || got.Checksum() == nil || want.Algorithm() != got.Algorithm() {
+		return nil
+	}
+	if got.String() != want.String() {
+		return &errDiffID{got: got, want: *want}
+	}
+	return nil
+}
+
+// CheckLayer validates the layer input, returning the parsed URI and a
+// verifier for the layer's digests. If "allow" is not nil, only digests using
+// the algorithms in it are verified.
+func checkLayer(l *claircore.Layer, allow map[string]bool) (*url.URL, *digestVerifier, error) {
+	uri := l.URI
+	if uri == "" && l.Repository != "" {
+		var err error
+		if uri, err = repositoryURI(l); err != nil {
+			return nil, nil, err
+		}
+	}
+	if uri == "" {
+		return nil, nil, fmt.Errorf("empty uri for layer %v", l.Hash)
+	}
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse remote path uri: %v", err)
+	}
+	if l.Hash.Checksum() == nil {
+		return nil, nil, fmt.Errorf("digest is empty")
+	}
+	vh, err := newDigestVerifier(l, allow)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, vh, nil
+}
+
+// DefaultReadAhead is the size of the buffer response bodies are read through
+// when WithReadAheadSize isn't used. It's much larger than bufio's default, as
+// decompressors make lots of small reads and each refill of the buffer is a
+// trip to the network.
+const defaultReadAhead = 128 * 1024
+
+// ReadAheadSize returns the configured read-ahead size, or the default.
+func (a *RemoteFetchArena) readAheadSize() int {
+	if a.readAhead == 0 {
+		return defaultReadAhead
+	}
+	return a.readAhead
+}
+
+// LayerStream is an in-progress layer download.
+type layerStream struct {
+	// R is the decompressed layer.
+	r io.Reader
+	// Raw is the response body. Everything read from it has been copied to
+	// the Writer passed to stream.
+	raw *bufio.Reader
+	c   compression
+	// ContentLength is the length of the response body, or -1 if it's not
+	// known, as with a chunked response.
+	contentLength int64
+	// Chunked reports whether the response used chunked transfer encoding.
+	chunked bool
+	// Read counts the bytes read from the response body.
+	read *countWriter
+	// Decoders supplies the decompressor.
+	decoders *decoderPool
+	body     io.Closer
+	done     []func()
+}
+
+// CheckLength reports an error if the response body was a different length
+// than the response said. Call it once the body has been read to the end.
+func (s *layerStream) checkLength() error {
+	if s.contentLength < 0 {
+		return nil
+	}
+	if n := s.read.n; n != s.contentLength {
+		return &errSizeMismatch{got: n, want: s.contentLength}
+	}
+	return nil
+}
+
+// CountWriter passes writes through to "w", counting the bytes written.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// SizeLimitWriter passes up to "max" bytes to "w", and fails any write that
+// would go past that with an errLayerTooLarge.
+type sizeLimitWriter struct {
+	w   io.Writer
+	n   int64
+	max int64
+}
+
+func (l *sizeLimitWriter) Write(b []byte) (int, error) {
+	if l.n+int64(len(b)) > l.max {
+		return 0, &errLayerTooLarge{max: l.max}
+	}
+	n, err := l.w.Write(b)
+	l.n += int64(n)
+	return n, err
+}
+
+// IsChunked reports whether the response body is sent with chunked transfer
+// encoding.
+func isChunked(resp *http.Response) bool {
+	for _, te := range resp.TransferEncoding {
+		if strings.EqualFold(te, "chunked") {
+			return true
+		}
+	}
+	return false
+}
+
+// Close releases the decompressor and the response body.
+func (s *layerStream) Close() error {
+	for _, f := range s.done {
+		f()
+	}
+	return s.body.Close()
+}
+
+// Stream requests the layer and sets up decompression. All the bytes off the
+// wire are copied to "hw".
+//
+// The caller must call Close on the returned layerStream.
+func (a *RemoteFetchArena) stream(ctx context.Context, l *claircore.Layer, url *url.URL, hw io.Writer) (*layerStream, error) {
+	st, err := a.open(ctx, l, url, hw)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.decompress(); err != nil {
+		st.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+// Open requests the layer and works out how it's compressed, like stream, but
+// leaves the response body undecoded.
+//
+// The caller must call Close on the returned layerStream.
+func (a *RemoteFetchArena) open(ctx context.Context, l *claircore.Layer, url *url.URL, hw io.Writer) (*layerStream, error) {
+	if url.Scheme == "file" {
+		return a.openLocal(ctx, l, url, hw)
+	}
+	c, req, err := a.newRequest(l, url)
+	if err != nil {
+		return nil, err
+	}
+	// The throughput monitor needs to be able to cancel a stalled read.
+	var cancel context.CancelFunc
+	if a.minThroughput > 0 {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	req = req.WithContext(ctx)
+	resp, err := a.doRetry(ctx, c, req)
+	if err != nil {
+		if cancel != nil {
+			cancel()
+		}
+		return nil, &errRequest{what: "request", inner: err}
+	}
+	var body io.ReadCloser = bodyReader{resp.Body}
+	body = a.throttled(ctx, resp, body)
+	if cancel != nil {
+		body = newThroughputMonitor(body, cancel, a.minThroughput, a.throughputWindow)
+	}
+	st := layerStream{
+		body:          body,
+		contentLength: resp.ContentLength,
+		chunked:       isChunked(resp),
+		read:          &countWriter{w: hw},
+		decoders:      a.decoders,
+	}
+	if st.chunked {
+		// The http package already reports an unknown length for these,
+		// but be sure: a Content-Length sent alongside is meaningless.
+		st.contentLength = -1
+	}
+	// The descriptor's size is for the whole blob, so it can only be checked
+	// against whole responses.
+	if l.Size > 0 && resp.StatusCode == http.StatusOK {
+		switch {
+		case st.contentLength < 0:
+			st.contentLength = l.Size
+		case st.contentLength != l.Size:
+			resp.Body.Close()
+			if cancel != nil {
+				cancel()
+			}
+			return nil, &errSizeMismatch{got: st.contentLength, want: l.Size}
+		}
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			st.Close()
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp)
+	}
+	zlog.Debug(ctx).
+		Int64("content-length", st.contentLength).
+		Bool("chunked", st.chunked).
+		Msg("response length")
+	if err := a.sniff(ctx, l, &st, body, resp.Header.Get("content-type")); err != nil {
+		return nil, err
+	}
+	ok = true
+	return &st, nil
+}
+
+// Sniff sets up the stream to read "body", working out how the layer is
+// compressed from the reported content-type "ct", the layer's media type, and
+// the start of the body.
+func (a *RemoteFetchArena) sniff(ctx context.Context, l *claircore.Layer, st *layerStream, body io.Reader, ct string) error {
+	tr := io.TeeReader(body, st.read)
+
+	br := bufio.NewReaderSize(tr, a.readAheadSize())
+	st.raw = br
+	// Look at the content-type and optionally fix it up.
+	reported := ct
+	zlog.Debug(ctx).
+		Str("content-type", ct).
+		Msg("reported content-type")
+	// A zero-length body is an empty layer, no matter what it claims to be.
+	// The digest check makes sure it was supposed to be empty.
+	empty := false
+	if _, err := br.Peek(1); errors.Is(err, io.EOF) {
+		zlog.Debug(ctx).
+			Msg("empty body, treating as empty tar")
+		ct = "application/x-tar"
+		empty = true
+	}
+	// The descriptor's media type is what the layer really is; registries
+	// commonly serve blobs as octet-streams.
+	if l.MediaType != "" && !empty {
+		ct = l.MediaType
+	}
+	// Peek returns io.EOF on bodies shorter than the magic we're looking for.
+	// These can't be compressed, so let detectCompression sort it out.
+	magic, err := br.Peek(6)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if genericContentType(ct) {
+		zlog.Debug(ctx).
+			Str("content-type", ct).
+			Msg("guessing compression")
+		switch detectCompression(magic) {
+		case cmpGzip:
+			ct = "application/gzip"
+		case cmpZstd:
+			ct = "application/zstd"
+		case cmpXz:
+			ct = "application/x-xz"
+		case cmpBzip2:
+			ct = "application/x-bzip2"
+		case cmpNone:
+			ct = "application/x-tar"
+		}
+		zlog.Debug(ctx).
+			Str("format", ct).
+			Msg("guessed compression")
+	}
+
+	cmp, known := mediaTypeCompression(ct)
+	if !known {
+		if a.strictMediaType && ct == l.MediaType {
+			return &MediaTypeError{Declared: l.MediaType, Reported: reported}
+		}
+		return fmt.Errorf("fetcher: unknown content-type %q", ct)
+	}
+	st.c = cmp
+	if a.strictMediaType && l.MediaType != "" && !empty {
+		if err := checkMediaType(l.MediaType, cmp, reported, magic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenericContentType reports whether the content-type says nothing about
+// what's in a layer, so its compression has to be guessed.
+func genericContentType(ct string) bool {
+	return ct == "" || ct == "text/plain" || ct == "binary/octet-stream" || ct == "application/octet-stream"
+}
+
+// MediaTypeCompression returns the compression of layers with the media type
+// or content-type, reporting false if it's not a recognized layer type.
+func mediaTypeCompression(ct string) (compression, bool) {
+	switch {
+	case ct == "application/vnd.docker.image.rootfs.diff.tar.gzip":
+		// Catch the old docker media type.
+		fallthrough
+	case ct == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip":
+		// Foreign layers, such as Windows base layers, are the same format.
+		fallthrough
+	case ct == "application/gzip" || ct == "application/x-gzip":
+		// GHCR reports gzipped layers as the latter.
+		fallthrough
+	case strings.HasSuffix(ct, ".tar+gzip"):
+		return cmpGzip, true
+	case ct == "application/zstd":
+		fallthrough
+	case strings.HasSuffix(ct, ".tar+zstd"):
+		return cmpZstd, true
+	case ct == "application/x-xz":
+		// Not a registered layer media type, but seen on layers converted
+		// from other image formats.
+		return cmpXz, true
+	case ct == "application/x-bzip2":
+		return cmpBzip2, true
+	case ct == "application/x-tar":
+		fallthrough
+	case strings.HasSuffix(ct, ".tar"):
+		return cmpNone, true
+	}
+	return 0, false
+}
+
+// CheckMediaType reports a *MediaTypeError if the content-type the server
+// reported or the start of the layer, "magic", disagree with the compression
+// of the declared media type. A generic content-type isn't checked.
+func checkMediaType(declared string, c compression, reported string, magic []byte) error {
+	if !genericContentType(reported) {
+		if rc, ok := mediaTypeCompression(reported); !ok || rc != c {
+			return &MediaTypeError{Declared: declared, Reported: reported}
+		}
+	}
+	if dc := detectCompression(magic); dc != c {
+		return &MediaTypeError{Declared: declared, Reported: reported, Detected: dc.String()}
+	}
+	return nil
+}
+
+// NewRequest returns a request for the layer and the client to send it with.
+func (a *RemoteFetchArena) newRequest(l *claircore.Layer, url *url.URL) (*http.Client, *http.Request, error) {
+	c := a.wc
+	if isUnixScheme(url.Scheme) {
+		sock, u, err := unixSocket(url)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c, err = a.unixClient(sock); err != nil {
+			return nil, nil, err
+		}
+		url = u
+	}
+	hdr := http.Header(l.Headers)
+	if err := checkHeader(hdr, a.maxHeaderBytes); err != nil {
+		return nil, nil, err
+	}
+	if a.headerFilter != nil {
+		hdr = a.filterHeader(url.Host, hdr)
+	}
+	req := &http.Request{
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Method:     http.MethodGet,
+		URL:        url,
+		Header:     hdr,
+	}
+	return c, req, nil
+}
+
+// Decompress sets up the stream's decompressor, reading from the response
+// body.
+func (s *layerStream) decompress() error {
+	r, done, err := s.decoders.decoder(s.c, s.raw)
+	if err != nil {
+		return err
+	}
+	s.r = r
+	if done != nil {
+		s.done = append(s.done, done)
+	}
+	return nil
+}
+
+// DigestVerifier hashes a layer once per digest algorithm, so that it can be
+// checked against the Layer's Hash and any AcceptableDigests.
+type digestVerifier struct {
+	io.Writer
+	want   []claircore.Digest
+	hashes map[string]hash.Hash
+}
+
+func newDigestVerifier(l *claircore.Layer, allow map[string]bool) (*digestVerifier, error) {
+	v := digestVerifier{
+		hashes: make(map[string]hash.Hash),
+	}
+	for _, d := range append([]claircore.Digest{l.Hash}, l.AcceptableDigests...) {
+		if d.Checksum() == nil {
+			return nil, fmt.Errorf("fetcher: invalid acceptable digest %q", d)
+		}
+		if allow != nil && !allow[d.Algorithm()] {
+			continue
+		}
+		v.want = append(v.want, d)
+	}
+	if len(v.want) == 0 {
+		return nil, fmt.Errorf("fetcher: %w: layer %v has no digest using an allowed algorithm", ErrDigestAlgorithm, l.Hash)
+	}
+	var ws []io.Writer
+	for _, d := range v.want {
+		a := d.Algorithm()
+		if _, ok := v.hashes[a]; ok {
+			continue
+		}
+		h := d.Hash()
+		v.hashes[a] = h
+		ws = append(ws, h)
+	}
+	v.Writer = io.MultiWriter(ws...)
+	return &v, nil
+}
+
+// Verify returns the first acceptable digest that matches what's been written,
+// or an error if none do.
+func (v *digestVerifier) Verify() (claircore.Digest, error) {
+	sums := make(map[string][]byte, len(v.hashes))
+	for a, h := range v.hashes {
+		sums[a] = h.Sum(nil)
+	}
+	for _, d := range v.want {
+		if bytes.Equal(sums[d.Algorithm()], d.Checksum()) {
+			return d, nil
+		}
+	}
+	if len(v.want) == 1 {
+		return claircore.Digest{}, &errDigestMismatch{
+			got:  []string{hex.EncodeToString(sums[v.want[0].Algorithm()])},
+			want: []string{hex.EncodeToString(v.want[0].Checksum())},
+		}
+	}
+	e := errDigestMismatch{
+		got:  make([]string, 0, len(v.want)),
+		want: make([]string, 0, len(v.want)),
+	}
+	for _, d := range v.want {
+		e.got = append(e.got, d.Algorithm()+":"+hex.EncodeToString(sums[d.Algorithm()]))
+		e.want = append(e.want, d.String())
+	}
+	return claircore.Digest{}, &e
+}
+
+// CompressionRatio is the assumed ratio of uncompressed to compressed size when
+// estimating the size of a compressed layer.
+const compressionRatio = 4
+
+// EstimateSize guesses the uncompressed size of a layer from the reported
+// Content-Length and the detected compression. The second return reports
+// whether a guess could be made.
+func estimateSize(cl int64, c compression) (int64, bool) {
+	if cl < 0 {
+		return 0, false
+	}
+	if c == cmpNone {
+		return cl, true
+	}
+	return cl * compressionRatio, true
+}
+
+// SpillWriter buffers writes in memory until they would reach "limit" bytes,
+// then moves everything to "w".
+type spillWriter struct {
+	buf     bytes.Buffer
+	limit   int64
+	w       io.Writer
+	spilled bool
+}
+
+func (s *spillWriter) Write(p []byte) (int, error) {
+	if s.spilled {
+		return s.w.Write(p)
+	}
+	if int64(s.buf.Len()+len(p)) < s.limit {
+		return s.buf.Write(p)
+	}
+	s.spilled = true
+	if _, err := s.w.Write(s.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	s.buf = bytes.Buffer{}
+	return s.w.Write(p)
+}
+
+// Do issues the request with the provided client, answering registry
+// authentication challenges if the arena is configured to.
+func (a *RemoteFetchArena) do(ctx context.Context, c *http.Client, req *http.Request) (*http.Response, error) {
+	if a.headerFilter != nil || !a.insecureRedirects {
+		// Use a copy of the client so that redirects are checked and
+		// filtered too.
+		cc := *c
+		next := cc.CheckRedirect
+		cc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if !a.insecureRedirects {
+				prev := via[len(via)-1].URL
+				if prev.Scheme == "https" && req.URL.Scheme == "http" {
+					return fmt.Errorf("%w: %s redirected to %s", ErrInsecureRedirect, prev.Redacted(), req.URL.Redacted())
+				}
+			}
+			if a.headerFilter != nil {
+				req.Header = a.filterHeader(req.URL.Host, req.Header)
+			}
+			if next != nil {
+				return next(req, via)
+			}
+			// This is the http package's default policy.
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		}
+		c = &cc
+	}
+	if a.auth == nil {
+		return c.Do(req)
+	}
+	return a.auth.Do(ctx, c, req)
+}
+
+// FilterHeader calls the configured HeaderFilter with a copy of the provided
+// headers.
+func (a *RemoteFetchArena) filterHeader(host string, h http.Header) http.Header {
+	out := a.headerFilter(host, h.Clone())
+	if out == nil {
+		out = make(http.Header)
+	}
+	return out
+}
+
+// Realizer returns an indexer.Realizer.
+func (a *RemoteFetchArena) Realizer(ctx context.Context) indexer.Realizer {
+	a.mu.Lock()
+	a.startSweep(ctx)
+	a.mu.Unlock()
+	return &FetchProxy{a: a}
+}
+
+// FetchProxy tracks the files fetched for layers.
+//
+// Realize and Close may be called concurrently. Close cancels any Realize
+// calls in progress and waits for them to return before releasing layers, and
+// Realize calls made after Close fail with ErrRealizerClosed.
+//
+// This can be unexported if FetchArena gets unexported.
+type FetchProxy struct {
+	a *RemoteFetchArena
+	// Ops tracks in-flight Realize calls for Close.
+	ops lifecycle.Tracker
+
+	mu sync.Mutex
+	// Clean holds the digest of every layer a reference was taken on, once
+	// per reference.
+	clean []string
+	// Partial holds the names of the files holding lazily fetched layers,
+	// which belong to the proxy alone.
+	partial []string
+}
+
+// Realize populates all the layers locally.
+//
+// A failure is reported as a *LayerError identifying the layer. Normally the
+// first failure cancels the remaining fetches; if the arena was configured
+// with WithBestEffort, every layer is attempted and all failures are reported
+// in a *FetchError.
+func (p *FetchProxy) Realize(ctx context.Context, ls []*claircore.Layer) error {
+	ctx, done, ok := p.ops.Begin(ctx)
+	defer done()
+	if !ok {
+		return fmt.Errorf("fetcher: %w", ErrRealizerClosed)
+	}
+	if p.a.bestEffort {
+		return p.realizeAll(ctx, ls)
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	lim := p.a.newLimiter()
+	var stopped error
+	for _, l := range ls {
+		l := l
+		if err := lim.acquire(ctx); err != nil {
+			// Either a fetch failed, which Wait reports, or the caller gave
+			// up.
+			stopped = &LayerError{Layer: l.Hash, URI: l.URI, Err: err}
+			break
+		}
+		fetch := p.fetch(ctx, l)
+		g.Go(func() error {
+			defer lim.release()
+			if err := fetch(); err != nil {
+				return &LayerError{Layer: l.Hash, URI: l.URI, Err: err}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("encountered error while fetching a layer: %w", err)
+	}
+	if stopped != nil {
+		return fmt.Errorf("encountered error while fetching a layer: %w", stopped)
+	}
+	return nil
+}
+
+// RealizeAll is Realize without the cancellation on first error.
+func (p *FetchProxy) realizeAll(ctx context.Context, ls []*claircore.Layer) error {
+	var g errgroup.Group
+	errs := make([]error, len(ls))
+	lim := p.a.newLimiter()
+	for i, l := range ls {
+		i, l := i, l
+		if err := lim.acquire(ctx); err != nil {
+			errs[i] = &LayerError{Layer: l.Hash, URI: l.URI, Err: err}
+			continue
+		}
+		fetch := p.fetch(ctx, l)
+		g.Go(func() error {
+			defer lim.release()
+			if err := fetch(); err != nil {
+				errs[i] = &LayerError{Layer: l.Hash, URI: l.URI, Err: err}
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	var fe FetchError
+	for _, err := range errs {
+		if err != nil {
+			fe.Errs = append(fe.Errs, err)
+		}
+	}
+	if len(fe.Errs) != 0 {
+		return fmt.Errorf("encountered errors while fetching layers: %w", &fe)
+	}
+	return nil
+}
+
+// Limiter bounds the number of goroutines a Realize call has running. A nil
+// limiter never blocks.
+type limiter chan struct{}
+
+// NewLimiter returns a limiter for a Realize call, per WithRealizeConcurrency.
+func (a *RemoteFetchArena) newLimiter() limiter {
+	if a.realizeLimit < 1 {
+		return nil
+	}
+	return make(limiter, a.realizeLimit)
+}
+
+// Acquire waits for a slot, reporting an error if the Context is done first.
+func (l limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release gives up a slot taken by acquire.
+func (l limiter) release() {
+	if l == nil {
+		return
+	}
+	<-l
+}
+
+// AddRef records a reference taken on the layer, to be released by Close.
+func (p *FetchProxy) addRef(digest string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clean = append(p.clean, digest)
+}
+
+// Close marks all the layers' backing files as unused.
+//
+// Any Realize calls in progress are canceled, and Close waits for them to
+// return so that every layer they took a reference on is released. Calling
+// Close more than once is not an error.
+//
+// This method may actually delete the backing files.
+func (p *FetchProxy) Close() error {
+	// Shutting down with a done Context cancels in-flight calls, then waits
+	// for them.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.ops.Shutdown(ctx)
+
+	p.mu.Lock()
+	clean, partial := p.clean, p.partial
+	p.clean, p.partial = nil, nil
+	p.mu.Unlock()
+	var err error
+	for _, digest := range clean {
+		e := p.a.forget(digest)
+		if e != nil {
+			if err == nil {
+				err = e
+			} else {
+				err = fmt.Errorf("%v; %v", err, e)
+			}
+		}
+	}
+	for _, name := range partial {
+		if e := os.Remove(name); e != nil {
+			if err == nil {
+				err = e
+			} else {
+				err = fmt.Errorf("%v; %v", err, e)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+type compression int
 
 const (
 	cmpGzip compression = iota
 	cmpZstd
 	cmpNone
+	// These are seekable variants, only detectable from the end of the
+	// blob.
+	cmpEStargz
+	cmpSeekableZstd
+	cmpZstdChunked
+	// These aren't used by any image format, but turn up in layers from
+	// older build systems.
+	cmpXz
+	cmpBzip2
 )
 
+func (c compression) String() string {
+	switch c {
+	case cmpGzip:
+		return "gzip"
+	case cmpZstd:
+		return "zstd"
+	case cmpNone:
+		return "tar"
+	case cmpEStargz:
+		return "estargz"
+	case cmpSeekableZstd:
+		return "zstd:seekable"
+	case cmpZstdChunked:
+		return "zstd:chunked"
+	case cmpXz:
+		return "xz"
+	case cmpBzip2:
+		return "bzip2"
+	}
+	return fmt.Sprintf("compression(%d)", int(c))
+}
+
+// CmpHeaders holds the magic numbers of the formats that can be detected from
+// the start of a blob.
 var cmpHeaders = [...][]byte{
-	{0x1F, 0x8B, 0x08},       // cmpGzip
-	{0x28, 0xB5, 0x2F, 0xFD}, // cmpZstd
+	cmpGzip:  {0x1F, 0x8B, 0x08},
+	cmpZstd:  {0x28, 0xB5, 0x2F, 0xFD},
+	cmpXz:    {0xFD, '7', 'z', 'X', 'Z', 0x00},
+	cmpBzip2: {'B', 'Z', 'h'},
 }
 
 func detectCompression(b []byte) compression {
 	for c, h := range cmpHeaders {
-		if len(b) < len(h) {
+		if len(h) == 0 || len(b) < len(h) {
 			continue
 		}
 		if bytes.Equal(h, b[:len(h)]) {
@@ -394,3 +2057,72 @@ func detectCompression(b []byte) compression {
 	}
 	return cmpNone
 }
+
+// FooterSize is the number of trailing bytes examined by detectSeekable.
+//
+// The largest footer recognized is eStargz's, at 51 bytes.
+const footerSize = 64
+
+var (
+	// SeekableZstdMagic is the little-endian magic number at the very end of
+	// a seekable zstd seek table.
+	seekableZstdMagic = []byte{0xB1, 0xEA, 0x92, 0x8F}
+	// ZstdChunkedMagic ends the footer of a "zstd:chunked" layer.
+	zstdChunkedMagic = []byte("GNUlInUx")
+	// EStargzMagic ends the gzip "extra" field in an eStargz footer.
+	estargzMagic = []byte("STARGZ")
+)
+
+// DetectSeekable refines the compression detected from the start of a blob by
+// looking at its last bytes for the footers of seekable formats.
+func detectSeekable(c compression, tail []byte) compression {
+	switch c {
+	case cmpGzip:
+		// The eStargz footer is an empty gzip member whose header "extra"
+		// field holds the offset of the TOC and a magic string.
+		i := bytes.LastIndex(tail, cmpHeaders[cmpGzip])
+		if i == -1 {
+			break
+		}
+		z, err := gzip.NewReader(bytes.NewReader(tail[i:]))
+		if err != nil {
+			break
+		}
+		defer z.Close()
+		if bytes.HasSuffix(z.Header.Extra, estargzMagic) {
+			return cmpEStargz
+		}
+	case cmpZstd:
+		switch {
+		case bytes.HasSuffix(tail, zstdChunkedMagic):
+			return cmpZstdChunked
+		case bytes.HasSuffix(tail, seekableZstdMagic):
+			return cmpSeekableZstd
+		}
+	}
+	return c
+}
+
+// TailBuffer is an io.Writer that keeps the last "size" bytes written to it.
+type tailBuffer struct {
+	b    []byte
+	size int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= t.size {
+		t.b = append(t.b[:0], p[n-t.size:]...)
+		return n, nil
+	}
+	t.b = append(t.b, p...)
+	if over := len(t.b) - t.size; over > 0 {
+		t.b = append(t.b[:0], t.b[over:]...)
+	}
+	return n, nil
+}
+
+// Bytes returns the retained bytes.
+func (t *tailBuffer) Bytes() []byte {
+	return t.b
+}
@@ -0,0 +1,93 @@
+package libindex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// BenchmarkFetchReadAhead fetches a gzipped layer over a connection where
+// every read off the network costs a fixed delay, as on a high-latency link,
+// using various read-ahead sizes.
+func BenchmarkFetchReadAhead(b *testing.B) {
+	ctx := context.Background()
+	// Mostly incompressible, so the compressed layer is a decent size.
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(0)).Read(data)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(tarball(b, string(data))); err != nil {
+		b.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		b.Fatal(err)
+	}
+	c, l := serveBlob(b, "application/vnd.oci.image.layer.v1.tar+gzip", gz.Bytes())
+	c.Transport = &latencyTransport{
+		RoundTripper: c.Transport,
+		delay:        50 * time.Microsecond,
+	}
+
+	bench := []struct {
+		name string
+		size int
+	}{
+		{"bufio", 4096},
+		{"Default", 0},
+		{"1MiB", 1 << 20},
+	}
+	for _, bc := range bench {
+		b.Run(bc.name, func(b *testing.B) {
+			ctx := zlog.Test(ctx, b)
+			a := NewRemoteFetchArena(c, b.TempDir(), WithReadAheadSize(bc.size))
+			defer a.Close(ctx)
+			b.SetBytes(int64(gz.Len()))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f := a.Realizer(ctx)
+				l := *l
+				if err := f.Realize(ctx, []*claircore.Layer{&l}); err != nil {
+					b.Fatal(err)
+				}
+				if err := f.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// LatencyTransport delays every read of a response body.
+type latencyTransport struct {
+	http.RoundTripper
+	delay time.Duration
+}
+
+func (t *latencyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	res, err := t.RoundTripper.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = &latencyBody{ReadCloser: res.Body, delay: t.delay}
+	return res, nil
+}
+
+type latencyBody struct {
+	io.ReadCloser
+	delay time.Duration
+}
+
+func (b *latencyBody) Read(p []byte) (int, error) {
+	time.Sleep(b.delay)
+	return b.ReadCloser.Read(p)
+}
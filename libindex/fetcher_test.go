@@ -2,21 +2,36 @@ package libindex
 
 import (
 	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
 	"github.com/quay/zlog"
+	"github.com/ulikunitz/xz"
 
 	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/pkg/tarfs"
 	"github.com/quay/claircore/test"
 )
 
@@ -194,3 +209,1427 @@ func commonLayerServer(t testing.TB, ct int) ([]claircore.Layer, http.Handler) {
 	}
 	return ls, http.FileServer(http.Dir(dir))
 }
+
+// Tarball returns a tar containing one file with the provided contents.
+func tarball(t testing.TB, contents string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Size: int64(len(contents)), Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// GzipTarball is like tarball, but gzip compressed.
+func gzipTarball(t testing.TB, contents string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	z := gzip.NewWriter(&b)
+	z.Write(tarball(t, contents))
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// Tarballs returns "n" distinct tarballs, the i-th holding the digits of "i"
+// repeated "size" times.
+func tarballs(t testing.TB, n, size int) [][]byte {
+	t.Helper()
+	bs := make([][]byte, n)
+	for i := range bs {
+		bs[i] = tarball(t, strings.Repeat(strconv.Itoa(i), size))
+	}
+	return bs
+}
+
+// BlobServer is the handler behind serveBlob and serveBlobs. By default, it
+// responds with the blob and its length; serveOptions change that.
+type blobServer struct {
+	t     testing.TB
+	ct    string
+	blobs map[string][]byte
+	// Seq counts requests, for the options that only affect the first few.
+	seq int64
+
+	delay   time.Duration
+	status  int
+	fail    int
+	cut     int
+	ranges  bool
+	chunked bool
+	reqs    *int64
+	written *int64
+}
+
+// ServeOption changes how a blobServer responds.
+type serveOption func(*blobServer)
+
+// WithDelay holds every response for "d", or until the request is canceled.
+func withDelay(d time.Duration) serveOption {
+	return func(s *blobServer) { s.delay = d }
+}
+
+// WithFailures fails the first "n" requests with the provided status.
+func withFailures(status, n int) serveOption {
+	return func(s *blobServer) { s.status, s.fail = status, n }
+}
+
+// WithCut drops the connection halfway through the body of the first "n"
+// responses.
+func withCut(n int) serveOption {
+	return func(s *blobServer) { s.cut = n }
+}
+
+// WithRanges answers range requests.
+func withRanges() serveOption {
+	return func(s *blobServer) { s.ranges = true }
+}
+
+// WithChunked sends bodies with chunked transfer encoding.
+func withChunked() serveOption {
+	return func(s *blobServer) { s.chunked = true }
+}
+
+// CountRequests counts requests into "n".
+func countRequests(n *int64) serveOption {
+	return func(s *blobServer) { s.reqs = n }
+}
+
+// CountBytes counts the body bytes written into "n".
+func countBytes(n *int64) serveOption {
+	return func(s *blobServer) { s.written = n }
+}
+
+func (s *blobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	seq := int(atomic.AddInt64(&s.seq, 1))
+	if s.reqs != nil {
+		atomic.AddInt64(s.reqs, 1)
+	}
+	if s.delay > 0 {
+		select {
+		case <-time.After(s.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if seq <= s.fail {
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "try again", s.status)
+		return
+	}
+	b, ok := s.blobs[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	out := w
+	if s.written != nil {
+		out = &countingWriter{ResponseWriter: w, n: s.written}
+	}
+	w.Header().Set("content-type", s.ct)
+	switch {
+	case s.ranges:
+		http.ServeContent(out, r, "", time.Time{}, bytes.NewReader(b))
+	case s.chunked:
+		// Writing in pieces with a flush between forces chunked encoding,
+		// as there's no Content-Length.
+		for len(b) > 0 {
+			n := 512
+			if n > len(b) {
+				n = len(b)
+			}
+			out.Write(b[:n])
+			w.(http.Flusher).Flush()
+			b = b[n:]
+		}
+	case seq <= s.cut:
+		w.Header().Set("content-length", strconv.Itoa(len(b)))
+		out.Write(b[:len(b)/2])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			s.t.Error(err)
+			return
+		}
+		conn.Close()
+	default:
+		w.Header().Set("content-length", strconv.Itoa(len(b)))
+		out.Write(b)
+	}
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
+
+// ServeBlobs arranges for the provided blobs to be served with the provided
+// content-type and returns a client and Layers pointing at them, in order.
+//
+// If "ct" is empty, the content-type is reported as
+// "application/octet-stream".
+func serveBlobs(t testing.TB, ct string, bs [][]byte, opts ...serveOption) (*http.Client, []*claircore.Layer) {
+	t.Helper()
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	s := &blobServer{t: t, ct: ct, blobs: make(map[string][]byte, len(bs))}
+	for _, o := range opts {
+		o(s)
+	}
+	ps := make([]string, len(bs))
+	for i, b := range bs {
+		ps[i] = "/" + strconv.Itoa(i)
+		s.blobs[ps[i]] = b
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	ls := make([]*claircore.Layer, len(bs))
+	for i, b := range bs {
+		sum := sha256.Sum256(b)
+		d, err := claircore.NewDigest("sha256", sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ls[i] = &claircore.Layer{
+			URI:     srv.URL + ps[i],
+			Hash:    d,
+			Headers: make(http.Header),
+		}
+	}
+	return srv.Client(), ls
+}
+
+// ServeBlob is serveBlobs for a single blob.
+func serveBlob(t testing.TB, ct string, b []byte, opts ...serveOption) (*http.Client, *claircore.Layer) {
+	t.Helper()
+	c, ls := serveBlobs(t, ct, [][]byte{b}, opts...)
+	return c, ls[0]
+}
+
+func TestFetchEmpty(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if err := tar.NewWriter(zw).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tt := []struct {
+		name string
+		ct   string
+		body []byte
+	}{
+		{name: "ZeroByte"},
+		{name: "ZeroByteTextPlain", ct: "text/plain"},
+		{name: "ZeroByteGzip", ct: "application/vnd.oci.image.layer.v1.tar+gzip"},
+		{name: "EmptyGzipTar", ct: "application/vnd.oci.image.layer.v1.tar+gzip", body: gz.Bytes()},
+		{name: "EmptyGzipTarGuessed", body: gz.Bytes()},
+		{name: "EmptyGzipTarForeign", ct: "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip", body: gz.Bytes()},
+		{name: "EmptyGzipTarNondistributable", ct: "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip", body: gz.Bytes()},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, tc.ct, tc.body)
+			a := NewRemoteFetchArena(c, t.TempDir())
+			f := a.Realizer(ctx)
+			if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			rd, err := l.Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rd.Close()
+			if _, err := tarfs.New(rd); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	t.Run("ZeroByteBadDigest", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "", nil)
+		l.Hash = digest("not empty")
+		a := NewRemoteFetchArena(c, t.TempDir())
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err == nil {
+			t.Error("expected error, got nil")
+		}
+	})
+}
+
+func TestFetchArenaConcurrency(t *testing.T) {
+	const (
+		limit   = 3
+		proxies = 4
+		layers  = 8
+	)
+	ctx := zlog.Test(context.Background(), t)
+	ls, h := commonLayerServer(t, proxies*layers)
+	var cur, peak int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&cur, 1)
+		defer atomic.AddInt64(&cur, -1)
+		for {
+			m := atomic.LoadInt64(&peak)
+			if n <= m || atomic.CompareAndSwapInt64(&peak, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	for i := range ls {
+		ls[i].URI = srv.URL + ls[i].URI
+	}
+	a := NewRemoteFetchArena(srv.Client(), t.TempDir(), WithArenaConcurrency(limit))
+	defer a.Close(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < proxies; i++ {
+		ps := make([]*claircore.Layer, layers)
+		for j := range ps {
+			ps[j] = &ls[i*layers+j]
+		}
+		f := a.Realizer(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f.Realize(ctx, ps); err != nil {
+				t.Error(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	t.Logf("max concurrent fetches: %d", peak)
+	if peak > limit {
+		t.Errorf("got: %d concurrent fetches, want: <= %d", peak, limit)
+	}
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(srv.Client(), t.TempDir(), WithArenaConcurrency(1))
+		// Hold the only slot.
+		if err := a.sem.Acquire(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		defer a.sem.Release(1)
+		ctx, done := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer done()
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, []*claircore.Layer{&ls[0]})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got: %v, want: %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestFetchRealizeConcurrency(t *testing.T) {
+	const (
+		limit  = 2
+		layers = 12
+	)
+	ctx := zlog.Test(context.Background(), t)
+	ls, h := commonLayerServer(t, 2*layers)
+	var cur, peak int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&cur, 1)
+		defer atomic.AddInt64(&cur, -1)
+		for {
+			m := atomic.LoadInt64(&peak)
+			if n <= m || atomic.CompareAndSwapInt64(&peak, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	for i := range ls {
+		ls[i].URI = srv.URL + ls[i].URI
+	}
+
+	for i, opts := range [][]ArenaOption{
+		{WithRealizeConcurrency(limit)},
+		{WithRealizeConcurrency(limit), WithBestEffort()},
+	} {
+		name := "Default"
+		if i == 1 {
+			name = "BestEffort"
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			atomic.StoreInt64(&peak, 0)
+			a := NewRemoteFetchArena(srv.Client(), t.TempDir(), opts...)
+			defer a.Close(ctx)
+			ps := make([]*claircore.Layer, layers)
+			for j := range ps {
+				ps[j] = &ls[i*layers+j]
+			}
+			f := a.Realizer(ctx)
+			defer f.Close()
+			if err := f.Realize(ctx, ps); err != nil {
+				t.Fatal(err)
+			}
+			for _, l := range ps {
+				rc, err := l.Reader()
+				if err != nil {
+					t.Errorf("%v: %v", l.Hash, err)
+					continue
+				}
+				rc.Close()
+			}
+			t.Logf("max concurrent fetches: %d", peak)
+			if got := atomic.LoadInt64(&peak); got > limit {
+				t.Errorf("got: %d concurrent fetches, want: <= %d", got, limit)
+			}
+		})
+	}
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(srv.Client(), t.TempDir(), WithRealizeConcurrency(1))
+		defer a.Close(ctx)
+		ctx, done := context.WithCancel(ctx)
+		done()
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, []*claircore.Layer{&ls[0], &ls[1]})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+// FullWriter reports ENOSPC on every write, like writing to /dev/full.
+type fullWriter struct{}
+
+func (fullWriter) Write(_ []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: "/dev/full", Err: syscall.ENOSPC}
+}
+
+func TestFetchNoSpace(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var b bytes.Buffer
+	if err := tar.NewWriter(&b).Close(); err != nil {
+		t.Fatal(err)
+	}
+	client, l := serveBlob(t, "application/x-tar", b.Bytes())
+
+	tt := []struct {
+		Name string
+		// Fails is the number of attempts that should see a full disk.
+		Fails int64
+		// Freed is what the EvictFunc reports, if not nil.
+		Freed *int64
+		// Evictions is the expected number of EvictFunc calls.
+		Evictions int64
+		// Attempts is the expected number of writes attempted.
+		Attempts int64
+		Err      bool
+	}{
+		{Name: "NoEvict", Fails: 1, Attempts: 1, Err: true},
+		{Name: "Evicted", Fails: 1, Freed: new(int64), Evictions: 1, Attempts: 2},
+		{Name: "NothingFreed", Fails: 1, Freed: new(int64), Evictions: 1, Attempts: 1, Err: true},
+		{Name: "RetryOnce", Fails: 100, Freed: new(int64), Evictions: 1, Attempts: 2, Err: true},
+	}
+	*tt[1].Freed = 4096
+	*tt[3].Freed = 4096
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			root := t.TempDir()
+			var opts []ArenaOption
+			var evictions, attempts int64
+			if tc.Freed != nil {
+				opts = append(opts, WithEvictOnNoSpace(func(_ context.Context) (int64, error) {
+					atomic.AddInt64(&evictions, 1)
+					return *tc.Freed, nil
+				}))
+			}
+			a := NewRemoteFetchArena(client, root, opts...)
+			defer a.Close(ctx)
+			fails := tc.Fails
+			a.wrapWriter = func(w io.Writer) io.Writer {
+				atomic.AddInt64(&attempts, 1)
+				if atomic.AddInt64(&fails, -1) >= 0 {
+					return fullWriter{}
+				}
+				return w
+			}
+
+			f := a.Realizer(ctx)
+			defer f.Close()
+			l := *l
+			err := f.Realize(ctx, []*claircore.Layer{&l})
+			switch {
+			case tc.Err && err == nil:
+				t.Fatal("expected error")
+			case !tc.Err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.Err:
+				t.Log(err)
+				if !errors.Is(err, ErrNoSpace) {
+					t.Errorf("error %v is not ErrNoSpace", err)
+				}
+				if !errors.Is(err, syscall.ENOSPC) {
+					t.Errorf("error %v is not ENOSPC", err)
+				}
+				// The partial file should have been cleaned up.
+				ents, err := os.ReadDir(root)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, e := range ents {
+					t.Errorf("leftover file: %q", e.Name())
+				}
+			}
+			if got, want := evictions, tc.Evictions; got != want {
+				t.Errorf("evictions: got: %d, want: %d", got, want)
+			}
+			if got, want := attempts, tc.Attempts; got != want {
+				t.Errorf("attempts: got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
+
+func TestFetchStoreCompressed(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	tb := tarball(t, "contents\n")
+	gz := func(footer bool) []byte {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write(tb)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !footer {
+			return b.Bytes()
+		}
+		// An eStargz footer is an empty gzip member with the TOC offset
+		// in the "extra" field.
+		off := b.Len()
+		zw = gzip.NewWriter(&b)
+		zw.Header.Extra = append([]byte{'S', 'G', 22, 0}, fmt.Sprintf("%016xSTARGZ", off)...)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	zst := func(footer bool) []byte {
+		var b bytes.Buffer
+		zw, err := zstd.NewWriter(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(tb)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if footer {
+			// A skippable frame holding an empty seek table.
+			b.Write([]byte{
+				0x5E, 0x2A, 0x4D, 0x18, // skippable frame magic
+				0x09, 0x00, 0x00, 0x00, // frame size
+				0x00, 0x00, 0x00, 0x00, // number of frames
+				0x00,                   // descriptor
+				0xB1, 0xEA, 0x92, 0x8F, // seekable magic
+			})
+		}
+		return b.Bytes()
+	}
+	xzb := func() []byte {
+		var b bytes.Buffer
+		zw, err := xz.NewWriter(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(tb)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}()
+	// There's no bzip2 compressor in the standard library.
+	bz2, err := os.ReadFile("testdata/layer.tar.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name   string
+		ct     string
+		body   []byte
+		format string
+	}{
+		{name: "Tar", ct: "application/vnd.oci.image.layer.v1.tar", body: tb, format: "tar"},
+		{name: "Gzip", ct: "application/vnd.oci.image.layer.v1.tar+gzip", body: gz(false), format: "gzip"},
+		{name: "EStargz", ct: "application/vnd.oci.image.layer.v1.tar+gzip", body: gz(true), format: "estargz"},
+		{name: "EStargzGuessed", body: gz(true), format: "estargz"},
+		{name: "Zstd", ct: "application/vnd.oci.image.layer.v1.tar+zstd", body: zst(false), format: "zstd"},
+		{name: "SeekableZstd", ct: "application/vnd.oci.image.layer.v1.tar+zstd", body: zst(true), format: "zstd:seekable"},
+		{name: "Xz", ct: "application/x-xz", body: xzb, format: "xz"},
+		{name: "XzGuessed", body: xzb, format: "xz"},
+		{name: "Bzip2", ct: "application/x-bzip2", body: bz2, format: "bzip2"},
+		{name: "Bzip2Guessed", body: bz2, format: "bzip2"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, tc.ct, tc.body)
+			a := NewRemoteFetchArena(c, t.TempDir(), WithStoreCompressed())
+			f := a.Realizer(ctx)
+			if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+				t.Fatal(err)
+			}
+			p, format, ok := a.Blob(l.Hash)
+			if !ok {
+				t.Fatal("blob not stored")
+			}
+			if got, want := format, tc.format; got != want {
+				t.Errorf("format: got: %q, want: %q", got, want)
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, tc.body) {
+				t.Error("stored blob differs from fetched blob")
+			}
+			rd, err := l.Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tarfs.New(rd); err != nil {
+				t.Error(err)
+			}
+			rd.Close()
+
+			if err := f.Close(); err != nil {
+				t.Error(err)
+			}
+			if _, _, ok := a.Blob(l.Hash); ok {
+				t.Error("blob reported after release")
+			}
+			if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("blob not removed: %v", err)
+			}
+		})
+	}
+	t.Run("Default", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "", gz(true))
+		a := NewRemoteFetchArena(c, t.TempDir())
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, ok := a.Blob(l.Hash); ok {
+			t.Error("blob reported without WithStoreCompressed")
+		}
+	})
+}
+
+func TestFetchLayerFile(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var b bytes.Buffer
+	if err := tar.NewWriter(&b).Close(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	t.Run("Success", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "application/x-tar", b.Bytes())
+		// Pre-open the file, as a parent process would.
+		pre, err := os.CreateTemp(dir, "layer.*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var calls int
+		root := t.TempDir()
+		a := NewRemoteFetchArena(c, root, WithLayerFile(func(_ context.Context, got *claircore.Layer) (*os.File, error) {
+			calls++
+			if got != l {
+				t.Errorf("unexpected layer: %v", got)
+			}
+			return pre, nil
+		}))
+		f := a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := calls, 1; got != want {
+			t.Errorf("calls: got: %d, want: %d", got, want)
+		}
+		rd, err := l.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := rd.(*os.File).Stat()
+		rd.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.Stat(pre.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(fi, want) {
+			t.Error("layer not written to supplied file")
+		}
+		if got, want := want.Size(), int64(b.Len()); got != want {
+			t.Errorf("size: got: %d, want: %d", got, want)
+		}
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+		// The file belongs to the caller, and the arena should have made
+		// nothing of its own.
+		if _, err := os.Stat(pre.Name()); err != nil {
+			t.Error(err)
+		}
+		ents, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range ents {
+			t.Errorf("unexpected file in arena: %s", e.Name())
+		}
+	})
+	t.Run("BadDigest", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "application/x-tar", b.Bytes())
+		l.Hash = digest("not this")
+		pre, err := os.CreateTemp(dir, "layer.*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := NewRemoteFetchArena(c, t.TempDir(), WithLayerFile(func(context.Context, *claircore.Layer) (*os.File, error) {
+			return pre, nil
+		}))
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err == nil {
+			t.Error("expected error, got nil")
+		}
+		fi, err := os.Stat(pre.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := fi.Size(), int64(0); got != want {
+			t.Errorf("size: got: %d, want: %d", got, want)
+		}
+	})
+}
+
+func TestFetchUncompressedSize(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var tb bytes.Buffer
+	if err := tar.NewWriter(&tb).Close(); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(tb.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tt := []struct {
+		name string
+		size int64
+		err  bool
+	}{
+		{name: "Unset"},
+		{name: "Match", size: int64(tb.Len())},
+		{name: "Short", size: int64(tb.Len()) - 1, err: true},
+		{name: "Long", size: int64(tb.Len()) + 512, err: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", gz.Bytes())
+			l.UncompressedSize = tc.size
+			a := NewRemoteFetchArena(c, t.TempDir())
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			t.Log(err)
+			if got, want := errors.Is(err, ErrSizeMismatch), tc.err; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			if !tc.err && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestFetchAcceptableDigests(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var tb bytes.Buffer
+	if err := tar.NewWriter(&tb).Close(); err != nil {
+		t.Fatal(err)
+	}
+	s256 := sha256.Sum256(tb.Bytes())
+	s512 := sha512.Sum512(tb.Bytes())
+	good256, err := claircore.NewDigest("sha256", s256[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	good512, err := claircore.NewDigest("sha512", s512[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad256, err := claircore.NewDigest("sha256", make([]byte, sha256.Size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad512, err := claircore.NewDigest("sha512", make([]byte, sha512.Size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt := []struct {
+		name       string
+		hash       claircore.Digest
+		acceptable []claircore.Digest
+		algos      []string
+		want       claircore.Digest
+		err        bool
+	}{
+		{name: "Single", hash: good256, want: good256},
+		{name: "SHA512", hash: good512, want: good512},
+		{name: "Both", hash: good256, acceptable: []claircore.Digest{good512}, want: good256},
+		{name: "Alternate", hash: bad256, acceptable: []claircore.Digest{bad512, good512}, want: good512},
+		{name: "None", hash: bad256, acceptable: []claircore.Digest{bad512}, err: true},
+		{name: "Allowed", hash: good256, acceptable: []claircore.Digest{good512}, algos: []string{"sha512"}, want: good512},
+		{name: "AllowedMismatch", hash: good256, acceptable: []claircore.Digest{bad512}, algos: []string{"sha512"}, err: true},
+		{name: "NoneAllowed", hash: good256, algos: []string{"sha512"}, err: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, "application/x-tar", tb.Bytes())
+			l.Hash = tc.hash
+			l.AcceptableDigests = tc.acceptable
+			var opts []ArenaOption
+			if tc.algos != nil {
+				opts = append(opts, WithDigestAlgorithms(tc.algos...))
+			}
+			a := NewRemoteFetchArena(c, t.TempDir(), opts...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			t.Log(err)
+			if got, want := err != nil, tc.err; got != want {
+				t.Fatalf("got error: %v, want error: %v", got, want)
+			}
+			if tc.err {
+				if len(tc.acceptable) == 0 && tc.algos != nil && !errors.Is(err, ErrDigestAlgorithm) {
+					t.Errorf("got: %v, want: %v", err, ErrDigestAlgorithm)
+				}
+				return
+			}
+			got, ok := l.Verified()
+			if !ok {
+				t.Fatal("verified digest not recorded")
+			}
+			if got.String() != tc.want.String() {
+				t.Errorf("verified: got: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFetchDiffID(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	tb := tarball(t, strings.Repeat("diff id\n", 1024))
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(tb); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(tb)
+	want, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name string
+		opts []ArenaOption
+	}{
+		{name: "Disk", opts: []ArenaOption{WithDiffID()}},
+		{name: "Memory", opts: []ArenaOption{WithDiffID(), WithMemoryThreshold(1 << 20)}},
+		{name: "Pool", opts: []ArenaOption{WithDiffID(), WithDecompressWorkers(1)}},
+		{name: "Disabled"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", gz.Bytes())
+			a := NewRemoteFetchArena(c, t.TempDir(), tc.opts...)
+			defer a.Close(ctx)
+			// The second Realizer picks up the layer already held by the
+			// arena.
+			for i := 0; i < 2; i++ {
+				f := a.Realizer(ctx)
+				defer f.Close()
+				l := *l
+				if err := f.Realize(ctx, []*claircore.Layer{&l}); err != nil {
+					t.Fatal(err)
+				}
+				got, ok := l.Verified()
+				if !ok {
+					t.Fatal("verified digest not recorded")
+				}
+				if got.String() != l.Hash.String() {
+					t.Errorf("verified: got: %v, want: %v", got, l.Hash)
+				}
+				d, ok := l.DiffID()
+				if tc.opts == nil {
+					if ok {
+						t.Errorf("unexpected diff ID: %v", d)
+					}
+					continue
+				}
+				if !ok {
+					t.Fatal("diff ID not recorded")
+				}
+				if d.String() != want.String() {
+					t.Errorf("diff ID: got: %v, want: %v", d, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchExpectedDiffID(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	tb := tarball(t, strings.Repeat("expected diff id\n", 1024))
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(tb); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(tb)
+	good, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum512 := sha512.Sum512(tb)
+	good512, err := claircore.NewDigest("sha512", sum512[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := claircore.MustParseDigest("sha256:" + strings.Repeat("f", 64))
+
+	tt := []struct {
+		name     string
+		want     claircore.Digest
+		opts     []ArenaOption
+		mismatch bool
+	}{
+		{name: "Match", want: good},
+		{name: "MatchSHA512", want: good512},
+		{name: "MatchMemory", want: good, opts: []ArenaOption{WithMemoryThreshold(1 << 20)}},
+		{name: "Mismatch", want: bad, mismatch: true},
+		{name: "MismatchMemory", want: bad, opts: []ArenaOption{WithMemoryThreshold(1 << 20)}, mismatch: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", gz.Bytes())
+			a := NewRemoteFetchArena(c, t.TempDir(), tc.opts...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			l.ExpectedDiffID = &tc.want
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			t.Log(err)
+			switch {
+			case tc.mismatch && !errors.Is(err, ErrDiffIDMismatch):
+				t.Fatalf("got: %v, want: %v", err, ErrDiffIDMismatch)
+			case tc.mismatch:
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			d, ok := l.DiffID()
+			if !ok {
+				t.Fatal("diff ID not recorded")
+			}
+			if got, want := d.String(), tc.want.String(); got != want {
+				t.Errorf("diff ID: got: %v, want: %v", got, want)
+			}
+		})
+	}
+
+	t.Run("Cached", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", gz.Bytes())
+		cache := t.TempDir()
+		realize := func(want *claircore.Digest) error {
+			a := NewRemoteFetchArena(c, t.TempDir(), WithLayerCache(cache, 0))
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			l := *l
+			l.ExpectedDiffID = want
+			return f.Realize(ctx, []*claircore.Layer{&l})
+		}
+		// Populate the cache without an expectation, then check the cached
+		// copy against one.
+		if err := realize(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := realize(&good); err != nil {
+			t.Error(err)
+		}
+		if err := realize(&bad); !errors.Is(err, ErrDiffIDMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrDiffIDMismatch)
+		}
+	})
+}
+
+func TestFetchMemoryThreshold(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const threshold = 10 * 1024
+	// Tar streams are sized in 512-byte blocks, so build layers whose sizes
+	// land right around the threshold.
+	mkTar := func(sz int) []byte {
+		var b bytes.Buffer
+		tw := tar.NewWriter(&b)
+		// Header plus two trailing zero blocks.
+		c := sz - 3*512
+		if err := tw.WriteHeader(&tar.Header{Name: "file", Size: int64(c), Mode: 0o644}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(bytes.Repeat([]byte{'x'}, c))
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if b.Len() != sz {
+			t.Fatalf("miscalculated tar size: got %d, want %d", b.Len(), sz)
+		}
+		return b.Bytes()
+	}
+	gz := func(b []byte) []byte {
+		var out bytes.Buffer
+		zw := gzip.NewWriter(&out)
+		zw.Write(b)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	under, at := mkTar(threshold-512), mkTar(threshold)
+	tt := []struct {
+		name   string
+		ct     string
+		body   []byte
+		size   int64
+		memory bool
+	}{
+		{name: "Small", ct: "application/x-tar", body: under, memory: true},
+		{name: "AtThreshold", ct: "application/x-tar", body: at, memory: false},
+		{name: "Large", ct: "application/x-tar", body: mkTar(4 * threshold), memory: false},
+		{name: "SmallKnown", ct: "application/vnd.oci.image.layer.v1.tar+gzip", body: gz(under), size: int64(len(under)), memory: true},
+		{name: "AtThresholdKnown", ct: "application/vnd.oci.image.layer.v1.tar+gzip", body: gz(at), size: int64(len(at)), memory: false},
+		// This compresses far better than estimated, so it starts out in
+		// memory and has to spill to disk.
+		{name: "Spill", ct: "application/vnd.oci.image.layer.v1.tar+gzip", body: gz(mkTar(16 * threshold)), memory: false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, tc.ct, tc.body)
+			l.UncompressedSize = tc.size
+			root := t.TempDir()
+			a := NewRemoteFetchArena(c, root, WithMemoryThreshold(threshold))
+			f := a.Realizer(ctx)
+			if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+				t.Fatal(err)
+			}
+			if !l.Fetched() {
+				t.Error("layer not fetched")
+			}
+			rd, err := l.Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, onDisk := rd.(*os.File)
+			if got, want := !onDisk, tc.memory; got != want {
+				t.Errorf("in memory: got: %v, want: %v", got, want)
+			}
+			sys, err := tarfs.New(rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fs.Stat(sys, "file"); err != nil {
+				t.Error(err)
+			}
+			rd.Close()
+
+			ents, err := os.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 1
+			if tc.memory {
+				want = 0
+			}
+			if got := len(ents); got != want {
+				t.Errorf("files in arena: got: %d, want: %d", got, want)
+			}
+			if err := f.Close(); err != nil {
+				t.Error(err)
+			}
+			ents, err = os.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range ents {
+				t.Errorf("file left in arena: %s", e.Name())
+			}
+		})
+	}
+}
+
+func TestFetchHeaderFilter(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var b bytes.Buffer
+	if err := tar.NewWriter(&b).Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b.Bytes())
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]http.Header)
+	record := func(name string, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[name] = r.Header.Clone()
+	}
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record("cdn", r)
+		w.Header().Set("content-type", "application/x-tar")
+		w.Write(b.Bytes())
+	}))
+	defer cdn.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record("origin", r)
+		http.Redirect(w, r, cdn.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer origin.Close()
+	cdnHost := strings.TrimPrefix(cdn.URL, "http://")
+
+	l := &claircore.Layer{
+		Hash: d,
+		URI:  origin.URL + "/blob",
+		Headers: http.Header{
+			"Authorization":  {"Bearer secret"},
+			"X-Internal-Id":  {"1234"},
+			"X-Request-Kind": {"layer"},
+		},
+	}
+	orig := http.Header(l.Headers).Clone()
+	var hosts []string
+	a := NewRemoteFetchArena(origin.Client(), t.TempDir(), WithHeaderFilter(func(host string, h http.Header) http.Header {
+		hosts = append(hosts, host)
+		if host == cdnHost {
+			h.Del("authorization")
+			h.Del("x-internal-id")
+		}
+		// Mutate the copy, to make sure the Layer doesn't see it.
+		h.Set("x-filtered", "1")
+		return h
+	}))
+	f := a.Realizer(ctx)
+	defer f.Close()
+	if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(hosts), 2; got != want {
+		t.Errorf("filter calls: got: %d, want: %d (%v)", got, want, hosts)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, k := range []string{"Authorization", "X-Internal-Id", "X-Request-Kind", "X-Filtered"} {
+		if got := seen["origin"].Get(k); got == "" {
+			t.Errorf("origin: missing %q", k)
+		}
+	}
+	for _, k := range []string{"Authorization", "X-Internal-Id"} {
+		if got := seen["cdn"].Get(k); got != "" {
+			t.Errorf("cdn: got %q: %q", k, got)
+		}
+	}
+	if got := seen["cdn"].Get("X-Request-Kind"); got == "" {
+		t.Error(`cdn: missing "X-Request-Kind"`)
+	}
+	if got, want := http.Header(l.Headers), orig; !cmp.Equal(got, want) {
+		t.Errorf("layer headers modified: %s", cmp.Diff(got, want))
+	}
+}
+
+func TestFetchLayerErrors(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var tb bytes.Buffer
+	if err := tar.NewWriter(&tb).Close(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("content-type", "application/x-tar")
+		w.Write(tb.Bytes())
+	}))
+	defer srv.Close()
+	sum := sha256.Sum256(tb.Bytes())
+	good, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, mismatch := test.RandomSHA256Digest(t), test.RandomSHA256Digest(t)
+	layers := func() []*claircore.Layer {
+		return []*claircore.Layer{
+			{Hash: good, URI: srv.URL + "/good", Headers: make(http.Header)},
+			{Hash: missing, URI: srv.URL + "/missing", Headers: make(http.Header)},
+			{Hash: mismatch, URI: srv.URL + "/mismatch", Headers: make(http.Header)},
+		}
+	}
+	bad := map[string]string{
+		missing.String():  srv.URL + "/missing",
+		mismatch.String(): srv.URL + "/mismatch",
+	}
+
+	t.Run("FailFast", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(srv.Client(), t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		ls := layers()
+		err := f.Realize(ctx, ls)
+		t.Log(err)
+		// The canceled fetches finish in the background, so wait for them
+		// before the test's logger goes away.
+		for _, l := range ls {
+			a.sf.Do(l.Hash.String(), func() (interface{}, error) { return nil, nil })
+		}
+		var le *LayerError
+		if !errors.As(err, &le) {
+			t.Fatalf("got: %v, want: *LayerError", err)
+		}
+		if got, want := bad[le.Layer.String()], le.URI; got != want {
+			t.Errorf("unexpected failing layer: %v (%s)", le.Layer, le.URI)
+		}
+		var fe *FetchError
+		if errors.As(err, &fe) {
+			t.Error("got an aggregate error without best-effort mode")
+		}
+	})
+
+	t.Run("BestEffort", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(srv.Client(), t.TempDir(), WithBestEffort())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		ls := layers()
+		err := f.Realize(ctx, ls)
+		t.Log(err)
+		var fe *FetchError
+		if !errors.As(err, &fe) {
+			t.Fatalf("got: %v, want: *FetchError", err)
+		}
+		got := make(map[string]string)
+		for _, err := range fe.Unwrap() {
+			var le *LayerError
+			if !errors.As(err, &le) {
+				t.Fatalf("got: %v, want: *LayerError", err)
+			}
+			got[le.Layer.String()] = le.URI
+		}
+		if !cmp.Equal(got, bad) {
+			t.Error(cmp.Diff(got, bad))
+		}
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("expected the aggregate error to contain %v", ErrDigestMismatch)
+		}
+		if !ls[0].Fetched() {
+			t.Error("good layer not fetched")
+		}
+	})
+}
+
+func TestFetchInsecureRedirect(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var b bytes.Buffer
+	if err := tar.NewWriter(&b).Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b.Bytes())
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/x-tar")
+		w.Write(b.Bytes())
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer secure.Close()
+	layer := func() *claircore.Layer {
+		return &claircore.Layer{
+			Hash:    d,
+			URI:     secure.URL + "/blob",
+			Headers: make(http.Header),
+		}
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(secure.Client(), t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		l := layer()
+		err := f.Realize(ctx, []*claircore.Layer{l})
+		t.Log(err)
+		if !errors.Is(err, ErrInsecureRedirect) {
+			t.Errorf("got: %v, want: %v", err, ErrInsecureRedirect)
+		}
+		if l.Fetched() {
+			t.Error("layer fetched over an insecure redirect")
+		}
+	})
+
+	t.Run("Allowed", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(secure.Client(), t.TempDir(), WithInsecureRedirects())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		l := layer()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if !l.Fetched() {
+			t.Error("layer not fetched")
+		}
+	})
+
+	t.Run("HeaderFilter", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		var calls int
+		a := NewRemoteFetchArena(secure.Client(), t.TempDir(), WithHeaderFilter(func(_ string, h http.Header) http.Header {
+			calls++
+			return h
+		}))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, []*claircore.Layer{layer()})
+		if !errors.Is(err, ErrInsecureRedirect) {
+			t.Errorf("got: %v, want: %v", err, ErrInsecureRedirect)
+		}
+		// Only the initial request should have been filtered.
+		if got, want := calls, 1; got != want {
+			t.Errorf("filter calls: got: %d, want: %d", got, want)
+		}
+	})
+}
+
+// FetchProgress records the layers reported to an indexer.Progress.
+type fetchProgress struct {
+	mu      sync.Mutex
+	fetched map[string]error
+}
+
+func (p *fetchProgress) LayerFetched(_ context.Context, l claircore.Digest, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetched[l.String()] = err
+}
+
+func (p *fetchProgress) ScannerDone(context.Context, claircore.Digest, ccindexer.VersionedScanner, error) {
+}
+func (p *fetchProgress) Coalescing(context.Context, claircore.Digest) {}
+
+func TestFetchProgress(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	c, ls := serveBlobs(t, "application/x-tar", tarballs(t, 2, 512))
+	missing := &claircore.Layer{
+		Hash:    digest("missing"),
+		URI:     ls[0].URI + "-missing",
+		Headers: make(map[string][]string),
+	}
+	p := &fetchProgress{fetched: make(map[string]error)}
+	ctx = ccindexer.WithProgress(ctx, p)
+	a := NewRemoteFetchArena(c, t.TempDir(), WithBestEffort())
+	defer a.Close(ctx)
+	f := a.Realizer(ctx)
+	defer f.Close()
+	if err := f.Realize(ctx, append(ls, missing)); err == nil {
+		t.Error("expected error")
+	}
+
+	if got, want := len(p.fetched), 3; got != want {
+		t.Fatalf("got: %d layers reported, want: %d", got, want)
+	}
+	for _, l := range ls {
+		if err := p.fetched[l.Hash.String()]; err != nil {
+			t.Errorf("%s: unexpected error: %v", l.Hash, err)
+		}
+	}
+	if err := p.fetched[missing.Hash.String()]; err == nil {
+		t.Errorf("%s: expected error", missing.Hash)
+	}
+}
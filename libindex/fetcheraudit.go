@@ -0,0 +1,73 @@
+package libindex
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+// Audit downloads the layer and checks it against its digests, discarding
+// the contents. It's meant for periodically confirming that the layers of
+// indexed images can still be fetched and are intact upstream.
+//
+// Nothing is written to the arena and the layer's reference count is not
+// touched. A layer that doesn't match its digest or UncompressedSize reports
+// an error that can be checked for with ErrDigestMismatch or ErrSizeMismatch.
+func (a *RemoteFetchArena) Audit(ctx context.Context, l *claircore.Layer) error {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/fetchArena.Audit",
+		"layer", l.Hash.String(),
+		"uri", l.URI)
+	zlog.Debug(ctx).Msg("layer audit start")
+
+	url, vh, err := checkLayer(l, a.digestAlgos)
+	if err != nil {
+		return err
+	}
+	// Audits count against the same limits as fetches, as they cost the
+	// same bandwidth.
+	release, err := indexer.AcquireLayerSlot(ctx)
+	if err != nil {
+		return fmt.Errorf("fetcher: unable to acquire layer slot: %w", err)
+	}
+	defer release()
+	if a.sem != nil {
+		if err := a.sem.Acquire(ctx, 1); err != nil {
+			return fmt.Errorf("fetcher: unable to acquire fetch slot: %w", err)
+		}
+		defer a.sem.Release(1)
+	}
+
+	st, err := a.stream(ctx, l, url, vh)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	n, err := io.Copy(io.Discard, st.r)
+	if err != nil {
+		return err
+	}
+	// As in realizeLayer, read anything after the end of the compressed
+	// stream so it's included in the digest.
+	if _, err := io.Copy(io.Discard, st.raw); err != nil {
+		return err
+	}
+	if err := st.checkLength(); err != nil {
+		return err
+	}
+	if _, err := vh.Verify(); err != nil {
+		return err
+	}
+	if want := l.UncompressedSize; want != 0 && n != want {
+		return &errSizeMismatch{got: n, want: want}
+	}
+	zlog.Debug(ctx).
+		Int64("size", n).
+		Msg("layer audit ok")
+	return nil
+}
@@ -0,0 +1,65 @@
+package libindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchAudit(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	c := []byte("audit me\n")
+	tb := tarball(t, string(c))
+	gz := gzipTarball(t, string(c))
+
+	t.Run("Valid", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		cl, l := serveBlob(t, "application/gzip", gz)
+		root := t.TempDir()
+		a := NewRemoteFetchArena(cl, root)
+		defer a.Close(ctx)
+		if err := a.Audit(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+		ents, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range ents {
+			t.Errorf("left behind: %s", e.Name())
+		}
+		if l.Fetched() {
+			t.Error("audited layer reports being fetched")
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		// Flip a bit in the file's contents, which leaves a valid tar with
+		// the wrong digest.
+		bad := append([]byte(nil), tb...)
+		i := bytes.Index(bad, c)
+		bad[i] ^= 0x20
+		cl, l := serveBlob(t, "application/x-tar", bad)
+		sum := sha256.Sum256(tb)
+		d, err := claircore.NewDigest("sha256", sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Hash = d
+		a := NewRemoteFetchArena(cl, t.TempDir())
+		defer a.Close(ctx)
+		err = a.Audit(ctx, l)
+		t.Log(err)
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrDigestMismatch)
+		}
+	})
+}
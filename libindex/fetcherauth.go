@@ -0,0 +1,348 @@
+package libindex
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quay/zlog"
+	"golang.org/x/sync/singleflight"
+)
+
+// CredentialFunc returns the credentials to present to a registry, or the
+// token service it delegates to, for the named host.
+//
+// Returning empty strings requests anonymous access. Returning the username
+// "<token>" has the password used as an identity token, which is exchanged for
+// tokens using OAuth2. See RegistryCredentials for a ready-made store.
+type CredentialFunc func(ctx context.Context, host string) (username, password string, err error)
+
+// RegistryAuth implements the client side of the Docker registry token
+// protocol, along with plain basic auth.
+//
+// See https://docs.docker.com/registry/spec/auth/token/ for the protocol.
+type registryAuth struct {
+	creds CredentialFunc
+	sf    singleflight.Group
+
+	mu sync.Mutex
+	// Tokens is a map of host and scope to Authorization header values.
+	tokens map[string]registryToken
+}
+
+type registryToken struct {
+	value   string
+	expires time.Time
+}
+
+// TokenSlack is how long before a token's reported expiry it stops being
+// used, to account for clock skew and time spent in flight.
+const tokenSlack = 5 * time.Second
+
+func newRegistryAuth(f CredentialFunc) *registryAuth {
+	return &registryAuth{
+		creds:  f,
+		tokens: make(map[string]registryToken),
+	}
+}
+
+// Do issues the request, answering at most one authentication challenge.
+//
+// If the request already carries an Authorization header, it's sent as-is.
+func (r *registryAuth) Do(ctx context.Context, c *http.Client, req *http.Request) (*http.Response, error) {
+	if req.Header.Get("authorization") != "" {
+		return c.Do(req)
+	}
+	ctx = zlog.ContextWithValues(ctx, "component", "libindex/registryAuth.Do")
+	host := req.URL.Host
+	scope := blobScope(req.URL.Path)
+	// Sent is the Authorization value the request went out with, if any.
+	sent, ok := r.cached(host, scope)
+	if ok {
+		req = withAuthorization(req, sent)
+	}
+	res, err := c.Do(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	ch, ok := pickChallenge(parseChallenges(res.Header.Values("www-authenticate")))
+	if !ok {
+		return res, nil
+	}
+	// Done with the 401, so drain enough of it to reuse the connection.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+	zlog.Debug(ctx).
+		Str("scheme", ch.scheme).
+		Str("host", host).
+		Msg("answering auth challenge")
+
+	if s, ok := ch.params["scope"]; ok {
+		scope = s
+	}
+	key := host + " " + scope
+	// Concurrent fetches from the same repository are likely to be challenged
+	// at the same time; only ask the token service once.
+	v, err, _ := r.sf.Do(key, func() (interface{}, error) {
+		// An exchange for another request may have finished between this
+		// request's 401 and now. Use its token, unless it's the one that was
+		// just rejected.
+		if v, ok := r.cached(host, scope); ok && v != sent {
+			return v, nil
+		}
+		tok, err := r.authorize(ctx, c, host, scope, ch)
+		if err != nil {
+			return "", err
+		}
+		r.mu.Lock()
+		r.tokens[key] = tok
+		r.mu.Unlock()
+		return tok.value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(withAuthorization(req, v.(string)))
+}
+
+// Cached reports a usable Authorization header value for the host and scope,
+// if one exists.
+func (r *registryAuth) cached(host, scope string) (string, bool) {
+	key := host + " " + scope
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tok, ok := r.tokens[key]
+	if !ok {
+		return "", false
+	}
+	if !tok.expires.IsZero() && time.Now().After(tok.expires.Add(-tokenSlack)) {
+		delete(r.tokens, key)
+		return "", false
+	}
+	return tok.value, true
+}
+
+// Authorize answers the challenge, returning the Authorization header value to
+// use and when it expires.
+func (r *registryAuth) authorize(ctx context.Context, c *http.Client, host, scope string, ch challenge) (registryToken, error) {
+	var user, pass string
+	if r.creds != nil {
+		var err error
+		user, pass, err = r.creds(ctx, host)
+		if err != nil {
+			return registryToken{}, fmt.Errorf("fetcher: unable to get credentials for %q: %w", host, err)
+		}
+	}
+
+	switch ch.scheme {
+	case "basic":
+		if user == "" && pass == "" {
+			return registryToken{}, fmt.Errorf("fetcher: %q requires basic auth, but no credentials are configured", host)
+		}
+		v := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+		return registryToken{value: "Basic " + v}, nil
+	case "bearer":
+	default:
+		panic("programmer error: unhandled scheme " + ch.scheme)
+	}
+
+	realm, ok := ch.params["realm"]
+	if !ok {
+		return registryToken{}, fmt.Errorf("fetcher: bearer challenge from %q missing realm", host)
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return registryToken{}, fmt.Errorf("fetcher: bad realm %q: %w", realm, err)
+	}
+	q := u.Query()
+	if s, ok := ch.params["service"]; ok {
+		q.Set("service", s)
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+	var req *http.Request
+	if user == identityTokenUser {
+		// An identity token is a refresh token, exchanged using OAuth2.
+		q.Set("grant_type", "refresh_token")
+		q.Set("refresh_token", pass)
+		q.Set("client_id", "claircore")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(q.Encode()))
+		if err == nil {
+			req.Header.Set("content-type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		u.RawQuery = q.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err == nil && (user != "" || pass != "") {
+			req.SetBasicAuth(user, pass)
+		}
+	}
+	if err != nil {
+		return registryToken{}, fmt.Errorf("fetcher: unable to construct token request: %w", err)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return registryToken{}, &errRequest{what: "token request", inner: err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		e := newStatusError(res)
+		e.URL = req.URL.Redacted()
+		return registryToken{}, e
+	}
+	var tr struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		IssuedAt    string `json:"issued_at"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return registryToken{}, fmt.Errorf("fetcher: unable to decode token response: %w", err)
+	}
+	tok := tr.Token
+	if tok == "" {
+		tok = tr.AccessToken
+	}
+	if tok == "" {
+		return registryToken{}, fmt.Errorf("fetcher: token service for %q returned no token", host)
+	}
+	// The spec says a missing "expires_in" means 60 seconds.
+	exp := time.Duration(tr.ExpiresIn) * time.Second
+	if exp <= 0 {
+		exp = 60 * time.Second
+	}
+	issued, err := time.Parse(time.RFC3339, tr.IssuedAt)
+	if err != nil {
+		issued = time.Now()
+	}
+	return registryToken{
+		value:   "Bearer " + tok,
+		expires: issued.Add(exp),
+	}, nil
+}
+
+// WithAuthorization returns a copy of the request with the Authorization
+// header set.
+func withAuthorization(req *http.Request, v string) *http.Request {
+	r := req.Clone(req.Context())
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Header.Set("authorization", v)
+	return r
+}
+
+// BlobScope returns the token scope needed to pull from the repository named
+// in a registry blob or manifest path, or the empty string if the path doesn't
+// look like one.
+func blobScope(p string) string {
+	if !strings.HasPrefix(p, "/v2/") {
+		return ""
+	}
+	i := strings.LastIndex(p, "/blobs/")
+	if i == -1 {
+		i = strings.LastIndex(p, "/manifests/")
+	}
+	if i <= len("/v2/") {
+		return ""
+	}
+	return "repository:" + p[len("/v2/"):i] + ":pull"
+}
+
+// Challenge is a parsed WWW-Authenticate challenge.
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+// PickChallenge returns the challenge to answer, preferring bearer tokens.
+func pickChallenge(cs []challenge) (challenge, bool) {
+	for _, s := range []string{"bearer", "basic"} {
+		for _, c := range cs {
+			if c.scheme == s {
+				return c, true
+			}
+		}
+	}
+	return challenge{}, false
+}
+
+// ParseChallenges parses WWW-Authenticate header values, as described in RFC
+// 7235, section 4.1.
+//
+// Malformed input results in a best-effort parse rather than an error.
+func parseChallenges(hs []string) []challenge {
+	var out []challenge
+	for _, s := range hs {
+		cur := -1
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			if s == "" {
+				break
+			}
+			i := strings.IndexAny(s, " \t,=")
+			switch i {
+			case -1:
+				i = len(s)
+			case 0:
+				// Stray "=", skip it.
+				s = s[1:]
+				continue
+			}
+			tok, rest := s[:i], strings.TrimLeft(s[i:], " \t")
+			if cur == -1 || !strings.HasPrefix(rest, "=") {
+				// Not an auth-param, so it's the start of a new challenge.
+				out = append(out, challenge{
+					scheme: strings.ToLower(tok),
+					params: make(map[string]string),
+				})
+				cur = len(out) - 1
+				s = rest
+				continue
+			}
+			rest = strings.TrimLeft(rest[1:], " \t")
+			var v string
+			if strings.HasPrefix(rest, `"`) {
+				var b strings.Builder
+				i := 1
+			Quoted:
+				for ; i < len(rest); i++ {
+					switch c := rest[i]; c {
+					case '\\':
+						i++
+						if i < len(rest) {
+							b.WriteByte(rest[i])
+						}
+					case '"':
+						i++
+						break Quoted
+					default:
+						b.WriteByte(c)
+					}
+				}
+				if i > len(rest) {
+					// Unterminated escape at the end of the input.
+					i = len(rest)
+				}
+				v, rest = b.String(), rest[i:]
+			} else {
+				i := strings.IndexAny(rest, " \t,")
+				if i == -1 {
+					i = len(rest)
+				}
+				v, rest = rest[:i], rest[i:]
+			}
+			out[cur].params[strings.ToLower(tok)] = v
+			s = rest
+		}
+	}
+	return out
+}
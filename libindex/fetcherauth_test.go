@@ -0,0 +1,441 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// TestRegistry is a registry that enforces the token protocol.
+//
+// Tokens are only good for a set number of blob fetches, to simulate them
+// expiring in the middle of fetching an image.
+type testRegistry struct {
+	t          testing.TB
+	srv        *httptest.Server
+	user, pass string
+	// Refresh, if set, is the only identity token accepted, and tokens
+	// must be requested with it.
+	refresh string
+	uses    int
+
+	mu     sync.Mutex
+	issued int
+	tokens map[string]int
+	blobs  map[string][]byte
+}
+
+const testScope = "repository:test/repo:pull"
+
+func newTestRegistry(t testing.TB, uses int) *testRegistry {
+	r := &testRegistry{
+		t:      t,
+		uses:   uses,
+		tokens: make(map[string]int),
+		blobs:  make(map[string][]byte),
+	}
+	r.srv = httptest.NewServer(r)
+	t.Cleanup(r.srv.Close)
+	return r
+}
+
+// Layers adds "n" blobs to the registry and returns Layers pointing at them.
+func (r *testRegistry) Layers(n int) []*claircore.Layer {
+	ls := make([]*claircore.Layer, n)
+	for i := range ls {
+		var b bytes.Buffer
+		w := tar.NewWriter(&b)
+		c := fmt.Sprintf("%032d\n", i)
+		if err := w.WriteHeader(&tar.Header{Name: fmt.Sprint(i), Size: int64(len(c))}); err != nil {
+			r.t.Fatal(err)
+		}
+		fmt.Fprint(w, c)
+		if err := w.Close(); err != nil {
+			r.t.Fatal(err)
+		}
+		sum := sha256.Sum256(b.Bytes())
+		d, err := claircore.NewDigest("sha256", sum[:])
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		r.mu.Lock()
+		r.blobs[d.String()] = b.Bytes()
+		r.mu.Unlock()
+		ls[i] = &claircore.Layer{
+			Hash:    d,
+			URI:     r.srv.URL + "/v2/test/repo/blobs/" + d.String(),
+			Headers: make(http.Header),
+		}
+	}
+	return ls
+}
+
+// Token mints a token, as if the token service was asked.
+func (r *testRegistry) Token() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.issued++
+	tok := fmt.Sprintf("token-%d", r.issued)
+	r.tokens[tok] = r.uses
+	return tok
+}
+
+func (r *testRegistry) Issued() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.issued
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/token" && r.refresh != "":
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.PostForm.Get("grant_type") != "refresh_token" || req.PostForm.Get("refresh_token") != r.refresh {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if got, want := req.PostForm.Get("scope"), testScope; got != want {
+			r.t.Errorf("scope: got: %q, want: %q", got, want)
+		}
+		w.Header().Set("content-type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": r.Token(),
+			"expires_in":   300,
+		})
+	case req.URL.Path == "/token":
+		if r.user != "" {
+			u, p, ok := req.BasicAuth()
+			if !ok || u != r.user || p != r.pass {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		if got, want := req.URL.Query().Get("scope"), testScope; got != want {
+			r.t.Errorf("scope: got: %q, want: %q", got, want)
+		}
+		if got, want := req.URL.Query().Get("service"), "registry.test"; got != want {
+			r.t.Errorf("service: got: %q, want: %q", got, want)
+		}
+		w.Header().Set("content-type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      r.Token(),
+			"expires_in": 300,
+		})
+	case strings.HasPrefix(req.URL.Path, "/v2/test/repo/blobs/"):
+		tok := strings.TrimPrefix(req.Header.Get("authorization"), "Bearer ")
+		r.mu.Lock()
+		n, ok := r.tokens[tok]
+		if ok && n > 0 {
+			r.tokens[tok] = n - 1
+		}
+		b, found := r.blobs[strings.TrimPrefix(req.URL.Path, "/v2/test/repo/blobs/")]
+		r.mu.Unlock()
+		if !ok || n == 0 {
+			w.Header().Set("www-authenticate",
+				fmt.Sprintf(`Bearer realm="%s/token",service="registry.test",scope="%s"`, r.srv.URL, testScope))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("content-type", "application/x-tar")
+		w.Write(b)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRegistryAuth(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const layers = 5
+
+	t.Run("Anonymous", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		r := newTestRegistry(t, 2)
+		// Fetch one at a time, so the token expiry is deterministic.
+		a := NewRemoteFetchArena(r.srv.Client(), t.TempDir(),
+			WithArenaConcurrency(1), WithRegistryAuth(nil))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, r.Layers(layers)); err != nil {
+			t.Fatal(err)
+		}
+		// Each token is good for two fetches, so one fetch in every two
+		// should need a new token.
+		if got, want := r.Issued(), (layers+1)/2; got != want {
+			t.Errorf("tokens issued: got: %d, want: %d", got, want)
+		}
+	})
+	t.Run("Credentials", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		r := newTestRegistry(t, layers)
+		r.user, r.pass = "user", "hunter2"
+		a := NewRemoteFetchArena(r.srv.Client(), t.TempDir(),
+			WithRegistryAuth(func(_ context.Context, host string) (string, string, error) {
+				if got, want := host, strings.TrimPrefix(r.srv.URL, "http://"); got != want {
+					t.Errorf("host: got: %q, want: %q", got, want)
+				}
+				return "user", "hunter2", nil
+			}))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, r.Layers(layers)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("BadCredentials", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		r := newTestRegistry(t, layers)
+		r.user, r.pass = "user", "hunter2"
+		a := NewRemoteFetchArena(r.srv.Client(), t.TempDir(),
+			WithRegistryAuth(func(context.Context, string) (string, string, error) {
+				return "user", "password", nil
+			}))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, r.Layers(1))
+		t.Log(err)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+	t.Run("Default", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		r := newTestRegistry(t, layers)
+		a := NewRemoteFetchArena(r.srv.Client(), t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, r.Layers(1))
+		t.Log(err)
+		if err == nil {
+			t.Error("expected error")
+		}
+		if got, want := r.Issued(), 0; got != want {
+			t.Errorf("tokens issued: got: %d, want: %d", got, want)
+		}
+	})
+	t.Run("Passthrough", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		r := newTestRegistry(t, layers)
+		ls := r.Layers(layers)
+		tok := r.Token()
+		for _, l := range ls {
+			http.Header(l.Headers).Set("authorization", "Bearer "+tok)
+		}
+		a := NewRemoteFetchArena(r.srv.Client(), t.TempDir(), WithRegistryAuth(nil))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, ls); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := r.Issued(), 1; got != want {
+			t.Errorf("tokens issued: got: %d, want: %d", got, want)
+		}
+	})
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+
+	t.Run("DockerConfig", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		r := newTestRegistry(t, 5)
+		r.user, r.pass = "user", "hunter2"
+		host := strings.TrimPrefix(r.srv.URL, "http://")
+		cfg := fmt.Sprintf(`{"auths":{"https://%s/v1/":{"auth":"%s"},"other.test":{"username":"u","password":"p"}}}`,
+			host, base64.StdEncoding.EncodeToString([]byte("user:hunter2")))
+		p := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(p, []byte(cfg), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		creds, err := LoadDockerConfig(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := RegistryCredentials{
+			host:         {Username: "user", Password: "hunter2"},
+			"other.test": {Username: "u", Password: "p"},
+		}
+		if !cmp.Equal(creds, want) {
+			t.Error(cmp.Diff(creds, want))
+		}
+		a := NewRemoteFetchArena(r.srv.Client(), t.TempDir(), WithRegistryAuth(creds.Lookup))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, r.Layers(5)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("IdentityToken", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		r := newTestRegistry(t, 5)
+		r.refresh = "refresh-me"
+		host := strings.TrimPrefix(r.srv.URL, "http://")
+		creds := RegistryCredentials{host: {Username: "ignored", IdentityToken: "refresh-me"}}
+		a := NewRemoteFetchArena(r.srv.Client(), t.TempDir(), WithRegistryAuth(creds.Lookup))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, r.Layers(5)); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := r.Issued(), 1; got != want {
+			t.Errorf("tokens issued: got: %d, want: %d", got, want)
+		}
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		creds := RegistryCredentials{
+			"index.docker.io": {Username: "hub", Password: "p"},
+			"quay.io":         {Username: "quay", Password: "q"},
+		}
+		for _, tc := range []struct {
+			host, user string
+		}{
+			{"quay.io", "quay"},
+			{"registry-1.docker.io", "hub"},
+			{"docker.io", "hub"},
+			{"quay.io:443", ""},
+			{"example.com", ""},
+		} {
+			u, _, err := creds.Lookup(ctx, tc.host)
+			if err != nil {
+				t.Error(err)
+			}
+			if u != tc.user {
+				t.Errorf("%s: got: %q, want: %q", tc.host, u, tc.user)
+			}
+		}
+	})
+
+	t.Run("BadConfig", func(t *testing.T) {
+		for _, cfg := range []string{
+			`{"auths":`,
+			`{"auths":{"quay.io":{"auth":"not base64!"}}}`,
+			`{"auths":{"quay.io":{"auth":"` + base64.StdEncoding.EncodeToString([]byte("nocolon")) + `"}}}`,
+		} {
+			p := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(p, []byte(cfg), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadDockerConfig(p)
+			t.Log(err)
+			if err == nil {
+				t.Errorf("%s: expected error", cfg)
+			}
+		}
+	})
+}
+
+func TestParseChallenges(t *testing.T) {
+	tt := []struct {
+		In   []string
+		Want []challenge
+	}{
+		{
+			In: []string{`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull"`},
+			Want: []challenge{
+				{scheme: "bearer", params: map[string]string{
+					"realm":   "https://auth.example.com/token",
+					"service": "registry.example.com",
+					"scope":   "repository:a/b:pull",
+				}},
+			},
+		},
+		{
+			In: []string{`Basic realm="Registry \"Realm\"", Bearer realm=https://auth.example.com/token`},
+			Want: []challenge{
+				{scheme: "basic", params: map[string]string{"realm": `Registry "Realm"`}},
+				{scheme: "bearer", params: map[string]string{"realm": "https://auth.example.com/token"}},
+			},
+		},
+		{
+			In: []string{`Basic`, `Bearer realm="unterminated\`},
+			Want: []challenge{
+				{scheme: "basic", params: map[string]string{}},
+				{scheme: "bearer", params: map[string]string{"realm": "unterminated"}},
+			},
+		},
+	}
+	for _, tc := range tt {
+		got := parseChallenges(tc.In)
+		if !cmp.Equal(got, tc.Want, cmp.AllowUnexported(challenge{})) {
+			t.Error(cmp.Diff(got, tc.Want, cmp.AllowUnexported(challenge{})))
+		}
+	}
+}
+
+// RoundTripFunc adapts a function to an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// TestRegistryAuthLateChallenge confirms a request challenged after another
+// request's token exchange finished uses that token instead of exchanging
+// again.
+func TestRegistryAuthLateChallenge(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	r := newTestRegistry(t, 5)
+	host := strings.TrimPrefix(r.srv.URL, "http://")
+	ra := newRegistryAuth(nil)
+	tr := r.srv.Client().Transport
+	c := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		res, err := tr.RoundTrip(req)
+		if err == nil && res.StatusCode == http.StatusUnauthorized && req.Header.Get("authorization") == "" {
+			// Another request's exchange finishes while this 401 is in
+			// flight.
+			ra.mu.Lock()
+			ra.tokens[host+" "+testScope] = registryToken{value: "Bearer " + r.Token()}
+			ra.mu.Unlock()
+		}
+		return res, err
+	})}
+	l := r.Layers(1)[0]
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.URI, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ra.Do(ctx, c, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Errorf("status: got: %d, want: %d", got, want)
+	}
+	if got, want := r.Issued(), 1; got != want {
+		t.Errorf("tokens issued: got: %d, want: %d", got, want)
+	}
+}
@@ -0,0 +1,419 @@
+package libindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// DefaultLayerCacheSize is used when WithLayerCache is passed a non-positive
+// size.
+const defaultLayerCacheSize = 10 * 1024 * 1024 * 1024
+
+// CacheTmpPrefix names files being added to the layer cache.
+const cacheTmpPrefix = "tmp."
+
+// CacheTmpAge is how old a temporary file in the layer cache must be before
+// it's assumed to be left over from a crashed process.
+const cacheTmpAge = time.Hour
+
+// LayerCache is a directory of verified, decompressed layers named by their
+// digest, shared by every arena configured with it, including ones in other
+// processes.
+//
+// Entries are only ever added by renaming a complete file into place, so a
+// reader never sees a partial layer. An entry's modification time is its last
+// use, and the least recently used entries are removed once the directory
+// grows past its limit.
+type layerCache struct {
+	dir string
+	max int64
+	// Mu serializes eviction passes within the process.
+	mu sync.Mutex
+}
+
+// Path reports where the layer with the provided digest is cached.
+func (c *layerCache) path(h string) string {
+	return filepath.Join(c.dir, h)
+}
+
+// Get links or copies the cached layer into a new file in "dir", reporting
+// whether the layer was cached.
+func (c *layerCache) get(h, dir string) (string, bool, error) {
+	src := c.path(h)
+	if _, err := os.Stat(src); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	name, err := linkTemp(src, dir, "fetch.*")
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// Evicted in the meantime.
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	}
+	// Mark it as used. Failing to is only a problem for eviction order.
+	now := time.Now()
+	os.Chtimes(src, now, now)
+	return name, true, nil
+}
+
+// Put adds the file holding the layer with the provided digest to the cache,
+// then evicts entries if the cache is over its limit.
+func (c *layerCache) put(ctx context.Context, h, src string) error {
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := linkTemp(src, c.dir, cacheTmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path(h)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return c.evict(ctx)
+}
+
+// Put implements LayerStore.
+func (c *layerCache) Put(ctx context.Context, d claircore.Digest, r io.Reader, size int64) error {
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(c.dir, cacheTmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := io.CopyN(f, r, size); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return noSpace(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return noSpace(err)
+	}
+	if err := os.Rename(tmp, c.path(d.String())); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return c.evict(ctx)
+}
+
+// Open implements LayerStore.
+func (c *layerCache) Open(ctx context.Context, d claircore.Digest) (io.ReadCloser, error) {
+	src := c.path(d.String())
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	os.Chtimes(src, now, now)
+	return f, nil
+}
+
+// Remove implements LayerStore.
+func (c *layerCache) Remove(ctx context.Context, d claircore.Digest) error {
+	err := os.Remove(c.path(d.String()))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Evict removes the least recently used entries until the cache is within its
+// limit, along with temporary files left behind by crashed processes.
+func (c *layerCache) evict(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ents, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		name string
+		size int64
+		used time.Time
+	}
+	var total int64
+	var es []entry
+	now := time.Now()
+	for _, e := range ents {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			// Removed by someone else.
+			continue
+		}
+		n := e.Name()
+		if strings.HasPrefix(n, cacheTmpPrefix) {
+			if now.Sub(fi.ModTime()) > cacheTmpAge {
+				os.Remove(filepath.Join(c.dir, n))
+			}
+			continue
+		}
+		if _, err := claircore.ParseDigest(n); err != nil {
+			continue
+		}
+		total += fi.Size()
+		es = append(es, entry{name: n, size: fi.Size(), used: fi.ModTime()})
+	}
+	if total <= c.max {
+		return nil
+	}
+	sort.Slice(es, func(i, j int) bool { return es[i].used.Before(es[j].used) })
+	var ct int
+	for _, e := range es {
+		if total <= c.max {
+			break
+		}
+		err := os.Remove(filepath.Join(c.dir, e.name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		total -= e.size
+		ct++
+	}
+	zlog.Debug(ctx).
+		Str("cache", c.dir).
+		Int("count", ct).
+		Int64("size", total).
+		Msg("evicted cached layers")
+	return nil
+}
+
+// LinkTemp creates a new file in "dir" with the contents of "src", named by
+// "pattern" as with os.CreateTemp. The file is a hard link if possible, and a
+// copy otherwise.
+func linkTemp(src, dir, pattern string) (string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	// The name is reserved; swap the file for a link if possible.
+	if err := os.Remove(name); err == nil {
+		if err := os.Link(src, name); err == nil {
+			f.Close()
+			return name, nil
+		}
+		// Reserve the name again for the copy.
+		f.Close()
+		f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return "", err
+		}
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		f.Close()
+		os.Remove(name)
+		return "", err
+	}
+	defer in.Close()
+	if _, err := io.Copy(f, in); err != nil {
+		f.Close()
+		os.Remove(name)
+		return "", noSpace(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(name)
+		return "", noSpace(err)
+	}
+	return name, nil
+}
+
+// Cacheable reports whether fetched layers go through the layer cache. Layers
+// written to caller-supplied files aren't cached, nor are arenas storing
+// compressed copies or compressed layers, as the cache only holds the
+// decompressed layer.
+func (a *RemoteFetchArena) cacheable() bool {
+	return a.cache != nil && a.layerFile == nil && !a.storeCompressed && a.decompress == DecompressOnFetch
+}
+
+// FromCache fills in the layer from the layer cache, if it's there, returning
+// the name of the file as realize would.
+func (a *RemoteFetchArena) fromCache(ctx context.Context, l *claircore.Layer) (string, bool) {
+	if !a.cacheable() {
+		return "", false
+	}
+	h := l.Hash.String()
+	dir := a.root
+	if a.spoolDir != "" {
+		dir = a.spoolDir
+	}
+	var name string
+	var ok bool
+	var err error
+	if fst, isFile := a.cache.(fileStore); isFile {
+		name, ok, err = fst.get(h, dir)
+	} else {
+		name, ok, err = a.copyStored(ctx, l.Hash, dir)
+	}
+	if err != nil {
+		zlog.Warn(ctx).
+			Err(err).
+			Str("layer", h).
+			Msg("unable to read layer cache, fetching")
+		return "", false
+	}
+	if !ok {
+		return "", false
+	}
+	var diffID claircore.Digest
+	if a.diffID || a.tarIndex || l.ExpectedDiffID != nil {
+		if diffID, err = a.prepareCached(name, l); err != nil {
+			os.Remove(name)
+			// Don't trip over it again.
+			a.cache.Remove(ctx, l.Hash)
+			zlog.Warn(ctx).
+				Err(err).
+				Str("layer", h).
+				Msg("unable to use cached layer, fetching")
+			return "", false
+		}
+		if err := checkDiffID(l, diffID); err != nil {
+			// The cached layer is what Hash says it is, so fetching it
+			// will fail the same way.
+			os.Remove(name)
+			zlog.Info(ctx).
+				Err(err).
+				Str("layer", h).
+				Msg("cached layer doesn't match expected diffID")
+			return "", false
+		}
+	}
+	zlog.Debug(ctx).
+		Str("layer", h).
+		Msg("using cached layer")
+	a.mu.Lock()
+	// Layers only enter the cache once verified against this digest.
+	a.verified[h] = l.Hash
+	if diffID.Checksum() != nil {
+		a.diffIDs[h] = diffID
+	}
+	if a.idleTTL > 0 {
+		a.fetched[h] = a.clock.Now()
+	}
+	a.mu.Unlock()
+	return name, true
+}
+
+// PrepareCached does what realizeLayer would have done with the layer's
+// contents beyond writing them out: computing its DiffID and building its tar
+// index, if configured or needed to check the layer's ExpectedDiffID.
+func (a *RemoteFetchArena) prepareCached(name string, l *claircore.Layer) (claircore.Digest, error) {
+	var diffID claircore.Digest
+	f, err := os.Open(name)
+	if err != nil {
+		return diffID, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return diffID, err
+	}
+	if want := l.ExpectedDiffID; a.diffID || want != nil {
+		algo, h := "sha256", sha256.New()
+		if want != nil {
+			algo, h = want.Algorithm(), want.Hash()
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return diffID, err
+		}
+		if diffID, err = claircore.NewDigest(algo, h.Sum(nil)); err != nil {
+			return diffID, err
+		}
+	}
+	if a.tarIndex {
+		if err := writeTarIndex(f, fi.Size()); err != nil {
+			return diffID, fmt.Errorf("fetcher: unable to build tar index: %w", err)
+		}
+	}
+	return diffID, nil
+}
+
+// ToCache adds a freshly fetched layer to the layer cache. Failing to is
+// logged and otherwise ignored.
+func (a *RemoteFetchArena) toCache(ctx context.Context, l *claircore.Layer, name string) {
+	if !a.cacheable() || name == "" {
+		return
+	}
+	var err error
+	if fst, ok := a.cache.(fileStore); ok {
+		err = fst.put(ctx, l.Hash.String(), name)
+	} else {
+		err = a.putStored(ctx, l.Hash, name)
+	}
+	if err != nil {
+		zlog.Warn(ctx).
+			Err(err).
+			Str("layer", l.Hash.String()).
+			Msg("unable to add layer to cache")
+	}
+}
+
+// CopyStored copies the layer from a LayerStore that doesn't keep local files
+// into a new file in "dir", reporting whether the layer was stored.
+func (a *RemoteFetchArena) copyStored(ctx context.Context, d claircore.Digest, dir string) (_ string, _ bool, err error) {
+	rc, err := a.cache.Open(ctx, d)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	}
+	defer rc.Close()
+	f, err := os.CreateTemp(dir, "fetch.*")
+	if err != nil {
+		return "", false, err
+	}
+	name := f.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(name)
+		}
+	}()
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return "", false, noSpace(err)
+	}
+	if err := f.Close(); err != nil {
+		return "", false, noSpace(err)
+	}
+	return name, true, nil
+}
+
+// PutStored adds the file holding the layer to a LayerStore that doesn't keep
+// local files.
+func (a *RemoteFetchArena) putStored(ctx context.Context, d claircore.Digest, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return a.cache.Put(ctx, d, f, fi.Size())
+}
@@ -0,0 +1,141 @@
+package libindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// Fresh returns copies of the layers with no local state.
+func fresh(ls []*claircore.Layer) []*claircore.Layer {
+	out := make([]*claircore.Layer, len(ls))
+	for i, l := range ls {
+		out[i] = &claircore.Layer{URI: l.URI, Hash: l.Hash, Headers: l.Headers}
+	}
+	return out
+}
+
+func TestFetchCache(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+
+	t.Run("Restart", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		bs := tarballs(t, 2, 1024)
+		var reqs int64
+		cl, ls := serveBlobs(t, "application/x-tar", bs, countRequests(&reqs))
+		cache := filepath.Join(t.TempDir(), "cache")
+
+		// The first arena fetches everything and goes away.
+		a := NewRemoteFetchArena(cl, t.TempDir(), WithLayerCache(cache, 0))
+		f := a.Realizer(ctx)
+		if err := f.Realize(ctx, fresh(ls)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if err := a.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := atomic.LoadInt64(&reqs), int64(2); got != want {
+			t.Fatalf("requests: got: %d, want: %d", got, want)
+		}
+
+		// A new arena, with a different root, doesn't touch the network.
+		root := t.TempDir()
+		a = NewRemoteFetchArena(cl, root, WithLayerCache(cache, 0), WithDiffID(), WithTarIndex())
+		defer a.Close(ctx)
+		f = a.Realizer(ctx)
+		defer f.Close()
+		got := fresh(ls)
+		if err := f.Realize(ctx, got); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := atomic.LoadInt64(&reqs), int64(2); got != want {
+			t.Errorf("requests: got: %d, want: %d", got, want)
+		}
+		for i, l := range got {
+			rc, err := l.Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(io.NewSectionReader(rc, 0, int64(len(bs[i]))+1))
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, bs[i]) {
+				t.Errorf("layer %d: contents differ", i)
+			}
+			sum := sha256.Sum256(bs[i])
+			want, _ := claircore.NewDigest("sha256", sum[:])
+			if d, ok := l.DiffID(); !ok || d.String() != want.String() {
+				t.Errorf("layer %d: diffID: got: %v, want: %v", i, d, want)
+			}
+			if _, err := a.Entry(l.Hash, "file"); err != nil {
+				t.Errorf("layer %d: %v", i, err)
+			}
+		}
+		// The arena's copies are independent of the cache.
+		if err := os.RemoveAll(cache); err != nil {
+			t.Fatal(err)
+		}
+		for i, l := range got {
+			rc, err := l.Reader()
+			if err != nil {
+				t.Errorf("layer %d: %v", i, err)
+				continue
+			}
+			rc.Close()
+		}
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		const size = 16 * 1024
+		cl, ls := serveBlobs(t, "application/x-tar", tarballs(t, 3, size))
+		cache := filepath.Join(t.TempDir(), "cache")
+		// Room for two layers, but not three.
+		a := NewRemoteFetchArena(cl, t.TempDir(), WithLayerCache(cache, 2*size+4096))
+		defer a.Close(ctx)
+		fetch := func(l *claircore.Layer) {
+			t.Helper()
+			f := a.Realizer(ctx)
+			defer f.Close()
+			if err := f.Realize(ctx, fresh([]*claircore.Layer{l})); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Modification times may be coarse, so set them outright.
+		age := func(l *claircore.Layer, ago time.Duration) {
+			t.Helper()
+			ts := time.Now().Add(-ago)
+			if err := os.Chtimes(filepath.Join(cache, l.Hash.String()), ts, ts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fetch(ls[0])
+		age(ls[0], 2*time.Hour)
+		fetch(ls[1])
+		age(ls[1], time.Hour)
+		// Using the first layer again makes the second the least recently
+		// used.
+		fetch(ls[0])
+		fetch(ls[2])
+
+		for i, want := range []bool{true, false, true} {
+			_, err := os.Stat(filepath.Join(cache, ls[i].Hash.String()))
+			if got := err == nil; got != want {
+				t.Errorf("layer %d: cached: got: %v, want: %v", i, got, want)
+			}
+		}
+	})
+}
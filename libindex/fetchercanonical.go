@@ -0,0 +1,57 @@
+package libindex
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// BlobFormat is an encoding stored copies of layers can be normalized to. See
+// WithCanonicalFormat.
+type BlobFormat int
+
+// These are the supported BlobFormats.
+const (
+	_ BlobFormat = iota
+	// BlobTar stores layers uncompressed.
+	BlobTar
+	// BlobGzip stores layers compressed with gzip.
+	BlobGzip
+	// BlobZstd stores layers compressed with zstd.
+	BlobZstd
+)
+
+// Compression returns the compression the format is reported as by Blob.
+func (f BlobFormat) compression() compression {
+	switch f {
+	case BlobTar:
+		return cmpNone
+	case BlobGzip:
+		return cmpGzip
+	case BlobZstd:
+		return cmpZstd
+	}
+	panic(fmt.Sprintf("programmer error: unknown BlobFormat %d", int(f)))
+}
+
+// Encoder returns a WriteCloser encoding into "w". Close must be called to
+// finish the encoding; it doesn't close "w".
+func (f BlobFormat) encoder(w io.Writer) (io.WriteCloser, error) {
+	switch f {
+	case BlobTar:
+		return nopWriteCloser{w}, nil
+	case BlobGzip:
+		return gzip.NewWriter(w), nil
+	case BlobZstd:
+		// Layers are fetched concurrently already, so one goroutine per
+		// encoder is plenty.
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("fetcher: unknown blob format %d", int(f))
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,138 @@
+package libindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchCanonical(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	c := strings.Repeat("canonical\n", 1000)
+	tb := tarball(t, c)
+	gz := gzipTarball(t, c)
+
+	// Decode undoes the canonical encoding.
+	decode := func(t *testing.T, format string, b []byte) []byte {
+		t.Helper()
+		var r io.Reader
+		switch format {
+		case "tar":
+			return b
+		case "gzip":
+			zr, err := gzip.NewReader(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = zr
+		case "zstd":
+			zr, err := zstd.NewReader(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+			r = zr
+		default:
+			t.Fatalf("unexpected format: %q", format)
+		}
+		out, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	tt := []struct {
+		name   string
+		format BlobFormat
+		want   string
+		opts   []ArenaOption
+	}{
+		{name: "Zstd", format: BlobZstd, want: "zstd"},
+		{name: "Tar", format: BlobTar, want: "tar"},
+		{name: "Gzip", format: BlobGzip, want: "gzip"},
+		{name: "Pooled", format: BlobZstd, want: "zstd", opts: []ArenaOption{WithDecompressWorkers(1)}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			cl, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", gz)
+			opts := append([]ArenaOption{WithCanonicalFormat(tc.format)}, tc.opts...)
+			a := NewRemoteFetchArena(cl, t.TempDir(), opts...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+				t.Fatal(err)
+			}
+			p, format, ok := a.Blob(l.Hash)
+			if !ok {
+				t.Fatal("no blob stored")
+			}
+			if got, want := format, tc.want; got != want {
+				t.Errorf("format: got: %q, want: %q", got, want)
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Re-encoding with gzip may well produce the same bytes.
+			if tc.want != "gzip" && bytes.Equal(b, gz) {
+				t.Error("blob stored verbatim")
+			}
+			if got := decode(t, format, b); !bytes.Equal(got, tb) {
+				t.Errorf("decoded blob differs from layer: got %d bytes, want %d", len(got), len(tb))
+			}
+		})
+	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		// The digest is of the uncompressed tar, not the gzip that's
+		// served, so the canonical copy would match it if that were what's
+		// verified.
+		cl, l := serveBlob(t, "application/gzip", gz)
+		sum := sha256.Sum256(tb)
+		d, err := claircore.NewDigest("sha256", sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Hash = d
+		root := t.TempDir()
+		a := NewRemoteFetchArena(cl, root, WithCanonicalFormat(BlobTar))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err = f.Realize(ctx, []*claircore.Layer{l})
+		t.Log(err)
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrDigestMismatch)
+		}
+		ents, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range ents {
+			t.Errorf("left behind: %s", e.Name())
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		a := NewRemoteFetchArena(nil, t.TempDir(), WithCanonicalFormat(BlobFormat(99)))
+		defer a.Close(ctx)
+		if a.storeCompressed || a.canonical != 0 {
+			t.Error("unknown format enabled storage")
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package libindex
+
+import "github.com/quay/claircore/indexer"
+
+var (
+	_ indexer.CapabilityReporter = (*RemoteFetchArena)(nil)
+	_ indexer.CapabilityReporter = (*FetchProxy)(nil)
+)
+
+// Capabilities reports the optional features of Realizers returned by the
+// arena, given the options it was constructed with.
+//
+// Layers are always fetched, and verified if fetched in full, before being
+// made available, so an arena never reports indexer.CapStreaming, and failed
+// fetches start over from the beginning, so it never reports
+// indexer.CapResumable.
+func (a *RemoteFetchArena) Capabilities() indexer.Capabilities {
+	// Layers on disk and in memory both support ReadAt.
+	c := indexer.CapSeekable | indexer.CapUnixSocket
+	if a.tarIndex {
+		c |= indexer.CapEntryIndex
+	}
+	// A canonical copy isn't the layer as fetched.
+	if a.storeCompressed && a.canonical == 0 {
+		c |= indexer.CapCompressedBlob
+	}
+	if a.lazy {
+		c |= indexer.CapPartial
+	}
+	if a.localFS != nil {
+		c |= indexer.CapLocalFile
+	}
+	return c
+}
+
+// Capabilities implements indexer.CapabilityReporter.
+func (p *FetchProxy) Capabilities() indexer.Capabilities {
+	return p.a.Capabilities()
+}
@@ -0,0 +1,90 @@
+package libindex
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+func TestFetchCapabilities(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	tt := []struct {
+		name string
+		opts []ArenaOption
+		want indexer.Capabilities
+	}{
+		{
+			name: "Default",
+			want: indexer.CapSeekable | indexer.CapUnixSocket,
+		},
+		{
+			name: "TarIndex",
+			opts: []ArenaOption{WithTarIndex()},
+			want: indexer.CapSeekable | indexer.CapUnixSocket | indexer.CapEntryIndex,
+		},
+		{
+			name: "StoreCompressed",
+			opts: []ArenaOption{WithStoreCompressed(), WithTarIndex()},
+			want: indexer.CapSeekable | indexer.CapUnixSocket | indexer.CapEntryIndex | indexer.CapCompressedBlob,
+		},
+		{
+			name: "LazyFetch",
+			opts: []ArenaOption{WithLazyFetch()},
+			want: indexer.CapSeekable | indexer.CapUnixSocket | indexer.CapPartial,
+		},
+		{
+			name: "LocalLayers",
+			opts: []ArenaOption{WithLocalLayers(fstest.MapFS{})},
+			want: indexer.CapSeekable | indexer.CapUnixSocket | indexer.CapLocalFile,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			a := NewRemoteFetchArena(nil, t.TempDir(), tc.opts...)
+			defer a.Close(ctx)
+			r := a.Realizer(ctx)
+			defer r.Close()
+			got := indexer.RealizerCapabilities(r)
+			t.Logf("capabilities: %v", got)
+			if got != tc.want {
+				t.Errorf("got: %v, want: %v", got, tc.want)
+			}
+			if got.Has(indexer.CapStreaming) || got.Has(indexer.CapResumable) {
+				t.Errorf("arena claims unsupported capabilities: %v", got)
+			}
+		})
+	}
+
+	t.Run("String", func(t *testing.T) {
+		for _, tc := range []struct {
+			c    indexer.Capabilities
+			want string
+		}{
+			{0, "none"},
+			{indexer.CapSeekable | indexer.CapEntryIndex, "seekable|entry-index"},
+			{indexer.CapCompressedBlob | 1<<20, "compressed-blob|0x100000"},
+		} {
+			if got := tc.c.String(); got != tc.want {
+				t.Errorf("got: %q, want: %q", got, tc.want)
+			}
+		}
+	})
+
+	t.Run("Unreported", func(t *testing.T) {
+		if got := indexer.RealizerCapabilities(nopRealizer{}); got != 0 {
+			t.Errorf("got: %v, want: none", got)
+		}
+	})
+}
+
+// NopRealizer is an indexer.Realizer that doesn't report capabilities.
+type nopRealizer struct{}
+
+func (nopRealizer) Realize(context.Context, []*claircore.Layer) error { return nil }
+func (nopRealizer) Close() error                                      { return nil }
@@ -0,0 +1,258 @@
+package libindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// ChunkedTransport records whether responses came back chunked.
+type chunkedTransport struct {
+	rt http.RoundTripper
+
+	mu      sync.Mutex
+	chunked []bool
+}
+
+func (t *chunkedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.rt.RoundTrip(req)
+	if err == nil {
+		t.mu.Lock()
+		t.chunked = append(t.chunked, isChunked(res) && res.ContentLength == -1)
+		t.mu.Unlock()
+	}
+	return res, err
+}
+
+// WatchChunked wraps the client's transport in a chunkedTransport.
+func watchChunked(c *http.Client) (*http.Client, *chunkedTransport) {
+	tr := &chunkedTransport{rt: c.Transport}
+	return &http.Client{Transport: tr}, tr
+}
+
+func TestFetchChunked(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const largeText = "incompressible? no, but large\n"
+	small, smallGz := tarball(t, "small"), gzipTarball(t, "small")
+	large, largeGz := tarball(t, strings.Repeat(largeText, 4096)), gzipTarball(t, strings.Repeat(largeText, 4096))
+	const gzType = "application/vnd.oci.image.layer.v1.tar+gzip"
+	// Check reads back the layer and reports whether it's in memory.
+	check := func(t *testing.T, l *claircore.Layer, want []byte) bool {
+		t.Helper()
+		rc, err := l.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Error("layer contents differ")
+		}
+		_, onDisk := rc.(*os.File)
+		return !onDisk
+	}
+	wasChunked := func(t *testing.T, tr *chunkedTransport) {
+		t.Helper()
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		if len(tr.chunked) == 0 || !tr.chunked[0] {
+			t.Fatal("response not chunked")
+		}
+	}
+
+	t.Run("Fetch", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, gzType, largeGz, withChunked())
+		c, tr := watchChunked(c)
+		a := NewRemoteFetchArena(c, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		wasChunked(t, tr)
+		check(t, l, large)
+	})
+
+	t.Run("Guessed", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "application/octet-stream", smallGz, withChunked())
+		c, tr := watchChunked(c)
+		a := NewRemoteFetchArena(c, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		wasChunked(t, tr)
+		check(t, l, small)
+	})
+
+	// With no length to estimate from, layers start out in memory and spill
+	// to disk if they're too large.
+	t.Run("MemoryThreshold", func(t *testing.T) {
+		for _, tc := range []struct {
+			name   string
+			gz, tb []byte
+			memory bool
+		}{
+			{"Small", smallGz, small, true},
+			{"Spill", largeGz, large, false},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				ctx := zlog.Test(ctx, t)
+				c, l := serveBlob(t, gzType, tc.gz, withChunked())
+				c, tr := watchChunked(c)
+				a := NewRemoteFetchArena(c, t.TempDir(), WithMemoryThreshold(int64(len(small))*2))
+				defer a.Close(ctx)
+				f := a.Realizer(ctx)
+				defer f.Close()
+				if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+					t.Fatal(err)
+				}
+				wasChunked(t, tr)
+				if got, want := check(t, l, tc.tb), tc.memory; got != want {
+					t.Errorf("in memory: got: %v, want: %v", got, want)
+				}
+			})
+		}
+	})
+
+	t.Run("StoreCompressed", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, gzType, largeGz, withChunked())
+		c, tr := watchChunked(c)
+		a := NewRemoteFetchArena(c, t.TempDir(), WithStoreCompressed())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		wasChunked(t, tr)
+		check(t, l, large)
+		p, format, ok := a.Blob(l.Hash)
+		if !ok {
+			t.Fatal("no blob")
+		}
+		if got, want := format, "gzip"; got != want {
+			t.Errorf("format: got: %q, want: %q", got, want)
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, largeGz) {
+			t.Errorf("blob differs: got %d bytes, want %d", len(b), len(largeGz))
+		}
+	})
+
+	t.Run("UncompressedSize", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, gzType, largeGz, withChunked())
+		l.UncompressedSize = int64(len(large))
+		a := NewRemoteFetchArena(c, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		check(t, l, large)
+
+		// A wrong size is still caught, even with the file preallocated.
+		c, l = serveBlob(t, gzType, largeGz, withChunked())
+		l.UncompressedSize = int64(len(large)) * 2
+		f = a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); !errors.Is(err, ErrSizeMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrSizeMismatch)
+		}
+	})
+}
+
+// LengthTransport returns canned responses, to send lengths the http package
+// wouldn't.
+type lengthTransport struct {
+	body    []byte
+	length  int64
+	chunked bool
+}
+
+func (t *lengthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/x-tar"}},
+		Body:          io.NopCloser(bytes.NewReader(t.body)),
+		ContentLength: t.length,
+		Request:       req,
+	}
+	if t.chunked {
+		res.TransferEncoding = []string{"chunked"}
+	}
+	return res, nil
+}
+
+func TestFetchContentLength(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	b := tarball(t, "length")
+	sum := sha256.Sum256(b)
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := int64(len(b))
+	tt := []struct {
+		name string
+		tr   lengthTransport
+		err  bool
+	}{
+		{name: "Exact", tr: lengthTransport{length: n}},
+		{name: "Unknown", tr: lengthTransport{length: -1}},
+		{name: "Long", tr: lengthTransport{length: n + 512}, err: true},
+		{name: "Short", tr: lengthTransport{length: n - 512}, err: true},
+		// The length of a chunked response is ignored.
+		{name: "Chunked", tr: lengthTransport{length: n + 512, chunked: true}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			tr := tc.tr
+			tr.body = b
+			c := &http.Client{Transport: &tr}
+			l := &claircore.Layer{
+				URI:     "http://example.com/blob",
+				Hash:    d,
+				Headers: make(http.Header),
+			}
+			a := NewRemoteFetchArena(c, t.TempDir())
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			if got, want := errors.Is(err, ErrSizeMismatch), tc.err; got != want {
+				t.Errorf("got: %v, want error: %v", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// Clean removes files from the arena root that were left behind by a previous
+// arena, such as one in a process that crashed.
+//
+// Only files named like the ones the arena creates and that the arena isn't
+// tracking are removed: layers, their stored and compacted copies, and
+// in-progress fetches. Other files in the root are left alone. If another
+// arena is using the same root, nothing is removed, as its files can't be told
+// apart from stale ones.
+//
+// Clean should be called before the arena is used; see Options.CleanOnInit.
+func (a *RemoteFetchArena) Clean(ctx context.Context) error {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/fetchArena.Clean",
+		"arena", a.root)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rootLock == nil {
+		lf, err := lockRoot(a.root)
+		if err != nil {
+			return fmt.Errorf("fetcher: unable to lock arena root: %w", err)
+		}
+		a.rootLock = lf
+	}
+
+	var removed int
+	ok, err := withExclusiveRoot(a.rootLock, func() error {
+		ents, err := os.ReadDir(a.root)
+		if err != nil {
+			return fmt.Errorf("fetcher: unable to read arena root: %w", err)
+		}
+		for _, e := range ents {
+			if !e.Type().IsRegular() || !a.stale(e.Name()) {
+				continue
+			}
+			err := os.Remove(filepath.Join(a.root, e.Name()))
+			switch {
+			case err == nil:
+				removed++
+			case errors.Is(err, os.ErrNotExist):
+			default:
+				return fmt.Errorf("fetcher: unable to remove stale file: %w", err)
+			}
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		zlog.Info(ctx).Msg("arena root in use by another arena, not cleaning")
+		return nil
+	}
+	zlog.Debug(ctx).
+		Int("count", removed).
+		Msg("removed stale files")
+	return nil
+}
+
+// Stale reports whether the named file in the root is one the arena would
+// have created, but isn't tracking.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) stale(name string) bool {
+	if strings.HasPrefix(name, "fetch.") || strings.HasPrefix(name, "compact.") {
+		return true
+	}
+	for _, s := range []string{blobSuffix, compactSuffix, tarIndexSuffix} {
+		name = strings.TrimSuffix(name, s)
+	}
+	if _, err := claircore.ParseDigest(name); err != nil {
+		return false
+	}
+	_, inUse := a.rc[name]
+	_, isIdle := a.idle[name]
+	_, isBusy := a.busy[name]
+	return !inUse && !isIdle && !isBusy
+}
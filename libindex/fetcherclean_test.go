@@ -0,0 +1,118 @@
+package libindex
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+)
+
+func TestFetchClean(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	d := digest("stale layer").String()
+	stale := []string{d, d + blobSuffix, d + compactSuffix, "fetch.123", "compact.456"}
+	keep := []string{"unrelated.txt", "sha256:not-a-digest"}
+	setup := func(t *testing.T) string {
+		t.Helper()
+		root := t.TempDir()
+		for _, n := range append(append([]string{}, stale...), keep...) {
+			if err := os.WriteFile(filepath.Join(root, n), []byte("x"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Mkdir(filepath.Join(root, digest("a directory").String()), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+	list := func(t *testing.T, root string) []string {
+		t.Helper()
+		ents, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, e := range ents {
+			if e.Type().IsRegular() {
+				out = append(out, e.Name())
+			}
+		}
+		sort.Strings(out)
+		return out
+	}
+	sorted := func(ss ...[]string) []string {
+		var out []string
+		for _, s := range ss {
+			out = append(out, s...)
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	t.Run("Clean", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		root := setup(t)
+		a := NewRemoteFetchArena(nil, root)
+		defer a.Close(ctx)
+		if err := a.Clean(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := list(t, root), sorted(keep); !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+	t.Run("NoClean", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		root := setup(t)
+		a := NewRemoteFetchArena(nil, root)
+		defer a.Close(ctx)
+		if got, want := list(t, root), sorted(stale, keep); !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+	t.Run("Tracked", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		root := setup(t)
+		a := NewRemoteFetchArena(nil, root)
+		defer a.Close(ctx)
+		a.rc[d] = 1
+		if err := a.Clean(ctx); err != nil {
+			t.Fatal(err)
+		}
+		want := sorted(keep, []string{d, d + blobSuffix, d + compactSuffix})
+		if got := list(t, root); !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+	t.Run("Shared", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("arena root locking not implemented on windows")
+		}
+		ctx := zlog.Test(ctx, t)
+		root := setup(t)
+		other := NewRemoteFetchArena(nil, root)
+		a := NewRemoteFetchArena(nil, root)
+		defer a.Close(ctx)
+		if err := a.Clean(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := list(t, root), sorted(stale, keep); !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+		// Once the other arena is gone, cleaning works.
+		if err := other.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Clean(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := list(t, root), sorted(keep); !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+}
@@ -0,0 +1,302 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+)
+
+// CompactSuffix is appended to a layer's file name to name the compacted
+// copy of the layer.
+const compactSuffix = ".zst"
+
+// IdleLayer is the bookkeeping for a layer retained with a zero refcount.
+type idleLayer struct {
+	since time.Time
+	// Compacted reports whether the layer is stored zstd compressed.
+	compacted bool
+}
+
+// Path reports the file holding the layer.
+func (e *idleLayer) path(root, digest string) string {
+	p := filepath.Join(root, digest)
+	if e.compacted {
+		p += compactSuffix
+	}
+	return p
+}
+
+// OnDisk reports whether the layer is stored as a file owned by the arena.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) onDisk(digest string) bool {
+	if _, ok := a.supplied[digest]; ok {
+		return false
+	}
+	if _, ok := a.mem[digest]; ok {
+		return false
+	}
+	return true
+}
+
+// Reuse claims a retained layer, if there is one. A compacted layer is
+// expanded back into a tar first.
+//
+// If the second return is true, the layer has been moved back into the
+// refcount map with a zero count and the first return is its path.
+func (a *RemoteFetchArena) reuse(ctx context.Context, h string) (string, bool, error) {
+	for {
+		a.mu.Lock()
+		if ch, ok := a.busy[h]; ok {
+			a.mu.Unlock()
+			select {
+			case <-ch:
+				continue
+			case <-ctx.Done():
+				return "", false, ctx.Err()
+			}
+		}
+		e, ok := a.idle[h]
+		if !ok {
+			a.mu.Unlock()
+			return "", false, nil
+		}
+		if a.expired(h, a.clock.Now()) {
+			err := a.removeIdle(h, e)
+			a.mu.Unlock()
+			if err != nil {
+				zlog.Warn(ctx).Err(err).Msg("unable to remove expired layer")
+			}
+			zlog.Debug(ctx).
+				Str("layer", h).
+				Msg("retained layer expired, fetching")
+			return "", false, nil
+		}
+		delete(a.idle, h)
+		tgt := filepath.Join(a.root, h)
+		if !e.compacted {
+			a.rc[h] = 0
+			a.mu.Unlock()
+			return tgt, true, nil
+		}
+		ch := make(chan struct{})
+		a.busy[h] = ch
+		a.mu.Unlock()
+
+		err := a.expand(ctx, h)
+		a.mu.Lock()
+		delete(a.busy, h)
+		close(ch)
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			// The compacted file is untouched.
+			a.idle[h] = e
+			a.mu.Unlock()
+			return "", false, err
+		default:
+			// Drop the entry and fetch the layer again.
+			delete(a.verified, h)
+			delete(a.diffIDs, h)
+			delete(a.compressed, h)
+			delete(a.fetched, h)
+			if err := a.removeTarIndex(h); err != nil {
+				zlog.Warn(ctx).Err(err).Msg("unable to remove tar index")
+			}
+			a.mu.Unlock()
+			zlog.Warn(ctx).
+				Err(err).
+				Str("layer", h).
+				Msg("unable to expand compacted layer, fetching")
+			if err := os.Remove(e.path(a.root, h)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				zlog.Warn(ctx).Err(err).Msg("unable to remove compacted layer")
+			}
+			return "", false, nil
+		}
+		a.rc[h] = 0
+		a.mu.Unlock()
+		return tgt, true, nil
+	}
+}
+
+// Compact re-compresses layers retained with WithRetainIdle using zstd, to
+// trade CPU for disk space on layers that aren't being used. A compacted
+// layer is transparently expanded the next time it's fetched.
+//
+// Layers in use are never touched. If the Context is canceled, Compact stops
+// and returns the Context's error; the layer being compacted at the time is
+// left as it was. Compaction doesn't affect digest verification, which is
+// only done against the bytes as fetched.
+//
+// Compact must not be called concurrently with Close.
+func (a *RemoteFetchArena) Compact(ctx context.Context) error {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/fetchArena.Compact",
+		"arena", a.root)
+	a.mu.Lock()
+	var todo []string
+	for d, e := range a.idle {
+		if !e.compacted {
+			todo = append(todo, d)
+		}
+	}
+	a.mu.Unlock()
+	zlog.Debug(ctx).
+		Int("count", len(todo)).
+		Msg("compacting idle layers")
+	for _, d := range todo {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := a.compactOne(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompactOne compacts the named layer if it's still idle and uncompacted.
+func (a *RemoteFetchArena) compactOne(ctx context.Context, h string) error {
+	a.mu.Lock()
+	e, ok := a.idle[h]
+	// Layers stored compressed have nothing to gain.
+	_, compressed := a.compressed[h]
+	if !ok || e.compacted || compressed {
+		a.mu.Unlock()
+		return nil
+	}
+	if _, ok := a.busy[h]; ok {
+		a.mu.Unlock()
+		return nil
+	}
+	// Remove the layer from the idle set for the duration, so that reuse
+	// waits instead of claiming it out from under us.
+	delete(a.idle, h)
+	ch := make(chan struct{})
+	a.busy[h] = ch
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.busy, h)
+		a.idle[h] = e
+		close(ch)
+	}()
+
+	src := filepath.Join(a.root, h)
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(a.root, "compact.*")
+	if err != nil {
+		return fmt.Errorf("fetcher: unable to create file: %w", err)
+	}
+	rm := true
+	defer func() {
+		if rm {
+			if err := os.Remove(out.Name()); err != nil {
+				zlog.Warn(ctx).Err(err).Msg("unable to remove unsuccessful compaction")
+			}
+		}
+	}()
+	enc, err := zstd.NewWriter(out)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(enc, &ctxReader{ctx: ctx, r: in}); err != nil {
+		enc.Close()
+		out.Close()
+		return noSpace(err)
+	}
+	if err := enc.Close(); err != nil {
+		out.Close()
+		return noSpace(err)
+	}
+	if err := out.Close(); err != nil {
+		return noSpace(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.Rename(out.Name(), src+compactSuffix); err != nil {
+		return err
+	}
+	rm = false
+	if err := os.Remove(src); err != nil {
+		// Leave the uncompressed copy in place rather than have two.
+		if err := os.Remove(src + compactSuffix); err != nil {
+			zlog.Warn(ctx).Err(err).Msg("unable to remove compacted layer")
+		}
+		return err
+	}
+	e.compacted = true
+	zlog.Debug(ctx).
+		Str("layer", h).
+		Msg("compacted layer")
+	return nil
+}
+
+// Expand reverses compactOne.
+func (a *RemoteFetchArena) expand(ctx context.Context, h string) error {
+	tgt := filepath.Join(a.root, h)
+	in, err := os.Open(tgt + compactSuffix)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	dec, err := zstd.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	out, err := os.CreateTemp(a.root, "fetch.*")
+	if err != nil {
+		return fmt.Errorf("fetcher: unable to create file: %w", err)
+	}
+	rm := true
+	defer func() {
+		if rm {
+			if err := os.Remove(out.Name()); err != nil {
+				zlog.Warn(ctx).Err(err).Msg("unable to remove unsuccessful expansion")
+			}
+		}
+	}()
+	if _, err := io.Copy(out, &ctxReader{ctx: ctx, r: dec}); err != nil {
+		out.Close()
+		return noSpace(err)
+	}
+	if err := out.Close(); err != nil {
+		return noSpace(err)
+	}
+	if err := os.Rename(out.Name(), tgt); err != nil {
+		return err
+	}
+	rm = false
+	if err := os.Remove(tgt + compactSuffix); err != nil {
+		zlog.Warn(ctx).Err(err).Msg("unable to remove compacted layer")
+	}
+	return nil
+}
+
+// CtxReader is a Reader that fails once its Context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
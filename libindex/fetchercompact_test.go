@@ -0,0 +1,204 @@
+package libindex
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchCompact(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	tb := tarball(t, strings.Repeat("compressible\n", 4096))
+	sum := sha256.Sum256(tb)
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reqs int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&reqs, 1)
+		w.Header().Set("content-type", "application/x-tar")
+		w.Write(tb)
+	}))
+	defer srv.Close()
+	layer := func() *claircore.Layer {
+		return &claircore.Layer{
+			Hash:    d,
+			URI:     srv.URL + "/blob",
+			Headers: make(http.Header),
+		}
+	}
+	// Check makes sure the layer reads back as the original tar.
+	check := func(t *testing.T, l *claircore.Layer) {
+		t.Helper()
+		rc, err := l.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tb) {
+			t.Error("layer contents differ")
+		}
+	}
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil
+	}
+
+	t.Run("Idle", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		atomic.StoreInt64(&reqs, 0)
+		root := t.TempDir()
+		a := NewRemoteFetchArena(srv.Client(), root, WithRetainIdle())
+		defer a.Close(ctx)
+		p := filepath.Join(root, d.String())
+
+		f := a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{layer()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !exists(p) {
+			t.Fatal("idle layer not retained")
+		}
+
+		if err := a.Compact(ctx); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(p + compactSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("compacted %d bytes to %d", len(tb), fi.Size())
+		if fi.Size() >= int64(len(tb)) {
+			t.Errorf("compacted layer not smaller: %d >= %d", fi.Size(), len(tb))
+		}
+		if exists(p) {
+			t.Error("uncompressed layer still present")
+		}
+
+		f = a.Realizer(ctx)
+		defer f.Close()
+		l := layer()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := atomic.LoadInt64(&reqs), int64(1); got != want {
+			t.Errorf("requests: got: %d, want: %d", got, want)
+		}
+		check(t, l)
+		if v, ok := l.Verified(); !ok || v.String() != d.String() {
+			t.Errorf("verified digest: got: %v, %v", v, ok)
+		}
+		if exists(p + compactSuffix) {
+			t.Error("compacted layer still present")
+		}
+	})
+
+	t.Run("InUse", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		root := t.TempDir()
+		a := NewRemoteFetchArena(srv.Client(), root, WithRetainIdle())
+		defer a.Close(ctx)
+		p := filepath.Join(root, d.String())
+
+		f := a.Realizer(ctx)
+		defer f.Close()
+		l := layer()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Compact(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if exists(p + compactSuffix) {
+			t.Error("in-use layer compacted")
+		}
+		check(t, l)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		atomic.StoreInt64(&reqs, 0)
+		root := t.TempDir()
+		a := NewRemoteFetchArena(srv.Client(), root, WithRetainIdle())
+		defer a.Close(ctx)
+		p := filepath.Join(root, d.String())
+
+		f := a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{layer()}); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := a.Compact(cctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("got: %v, want: %v", err, context.Canceled)
+		}
+		if !exists(p) || exists(p+compactSuffix) {
+			t.Error("canceled compaction changed the arena")
+		}
+		ents, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(ents), 1; got != want {
+			t.Errorf("arena entries: got: %d, want: %d", got, want)
+		}
+
+		f = a.Realizer(ctx)
+		defer f.Close()
+		l := layer()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := atomic.LoadInt64(&reqs), int64(1); got != want {
+			t.Errorf("requests: got: %d, want: %d", got, want)
+		}
+		check(t, l)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		root := t.TempDir()
+		a := NewRemoteFetchArena(srv.Client(), root, WithRetainIdle())
+		f := a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{layer()}); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if err := a.Compact(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		ents, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range ents {
+			t.Errorf("left behind: %s", e.Name())
+		}
+	})
+}
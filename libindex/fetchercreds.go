@@ -0,0 +1,128 @@
+package libindex
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RegistryCredentials is a credential store for WithRegistryAuth, holding
+// credentials by registry host. Hosts include the port, if any, like
+// "registry.example.com:5000".
+//
+// The map must not be modified while in use.
+type RegistryCredentials map[string]RegistryCredential
+
+// RegistryCredential is a set of credentials for one registry.
+//
+// If IdentityToken is set, it's exchanged for tokens as an OAuth2 refresh
+// token and the Username and Password are ignored. Otherwise, the Username and
+// Password are presented to the registry or its token service.
+type RegistryCredential struct {
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+// IdentityTokenUser is the username a CredentialFunc returns to have the
+// password used as an OAuth2 refresh token, as done by Docker's credential
+// helpers.
+const identityTokenUser = "<token>"
+
+// DockerHubHosts are the names Docker Hub is known by. Credentials for any
+// are used for all.
+var dockerHubHosts = []string{"docker.io", "index.docker.io", "registry-1.docker.io"}
+
+// Lookup is a CredentialFunc returning the credentials for the host. Hosts
+// without credentials get anonymous access.
+//
+// Use the method value with WithRegistryAuth:
+//
+//	WithRegistryAuth(creds.Lookup)
+func (rc RegistryCredentials) Lookup(_ context.Context, host string) (string, string, error) {
+	c, ok := rc[host]
+	if !ok && isDockerHub(host) {
+		for _, h := range dockerHubHosts {
+			if c, ok = rc[h]; ok {
+				break
+			}
+		}
+	}
+	switch {
+	case !ok:
+		return "", "", nil
+	case c.IdentityToken != "":
+		return identityTokenUser, c.IdentityToken, nil
+	}
+	return c.Username, c.Password, nil
+}
+
+// IsDockerHub reports whether the host is one of dockerHubHosts.
+func isDockerHub(host string) bool {
+	for _, h := range dockerHubHosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadDockerConfig reads the credentials stored in the "auths" section of a
+// Docker client configuration file, as written by "docker login" and used by
+// podman and skopeo as "auth.json".
+//
+// Credentials kept by credential helpers ("credsStore" and "credHelpers")
+// aren't read.
+func LoadDockerConfig(path string) (RegistryCredentials, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fetcher: unable to read docker config: %w", err)
+	}
+	var cfg struct {
+		Auths map[string]struct {
+			Auth          string `json:"auth"`
+			Username      string `json:"username"`
+			Password      string `json:"password"`
+			IdentityToken string `json:"identitytoken"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("fetcher: unable to parse docker config %q: %w", path, err)
+	}
+	rc := make(RegistryCredentials, len(cfg.Auths))
+	for k, a := range cfg.Auths {
+		c := RegistryCredential{
+			Username:      a.Username,
+			Password:      a.Password,
+			IdentityToken: a.IdentityToken,
+		}
+		if a.Auth != "" {
+			dec, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("fetcher: bad auth for %q in docker config %q: %w", k, path, err)
+			}
+			i := strings.IndexByte(string(dec), ':')
+			if i == -1 {
+				return nil, fmt.Errorf("fetcher: bad auth for %q in docker config %q: no separator", k, path)
+			}
+			c.Username, c.Password = string(dec[:i]), string(dec[i+1:])
+		}
+		rc[configHost(k)] = c
+	}
+	return rc, nil
+}
+
+// ConfigHost returns the host named by a key of a Docker config's "auths"
+// section, which may be a URL like "https://index.docker.io/v1/".
+func configHost(k string) string {
+	if i := strings.Index(k, "://"); i != -1 {
+		k = k[i+len("://"):]
+	}
+	if i := strings.IndexByte(k, '/'); i != -1 {
+		k = k[:i]
+	}
+	return k
+}
@@ -0,0 +1,161 @@
+package libindex
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// DefaultMaxDecoderWindow is the largest zstd window a layer may ask for when
+// WithMaxDecoderWindow isn't used. This is enough for the "--long" mode of the
+// zstd tool; the default compression levels use windows of at most 8 MiB.
+const defaultMaxDecoderWindow = 128 << 20
+
+// DecoderPool holds decompressors for reuse across fetches.
+//
+// Both kinds are kept in a sync.Pool. A streaming zstd decoder has a goroutine
+// that only exits when the decoder is closed, and a sync.Pool drops its
+// contents without telling anyone, so pooled zstd decoders are wrapped in a
+// pooledZstd with a finalizer that closes the decoder once the wrapper is
+// collected.
+//
+// A decompressor is only returned to the pool by its reader's Close, which
+// gives up the reader's reference to it, so a reader closed twice, or read
+// after being closed, can't touch a decompressor that's been handed to
+// another fetch.
+type decoderPool struct {
+	gzip sync.Pool
+	zstd sync.Pool
+	// MaxWindow is the limit on zstd window sizes.
+	maxWindow uint64
+}
+
+// PooledZstd is a zstd decoder kept in a decoderPool.
+type pooledZstd struct {
+	*zstd.Decoder
+}
+
+func newDecoderPool(maxWindow uint64) *decoderPool {
+	switch {
+	case maxWindow == 0:
+		maxWindow = defaultMaxDecoderWindow
+	case maxWindow < zstd.MinWindowSize:
+		maxWindow = zstd.MinWindowSize
+	}
+	return &decoderPool{
+		maxWindow: maxWindow,
+	}
+}
+
+// Decoder returns a Reader of the tar stream compressed in "br", and a
+// function to release the decompressor, if there is one.
+func (p *decoderPool) decoder(c compression, br *bufio.Reader) (io.Reader, func(), error) {
+	switch c {
+	case cmpGzip:
+		g, err := newGzipMembers(p, br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return g, func() { g.Close() }, nil
+	case cmpZstd:
+		s, err := newZstdFrames(p, br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	case cmpXz:
+		// The xz reader handles concatenated streams and the padding
+		// between them itself.
+		x, err := xz.NewReader(br)
+		if err != nil {
+			return nil, nil, &errDecompress{c: c, err: err}
+		}
+		return &decompressReader{c: c, r: x}, nil, nil
+	case cmpBzip2:
+		return &decompressReader{c: c, r: bzip2.NewReader(br)}, nil, nil
+	case cmpNone:
+		return br, nil, nil
+	}
+	return nil, nil, fmt.Errorf("fetcher: unable to decompress %v", c)
+}
+
+// DecompressReader reports errors from a decompressor that isn't pooled as
+// errDecompress.
+//
+// Xz and bzip2 layers are rare enough that their decompressors aren't worth
+// keeping around.
+type decompressReader struct {
+	c compression
+	r io.Reader
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &errDecompress{c: d.c, err: err}
+	}
+	return n, err
+}
+
+// GetGzip returns a gzip.Reader reading the member at the start of "r".
+func (p *decoderPool) getGzip(r io.Reader) (*gzip.Reader, error) {
+	z, ok := p.gzip.Get().(*gzip.Reader)
+	if !ok {
+		return gzip.NewReader(r)
+	}
+	if err := z.Reset(r); err != nil {
+		p.putGzip(z)
+		return nil, err
+	}
+	return z, nil
+}
+
+// EmptyReader is what pooled gzip readers are pointed at, so that they don't
+// keep a fetch's buffers alive. Resetting onto it fails, which is fine: the
+// reader is reset again before it's used.
+var emptyReader = bytes.NewReader(nil)
+
+func (p *decoderPool) putGzip(z *gzip.Reader) {
+	z.Reset(emptyReader)
+	p.gzip.Put(z)
+}
+
+// GetZstd returns a zstd decoder reading from "r".
+func (p *decoderPool) getZstd(r io.Reader) (*pooledZstd, error) {
+	if d, ok := p.zstd.Get().(*pooledZstd); ok {
+		if err := d.Reset(r); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	d, err := zstd.NewReader(r,
+		zstd.WithDecoderMaxWindow(p.maxWindow),
+		zstd.WithDecoderMaxMemory(p.maxWindow),
+		// Frames in a stream are decoded one after another, so additional
+		// block decoders would only take up memory.
+		zstd.WithDecoderConcurrency(1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	pd := &pooledZstd{Decoder: d}
+	runtime.SetFinalizer(pd, func(pd *pooledZstd) { pd.Close() })
+	return pd, nil
+}
+
+func (p *decoderPool) putZstd(d *pooledZstd) {
+	// Drop the reference to the input and stop any decoding in progress. A
+	// decoder that can't be reset is left for the finalizer.
+	if err := d.Reset(nil); err != nil {
+		return
+	}
+	p.zstd.Put(d)
+}
@@ -0,0 +1,206 @@
+package libindex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchZstdWindow(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const ct = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+	t.Run("Hostile", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		// A frame header declaring a 2 TiB window, followed by a single raw
+		// block.
+		frame := []byte{
+			0x28, 0xb5, 0x2f, 0xfd, // Magic
+			0x00,             // Frame header descriptor: no single segment
+			0xf8,             // Window descriptor: exponent 31
+			0x09, 0x00, 0x00, // Last block, raw, one byte
+			'x',
+		}
+		c, l := serveBlob(t, ct, frame)
+		a := NewRemoteFetchArena(c, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := f.Realize(ctx, []*claircore.Layer{l})
+		runtime.ReadMemStats(&after)
+		t.Log(err)
+		if !errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			t.Errorf("got: %v, want: %v", err, zstd.ErrWindowSizeExceeded)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 32<<20 {
+			t.Errorf("allocated %d bytes", n)
+		}
+	})
+
+	// A legitimate frame using an 8 MiB window.
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(0)).Read(data)
+	var b bytes.Buffer
+	w, err := zstd.NewWriter(&b, zstd.WithWindowSize(8<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(tarball(t, string(data))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tt := []struct {
+		name string
+		max  uint64
+		err  bool
+	}{
+		{name: "Default"},
+		{name: "Limited", max: 1 << 20, err: true},
+		{name: "Allowed", max: 8 << 20},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, ct, b.Bytes())
+			a := NewRemoteFetchArena(c, t.TempDir(), WithMaxDecoderWindow(tc.max))
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			t.Log(err)
+			switch {
+			case tc.err && !errors.Is(err, zstd.ErrWindowSizeExceeded):
+				t.Errorf("got: %v, want: %v", err, zstd.ErrWindowSizeExceeded)
+			case !tc.err && err != nil:
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDecoderPool(t *testing.T) {
+	p := newDecoderPool(0)
+	var b bytes.Buffer
+	w, err := zstd.NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("pooled"))
+	w.Close()
+	open := func() *zstdFrames {
+		t.Helper()
+		z, err := newZstdFrames(p, bufio.NewReader(bytes.NewReader(b.Bytes())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return z
+	}
+
+	for i := 0; i < 3; i++ {
+		z := open()
+		got, err := io.ReadAll(z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "pooled" {
+			t.Errorf("got: %q", got)
+		}
+		// Closing twice, as a deferred Close after an explicit one would,
+		// must only return the decoder once: otherwise two readers would
+		// end up sharing it.
+		z.Close()
+		z.Close()
+		a, b := open(), open()
+		if a.d == b.d {
+			t.Error("decoder handed out twice")
+		}
+		a.Close()
+		b.Close()
+		if _, err := z.Read(make([]byte, 1)); !errors.Is(err, errClosed) {
+			t.Errorf("got: %v, want: %v", err, errClosed)
+		}
+	}
+
+	// A decoder abandoned mid-stream is still usable.
+	z := open()
+	z.Read(make([]byte, 1))
+	z.Close()
+	z = open()
+	defer z.Close()
+	if got, err := io.ReadAll(z); err != nil || string(got) != "pooled" {
+		t.Errorf("got: %q, %v", got, err)
+	}
+}
+
+// BenchmarkZstdDecoder decompresses layers concurrently, constructing a
+// decoder for each one as the fetcher used to, and with decoders from a
+// decoderPool.
+func BenchmarkZstdDecoder(b *testing.B) {
+	data := make([]byte, 1<<20)
+	rng := rand.New(rand.NewSource(0))
+	for i := range data {
+		data[i] = 'a' + byte(rng.Intn(16))
+	}
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := w.Write(tarball(b, string(data))); err != nil {
+		b.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+	layer := buf.Bytes()
+
+	b.Run("New", func(b *testing.B) {
+		b.SetBytes(int64(len(layer)))
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				d, err := zstd.NewReader(bufio.NewReader(bytes.NewReader(layer)))
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				if _, err := io.Copy(io.Discard, d); err != nil {
+					b.Error(err)
+				}
+				d.Close()
+			}
+		})
+	})
+	b.Run("Pooled", func(b *testing.B) {
+		p := newDecoderPool(0)
+		b.SetBytes(int64(len(layer)))
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				r, done, err := p.decoder(cmpZstd, bufio.NewReader(bytes.NewReader(layer)))
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					b.Error(err)
+				}
+				done()
+			}
+		})
+	})
+}
@@ -0,0 +1,68 @@
+package libindex
+
+import "net/http"
+
+// DefaultMaxHeaderBytes is the limit on the size of a Layer's headers used if
+// none is configured. Registry tokens run to a few kilobytes at most.
+const defaultMaxHeaderBytes = 64 * 1024
+
+// CheckHeader validates the headers from a Layer before they're used to build
+// a request.
+//
+// Layers' headers come from manifests, which aren't trusted, so names must be
+// valid tokens, values may not contain control characters other than tab, and
+// the whole set must fit in "max" bytes, counted as it would be sent. The
+// http package refuses some of this on its own, but only once the request is
+// being written and with a less specific error.
+func checkHeader(h http.Header, max int) error {
+	if max <= 0 {
+		max = defaultMaxHeaderBytes
+	}
+	sz := 0
+	for k, vs := range h {
+		if !validHeaderName(k) {
+			return &errInvalidHeader{name: k, reason: "invalid name"}
+		}
+		for _, v := range vs {
+			if !validHeaderValue(v) {
+				return &errInvalidHeader{name: k, reason: "control character in value"}
+			}
+			// Name, ": ", value, CRLF.
+			sz += len(k) + len(v) + 4
+		}
+		if sz > max {
+			return &errInvalidHeader{name: k, reason: "headers too large"}
+		}
+	}
+	return nil
+}
+
+// ValidHeaderName reports whether "n" is a token, per RFC 7230 section 3.2.6.
+func validHeaderName(n string) bool {
+	if n == "" {
+		return false
+	}
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c == '!', c == '#', c == '$', c == '%', c == '&', c == '\'', c == '*',
+			c == '+', c == '-', c == '.', c == '^', c == '_', c == '`', c == '|', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidHeaderValue reports whether "v" is free of control characters, other
+// than horizontal tab. This is what rules out CR and LF.
+func validHeaderValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
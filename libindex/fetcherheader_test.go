@@ -0,0 +1,105 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchInvalidHeader(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	tt := []struct {
+		name   string
+		header http.Header
+		opts   []ArenaOption
+		err    error
+	}{
+		{
+			name:   "OK",
+			header: http.Header{"Authorization": {"Bearer " + strings.Repeat("a", 4096)}},
+		},
+		{
+			name:   "CRLF",
+			header: http.Header{"Authorization": {"Bearer x\r\nX-Injected: 1"}},
+			err:    ErrInvalidHeader,
+		},
+		{
+			name:   "LF",
+			header: http.Header{"Accept": {"*/*\nHost: evil.example.com"}},
+			err:    ErrInvalidHeader,
+		},
+		{
+			name:   "NUL",
+			header: http.Header{"Accept": {"*/*\x00"}},
+			err:    ErrInvalidHeader,
+		},
+		{
+			name:   "Name",
+			header: http.Header{"X-Injected: 1\r\nAccept": {"*/*"}},
+			err:    ErrInvalidHeader,
+		},
+		{
+			name:   "Oversized",
+			header: http.Header{"Authorization": {"Bearer " + strings.Repeat("a", defaultMaxHeaderBytes)}},
+			err:    ErrInvalidHeader,
+		},
+		{
+			name: "OversizedTotal",
+			header: http.Header{
+				"X-A": {strings.Repeat("a", 600)},
+				"X-B": {strings.Repeat("b", 600)},
+			},
+			opts: []ArenaOption{WithMaxHeaderBytes(1024)},
+			err:  ErrInvalidHeader,
+		},
+		{
+			name:   "Configured",
+			header: http.Header{"Authorization": {"Bearer " + strings.Repeat("a", defaultMaxHeaderBytes)}},
+			opts:   []ArenaOption{WithMaxHeaderBytes(2 * defaultMaxHeaderBytes)},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar", tarball(t, ""))
+			ct := &countTransport{RoundTripper: c.Transport}
+			c.Transport = ct
+			l.Headers = map[string][]string(tc.header)
+			a := NewRemoteFetchArena(c, t.TempDir(), tc.opts...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			switch {
+			case tc.err == nil && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.err != nil && !errors.Is(err, tc.err):
+				t.Fatalf("got: %v, want: %v", err, tc.err)
+			}
+			if tc.err != nil {
+				t.Log(err)
+				if n := atomic.LoadInt32(&ct.n); n != 0 {
+					t.Errorf("%d requests sent", n)
+				}
+			}
+		})
+	}
+}
+
+// CountTransport counts the requests made through it.
+type countTransport struct {
+	http.RoundTripper
+	n int32
+}
+
+func (t *countTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.n, 1)
+	return t.RoundTripper.RoundTrip(r)
+}
@@ -0,0 +1,558 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+// ErrNotLazy is reported by fetchLazy when a layer can't be fetched in part,
+// either because it's not in a format with a table of contents or because the
+// server doesn't support range requests. The layer is fetched in full
+// instead.
+var errNotLazy = errors.New("layer can't be fetched lazily")
+
+// EStargzFooterSize is the size of an eStargz footer. The footer of the
+// original stargz format is 47 bytes, and so is found in the same suffix.
+const estargzFooterSize = 51
+
+// MaxTOCSize bounds the size of an eStargz table of contents, both compressed
+// and not.
+const maxTOCSize = 64 << 20
+
+// EStargzTOCName is the name of the table of contents in its tar stream.
+const estargzTOCName = "stargz.index.json"
+
+// EStargzTOC is the table of contents of an eStargz layer.
+type estargzTOC struct {
+	Version int             `json:"version"`
+	Entries []*estargzEntry `json:"entries"`
+}
+
+// EStargzEntry is an entry in the table of contents of an eStargz layer.
+//
+// A regular file may be split into chunks, each starting a gzip member at
+// Offset, or starting InnerOffset bytes into the member at Offset. The first
+// chunk is described by the "reg" entry and the rest by "chunk" entries that
+// follow it.
+type estargzEntry struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Size        int64  `json:"size,omitempty"`
+	ModTime     string `json:"modtime,omitempty"`
+	LinkName    string `json:"linkName,omitempty"`
+	Mode        int64  `json:"mode,omitempty"`
+	UID         int    `json:"uid,omitempty"`
+	GID         int    `json:"gid,omitempty"`
+	Uname       string `json:"userName,omitempty"`
+	Gname       string `json:"groupName,omitempty"`
+	DevMajor    int64  `json:"devMajor,omitempty"`
+	DevMinor    int64  `json:"devMinor,omitempty"`
+	Offset      int64  `json:"offset,omitempty"`
+	InnerOffset int64  `json:"innerOffset,omitempty"`
+	ChunkOffset int64  `json:"chunkOffset,omitempty"`
+	ChunkSize   int64  `json:"chunkSize,omitempty"`
+	ChunkDigest string `json:"chunkDigest,omitempty"`
+	Digest      string `json:"digest,omitempty"`
+}
+
+// Fetch returns a function that realizes the layer for the proxy, taking a
+// reference on it. With WithLazyFetch, only the files of interest are fetched
+// if possible; see fetchLazy.
+func (p *FetchProxy) fetch(ctx context.Context, l *claircore.Layer) func() error {
+	full := p.a.fetchOne(ctx, l)
+	return func() (err error) {
+		if pr, ok := indexer.ProgressFrom(ctx); ok {
+			defer func() { pr.LayerFetched(ctx, l.Hash, err) }()
+		}
+		if pats, ok := indexer.FilesOfInterest(ctx); ok && p.a.lazy {
+			name, err := p.a.fetchLazy(ctx, l, pats)
+			switch {
+			case err == nil:
+				p.mu.Lock()
+				p.partial = append(p.partial, name)
+				p.mu.Unlock()
+				p.a.countRequest(sourceLazy)
+				return l.SetLocal(name)
+			case errors.Is(err, errNotLazy):
+				zlog.Debug(ctx).
+					Err(err).
+					Str("layer", l.Hash.String()).
+					Msg("fetching whole layer")
+			default:
+				return err
+			}
+		}
+		if err := full(); err != nil {
+			return err
+		}
+		p.addRef(l.Hash.String())
+		return nil
+	}
+}
+
+// FetchLazy writes a tar containing some of the layer into a new file,
+// returning its name. The tar has every directory, link, and empty file in the
+// layer, and the regular files matching "pats" as described by
+// indexer.FileInterest.
+//
+// This only works for eStargz layers served by a server that supports range
+// requests; errNotLazy is reported otherwise. The contents of files are checked
+// against the digests in the table of contents, but the table itself can't be
+// checked against the layer's digest without reading the whole layer.
+func (a *RemoteFetchArena) fetchLazy(ctx context.Context, l *claircore.Layer, pats []string) (_ string, err error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/fetchArena.fetchLazy",
+		"arena", a.root,
+		"layer", l.Hash.String())
+	if err := a.checkClosing(); err != nil {
+		return "", err
+	}
+	u, _, err := checkLayer(l, nil)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "file" {
+		return "", fmt.Errorf("%w: local layer", errNotLazy)
+	}
+	if a.quota != nil {
+		if err := a.quota.admit(ctx, 0); err != nil {
+			return "", err
+		}
+	}
+	release, err := indexer.AcquireLayerSlot(ctx)
+	if err != nil {
+		return "", fmt.Errorf("fetcher: unable to acquire layer slot: %w", err)
+	}
+	defer release()
+	if a.sem != nil {
+		if err := a.sem.Acquire(ctx, 1); err != nil {
+			return "", fmt.Errorf("fetcher: unable to acquire fetch slot: %w", err)
+		}
+		defer a.sem.Release(1)
+	}
+
+	footer, total, err := a.rangeBytes(ctx, l, u, "bytes=-"+strconv.Itoa(estargzFooterSize), estargzFooterSize)
+	if err != nil {
+		return "", err
+	}
+	tocOff, ok := parseEStargzFooter(footer)
+	if !ok || total < 0 || tocOff >= total || total-tocOff > maxTOCSize {
+		return "", fmt.Errorf("%w: no eStargz footer", errNotLazy)
+	}
+	toc, err := a.fetchTOC(ctx, l, u, tocOff)
+	if err != nil {
+		return "", err
+	}
+
+	ents, wanted := lazyEntries(toc, pats)
+	// The end of a chunk's compressed data is the start of whatever comes
+	// next in the blob.
+	offs := make([]int64, 0, len(toc.Entries)+1)
+	for _, e := range toc.Entries {
+		if e.Offset > 0 {
+			offs = append(offs, e.Offset)
+		}
+	}
+	offs = append(offs, tocOff)
+	sort.Slice(offs, func(i, j int) bool { return offs[i] < offs[j] })
+	next := func(off int64) (int64, bool) {
+		i := sort.Search(len(offs), func(i int) bool { return offs[i] > off })
+		if i == len(offs) {
+			return 0, false
+		}
+		return offs[i], true
+	}
+	chunks := make(map[string][]*estargzEntry)
+	for _, e := range toc.Entries {
+		switch e.Type {
+		case "reg", "chunk":
+			if _, ok := wanted[e.Name]; ok && e.Size+e.ChunkSize > 0 {
+				chunks[e.Name] = append(chunks[e.Name], e)
+			}
+		}
+	}
+
+	dir := a.root
+	if a.spoolDir != "" {
+		dir = a.spoolDir
+	}
+	f, err := os.CreateTemp(dir, "fetch.*")
+	if err != nil {
+		return "", fmt.Errorf("fetcher: unable to create file: %w", err)
+	}
+	name := f.Name()
+	fw, done := a.quotaWriter(name, f)
+	defer done()
+	defer func() {
+		if err != nil {
+			os.Remove(name)
+		}
+	}()
+	defer f.Close()
+	tw := tar.NewWriter(fw)
+	var fetched int
+	for _, e := range ents {
+		h, err := e.header()
+		if err != nil {
+			return "", err
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return "", noSpace(err)
+		}
+		cs := chunks[e.Name]
+		if e.Type != "reg" || len(cs) == 0 {
+			continue
+		}
+		for _, c := range cs {
+			end, ok := next(c.Offset)
+			if !ok {
+				return "", fmt.Errorf("%w: %s: chunk offset %d past table of contents", errNotLazy, e.Name, c.Offset)
+			}
+			if err := a.fetchChunk(ctx, l, u, c, e.Size, end, tw); err != nil {
+				return "", fmt.Errorf("fetcher: %s: %w", e.Name, err)
+			}
+		}
+		fetched++
+	}
+	if err := tw.Close(); err != nil {
+		return "", noSpace(err)
+	}
+	if err := f.Close(); err != nil {
+		return "", noSpace(err)
+	}
+	zlog.Debug(ctx).
+		Int("entries", len(ents)).
+		Int("files", fetched).
+		Msg("fetched layer lazily")
+	return name, nil
+}
+
+// LazyEntries returns the entries to put in a lazily fetched layer, in the
+// order they appear in the table of contents, and the set of regular files
+// among them with contents.
+//
+// Files are matched by every path they can be reached by through symlinked
+// directories, and the targets of matching symlinks and hardlinks are
+// included as well.
+func lazyEntries(toc *estargzTOC, pats []string) ([]*estargzEntry, map[string]struct{}) {
+	byName := make(map[string]*estargzEntry, len(toc.Entries))
+	// Directory symlinks, by cleaned target.
+	dirLinks := make(map[string][]string)
+	for _, e := range toc.Entries {
+		e.Name = cleanName(e.Name)
+		if e.Type == "chunk" || e.Name == "" {
+			continue
+		}
+		byName[e.Name] = e
+	}
+	for n, e := range byName {
+		if e.Type != "symlink" {
+			continue
+		}
+		t := linkTarget(n, e.LinkName)
+		if te, ok := byName[t]; ok && te.Type == "dir" {
+			dirLinks[t] = append(dirLinks[t], n)
+		}
+	}
+	match := func(n string) bool {
+		if indexer.MatchFile(pats, n) {
+			return true
+		}
+		for t, ls := range dirLinks {
+			if !strings.HasPrefix(n, t+"/") {
+				continue
+			}
+			for _, l := range ls {
+				if indexer.MatchFile(pats, l+n[len(t):]) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	wanted := make(map[string]struct{})
+	var want func(n string, depth int)
+	want = func(n string, depth int) {
+		e, ok := byName[n]
+		if !ok || depth > 8 {
+			return
+		}
+		switch e.Type {
+		case "reg":
+			wanted[n] = struct{}{}
+		case "symlink":
+			want(linkTarget(n, e.LinkName), depth+1)
+		case "hardlink":
+			want(cleanName(e.LinkName), depth+1)
+		}
+	}
+	for n, e := range byName {
+		switch e.Type {
+		case "reg", "symlink", "hardlink":
+			if match(n) {
+				want(n, 0)
+			}
+		}
+	}
+
+	var ents []*estargzEntry
+	for _, e := range toc.Entries {
+		if e.Name == "" {
+			continue
+		}
+		switch e.Type {
+		case "chunk":
+			continue
+		case "reg":
+			if _, ok := wanted[e.Name]; !ok && e.Size != 0 {
+				continue
+			}
+		case "hardlink":
+			if _, ok := wanted[cleanName(e.LinkName)]; !ok {
+				continue
+			}
+		}
+		ents = append(ents, e)
+	}
+	return ents, wanted
+}
+
+// CleanName normalizes a name from a table of contents to be relative to the
+// root, without a trailing slash.
+func cleanName(n string) string {
+	return strings.TrimPrefix(path.Clean("/"+n), "/")
+}
+
+// LinkTarget resolves a symlink's target relative to the root.
+func linkTarget(name, target string) string {
+	if path.IsAbs(target) {
+		return cleanName(target)
+	}
+	return cleanName(path.Join(path.Dir(name), target))
+}
+
+// Header returns the tar header for the entry.
+func (e *estargzEntry) header() (*tar.Header, error) {
+	h := &tar.Header{
+		Name:     e.Name,
+		Mode:     e.Mode,
+		Uid:      e.UID,
+		Gid:      e.GID,
+		Uname:    e.Uname,
+		Gname:    e.Gname,
+		Linkname: e.LinkName,
+		Devmajor: e.DevMajor,
+		Devminor: e.DevMinor,
+	}
+	if e.ModTime != "" {
+		if t, err := time.Parse(time.RFC3339, e.ModTime); err == nil {
+			h.ModTime = t
+		}
+	}
+	switch e.Type {
+	case "dir":
+		h.Typeflag = tar.TypeDir
+		h.Name += "/"
+	case "reg":
+		h.Typeflag = tar.TypeReg
+		h.Size = e.Size
+	case "symlink":
+		h.Typeflag = tar.TypeSymlink
+	case "hardlink":
+		h.Typeflag = tar.TypeLink
+		h.Linkname = cleanName(e.LinkName)
+	case "char":
+		h.Typeflag = tar.TypeChar
+	case "block":
+		h.Typeflag = tar.TypeBlock
+	case "fifo":
+		h.Typeflag = tar.TypeFifo
+	default:
+		return nil, fmt.Errorf("fetcher: %q: unknown entry type %q", e.Name, e.Type)
+	}
+	return h, nil
+}
+
+// ParseEStargzFooter returns the offset of the table of contents from the end
+// of an eStargz or stargz layer.
+func parseEStargzFooter(b []byte) (int64, bool) {
+	i := bytes.LastIndex(b, cmpHeaders[cmpGzip])
+	if i == -1 {
+		return 0, false
+	}
+	z, err := gzip.NewReader(bytes.NewReader(b[i:]))
+	if err != nil {
+		return 0, false
+	}
+	defer z.Close()
+	ex := z.Header.Extra
+	switch {
+	case len(ex) == 26 && bytes.HasPrefix(ex, []byte{'S', 'G', 22, 0}):
+		ex = ex[4:]
+	case len(ex) == 22:
+	default:
+		return 0, false
+	}
+	if !bytes.HasSuffix(ex, estargzMagic) {
+		return 0, false
+	}
+	off, err := strconv.ParseInt(string(ex[:16]), 16, 64)
+	if err != nil || off < 0 {
+		return 0, false
+	}
+	return off, true
+}
+
+// FetchTOC fetches and decodes the table of contents starting at "off".
+func (a *RemoteFetchArena) fetchTOC(ctx context.Context, l *claircore.Layer, u *url.URL, off int64) (*estargzTOC, error) {
+	b, _, err := a.rangeBytes(ctx, l, u, fmt.Sprintf("bytes=%d-", off), maxTOCSize)
+	if err != nil {
+		return nil, err
+	}
+	z, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad table of contents: %v", errNotLazy, err)
+	}
+	z.Multistream(false)
+	tr := tar.NewReader(io.LimitReader(z, maxTOCSize))
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad table of contents: %v", errNotLazy, err)
+		}
+		if h.Name != estargzTOCName {
+			continue
+		}
+		var toc estargzTOC
+		if err := json.NewDecoder(tr).Decode(&toc); err != nil {
+			return nil, fmt.Errorf("%w: bad table of contents: %v", errNotLazy, err)
+		}
+		return &toc, nil
+	}
+}
+
+// FetchChunk writes the chunk of a file of size "size" to "w", checking its
+// digest. The chunk's compressed data ends before "end".
+func (a *RemoteFetchArena) fetchChunk(ctx context.Context, l *claircore.Layer, u *url.URL, c *estargzEntry, size, end int64, w io.Writer) error {
+	n := c.ChunkSize
+	if n == 0 {
+		n = size - c.ChunkOffset
+	}
+	body, _, err := a.rangeGet(ctx, l, u, fmt.Sprintf("bytes=%d-%d", c.Offset, end-1))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	z, err := gzip.NewReader(io.LimitReader(body, end-c.Offset))
+	if err != nil {
+		return &errDecompress{c: cmpGzip, err: err}
+	}
+	defer z.Close()
+	if _, err := io.CopyN(io.Discard, z, c.InnerOffset); err != nil {
+		return &errDecompress{c: cmpGzip, err: err}
+	}
+	want := c.ChunkDigest
+	if want == "" && c.Type == "reg" && n == size {
+		want = c.Digest
+	}
+	if want == "" {
+		_, err := io.CopyN(w, z, n)
+		if err != nil {
+			return &errDecompress{c: cmpGzip, err: err}
+		}
+		return nil
+	}
+	wd, err := claircore.ParseDigest(want)
+	if err != nil {
+		return fmt.Errorf("fetcher: bad digest in table of contents: %w", err)
+	}
+	h := wd.Hash()
+	if _, err := io.CopyN(io.MultiWriter(w, h), z, n); err != nil {
+		return &errDecompress{c: cmpGzip, err: err}
+	}
+	got, err := claircore.NewDigest(wd.Algorithm(), h.Sum(nil))
+	if err != nil {
+		return err
+	}
+	if got.String() != wd.String() {
+		return &errDigestMismatch{got: []string{got.String()}, want: []string{want}}
+	}
+	return nil
+}
+
+// RangeGet requests a range of the layer, returning the response body and the
+// layer's total size if the server reported it, or -1.
+//
+// ErrNotLazy is reported if the server doesn't honor the range.
+func (a *RemoteFetchArena) rangeGet(ctx context.Context, l *claircore.Layer, u *url.URL, rng string) (io.ReadCloser, int64, error) {
+	c, req, err := a.newRequest(l, u)
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header = req.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set("Range", rng)
+	req = req.WithContext(ctx)
+	resp, err := a.doRetry(ctx, c, req)
+	if err != nil {
+		return nil, -1, &errRequest{what: "request", inner: err}
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK, http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return nil, -1, fmt.Errorf("%w: range not supported (%s)", errNotLazy, resp.Status)
+	default:
+		defer resp.Body.Close()
+		return nil, -1, newStatusError(resp)
+	}
+	total := int64(-1)
+	cr := resp.Header.Get("Content-Range")
+	if i := strings.LastIndexByte(cr, '/'); i != -1 {
+		if n, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+			total = n
+		}
+	}
+	body := &meteredBody{ReadCloser: bodyReader{resp.Body}, c: fetchedBytes.WithLabelValues(a.root)}
+	return a.throttled(ctx, resp, body), total, nil
+}
+
+// RangeBytes is rangeGet, reading the whole range into memory. Ranges longer
+// than "max" bytes are reported as errNotLazy.
+func (a *RemoteFetchArena) rangeBytes(ctx context.Context, l *claircore.Layer, u *url.URL, rng string, max int64) ([]byte, int64, error) {
+	body, total, err := a.rangeGet(ctx, l, u, rng)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer body.Close()
+	b, err := io.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, -1, err
+	}
+	if int64(len(b)) > max {
+		return nil, -1, fmt.Errorf("%w: range %q too large", errNotLazy, rng)
+	}
+	return b, total, nil
+}
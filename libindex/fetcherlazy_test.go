@@ -0,0 +1,394 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
+	indexer "github.com/quay/claircore/test/mock/indexer"
+)
+
+// LazyFile is a file to put in a test eStargz layer.
+type lazyFile struct {
+	h    *tar.Header
+	body string
+}
+
+// SwitchWriter lets the gzip member a tar.Writer writes to be swapped out.
+type switchWriter struct{ w io.Writer }
+
+func (s *switchWriter) Write(b []byte) (int, error) { return s.w.Write(b) }
+
+// Estargz builds an eStargz layer from "fs", splitting file contents into
+// chunks of at most "chunk" bytes. If "tamper" is not nil, it's called on the
+// table of contents before it's written.
+//
+// Like the real thing, every chunk starts a new gzip member, so the whole
+// blob decompresses to a tar with the table of contents as its last file.
+func estargz(t testing.TB, chunk int, fs []lazyFile, tamper func(*estargzTOC)) []byte {
+	t.Helper()
+	var blob bytes.Buffer
+	zw := gzip.NewWriter(&blob)
+	sw := &switchWriter{zw}
+	tw := tar.NewWriter(sw)
+	member := func() int64 {
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		off := int64(blob.Len())
+		zw = gzip.NewWriter(&blob)
+		sw.w = zw
+		return off
+	}
+	digest := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	toc := estargzTOC{Version: 1}
+	for _, f := range fs {
+		h := f.h
+		h.Size = int64(len(f.body))
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		e := &estargzEntry{
+			Name:     h.Name,
+			Size:     h.Size,
+			LinkName: h.Linkname,
+			Mode:     h.Mode,
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			e.Type = "dir"
+		case tar.TypeReg:
+			e.Type = "reg"
+		case tar.TypeSymlink:
+			e.Type = "symlink"
+		case tar.TypeLink:
+			e.Type = "hardlink"
+		}
+		toc.Entries = append(toc.Entries, e)
+		if e.Type != "reg" || h.Size == 0 {
+			continue
+		}
+		e.Digest = digest(f.body)
+		for off := 0; off < len(f.body); off += chunk {
+			end := off + chunk
+			if end > len(f.body) {
+				end = len(f.body)
+			}
+			c := e
+			if off != 0 {
+				c = &estargzEntry{Name: h.Name, Type: "chunk", ChunkOffset: int64(off)}
+				toc.Entries = append(toc.Entries, c)
+			}
+			c.Offset = member()
+			if len(f.body) > chunk {
+				c.ChunkSize = int64(end - off)
+			}
+			c.ChunkDigest = digest(f.body[off:end])
+			if _, err := tw.Write([]byte(f.body[off:end])); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		tamper(&toc)
+	}
+	js, err := json.Marshal(&toc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tocOff := member()
+	tw = tar.NewWriter(zw)
+	if err := tw.WriteHeader(&tar.Header{Name: estargzTOCName, Size: int64(len(js)), Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(js)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fw := gzip.NewWriter(&blob)
+	fw.Header.Extra = append([]byte{'S', 'G', 22, 0}, fmt.Sprintf("%016xSTARGZ", tocOff)...)
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return blob.Bytes()
+}
+
+// ReadLayer returns the contents of every file in the layer by name, with
+// symlinks reported as "-> target".
+func readLayer(t testing.TB, l *claircore.Layer) map[string]string {
+	t.Helper()
+	rc, err := l.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got := make(map[string]string)
+	tr := tar.NewReader(rc)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch h.Typeflag {
+		case tar.TypeSymlink, tar.TypeLink:
+			got[h.Name] = "-> " + h.Linkname
+		default:
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[h.Name] = string(b)
+		}
+	}
+	return got
+}
+
+func TestFetchLazy(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	// Big is random, so it doesn't compress and skipping it is measurable.
+	big := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(big)
+	status := strings.Repeat("Package: test\nStatus: install ok installed\n\n", 64)
+	dir := func(n string) lazyFile {
+		return lazyFile{h: &tar.Header{Typeflag: tar.TypeDir, Name: n, Mode: 0o755}}
+	}
+	fs := []lazyFile{
+		dir("etc/"),
+		{h: &tar.Header{Typeflag: tar.TypeSymlink, Name: "etc/os-release", Linkname: "../usr/lib/os-release"}},
+		dir("usr/"),
+		dir("usr/bin/"),
+		{h: &tar.Header{Typeflag: tar.TypeReg, Name: "usr/bin/big", Mode: 0o755}, body: string(big)},
+		{h: &tar.Header{Typeflag: tar.TypeLink, Name: "usr/bin/big2", Linkname: "usr/bin/big"}},
+		dir("usr/lib/"),
+		{h: &tar.Header{Typeflag: tar.TypeReg, Name: "usr/lib/os-release", Mode: 0o644}, body: "ID=test\n"},
+		dir("usr/share/"),
+		{h: &tar.Header{Typeflag: tar.TypeReg, Name: "usr/share/.wh.gone", Mode: 0o644}},
+		dir("var/"),
+		dir("var/lib/"),
+		dir("var/lib/dpkg/"),
+		{h: &tar.Header{Typeflag: tar.TypeReg, Name: "var/lib/dpkg/status", Mode: 0o644}, body: status},
+	}
+	blob := estargz(t, 1024, fs, nil)
+	pats := []string{"etc/os-release", "status"}
+
+	t.Run("Partial", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		var served int64
+		c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", blob, withRanges(), countBytes(&served))
+		root := t.TempDir()
+		a := NewRemoteFetchArena(c, root, WithLazyFetch())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		if got := ccindexer.RealizerCapabilities(f); !got.Has(ccindexer.CapPartial) {
+			t.Errorf("capabilities: got: %v, want: %v", got, ccindexer.CapPartial)
+		}
+		if err := f.Realize(ccindexer.WithFilesOfInterest(ctx, pats), []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		got := readLayer(t, l)
+		want := map[string]string{
+			"etc/":                "",
+			"etc/os-release":      "-> ../usr/lib/os-release",
+			"usr/":                "",
+			"usr/bin/":            "",
+			"usr/lib/":            "",
+			"usr/lib/os-release":  "ID=test\n",
+			"usr/share/":          "",
+			"usr/share/.wh.gone":  "",
+			"var/":                "",
+			"var/lib/":            "",
+			"var/lib/dpkg/":       "",
+			"var/lib/dpkg/status": status,
+		}
+		if len(got) != len(want) {
+			t.Errorf("entries: got: %d, want: %d", len(got), len(want))
+		}
+		for n, w := range want {
+			g, ok := got[n]
+			switch {
+			case !ok:
+				t.Errorf("%s: missing", n)
+			case g != w:
+				t.Errorf("%s: got: %q, want: %q", n, g, w)
+			}
+		}
+		n := atomic.LoadInt64(&served)
+		t.Logf("served %d of %d bytes", n, len(blob))
+		if n >= int64(len(big)) {
+			t.Errorf("served %d bytes, more than the skipped file", n)
+		}
+
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+		ents, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range ents {
+			if strings.HasPrefix(e.Name(), "fetch.") {
+				t.Errorf("partial layer left behind: %s", e.Name())
+			}
+		}
+	})
+
+	t.Run("DigestMismatch", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		bad := estargz(t, 1024, fs, func(toc *estargzTOC) {
+			for _, e := range toc.Entries {
+				if e.Name == "usr/lib/os-release" {
+					e.ChunkDigest = "sha256:" + strings.Repeat("0", 64)
+				}
+			}
+		})
+		c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", bad, withRanges())
+		a := NewRemoteFetchArena(c, t.TempDir(), WithLazyFetch())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ccindexer.WithFilesOfInterest(ctx, pats), []*claircore.Layer{l})
+		t.Log(err)
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrDigestMismatch)
+		}
+	})
+
+	// In all of these, the whole layer is fetched.
+	plain := tarball(t, "plain")
+	var plainGz bytes.Buffer
+	zw := gzip.NewWriter(&plainGz)
+	zw.Write(plain)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name                string
+		blob                []byte
+		ranged, lazy, files bool
+		want                string
+	}{
+		{name: "NoRange", blob: blob, lazy: true, files: true, want: "usr/bin/big"},
+		{name: "NotEStargz", blob: plainGz.Bytes(), ranged: true, lazy: true, files: true, want: "file"},
+		{name: "NoFiles", blob: blob, ranged: true, lazy: true, want: "usr/bin/big"},
+		{name: "Disabled", blob: blob, ranged: true, files: true, want: "usr/bin/big"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			var sopts []serveOption
+			if tc.ranged {
+				sopts = append(sopts, withRanges())
+			}
+			c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", tc.blob, sopts...)
+			var opts []ArenaOption
+			if tc.lazy {
+				opts = append(opts, WithLazyFetch())
+			}
+			a := NewRemoteFetchArena(c, t.TempDir(), opts...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			rctx := ctx
+			if tc.files {
+				rctx = ccindexer.WithFilesOfInterest(ctx, pats)
+			}
+			if err := f.Realize(rctx, []*claircore.Layer{l}); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := readLayer(t, l)[tc.want]; !ok {
+				t.Errorf("%s: missing from whole layer", tc.want)
+			}
+		})
+	}
+
+	t.Run("New", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		eco := func(ps ...ccindexer.PackageScanner) *ccindexer.Ecosystem {
+			return &ccindexer.Ecosystem{
+				Name:                 "lazy-test",
+				PackageScanners:      func(context.Context) ([]ccindexer.PackageScanner, error) { return ps, nil },
+				DistributionScanners: func(context.Context) ([]ccindexer.DistributionScanner, error) { return nil, nil },
+				RepositoryScanners:   func(context.Context) ([]ccindexer.RepositoryScanner, error) { return nil, nil },
+			}
+		}
+		lazy := &lazyTestScanner{filterTestScanner{pkgs: []string{"bash"}}}
+		rules := PackageRules{Allow: []PackageRule{{Name: "bash"}}}
+		for _, tc := range []struct {
+			name string
+			eco  *ccindexer.Ecosystem
+			opts func(*Options)
+			want []string
+		}{
+			{name: "Declared", eco: eco(lazy), want: []string{"status"}},
+			{
+				name: "Filtered",
+				eco:  eco(lazy),
+				opts: func(o *Options) { o.PackageFilter, o.PackageFilterID = rules.Filter, rules.ID() },
+				want: []string{"status"},
+			},
+			{name: "Undeclared", eco: eco(lazy, &filterTestScanner{})},
+			{
+				name: "Verify",
+				eco:  eco(lazy),
+				opts: func(o *Options) { o.VerifyPackages = []string{"lazy-test"} },
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				ctx := zlog.Test(ctx, t)
+				store := indexer.NewMockStore(gomock.NewController(t))
+				store.EXPECT().RegisterScanners(gomock.Any(), gomock.Any()).Return(nil)
+				opts := &Options{
+					Store:      store,
+					Locker:     testLocker{},
+					FetchArena: NewRemoteFetchArena(nil, t.TempDir()),
+					Ecosystems: []*ccindexer.Ecosystem{tc.eco},
+				}
+				if tc.opts != nil {
+					tc.opts(opts)
+				}
+				l, err := New(ctx, opts, http.DefaultClient)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := strings.Join(l.files, ","), strings.Join(tc.want, ","); got != want {
+					t.Errorf("files: got: %q, want: %q", got, want)
+				}
+			})
+		}
+	})
+}
+
+// LazyTestScanner is a package scanner that only reads dpkg databases.
+type lazyTestScanner struct{ filterTestScanner }
+
+func (*lazyTestScanner) Name() string              { return "lazy-test" }
+func (*lazyTestScanner) FilesOfInterest() []string { return []string{"status"} }
@@ -0,0 +1,127 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// Layers may be read from local files by using a "file" URI, when the arena
+// is configured with WithLocalLayers:
+//
+//	file:///images/ubuntu/3c0b1d8a.tar.gz
+//	file://localhost/images/ubuntu/3c0b1d8a.tar.gz
+//
+// The path is looked up in the configured fs.FS, relative to its root. No
+// HTTP request is made: the Layer's Headers are ignored, and the file is read
+// as-is. Its compression is worked out the same way as for a response with no
+// content-type, and it's checked against the Layer's digest and size just the
+// same.
+//
+// Local layers are never fetched lazily. An fs.FS supplied for a single call
+// with WithLocalFS is used in place of the configured one.
+
+// ErrNoLocalLayers is reported for file URIs when the arena isn't configured
+// to read local layers.
+var errNoLocalLayers = errors.New("fetcher: local layers not enabled")
+
+// LocalFSKey is the Context key for an fs.FS to read local layers from.
+type localFSKey struct{}
+
+// WithLocalFS returns a Context that has a RemoteFetchArena read layers with
+// "file" URIs from "fsys", in place of the fs.FS it was configured with by
+// WithLocalLayers, if any. Use it with Index to index the layers of an image
+// from a particular place, as IndexLocal does.
+//
+// This has the same concerns as WithLocalLayers: don't use it to index
+// manifests from untrusted sources.
+func WithLocalFS(ctx context.Context, fsys fs.FS) context.Context {
+	return context.WithValue(ctx, localFSKey{}, fsys)
+}
+
+// LocalFSFrom returns the fs.FS to read local layers from, or nil if there isn't
+// one.
+func (a *RemoteFetchArena) localFSFrom(ctx context.Context) fs.FS {
+	if fsys, ok := ctx.Value(localFSKey{}).(fs.FS); ok {
+		return fsys
+	}
+	return a.localFS
+}
+
+// LocalName returns the name in the arena's fs.FS of the file a "file" URI
+// refers to.
+func localName(u *url.URL) (string, error) {
+	switch {
+	case u.Opaque != "":
+		return "", fmt.Errorf("fetcher: relative file uri: %q", u.Redacted())
+	case u.Host != "" && u.Host != "localhost":
+		return "", fmt.Errorf("fetcher: file uri with remote host: %q", u.Redacted())
+	}
+	name := strings.TrimPrefix(path.Clean(u.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("fetcher: invalid file uri path: %q", u.Path)
+	}
+	return name, nil
+}
+
+// OpenLocal opens the layer's local file and works out how it's compressed,
+// like open. Everything read from the file is copied to "hw".
+//
+// The caller must call Close on the returned layerStream.
+func (a *RemoteFetchArena) openLocal(ctx context.Context, l *claircore.Layer, u *url.URL, hw io.Writer) (*layerStream, error) {
+	fsys := a.localFSFrom(ctx)
+	if fsys == nil {
+		return nil, fmt.Errorf("%w: %q", errNoLocalLayers, u.Redacted())
+	}
+	name, err := localName(u)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, &errLocal{name: name, inner: err}
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			f.Close()
+		}
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, &errLocal{name: name, inner: err}
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &errLocal{name: name, inner: fmt.Errorf("not a regular file: %v", fi.Mode().Type())}
+	}
+	if l.Size > 0 && fi.Size() != l.Size {
+		return nil, &errSizeMismatch{got: fi.Size(), want: l.Size}
+	}
+	zlog.Debug(ctx).
+		Str("file", name).
+		Int64("size", fi.Size()).
+		Msg("reading local layer")
+	st := layerStream{
+		body:          f,
+		contentLength: fi.Size(),
+		read:          &countWriter{w: hw},
+		decoders:      a.decoders,
+	}
+	if err := a.sniff(ctx, l, &st, f, ""); err != nil {
+		return nil, err
+	}
+	ok = true
+	return &st, nil
+}
@@ -0,0 +1,84 @@
+package libindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchLocal(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	layer := gzipTarball(t, "read from a file")
+	sum := sha256.Sum256(layer)
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"images/layer.tar.gz": &fstest.MapFile{Data: layer},
+		"images/dir":          &fstest.MapFile{Mode: os.ModeDir},
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "layer.tar.gz"), layer, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bad := claircore.MustParseDigest("sha256:" + strings.Repeat("a", 64))
+
+	tt := []struct {
+		name string
+		fsys fs.FS
+		uri  string
+		hash claircore.Digest
+		// Err is whether the fetch should fail, and "is", if not nil, the
+		// error it should satisfy.
+		err bool
+		is  error
+	}{
+		{name: "MapFS", fsys: fsys, uri: "file:///images/layer.tar.gz", hash: d},
+		{name: "Localhost", fsys: fsys, uri: "file://localhost/images/layer.tar.gz", hash: d},
+		{name: "DirFS", fsys: os.DirFS(dir), uri: "file:///layer.tar.gz", hash: d},
+		{name: "Digest", fsys: fsys, uri: "file:///images/layer.tar.gz", hash: bad, err: true, is: ErrDigestMismatch},
+		{name: "Missing", fsys: fsys, uri: "file:///images/missing.tar.gz", hash: d, err: true, is: ErrNotFound},
+		{name: "Directory", fsys: fsys, uri: "file:///images/dir", hash: d, err: true},
+		{name: "RemoteHost", fsys: fsys, uri: "file://example.com/images/layer.tar.gz", hash: d, err: true},
+		{name: "Disabled", uri: "file:///images/layer.tar.gz", hash: d, err: true, is: errNoLocalLayers},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			var opts []ArenaOption
+			if tc.fsys != nil {
+				opts = append(opts, WithLocalLayers(tc.fsys))
+			}
+			a := NewRemoteFetchArena(nil, t.TempDir(), opts...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			l := &claircore.Layer{Hash: tc.hash, URI: tc.uri}
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			t.Log(err)
+			switch {
+			case !tc.err && err != nil:
+				t.Fatal(err)
+			case !tc.err:
+				if got, want := readLayer(t, l)["file"], "read from a file"; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+			case err == nil:
+				t.Fatal("expected error")
+			case tc.is != nil && !errors.Is(err, tc.is):
+				t.Errorf("got: %v, want: %v", err, tc.is)
+			}
+		})
+	}
+}
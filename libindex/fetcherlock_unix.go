@@ -0,0 +1,126 @@
+//go:build !windows
+// +build !windows
+
+package libindex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// SharedRootSupported reports whether layer files can be shared with other
+// processes. See WithSharedRoot.
+const sharedRootSupported = true
+
+// LockRoot opens the arena root and takes a shared flock(2) on it. Every
+// arena holds this for its lifetime, so that Clean can tell if another arena
+// is using the same root.
+func lockRoot(root string) (*os.File, error) {
+	f, err := os.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// WithExclusiveRoot calls "f" if the lock on the root can be upgraded to an
+// exclusive one without waiting, reporting whether it was called. The shared
+// lock is held again on return.
+func withExclusiveRoot(lf *os.File, f func() error) (bool, error) {
+	fd := int(lf.Fd())
+	// Converting a flock isn't atomic, so the shared lock may have been lost
+	// even if this fails. Always re-take it.
+	defer syscall.Flock(fd, syscall.LOCK_SH)
+	if err := syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return false, nil
+	}
+	return true, f()
+}
+
+// UnlockRoot releases the lock taken by lockRoot.
+func unlockRoot(lf *os.File) error {
+	syscall.Flock(int(lf.Fd()), syscall.LOCK_UN)
+	return lf.Close()
+}
+
+// LockLayer opens the layer file "name" and takes a shared flock(2) on it,
+// which is held for as long as the layer is in use. An error satisfying
+// os.ErrNotExist is returned if the file was removed, including while waiting
+// for the lock.
+func lockLayer(name string) (*os.File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// The process that last released the layer removes it with an exclusive
+	// lock held, so by the time the lock is granted the name may be gone or
+	// refer to a newer file.
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	ni, err := os.Stat(name)
+	if err != nil || !os.SameFile(fi, ni) {
+		f.Close()
+		return nil, fmt.Errorf("fetcher: layer file %q removed: %w", name, os.ErrNotExist)
+	}
+	return f, nil
+}
+
+// PublishLayer makes the freshly fetched file "ff" available as "tgt", the
+// name other processes look for it under, and returns it locked as by
+// lockLayer. If another process already published the layer, that file is
+// used and "ff" removed.
+func publishLayer(ff, tgt string) (*os.File, error) {
+	if ff == tgt {
+		return lockLayer(tgt)
+	}
+	// Lock before linking, so the file can't be removed out from under us
+	// once it's visible.
+	f, err := os.Open(ff)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
+		f.Close()
+		return nil, err
+	}
+	err = os.Link(ff, tgt)
+	os.Remove(ff)
+	switch {
+	case err == nil:
+		return f, nil
+	case errors.Is(err, os.ErrExist):
+		f.Close()
+		return lockLayer(tgt)
+	default:
+		f.Close()
+		return nil, err
+	}
+}
+
+// ReleaseLayer gives up the lock taken on the layer file "name", removing the
+// file if no other process holds a lock on it.
+func releaseLayer(lf *os.File, name string) error {
+	defer lf.Close()
+	if err := syscall.Flock(int(lf.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		// Still in use elsewhere.
+		return nil
+	}
+	err := os.Remove(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package libindex
+
+import (
+	"errors"
+	"os"
+)
+
+// The windows implementation of the arena root locking is non-functional, so
+// Clean can't tell if another arena is using the same root. Don't share an
+// arena root between processes on windows if using Options.CleanOnInit.
+//
+// Layer files can't be shared either, so WithSharedRoot has no effect.
+
+const sharedRootSupported = false
+
+var errSharedRoot = errors.New("fetcher: shared roots not supported")
+
+func lockRoot(_ string) (*os.File, error) { return nil, nil }
+
+func withExclusiveRoot(_ *os.File, f func() error) (bool, error) { return true, f() }
+
+func unlockRoot(_ *os.File) error { return nil }
+
+func lockLayer(_ string) (*os.File, error) { return nil, errSharedRoot }
+
+func publishLayer(_, _ string) (*os.File, error) { return nil, errSharedRoot }
+
+func releaseLayer(lf *os.File, _ string) error { return lf.Close() }
@@ -0,0 +1,217 @@
+package libindex
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	fetchWait = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "fetch_wait_seconds",
+			Help:      "Time fetches spent waiting for disk space and fetch slots before sending a request.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 9),
+		},
+		[]string{"arena"},
+	)
+	fetchDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "fetch_duration_seconds",
+			Help:      "Duration of layer fetches, from sending the request to the layer being written out.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+		},
+		[]string{"arena", "outcome"},
+	)
+	fetchedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "fetched_bytes_total",
+			Help:      "Bytes of layers read off the network, including fetches that failed.",
+		},
+		[]string{"arena"},
+	)
+	decompressionRatio = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "decompression_ratio",
+			Help:      "Ratio of the decompressed size of fetched layers to their compressed size.",
+			Buckets:   prometheus.ExponentialBuckets(1, 1.5, 10),
+		},
+		[]string{"arena"},
+	)
+	layerRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "layer_requests_total",
+			Help:      "Layers handed out by the arena, by where they came from.",
+		},
+		[]string{"arena", "source"},
+	)
+	layersHeld = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "layers_in_use",
+			Help:      "Number of layers in the arena with references held on them.",
+		},
+		[]string{"arena"},
+	)
+	layerRefs = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "layer_references",
+			Help:      "Number of references held on layers in the arena.",
+		},
+		[]string{"arena"},
+	)
+)
+
+// Values of the "source" label of layerRequests.
+const (
+	// SourceFetch is a layer fetched for the request.
+	sourceFetch = "fetch"
+	// SourceShared is a layer fetched for a concurrent request for the same
+	// layer.
+	sourceShared = "shared"
+	// SourceRetained is a layer retained by the arena after its last
+	// reference was released.
+	sourceRetained = "retained"
+	// SourceCache is a layer taken from the layer store.
+	sourceCache = "cache"
+	// SourceLazy is a layer partially fetched by WithLazyFetch.
+	sourceLazy = "lazy"
+	// SourcePeer is a layer file already in a shared root. See
+	// WithSharedRoot.
+	sourcePeer = "peer"
+)
+
+// Outcomes are the values of the "outcome" label of fetchDuration.
+var outcomes = []string{"ok", "error"}
+
+// CountRequest records a layer handed out from "source".
+func (a *RemoteFetchArena) countRequest(source string) {
+	layerRequests.WithLabelValues(a.root, source).Inc()
+}
+
+// ObserveFetch records a finished fetch. "Start" is when the fetch started,
+// "sent" when the request was sent, or the zero Time if it never was, and "n"
+// the size of the layer written out.
+func (a *RemoteFetchArena) observeFetch(start, sent time.Time, st *layerStream, n int64, err error) {
+	if sent.IsZero() {
+		return
+	}
+	fetchWait.WithLabelValues(a.root).Observe(sent.Sub(start).Seconds())
+	outcome := outcomes[0]
+	if err != nil {
+		outcome = outcomes[1]
+	}
+	fetchDuration.WithLabelValues(a.root, outcome).Observe(time.Since(sent).Seconds())
+	if st == nil {
+		return
+	}
+	read := st.read.n
+	fetchedBytes.WithLabelValues(a.root).Add(float64(read))
+	if err == nil && read > 0 && st.c != cmpNone {
+		decompressionRatio.WithLabelValues(a.root).Observe(float64(n) / float64(read))
+	}
+}
+
+// RefsChanged updates the reference gauges.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) refsChanged() {
+	var n int
+	for _, ct := range a.rc {
+		n += ct
+	}
+	layersHeld.WithLabelValues(a.root).Set(float64(len(a.rc)))
+	layerRefs.WithLabelValues(a.root).Set(float64(n))
+}
+
+// ReportUsage updates the arena's disk usage gauge. Arenas with a disk quota
+// keep it current as they write, so this only lists the directories of arenas
+// without one.
+func (a *RemoteFetchArena) reportUsage() {
+	if a.quota != nil {
+		return
+	}
+	quotaUsage.WithLabelValues(a.root).Set(float64(dirUsage(a.dirs(), nil)))
+}
+
+// Dirs returns the directories the arena writes layers into.
+func (a *RemoteFetchArena) dirs() []string {
+	dirs := []string{a.root}
+	if a.spoolDir != "" && a.spoolDir != a.root {
+		dirs = append(dirs, a.spoolDir)
+	}
+	return dirs
+}
+
+// DirUsage returns the total size of the regular files in "dirs", other than
+// the ones in "skip".
+func dirUsage(dirs []string, skip map[string]int64) int64 {
+	var n int64
+	for _, d := range dirs {
+		ents, err := os.ReadDir(d)
+		if err != nil {
+			continue
+		}
+		for _, e := range ents {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			if _, ok := skip[filepath.Join(d, e.Name())]; ok {
+				continue
+			}
+			fi, err := e.Info()
+			if err != nil {
+				// Removed in the meantime.
+				continue
+			}
+			n += fi.Size()
+		}
+	}
+	return n
+}
+
+// CloseMetrics removes the arena's metrics.
+func (a *RemoteFetchArena) closeMetrics() {
+	fetchWait.DeleteLabelValues(a.root)
+	for _, o := range outcomes {
+		fetchDuration.DeleteLabelValues(a.root, o)
+	}
+	fetchedBytes.DeleteLabelValues(a.root)
+	decompressionRatio.DeleteLabelValues(a.root)
+	for _, s := range []string{sourceFetch, sourceShared, sourceRetained, sourceCache, sourceLazy, sourcePeer} {
+		layerRequests.DeleteLabelValues(a.root, s)
+	}
+	layersHeld.DeleteLabelValues(a.root)
+	layerRefs.DeleteLabelValues(a.root)
+	quotaUsage.DeleteLabelValues(a.root)
+}
+
+// MeteredBody counts the bytes read from a response body as fetched.
+type meteredBody struct {
+	io.ReadCloser
+	c prometheus.Counter
+}
+
+// Read implements io.Reader.
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.c.Add(float64(n))
+	return n, err
+}
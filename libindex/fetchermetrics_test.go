@@ -0,0 +1,102 @@
+package libindex
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// SampleCount returns the number of observations in the histogram.
+func sampleCount(t testing.TB, o prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestFetchMetrics(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	b := gzipTarball(t, strings.Repeat("metrics\n", 4096))
+	cl, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", b)
+	cache := t.TempDir()
+	root := t.TempDir()
+	a := NewRemoteFetchArena(cl, root, WithRetainIdle(), WithLayerCache(cache, 0))
+	realize := func() *FetchProxy {
+		t.Helper()
+		f := a.Realizer(ctx).(*FetchProxy)
+		if err := f.Realize(ctx, fresh([]*claircore.Layer{l})); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	requests := func(src string) float64 {
+		return testutil.ToFloat64(layerRequests.WithLabelValues(root, src))
+	}
+
+	// The second request for the layer, while it's in use, is served from
+	// the layer cache.
+	f := realize()
+	g := realize()
+	if got, want := requests(sourceFetch), 1.0; got != want {
+		t.Errorf("fetched: got: %v, want: %v", got, want)
+	}
+	if got, want := requests(sourceCache), 1.0; got != want {
+		t.Errorf("cache: got: %v, want: %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(layersHeld.WithLabelValues(root)), 1.0; got != want {
+		t.Errorf("layers in use: got: %v, want: %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(layerRefs.WithLabelValues(root)), 2.0; got != want {
+		t.Errorf("references: got: %v, want: %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(fetchedBytes.WithLabelValues(root)), float64(len(b)); got != want {
+		t.Errorf("fetched bytes: got: %v, want: %v", got, want)
+	}
+	if got, want := sampleCount(t, fetchDuration.WithLabelValues(root, "ok")), uint64(1); got != want {
+		t.Errorf("fetch durations: got: %v, want: %v", got, want)
+	}
+	if got, want := sampleCount(t, decompressionRatio.WithLabelValues(root)), uint64(1); got != want {
+		t.Errorf("decompression ratios: got: %v, want: %v", got, want)
+	}
+	if got := testutil.ToFloat64(quotaUsage.WithLabelValues(root)); got == 0 {
+		t.Error("disk usage not reported")
+	}
+	f.Close()
+	g.Close()
+	if got := testutil.ToFloat64(layerRefs.WithLabelValues(root)); got != 0 {
+		t.Errorf("references: got: %v, want: 0", got)
+	}
+
+	// The layer was retained, so it's reused.
+	realize().Close()
+	if got, want := requests(sourceRetained), 1.0; got != want {
+		t.Errorf("retained: got: %v, want: %v", got, want)
+	}
+	if err := a.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the arena removes its metrics.
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "arena" && lp.GetValue() == root {
+					t.Errorf("metric %s left after Close", mf.GetName())
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// Sources returns the places to fetch the layer from, in the order to try
+// them: the layer's Mirrors, as described by claircore.LayerMirror, then the
+// layer itself.
+//
+// Each mirror is returned as a copy of the layer with the mirror's URI and
+// Headers.
+func sources(l *claircore.Layer) []*claircore.Layer {
+	if len(l.Mirrors) == 0 {
+		return []*claircore.Layer{l}
+	}
+	ms := make([]claircore.LayerMirror, len(l.Mirrors))
+	copy(ms, l.Mirrors)
+	sort.SliceStable(ms, func(i, j int) bool { return ms[i].Priority < ms[j].Priority })
+	// Within a priority, mirrors are picked as done for SRV records.
+	for i := 0; i < len(ms); {
+		j := i + 1
+		for j < len(ms) && ms[j].Priority == ms[i].Priority {
+			j++
+		}
+		for k := i; k < j-1; k++ {
+			var total int
+			for _, m := range ms[k:j] {
+				total += weight(m)
+			}
+			n := rand.Intn(total)
+			for p := k; p < j; p++ {
+				if n -= weight(ms[p]); n < 0 {
+					ms[k], ms[p] = ms[p], ms[k]
+					break
+				}
+			}
+		}
+		i = j
+	}
+
+	out := make([]*claircore.Layer, 0, len(ms)+1)
+	for _, m := range ms {
+		c := *l
+		c.URI = m.URI
+		c.Headers = m.Headers
+		c.Repository = ""
+		c.Mirrors = nil
+		out = append(out, &c)
+	}
+	return append(out, l)
+}
+
+func weight(m claircore.LayerMirror) int {
+	if m.Weight < 1 {
+		return 1
+	}
+	return m.Weight
+}
+
+// RealizeMirrors is realizeRetry, trying each of the layer's sources in turn
+// until one succeeds.
+//
+// Failures that would happen no matter where the layer came from, like
+// running out of space, are returned without trying the rest. Otherwise, the
+// last source's error is returned.
+func (a *RemoteFetchArena) realizeMirrors(ctx context.Context, l *claircore.Layer) (string, error) {
+	srcs := sources(l)
+	var name string
+	var err error
+	for i, src := range srcs {
+		name, err = a.realizeRetry(ctx, src)
+		if err == nil || i == len(srcs)-1 || !fallback(ctx, err) {
+			break
+		}
+		zlog.Info(ctx).
+			Str("layer", l.Hash.String()).
+			Str("uri", src.URI).
+			Str("next", srcs[i+1].URI).
+			Err(err).
+			Msg("layer fetch from mirror failed, trying next source")
+	}
+	return name, err
+}
+
+// Fallback reports whether a layer fetch that failed with "err" should be
+// tried from another source.
+func fallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	for _, local := range []error{
+		ErrNoSpace,
+		ErrQuotaExceeded,
+		ErrQueueTimeout,
+		ErrShutdown,
+		ErrRealizerClosed,
+	} {
+		if errors.Is(err, local) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package libindex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchMirrors(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	good := tarball(t, "layer")
+	cl, upstream := serveBlob(t, "application/x-tar", good)
+	// Mirror returns a server that serves "b", counting requests.
+	mirror := func(t *testing.T, status int, b []byte, hdr *string) (string, *int64) {
+		var n int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&n, 1)
+			if hdr != nil {
+				*hdr = r.Header.Get("x-test")
+			}
+			w.Header().Set("content-type", "application/x-tar")
+			w.Header().Set("content-length", strconv.Itoa(len(b)))
+			w.WriteHeader(status)
+			w.Write(b)
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL + "/layer", &n
+	}
+	fetch := func(t *testing.T, ms ...claircore.LayerMirror) {
+		t.Helper()
+		ctx := zlog.Test(ctx, t)
+		l := &claircore.Layer{
+			Hash:    upstream.Hash,
+			URI:     upstream.URI,
+			Headers: map[string][]string{"X-Test": {"upstream"}},
+			Mirrors: ms,
+		}
+		a := NewRemoteFetchArena(cl, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := readLayer(t, l)["file"], "layer"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	}
+
+	t.Run("Mirror", func(t *testing.T) {
+		var hdr string
+		u, n := mirror(t, http.StatusOK, good, &hdr)
+		fetch(t, claircore.LayerMirror{URI: u, Headers: map[string][]string{"X-Test": {"mirror"}}})
+		if got, want := atomic.LoadInt64(n), int64(1); got != want {
+			t.Errorf("mirror requests: got: %d, want: %d", got, want)
+		}
+		if got, want := hdr, "mirror"; got != want {
+			t.Errorf("header: got: %q, want: %q", got, want)
+		}
+	})
+
+	t.Run("Fallback", func(t *testing.T) {
+		var hdr string
+		down, n1 := mirror(t, http.StatusNotFound, nil, nil)
+		bad, n2 := mirror(t, http.StatusOK, tarball(t, "poisoned"), &hdr)
+		// The broken mirror is tried first, then the poisoned one, then the
+		// upstream.
+		fetch(t,
+			claircore.LayerMirror{URI: bad, Priority: 2},
+			claircore.LayerMirror{URI: down, Priority: 1},
+		)
+		if atomic.LoadInt64(n1) != 1 || atomic.LoadInt64(n2) != 1 {
+			t.Errorf("mirror requests: got: %d, %d, want: 1, 1", *n1, *n2)
+		}
+		if hdr != "" {
+			t.Errorf("layer headers sent to mirror: %q", hdr)
+		}
+	})
+}
+
+func TestSources(t *testing.T) {
+	l := &claircore.Layer{
+		URI: "upstream",
+		Mirrors: []claircore.LayerMirror{
+			{URI: "light", Weight: 1},
+			{URI: "last", Priority: 1},
+			{URI: "heavy", Weight: 3},
+		},
+	}
+	const trials = 1000
+	heavy := 0
+	for i := 0; i < trials; i++ {
+		srcs := sources(l)
+		if len(srcs) != 4 {
+			t.Fatalf("got %d sources, want 4", len(srcs))
+		}
+		if srcs[2].URI != "last" || srcs[3] != l {
+			t.Fatalf("bad order: %q, %q, %q, %q", srcs[0].URI, srcs[1].URI, srcs[2].URI, srcs[3].URI)
+		}
+		if srcs[0].URI == "heavy" {
+			heavy++
+		}
+	}
+	// Heavy should be first three quarters of the time.
+	t.Logf("heavy first %d/%d times", heavy, trials)
+	if heavy < trials*65/100 || heavy > trials*85/100 {
+		t.Errorf("heavy first %d/%d times, want about %d", heavy, trials, trials*3/4)
+	}
+}
@@ -0,0 +1,174 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/quay/claircore/pkg/tarfs"
+)
+
+// DecompressPolicy decides which layers are stored compressed, exactly as
+// fetched, and decompressed every time they're read instead of once when
+// they're fetched. See WithDecompressPolicy.
+//
+// Storing a layer compressed saves writing out, and keeping, its decompressed
+// tar, at the cost of decompressing it again for every reader. Layers are
+// still decompressed once as they're fetched, to check them.
+type DecompressPolicy int
+
+// These are the defined DecompressPolicies.
+const (
+	// DecompressOnFetch stores every layer decompressed. This is the
+	// default.
+	DecompressOnFetch DecompressPolicy = iota
+	// DecompressZstdOnRead stores zstd-compressed layers compressed. Zstd is
+	// cheap enough to decompress that this is usually a win when disk
+	// bandwidth is scarcer than CPU.
+	DecompressZstdOnRead
+	// DecompressOnRead stores every compressed layer compressed.
+	DecompressOnRead
+)
+
+// OnRead reports whether layers with the compression "c" are stored
+// compressed under the policy. It's false for uncompressed layers, which are
+// stored as fetched either way.
+func (p DecompressPolicy) onRead(c compression) bool {
+	switch p {
+	case DecompressZstdOnRead:
+		return c == cmpZstd
+	case DecompressOnRead:
+		return c != cmpNone
+	}
+	return false
+}
+
+// HeldWriter holds everything written to it until release is called.
+//
+// The bytes off the wire are written out before the layer's compression is
+// known, which decides whether they're kept.
+type heldWriter struct {
+	buf      bytes.Buffer
+	w        io.Writer
+	released bool
+}
+
+func (h *heldWriter) Write(p []byte) (int, error) {
+	switch {
+	case !h.released:
+		return h.buf.Write(p)
+	case h.w == nil:
+		return len(p), nil
+	}
+	return h.w.Write(p)
+}
+
+// Release passes what's been held, and every later write, to "w". If "w" is
+// nil, they're discarded.
+func (h *heldWriter) release(w io.Writer) error {
+	h.released, h.w = true, w
+	if w == nil {
+		h.buf = bytes.Buffer{}
+		return nil
+	}
+	_, err := h.buf.WriteTo(w)
+	return err
+}
+
+// CopyTar is io.Copy for a tar stream, reporting an error that's
+// tarfs.ErrFormat if "r" isn't a well-formed tar.
+//
+// Layers stored compressed are checked with this as they're fetched, as
+// there's no decompressed file to hand to tarfs.New afterwards.
+func copyTar(w io.Writer, r io.Reader) (int64, error) {
+	cr := &checkReader{r: r}
+	cw := &checkWriter{w: w}
+	tr := tar.NewReader(io.TeeReader(cr, cw))
+	for {
+		_, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		switch {
+		case err == nil:
+			continue
+		case cr.err != nil:
+			return cw.n, cr.err
+		case cw.err != nil:
+			return cw.n, cw.err
+		}
+		return cw.n, fmt.Errorf("%w: %v", tarfs.ErrFormat, err)
+	}
+	// The end-of-archive marker may be followed by padding.
+	_, err := io.Copy(cw, cr)
+	return cw.n, err
+}
+
+// CheckReader passes reads through to "r", recording the first error other
+// than io.EOF.
+type checkReader struct {
+	r   io.Reader
+	err error
+}
+
+func (c *checkReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if err != nil && !errors.Is(err, io.EOF) && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// CheckWriter passes writes through to "w", counting the bytes written and
+// recording the first error.
+type checkWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *checkWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// Decompressor returns a function opening the layer stored compressed with
+// "c" in the file "name", for use with claircore.Layer.SetStream.
+func (a *RemoteFetchArena) decompressor(name string, c compression) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		r, done, err := a.decoders.decoder(c, bufio.NewReaderSize(f, a.readAheadSize()))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &decompressedLayer{Reader: r, f: f, done: done}, nil
+	}
+}
+
+// DecompressedLayer is a layer being read through a decompressor.
+type decompressedLayer struct {
+	io.Reader
+	f    *os.File
+	done func()
+}
+
+// Close releases the decompressor and closes the file.
+func (d *decompressedLayer) Close() error {
+	if d.done != nil {
+		d.done()
+		d.done = nil
+	}
+	return d.f.Close()
+}
@@ -0,0 +1,140 @@
+package libindex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/pkg/tarfs"
+)
+
+func TestFetchDecompressPolicy(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const contents = "stored compressed"
+	raw := tarball(t, contents)
+	var zb bytes.Buffer
+	zw, err := zstd.NewWriter(&zb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(raw)
+	zw.Close()
+	blobs := map[string][]byte{
+		"gzip": gzipTarball(t, contents),
+		"zstd": zb.Bytes(),
+		"tar":  raw,
+	}
+
+	tt := []struct {
+		name   string
+		policy DecompressPolicy
+		// Compressed are the formats that should be stored as fetched.
+		compressed []string
+	}{
+		{name: "OnFetch", policy: DecompressOnFetch},
+		{name: "ZstdOnRead", policy: DecompressZstdOnRead, compressed: []string{"zstd"}},
+		{name: "OnRead", policy: DecompressOnRead, compressed: []string{"gzip", "zstd"}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, format := range []string{"gzip", "zstd", "tar"} {
+				t.Run(format, func(t *testing.T) {
+					ctx := zlog.Test(ctx, t)
+					blob := blobs[format]
+					c, l := serveBlob(t, "", blob)
+					root := t.TempDir()
+					a := NewRemoteFetchArena(c, root, WithDecompressPolicy(tc.policy), WithDiffID())
+					defer a.Close(ctx)
+					f := a.Realizer(ctx)
+					defer f.Close()
+					if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+						t.Fatal(err)
+					}
+
+					want := raw
+					for _, c := range tc.compressed {
+						if c == format {
+							want = blob
+						}
+					}
+					stored, err := os.ReadFile(filepath.Join(root, l.Hash.String()))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(stored, want) {
+						t.Errorf("stored layer: got %d bytes, want %d", len(stored), len(want))
+					}
+					// The DiffID is of the tar either way.
+					if got, ok := l.DiffID(); !ok || got.String() != sha256Digest(t, raw).String() {
+						t.Errorf("diffID: got: %v, want: %v", got, sha256Digest(t, raw))
+					}
+
+					rc, err := l.Stream()
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, err := io.ReadAll(rc)
+					rc.Close()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, raw) {
+						t.Error("stream: contents differ")
+					}
+					if got, want := readLayer(t, l)["file"], contents; got != want {
+						t.Errorf("reader: got: %q, want: %q", got, want)
+					}
+				})
+			}
+		})
+	}
+
+	t.Run("NotTar", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write(bytes.Repeat([]byte("not a tar "), 100))
+		zw.Close()
+		c, l := serveBlob(t, "", b.Bytes())
+		a := NewRemoteFetchArena(c, t.TempDir(), WithDecompressPolicy(DecompressOnRead))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, []*claircore.Layer{l})
+		t.Log(err)
+		if !errors.Is(err, tarfs.ErrFormat) {
+			t.Errorf("got: %v, want: %v", err, tarfs.ErrFormat)
+		}
+	})
+
+	t.Run("StoreCompressed", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "", blobs["gzip"])
+		root := t.TempDir()
+		a := NewRemoteFetchArena(c, root, WithDecompressPolicy(DecompressOnRead), WithStoreCompressed())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		// The kept copy is all that's stored compressed.
+		rc, err := l.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if _, ok := rc.(*os.File); !ok {
+			t.Errorf("got: %T, want: *os.File", rc)
+		}
+	})
+}
@@ -0,0 +1,642 @@
+package libindex
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/quay/claircore"
+)
+
+// ArenaOption configures a RemoteFetchArena.
+//
+// ArenaOptions are applied in order by NewRemoteFetchArena.
+type ArenaOption func(*RemoteFetchArena)
+
+// WithArenaConcurrency bounds the number of layer fetches the arena will have
+// in flight at once, across every Realizer it has handed out.
+//
+// This is distinct from any limit applied to a single Realize call. A value
+// less than 1 means no limit, which is the default.
+func WithArenaConcurrency(n int) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if n < 1 {
+			a.sem = nil
+			return
+		}
+		a.sem = semaphore.NewWeighted(int64(n))
+	}
+}
+
+// EvictFunc frees space in an arena's filesystem, reporting the number of bytes
+// freed.
+type EvictFunc func(context.Context) (freed int64, err error)
+
+// WithEvictOnNoSpace configures an EvictFunc to be called when a layer fetch
+// fails with ErrNoSpace or ErrQuotaExceeded.
+//
+// The EvictFunc is called at most once per failed fetch. If it reports freeing
+// any space, the fetch is retried exactly once; a second failure is returned
+// to the caller as-is. This is only useful for arenas that keep unreferenced
+// layers around, as the default arena removes layers as soon as they're
+// unused.
+func WithEvictOnNoSpace(f EvictFunc) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.evict = f
+	}
+}
+
+// WithRetainIdle has the arena keep layers on disk once nothing is using
+// them, instead of removing them immediately. Later fetches of a retained
+// layer reuse it without going to the network. Retained layers are removed by
+// Close.
+//
+// Layers held in memory or written to caller-supplied files are not retained.
+// See also RemoteFetchArena.Compact.
+func WithRetainIdle() ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.retainIdle = true
+	}
+}
+
+// WithRegistryAuth has the arena answer authentication challenges from
+// registries itself, instead of relying on the Layer's Headers to carry
+// credentials.
+//
+// Both the Docker registry token protocol and basic auth are supported. Tokens
+// are cached per host and repository until they expire or are rejected. The
+// CredentialFunc may be nil, in which case only anonymous tokens are
+// requested. Layers with an "Authorization" header are sent as-is.
+//
+// For credentials kept per registry, such as those from LoadDockerConfig, use
+// the Lookup method of a RegistryCredentials.
+func WithRegistryAuth(f CredentialFunc) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.auth = newRegistryAuth(f)
+	}
+}
+
+// WithStoreCompressed has the arena keep a copy of every layer exactly as it
+// was fetched, in addition to the decompressed tar that indexers read.
+//
+// This preserves metadata that decompression discards, such as the footers of
+// seekable formats like eStargz and seekable zstd. The copy is available via
+// the arena's Blob method for as long as the layer is held.
+func WithStoreCompressed() ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.storeCompressed = true
+	}
+}
+
+// LayerFileFunc returns the file a layer should be written into.
+//
+// The returned file must be empty, writable, and have a Name that can be used
+// to open it again for reading. For a descriptor inherited from a parent
+// process, a name like "/proc/self/fd/3" can be provided to os.NewFile.
+type LayerFileFunc func(ctx context.Context, l *claircore.Layer) (*os.File, error)
+
+// WithLayerFile has the arena write layers into files provided by the passed
+// function instead of creating files in its root directory. This allows the
+// arena to be used where the process is not permitted to open files itself.
+//
+// The arena closes the returned file once the layer has been written and
+// verified, and never renames or removes it; the caller owns the file. If the
+// fetch fails, the file is truncated. Note that WithStoreCompressed still has
+// the arena create files of its own.
+func WithLayerFile(f LayerFileFunc) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.layerFile = f
+	}
+}
+
+// WithMemoryThreshold has the arena keep layers smaller than "n" bytes, once
+// decompressed, in memory instead of on disk.
+//
+// The size is taken from the Layer's UncompressedSize if set, or estimated from
+// the response otherwise. If there's no estimate, as with a chunked response,
+// the layer starts out in memory. A layer that turns out to be larger than
+// estimated is moved to disk as it's written. This option has no effect when combined with
+// WithLayerFile or WithStoreCompressed.
+func WithMemoryThreshold(n int64) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.memThreshold = n
+	}
+}
+
+// HeaderFilter is called with the target host and a copy of the headers for
+// every request the arena makes for a layer, and returns the headers to send.
+type HeaderFilter func(host string, h http.Header) http.Header
+
+// WithHeaderFilter has the arena pass request headers through the provided
+// function before sending them, including when following redirects. This
+// allows callers to strip or rewrite headers depending on the destination,
+// for example to avoid sending credentials to a CDN.
+//
+// The Layer's Headers are never modified.
+func WithHeaderFilter(f HeaderFilter) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.headerFilter = f
+	}
+}
+
+// WithBestEffort has Realize attempt to fetch every layer even after one
+// fails, and report all the failures together in a *FetchError. By default,
+// the first failure cancels the remaining fetches.
+func WithBestEffort() ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.bestEffort = true
+	}
+}
+
+// WithInsecureRedirects has the arena follow redirects from https to http
+// URLs. By default, such a redirect fails the fetch with ErrInsecureRedirect,
+// as it would expose the request's credentials and the layer's contents to
+// the network.
+func WithInsecureRedirects() ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.insecureRedirects = true
+	}
+}
+
+// WithMinThroughput has the arena abort layer fetches that read fewer than
+// "rate" bytes per second, on average, over any period of length "window".
+// Aborted fetches report ErrTooSlow.
+//
+// Throughput is measured on the bytes off the wire, starting once the
+// response headers are received, so the first check happens one window into
+// the body. Unlike a fixed timeout, this doesn't penalize large layers that
+// are making steady progress. A non-positive window uses a default of 30
+// seconds. A rate less than 1 disables the check, which is the default.
+func WithMinThroughput(rate int64, window time.Duration) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if window <= 0 {
+			window = defaultThroughputWindow
+		}
+		a.minThroughput = rate
+		a.throughputWindow = window
+		if rate < 1 {
+			a.minThroughput = 0
+		}
+	}
+}
+
+// WithReadAheadSize sets the size of the buffer layer responses are read
+// through, ahead of decompression. A larger buffer pulls more off the network
+// per read, which helps on links with a lot of bandwidth but high latency. A
+// value less than 1 uses the default of 128 KiB.
+//
+// This doesn't affect the buffering used when writing layers out.
+func WithReadAheadSize(n int) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if n < 1 {
+			a.readAhead = 0
+			return
+		}
+		a.readAhead = n
+	}
+}
+
+// WithTarIndex has the arena build an index of the entries in every layer it
+// stores on disk, so that single files can be found and read with the
+// arena's Entry and OpenEntry methods without walking the whole tar. The
+// index is kept alongside the layer and removed with it.
+//
+// This option has no effect for layers kept in memory or written to files
+// provided by WithLayerFile.
+func WithTarIndex() ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.tarIndex = true
+	}
+}
+
+// WithSpoolDir has the arena write layers into "dir" while they're in use,
+// instead of the arena's root. This allows layers to be spooled to a fast
+// volume while the root holds the long-lived cache of layers retained with
+// WithRetainIdle. Together with WithMemoryThreshold, small layers are kept in
+// memory, larger ones in the spool directory, and a layer that turns out to
+// be larger than expected is moved from memory into the spool directory as
+// it's written.
+//
+// When a spooled layer is retained, it's moved into the root. This can only
+// be done if both are on the same filesystem; otherwise the layer is removed
+// as if it weren't retained. Clean only looks at the root.
+func WithSpoolDir(dir string) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.spoolDir = ""
+		if dir == "" {
+			return
+		}
+		dir = filepath.Clean(dir)
+		if dir != filepath.Clean(a.root) {
+			a.spoolDir = dir
+		}
+	}
+}
+
+// WithIdleTTL bounds how long a layer retained with WithRetainIdle is kept
+// around: once "ttl" has passed since the layer was fetched, it's removed
+// instead of being reused, even if it's been used recently. This bounds the
+// staleness of content that's gated by authentication or may be revoked.
+//
+// Expired layers are never reused, and are removed by a periodic sweep that
+// starts once the arena's first Realizer is created; see WithSweepInterval. Layers in use are never removed. A zero "ttl" means
+// retained layers don't expire.
+func WithIdleTTL(ttl time.Duration) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.idleTTL = ttl
+	}
+}
+
+// WithSweepInterval sets how often the arena looks for retained layers that
+// have outlived the TTL set by WithIdleTTL. The default is the TTL itself.
+func WithSweepInterval(d time.Duration) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.sweepInterval = d
+	}
+}
+
+// WithMaxHeaderBytes sets the limit on the total size of a Layer's headers,
+// counted as they'd be sent. Layers with larger headers fail to fetch with
+// ErrInvalidHeader. A value less than 1 uses the default of 64 KiB.
+func WithMaxHeaderBytes(n int) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if n < 1 {
+			a.maxHeaderBytes = 0
+			return
+		}
+		a.maxHeaderBytes = n
+	}
+}
+
+// WithRetryPolicy has the arena consult "p" when a layer request fails, either
+// with an error or a status other than 200, or the connection fails while the
+// layer is being read, to decide whether to try again.
+// ExponentialBackoff is a reasonable choice. By default, failed requests
+// aren't retried.
+func WithRetryPolicy(p RetryPolicy) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.retry = p
+	}
+}
+
+// WithDecompressWorkers separates fetching a compressed layer from
+// decompressing it. Each layer is downloaded in full to a temporary file and
+// its digest checked, which frees its fetch slot (see WithArenaConcurrency),
+// and then decompressed by one of at most "n" workers shared by every
+// Realizer the arena hands out. This allows many layers to be in flight on
+// the network without running as many CPU-bound decompressors. A value less
+// than 1 uses GOMAXPROCS.
+//
+// Layers that aren't compressed are written out as they're fetched, as
+// before. The compressed layer takes up space in the spool directory, or the
+// arena's root, until it's been decompressed.
+func WithDecompressWorkers(n int) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if n < 1 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		a.pool = newDecompressPool(n)
+	}
+}
+
+// WithDiffID has the arena compute the sha256 digest of every layer's
+// uncompressed tar stream as it's written out, and record it on the Layer
+// alongside the digest the layer was verified against: see Layer.DiffID and
+// Layer.Verified. This costs a second pass of hashing over the uncompressed
+// contents.
+//
+// Layers with an ExpectedDiffID always have it computed, using the expected
+// digest's algorithm, and fail with ErrDiffIDMismatch if it doesn't match.
+func WithDiffID() ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.diffID = true
+	}
+}
+
+// WithMaxDecoderWindow sets the largest window, in bytes, a zstd-compressed
+// layer may ask the decompressor for. The window is memory the decompressor
+// must allocate, so this bounds what a hostile layer can make the arena
+// allocate. Layers asking for more fail to fetch. A value of 0 uses the
+// default of 128 MiB.
+func WithMaxDecoderWindow(n uint64) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.maxDecoderWindow = n
+	}
+}
+
+// WithRatioCheck has the arena guard against decompression bombs by
+// decompressing the first 64 KiB of every compressed layer before fetching
+// the rest. If that prefix expands to more than "max" times its size, the
+// fetch fails with ErrSuspiciousRatio before anything is written out. A value
+// less than 1 uses the default of 500.
+//
+// Ordinary layers compress at well under 100:1, but a layer that starts with
+// a large file of zeros, such as a sparse file stored densely, may trip a low
+// limit.
+func WithRatioCheck(max int) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if max < 1 {
+			max = defaultMaxRatio
+		}
+		a.maxRatio = max
+	}
+}
+
+// WithTraceFile has the arena append a FetchTrace record for every layer it
+// fetches to the named file, as a line of JSON. The records time each stage
+// of the fetch, for diagnosing fetch problems where no tracing backend is
+// available.
+//
+// Once the file would grow past "size" bytes, it's renamed with a ".1"
+// suffix, replacing any previous one, and a new file is started. A value
+// less than 1 uses the default of 16 MiB. Errors writing records don't fail
+// fetches; they're reported by Close.
+func WithTraceFile(path string, size int64) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if size < 1 {
+			size = defaultTraceFileSize
+		}
+		a.trace = &traceWriter{path: path, max: size}
+	}
+}
+
+// WithCanonicalFormat has the arena keep a copy of every layer, as with
+// WithStoreCompressed, but re-encoded into the provided format no matter how
+// it was fetched. Blob reports the canonical format for every layer.
+//
+// The layer's digest is still checked against the bytes as fetched. Every
+// layer is re-encoded, even one that was fetched in the canonical format, so
+// the footers of seekable formats aren't preserved. An unknown format leaves
+// the option unset.
+func WithCanonicalFormat(f BlobFormat) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		switch f {
+		case BlobTar, BlobGzip, BlobZstd:
+		default:
+			return
+		}
+		a.storeCompressed = true
+		a.canonical = f
+	}
+}
+
+// WithFetchTimeout bounds how long a layer fetch may take. The "fetch"
+// timeout starts once the layer holds its concurrency slots, as set with
+// WithArenaConcurrency or libindex's LayerConcurrency, and covers the
+// request, decompression, and verification. Time spent waiting for the
+// slots is bounded separately by the "queue" timeout, so that a layer isn't
+// timed out for time spent queued behind others.
+//
+// The errors reported can be checked for with ErrFetchTimeout and
+// ErrQueueTimeout. A zero duration disables the respective timeout.
+func WithFetchTimeout(fetch, queue time.Duration) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if fetch < 0 {
+			fetch = 0
+		}
+		if queue < 0 {
+			queue = 0
+		}
+		a.fetchTimeout = fetch
+		a.queueTimeout = queue
+	}
+}
+
+// WithLayerCache has the arena keep a copy of every layer it fetches in the
+// named directory, and check there before going to the network. The
+// directory may be shared by multiple arenas, including ones in other
+// processes, and its contents outlive the arena: layers fetched before a
+// restart are reused after it.
+//
+// Layers are only added once verified, and are stored decompressed under
+// their digest. Once the directory holds more than "size" bytes, the least
+// recently used layers are removed. A value less than 1 uses the default of
+// 10 GiB. The layers in use by an arena are separate files, so removing them
+// from the cache doesn't disturb their users. If the directory is on the same
+// filesystem as the arena's root, layers are hard linked instead of copied.
+//
+// The contents of the directory are trusted: a cached layer isn't verified
+// again. Layers kept in memory or written to files from WithLayerFile aren't
+// cached, and the cache isn't used with WithStoreCompressed.
+//
+// This is WithLayerStore with a store from NewDiskLayerStore.
+func WithLayerCache(dir string, size int64) ArenaOption {
+	if dir == "" {
+		return WithLayerStore(nil)
+	}
+	return WithLayerStore(NewDiskLayerStore(dir, size))
+}
+
+// WithLayerStore has the arena keep a copy of every layer it fetches in the
+// provided LayerStore, and check there before going to the network. See
+// WithLayerCache for the details, which apply to any store.
+//
+// Layers in stores other than the ones from NewDiskLayerStore are copied to
+// and from the arena's files, and are added as part of the fetch, so a slow
+// store slows down Realize. Failing to add a layer is logged and otherwise
+// ignored. Passing nil disables the store.
+func WithLayerStore(s LayerStore) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.cache = s
+	}
+}
+
+// WithRealizeConcurrency bounds the number of layers a single Realize call
+// fetches at once. Layers beyond the limit aren't started until an earlier
+// one finishes, so a manifest with hundreds of layers doesn't start hundreds
+// of downloads.
+//
+// This is applied before, and in addition to, WithArenaConcurrency. A value
+// less than 1 means no limit, which is the default.
+func WithRealizeConcurrency(n int) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if n < 1 {
+			n = 0
+		}
+		a.realizeLimit = n
+	}
+}
+
+// WithLazyFetch has the arena fetch only the files scanners will read, when
+// the Context passed to Realize says which they are (see
+// indexer.WithFilesOfInterest, which Libindex sets when every configured
+// scanner implements indexer.FileInterest) and the layer allows it.
+//
+// Layers in the eStargz format served by a server supporting range requests
+// are fetched by reading their table of contents, then only the files of
+// interest. The realized layer has every directory, link, and empty file, but
+// no other files. Any other layer is fetched in full.
+//
+// A lazily fetched layer can't be checked against its digest, as that would
+// mean reading all of it. The contents of the files that are fetched are
+// checked against the digests in the table of contents, but the table itself
+// is trusted, so this should only be used with trusted registries. Lazily
+// fetched layers aren't shared between Realizers, cached, or given a DiffID.
+func WithLazyFetch() ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.lazy = true
+	}
+}
+
+// WithDiskQuota limits the disk space used by the arena's root and spool
+// directories to "n" bytes. A value less than 1 means no limit, which is the
+// default.
+//
+// A fetch only starts while usage is below the quota, and fails with
+// ErrQuotaExceeded if writing the layer would take usage past it. Usage
+// includes everything in the directories, such as retained layers, stored
+// blobs, and tar indexes, and is found by listing them, so space freed by
+// removing layers is noticed right away by fetches starting or running out
+// of room, and within a fraction of a second by fetches waiting.
+//
+// If "failFast" is set, a fetch that can't start reports ErrQuotaExceeded
+// immediately. Otherwise, it waits for usage to drop, subject to the queue
+// timeout (see WithFetchTimeout), and a fetch that runs out of room partway
+// through is started over once there's room for more than it wrote. Either
+// way, a layer larger than the quota can never be fetched, and an EvictFunc
+// (see WithEvictOnNoSpace) is given a chance to make room as when the
+// filesystem fills up.
+//
+// Layers kept in memory or written to files from WithLayerFile don't count
+// against the quota. Usage is reported by DiskUsage and by the
+// "claircore_fetcher_disk_usage_bytes" metric.
+func WithDiskQuota(n int64, failFast bool) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if n < 1 {
+			n = 0
+		}
+		a.quotaMax = n
+		a.quotaFailFast = failFast
+	}
+}
+
+// WithDigestAlgorithms limits the digest algorithms layers are verified with
+// to the named ones, such as "sha512". By default, any algorithm claircore
+// knows (see claircore.RegisterDigestAlgorithm) is used.
+//
+// A layer's Hash and AcceptableDigests using other algorithms are ignored, and
+// a layer without any digest using an allowed algorithm is refused with
+// ErrDigestAlgorithm before it's fetched. This lets deployments phase out weak
+// algorithms by refusing to trust them.
+func WithDigestAlgorithms(algos ...string) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.digestAlgos = make(map[string]bool, len(algos))
+		for _, n := range algos {
+			a.digestAlgos[n] = true
+		}
+	}
+}
+
+// WithBandwidthLimit limits the rate layers are read off the network to
+// "total" bytes per second across the arena and "perHost" bytes per second
+// from any one host, so that bursts of indexing don't crowd out everything
+// else on the network. A limit less than 1 means no limit, which is the
+// default for both.
+//
+// Hosts are the ones responses come from, after any redirects. Limits are
+// shared by all of the arena's Realizers. A minimum throughput set with
+// WithMinThroughput should be well below the limits, or throttled fetches
+// will be aborted as too slow.
+func WithBandwidthLimit(total, perHost int64) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.throttle = nil
+		if total > 0 || perHost > 0 {
+			a.throttle = newThrottle(total, perHost)
+		}
+	}
+}
+
+// WithStrictMediaType has the arena check layers with a MediaType, as
+// declared in their image manifest, against what's fetched. Layers whose
+// declared type is unknown, that the server reports as a different type, or
+// whose contents are compressed differently than the declared type says fail
+// with a *MediaTypeError, which can be checked for with ErrMediaTypeMismatch.
+//
+// Servers reporting a generic content-type, like "application/octet-stream",
+// aren't counted as disagreeing. By default, the declared type is trusted
+// without checking.
+func WithStrictMediaType() ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.strictMediaType = true
+	}
+}
+
+// WithMaxLayerSize limits the size of layers once decompressed to "n" bytes.
+// A layer growing past the limit is aborted as soon as it does, instead of
+// being written out in full, and fails with an error satisfying
+// ErrLayerTooLarge. Layers whose UncompressedSize is over the limit fail
+// without being fetched. This protects the arena's disk from decompression
+// bombs and runaway layers.
+//
+// The limit applies to layers as they're fetched: layers taken from a layer
+// store aren't checked. A value less than 1 removes the limit, which is the
+// default.
+func WithMaxLayerSize(n int64) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		if n < 1 {
+			n = 0
+		}
+		a.maxLayerSize = n
+	}
+}
+
+// WithSharedRoot has the arena share layer files with other processes using
+// the same root directory, such as several indexers on one host sharing a
+// volume. A layer already fetched by another process is used in place
+// instead of being fetched again, and a layer file is only removed once no
+// process is using it. Processes coordinate with advisory flock(2) locks on
+// the layer files, so the root must be on a filesystem that supports them;
+// other processes must use this option as well.
+//
+// Only the layer files are shared, so this disables WithStoreCompressed,
+// WithTarIndex, WithRetainIdle, and WithSpoolDir. A disk quota counts every
+// file in the root, including other processes' layers. On windows, this has
+// no effect.
+func WithSharedRoot() ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.shared = true
+	}
+}
+
+// WithLocalLayers has the arena read layers with "file" URIs from "fsys",
+// so locally built images and CI artifacts can be indexed without a
+// registry. URI paths are looked up relative to the root of "fsys": use
+// os.DirFS to serve a directory, or an fs.FS over an archive, such as the
+// output of "docker save", to serve its blobs. Local layers are verified
+// like any other.
+//
+// File URIs are rejected unless this option is used, so that whoever
+// supplies manifests can't have the arena read arbitrary files.
+func WithLocalLayers(fsys fs.FS) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		a.localFS = fsys
+	}
+}
+
+// WithDecompressPolicy has the arena store the layers "p" picks exactly as
+// fetched, and hand them to scanners as streams that decompress on the fly,
+// instead of writing out their decompressed tars. This trades CPU for disk:
+// each layer is written once, compressed, but decompressed again by every
+// reader. Layers are still decompressed and checked as they're fetched.
+//
+// Scanners get these layers from claircore.Layer.Stream; Reader copies them
+// into a temporary file first. Layers stored compressed aren't kept in memory
+// with WithMemoryThreshold, indexed with WithTarIndex, or put in a layer
+// store, and this has no effect with WithLayerFile, WithStoreCompressed, or
+// WithSharedRoot. An unknown policy leaves the option unset.
+func WithDecompressPolicy(p DecompressPolicy) ArenaOption {
+	return func(a *RemoteFetchArena) {
+		switch p {
+		case DecompressOnFetch, DecompressZstdOnRead, DecompressOnRead:
+		default:
+			return
+		}
+		a.decompress = p
+	}
+}
@@ -0,0 +1,153 @@
+package libindex
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Pins is a map of host to the pins for its certificates.
+//
+// A pin is the base64 encoded SHA-256 digest of a certificate's
+// SubjectPublicKeyInfo, optionally prefixed with "sha256/", as produced by:
+//
+//	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+//
+// Hosts are matched case-insensitively, without the port. Hosts given as IP
+// addresses are identified by the addresses in the server's certificate, so
+// pinning them relies on the certificate having been verified.
+type Pins map[string][]string
+
+// PinnedTransport returns a copy of "base", or of http.DefaultTransport if
+// nil, that fails connections to the hosts in "pins" unless one of the
+// certificates in the verified chain has a pinned public key. Connections to
+// other hosts are checked as they would be by "base".
+//
+// Pinning is checked in addition to the usual verification against trusted
+// roots, in the TLS configuration's VerifyConnection hook: unlike
+// VerifyPeerCertificate, it's told the name of the server being connected to.
+// Any existing VerifyConnection hook is still called. A pinned host that's
+// contacted over plain http is not affected, so this is best combined with
+// refusing insecure redirects, which is the arena's default.
+//
+// The returned Transport is meant to be used in the http.Client passed to
+// NewRemoteFetchArena.
+func PinnedTransport(base *http.Transport, pins Pins) (*http.Transport, error) {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	want := make(map[string]map[string]struct{}, len(pins))
+	for h, ps := range pins {
+		if len(ps) == 0 {
+			return nil, fmt.Errorf("fetcher: no pins for host %q", h)
+		}
+		set := make(map[string]struct{}, len(ps))
+		for _, p := range ps {
+			p = strings.TrimPrefix(p, "sha256/")
+			b, err := base64.StdEncoding.DecodeString(p)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("fetcher: invalid pin %q for host %q", p, h)
+			}
+			set[p] = struct{}{}
+		}
+		want[pinHost(h)] = set
+	}
+
+	// The server name is only sent, and so only reported in the connection
+	// state, for DNS names. For IP addresses, the leaf certificate is already
+	// known to be valid for the address dialed, so every pinned address it
+	// names is checked.
+	lookup := func(cs tls.ConnectionState) (map[string]struct{}, bool) {
+		if cs.ServerName != "" {
+			set, ok := want[pinHost(cs.ServerName)]
+			return set, ok
+		}
+		if len(cs.PeerCertificates) == 0 {
+			return nil, false
+		}
+		var set map[string]struct{}
+		for _, ip := range cs.PeerCertificates[0].IPAddresses {
+			ps, ok := want[ip.String()]
+			if !ok {
+				continue
+			}
+			if set == nil {
+				set = make(map[string]struct{})
+			}
+			for p := range ps {
+				set[p] = struct{}{}
+			}
+		}
+		return set, set != nil
+	}
+
+	t := base.Clone()
+	cfg := t.TLSClientConfig
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg = cfg.Clone()
+	next := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if next != nil {
+			if err := next(cs); err != nil {
+				return err
+			}
+		}
+		set, ok := lookup(cs)
+		if !ok {
+			return nil
+		}
+		// Without verified chains, as with InsecureSkipVerify, only the
+		// certificates as sent can be checked.
+		chains := cs.VerifiedChains
+		if len(chains) == 0 {
+			chains = [][]*x509.Certificate{cs.PeerCertificates}
+		}
+		for _, chain := range chains {
+			for _, c := range chain {
+				if _, ok := set[SPKIPin(c)]; ok {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("fetcher: %w: %s", ErrPinMismatch, peerName(cs))
+	}
+	t.TLSClientConfig = cfg
+	return t, nil
+}
+
+// SPKIPin returns the pin for the certificate's public key, in the form used
+// in Pins.
+func SPKIPin(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// PeerName returns a name for the server for use in errors.
+func peerName(cs tls.ConnectionState) string {
+	switch {
+	case cs.ServerName != "":
+		return cs.ServerName
+	case len(cs.PeerCertificates) != 0 && len(cs.PeerCertificates[0].IPAddresses) != 0:
+		return cs.PeerCertificates[0].IPAddresses[0].String()
+	}
+	return "unknown server"
+}
+
+// PinHost normalizes a host for lookup in the pins.
+func pinHost(h string) string {
+	if host, _, err := net.SplitHostPort(h); err == nil {
+		h = host
+	}
+	h = strings.Trim(h, "[]")
+	if ip := net.ParseIP(h); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(h)
+}
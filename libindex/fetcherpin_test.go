@@ -0,0 +1,108 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchPinned(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if err := tar.NewWriter(zw).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := gz.Bytes()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/vnd.oci.image.layer.v1.tar+gzip")
+		w.Header().Set("content-length", strconv.Itoa(len(b)))
+		w.Write(b)
+	}))
+	defer srv.Close()
+	sum := sha256.Sum256(b)
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin := SPKIPin(srv.Certificate())
+	// The test server's certificate is for 127.0.0.1 and example.com.
+	const host = "127.0.0.1"
+	addr := srv.Listener.Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+	other := sha256.Sum256([]byte("some other key"))
+	wrong := base64.StdEncoding.EncodeToString(other[:])
+
+	tt := []struct {
+		name string
+		host string
+		pins Pins
+		err  error
+	}{
+		{name: "Match", host: host, pins: Pins{host: {"sha256/" + pin}}},
+		{name: "MatchAny", host: host, pins: Pins{host: {wrong, pin}}},
+		{name: "Mismatch", host: host, pins: Pins{host: {wrong}}, err: ErrPinMismatch},
+		{name: "Unpinned", host: host, pins: Pins{"registry.example.com": {wrong}}},
+		{name: "NameMatch", host: "example.com", pins: Pins{"Example.com": {pin}}},
+		{name: "NameMismatch", host: "example.com", pins: Pins{"example.com": {wrong}}, err: ErrPinMismatch},
+		{name: "NameUnpinned", host: "example.com", pins: Pins{host: {wrong}}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			tr, err := PinnedTransport(srv.Client().Transport.(*http.Transport), tc.pins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Send every connection to the test server, whatever the name.
+			tr.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			}
+			a := NewRemoteFetchArena(&http.Client{Transport: tr}, t.TempDir())
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			l := &claircore.Layer{
+				URI:     "https://" + net.JoinHostPort(tc.host, port) + "/blob",
+				Hash:    d,
+				Headers: make(http.Header),
+			}
+			err = f.Realize(ctx, []*claircore.Layer{l})
+			switch {
+			case tc.err == nil && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.err != nil && !errors.Is(err, tc.err):
+				t.Errorf("got: %v, want: %v", err, tc.err)
+			}
+		})
+	}
+
+	t.Run("BadPin", func(t *testing.T) {
+		for _, p := range []Pins{
+			{host: {"not base64!"}},
+			{host: {"c2hvcnQ="}},
+			{host: nil},
+		} {
+			if _, err := PinnedTransport(nil, p); err == nil {
+				t.Errorf("%v: expected error", p)
+			}
+		}
+	})
+}
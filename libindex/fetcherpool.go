@@ -0,0 +1,147 @@
+package libindex
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/quay/zlog"
+)
+
+// DecompressPool runs decompression jobs on a bounded number of goroutines.
+//
+// Workers are started as jobs arrive, up to the limit, and exit as soon as
+// there's no job waiting for them, so an idle pool holds no goroutines and
+// needs no shutdown.
+type decompressPool struct {
+	// Jobs is unbuffered: a send succeeds only if a worker is ready for it.
+	jobs chan *poolJob
+	// Slots holds a token for every running worker.
+	slots chan struct{}
+}
+
+type poolJob struct {
+	f    func() error
+	err  error
+	done chan struct{}
+}
+
+func newDecompressPool(n int) *decompressPool {
+	return &decompressPool{
+		jobs:  make(chan *poolJob),
+		slots: make(chan struct{}, n),
+	}
+}
+
+// Do runs "f" on one of the pool's workers and returns its error.
+//
+// The Context only bounds the wait for a worker: once "f" has started, Do
+// waits for it to return, so "f" should watch the Context itself.
+func (p *decompressPool) do(ctx context.Context, f func() error) error {
+	j := &poolJob{f: f, done: make(chan struct{})}
+	select {
+	case p.jobs <- j:
+	case p.slots <- struct{}{}:
+		go p.worker(j)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-j.done
+	return j.err
+}
+
+func (p *decompressPool) worker(j *poolJob) {
+	defer func() { <-p.slots }()
+	for {
+		j.err = j.f()
+		close(j.done)
+		// A submitter blocked on the send is picked up here; otherwise the
+		// worker exits and frees its slot for the next one.
+		select {
+		case j = <-p.jobs:
+		default:
+			return
+		}
+	}
+}
+
+// Download reads the rest of the response body, returning the name of a file
+// holding the blob as fetched. If the arena is storing compressed layers,
+// that's the stored copy, "bf"; otherwise it's a temporary file the caller
+// must remove.
+func (a *RemoteFetchArena) download(ctx context.Context, st *layerStream, bf *os.File, blob *bufio.Writer) (string, error) {
+	if bf != nil {
+		// Everything read is already being copied into the stored blob.
+		if _, err := io.Copy(io.Discard, st.raw); err != nil {
+			return "", err
+		}
+		if err := blob.Flush(); err != nil {
+			return "", noSpace(err)
+		}
+		return bf.Name(), st.checkLength()
+	}
+	dir := a.root
+	if a.spoolDir != "" {
+		dir = a.spoolDir
+	}
+	f, err := os.CreateTemp(dir, "fetch.*.raw")
+	if err != nil {
+		return "", fmt.Errorf("fetcher: unable to create file: %w", err)
+	}
+	name := f.Name()
+	ok := false
+	defer func() {
+		if !ok {
+			if err := os.Remove(name); err != nil {
+				zlog.Warn(ctx).Err(err).Msg("unable to remove unsuccessful blob fetch")
+			}
+		}
+	}()
+	if st.contentLength > 0 {
+		if err := preallocate(f, st.contentLength); err != nil {
+			f.Close()
+			return "", err
+		}
+	}
+	w := bufio.NewWriter(f)
+	if _, err := io.Copy(w, st.raw); err != nil {
+		f.Close()
+		return "", noSpace(err)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return "", noSpace(err)
+	}
+	if err := f.Close(); err != nil {
+		return "", noSpace(err)
+	}
+	if err := st.checkLength(); err != nil {
+		return "", err
+	}
+	ok = true
+	return name, nil
+}
+
+// DecompressFile decompresses the blob in the named file into "w", returning
+// the size of the tar stream.
+func decompressFile(ctx context.Context, p *decoderPool, name string, c compression, sz int, w *bufio.Writer) (int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r, done, err := p.decoder(c, bufio.NewReaderSize(&ctxReader{ctx: ctx, r: f}, sz))
+	if err != nil {
+		return 0, err
+	}
+	if done != nil {
+		defer done()
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return n, noSpace(err)
+	}
+	return n, noSpace(w.Flush())
+}
@@ -0,0 +1,106 @@
+//go:build !windows
+// +build !windows
+
+package libindex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// BenchmarkDecompressWorkers fetches a manifest's worth of gzipped layers over
+// a slow connection, once with decompression done inline under a fetch limit
+// of GOMAXPROCS, the way CPU use is bounded without a pool, and once with
+// many fetches in flight feeding a decompression pool of the same size.
+//
+// The "cpu-util" metric is the CPU time used over the CPU time available in
+// the wall time taken. Overlapping downloads keeps the decompressors busier.
+func BenchmarkDecompressWorkers(b *testing.B) {
+	ctx := context.Background()
+	const layers = 16
+	procs := runtime.GOMAXPROCS(0)
+	rng := rand.New(rand.NewSource(0))
+	var c *http.Client
+	ls := make([]*claircore.Layer, layers)
+	var total int64
+	for i := range ls {
+		// Text-like data, so decompression has some work to do.
+		data := make([]byte, 2<<20)
+		for j := range data {
+			data[j] = 'a' + byte(rng.Intn(8))
+		}
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		if _, err := zw.Write(tarball(b, string(data))); err != nil {
+			b.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			b.Fatal(err)
+		}
+		total += int64(gz.Len())
+		c, ls[i] = serveBlob(b, "application/vnd.oci.image.layer.v1.tar+gzip", gz.Bytes())
+	}
+	c.Transport = &latencyTransport{
+		RoundTripper: c.Transport,
+		delay:        time.Millisecond,
+	}
+
+	bench := []struct {
+		name string
+		opts []ArenaOption
+	}{
+		{"Inline", []ArenaOption{WithArenaConcurrency(procs)}},
+		{"Pool", []ArenaOption{WithArenaConcurrency(layers), WithDecompressWorkers(procs)}},
+	}
+	for _, bc := range bench {
+		b.Run(fmt.Sprintf("%s/procs=%d", bc.name, procs), func(b *testing.B) {
+			ctx := zlog.Test(ctx, b)
+			a := NewRemoteFetchArena(c, b.TempDir(), bc.opts...)
+			defer a.Close(ctx)
+			b.SetBytes(total)
+			b.ReportAllocs()
+			cpu0 := cpuTime(b)
+			start := time.Now()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f := a.Realizer(ctx)
+				cp := make([]*claircore.Layer, len(ls))
+				for i, l := range ls {
+					l := *l
+					cp[i] = &l
+				}
+				if err := f.Realize(ctx, cp); err != nil {
+					b.Fatal(err)
+				}
+				if err := f.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			wall := time.Since(start)
+			cpu := cpuTime(b) - cpu0
+			b.ReportMetric(float64(cpu)/float64(wall*time.Duration(procs)), "cpu-util")
+		})
+	}
+}
+
+// CpuTime reports the user and system CPU time used by the process.
+func cpuTime(b *testing.B) time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
@@ -0,0 +1,222 @@
+package libindex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestDecompressPool(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const limit = 2
+	p := newDecompressPool(limit)
+	var cur, max int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := p.do(ctx, func() error {
+				n := atomic.AddInt32(&cur, 1)
+				for {
+					m := atomic.LoadInt32(&max)
+					if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&cur, -1)
+				return fmt.Errorf("job %d", i)
+			})
+			if got, want := fmt.Sprint(err), fmt.Sprintf("job %d", i); got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if max > limit {
+		t.Errorf("concurrent jobs: got: %d, want: <=%d", max, limit)
+	}
+	if n := len(p.slots); n != 0 {
+		t.Errorf("%d workers left running", n)
+	}
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		p := newDecompressPool(1)
+		block, started := make(chan struct{}), make(chan struct{})
+		go p.do(ctx, func() error {
+			close(started)
+			<-block
+			return nil
+		})
+		<-started
+		cancel()
+		if err := p.do(ctx, func() error { return nil }); !errors.Is(err, context.Canceled) {
+			t.Errorf("got: %v, want: %v", err, context.Canceled)
+		}
+		close(block)
+	})
+}
+
+func TestFetchDecompressWorkers(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	type blob struct {
+		ct   string
+		tar  []byte
+		body []byte
+	}
+	mk := func(i int) blob {
+		tb := tarball(t, strings.Repeat(fmt.Sprintf("layer %d\n", i), 4096))
+		var b bytes.Buffer
+		switch i % 3 {
+		case 0:
+			w := gzip.NewWriter(&b)
+			w.Write(tb)
+			w.Close()
+			return blob{"application/vnd.oci.image.layer.v1.tar+gzip", tb, b.Bytes()}
+		case 1:
+			w, err := zstd.NewWriter(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(tb)
+			w.Close()
+			return blob{"application/vnd.oci.image.layer.v1.tar+zstd", tb, b.Bytes()}
+		default:
+			return blob{"application/vnd.oci.image.layer.v1.tar", tb, tb}
+		}
+	}
+	// No downloaded blobs should be left behind, whatever happens.
+	checkRoot := func(t *testing.T, root string) {
+		t.Helper()
+		ms, err := filepath.Glob(filepath.Join(root, "*.raw"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ms) != 0 {
+			t.Errorf("downloaded blobs left behind: %v", ms)
+		}
+	}
+
+	t.Run("Layers", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		var ls []*claircore.Layer
+		var want [][]byte
+		var client *http.Client
+		for i := 0; i < 6; i++ {
+			b := mk(i)
+			c, l := serveBlob(t, b.ct, b.body)
+			client = c
+			ls = append(ls, l)
+			want = append(want, b.tar)
+		}
+		root := t.TempDir()
+		a := NewRemoteFetchArena(client, root, WithDecompressWorkers(1), WithArenaConcurrency(len(ls)))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, ls); err != nil {
+			t.Fatal(err)
+		}
+		for i, l := range ls {
+			rc, err := l.Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want[i]) {
+				t.Errorf("layer %d: contents differ", i)
+			}
+		}
+		checkRoot(t, root)
+	})
+
+	t.Run("StoreCompressed", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		b := mk(0)
+		c, l := serveBlob(t, b.ct, b.body)
+		root := t.TempDir()
+		a := NewRemoteFetchArena(c, root, WithDecompressWorkers(1), WithStoreCompressed())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		p, _, ok := a.Blob(l.Hash)
+		if !ok {
+			t.Fatal("blob not stored")
+		}
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, b.body) {
+			t.Error("stored blob differs")
+		}
+		checkRoot(t, root)
+	})
+
+	t.Run("VerifiedFirst", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		// A corrupt blob that doesn't match its digest is reported as such,
+		// without being handed to a decompressor.
+		b := mk(0)
+		_, want := serveBlob(t, b.ct, b.body)
+		corrupt := append([]byte{}, b.body...)
+		for i := 20; i < 40; i++ {
+			corrupt[i] ^= 0xff
+		}
+		c, l := serveBlob(t, b.ct, corrupt)
+		l.Hash = want.Hash
+		root := t.TempDir()
+		a := NewRemoteFetchArena(c, root, WithDecompressWorkers(1))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, []*claircore.Layer{l})
+		t.Log(err)
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrDigestMismatch)
+		}
+		checkRoot(t, root)
+	})
+
+	t.Run("TrailingData", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		b := mk(1)
+		c, l := serveBlob(t, b.ct, append(b.body, "garbage"...))
+		root := t.TempDir()
+		a := NewRemoteFetchArena(c, root, WithDecompressWorkers(1))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, []*claircore.Layer{l})
+		t.Log(err)
+		if !errors.Is(err, ErrTrailingData) {
+			t.Errorf("got: %v, want: %v", err, ErrTrailingData)
+		}
+		checkRoot(t, root)
+	})
+}
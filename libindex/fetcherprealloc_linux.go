@@ -0,0 +1,20 @@
+package libindex
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Preallocate reserves "size" bytes of disk for the file, so that running out
+// of space is noticed before the layer is read. The file's size is extended to
+// "size".
+//
+// Filesystems that don't support this are not an error.
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EINVAL) {
+		return nil
+	}
+	return noSpace(err)
+}
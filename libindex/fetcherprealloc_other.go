@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package libindex
+
+import "os"
+
+// Preallocate is a no-op on this platform.
+func preallocate(_ *os.File, _ int64) error { return nil }
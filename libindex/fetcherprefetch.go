@@ -0,0 +1,35 @@
+package libindex
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quay/claircore"
+)
+
+// Prefetch fetches and verifies the layers, then releases them, so that a
+// later Realize of the same layers doesn't have to go to the network.
+//
+// Released layers are only kept by an arena with WithRetainIdle or a layer
+// store, such as one from WithLayerCache; other arenas report an error
+// satisfying ErrNotRetained without fetching anything. Kept layers are
+// subject to eviction and WithIdleTTL like any other, so layers prefetched
+// long before they're needed may have to be fetched again.
+//
+// Copies of the Layers are realized: the passed Layers are left untouched.
+func (a *RemoteFetchArena) Prefetch(ctx context.Context, ls []*claircore.Layer) error {
+	if !a.retainIdle && !a.cacheable() {
+		return fmt.Errorf("fetcher: %w", ErrNotRetained)
+	}
+	cp := make([]*claircore.Layer, len(ls))
+	for i, l := range ls {
+		c := *l
+		cp[i] = &c
+	}
+	p := a.Realizer(ctx)
+	err := p.Realize(ctx, cp)
+	if cerr := p.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/quay/zlog"
+)
+
+func TestFetchPrefetch(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	tt := []struct {
+		name string
+		opts func(t *testing.T) []ArenaOption
+		// Err is the error Prefetch should report, if any.
+		err error
+	}{
+		{
+			name: "RetainIdle",
+			opts: func(t *testing.T) []ArenaOption { return []ArenaOption{WithRetainIdle()} },
+		},
+		{
+			name: "LayerCache",
+			opts: func(t *testing.T) []ArenaOption {
+				return []ArenaOption{WithLayerCache(filepath.Join(t.TempDir(), "cache"), 0)}
+			},
+		},
+		{
+			name: "NotRetained",
+			opts: func(t *testing.T) []ArenaOption { return nil },
+			err:  ErrNotRetained,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			var reqs int64
+			cl, ls := serveBlobs(t, "application/x-tar", tarballs(t, 2, 1024), countRequests(&reqs))
+			a := NewRemoteFetchArena(cl, t.TempDir(), tc.opts(t)...)
+			defer a.Close(ctx)
+
+			ls = fresh(ls)
+			err := a.Prefetch(ctx, ls)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Errorf("got: %v, want: %v", err, tc.err)
+				}
+				if got := atomic.LoadInt64(&reqs); got != 0 {
+					t.Errorf("requests: got: %d, want: 0", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, l := range ls {
+				if l.Fetched() {
+					t.Errorf("layer %d: passed Layer was realized", i)
+				}
+			}
+			a.mu.Lock()
+			held := len(a.rc)
+			a.mu.Unlock()
+			if held != 0 {
+				t.Errorf("layers in use: got: %d, want: 0", held)
+			}
+
+			// The layers are realized without going back to the network.
+			f := a.Realizer(ctx)
+			defer f.Close()
+			if err := f.Realize(ctx, ls); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := atomic.LoadInt64(&reqs), int64(2); got != want {
+				t.Errorf("requests: got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// TestFetchProxyClose races Close against Realize. Run it with the race
+// detector.
+func TestFetchProxyClose(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+
+	t.Run("Concurrent", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		const n = 4
+		blobs := make([]*claircore.Layer, n)
+		var c *http.Client
+		for i := range blobs {
+			c, blobs[i] = serveBlob(t, "application/x-tar", tarball(t, strconv.Itoa(i)))
+		}
+		// Slow the bodies down so that Close lands in the middle of fetches.
+		c.Transport = &latencyTransport{RoundTripper: c.Transport, delay: time.Millisecond}
+		root := t.TempDir()
+		a := NewRemoteFetchArena(c, root)
+		defer a.Close(ctx)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			ls := make([]*claircore.Layer, n)
+			for j, l := range blobs {
+				cl := *l
+				ls[j] = &cl
+			}
+			f := a.Realizer(ctx)
+			delay := time.Duration(i%5) * time.Millisecond
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				err := f.Realize(ctx, ls)
+				switch {
+				case err == nil:
+				case errors.Is(err, ErrRealizerClosed), errors.Is(err, context.Canceled):
+				default:
+					t.Errorf("unexpected error: %v", err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				time.Sleep(delay)
+				if err := f.Close(); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		// Every reference taken was released, so nothing is left behind.
+		a.mu.Lock()
+		if len(a.rc) != 0 {
+			t.Errorf("leaked references: %v", a.rc)
+		}
+		a.mu.Unlock()
+		// Fetches abandoned by a canceled Realize finish in the background.
+		var ents []os.DirEntry
+		for i := 0; i < 100; i++ {
+			var err error
+			ents, err = os.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ents) == 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, e := range ents {
+			t.Errorf("left behind: %s", e.Name())
+		}
+	})
+
+	t.Run("RealizeAfterClose", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "application/x-tar", tarball(t, "contents"))
+		a := NewRemoteFetchArena(c, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		err := f.Realize(ctx, []*claircore.Layer{l})
+		if !errors.Is(err, ErrRealizerClosed) {
+			t.Errorf("got: %v, want: %v", err, ErrRealizerClosed)
+		}
+		// A second Close is harmless.
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("FailedNotReleased", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "application/x-tar", tarball(t, "contents"))
+		a := NewRemoteFetchArena(c, t.TempDir(), WithBestEffort())
+		defer a.Close(ctx)
+		holder := a.Realizer(ctx)
+		if err := holder.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		defer holder.Close()
+
+		// A Realizer whose only fetch failed mustn't release the reference
+		// held by the other.
+		bad := *l
+		bad.URI = ""
+		f := a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{&bad}); err == nil {
+			t.Fatal("expected error")
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		a.mu.Lock()
+		if got, want := a.rc[l.Hash.String()], 1; got != want {
+			t.Errorf("refcount: got: %d, want: %d", got, want)
+		}
+		a.mu.Unlock()
+		rc, err := l.Reader()
+		if err != nil {
+			t.Fatalf("layer released out from under its user: %v", err)
+		}
+		rc.Close()
+	})
+}
@@ -0,0 +1,232 @@
+package libindex
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/quay/zlog"
+)
+
+var (
+	quotaUsage = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "disk_usage_bytes",
+			Help:      "Disk space used by layers in the arena.",
+		},
+		[]string{"arena"},
+	)
+	quotaLimit = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "disk_quota_bytes",
+			Help:      "Disk quota of the arena.",
+		},
+		[]string{"arena"},
+	)
+	quotaWaits = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "disk_quota_waits_total",
+			Help:      "Number of fetches that waited for disk usage to drop below the quota.",
+		},
+		[]string{"arena"},
+	)
+	quotaExceeded = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore",
+			Subsystem: "fetcher",
+			Name:      "disk_quota_exceeded_total",
+			Help:      "Number of fetches that failed because of the disk quota.",
+		},
+		[]string{"arena"},
+	)
+)
+
+// QuotaPoll is how often a fetch waiting on the disk quota checks the arena's
+// usage, in case layers were removed.
+const quotaPoll = 250 * time.Millisecond
+
+// DiskQuota limits the disk space used by the files in an arena's
+// directories. See WithDiskQuota.
+//
+// Usage is found by listing the directories, so it includes everything in
+// them, and adding the sizes of the files being written. A file being written
+// is counted by its writer until it's done, then by listing.
+type diskQuota struct {
+	arena    string
+	max      int64
+	failFast bool
+	dirs     []string
+
+	mu sync.Mutex
+	// Listed is the size of the files in the directories, other than the
+	// ones being written, as of the last listing.
+	listed int64
+	// Writing has the bytes written to each file being written, and
+	// pending is their sum.
+	writing map[string]int64
+	pending int64
+	// Wake is closed and replaced when a write finishes.
+	wake chan struct{}
+}
+
+func newDiskQuota(arena string, max int64, failFast bool, dirs ...string) *diskQuota {
+	q := &diskQuota{
+		arena:    arena,
+		max:      max,
+		failFast: failFast,
+		dirs:     dirs,
+		writing:  make(map[string]int64),
+		wake:     make(chan struct{}),
+	}
+	quotaLimit.WithLabelValues(arena).Set(float64(max))
+	return q
+}
+
+// List updates the listed size.
+//
+// The caller must hold the lock.
+func (q *diskQuota) list() {
+	q.listed = dirUsage(q.dirs, q.writing)
+	quotaUsage.WithLabelValues(q.arena).Set(float64(q.listed + q.pending))
+}
+
+// Usage reports the current usage.
+func (q *diskQuota) usage() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.list()
+	return q.listed + q.pending
+}
+
+// Admit returns once usage is low enough to start writing a layer. "Need" is
+// how much room to wait for, beyond any: a fetch that ran out of room
+// partway through asks for more than it managed to write.
+//
+// If the quota fails fast, or "need" could never fit, an error satisfying
+// ErrQuotaExceeded is returned instead of waiting.
+func (q *diskQuota) admit(ctx context.Context, need int64) error {
+	waited := false
+	for {
+		q.mu.Lock()
+		q.list()
+		used := q.listed + q.pending
+		if used+need < q.max {
+			q.mu.Unlock()
+			return nil
+		}
+		wake := q.wake
+		q.mu.Unlock()
+		if q.failFast || need >= q.max {
+			quotaExceeded.WithLabelValues(q.arena).Inc()
+			return &errQuota{used: used, max: q.max, need: need}
+		}
+		if !waited {
+			waited = true
+			quotaWaits.WithLabelValues(q.arena).Inc()
+			zlog.Debug(ctx).
+				Int64("used", used).
+				Int64("quota", q.max).
+				Int64("need", need).
+				Msg("waiting for disk quota")
+		}
+		t := time.NewTimer(quotaPoll)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-wake:
+			t.Stop()
+		case <-t.C:
+		}
+	}
+}
+
+// Writer returns a Writer that counts what's written to "w", the file
+// "name", against the quota, along with a function to call once the file is
+// done being written or has been removed.
+func (q *diskQuota) writer(name string, w io.Writer) (io.Writer, func()) {
+	q.mu.Lock()
+	q.writing[name] = 0
+	q.mu.Unlock()
+	done := func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		n, ok := q.writing[name]
+		if !ok {
+			return
+		}
+		delete(q.writing, name)
+		q.pending -= n
+		q.list()
+		close(q.wake)
+		q.wake = make(chan struct{})
+	}
+	return &quotaWriter{q: q, name: name, w: w}, done
+}
+
+// QuotaWriter is the Writer returned by diskQuota.writer.
+type quotaWriter struct {
+	q    *diskQuota
+	name string
+	w    io.Writer
+}
+
+// Write implements io.Writer.
+//
+// A write that would exceed the quota is refused outright. Usage is listed
+// again first, as layers may have been removed since the last listing.
+func (w *quotaWriter) Write(b []byte) (int, error) {
+	q := w.q
+	n := int64(len(b))
+	q.mu.Lock()
+	if q.listed+q.pending+n > q.max {
+		q.list()
+	}
+	if used := q.listed + q.pending; used+n > q.max {
+		need := q.writing[w.name] + n
+		q.mu.Unlock()
+		quotaExceeded.WithLabelValues(q.arena).Inc()
+		return 0, &errQuota{used: used, max: q.max, need: need}
+	}
+	q.writing[w.name] += n
+	q.pending += n
+	quotaUsage.WithLabelValues(q.arena).Set(float64(q.listed + q.pending))
+	q.mu.Unlock()
+	return w.w.Write(b)
+}
+
+// Close removes the arena's metrics.
+func (q *diskQuota) close() {
+	quotaUsage.DeleteLabelValues(q.arena)
+	quotaLimit.DeleteLabelValues(q.arena)
+	quotaWaits.DeleteLabelValues(q.arena)
+	quotaExceeded.DeleteLabelValues(q.arena)
+}
+
+// DiskUsage reports the disk space used by the arena's files and its quota.
+// It reports false if the arena has no quota. See WithDiskQuota.
+func (a *RemoteFetchArena) DiskUsage() (used, quota int64, ok bool) {
+	if a.quota == nil {
+		return 0, 0, false
+	}
+	return a.quota.usage(), a.quota.max, true
+}
+
+// QuotaWriter wraps the writer for the file "name" if the arena has a disk
+// quota. The returned function must be called once the file is written or
+// removed.
+func (a *RemoteFetchArena) quotaWriter(name string, w io.Writer) (io.Writer, func()) {
+	if a.quota == nil {
+		return w, func() {}
+	}
+	return a.quota.writer(name, w)
+}
@@ -0,0 +1,151 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchQuota(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const size = 64 * 1024
+	// Realize fetches the layer with a new Realizer, which is returned so the
+	// layer stays on disk until it's closed.
+	realize := func(ctx context.Context, a *RemoteFetchArena, l *claircore.Layer) (*FetchProxy, error) {
+		f := a.Realizer(ctx).(*FetchProxy)
+		err := f.Realize(ctx, fresh([]*claircore.Layer{l}))
+		return f, err
+	}
+
+	t.Run("FailFast", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		bs := tarballs(t, 2, size)
+		cl, ls := serveBlobs(t, "application/x-tar", bs)
+		// Room for one layer and half of another.
+		a := NewRemoteFetchArena(cl, t.TempDir(), WithDiskQuota(int64(len(bs[0]))*3/2, true))
+		defer a.Close(ctx)
+		f, err := realize(ctx, a, ls[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		used, quota, ok := a.DiskUsage()
+		t.Logf("using %d of %d", used, quota)
+		if !ok || used < int64(len(bs[0])) {
+			t.Errorf("usage: got: %d, want at least %d", used, len(bs[0]))
+		}
+		g, err := realize(ctx, a, ls[1])
+		g.Close()
+		t.Log(err)
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("got: %v, want: %v", err, ErrQuotaExceeded)
+		}
+		// Once the first layer is gone, there's room.
+		f.Close()
+		g, err = realize(ctx, a, ls[1])
+		if err != nil {
+			t.Error(err)
+		}
+		g.Close()
+	})
+
+	t.Run("Wait", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		bs := tarballs(t, 2, size)
+		cl, ls := serveBlobs(t, "application/x-tar", bs)
+		a := NewRemoteFetchArena(cl, t.TempDir(), WithDiskQuota(int64(len(bs[0]))*3/2, false))
+		defer a.Close(ctx)
+		f, err := realize(ctx, a, ls[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		errCh := make(chan error, 1)
+		go func() {
+			g, err := realize(ctx, a, ls[1])
+			g.Close()
+			errCh <- err
+		}()
+		select {
+		case err := <-errCh:
+			t.Fatalf("fetch finished while over quota: %v", err)
+		case <-time.After(2 * quotaPoll):
+		}
+		f.Close()
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("fetch still waiting after space was freed")
+		}
+	})
+
+	t.Run("QueueTimeout", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		bs := tarballs(t, 2, size)
+		cl, ls := serveBlobs(t, "application/x-tar", bs)
+		// Full with one layer.
+		a := NewRemoteFetchArena(cl, t.TempDir(),
+			WithDiskQuota(int64(len(bs[0])), false),
+			WithFetchTimeout(0, 2*quotaPoll))
+		defer a.Close(ctx)
+		f, err := realize(ctx, a, ls[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		g, err := realize(ctx, a, ls[1])
+		g.Close()
+		t.Log(err)
+		if !errors.Is(err, ErrQueueTimeout) {
+			t.Errorf("got: %v, want: %v", err, ErrQueueTimeout)
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		bs := tarballs(t, 1, size)
+		cl, ls := serveBlobs(t, "application/x-tar", bs)
+		a := NewRemoteFetchArena(cl, t.TempDir(), WithDiskQuota(int64(len(bs[0]))/2, false))
+		defer a.Close(ctx)
+		f, err := realize(ctx, a, ls[0])
+		f.Close()
+		t.Log(err)
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("got: %v, want: %v", err, ErrQuotaExceeded)
+		}
+		if used, _, _ := a.DiskUsage(); used != 0 {
+			t.Errorf("usage: got: %d, want: 0", used)
+		}
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		bs := tarballs(t, 2, size)
+		cl, ls := serveBlobs(t, "application/x-tar", bs)
+		var f *FetchProxy
+		evict := func(context.Context) (int64, error) {
+			f.Close()
+			return int64(len(bs[0])), nil
+		}
+		a := NewRemoteFetchArena(cl, t.TempDir(),
+			WithDiskQuota(int64(len(bs[0]))*3/2, true),
+			WithEvictOnNoSpace(evict))
+		defer a.Close(ctx)
+		var err error
+		f, err = realize(ctx, a, ls[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := realize(ctx, a, ls[1])
+		if err != nil {
+			t.Error(err)
+		}
+		g.Close()
+	})
+}
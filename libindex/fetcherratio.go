@@ -0,0 +1,69 @@
+package libindex
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// DefaultMaxRatio is the limit on how many times its size the start of a
+// layer may decompress to when WithRatioCheck isn't given one. Gzip can't do
+// much better than 1000:1 even on a run of zeros, so this only catches layers
+// that start with little besides a single repeated byte.
+const defaultMaxRatio = 500
+
+// RatioPrefix is how much of a compressed layer is decompressed up front to
+// estimate its compression ratio. It's limited to the read-ahead buffer.
+const ratioPrefix = 64 * 1024
+
+// MinSuspiciousSize is the least a prefix must decompress to before its ratio
+// is considered. This keeps small layers, such as an empty tar padded out to a
+// full record, from looking like bombs.
+const minSuspiciousSize = 1 << 20
+
+// CheckRatio decompresses the start of the response body and reports
+// ErrSuspiciousRatio if it expands by more than "max" times.
+//
+// The prefix is only peeked at, not consumed, so the layer's full
+// decompression reads the same bytes off the network. At most a bounded
+// amount of output is produced and none of it is kept.
+func (s *layerStream) checkRatio(max int) error {
+	if s.c == cmpNone {
+		return nil
+	}
+	n := ratioPrefix
+	if sz := s.raw.Size(); sz < n {
+		n = sz
+	}
+	prefix, err := s.raw.Peek(n)
+	switch {
+	case err == nil:
+	case err == io.EOF:
+		// A body shorter than the prefix is checked in its entirety.
+	default:
+		return err
+	}
+	if len(prefix) == 0 {
+		return nil
+	}
+	limit := int64(len(prefix)) * int64(max)
+	if limit < minSuspiciousSize {
+		limit = minSuspiciousSize
+	}
+	r, done, err := s.decoders.decoder(s.c, bufio.NewReader(bytes.NewReader(prefix)))
+	if err != nil {
+		// Leave it to the real decompression to report.
+		return nil
+	}
+	if done != nil {
+		defer done()
+	}
+	// The prefix almost certainly ends partway through the stream, so the
+	// decompressor is expected to report an unexpected EOF. Any other
+	// errors are reported by the real decompression as well.
+	out, _ := io.Copy(io.Discard, io.LimitReader(r, limit+1))
+	if out > limit {
+		return &errSuspiciousRatio{read: int64(len(prefix)), limit: limit, max: max}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchRatioCheck(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const (
+		gzipType = "application/vnd.oci.image.layer.v1.tar+gzip"
+		zstdType = "application/vnd.oci.image.layer.v1.tar+zstd"
+	)
+	// Bomb is a tar holding 96 MiB of zeros, which compresses to more than
+	// the checked prefix.
+	var bomb bytes.Buffer
+	tw := tar.NewWriter(&bomb)
+	const bombSize = 96 << 20
+	if err := tw.WriteHeader(&tar.Header{Name: "zeros", Size: bombSize, Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.CopyN(tw, zeroReader{}, bombSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Ordinary is a tar larger than the checked prefix, compressing about as
+	// well as a real layer.
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"lib", "usr", "share", "doc", "locale", "bin", "etc", "\n"}
+	var text bytes.Buffer
+	for text.Len() < 1<<20 {
+		text.WriteString(words[rng.Intn(len(words))])
+	}
+	noise := make([]byte, 256<<10)
+	rng.Read(noise)
+	ordinary := tarball(t, text.String()+string(noise))
+	empty := tarball(t, "")
+
+	gz := func(b []byte) []byte {
+		var out bytes.Buffer
+		w, err := gzip.NewWriterLevel(&out, gzip.BestSpeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	zst := func(b []byte) []byte {
+		var out bytes.Buffer
+		w, err := zstd.NewWriter(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+
+	tt := []struct {
+		name string
+		ct   string
+		blob []byte
+		opts []ArenaOption
+		err  error
+	}{
+		{name: "GzipBomb", ct: gzipType, blob: gz(bomb.Bytes()), opts: []ArenaOption{WithRatioCheck(0)}, err: ErrSuspiciousRatio},
+		{name: "ZstdBomb", ct: zstdType, blob: zst(bomb.Bytes()), opts: []ArenaOption{WithRatioCheck(0)}, err: ErrSuspiciousRatio},
+		{name: "PooledBomb", ct: gzipType, blob: gz(bomb.Bytes()), opts: []ArenaOption{WithRatioCheck(0), WithDecompressWorkers(1)}, err: ErrSuspiciousRatio},
+		{name: "Disabled", ct: gzipType, blob: gz(bomb.Bytes())},
+		{name: "Generous", ct: gzipType, blob: gz(bomb.Bytes()), opts: []ArenaOption{WithRatioCheck(5000)}},
+		{name: "Gzip", ct: gzipType, blob: gz(ordinary), opts: []ArenaOption{WithRatioCheck(0)}},
+		{name: "Zstd", ct: zstdType, blob: zst(ordinary), opts: []ArenaOption{WithRatioCheck(0)}},
+		{name: "Pooled", ct: zstdType, blob: zst(ordinary), opts: []ArenaOption{WithRatioCheck(0), WithDecompressWorkers(1)}},
+		{name: "Empty", ct: gzipType, blob: gz(empty), opts: []ArenaOption{WithRatioCheck(1)}},
+		{name: "Uncompressed", ct: "application/x-tar", blob: bomb.Bytes(), opts: []ArenaOption{WithRatioCheck(1)}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, tc.ct, tc.blob)
+			a := NewRemoteFetchArena(c, t.TempDir(), tc.opts...)
+			defer a.Close(ctx)
+			var wrote countWriter
+			a.wrapWriter = func(w io.Writer) io.Writer {
+				wrote.w = w
+				return &wrote
+			}
+			t.Logf("compressed size: %d", len(tc.blob))
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			if tc.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				// The prefix was fed back into the full decompression.
+				if wrote.n == 0 {
+					t.Errorf("nothing written")
+				}
+				return
+			}
+			t.Log(err)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got: %v, want: %v", err, tc.err)
+			}
+			if wrote.n != 0 {
+				t.Errorf("wrote %d bytes of a rejected layer", wrote.n)
+			}
+		})
+	}
+}
+
+// ZeroReader is an endless source of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+func TestFetchMaxLayerSize(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const limit = 256 << 10
+	// Zeros compress well, so the layer is far smaller than the limit on the
+	// wire and far larger once decompressed.
+	var big bytes.Buffer
+	tw := tar.NewWriter(&big)
+	if err := tw.WriteHeader(&tar.Header{Name: "zeros", Size: 4 * limit, Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.CopyN(tw, zeroReader{}, 4*limit); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(big.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	small := gzipTarball(t, "small")
+
+	tt := []struct {
+		name     string
+		blob     []byte
+		size     int64
+		opts     []ArenaOption
+		tooLarge bool
+	}{
+		{name: "Small", blob: small},
+		{name: "Stream", blob: gz.Bytes(), tooLarge: true},
+		{name: "Pool", blob: gz.Bytes(), opts: []ArenaOption{WithDecompressWorkers(1)}, tooLarge: true},
+		{name: "Memory", blob: gz.Bytes(), opts: []ArenaOption{WithMemoryThreshold(limit / 2)}, tooLarge: true},
+		{name: "Declared", blob: small, size: 2 * limit, tooLarge: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", tc.blob)
+			l.UncompressedSize = tc.size
+			root := t.TempDir()
+			a := NewRemoteFetchArena(c, root, append(tc.opts, WithMaxLayerSize(limit))...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			t.Log(err)
+			if !tc.tooLarge {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrLayerTooLarge) || !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("got: %v, want: %v", err, ErrLayerTooLarge)
+			}
+			ents, err := os.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range ents {
+				if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+					t.Errorf("file left in arena: %s", e.Name())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package libindex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/pkg/tarfs"
+)
+
+// TestFetchReadAhead checks that compression is still detected with read-ahead
+// buffers smaller than the magic numbers being looked for.
+func TestFetchReadAhead(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	plain := tarball(t, strings.Repeat("readahead\n", 1024))
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	if _, err := gw.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var zs bytes.Buffer
+	zw, err := zstd.NewWriter(&zs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	blobs := []struct {
+		name string
+		body []byte
+	}{
+		{"Tar", plain},
+		{"Gzip", gz.Bytes()},
+		{"Zstd", zs.Bytes()},
+	}
+
+	for _, sz := range []int{1, 16, 0} {
+		for _, b := range blobs {
+			t.Run(strconv.Itoa(sz)+"/"+b.name, func(t *testing.T) {
+				ctx := zlog.Test(ctx, t)
+				c, l := serveBlob(t, "", b.body)
+				a := NewRemoteFetchArena(c, t.TempDir(), WithReadAheadSize(sz))
+				f := a.Realizer(ctx)
+				defer f.Close()
+				if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+					t.Fatal(err)
+				}
+				rd, err := l.Reader()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rd.Close()
+				if _, err := tarfs.New(rd); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
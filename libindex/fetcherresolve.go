@@ -0,0 +1,325 @@
+package libindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// ErrNoPlatform is reported by Resolve when an image index has no image for
+// the requested platform.
+var errNoPlatform = errors.New("no image for platform")
+
+// Media types of the manifests Resolve understands.
+const (
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// MaxManifestSize bounds the size of a manifest or index, as suggested by the
+// distribution spec.
+const maxManifestSize = 4 << 20
+
+// DockerHubRegistry is the host Docker Hub's API is served from.
+const dockerHubRegistry = "registry-1.docker.io"
+
+var (
+	refName = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*)*$`)
+	refTag  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// Reference is a parsed image reference, like
+// "quay.io/projectquay/clair:4.4.0".
+type reference struct {
+	// Host is the registry's host, with Docker Hub's names replaced by the
+	// host its API is served from.
+	host string
+	// Name is the repository within the registry.
+	name string
+	// Tag and digest are what's referenced within the repository, and may
+	// both be empty.
+	tag    string
+	digest string
+}
+
+// ParseReference parses an image reference in the usual Docker form. As with
+// Docker, a reference without a registry host is to Docker Hub, and a Docker
+// Hub repository without a namespace is in "library".
+func parseReference(s string) (reference, error) {
+	var r reference
+	rest := s
+	if i := strings.IndexByte(rest, '@'); i != -1 {
+		rest, r.digest = rest[:i], rest[i+1:]
+		if _, err := claircore.ParseDigest(r.digest); err != nil {
+			return r, fmt.Errorf("fetcher: bad reference %q: %w", s, err)
+		}
+	}
+	if i := strings.LastIndexByte(rest, ':'); i != -1 && !strings.Contains(rest[i:], "/") {
+		rest, r.tag = rest[:i], rest[i+1:]
+		if !refTag.MatchString(r.tag) {
+			return r, fmt.Errorf("fetcher: bad reference %q: bad tag %q", s, r.tag)
+		}
+	}
+	r.name = rest
+	if i := strings.IndexByte(rest, '/'); i != -1 {
+		if h := rest[:i]; strings.ContainsAny(h, ".:") || h == "localhost" {
+			r.host, r.name = h, rest[i+1:]
+		}
+	}
+	switch r.host {
+	case "", "docker.io", "index.docker.io":
+		r.host = dockerHubRegistry
+		if !strings.Contains(r.name, "/") {
+			r.name = "library/" + r.name
+		}
+	}
+	if !refName.MatchString(r.name) {
+		return r, fmt.Errorf("fetcher: bad reference %q: bad repository name %q", s, r.name)
+	}
+	return r, nil
+}
+
+// Repository returns the reference's repository, in the form used by
+// claircore.Layer.
+func (r reference) repository() string {
+	return r.host + "/" + r.name
+}
+
+// URL returns the URL of the named kind of object in the reference's
+// repository: "blobs" or "manifests".
+func (r reference) url(kind, ref string) *url.URL {
+	return &url.URL{
+		Scheme: "https",
+		Host:   r.host,
+		Path:   "/v2/" + r.name + "/" + kind + "/" + ref,
+	}
+}
+
+// RepositoryURI returns the URI of the layer's blob in its Repository.
+func repositoryURI(l *claircore.Layer) (string, error) {
+	r, err := parseReference(l.Repository)
+	switch {
+	case err != nil:
+		return "", err
+	case r.tag != "" || r.digest != "":
+		return "", fmt.Errorf("fetcher: repository %q names an image", l.Repository)
+	}
+	return r.url("blobs", l.Hash.String()).String(), nil
+}
+
+// Descriptor is an OCI content descriptor.
+type descriptor struct {
+	MediaType string   `json:"mediaType"`
+	Digest    string   `json:"digest"`
+	Size      int64    `json:"size"`
+	URLs      []string `json:"urls,omitempty"`
+	Platform  *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant,omitempty"`
+	} `json:"platform,omitempty"`
+}
+
+// ImageManifest is an OCI image manifest, image index, or their Docker
+// equivalents.
+type imageManifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    *descriptor  `json:"config,omitempty"`
+	Layers    []descriptor `json:"layers,omitempty"`
+	Manifests []descriptor `json:"manifests,omitempty"`
+}
+
+// Resolve looks up the image named by "ref", like
+// "quay.io/projectquay/clair:4.4.0", and returns a Manifest ready to index.
+// The Manifest's Layers name their Repository instead of a URI, so they're
+// fetched from the registry by the arena, authenticated as configured by
+// WithRegistryAuth.
+//
+// If the reference names an image index (a "manifest list"), the image for
+// "platform", given as "os/architecture" or "os/architecture/variant", is
+// used. An empty platform means "linux" and the architecture the program is
+// running on. A reference without a tag or digest means "latest".
+//
+// Registries are always reached with https.
+func (a *RemoteFetchArena) Resolve(ctx context.Context, ref, platform string) (*claircore.Manifest, error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/fetchArena.Resolve",
+		"ref", ref)
+	r, err := parseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	if platform == "" {
+		platform = "linux/" + runtime.GOARCH
+	}
+	want := r.digest
+	if want == "" {
+		want = r.tag
+	}
+	if want == "" {
+		want = "latest"
+	}
+	m, d, err := a.getManifest(ctx, r, want)
+	if err != nil {
+		return nil, err
+	}
+	var os string
+	if m.MediaType == mediaTypeOCIIndex || m.MediaType == mediaTypeDockerList {
+		child := pickPlatform(m, platform)
+		if child == nil {
+			return nil, fmt.Errorf("fetcher: %q: %w %q", ref, errNoPlatform, platform)
+		}
+		zlog.Debug(ctx).
+			Str("platform", platform).
+			Str("manifest", child.Digest).
+			Msg("picked image from index")
+		os = child.Platform.OS
+		if m, d, err = a.getManifest(ctx, r, child.Digest); err != nil {
+			return nil, err
+		}
+		if m.MediaType == mediaTypeOCIIndex || m.MediaType == mediaTypeDockerList {
+			return nil, fmt.Errorf("fetcher: %q: nested image index", ref)
+		}
+	}
+
+	out := &claircore.Manifest{
+		Hash:   d,
+		OS:     os,
+		Layers: make([]*claircore.Layer, 0, len(m.Layers)),
+	}
+	if m.Config != nil {
+		cd, err := claircore.ParseDigest(m.Config.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("fetcher: %q: bad config digest: %w", ref, err)
+		}
+		out.ConfigRef = &claircore.ConfigRef{
+			Hash: cd,
+			URI:  r.url("blobs", cd.String()).String(),
+		}
+	}
+	for _, ld := range m.Layers {
+		h, err := claircore.ParseDigest(ld.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("fetcher: %q: bad layer digest: %w", ref, err)
+		}
+		l := &claircore.Layer{
+			Hash:       h,
+			Repository: r.repository(),
+			MediaType:  ld.MediaType,
+			Size:       ld.Size,
+			Headers:    make(map[string][]string),
+		}
+		// Foreign layers aren't in the registry.
+		if len(ld.URLs) != 0 {
+			l.URI = ld.URLs[0]
+		}
+		out.Layers = append(out.Layers, l)
+	}
+	return out, nil
+}
+
+// PickPlatform returns the descriptor of the image for "platform" in the image
+// index, or nil if there isn't one.
+func pickPlatform(m *imageManifest, platform string) *descriptor {
+	for i := range m.Manifests {
+		c := &m.Manifests[i]
+		if c.Platform == nil {
+			continue
+		}
+		p := c.Platform.OS + "/" + c.Platform.Architecture
+		if platform == p || platform == p+"/"+c.Platform.Variant {
+			return c
+		}
+	}
+	return nil
+}
+
+// GetManifest fetches the manifest or index named by "ref" in the
+// reference's repository, returning it and its digest.
+//
+// The digest is of the bytes returned, so a manifest requested by digest is
+// checked against it.
+func (a *RemoteFetchArena) getManifest(ctx context.Context, r reference, ref string) (*imageManifest, claircore.Digest, error) {
+	var d claircore.Digest
+	u := r.url("manifests", ref)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, d, fmt.Errorf("fetcher: unable to construct manifest request: %w", err)
+	}
+	req.Header.Set("accept", strings.Join([]string{
+		mediaTypeOCIManifest, mediaTypeOCIIndex,
+		mediaTypeDockerManifest, mediaTypeDockerList,
+	}, ", "))
+	c := a.wc
+	if c == nil {
+		c = http.DefaultClient
+	}
+	res, err := a.doRetry(ctx, c, req)
+	if err != nil {
+		return nil, d, &errRequest{what: "manifest request", inner: err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		e := newStatusError(res)
+		e.URL = u.Redacted()
+		return nil, d, e
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, d, fmt.Errorf("fetcher: %s: %w", u.Redacted(), err)
+	}
+	if len(b) > maxManifestSize {
+		return nil, d, fmt.Errorf("fetcher: %s: %w: manifest larger than %d bytes", u.Redacted(), ErrTooLarge, maxManifestSize)
+	}
+
+	algo, h := "sha256", sha256.New()
+	pd, err := claircore.ParseDigest(ref)
+	if err == nil {
+		algo, h = pd.Algorithm(), pd.Hash()
+	}
+	h.Write(b)
+	if d, err = claircore.NewDigest(algo, h.Sum(nil)); err != nil {
+		return nil, d, err
+	}
+	if pd.Checksum() != nil && d.String() != pd.String() {
+		return nil, d, &errDigestMismatch{got: []string{d.String()}, want: []string{pd.String()}}
+	}
+
+	var m imageManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, d, fmt.Errorf("fetcher: %s: unable to decode manifest: %w", u.Redacted(), err)
+	}
+	// The Content-Type is authoritative, but older manifests may only say
+	// what they are there.
+	if ct, _, err := mime.ParseMediaType(res.Header.Get("content-type")); err == nil && ct != "application/json" {
+		m.MediaType = ct
+	}
+	switch m.MediaType {
+	case mediaTypeOCIManifest, mediaTypeDockerManifest, mediaTypeOCIIndex, mediaTypeDockerList:
+	case "":
+		// An OCI manifest may leave out its media type.
+		if m.Manifests != nil {
+			m.MediaType = mediaTypeOCIIndex
+		} else {
+			m.MediaType = mediaTypeOCIManifest
+		}
+	default:
+		return nil, d, fmt.Errorf("fetcher: %s: unsupported manifest type %q", u.Redacted(), m.MediaType)
+	}
+	return &m, d, nil
+}
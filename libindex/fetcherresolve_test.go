@@ -0,0 +1,331 @@
+package libindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// FakeDistribution is just enough of a registry's distribution API to resolve
+// and pull images. Blobs are served as octet-streams, like most registries do.
+type fakeDistribution struct {
+	t    testing.TB
+	name string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	ct   string
+	body []byte
+}
+
+func newFakeDistribution(t testing.TB, name string) *fakeDistribution {
+	return &fakeDistribution{t: t, name: name, objects: make(map[string]fakeObject)}
+}
+
+// Blob adds a blob, returning its descriptor.
+func (f *fakeDistribution) Blob(mt string, b []byte) descriptor {
+	sum := sha256.Sum256(b)
+	d := fmt.Sprintf("sha256:%x", sum)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects["/v2/"+f.name+"/blobs/"+d] = fakeObject{ct: "application/octet-stream", body: b}
+	return descriptor{MediaType: mt, Digest: d, Size: int64(len(b))}
+}
+
+// Manifest adds a manifest or index under its digest and the provided tags,
+// returning its descriptor.
+func (f *fakeDistribution) Manifest(mt string, v interface{}, tags ...string) descriptor {
+	b, err := json.Marshal(v)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	sum := sha256.Sum256(b)
+	d := fmt.Sprintf("sha256:%x", sum)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ref := range append(tags, d) {
+		f.objects["/v2/"+f.name+"/manifests/"+ref] = fakeObject{ct: mt, body: b}
+	}
+	return descriptor{MediaType: mt, Digest: d, Size: int64(len(b))}
+}
+
+func (f *fakeDistribution) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	o, ok := f.objects[r.URL.Path]
+	f.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if strings.Contains(r.URL.Path, "/manifests/") &&
+		!strings.Contains(r.Header.Get("accept"), o.ct) {
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+	w.Header().Set("content-type", o.ct)
+	w.Header().Set("content-length", strconv.Itoa(len(o.body)))
+	w.Write(o.body)
+}
+
+func TestResolve(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const name = "project/image"
+	reg := newFakeDistribution(t, name)
+	srv := httptest.NewTLSServer(reg)
+	t.Cleanup(srv.Close)
+	host := srv.Listener.Addr().String()
+
+	cfg := reg.Blob("application/vnd.oci.image.config.v1+json", []byte(`{}`))
+	amd := imageManifest{
+		MediaType: mediaTypeOCIManifest,
+		Config:    &cfg,
+		Layers: []descriptor{
+			reg.Blob("application/vnd.oci.image.layer.v1.tar+gzip", gzipTarball(t, "amd64 one")),
+			reg.Blob("application/vnd.oci.image.layer.v1.tar", tarball(t, "amd64 two")),
+		},
+	}
+	amdDesc := reg.Manifest(mediaTypeOCIManifest, amd, "amd64")
+	arm := imageManifest{
+		MediaType: mediaTypeDockerManifest,
+		Config:    &cfg,
+		Layers: []descriptor{
+			reg.Blob("application/vnd.docker.image.rootfs.diff.tar.gzip", gzipTarball(t, "arm64")),
+		},
+	}
+	armDesc := reg.Manifest(mediaTypeDockerManifest, arm)
+	idx := imageManifest{MediaType: mediaTypeOCIIndex}
+	for _, p := range []struct {
+		d          descriptor
+		arch, vari string
+	}{
+		{amdDesc, "amd64", ""},
+		{armDesc, "arm64", "v8"},
+	} {
+		p.d.Platform = &struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant,omitempty"`
+		}{OS: "linux", Architecture: p.arch, Variant: p.vari}
+		idx.Manifests = append(idx.Manifests, p.d)
+	}
+	reg.Manifest(mediaTypeOCIIndex, idx, "latest")
+
+	a := NewRemoteFetchArena(srv.Client(), t.TempDir())
+	t.Cleanup(func() { a.Close(ctx) })
+
+	t.Run("Pull", func(t *testing.T) {
+		table := []struct {
+			ref, platform string
+			want          descriptor
+			contents      []string
+		}{
+			{host + "/" + name, "linux/amd64", amdDesc, []string{"amd64 one", "amd64 two"}},
+			{host + "/" + name + ":latest", "linux/arm64/v8", armDesc, []string{"arm64"}},
+			{host + "/" + name + ":amd64", "", amdDesc, []string{"amd64 one", "amd64 two"}},
+			{host + "/" + name + "@" + armDesc.Digest, "", armDesc, []string{"arm64"}},
+		}
+		for _, tc := range table {
+			t.Run(tc.ref[len(host):]+" "+tc.platform, func(t *testing.T) {
+				ctx := zlog.Test(ctx, t)
+				m, err := a.Resolve(ctx, tc.ref, tc.platform)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := m.Hash.String(), tc.want.Digest; got != want {
+					t.Errorf("manifest: got: %q, want: %q", got, want)
+				}
+				if got, want := m.ConfigRef.URI, "https://"+host+"/v2/"+name+"/blobs/"+cfg.Digest; got != want {
+					t.Errorf("config: got: %q, want: %q", got, want)
+				}
+				if got, want := len(m.Layers), len(tc.contents); got != want {
+					t.Fatalf("layers: got: %d, want: %d", got, want)
+				}
+				f := a.Realizer(ctx)
+				defer f.Close()
+				if err := f.Realize(ctx, m.Layers); err != nil {
+					t.Fatal(err)
+				}
+				for i, l := range m.Layers {
+					if l.URI != "" || l.Repository != host+"/"+name {
+						t.Errorf("layer %d: unexpected location: %q, %q", i, l.URI, l.Repository)
+					}
+					if got, want := readLayer(t, l)["file"], tc.contents[i]; got != want {
+						t.Errorf("layer %d: got: %q, want: %q", i, got, want)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("NoPlatform", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		_, err := a.Resolve(ctx, host+"/"+name, "windows/amd64")
+		t.Log(err)
+		if !errors.Is(err, errNoPlatform) {
+			t.Errorf("got: %v, want: %v", err, errNoPlatform)
+		}
+	})
+
+	t.Run("DigestMismatch", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		// Serve the wrong manifest for a digest.
+		bad := "sha256:" + strings.Repeat("0", 64)
+		reg.mu.Lock()
+		reg.objects["/v2/"+name+"/manifests/"+bad] = reg.objects["/v2/"+name+"/manifests/amd64"]
+		reg.mu.Unlock()
+		_, err := a.Resolve(ctx, host+"/"+name+"@"+bad, "")
+		t.Log(err)
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrDigestMismatch)
+		}
+	})
+
+	t.Run("SizeMismatch", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		m, err := a.Resolve(ctx, host+"/"+name+":amd64", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Layers[0].Size++
+		b := NewRemoteFetchArena(srv.Client(), t.TempDir())
+		defer b.Close(ctx)
+		f := b.Realizer(ctx)
+		defer f.Close()
+		err = f.Realize(ctx, m.Layers[:1])
+		t.Log(err)
+		if !errors.Is(err, ErrSizeMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrSizeMismatch)
+		}
+	})
+
+	t.Run("MediaType", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		// A layer whose media type doesn't match its contents fails, which
+		// shows the media type is used over guessing.
+		l := &claircore.Layer{
+			Hash:       claircore.MustParseDigest(amd.Layers[0].Digest),
+			Repository: host + "/" + name,
+			MediaType:  "application/vnd.oci.image.layer.v1.tar+zstd",
+		}
+		b := NewRemoteFetchArena(srv.Client(), t.TempDir())
+		defer b.Close(ctx)
+		f := b.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, []*claircore.Layer{l})
+		t.Log(err)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestParseReference(t *testing.T) {
+	const d = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	table := []struct {
+		in   string
+		want reference
+	}{
+		{"ubuntu", reference{host: dockerHubRegistry, name: "library/ubuntu"}},
+		{"ubuntu:22.04", reference{host: dockerHubRegistry, name: "library/ubuntu", tag: "22.04"}},
+		{"docker.io/grafana/grafana", reference{host: dockerHubRegistry, name: "grafana/grafana"}},
+		{"quay.io/projectquay/clair:4.4.0", reference{host: "quay.io", name: "projectquay/clair", tag: "4.4.0"}},
+		{"localhost/image@" + d, reference{host: "localhost", name: "image", digest: d}},
+		{"localhost:5000/a/b/c:tag@" + d, reference{host: "localhost:5000", name: "a/b/c", tag: "tag", digest: d}},
+	}
+	for _, tc := range table {
+		got, err := parseReference(tc.in)
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got: %+v, want: %+v", tc.in, got, tc.want)
+		}
+	}
+	for _, in := range []string{
+		"",
+		"UPPER/case",
+		"quay.io/",
+		"image:-tag",
+		"image:",
+		"image@sha256:short",
+	} {
+		if got, err := parseReference(in); err == nil {
+			t.Errorf("%q: expected error, got: %+v", in, got)
+		}
+	}
+}
+
+func TestFetchStrictMediaType(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const (
+		octets   = "application/octet-stream"
+		ociGzip  = "application/vnd.oci.image.layer.v1.tar+gzip"
+		ociZstd  = "application/vnd.oci.image.layer.v1.tar+zstd"
+		ociTar   = "application/vnd.oci.image.layer.v1.tar"
+		dockerGz = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	)
+	gz := gzipTarball(t, "layer")
+	tt := []struct {
+		name     string
+		ct       string
+		declared string
+		strict   bool
+		body     []byte
+		err      bool
+		mismatch bool
+	}{
+		{name: "Match", ct: octets, declared: ociGzip, strict: true, body: gz},
+		{name: "MatchReported", ct: dockerGz, declared: ociGzip, strict: true, body: gz},
+		{name: "Undeclared", ct: ociGzip, strict: true, body: gz},
+		{name: "Contents", ct: octets, declared: ociTar, strict: true, body: gz, err: true, mismatch: true},
+		{name: "Reported", ct: ociZstd, declared: ociGzip, strict: true, body: gz, err: true, mismatch: true},
+		{name: "Unknown", ct: octets, declared: "application/vnd.example.layer", strict: true, body: gz, err: true, mismatch: true},
+		{name: "Empty", ct: octets, declared: ociGzip, strict: true, body: []byte{}},
+		// Without strict checking, the declared type is used as-is.
+		{name: "Lax", ct: ociZstd, declared: ociGzip, body: gz},
+		{name: "LaxContents", ct: octets, declared: ociZstd, body: gz, err: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, tc.ct, tc.body)
+			l.MediaType = tc.declared
+			var opts []ArenaOption
+			if tc.strict {
+				opts = append(opts, WithStrictMediaType())
+			}
+			a := NewRemoteFetchArena(c, t.TempDir(), opts...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			t.Log(err)
+			if got, want := err != nil, tc.err; got != want {
+				t.Fatalf("got error: %v, want error: %v", got, want)
+			}
+			if got, want := errors.Is(err, ErrMediaTypeMismatch), tc.mismatch; got != want {
+				t.Errorf("media type mismatch: got: %v, want: %v", got, want)
+			}
+			var mte *MediaTypeError
+			if tc.mismatch && (!errors.As(err, &mte) || mte.Declared != tc.declared) {
+				t.Errorf("unexpected error: %#v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package libindex
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/quay/zlog"
+)
+
+// RetryPolicy decides whether a failed layer request is retried, and when.
+//
+// The policy is consulted when a request fails, and again if the connection
+// fails partway through an accepted response, such as by being reset. In the
+// latter case the layer is fetched again from the start, as a partial layer
+// can't be resumed. Other failures reading the body, like a digest mismatch,
+// are reported as-is.
+type RetryPolicy interface {
+	// NextDelay is called after the attempt'th request for a layer, counting
+	// from 1, fails. Exactly one of "resp" and "err" is non-nil: "resp" is a
+	// response with a status other than 200, and its body must not be read.
+	// It returns how long to wait before the next attempt and whether to make
+	// one at all.
+	NextDelay(attempt int, resp *http.Response, err error) (time.Duration, bool)
+}
+
+// ExponentialBackoff is a RetryPolicy that retries connection errors and
+// responses indicating a transient problem (408, 429, 500, 502, 503, and 504)
+// with exponentially increasing, jittered delays.
+//
+// A Retry-After header on a 429 or 503 response is honored, up to Max.
+// Errors that retrying can't fix, such as certificate problems, insecure
+// redirects, and invalid headers, aren't retried.
+//
+// The zero value is ready to use.
+type ExponentialBackoff struct {
+	// Base is the delay before the first retry. The delay doubles with every
+	// attempt after that. Defaults to 1 second.
+	Base time.Duration
+	// Max caps the delay between attempts. Defaults to 30 seconds.
+	Max time.Duration
+	// MaxAttempts is the total number of requests made for a layer,
+	// including the first. Defaults to 4.
+	MaxAttempts int
+}
+
+var _ RetryPolicy = (*ExponentialBackoff)(nil)
+
+// NextDelay implements RetryPolicy.
+func (b *ExponentialBackoff) NextDelay(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	base, max, n := b.Base, b.Max, b.MaxAttempts
+	if base <= 0 {
+		base = time.Second
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	if n <= 0 {
+		n = 4
+	}
+	if attempt >= n || !retryable(resp, err) {
+		return 0, false
+	}
+	if resp != nil {
+		if d, ok := retryAfter(resp); ok {
+			if d > max {
+				d = max
+			}
+			return d, true
+		}
+	}
+	d := max
+	if shift := attempt - 1; shift < 32 && base<<shift > 0 && base<<shift < max {
+		d = base << shift
+	}
+	// Wait between half and all of the delay, so that fetches that failed
+	// together don't all retry together.
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1)), true
+}
+
+// Retryable reports whether the failure is one that could go away on its own.
+func retryable(resp *http.Response, err error) bool {
+	if resp != nil {
+		switch resp.StatusCode {
+		case http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalid          x509.CertificateInvalidError
+		hostname         x509.HostnameError
+	)
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, ErrInsecureRedirect),
+		errors.Is(err, ErrPinMismatch),
+		errors.Is(err, ErrInvalidHeader),
+		errors.As(err, &unknownAuthority),
+		errors.As(err, &invalid),
+		errors.As(err, &hostname):
+		return false
+	}
+	return true
+}
+
+// RetryAfter parses the response's Retry-After header, if it's a 429 or 503.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// DoRetry issues the request, retrying according to the arena's RetryPolicy.
+//
+// The final response is returned whatever its status, for the caller to
+// report.
+func (a *RemoteFetchArena) doRetry(ctx context.Context, c *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := a.do(ctx, c, req)
+		if a.retry == nil || (err == nil && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent)) {
+			return resp, err
+		}
+		var failed *http.Response
+		if err == nil {
+			failed = resp
+		}
+		d, ok := a.retry.NextDelay(attempt, failed, err)
+		if !ok {
+			return resp, err
+		}
+		ev := zlog.Info(ctx).
+			Int("attempt", attempt).
+			Dur("delay", d)
+		if err != nil {
+			ev = ev.Err(err)
+		} else {
+			ev = ev.Int("status", resp.StatusCode)
+			// Read a little of the body so the connection can be reused.
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		ev.Msg("layer request failed, retrying")
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package libindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// Only503 retries 503 responses, immediately, up to three attempts.
+type only503 struct{}
+
+func (only503) NextDelay(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	return 0, attempt < 3 && resp != nil && resp.StatusCode == http.StatusServiceUnavailable
+}
+
+func TestFetchRetry(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	b := tarball(t, "retry")
+	tt := []struct {
+		name     string
+		status   int
+		fail     int
+		policy   func() RetryPolicy
+		requests int64
+		ok       bool
+	}{
+		{name: "None", status: http.StatusServiceUnavailable, fail: 1, requests: 1},
+		{name: "Only503", status: http.StatusServiceUnavailable, fail: 2, policy: func() RetryPolicy { return only503{} }, requests: 3, ok: true},
+		{name: "Only503Exhausted", status: http.StatusServiceUnavailable, fail: 3, policy: func() RetryPolicy { return only503{} }, requests: 3},
+		{name: "Only503Other", status: http.StatusBadGateway, fail: 1, policy: func() RetryPolicy { return only503{} }, requests: 1},
+		{
+			name: "Default", status: http.StatusBadGateway, fail: 2,
+			policy:   func() RetryPolicy { return &ExponentialBackoff{Base: time.Millisecond} },
+			requests: 3, ok: true,
+		},
+		{
+			name: "DefaultNotFound", status: http.StatusNotFound, fail: 1,
+			policy:   func() RetryPolicy { return &ExponentialBackoff{Base: time.Millisecond} },
+			requests: 1,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			var n int64
+			c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar", b,
+				withFailures(tc.status, tc.fail), countRequests(&n))
+			var opts []ArenaOption
+			if tc.policy != nil {
+				opts = append(opts, WithRetryPolicy(tc.policy()))
+			}
+			a := NewRemoteFetchArena(c, t.TempDir(), opts...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			if tc.ok && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Error("expected error")
+			}
+			if got, want := atomic.LoadInt64(&n), tc.requests; got != want {
+				t.Errorf("requests: got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
+
+func TestFetchRetryInterrupted(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	b := tarball(t, "interrupted")
+	backoff := func() RetryPolicy { return &ExponentialBackoff{Base: time.Millisecond} }
+	tt := []struct {
+		name     string
+		cut      int
+		policy   func() RetryPolicy
+		requests int64
+		ok       bool
+	}{
+		{name: "None", cut: 1, requests: 1},
+		{name: "Default", cut: 2, policy: backoff, requests: 3, ok: true},
+		{name: "DefaultExhausted", cut: 4, policy: backoff, requests: 4},
+		// Only503 doesn't retry errors, so the interruption is final.
+		{name: "Only503", cut: 1, policy: func() RetryPolicy { return only503{} }, requests: 1},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			var n int64
+			c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar", b,
+				withCut(tc.cut), countRequests(&n))
+			var opts []ArenaOption
+			if tc.policy != nil {
+				opts = append(opts, WithRetryPolicy(tc.policy()))
+			}
+			a := NewRemoteFetchArena(c, t.TempDir(), opts...)
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			t.Log(err)
+			if tc.ok && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Error("expected error")
+			}
+			if got, want := atomic.LoadInt64(&n), tc.requests; got != want {
+				t.Errorf("requests: got: %d, want: %d", got, want)
+			}
+		})
+	}
+
+	t.Run("Mismatch", func(t *testing.T) {
+		// A complete response with the wrong contents isn't retried.
+		ctx := zlog.Test(ctx, t)
+		var n int64
+		c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar", b, countRequests(&n))
+		sum := sha256.Sum256([]byte("something else"))
+		d, err := claircore.NewDigest("sha256", sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Hash = d
+		a := NewRemoteFetchArena(c, t.TempDir(), WithRetryPolicy(backoff()))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrDigestMismatch)
+		}
+		if got, want := atomic.LoadInt64(&n), int64(1); got != want {
+			t.Errorf("requests: got: %d, want: %d", got, want)
+		}
+	})
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	resp := func(code int, retryAfter string) *http.Response {
+		r := &http.Response{StatusCode: code, Header: make(http.Header)}
+		if retryAfter != "" {
+			r.Header.Set("Retry-After", retryAfter)
+		}
+		return r
+	}
+	var p ExponentialBackoff
+
+	t.Run("Retryable", func(t *testing.T) {
+		tt := []struct {
+			name string
+			resp *http.Response
+			err  error
+			want bool
+		}{
+			{name: "503", resp: resp(503, ""), want: true},
+			{name: "429", resp: resp(429, ""), want: true},
+			{name: "502", resp: resp(502, ""), want: true},
+			{name: "404", resp: resp(404, "")},
+			{name: "401", resp: resp(401, "")},
+			{name: "Network", err: errors.New("connection reset by peer"), want: true},
+			{name: "Canceled", err: context.Canceled},
+			{name: "InsecureRedirect", err: ErrInsecureRedirect},
+			{name: "UnknownAuthority", err: &url.Error{Op: "Get", URL: "https://example.com/", Err: x509.UnknownAuthorityError{}}},
+		}
+		for _, tc := range tt {
+			if _, got := p.NextDelay(1, tc.resp, tc.err); got != tc.want {
+				t.Errorf("%s: got: %v, want: %v", tc.name, got, tc.want)
+			}
+		}
+	})
+
+	t.Run("Attempts", func(t *testing.T) {
+		for i := 1; i < 4; i++ {
+			if _, ok := p.NextDelay(i, resp(503, ""), nil); !ok {
+				t.Errorf("attempt %d: not retried", i)
+			}
+		}
+		if _, ok := p.NextDelay(4, resp(503, ""), nil); ok {
+			t.Error("attempt 4: retried")
+		}
+	})
+
+	t.Run("Delay", func(t *testing.T) {
+		p := ExponentialBackoff{Base: time.Second, Max: 10 * time.Second, MaxAttempts: 100}
+		for i, want := range []time.Duration{
+			time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+			10 * time.Second, 10 * time.Second,
+		} {
+			for j := 0; j < 20; j++ {
+				d, _ := p.NextDelay(i+1, nil, errors.New("oops"))
+				if d < want/2 || d > want {
+					t.Errorf("attempt %d: got: %v, want: [%v, %v]", i+1, d, want/2, want)
+				}
+			}
+		}
+		if d, _ := p.NextDelay(99, nil, errors.New("oops")); d > 10*time.Second {
+			t.Errorf("attempt 99: got: %v", d)
+		}
+	})
+
+	t.Run("RetryAfter", func(t *testing.T) {
+		p := ExponentialBackoff{Max: time.Minute}
+		if d, ok := p.NextDelay(1, resp(429, "7"), nil); !ok || d != 7*time.Second {
+			t.Errorf("got: %v, %v", d, ok)
+		}
+		if d, ok := p.NextDelay(1, resp(503, "3600"), nil); !ok || d != time.Minute {
+			t.Errorf("got: %v, %v", d, ok)
+		}
+		date := time.Now().Add(20 * time.Second).UTC().Format(http.TimeFormat)
+		if d, ok := p.NextDelay(1, resp(503, date), nil); !ok || d > 20*time.Second || d < 18*time.Second {
+			t.Errorf("got: %v, %v", d, ok)
+		}
+	})
+}
@@ -0,0 +1,261 @@
+package libindex
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/quay/claircore"
+)
+
+// S3Config configures an S3LayerStore.
+type S3Config struct {
+	// Endpoint is the base URL of the service, like
+	// "https://s3.us-east-1.amazonaws.com". Buckets are addressed by path, so
+	// this works with other services implementing the S3 API.
+	Endpoint string
+	// Region is the region requests are signed for. If empty, "us-east-1"
+	// is used.
+	Region string
+	// Bucket is the bucket layers are kept in.
+	Bucket string
+	// Prefix is prepended to the name of every object. Layers are named by
+	// their digest, like "sha256/<hex>".
+	Prefix string
+	// AccessKeyID, SecretAccessKey, and SessionToken are the credentials
+	// requests are signed with. SessionToken is only needed for temporary
+	// credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// S3LayerStore is a LayerStore keeping layers as objects in an S3 bucket, so
+// they can be shared by arenas on different machines and outlive all of them.
+//
+// The store doesn't limit its size or remove anything on its own; use the
+// bucket's lifecycle rules for that. Layers are uploaded in a single request,
+// so layers larger than the service's limit for one (5 GiB for S3) fail to be
+// added, which is logged and otherwise ignored by the arena.
+type S3LayerStore struct {
+	c    *http.Client
+	cfg  S3Config
+	base *url.URL
+	// Now is the clock requests are signed with.
+	now func() time.Time
+}
+
+// NewS3LayerStore returns an S3LayerStore making requests with the provided
+// client. If the client is nil, http.DefaultClient is used.
+func NewS3LayerStore(c *http.Client, cfg S3Config) (*S3LayerStore, error) {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("fetcher: s3: bad endpoint: %w", err)
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https", u.Host == "":
+		return nil, fmt.Errorf("fetcher: s3: bad endpoint: %q", cfg.Endpoint)
+	case cfg.Bucket == "":
+		return nil, errors.New("fetcher: s3: no bucket")
+	case cfg.AccessKeyID == "" || cfg.SecretAccessKey == "":
+		return nil, errors.New("fetcher: s3: no credentials")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &S3LayerStore{
+		c:    c,
+		cfg:  cfg,
+		base: u,
+		now:  time.Now,
+	}, nil
+}
+
+// Put implements LayerStore.
+func (s *S3LayerStore) Put(ctx context.Context, d claircore.Digest, r io.Reader, size int64) error {
+	req, err := s.request(ctx, http.MethodPut, d, io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return s.statusError(res)
+	}
+	return nil
+}
+
+// Open implements LayerStore.
+func (s *S3LayerStore) Open(ctx context.Context, d claircore.Digest) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, d, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return bodyReader{res.Body}, nil
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, &fs.PathError{Op: "open", Path: req.URL.String(), Err: fs.ErrNotExist}
+	}
+	defer res.Body.Close()
+	return nil, s.statusError(res)
+}
+
+// Remove implements LayerStore.
+func (s *S3LayerStore) Remove(ctx context.Context, d claircore.Digest) error {
+	req, err := s.request(ctx, http.MethodDelete, d, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return s.statusError(res)
+}
+
+// Request returns a request for the object holding the layer with the
+// provided digest.
+func (s *S3LayerStore) request(ctx context.Context, method string, d claircore.Digest, body io.Reader) (*http.Request, error) {
+	u := *s.base
+	u.Path = path.Join("/", u.Path, s.cfg.Bucket, s.cfg.Prefix, d.Algorithm(), hex.EncodeToString(d.Checksum()))
+	u.RawPath = uriEncode(u.Path)
+	u.RawQuery = ""
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("fetcher: s3: %w", err)
+	}
+	return req, nil
+}
+
+// Do signs and sends the request.
+func (s *S3LayerStore) do(req *http.Request) (*http.Response, error) {
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+	signV4(req, s.cfg.AccessKeyID, s.cfg.SecretAccessKey, s.cfg.Region, "UNSIGNED-PAYLOAD", s.now())
+	res, err := s.c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetcher: s3: %w", err)
+	}
+	return res, nil
+}
+
+// StatusError returns an error for an unexpected response, including the
+// start of its body, which S3 fills with an explanation.
+func (s *S3LayerStore) statusError(res *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return fmt.Errorf("fetcher: s3: %s %s: unexpected status: %s: %s",
+		res.Request.Method, res.Request.URL.Path, res.Status, strings.TrimSpace(string(b)))
+}
+
+// SignV4 adds an AWS Signature Version 4 authorization to the request for the
+// S3 service. "Payload" is the hex-encoded SHA-256 of the body, or
+// "UNSIGNED-PAYLOAD".
+//
+// Every header already on the request is signed, along with the host.
+func signV4(req *http.Request, keyID, secret, region, payload string, now time.Time) {
+	const (
+		algorithm = "AWS4-HMAC-SHA256"
+		service   = "s3"
+	)
+	now = now.UTC()
+	stamp := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	hdrs := map[string]string{"host": host}
+	for k, vs := range req.Header {
+		k = strings.ToLower(k)
+		if k == "authorization" || k == "user-agent" {
+			continue
+		}
+		for i := range vs {
+			vs[i] = strings.Join(strings.Fields(vs[i]), " ")
+		}
+		hdrs[k] = strings.Join(vs, ",")
+	}
+	names := make([]string, 0, len(hdrs))
+	for k := range hdrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canon strings.Builder
+	canon.WriteString(req.Method + "\n")
+	canon.WriteString(req.URL.EscapedPath() + "\n")
+	canon.WriteString(req.URL.RawQuery + "\n")
+	for _, k := range names {
+		canon.WriteString(k + ":" + hdrs[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canon.WriteString("\n" + signed + "\n" + payload)
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canon.String()))
+	toSign := algorithm + "\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	mac := func(key []byte, msg string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(msg))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+secret), day)
+	key = mac(key, region)
+	key = mac(key, service)
+	key = mac(key, "aws4_request")
+	sig := hex.EncodeToString(mac(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, keyID, scope, signed, sig))
+}
+
+// UriEncode escapes "p" as S3 expects in a canonical request: everything but
+// unreserved characters and slashes is percent-encoded.
+func uriEncode(p string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0xF])
+		}
+	}
+	return b.String()
+}
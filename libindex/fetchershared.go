@@ -0,0 +1,70 @@
+package libindex
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/quay/claircore"
+)
+
+// FromPeer reports the layer's file in the root if it's already there,
+// fetched by this arena or by another process sharing the root. The file is
+// locked once fetchOne takes the layer into use.
+func (a *RemoteFetchArena) fromPeer(l *claircore.Layer) (string, bool, error) {
+	if !a.shared {
+		return "", false, nil
+	}
+	h := l.Hash.String()
+	name := filepath.Join(a.root, h)
+	a.mu.Lock()
+	_, held := a.layerLocks[h]
+	a.mu.Unlock()
+	if held {
+		return name, true, nil
+	}
+	fi, err := os.Lstat(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	case !fi.Mode().IsRegular():
+		return "", false, nil
+	}
+	var diffID claircore.Digest
+	if a.diffID || l.ExpectedDiffID != nil {
+		diffID, err = a.prepareCached(name, l)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// Released by its last user in the meantime.
+			return "", false, nil
+		case err != nil:
+			return "", false, err
+		}
+		if err := checkDiffID(l, diffID); err != nil {
+			return "", false, err
+		}
+	}
+	a.mu.Lock()
+	// Layers are only published once verified against this digest.
+	a.verified[h] = l.Hash
+	if diffID.Checksum() != nil {
+		a.diffIDs[h] = diffID
+	}
+	a.mu.Unlock()
+	return name, true, nil
+}
+
+// RemoveLayer removes the layer's file. If the root is shared, the file is
+// left for any other process still using it.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) removeLayer(digest string) error {
+	name := filepath.Join(a.dir(digest), digest)
+	if lf, ok := a.layerLocks[digest]; ok {
+		delete(a.layerLocks, digest)
+		return releaseLayer(lf, name)
+	}
+	return os.Remove(name)
+}
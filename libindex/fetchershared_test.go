@@ -0,0 +1,153 @@
+package libindex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchSharedRoot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shared roots not supported on windows")
+	}
+	ctx := zlog.Test(context.Background(), t)
+	const size = 32 * 1024
+	// Two arenas on the same root stand in for two processes: flock(2) locks
+	// taken through separate opens conflict just the same.
+	arenas := func(t *testing.T, cl *http.Client) (string, *RemoteFetchArena, *RemoteFetchArena) {
+		t.Helper()
+		root := t.TempDir()
+		a := NewRemoteFetchArena(cl, root, WithSharedRoot())
+		b := NewRemoteFetchArena(cl, root, WithSharedRoot())
+		t.Cleanup(func() {
+			a.Close(ctx)
+			b.Close(ctx)
+		})
+		return root, a, b
+	}
+	realize := func(t *testing.T, a *RemoteFetchArena, l *claircore.Layer) (*FetchProxy, *claircore.Layer) {
+		t.Helper()
+		f := a.Realizer(ctx).(*FetchProxy)
+		ls := fresh([]*claircore.Layer{l})
+		if err := f.Realize(ctx, ls); err != nil {
+			t.Fatal(err)
+		}
+		return f, ls[0]
+	}
+	// Local reports the file backing a realized layer.
+	local := func(t *testing.T, l *claircore.Layer) string {
+		t.Helper()
+		rc, err := l.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		f, ok := rc.(*os.File)
+		if !ok {
+			t.Fatalf("layer not backed by a file: %T", rc)
+		}
+		return f.Name()
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(name)
+		return !errors.Is(err, os.ErrNotExist)
+	}
+
+	t.Run("Reuse", func(t *testing.T) {
+		var reqs int64
+		cl, ls := serveBlobs(t, "application/x-tar", tarballs(t, 1, size), countRequests(&reqs))
+		_, a, b := arenas(t, cl)
+		f, la := realize(t, a, ls[0])
+		g, lb := realize(t, b, ls[0])
+		if got, want := atomic.LoadInt64(&reqs), int64(1); got != want {
+			t.Errorf("requests: got: %d, want: %d", got, want)
+		}
+		pa, pb := local(t, la), local(t, lb)
+		if pa != pb {
+			t.Errorf("paths differ: %q, %q", pa, pb)
+		}
+		if d, ok := lb.Verified(); !ok || d.String() != ls[0].Hash.String() {
+			t.Errorf("verified: got: %v, want: %v", d, ls[0].Hash)
+		}
+		// The file stays until both have released it.
+		f.Close()
+		if !exists(pa) {
+			t.Fatal("layer removed while in use by another arena")
+		}
+		g.Close()
+		if exists(pa) {
+			t.Error("layer left after last release")
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		cl, ls := serveBlobs(t, "application/x-tar", tarballs(t, 1, size))
+		_, a, b := arenas(t, cl)
+		realize(t, a, ls[0])
+		g, lb := realize(t, b, ls[0])
+		defer g.Close()
+		if err := a.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if !exists(local(t, lb)) {
+			t.Error("layer removed by closing another arena")
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		bs := tarballs(t, 1, size)
+		cl, ls := serveBlobs(t, "application/x-tar", bs)
+		root, a, b := arenas(t, cl)
+		var wg sync.WaitGroup
+		fs := make([]*FetchProxy, 8)
+		lls := make([][]*claircore.Layer, len(fs))
+		errs := make([]error, len(fs))
+		for i := range fs {
+			arena := a
+			if i%2 == 1 {
+				arena = b
+			}
+			fs[i] = arena.Realizer(ctx).(*FetchProxy)
+			lls[i] = fresh(ls)
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = fs[i].Realize(ctx, lls[i])
+			}(i)
+		}
+		wg.Wait()
+		for i, err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Every layer is whole, whichever arena wrote it.
+			b, err := os.ReadFile(local(t, lls[i][0]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, bs[0]) {
+				t.Errorf("%d: layer contents differ", i)
+			}
+		}
+		for _, f := range fs {
+			f.Close()
+		}
+		ents, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range ents {
+			t.Errorf("file left in root: %s", e.Name())
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package libindex
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/quay/zlog"
+)
+
+// Shutdown stops the arena from fetching any more layers and waits for every
+// FetchProxy to release the layers it holds, then removes all files left in
+// the arena.
+//
+// Once Shutdown is called, fetches report ErrShutdown. If the Context is done
+// before all layers are released, only unreferenced layers are removed and
+// the Context's error is reported; the remaining layers are removed as their
+// FetchProxies are closed.
+func (a *RemoteFetchArena) Shutdown(ctx context.Context) error {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/fetchArena.Shutdown",
+		"arena", a.root)
+	a.mu.Lock()
+	a.closing = true
+	a.stopSweep()
+	var wait chan struct{}
+	if n := len(a.rc); n != 0 {
+		if a.drained == nil {
+			a.drained = make(chan struct{})
+		}
+		wait = a.drained
+		zlog.Info(ctx).
+			Int("count", n).
+			Msg("waiting for layers to be released")
+	}
+	a.mu.Unlock()
+
+	if wait != nil {
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			n := len(a.rc)
+			if err := a.clearIdle(); err != nil {
+				zlog.Warn(ctx).
+					Err(err).
+					Msg("unable to remove idle layers")
+			}
+			return fmt.Errorf("fetcher: %d layers still in use: %w", n, ctx.Err())
+		}
+	}
+	return a.Close(ctx)
+}
+
+// CheckClosing reports ErrShutdown if Shutdown has been called.
+func (a *RemoteFetchArena) checkClosing() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closing {
+		return fmt.Errorf("fetcher: %w", ErrShutdown)
+	}
+	return nil
+}
+
+// ClearIdle removes all retained layers that aren't in use.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) clearIdle() error {
+	var err error
+	for d, il := range a.idle {
+		if e := a.removeIdle(d, il); e != nil {
+			if err == nil {
+				err = e
+			} else {
+				err = fmt.Errorf("%v; %v", err, e)
+			}
+		}
+	}
+	return err
+}
+
+// RemoveIdle removes a retained layer and everything stored alongside it.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) removeIdle(d string, il *idleLayer) error {
+	var err error
+	delete(a.idle, d)
+	delete(a.verified, d)
+	delete(a.diffIDs, d)
+	delete(a.compressed, d)
+	delete(a.fetched, d)
+	if e := a.removeBlob(d); e != nil {
+		err = e
+	}
+	if e := a.removeTarIndex(d); e != nil {
+		if err == nil {
+			err = e
+		} else {
+			err = fmt.Errorf("%v; %v", err, e)
+		}
+	}
+	if e := os.Remove(il.path(a.root, d)); e != nil {
+		if err == nil {
+			err = e
+		} else {
+			err = fmt.Errorf("%v; %v", err, e)
+		}
+	}
+	return err
+}
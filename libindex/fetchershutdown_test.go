@@ -0,0 +1,70 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchShutdown(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	c, l := serveBlob(t, "", tarball(t, "layer contents"))
+	root := t.TempDir()
+	a := NewRemoteFetchArena(c, root)
+	f := a.Realizer(ctx)
+	if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+		t.Fatal(err)
+	}
+	count := func() int {
+		t.Helper()
+		ents, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(ents)
+	}
+	if count() == 0 {
+		t.Fatal("no files in arena after fetch")
+	}
+
+	// The FetchProxy still holds the layer, so Shutdown must give up without
+	// removing it.
+	sctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := a.Shutdown(sctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if count() == 0 {
+		t.Error("layer removed while in use")
+	}
+	rd, err := l.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd.Close()
+
+	// New fetches are refused.
+	f2 := a.Realizer(ctx)
+	_, l2 := serveBlob(t, "", tarball(t, "other contents"))
+	if err := f2.Realize(ctx, []*claircore.Layer{l2}); !errors.Is(err, ErrShutdown) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	f2.Close()
+
+	// Releasing the layer removes it.
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("%d files left in arena", n)
+	}
+	if err := a.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,69 @@
+package libindex
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/quay/claircore/indexer"
+)
+
+var _ indexer.TempFiler = (*FetchProxy)(nil)
+
+// Dir reports the directory holding the files for a layer owned by the arena.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) dir(digest string) string {
+	if _, ok := a.spooled[digest]; ok {
+		return a.spoolDir
+	}
+	return a.root
+}
+
+// Unspool moves a layer out of the spool directory and into the root, so it
+// can be retained, reporting whether that worked. Layers that aren't spooled
+// are left alone.
+//
+// Moving is only a rename, so this fails if the spool directory is on a
+// different filesystem than the root. A layer that can't be moved is left in
+// the spool directory.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) unspool(digest string) bool {
+	if _, ok := a.spooled[digest]; !ok {
+		return true
+	}
+	src, dst := filepath.Join(a.spoolDir, digest), filepath.Join(a.root, digest)
+	if err := os.Rename(src, dst); err != nil {
+		return false
+	}
+	delete(a.spooled, digest)
+	// The sidecar files are on the same filesystem as the layer was, so
+	// these failing is unexpected. Drop anything that didn't make it.
+	if _, ok := a.formats[digest]; ok {
+		if err := os.Rename(src+blobSuffix, dst+blobSuffix); err != nil {
+			delete(a.formats, digest)
+			os.Remove(src + blobSuffix)
+		}
+	}
+	if a.tarIndex {
+		err := os.Rename(src+tarIndexSuffix, dst+tarIndexSuffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(src + tarIndexSuffix)
+		}
+	}
+	return true
+}
+
+// TempFile implements indexer.TempFiler.
+//
+// The file is created where fetched layers are written: the spool directory
+// if there is one, otherwise the root. Its name is prefixed like the arena's
+// own temporary files, so Clean removes it if it's left behind.
+func (p *FetchProxy) TempFile(pattern string) (*os.File, error) {
+	dir := p.a.root
+	if p.a.spoolDir != "" {
+		dir = p.a.spoolDir
+	}
+	return os.CreateTemp(dir, "fetch."+pattern)
+}
@@ -0,0 +1,170 @@
+package libindex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/pkg/tarfs"
+)
+
+func TestFetchSpoolDir(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const threshold = 16 * 1024
+	gz := func(b []byte) []byte {
+		var out bytes.Buffer
+		zw := gzip.NewWriter(&out)
+		zw.Write(b)
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	count := func(t *testing.T, dir string) int {
+		t.Helper()
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range ents {
+			t.Logf("%s: %s", dir, e.Name())
+		}
+		return len(ents)
+	}
+	tt := []struct {
+		name  string
+		ct    string
+		body  []byte
+		where string
+	}{
+		{name: "Small", ct: "application/x-tar", body: tarball(t, "small"), where: "memory"},
+		{name: "Large", ct: "application/x-tar", body: tarball(t, strings.Repeat("x", 4*threshold)), where: "spool"},
+		// This compresses far better than estimated, so it starts out in
+		// memory and has to be moved to the spool directory.
+		{name: "Spill", ct: "application/vnd.oci.image.layer.v1.tar+gzip", body: gz(tarball(t, strings.Repeat("x", 16*threshold))), where: "spool"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, tc.ct, tc.body)
+			root, spool := t.TempDir(), t.TempDir()
+			a := NewRemoteFetchArena(c, root, WithMemoryThreshold(threshold), WithSpoolDir(spool))
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+				t.Fatal(err)
+			}
+			rd, err := l.Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, onDisk := rd.(*os.File)
+			if got, want := !onDisk, tc.where == "memory"; got != want {
+				t.Errorf("in memory: got: %v, want: %v", got, want)
+			}
+			if _, err := tarfs.New(rd); err != nil {
+				t.Error(err)
+			}
+			rd.Close()
+			if got := count(t, root); got != 0 {
+				t.Errorf("files in root: got: %d, want: 0", got)
+			}
+			want := 0
+			if tc.where == "spool" {
+				want = 1
+			}
+			if got := count(t, spool); got != want {
+				t.Errorf("files in spool: got: %d, want: %d", got, want)
+			}
+
+			if err := f.Close(); err != nil {
+				t.Error(err)
+			}
+			if got := count(t, spool); got != 0 {
+				t.Errorf("files left in spool: %d", got)
+			}
+		})
+	}
+
+	t.Run("Retained", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "application/x-tar", tarball(t, strings.Repeat("x", 4*threshold)))
+		root, spool := t.TempDir(), t.TempDir()
+		a := NewRemoteFetchArena(c, root, WithSpoolDir(spool), WithRetainIdle(), WithTarIndex())
+		f := a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := count(t, spool), 2; got != want {
+			t.Errorf("files in spool: got: %d, want: %d", got, want)
+		}
+		f.Close()
+
+		// Retaining the layer moves it, and its index, into the root.
+		if got := count(t, spool); got != 0 {
+			t.Errorf("files left in spool: %d", got)
+		}
+		if got, want := count(t, root), 2; got != want {
+			t.Errorf("files in root: got: %d, want: %d", got, want)
+		}
+
+		f = a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.Entry(l.Hash, "file"); err != nil {
+			t.Error(err)
+		}
+		f.Close()
+		if err := a.Close(ctx); err != nil {
+			t.Error(err)
+		}
+		if got := count(t, root) + count(t, spool); got != 0 {
+			t.Errorf("files left: %d", got)
+		}
+	})
+}
+
+func TestFetchProxyTempFile(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	root, spool := t.TempDir(), t.TempDir()
+	for _, tc := range []struct {
+		name string
+		opts []ArenaOption
+		want string
+	}{
+		{name: "Root", want: root},
+		{name: "Spool", opts: []ArenaOption{WithSpoolDir(spool)}, want: spool},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := NewRemoteFetchArena(http.DefaultClient, root, tc.opts...)
+			defer a.Close(ctx)
+			p := a.Realizer(ctx).(*FetchProxy)
+			defer p.Close()
+			f, err := p.TempFile("test.*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			defer os.Remove(f.Name())
+			if got, want := filepath.Dir(f.Name()), tc.want; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			// Files left behind are removed by Clean.
+			a.mu.Lock()
+			stale := a.stale(filepath.Base(f.Name()))
+			a.mu.Unlock()
+			if !stale {
+				t.Errorf("%q not considered stale", f.Name())
+			}
+		})
+	}
+}
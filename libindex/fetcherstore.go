@@ -0,0 +1,158 @@
+package libindex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/quay/claircore"
+)
+
+// LayerStore holds verified, decompressed layers by digest, outside of any
+// one arena. See WithLayerStore.
+//
+// Implementations must be safe for concurrent use, and should be safe to
+// share between processes: a layer must never be visible to Open until all of
+// it has been Put.
+type LayerStore interface {
+	// Put stores the "size" bytes read from "r" as the layer with the
+	// provided digest, replacing any stored copy.
+	Put(ctx context.Context, d claircore.Digest, r io.Reader, size int64) error
+	// Open returns the stored layer with the provided digest. If it's not
+	// stored, the returned error satisfies errors.Is(err, fs.ErrNotExist).
+	Open(ctx context.Context, d claircore.Digest) (io.ReadCloser, error)
+	// Remove removes the stored layer with the provided digest. Removing a
+	// layer that isn't stored isn't an error.
+	Remove(ctx context.Context, d claircore.Digest) error
+}
+
+// FileStore is implemented by LayerStores keeping layers as local files,
+// which the arena can link instead of copying.
+type fileStore interface {
+	// Get links or copies the stored layer into a new file in "dir",
+	// reporting whether the layer was stored.
+	get(h, dir string) (string, bool, error)
+	// Put adds the file holding the layer to the store.
+	put(ctx context.Context, h, src string) error
+}
+
+var (
+	_ LayerStore = (*layerCache)(nil)
+	_ fileStore  = (*layerCache)(nil)
+	_ LayerStore = (*memoryStore)(nil)
+	_ LayerStore = (*S3LayerStore)(nil)
+)
+
+// NewDiskLayerStore returns a LayerStore keeping layers in the named
+// directory, removing the least recently used ones once it holds more than
+// "size" bytes. A value less than 1 uses the default of 10 GiB.
+//
+// This is the store used by WithLayerCache; see there for details.
+func NewDiskLayerStore(dir string, size int64) LayerStore {
+	if size < 1 {
+		size = defaultLayerCacheSize
+	}
+	return &layerCache{dir: dir, max: size}
+}
+
+// NewMemoryLayerStore returns a LayerStore keeping layers in memory, removing
+// the least recently used ones once it holds more than "size" bytes. A value
+// less than 1 uses the default of 1 GiB.
+//
+// Layers larger than the limit aren't stored. The store only lasts as long as
+// the process, but can be shared by all the arenas in it. To have layers
+// outlive the process on a memory-backed filesystem such as tmpfs, use
+// NewDiskLayerStore with a directory there instead.
+func NewMemoryLayerStore(size int64) LayerStore {
+	if size < 1 {
+		size = defaultMemoryStoreSize
+	}
+	return &memoryStore{
+		max:    size,
+		layers: make(map[string]*memoryLayer),
+	}
+}
+
+// DefaultMemoryStoreSize is used when NewMemoryLayerStore is passed a
+// non-positive size.
+const defaultMemoryStoreSize = 1024 * 1024 * 1024
+
+// MemoryStore is a LayerStore in memory.
+type memoryStore struct {
+	max int64
+
+	mu     sync.Mutex
+	size   int64
+	tick   uint64
+	layers map[string]*memoryLayer
+}
+
+// MemoryLayer is a layer held by a memoryStore. Used is the store's tick at
+// the layer's last use.
+type memoryLayer struct {
+	b    []byte
+	used uint64
+}
+
+// Put implements LayerStore.
+func (s *memoryStore) Put(ctx context.Context, d claircore.Digest, r io.Reader, size int64) error {
+	if size > s.max {
+		return nil
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return fmt.Errorf("fetcher: unable to read layer: %w", err)
+	}
+	h := d.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.layers[h]; ok {
+		s.size -= int64(len(old.b))
+	}
+	s.tick++
+	s.layers[h] = &memoryLayer{b: b, used: s.tick}
+	s.size += size
+	for s.size > s.max {
+		var oldest string
+		var used uint64
+		for h, l := range s.layers {
+			if oldest == "" || l.used < used {
+				oldest, used = h, l.used
+			}
+		}
+		s.size -= int64(len(s.layers[oldest].b))
+		delete(s.layers, oldest)
+	}
+	return nil
+}
+
+// Open implements LayerStore.
+//
+// The returned ReadCloser shares memory with the store, but layers are never
+// modified once stored.
+func (s *memoryStore) Open(ctx context.Context, d claircore.Digest) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.layers[d.String()]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: d.String(), Err: fs.ErrNotExist}
+	}
+	s.tick++
+	l.used = s.tick
+	return io.NopCloser(bytes.NewReader(l.b)), nil
+}
+
+// Remove implements LayerStore.
+func (s *memoryStore) Remove(ctx context.Context, d claircore.Digest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := d.String()
+	if l, ok := s.layers[h]; ok {
+		s.size -= int64(len(l.b))
+		delete(s.layers, h)
+	}
+	return nil
+}
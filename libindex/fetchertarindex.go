@@ -0,0 +1,217 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/quay/claircore"
+)
+
+// TarIndexSuffix is appended to a layer's file name to name the layer's index
+// of tar entries.
+const tarIndexSuffix = ".idx"
+
+// TarEntry describes one entry in a layer, as recorded in the layer's index.
+type TarEntry struct {
+	// Name is the cleaned path of the entry, without a leading "/" or "./".
+	Name string `json:"name"`
+	// Linkname is the target of a link.
+	Linkname string `json:"linkname,omitempty"`
+	// Offset is where the entry's contents start in the layer.
+	Offset int64 `json:"offset"`
+	// Size is the length of the entry's contents.
+	Size int64 `json:"size"`
+	// Type is the tar typeflag of the entry.
+	Type byte `json:"type"`
+}
+
+// CleanEntryName puts a name into the form used in the tar index.
+func cleanEntryName(n string) string {
+	n = path.Clean("/" + n)
+	return n[1:]
+}
+
+// BuildTarIndex walks the tar in "r" and records the location of every entry.
+func buildTarIndex(r io.ReadSeeker) ([]TarEntry, error) {
+	var out []TarEntry
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return out, nil
+		default:
+			return nil, err
+		}
+		// The tar.Reader stops reading at the end of the header blocks, so
+		// the current position is the start of the contents.
+		off, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		e := TarEntry{
+			Name:     cleanEntryName(h.Name),
+			Linkname: h.Linkname,
+			Offset:   off,
+			Size:     h.Size,
+			Type:     h.Typeflag,
+		}
+		if e.Type == tar.TypeGNUSparse || len(h.PAXRecords["GNU.sparse.map"]) != 0 || h.PAXRecords["GNU.sparse.major"] != "" {
+			// Sparse files can't be read as a single run of bytes, so they're
+			// left out.
+			continue
+		}
+		out = append(out, e)
+	}
+}
+
+// WriteTarIndex builds the index for the tar in "f" and writes it alongside.
+func writeTarIndex(f *os.File, size int64) error {
+	idx, err := buildTarIndex(io.NewSectionReader(f, 0, size))
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(f.Name()+tarIndexSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	if err := json.NewEncoder(w).Encode(idx); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return noSpace(err)
+	}
+	return out.Close()
+}
+
+// RemoveTarIndex removes the layer's index, if any.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) removeTarIndex(digest string) error {
+	delete(a.entries, digest)
+	if !a.tarIndex {
+		return nil
+	}
+	err := os.Remove(filepath.Join(a.dir(digest), digest) + tarIndexSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// LoadTarIndex returns the index for a layer held by the arena, reading it in
+// if needed.
+func (a *RemoteFetchArena) loadTarIndex(d claircore.Digest) (map[string]TarEntry, error) {
+	h := d.String()
+	notExist := &fs.PathError{Op: "index", Path: h, Err: fs.ErrNotExist}
+	a.mu.Lock()
+	if _, ok := a.rc[h]; !ok || !a.tarIndex || !a.onDisk(h) {
+		a.mu.Unlock()
+		return nil, notExist
+	}
+	if idx, ok := a.entries[h]; ok {
+		a.mu.Unlock()
+		return idx, nil
+	}
+	p := filepath.Join(a.dir(h), h) + tarIndexSuffix
+	a.mu.Unlock()
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var es []TarEntry
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&es); err != nil {
+		return nil, fmt.Errorf("fetcher: bad tar index for %v: %w", h, err)
+	}
+	// Later entries replace earlier ones, as when extracting the tar.
+	idx := make(map[string]TarEntry, len(es))
+	for _, e := range es {
+		idx[e.Name] = e
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.rc[h]; !ok {
+		// Released while the index was being read.
+		return nil, notExist
+	}
+	a.entries[h] = idx
+	return idx, nil
+}
+
+// Entry looks up the named file in the index of a layer held by the arena.
+//
+// Only layers fetched with WithTarIndex while the arena is holding them have
+// an index. The returned error reports fs.ErrNotExist if there's no index or
+// no such entry.
+func (a *RemoteFetchArena) Entry(d claircore.Digest, name string) (TarEntry, error) {
+	idx, err := a.loadTarIndex(d)
+	if err != nil {
+		return TarEntry{}, err
+	}
+	e, ok := idx[cleanEntryName(name)]
+	if !ok {
+		return TarEntry{}, &fs.PathError{Op: "entry", Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+// OpenEntry returns the contents of the named regular file in a layer held by
+// the arena, using the layer's index to read it without walking the tar. Hard
+// links are followed.
+//
+// See Entry for which layers have an index.
+func (a *RemoteFetchArena) OpenEntry(d claircore.Digest, name string) (io.ReadCloser, error) {
+	idx, err := a.loadTarIndex(d)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := idx[cleanEntryName(name)]
+	if ok && e.Type == tar.TypeLink {
+		e, ok = idx[cleanEntryName(e.Linkname)]
+	}
+	switch {
+	case !ok:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case e.Type != tar.TypeReg && e.Type != tar.TypeRegA:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	h := d.String()
+	a.mu.Lock()
+	p := filepath.Join(a.dir(h), h)
+	a.mu.Unlock()
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	return &entryReader{
+		SectionReader: io.NewSectionReader(f, e.Offset, e.Size),
+		f:             f,
+	}, nil
+}
+
+// EntryReader reads one entry out of a layer file.
+type entryReader struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (r *entryReader) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,164 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchTarIndex(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	tw := tar.NewWriter(zw)
+	files := []struct {
+		h    tar.Header
+		body string
+	}{
+		{h: tar.Header{Name: "./etc/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{h: tar.Header{Name: "./etc/os-release", Typeflag: tar.TypeReg, Mode: 0o644}, body: "ID=test\n"},
+		{h: tar.Header{Name: "usr/lib/big", Typeflag: tar.TypeReg, Mode: 0o644}, body: string(bytes.Repeat([]byte("x"), 10000))},
+		{h: tar.Header{Name: "usr/lib/hard", Typeflag: tar.TypeLink, Linkname: "./etc/os-release"}},
+		{h: tar.Header{Name: "usr/lib/soft", Typeflag: tar.TypeSymlink, Linkname: "../../etc/os-release"}},
+		{h: tar.Header{Name: "usr/lib/after", Typeflag: tar.TypeReg, Mode: 0o644}, body: "after\n"},
+	}
+	for _, f := range files {
+		h := f.h
+		h.Size = int64(len(f.body))
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, f.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Lookup", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", gz.Bytes())
+		root := t.TempDir()
+		a := NewRemoteFetchArena(c, root, WithTarIndex())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		idx := filepath.Join(root, l.Hash.String()) + tarIndexSuffix
+		if _, err := os.Stat(idx); err != nil {
+			t.Fatal(err)
+		}
+
+		e, err := a.Entry(l.Hash, "/usr/lib/big")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := e.Size, int64(10000); got != want {
+			t.Errorf("size: got: %d, want: %d", got, want)
+		}
+		if _, err := a.Entry(l.Hash, "nonexistent"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got: %v, want: %v", err, fs.ErrNotExist)
+		}
+
+		for _, tc := range []struct {
+			name, want string
+		}{
+			{"etc/os-release", "ID=test\n"},
+			{"./usr/lib/after", "after\n"},
+			{"usr/lib/hard", "ID=test\n"},
+			{"usr/lib/big", string(bytes.Repeat([]byte("x"), 10000))},
+		} {
+			rc, err := a.OpenEntry(l.Hash, tc.name)
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+				continue
+			}
+			b, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			if got := string(b); got != tc.want {
+				t.Errorf("%s: got: %q, want: %q", tc.name, got, tc.want)
+			}
+		}
+		if _, err := a.OpenEntry(l.Hash, "usr/lib/soft"); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("got: %v, want: %v", err, fs.ErrInvalid)
+		}
+
+		// Releasing the layer removes the index with it.
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Stat(idx); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("index left behind: %v", err)
+		}
+		if _, err := a.Entry(l.Hash, "etc/os-release"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got: %v, want: %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("Retained", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", gz.Bytes())
+		root := t.TempDir()
+		a := NewRemoteFetchArena(c, root, WithTarIndex(), WithRetainIdle())
+		f := a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		idx := filepath.Join(root, l.Hash.String()) + tarIndexSuffix
+		if _, err := os.Stat(idx); err != nil {
+			t.Fatalf("index not retained: %v", err)
+		}
+
+		// A reused layer keeps its index.
+		f = a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.Entry(l.Hash, "etc/os-release"); err != nil {
+			t.Error(err)
+		}
+		f.Close()
+
+		if err := a.Close(ctx); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Stat(idx); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("index left behind: %v", err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, "application/vnd.oci.image.layer.v1.tar+gzip", gz.Bytes())
+		a := NewRemoteFetchArena(c, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.Entry(l.Hash, "etc/os-release"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("got: %v, want: %v", err, fs.ErrNotExist)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package libindex
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// MaxThrottleBurst caps the burst size of the bandwidth limiters, so that a
+// high limit doesn't let a single read take a large chunk of a second's
+// allowance at once.
+const maxThrottleBurst = 256 * 1024
+
+// Throttle limits the rate layers are read at, across the arena and for each
+// host. See WithBandwidthLimit.
+type throttle struct {
+	total   *rate.Limiter
+	perHost rate.Limit
+
+	mu    sync.Mutex
+	hosts map[string]*rate.Limiter
+}
+
+func newThrottle(total, perHost int64) *throttle {
+	t := &throttle{
+		hosts: make(map[string]*rate.Limiter),
+	}
+	if total > 0 {
+		t.total = newLimiter(total)
+	}
+	if perHost > 0 {
+		t.perHost = rate.Limit(perHost)
+	}
+	return t
+}
+
+// NewLimiter returns a limiter for "n" bytes per second, with a burst of at
+// most a second's worth.
+func newLimiter(n int64) *rate.Limiter {
+	b := n
+	if b > maxThrottleBurst {
+		b = maxThrottleBurst
+	}
+	return rate.NewLimiter(rate.Limit(n), int(b))
+}
+
+// Limiters returns the limiters that apply to reads from "host".
+func (t *throttle) limiters(host string) []*rate.Limiter {
+	var ls []*rate.Limiter
+	if t.perHost != 0 {
+		t.mu.Lock()
+		l, ok := t.hosts[host]
+		if !ok {
+			l = newLimiter(int64(t.perHost))
+			t.hosts[host] = l
+		}
+		t.mu.Unlock()
+		ls = append(ls, l)
+	}
+	if t.total != nil {
+		ls = append(ls, t.total)
+	}
+	return ls
+}
+
+// Reader wraps a response body from "host" so that it's read no faster than
+// the limits allow. Reads block until there's room, or "ctx" is done.
+func (t *throttle) reader(ctx context.Context, host string, body io.ReadCloser) io.ReadCloser {
+	ls := t.limiters(host)
+	if len(ls) == 0 {
+		return body
+	}
+	burst := ls[0].Burst()
+	for _, l := range ls[1:] {
+		if b := l.Burst(); b < burst {
+			burst = b
+		}
+	}
+	return &throttledReader{ctx: ctx, body: body, ls: ls, burst: burst}
+}
+
+// ThrottledReader is the ReadCloser returned by throttle.reader.
+type throttledReader struct {
+	ctx   context.Context
+	body  io.ReadCloser
+	ls    []*rate.Limiter
+	burst int
+}
+
+// Read implements io.Reader.
+//
+// The bytes read are paid for after the fact, so a read is never held up
+// waiting for room it ends up not using.
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.burst {
+		p = p[:r.burst]
+	}
+	n, err := r.body.Read(p)
+	if n > 0 {
+		for _, l := range r.ls {
+			if werr := l.WaitN(r.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (r *throttledReader) Close() error {
+	return r.body.Close()
+}
+
+// Throttled wraps "body", read from the response, if the arena has bandwidth
+// limits.
+func (a *RemoteFetchArena) throttled(ctx context.Context, resp *http.Response, body io.ReadCloser) io.ReadCloser {
+	if a.throttle == nil {
+		return body
+	}
+	var host string
+	if resp.Request != nil {
+		host = resp.Request.URL.Host
+	}
+	return a.throttle.reader(ctx, host, body)
+}
@@ -0,0 +1,54 @@
+package libindex
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+)
+
+func TestFetchBandwidthLimit(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const limit = 16 * 1024
+	tt := []struct {
+		name           string
+		total, perHost int64
+	}{
+		{name: "Total", total: limit},
+		{name: "PerHost", perHost: limit},
+		{name: "Both", total: 4 * limit, perHost: limit},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			// Two layers from the same host, fetched concurrently.
+			bs := tarballs(t, 2, limit)
+			cl, ls := serveBlobs(t, "application/x-tar", bs)
+			a := NewRemoteFetchArena(cl, t.TempDir(), WithBandwidthLimit(tc.total, tc.perHost))
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			start := time.Now()
+			if err := f.Realize(ctx, fresh(ls)); err != nil {
+				t.Fatal(err)
+			}
+			took := time.Since(start)
+			// The first second's worth is allowed as a burst.
+			sz := len(bs[0]) + len(bs[1])
+			want := time.Duration(float64(sz-limit) / limit * float64(time.Second))
+			t.Logf("fetched %d bytes in %v", sz, took)
+			if took < want*9/10 {
+				t.Errorf("fetched too quickly: got: %v, want: at least %v", took, want)
+			}
+		})
+	}
+
+	t.Run("Unlimited", func(t *testing.T) {
+		a := NewRemoteFetchArena(nil, t.TempDir(), WithBandwidthLimit(limit, limit), WithBandwidthLimit(0, -1))
+		defer a.Close(ctx)
+		if a.throttle != nil {
+			t.Error("unexpected throttle")
+		}
+	})
+}
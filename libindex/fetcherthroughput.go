@@ -0,0 +1,103 @@
+package libindex
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// ThroughputBuckets is the number of parts a throughput window is divided
+// into. The window slides forward by one bucket at a time.
+const throughputBuckets = 4
+
+// DefaultThroughputWindow is used when WithMinThroughput is passed a
+// non-positive window.
+const defaultThroughputWindow = 30 * time.Second
+
+// ThroughputMonitor wraps a response body and cancels the request if fewer
+// than the minimum number of bytes are read over any window.
+type throughputMonitor struct {
+	body   io.ReadCloser
+	cancel context.CancelFunc
+	min    int64
+	window time.Duration
+	stop   chan struct{}
+	once   sync.Once
+
+	mu   sync.Mutex
+	n    int64
+	slow error
+}
+
+// NewThroughputMonitor starts monitoring the body. The provided CancelFunc
+// must cancel the request the body belongs to.
+func newThroughputMonitor(body io.ReadCloser, cancel context.CancelFunc, rate int64, window time.Duration) *throughputMonitor {
+	m := &throughputMonitor{
+		body:   body,
+		cancel: cancel,
+		min:    int64(float64(rate) * window.Seconds()),
+		window: window,
+		stop:   make(chan struct{}),
+	}
+	go m.watch()
+	return m
+}
+
+// Watch samples the byte count once per bucket and compares the total over
+// the last window to the minimum.
+func (m *throughputMonitor) watch() {
+	t := time.NewTicker(m.window / throughputBuckets)
+	defer t.Stop()
+	var ring [throughputBuckets]int64
+	var prev int64
+	for i := 0; ; i++ {
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+		}
+		m.mu.Lock()
+		cur := m.n
+		m.mu.Unlock()
+		ring[i%throughputBuckets] = cur - prev
+		prev = cur
+		if i < throughputBuckets-1 {
+			// Not a full window yet.
+			continue
+		}
+		var sum int64
+		for _, n := range ring {
+			sum += n
+		}
+		if sum < m.min {
+			m.mu.Lock()
+			m.slow = &errTooSlow{n: sum, window: m.window, min: m.min}
+			m.mu.Unlock()
+			m.cancel()
+			return
+		}
+	}
+}
+
+// Read implements io.Reader.
+func (m *throughputMonitor) Read(p []byte) (int, error) {
+	n, err := m.body.Read(p)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.n += int64(n)
+	if err != nil && err != io.EOF && m.slow != nil {
+		// Report why the request was canceled instead of the
+		// cancellation itself.
+		err = m.slow
+	}
+	return n, err
+}
+
+// Close stops the monitor and closes the body.
+func (m *throughputMonitor) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	err := m.body.Close()
+	m.cancel()
+	return err
+}
@@ -0,0 +1,104 @@
+package libindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchMinThroughput(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	b := tarball(t, strings.Repeat("x", 1024))
+	sum := sha256.Sum256(b)
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/x-tar")
+		if r.URL.Path == "/fast" {
+			w.Write(b)
+			return
+		}
+		// Trickle out the layer, 16 bytes every 10ms.
+		f := w.(http.Flusher)
+		buf := b
+		for len(buf) > 0 {
+			n := 16
+			if n > len(buf) {
+				n = len(buf)
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			f.Flush()
+			buf = buf[n:]
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer srv.Close()
+	layer := func(p string) *claircore.Layer {
+		return &claircore.Layer{
+			Hash:    d,
+			URI:     srv.URL + p,
+			Headers: make(http.Header),
+		}
+	}
+
+	t.Run("Slow", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(srv.Client(), t.TempDir(), WithMinThroughput(1<<20, 200*time.Millisecond))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		start := time.Now()
+		err := f.Realize(ctx, []*claircore.Layer{layer("/slow")})
+		t.Logf("%v after %v", err, time.Since(start))
+		if !errors.Is(err, ErrTooSlow) {
+			t.Errorf("got: %v, want: %v", err, ErrTooSlow)
+		}
+	})
+
+	t.Run("Fast", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(srv.Client(), t.TempDir(), WithMinThroughput(1<<20, 200*time.Millisecond))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		l := layer("/fast")
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if !l.Fetched() {
+			t.Error("layer not fetched")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(srv.Client(), t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		l := layer("/slow")
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		if !l.Fetched() {
+			t.Error("layer not fetched")
+		}
+	})
+}
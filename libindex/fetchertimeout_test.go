@@ -0,0 +1,120 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+)
+
+func TestFetchTimeout(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const delay = 100 * time.Millisecond
+
+	t.Run("Queued", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		// Only one layer is fetched at a time, so the last one waits for
+		// the others: several times longer than the fetch timeout.
+		cl, ls := serveBlobs(t, "application/x-tar", tarballs(t, 5, 1), withDelay(delay))
+		a := NewRemoteFetchArena(cl, t.TempDir(),
+			WithArenaConcurrency(1),
+			WithFetchTimeout(4*delay, 0))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		start := time.Now()
+		if err := f.Realize(ctx, ls); err != nil {
+			t.Fatal(err)
+		}
+		if el := time.Since(start); el < 4*delay {
+			t.Errorf("fetches weren't serialized: took %v", el)
+		}
+	})
+
+	t.Run("Fetch", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		cl, ls := serveBlobs(t, "application/x-tar", tarballs(t, 1, 1), withDelay(delay))
+		a := NewRemoteFetchArena(cl, t.TempDir(), WithFetchTimeout(delay/4, 0))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, ls)
+		t.Log(err)
+		if !errors.Is(err, ErrFetchTimeout) {
+			t.Errorf("got: %v, want: %v", err, ErrFetchTimeout)
+		}
+		if errors.Is(err, ErrQueueTimeout) {
+			t.Errorf("fetch timeout reported as queue timeout: %v", err)
+		}
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		cl, ls := serveBlobs(t, "application/x-tar", tarballs(t, 2, 1), withDelay(delay))
+		a := NewRemoteFetchArena(cl, t.TempDir(),
+			WithArenaConcurrency(1),
+			WithBestEffort(),
+			WithFetchTimeout(0, delay/4))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, ls)
+		t.Log(err)
+		if !errors.Is(err, ErrQueueTimeout) {
+			t.Errorf("got: %v, want: %v", err, ErrQueueTimeout)
+		}
+		if errors.Is(err, ErrFetchTimeout) {
+			t.Errorf("queue timeout reported as fetch timeout: %v", err)
+		}
+		// The layer that got the slot isn't affected.
+		var fetched int
+		for _, l := range ls {
+			if l.Fetched() {
+				fetched++
+			}
+		}
+		if got, want := fetched, 1; got != want {
+			t.Errorf("fetched: got: %d, want: %d", got, want)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		var reqs int64
+		cl, ls := serveBlobs(t, "application/x-tar", tarballs(t, 1, 1), withDelay(delay), countRequests(&reqs))
+		root := t.TempDir()
+		a := NewRemoteFetchArena(cl, root, WithFetchTimeout(time.Minute, time.Minute))
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		cctx, cancel := context.WithTimeout(ctx, delay/4)
+		defer cancel()
+		err := f.Realize(cctx, ls)
+		t.Log(err)
+		if errors.Is(err, ErrFetchTimeout) || errors.Is(err, ErrQueueTimeout) {
+			t.Errorf("caller's deadline reported as an arena timeout: %v", err)
+		}
+		// Realize returns as soon as the Context is done, but the fetch
+		// itself winds down in the background. Its file is created before
+		// the request is sent, so once the request has been seen, wait for
+		// the file to be removed so it isn't racing the test's cleanup.
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			fs, err := filepath.Glob(filepath.Join(root, "fetch.*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if atomic.LoadInt64(&reqs) != 0 && len(fs) == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("abandoned fetch not cleaned up")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package libindex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/quay/claircore"
+)
+
+// DefaultTraceFileSize is used when WithTraceFile is passed a non-positive
+// size.
+const defaultTraceFileSize = 16 * 1024 * 1024
+
+// FetchTrace is the record of one layer fetch written by WithTraceFile.
+//
+// Stages that weren't reached are omitted. With WithDecompressWorkers, a
+// layer is verified before it's decompressed, so VerifyDone may come before
+// DecompressStart.
+type FetchTrace struct {
+	// Layer is the digest of the layer.
+	Layer string `json:"layer"`
+	// URL is the layer's URI, without any query or userinfo, as they may
+	// hold credentials.
+	URL string `json:"url"`
+	// Start is when the fetch was started, before waiting for any slots.
+	Start time.Time `json:"start"`
+	// RequestStart is when the request was sent.
+	RequestStart *time.Time `json:"request_start,omitempty"`
+	// FirstByte is when the start of the response body was received.
+	FirstByte *time.Time `json:"first_byte,omitempty"`
+	// DecompressStart is when decompression started.
+	DecompressStart *time.Time `json:"decompress_start,omitempty"`
+	// CopyDone is when the layer was done being written out.
+	CopyDone *time.Time `json:"copy_done,omitempty"`
+	// VerifyDone is when the layer's digest was checked.
+	VerifyDone *time.Time `json:"verify_done,omitempty"`
+	// End is when the fetch finished, successfully or not.
+	End time.Time `json:"end"`
+	// Compression is the detected encoding of the response.
+	Compression string `json:"compression,omitempty"`
+	// ContentLength is the length the response reported, or -1 if unknown.
+	ContentLength int64 `json:"content_length,omitempty"`
+	// Read is the number of bytes read off the network.
+	Read int64 `json:"read"`
+	// Written is the size of the decompressed layer.
+	Written int64 `json:"written"`
+	// Outcome is "ok" or "error".
+	Outcome string `json:"outcome"`
+	// Error is the error the fetch failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// NewTrace starts a FetchTrace for the layer. It returns nil if tracing isn't
+// enabled; all the fetchTrace methods are no-ops on a nil receiver.
+func (a *RemoteFetchArena) newTrace(l *claircore.Layer) *fetchTrace {
+	if a.trace == nil {
+		return nil
+	}
+	t := &fetchTrace{w: a.trace}
+	t.rec.Layer = l.Hash.String()
+	t.rec.URL = traceURL(l.URI)
+	t.rec.Start = time.Now()
+	return t
+}
+
+// TraceURL removes anything that may hold credentials from a URI.
+func traceURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	return u.String()
+}
+
+// FetchTrace collects a FetchTrace as a fetch progresses.
+type fetchTrace struct {
+	w   *traceWriter
+	rec FetchTrace
+}
+
+// TraceStage names one of the optional timestamps in a FetchTrace.
+type traceStage int
+
+const (
+	traceRequest traceStage = iota
+	traceFirstByte
+	traceDecompress
+	traceCopy
+	traceVerify
+)
+
+// Mark records the current time as when the stage was reached.
+func (t *fetchTrace) mark(s traceStage) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	switch s {
+	case traceRequest:
+		t.rec.RequestStart = &now
+	case traceFirstByte:
+		t.rec.FirstByte = &now
+	case traceDecompress:
+		t.rec.DecompressStart = &now
+	case traceCopy:
+		t.rec.CopyDone = &now
+	case traceVerify:
+		t.rec.VerifyDone = &now
+	}
+}
+
+// Stream records what's known about the response.
+func (t *fetchTrace) stream(st *layerStream) {
+	if t == nil {
+		return
+	}
+	t.rec.Compression = st.c.String()
+	t.rec.ContentLength = st.contentLength
+}
+
+// Finish completes the record and writes it out.
+func (t *fetchTrace) finish(st *layerStream, written int64, err error) {
+	if t == nil {
+		return
+	}
+	t.rec.End = time.Now()
+	if st != nil {
+		t.rec.Read = st.read.n
+	}
+	t.rec.Written = written
+	t.rec.Outcome = "ok"
+	if err != nil {
+		t.rec.Outcome = "error"
+		t.rec.Error = err.Error()
+	}
+	t.w.write(&t.rec)
+}
+
+// TraceWriter appends FetchTrace records to a file, one JSON object per line.
+//
+// Once the file would grow past the size limit, it's renamed with a ".1"
+// suffix, replacing any previous one, and a new file is started. At most
+// about twice the limit is kept on disk.
+type traceWriter struct {
+	path string
+	max  int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	// Err is the last error encountered, reported once.
+	err error
+}
+
+// Write appends a record. Errors are remembered for Close to report, as a
+// failure to write a trace shouldn't fail the fetch.
+func (w *traceWriter) write(rec *FetchTrace) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	b = append(b, '\n')
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f != nil && w.size > 0 && w.size+int64(len(b)) > w.max {
+		if err := w.rotate(); err != nil {
+			w.err = err
+			return
+		}
+	}
+	if w.f == nil {
+		if err := w.open(); err != nil {
+			w.err = err
+			return
+		}
+	}
+	n, err := w.f.Write(b)
+	w.size += int64(n)
+	if err != nil {
+		w.err = err
+	}
+}
+
+func (w *traceWriter) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+}
+
+// Open opens the trace file for appending. The caller must hold the lock.
+func (w *traceWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, fi.Size()
+	if w.size >= w.max {
+		return w.rotate()
+	}
+	return nil
+}
+
+// Rotate moves the current trace file aside. The caller must hold the lock.
+func (w *traceWriter) rotate() error {
+	if w.f != nil {
+		if err := w.f.Close(); err != nil {
+			return err
+		}
+		w.f = nil
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w.f, w.size = f, 0
+	return nil
+}
+
+// Close closes the trace file, reporting any error encountered writing
+// records since the last call. A later write opens the file again.
+func (w *traceWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.err
+	w.err = nil
+	if w.f != nil {
+		if cerr := w.f.Close(); err == nil {
+			err = cerr
+		}
+		w.f = nil
+	}
+	if err != nil {
+		return fmt.Errorf("fetcher: unable to write trace: %w", err)
+	}
+	return nil
+}
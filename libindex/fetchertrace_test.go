@@ -0,0 +1,196 @@
+package libindex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchTrace(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	c := []byte("trace me\n")
+	tb := tarball(t, string(c))
+	gz := gzipTarball(t, string(c))
+
+	// ReadTraces closes the arena, then reads back the records it wrote.
+	readTraces := func(t *testing.T, a *RemoteFetchArena, name string) []FetchTrace {
+		t.Helper()
+		if err := a.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var out []FetchTrace
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			t.Logf("%s", s.Bytes())
+			var rec FetchTrace
+			if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, rec)
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	// Ordered checks that the stages that were reached happened in order.
+	ordered := func(t *testing.T, rec *FetchTrace, stages ...*time.Time) {
+		t.Helper()
+		prev := rec.Start
+		for i, s := range stages {
+			if s == nil {
+				continue
+			}
+			if s.Before(prev) {
+				t.Errorf("stage %d (%v) before previous stage (%v)", i, s, prev)
+			}
+			prev = *s
+		}
+		if rec.End.Before(prev) {
+			t.Errorf("end (%v) before last stage (%v)", rec.End, prev)
+		}
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		cl, l := serveBlob(t, "application/gzip", gz)
+		l.URI += "?X-Amz-Signature=secret"
+		name := filepath.Join(t.TempDir(), "trace.jsonl")
+		a := NewRemoteFetchArena(cl, t.TempDir(), WithTraceFile(name, 0))
+		f := a.Realizer(ctx)
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		recs := readTraces(t, a, name)
+		if got, want := len(recs), 1; got != want {
+			t.Fatalf("records: got: %d, want: %d", got, want)
+		}
+		rec := &recs[0]
+		if got, want := rec.Layer, l.Hash.String(); got != want {
+			t.Errorf("layer: got: %q, want: %q", got, want)
+		}
+		if strings.Contains(rec.URL, "secret") {
+			t.Errorf("query leaked into trace: %q", rec.URL)
+		}
+		if got, want := rec.Outcome, "ok"; got != want {
+			t.Errorf("outcome: got: %q, want: %q (%s)", got, want, rec.Error)
+		}
+		if got, want := rec.Compression, "gzip"; got != want {
+			t.Errorf("compression: got: %q, want: %q", got, want)
+		}
+		if got, want := rec.Read, int64(len(gz)); got != want {
+			t.Errorf("read: got: %d, want: %d", got, want)
+		}
+		if got, want := rec.Written, int64(len(tb)); got != want {
+			t.Errorf("written: got: %d, want: %d", got, want)
+		}
+		stages := []*time.Time{rec.RequestStart, rec.FirstByte, rec.DecompressStart, rec.CopyDone, rec.VerifyDone}
+		for i, s := range stages {
+			if s == nil {
+				t.Errorf("stage %d missing", i)
+			}
+		}
+		ordered(t, rec, stages...)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		// Flip a bit in the file's contents, which leaves a valid tar with
+		// the wrong digest.
+		bad := append([]byte(nil), tb...)
+		bad[bytes.Index(bad, c)] ^= 0x20
+		cl, l := serveBlob(t, "application/x-tar", bad)
+		sum := sha256.Sum256(tb)
+		d, err := claircore.NewDigest("sha256", sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Hash = d
+		name := filepath.Join(t.TempDir(), "trace.jsonl")
+		a := NewRemoteFetchArena(cl, t.TempDir(), WithTraceFile(name, 0))
+		f := a.Realizer(ctx)
+		err = f.Realize(ctx, []*claircore.Layer{l})
+		f.Close()
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Fatalf("got: %v, want: %v", err, ErrDigestMismatch)
+		}
+		recs := readTraces(t, a, name)
+		if got, want := len(recs), 1; got != want {
+			t.Fatalf("records: got: %d, want: %d", got, want)
+		}
+		rec := &recs[0]
+		if got, want := rec.Outcome, "error"; got != want {
+			t.Errorf("outcome: got: %q, want: %q", got, want)
+		}
+		if !strings.Contains(rec.Error, ErrDigestMismatch.Error()) {
+			t.Errorf("error: got: %q, want mention of %q", rec.Error, ErrDigestMismatch)
+		}
+		if got, want := rec.Compression, "tar"; got != want {
+			t.Errorf("compression: got: %q, want: %q", got, want)
+		}
+		if got, want := rec.Read, int64(len(bad)); got != want {
+			t.Errorf("read: got: %d, want: %d", got, want)
+		}
+		if rec.CopyDone == nil {
+			t.Error("copy stage missing")
+		}
+		if rec.VerifyDone != nil {
+			t.Error("verify stage recorded for a failed verification")
+		}
+		ordered(t, rec, rec.RequestStart, rec.FirstByte, rec.DecompressStart, rec.CopyDone)
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		dir := t.TempDir()
+		name := filepath.Join(dir, "trace.jsonl")
+		w := &traceWriter{path: name, max: 1024}
+		const n = 50
+		done := make(chan struct{})
+		for i := 0; i < n; i++ {
+			go func() {
+				defer func() { done <- struct{}{} }()
+				w.write(&FetchTrace{Layer: "sha256:" + strings.Repeat("0", 64), Outcome: "ok"})
+			}()
+		}
+		for i := 0; i < n; i++ {
+			<-done
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{name, name + ".1"} {
+			b, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) > 1024 {
+				t.Errorf("%s: %d bytes, want <= 1024", filepath.Base(p), len(b))
+			}
+			// Every line is a whole record, so writes weren't interleaved.
+			for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+				var rec FetchTrace
+				if err := json.Unmarshal(line, &rec); err != nil {
+					t.Errorf("%s: %v: %q", filepath.Base(p), err, line)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package libindex
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Layers in the wild sometimes have bytes after the compressed stream:
+// registries that pad blobs out to a block size, or junk from a broken
+// upload. The readers here decompress a layer, accept NUL padding after the
+// last member or frame, and report anything else as ErrTrailingData.
+//
+// Everything is read through the layer's buffered reader, so padding and
+// garbage are hashed along with the rest of the blob.
+
+// GzipMembers reads every member of a gzip stream.
+//
+// The gzip package reads concatenated members on its own, but can't tell the
+// end of the stream from garbage after it. This reads one member at a time
+// and looks at what follows each.
+type gzipMembers struct {
+	p   *decoderPool
+	z   *gzip.Reader
+	br  *bufio.Reader
+	err error
+}
+
+// NewGzipMembers returns a reader for the gzip stream in "br", using a gzip
+// reader from "p".
+func newGzipMembers(p *decoderPool, br *bufio.Reader) (*gzipMembers, error) {
+	z, err := p.getGzip(br)
+	if err != nil {
+		return nil, &errDecompress{c: cmpGzip, err: err}
+	}
+	z.Multistream(false)
+	return &gzipMembers{p: p, z: z, br: br}, nil
+}
+
+func (g *gzipMembers) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	n, err := g.z.Read(p)
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF):
+		err = g.next()
+	default:
+		err = &errDecompress{c: cmpGzip, err: err}
+	}
+	g.err = err
+	return n, err
+}
+
+// Next moves on to the next member, if there is one.
+func (g *gzipMembers) next() error {
+	b, err := g.br.Peek(2)
+	switch {
+	case len(b) == 0 && errors.Is(err, io.EOF):
+		return io.EOF
+	case len(b) == 2 && b[0] == 0x1f && b[1] == 0x8b:
+		if err := g.z.Reset(g.br); err != nil {
+			return &errDecompress{c: cmpGzip, err: err}
+		}
+		g.z.Multistream(false)
+		return nil
+	}
+	return trailing(g.br, cmpGzip)
+}
+
+// Close returns the gzip reader to the pool. The gzipMembers can't be used
+// afterwards.
+func (g *gzipMembers) Close() error {
+	if g.z == nil {
+		return nil
+	}
+	g.p.putGzip(g.z)
+	g.z = nil
+	g.err = errClosed
+	return nil
+}
+
+// ZstdFrames reads a zstd stream, telling padding and garbage after the last
+// frame from a corrupt stream.
+//
+// The decoder reads exactly as much as it needs, and after the last frame
+// tries to read another frame's magic number. Its input is watched so that if
+// that fails, the bytes it consumed can be checked.
+type zstdFrames struct {
+	p   *decoderPool
+	d   *pooledZstd
+	in  *lastBytes
+	br  *bufio.Reader
+	out int64
+	err error
+}
+
+// NewZstdFrames returns a reader for the zstd stream in "br", using a decoder
+// from "p".
+func newZstdFrames(p *decoderPool, br *bufio.Reader) (*zstdFrames, error) {
+	in := &lastBytes{r: br}
+	d, err := p.getZstd(in)
+	if err != nil {
+		return nil, &errDecompress{c: cmpZstd, err: err}
+	}
+	return &zstdFrames{p: p, d: d, in: in, br: br}, nil
+}
+
+func (z *zstdFrames) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n, err := z.d.Read(p)
+	z.out += int64(n)
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+	case errors.Is(err, zstd.ErrMagicMismatch) && z.out > 0:
+		// Not the start of the stream, so this is whatever comes after
+		// the last frame.
+		if z.in.nul() {
+			err = trailing(z.br, cmpZstd)
+		} else {
+			err = &errTrailingData{c: cmpZstd}
+		}
+	default:
+		err = &errDecompress{c: cmpZstd, err: err}
+	}
+	z.err = err
+	return n, err
+}
+
+// Close returns the decoder to the pool. The zstdFrames can't be used
+// afterwards.
+func (z *zstdFrames) Close() {
+	if z.d == nil {
+		return
+	}
+	z.p.putZstd(z.d)
+	z.d = nil
+	z.err = errClosed
+}
+
+// ErrClosed is returned by reads of a closed decompressing reader.
+var errClosed = errors.New("fetcher: read of closed decompressor")
+
+// LastBytes remembers the last few bytes read through it.
+type lastBytes struct {
+	r    io.Reader
+	last [4]byte
+	n    int
+}
+
+func (l *lastBytes) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if n >= len(l.last) {
+		copy(l.last[:], p[n-len(l.last):n])
+		l.n = len(l.last)
+		return n, err
+	}
+	// Short read: shift the previous bytes down to make room.
+	copy(l.last[:], l.last[n:])
+	copy(l.last[len(l.last)-n:], p[:n])
+	if l.n += n; l.n > len(l.last) {
+		l.n = len(l.last)
+	}
+	return n, err
+}
+
+// Nul reports whether the last bytes read, a frame magic's worth, were all
+// NUL.
+func (l *lastBytes) nul() bool {
+	return l.n == len(l.last) && l.last == [4]byte{}
+}
+
+// Trailing reads the rest of "br", returning io.EOF if it's all NUL padding
+// and an error reporting trailing garbage otherwise.
+func trailing(br *bufio.Reader, c compression) error {
+	for {
+		b, err := br.ReadByte()
+		switch {
+		case errors.Is(err, io.EOF):
+			return io.EOF
+		case err != nil:
+			return err
+		case b != 0:
+			return &errTrailingData{c: c}
+		}
+	}
+}
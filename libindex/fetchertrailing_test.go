@@ -0,0 +1,160 @@
+package libindex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchTrailingData(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	tb := tarball(t, strings.Repeat("trailing data\n", 1024))
+	gz := func(parts ...[]byte) []byte {
+		var b bytes.Buffer
+		for _, p := range parts {
+			w := gzip.NewWriter(&b)
+			if _, err := w.Write(p); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b.Bytes()
+	}
+	zst := func(parts ...[]byte) []byte {
+		var b bytes.Buffer
+		for _, p := range parts {
+			w, err := zstd.NewWriter(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(p); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b.Bytes()
+	}
+	cat := func(bs ...[]byte) []byte { return bytes.Join(bs, nil) }
+	pad := make([]byte, 512)
+	half := len(tb) / 2
+	corrupt := gz(tb)
+	corrupt = cat(corrupt[:20], bytes.Repeat([]byte{0xff}, 64), corrupt[84:])
+
+	const (
+		gzType   = "application/vnd.oci.image.layer.v1.tar+gzip"
+		zstdType = "application/vnd.oci.image.layer.v1.tar+zstd"
+	)
+	tt := []struct {
+		name string
+		ct   string
+		blob []byte
+		err  error
+		msg  string
+	}{
+		{name: "Gzip", ct: gzType, blob: gz(tb)},
+		{name: "GzipConcatenated", ct: gzType, blob: gz(tb[:half], tb[half:])},
+		{name: "GzipEmptyMember", ct: gzType, blob: gz(tb, nil)},
+		{name: "GzipPadded", ct: gzType, blob: cat(gz(tb), pad)},
+		{name: "GzipPaddedShort", ct: gzType, blob: cat(gz(tb), []byte{0})},
+		{name: "GzipConcatenatedPadded", ct: gzType, blob: cat(gz(tb[:half], tb[half:]), pad)},
+		{name: "GzipGarbage", ct: gzType, blob: cat(gz(tb), []byte("garbage")), err: ErrTrailingData, msg: "garbage after gzip stream"},
+		{name: "GzipPaddedGarbage", ct: gzType, blob: cat(gz(tb), pad, []byte("garbage")), err: ErrTrailingData},
+		{name: "GzipCorrupt", ct: gzType, blob: corrupt, msg: "gzip decompression failed"},
+		{name: "Zstd", ct: zstdType, blob: zst(tb)},
+		{name: "ZstdConcatenated", ct: zstdType, blob: zst(tb[:half], tb[half:])},
+		{name: "ZstdPadded", ct: zstdType, blob: cat(zst(tb), pad)},
+		{name: "ZstdGarbage", ct: zstdType, blob: cat(zst(tb), []byte("garbage")), err: ErrTrailingData, msg: "garbage after zstd stream"},
+		{name: "ZstdPaddedGarbage", ct: zstdType, blob: cat(zst(tb), pad, []byte("garbage")), err: ErrTrailingData},
+		{name: "ZstdCorrupt", ct: zstdType, blob: []byte("\x28\xb5\x2f\xfdnot really zstd"), msg: "zstd decompression failed"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			c, l := serveBlob(t, tc.ct, tc.blob)
+			l.UncompressedSize = int64(len(tb))
+			a := NewRemoteFetchArena(c, t.TempDir())
+			defer a.Close(ctx)
+			f := a.Realizer(ctx)
+			defer f.Close()
+			err := f.Realize(ctx, []*claircore.Layer{l})
+			wantErr := tc.err != nil || tc.msg != ""
+			switch {
+			case !wantErr && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case wantErr && err == nil:
+				t.Fatal("expected error")
+			case tc.err != nil && !errors.Is(err, tc.err):
+				t.Errorf("got: %v, want: %v", err, tc.err)
+			case tc.msg != "" && !strings.Contains(err.Error(), tc.msg):
+				t.Errorf("got: %v, want message containing %q", err, tc.msg)
+			}
+			if err != nil {
+				t.Log(err)
+			}
+		})
+	}
+
+	t.Run("DigestMismatch", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		c, l := serveBlob(t, gzType, gz(tb))
+		_, other := serveBlob(t, gzType, cat(gz(tb), pad))
+		l.Hash = other.Hash
+		a := NewRemoteFetchArena(c, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, []*claircore.Layer{l})
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Fatalf("got: %v, want: %v", err, ErrDigestMismatch)
+		}
+		if !strings.Contains(err.Error(), "digest mismatch") {
+			t.Errorf("unexpected message: %v", err)
+		}
+	})
+}
+
+func TestLastBytes(t *testing.T) {
+	in := []byte("0123456789abcdef")
+	tt := []struct {
+		name string
+		r    io.Reader
+	}{
+		{name: "Whole", r: bytes.NewReader(in)},
+		{name: "OneByte", r: iotest.OneByteReader(bytes.NewReader(in))},
+		{name: "HalfReader", r: iotest.HalfReader(bytes.NewReader(in))},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &lastBytes{r: tc.r}
+			if _, err := io.Copy(io.Discard, l); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(l.last[:l.n]), "cdef"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+	t.Run("Short", func(t *testing.T) {
+		l := &lastBytes{r: iotest.OneByteReader(bytes.NewReader([]byte{0, 0}))}
+		if _, err := io.Copy(io.Discard, l); err != nil {
+			t.Fatal(err)
+		}
+		if l.nul() {
+			t.Error("two bytes reported as a frame magic's worth")
+		}
+	})
+}
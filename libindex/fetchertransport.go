@@ -0,0 +1,159 @@
+package libindex
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// TransportConfig is how to connect to a registry. See HostTransport.
+type TransportConfig struct {
+	// Proxy, if not empty, is the URL of the proxy to connect through, like
+	// "http://proxy.example.com:3128".
+	Proxy string
+	// NoProxy, if set, has connections made directly, even if the base
+	// Transport would use a proxy. It can't be used with Proxy.
+	NoProxy bool
+	// CAFile, if not empty, is a file of PEM encoded certificates to trust in
+	// addition to the system's roots. Any roots set in the base Transport are
+	// replaced.
+	CAFile string
+	// CertFile and KeyFile, if not empty, are PEM encoded files holding a
+	// client certificate and its key to present to the registry.
+	CertFile string
+	KeyFile  string
+	// MinTLSVersion, if non-zero, is the minimum TLS version to accept, like
+	// tls.VersionTLS13.
+	MinTLSVersion uint16
+}
+
+// HostTransport returns an http.RoundTripper that sends requests for the
+// hosts in "hosts" through copies of "base", or of http.DefaultTransport if
+// nil, configured as described by their TransportConfig. Requests for other
+// hosts use "base" as-is.
+//
+// Hosts are matched case-insensitively, first including the port and then
+// without it, so "registry.example.com" applies to any port unless a
+// configuration for "registry.example.com:5000" is also present. Redirects
+// are matched by the host redirected to, so a registry redirecting blob
+// requests to a CDN may need the CDN configured as well.
+//
+// Files are read once, when this is called. The returned RoundTripper is
+// meant to be used in the http.Client passed to NewRemoteFetchArena, and may
+// be combined with PinnedTransport by using that Transport as "base".
+func HostTransport(base *http.Transport, hosts map[string]TransportConfig) (http.RoundTripper, error) {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	t := &hostTransport{
+		base:  base,
+		hosts: make(map[string]*http.Transport, len(hosts)),
+	}
+	for h, cfg := range hosts {
+		tr, err := cfg.transport(base)
+		if err != nil {
+			return nil, fmt.Errorf("fetcher: bad transport configuration for host %q: %w", h, err)
+		}
+		t.hosts[transportHost(h)] = tr
+	}
+	return t, nil
+}
+
+// Transport returns a copy of "base" with the configuration applied.
+func (cfg *TransportConfig) transport(base *http.Transport) (*http.Transport, error) {
+	t := base.Clone()
+	switch {
+	case cfg.Proxy != "" && cfg.NoProxy:
+		return nil, fmt.Errorf("both Proxy and NoProxy set")
+	case cfg.Proxy != "":
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy: %w", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("bad proxy %q: need a scheme and host", cfg.Proxy)
+		}
+		t.Proxy = http.ProxyURL(u)
+	case cfg.NoProxy:
+		t.Proxy = nil
+	}
+
+	tc := t.TLSClientConfig
+	if tc == nil {
+		tc = &tls.Config{}
+	}
+	tc = tc.Clone()
+	if cfg.CAFile != "" {
+		b, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates in %q", cfg.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+	switch {
+	case cfg.CertFile != "" && cfg.KeyFile != "":
+		c, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{c}
+	case cfg.CertFile != "" || cfg.KeyFile != "":
+		return nil, fmt.Errorf("need both CertFile and KeyFile")
+	}
+	if cfg.MinTLSVersion != 0 {
+		tc.MinVersion = cfg.MinTLSVersion
+	}
+	t.TLSClientConfig = tc
+	return t, nil
+}
+
+// HostTransport is the RoundTripper returned by HostTransport.
+type hostTransport struct {
+	base  *http.Transport
+	hosts map[string]*http.Transport
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.pick(req.URL.Host).RoundTrip(req)
+}
+
+// Pick returns the Transport for the host, which may include a port.
+func (t *hostTransport) pick(host string) *http.Transport {
+	if tr, ok := t.hosts[transportHost(host)]; ok {
+		return tr
+	}
+	if tr, ok := t.hosts[pinHost(host)]; ok {
+		return tr
+	}
+	return t.base
+}
+
+// CloseIdleConnections closes the idle connections of every Transport.
+func (t *hostTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+	for _, tr := range t.hosts {
+		tr.CloseIdleConnections()
+	}
+}
+
+// TransportHost normalizes a host, which may include a port, for lookup in a
+// hostTransport.
+func transportHost(h string) string {
+	host, port, err := net.SplitHostPort(h)
+	if err != nil || port == "" {
+		return pinHost(h)
+	}
+	return net.JoinHostPort(pinHost(host), port)
+}
@@ -0,0 +1,219 @@
+package libindex
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// WritePEM writes the blocks to a new file in "dir", returning its name.
+func writePEM(t testing.TB, dir, name string, bs ...*pem.Block) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, b := range bs {
+		if err := pem.Encode(f, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func TestHostTransport(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	dir := t.TempDir()
+	b := tarball(t, "layer")
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/x-tar")
+		w.Header().Set("content-length", strconv.Itoa(len(b)))
+		w.Write(b)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(handler))
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequestClientCert,
+		MaxVersion: tls.VersionTLS12,
+	}
+	var clientCerts int64
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) != 0 {
+			atomic.AddInt64(&clientCerts, 1)
+		}
+		handler(w, r)
+	})
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	ca := writePEM(t, dir, "ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	// A client certificate.
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := writePEM(t, dir, "cert.pem", &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyFile := writePEM(t, dir, "key.pem", &pem.Block{Type: "PRIVATE KEY", Bytes: kder})
+
+	// A forward proxy for plain http, which serves the layer itself.
+	var proxied int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "registry.invalid" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		atomic.AddInt64(&proxied, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+
+	sum := sha256.Sum256(b)
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := func(uri string) *claircore.Layer {
+		return &claircore.Layer{Hash: d, URI: uri}
+	}
+	fetch := func(t *testing.T, rt http.RoundTripper, l *claircore.Layer) error {
+		t.Helper()
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(&http.Client{Transport: rt}, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		err := f.Realize(ctx, []*claircore.Layer{l})
+		t.Log(err)
+		return err
+	}
+	host := srv.Listener.Addr().String()
+	base := http.DefaultTransport.(*http.Transport).Clone()
+
+	t.Run("Untrusted", func(t *testing.T) {
+		rt, err := HostTransport(base, map[string]TransportConfig{
+			"registry.example.com": {CAFile: ca},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fetch(t, rt, layer(srv.URL+"/blob")); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("CA", func(t *testing.T) {
+		for _, h := range []string{host, "127.0.0.1"} {
+			rt, err := HostTransport(base, map[string]TransportConfig{
+				h: {CAFile: ca},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fetch(t, rt, layer(srv.URL+"/blob")); err != nil {
+				t.Errorf("%s: %v", h, err)
+			}
+		}
+		if got := atomic.LoadInt64(&clientCerts); got != 0 {
+			t.Errorf("unexpected client certificate")
+		}
+	})
+
+	t.Run("ClientCert", func(t *testing.T) {
+		rt, err := HostTransport(base, map[string]TransportConfig{
+			host: {CAFile: ca, CertFile: cert, KeyFile: keyFile},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fetch(t, rt, layer(srv.URL+"/blob")); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt64(&clientCerts); got != 1 {
+			t.Errorf("client certificates: got: %d, want: 1", got)
+		}
+	})
+
+	t.Run("MinTLSVersion", func(t *testing.T) {
+		rt, err := HostTransport(base, map[string]TransportConfig{
+			host: {CAFile: ca, MinTLSVersion: tls.VersionTLS13},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fetch(t, rt, layer(srv.URL+"/blob")); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("Proxy", func(t *testing.T) {
+		rt, err := HostTransport(base, map[string]TransportConfig{
+			"registry.invalid": {Proxy: proxy.URL},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fetch(t, rt, layer("http://registry.invalid/blob")); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt64(&proxied); got != 1 {
+			t.Errorf("proxied requests: got: %d, want: 1", got)
+		}
+		// Other hosts aren't proxied.
+		_, l := serveBlob(t, "application/x-tar", b)
+		if err := fetch(t, rt, l); err != nil {
+			t.Error(err)
+		}
+		if got := atomic.LoadInt64(&proxied); got != 1 {
+			t.Errorf("proxied requests: got: %d, want: 1", got)
+		}
+	})
+
+	t.Run("BadConfig", func(t *testing.T) {
+		for _, cfg := range []TransportConfig{
+			{Proxy: "proxy.example.com"},
+			{Proxy: "http://proxy.example.com", NoProxy: true},
+			{CAFile: keyFile},
+			{CAFile: filepath.Join(dir, "missing.pem")},
+			{CertFile: cert},
+			{CertFile: cert, KeyFile: ca},
+		} {
+			if _, err := HostTransport(base, map[string]TransportConfig{"h": cfg}); err == nil {
+				t.Errorf("%+v: expected error", cfg)
+			} else {
+				t.Log(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package libindex
+
+import (
+	"context"
+	"time"
+
+	"github.com/quay/zlog"
+)
+
+// Expired reports whether the layer was fetched longer ago than the TTL set
+// by WithIdleTTL.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) expired(digest string, now time.Time) bool {
+	if a.idleTTL <= 0 {
+		return false
+	}
+	t, ok := a.fetched[digest]
+	return ok && now.Sub(t) >= a.idleTTL
+}
+
+// Sweep removes retained layers that have outlived the TTL set by
+// WithIdleTTL. Layers in use are never removed; a layer that expires while in
+// use is removed once it's released.
+//
+// Once a Realizer has been handed out, the arena sweeps on its own at the
+// interval set by WithSweepInterval, so calling this is only needed to force
+// a sweep. It does nothing if there's no
+// TTL.
+func (a *RemoteFetchArena) Sweep(ctx context.Context) error {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/fetchArena.Sweep",
+		"arena", a.root)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.idleTTL <= 0 {
+		return nil
+	}
+	now := a.clock.Now()
+	var ct int
+	var err error
+	for d, il := range a.idle {
+		if !a.expired(d, now) {
+			continue
+		}
+		ct++
+		if e := a.removeIdle(d, il); e != nil && err == nil {
+			err = e
+		}
+	}
+	if ct != 0 {
+		zlog.Debug(ctx).
+			Int("count", ct).
+			Msg("removed expired layers")
+	}
+	return err
+}
+
+// StartSweep starts the goroutine that periodically calls Sweep, if the
+// arena has a TTL and it's not already running. The goroutine logs using the
+// values in the provided Context, but isn't stopped by it.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) startSweep(ctx context.Context) {
+	if a.idleTTL <= 0 || !a.retainIdle || a.sweepStop != nil || a.closing {
+		return
+	}
+	iv := a.sweepInterval
+	if iv <= 0 {
+		iv = a.idleTTL
+	}
+	stop := make(chan struct{})
+	a.sweepStop = stop
+	ctx = zlog.ContextWithValues(detached{ctx},
+		"component", "libindex/fetchArena.sweep",
+		"arena", a.root)
+	tick, done := a.clock.Tick(iv)
+	go func() {
+		defer done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick:
+			}
+			if err := a.Sweep(ctx); err != nil {
+				zlog.Warn(ctx).
+					Err(err).
+					Msg("unable to remove expired layers")
+			}
+		}
+	}()
+}
+
+// Clock is the source of time for expiring layers.
+type clock interface {
+	Now() time.Time
+	// Tick returns a channel that receives every "d", and a function to stop
+	// it.
+	Tick(d time.Duration) (<-chan time.Time, func())
+}
+
+// RealClock is the clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// Detached is a Context with the values of the embedded Context, but that's
+// never done.
+type detached struct{ context.Context }
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// StopSweep stops the goroutine started by startSweep, if any.
+//
+// The caller must hold the arena lock.
+func (a *RemoteFetchArena) stopSweep() {
+	if a.sweepStop != nil {
+		close(a.sweepStop)
+		a.sweepStop = nil
+	}
+}
@@ -0,0 +1,175 @@
+package libindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchIdleTTL(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	b := tarball(t, "ttl")
+	sum := sha256.Sum256(b)
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reqs int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&reqs, 1)
+		w.Header().Set("content-type", "application/x-tar")
+		w.Write(b)
+	}))
+	defer srv.Close()
+	// Fetch realizes the layer and returns the Realizer holding it.
+	fetch := func(t *testing.T, a *RemoteFetchArena) *FetchProxy {
+		t.Helper()
+		f := a.Realizer(ctx).(*FetchProxy)
+		l := &claircore.Layer{
+			Hash:    d,
+			URI:     srv.URL + "/blob",
+			Headers: make(http.Header),
+		}
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil
+	}
+
+	t.Run("Sweep", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		atomic.StoreInt64(&reqs, 0)
+		root := t.TempDir()
+		p := filepath.Join(root, d.String())
+		clk := newFakeClock()
+		a := NewRemoteFetchArena(srv.Client(), root,
+			WithRetainIdle(), WithIdleTTL(time.Minute), WithSweepInterval(time.Second))
+		a.clock = clk
+		defer a.Close(ctx)
+		fetch(t, a).Close()
+		if !exists(p) {
+			t.Fatal("layer not retained")
+		}
+		clk.Advance(time.Minute)
+		// The sweeper only takes the second tick once it's done with the
+		// first.
+		clk.tick <- clk.Now()
+		clk.tick <- clk.Now()
+		if exists(p) {
+			t.Fatal("expired layer not removed")
+		}
+		fetch(t, a).Close()
+		if got, want := atomic.LoadInt64(&reqs), int64(2); got != want {
+			t.Errorf("requests: got: %d, want: %d", got, want)
+		}
+	})
+
+	t.Run("Access", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		atomic.StoreInt64(&reqs, 0)
+		clk := newFakeClock()
+		a := NewRemoteFetchArena(srv.Client(), t.TempDir(),
+			WithRetainIdle(), WithIdleTTL(time.Minute), WithSweepInterval(time.Hour))
+		a.clock = clk
+		defer a.Close(ctx)
+		fetch(t, a).Close()
+		clk.Advance(30 * time.Second)
+		fetch(t, a).Close()
+		if got, want := atomic.LoadInt64(&reqs), int64(1); got != want {
+			t.Errorf("requests: got: %d, want: %d", got, want)
+		}
+		// Reuse doesn't extend the lifetime, and an expired layer is fetched
+		// again even though the sweep hasn't run.
+		clk.Advance(30 * time.Second)
+		fetch(t, a).Close()
+		if got, want := atomic.LoadInt64(&reqs), int64(2); got != want {
+			t.Errorf("requests: got: %d, want: %d", got, want)
+		}
+	})
+
+	t.Run("InUse", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		root := t.TempDir()
+		p := filepath.Join(root, d.String())
+		clk := newFakeClock()
+		a := NewRemoteFetchArena(srv.Client(), root,
+			WithRetainIdle(), WithIdleTTL(time.Minute), WithSweepInterval(time.Hour))
+		a.clock = clk
+		defer a.Close(ctx)
+		f := fetch(t, a)
+		clk.Advance(2 * time.Minute)
+		if err := a.Sweep(ctx); err != nil {
+			t.Error(err)
+		}
+		if !exists(p) {
+			t.Fatal("layer in use removed")
+		}
+		// Released after expiring, so it's not retained.
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+		if exists(p) {
+			t.Error("expired layer retained")
+		}
+	})
+
+	t.Run("NoTTL", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		root := t.TempDir()
+		p := filepath.Join(root, d.String())
+		a := NewRemoteFetchArena(srv.Client(), root, WithRetainIdle(), WithIdleTTL(0))
+		defer a.Close(ctx)
+		fetch(t, a).Close()
+		if err := a.Sweep(ctx); err != nil {
+			t.Error(err)
+		}
+		if !exists(p) {
+			t.Error("layer not retained")
+		}
+	})
+}
+
+// FakeClock is a clock that only moves when told to, and ticks when sent to.
+type fakeClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	tick chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:  time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		tick: make(chan time.Time),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) Tick(_ time.Duration) (<-chan time.Time, func()) {
+	return c.tick, func() {}
+}
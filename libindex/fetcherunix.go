@@ -0,0 +1,112 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Layers may be fetched over a Unix domain socket by using one of these URI
+// schemes:
+//
+//	unix:///run/registry.sock/v2/library/ubuntu/blobs/sha256:...
+//	http+unix://registry.example.com/run/registry.sock/v2/...
+//	https+unix://registry.example.com/run/registry.sock/v2/...
+//
+// The path is walked from the root until a socket is found; the remainder is
+// the path of the request sent over it. The host, if present, is used for the
+// Host header and TLS verification. It defaults to "localhost". Redirects
+// and authentication requests for the layer are sent over the same socket.
+//
+// The socket is dialed with a copy of the arena's client. Its Transport must
+// be nil or an *http.Transport.
+
+// IsUnixScheme reports whether the scheme names a Unix socket.
+func isUnixScheme(s string) bool {
+	switch s {
+	case "unix", "http+unix", "https+unix":
+		return true
+	}
+	return false
+}
+
+// UnixSocket splits a Unix socket URI into the socket path and the URL to
+// request over it.
+func unixSocket(u *url.URL) (string, *url.URL, error) {
+	out := *u
+	out.RawPath = ""
+	switch u.Scheme {
+	case "unix", "http+unix":
+		out.Scheme = "http"
+	case "https+unix":
+		out.Scheme = "https"
+	default:
+		return "", nil, fmt.Errorf("fetcher: not a unix socket uri: %q", u.Redacted())
+	}
+	if out.Host == "" {
+		out.Host = "localhost"
+	}
+	p := u.Path
+	for i := 1; i <= len(p); i++ {
+		if i != len(p) && p[i] != '/' {
+			continue
+		}
+		fi, err := os.Stat(p[:i])
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist):
+			return "", nil, fmt.Errorf("fetcher: no socket in %q: %w", p, err)
+		default:
+			return "", nil, fmt.Errorf("fetcher: unable to find socket: %w", err)
+		}
+		if fi.Mode()&fs.ModeSocket == 0 {
+			continue
+		}
+		out.Path = p[i:]
+		if !strings.HasPrefix(out.Path, "/") {
+			out.Path = "/" + out.Path
+		}
+		return p[:i], &out, nil
+	}
+	return "", nil, fmt.Errorf("fetcher: no socket in %q", p)
+}
+
+// UnixClient returns a client that sends every request over the socket at
+// "sock". Clients are reused for the life of the arena.
+func (a *RemoteFetchArena) unixClient(sock string) (*http.Client, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.sockets[sock]; ok {
+		return c, nil
+	}
+	var c http.Client
+	if a.wc != nil {
+		c = *a.wc
+	}
+	var t *http.Transport
+	switch rt := c.Transport.(type) {
+	case nil:
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		t = rt.Clone()
+	default:
+		return nil, fmt.Errorf("fetcher: unable to dial unix socket with transport %T", rt)
+	}
+	var d net.Dialer
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return d.DialContext(ctx, "unix", sock)
+	}
+	t.Proxy = nil
+	c.Transport = t
+	if a.sockets == nil {
+		a.sockets = make(map[string]*http.Client)
+	}
+	a.sockets[sock] = &c
+	return &c, nil
+}
@@ -0,0 +1,80 @@
+package libindex
+
+import (
+	"context"
+	"crypto/sha256"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+func TestFetchUnixSocket(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	layer := tarball(t, "served over a socket")
+	sum := sha256.Sum256(layer)
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "registry.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := make(chan string, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/blob" {
+			t.Errorf("unexpected path: %q", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		hosts <- r.Host
+		w.Write(layer)
+	}))
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	tt := []struct {
+		name string
+		uri  string
+		host string
+	}{
+		{name: "Unix", uri: "unix://" + sock + "/v2/blob", host: "localhost"},
+		{name: "HTTPUnix", uri: "http+unix://registry.example.com" + sock + "/v2/blob", host: "registry.example.com"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			a := NewRemoteFetchArena(&http.Client{}, t.TempDir())
+			f := a.Realizer(ctx)
+			defer f.Close()
+			l := &claircore.Layer{Hash: d, URI: tc.uri}
+			if err := f.Realize(ctx, []*claircore.Layer{l}); err != nil {
+				t.Fatal(err)
+			}
+			if !l.Fetched() {
+				t.Error("layer not fetched")
+			}
+			if got, want := <-hosts, tc.host; got != want {
+				t.Errorf("host: got: %q, want: %q", got, want)
+			}
+		})
+	}
+	t.Run("NoSocket", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		a := NewRemoteFetchArena(&http.Client{}, t.TempDir())
+		f := a.Realizer(ctx)
+		defer f.Close()
+		l := &claircore.Layer{Hash: d, URI: "unix://" + filepath.Dir(sock) + "/missing.sock/v2/blob"}
+		if err := f.Realize(ctx, []*claircore.Layer{l}); err == nil {
+			t.Error("expected error, got nil")
+		}
+	})
+}
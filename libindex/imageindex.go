@@ -0,0 +1,94 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+// IndexImageIndex indexes every manifest in the provided image index and
+// returns their IndexReports, keyed by platform.
+//
+// Manifests are indexed one after another, so layers shared between platforms
+// are fetched and scanned once: later manifests find the layer's artifacts
+// already in the store. If the Store implements indexer.ImageIndexStore, the
+// image index is recorded so AffectedManifests can report on it.
+//
+// As with Index, an error encountered while indexing a manifest is reported
+// in that manifest's IndexReport, and the other manifests are still indexed.
+// An error is only returned if the image index is invalid or an operation
+// cannot start.
+func (l *Libindex) IndexImageIndex(ctx context.Context, idx *claircore.ImageIndex) (*claircore.ImageIndexReport, error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/Libindex.IndexImageIndex",
+		"index", idx.Hash.String())
+	if err := validateImageIndex(idx); err != nil {
+		return nil, err
+	}
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	out := &claircore.ImageIndexReport{
+		Hash:      idx.Hash,
+		Manifests: make(map[string]claircore.Digest, len(idx.Manifests)),
+		Reports:   make(map[string]*claircore.IndexReport, len(idx.Manifests)),
+	}
+	for _, pm := range idx.Manifests {
+		p := pm.Platform.String()
+		ctx := zlog.ContextWithValues(ctx, "platform", p)
+		zlog.Debug(ctx).
+			Stringer("manifest", pm.Manifest.Hash).
+			Msg("indexing platform manifest")
+		ir, err := l.Index(ctx, pm.Manifest)
+		if ir == nil {
+			return nil, fmt.Errorf("libindex: unable to index %q manifest: %w", p, err)
+		}
+		if err != nil {
+			zlog.Info(ctx).
+				Err(err).
+				Stringer("manifest", pm.Manifest.Hash).
+				Msg("platform manifest failed to index")
+		}
+		out.Manifests[p] = pm.Manifest.Hash
+		out.Reports[p] = ir
+	}
+
+	if s, ok := l.store.(indexer.ImageIndexStore); ok {
+		if err := s.PersistImageIndex(ctx, idx); err != nil {
+			return nil, fmt.Errorf("libindex: unable to persist image index: %w", err)
+		}
+	} else {
+		zlog.Debug(ctx).Msg("store does not record image indexes")
+	}
+	return out, nil
+}
+
+// ValidateImageIndex reports whether the image index can be indexed.
+func validateImageIndex(idx *claircore.ImageIndex) error {
+	if len(idx.Manifests) == 0 {
+		return errors.New("libindex: image index has no manifests")
+	}
+	seen := make(map[string]struct{}, len(idx.Manifests))
+	for i, pm := range idx.Manifests {
+		if pm.Manifest == nil {
+			return fmt.Errorf("libindex: image index manifest %d missing", i)
+		}
+		p := pm.Platform.String()
+		if pm.Platform.OS == "" || pm.Platform.Architecture == "" {
+			return fmt.Errorf("libindex: image index manifest %d: incomplete platform %q", i, p)
+		}
+		if _, ok := seen[p]; ok {
+			return fmt.Errorf("libindex: image index has multiple manifests for %q", p)
+		}
+		seen[p] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,412 @@
+package libindex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
+)
+
+func TestIndexImageIndex(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	base := &claircore.Layer{Hash: digest("shared base")}
+	amd64 := &claircore.Manifest{
+		Hash: digest("amd64 manifest"),
+		Layers: []*claircore.Layer{
+			base,
+			{Hash: digest("amd64 layer")},
+		},
+	}
+	arm64 := &claircore.Manifest{
+		Hash: digest("arm64 manifest"),
+		Layers: []*claircore.Layer{
+			{Hash: base.Hash},
+			{Hash: digest("arm64 layer")},
+		},
+	}
+	idx := &claircore.ImageIndex{
+		Hash: digest("image index"),
+		Manifests: []claircore.PlatformManifest{
+			{Platform: claircore.Platform{OS: "linux", Architecture: "amd64"}, Manifest: amd64},
+			{Platform: claircore.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, Manifest: arm64},
+		},
+	}
+
+	s := newImageIndexStore()
+	fa := &countingArena{fetched: make(map[string]int)}
+	scnr := &countingScanner{scanned: make(map[string]int)}
+	opts := &Options{
+		Store:      s,
+		Locker:     testLocker{},
+		FetchArena: fa,
+		Ecosystems: []*ccindexer.Ecosystem{{
+			Name: "imageindex",
+			PackageScanners: func(context.Context) ([]ccindexer.PackageScanner, error) {
+				return []ccindexer.PackageScanner{scnr}, nil
+			},
+			DistributionScanners: func(context.Context) ([]ccindexer.DistributionScanner, error) { return nil, nil },
+			RepositoryScanners:   func(context.Context) ([]ccindexer.RepositoryScanner, error) { return nil, nil },
+			Coalescer: func(context.Context) (ccindexer.Coalescer, error) {
+				return layerCoalescer{}, nil
+			},
+		}},
+	}
+	l, err := New(ctx, opts, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close(ctx)
+
+	r, err := l.IndexImageIndex(ctx, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantManifests := map[string]claircore.Digest{
+		"linux/amd64":    amd64.Hash,
+		"linux/arm64/v8": arm64.Hash,
+	}
+	if got, want := r.Manifests, wantManifests; !cmp.Equal(got, want, cmp.AllowUnexported(claircore.Digest{})) {
+		t.Error(cmp.Diff(got, want, cmp.AllowUnexported(claircore.Digest{})))
+	}
+	for p, ir := range r.Reports {
+		if !ir.Success {
+			t.Errorf("%s: unsuccessful report: %q", p, ir.Err)
+		}
+		if got, want := ir.Hash.String(), r.Manifests[p].String(); got != want {
+			t.Errorf("%s: got: %v, want: %v", p, got, want)
+		}
+		if got, want := len(ir.Packages), 2; got != want {
+			t.Errorf("%s: got: %d packages, want: %d", p, got, want)
+		}
+	}
+
+	t.Run("Dedup", func(t *testing.T) {
+		for h, n := range fa.fetched {
+			if n != 1 {
+				t.Errorf("layer %s fetched %d times", h, n)
+			}
+		}
+		for h, n := range scnr.scanned {
+			if n != 1 {
+				t.Errorf("layer %s scanned %d times", h, n)
+			}
+		}
+		if got, want := len(scnr.scanned), 3; got != want {
+			t.Errorf("got: %d layers scanned, want: %d", got, want)
+		}
+	})
+
+	t.Run("AffectedManifests", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		s.affected = []claircore.Digest{arm64.Hash, digest("lonely manifest")}
+		affected, err := l.AffectedManifests(ctx, createTestVulns(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]string{
+			idx.Hash.String(): {"0", "1"},
+		}
+		got := affected.VulnerableIndexes
+		for _, ids := range got {
+			sort.Strings(ids)
+		}
+		if !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+
+	t.Run("PartialFailure", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		bad := &claircore.Manifest{
+			Hash:   digest("bad manifest"),
+			Layers: []*claircore.Layer{{Hash: digest("bad layer")}},
+		}
+		fa.mu.Lock()
+		fa.fail = map[string]bool{digest("bad layer").String(): true}
+		fa.mu.Unlock()
+		defer func() {
+			fa.mu.Lock()
+			fa.fail = nil
+			fa.mu.Unlock()
+		}()
+		idx := &claircore.ImageIndex{
+			Hash: digest("partial image index"),
+			Manifests: []claircore.PlatformManifest{
+				{Platform: claircore.Platform{OS: "linux", Architecture: "amd64"}, Manifest: bad},
+				{Platform: claircore.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, Manifest: arm64},
+			},
+		}
+		r, err := l.IndexImageIndex(ctx, idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(r.Reports), 2; got != want {
+			t.Fatalf("got: %d reports, want: %d", got, want)
+		}
+		if ir := r.Reports["linux/amd64"]; ir.Success || ir.Err == "" {
+			t.Errorf("failed manifest: got: success %v, error %q", ir.Success, ir.Err)
+		}
+		if ir := r.Reports["linux/arm64/v8"]; !ir.Success {
+			t.Errorf("other manifest: unsuccessful report: %q", ir.Err)
+		}
+		idxs, err := s.ImageIndexes(ctx, []claircore.Digest{arm64.Hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, h := range idxs[arm64.Hash.String()] {
+			found = found || h.String() == idx.Hash.String()
+		}
+		if !found {
+			t.Error("image index not persisted")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		for name, idx := range map[string]*claircore.ImageIndex{
+			"Empty": {Hash: digest("empty")},
+			"Duplicate": {
+				Hash: digest("duplicate"),
+				Manifests: []claircore.PlatformManifest{
+					{Platform: claircore.Platform{OS: "linux", Architecture: "amd64"}, Manifest: amd64},
+					{Platform: claircore.Platform{OS: "linux", Architecture: "amd64"}, Manifest: arm64},
+				},
+			},
+			"NoPlatform": {
+				Hash: digest("no platform"),
+				Manifests: []claircore.PlatformManifest{
+					{Manifest: amd64},
+				},
+			},
+		} {
+			if _, err := l.IndexImageIndex(ctx, idx); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+	})
+}
+
+// ImageIndexStore is an in-memory indexer.Store implementing
+// indexer.ImageIndexStore, with just enough behavior for a Controller.
+type imageIndexStore struct {
+	ccindexer.Store
+
+	mu       sync.Mutex
+	scanned  map[string]bool
+	pkgs     map[string][]*claircore.Package
+	reports  map[string]*claircore.IndexReport
+	finished map[string]bool
+	indexes  map[string][]claircore.Digest
+	affected []claircore.Digest
+}
+
+var _ ccindexer.ImageIndexStore = (*imageIndexStore)(nil)
+
+func newImageIndexStore() *imageIndexStore {
+	return &imageIndexStore{
+		scanned:  make(map[string]bool),
+		pkgs:     make(map[string][]*claircore.Package),
+		reports:  make(map[string]*claircore.IndexReport),
+		finished: make(map[string]bool),
+		indexes:  make(map[string][]claircore.Digest),
+	}
+}
+
+func (s *imageIndexStore) RegisterScanners(context.Context, ccindexer.VersionedScanners) error {
+	return nil
+}
+
+func (s *imageIndexStore) Close(context.Context) error { return nil }
+
+func (s *imageIndexStore) PersistManifest(context.Context, claircore.Manifest) error { return nil }
+
+func (s *imageIndexStore) ManifestScanned(_ context.Context, hash claircore.Digest, _ ccindexer.VersionedScanners) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finished[hash.String()], nil
+}
+
+func (s *imageIndexStore) LayerScanned(_ context.Context, hash claircore.Digest, scnr ccindexer.VersionedScanner) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scanned[hash.String()+scnr.Name()], nil
+}
+
+func (s *imageIndexStore) SetLayerScanned(_ context.Context, hash claircore.Digest, scnr ccindexer.VersionedScanner) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanned[hash.String()+scnr.Name()] = true
+	return nil
+}
+
+func (s *imageIndexStore) IndexPackages(_ context.Context, pkgs []*claircore.Package, l *claircore.Layer, _ ccindexer.VersionedScanner) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pkgs[l.Hash.String()] = append(s.pkgs[l.Hash.String()], pkgs...)
+	return nil
+}
+
+func (s *imageIndexStore) PackagesByLayer(_ context.Context, hash claircore.Digest, _ ccindexer.VersionedScanners) ([]*claircore.Package, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*claircore.Package
+	for _, p := range s.pkgs[hash.String()] {
+		cp := *p
+		cp.ID = cp.Name
+		out = append(out, &cp)
+	}
+	return out, nil
+}
+
+func (s *imageIndexStore) DistributionsByLayer(context.Context, claircore.Digest, ccindexer.VersionedScanners) ([]*claircore.Distribution, error) {
+	return nil, nil
+}
+
+func (s *imageIndexStore) RepositoriesByLayer(context.Context, claircore.Digest, ccindexer.VersionedScanners) ([]*claircore.Repository, error) {
+	return nil, nil
+}
+
+func (s *imageIndexStore) IndexManifest(context.Context, *claircore.IndexReport) error { return nil }
+
+func (s *imageIndexStore) SetIndexReport(_ context.Context, ir *claircore.IndexReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save(ir)
+}
+
+func (s *imageIndexStore) SetIndexFinished(_ context.Context, ir *claircore.IndexReport, _ ccindexer.VersionedScanners) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished[ir.Hash.String()] = true
+	return s.save(ir)
+}
+
+// Save stores a copy of the report. It must be called with the lock held.
+func (s *imageIndexStore) save(ir *claircore.IndexReport) error {
+	b, err := json.Marshal(ir)
+	if err != nil {
+		return err
+	}
+	var stored claircore.IndexReport
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return err
+	}
+	s.reports[ir.Hash.String()] = &stored
+	return nil
+}
+
+func (s *imageIndexStore) IndexReport(_ context.Context, hash claircore.Digest) (*claircore.IndexReport, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ir, ok := s.reports[hash.String()]
+	if !ok {
+		return nil, false, nil
+	}
+	cp := *ir
+	return &cp, true, nil
+}
+
+func (s *imageIndexStore) AffectedManifests(context.Context, claircore.Vulnerability, claircore.CheckVulnernableFunc) ([]claircore.Digest, error) {
+	return s.affected, nil
+}
+
+func (s *imageIndexStore) PersistImageIndex(_ context.Context, idx *claircore.ImageIndex) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pm := range idx.Manifests {
+		k := pm.Manifest.Hash.String()
+		s.indexes[k] = append(s.indexes[k], idx.Hash)
+	}
+	return nil
+}
+
+func (s *imageIndexStore) ImageIndexes(_ context.Context, ms []claircore.Digest) (map[string][]claircore.Digest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string][]claircore.Digest)
+	for _, m := range ms {
+		if idxs, ok := s.indexes[m.String()]; ok {
+			out[m.String()] = idxs
+		}
+	}
+	return out, nil
+}
+
+// CountingArena records how many times each layer is fetched. Fetching a
+// layer in "fail" reports an error.
+type countingArena struct {
+	mu      sync.Mutex
+	fetched map[string]int
+	fail    map[string]bool
+}
+
+func (a *countingArena) Realizer(context.Context) ccindexer.Realizer { return countingRealizer{a} }
+func (a *countingArena) Close(context.Context) error                 { return nil }
+
+// CountingRealizer is the Realizer for a countingArena.
+type countingRealizer struct{ a *countingArena }
+
+func (countingRealizer) Close() error { return nil }
+
+func (r countingRealizer) Realize(_ context.Context, ls []*claircore.Layer) error {
+	a := r.a
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, l := range ls {
+		a.fetched[l.Hash.String()]++
+		if a.fail[l.Hash.String()] {
+			return fmt.Errorf("unable to fetch layer %s", l.Hash)
+		}
+	}
+	return nil
+}
+
+// CountingScanner reports one package per layer and records how many times
+// each layer is scanned.
+type countingScanner struct {
+	mu      sync.Mutex
+	scanned map[string]int
+}
+
+var _ ccindexer.PackageScanner = (*countingScanner)(nil)
+
+func (*countingScanner) Name() string    { return "counting" }
+func (*countingScanner) Version() string { return "1" }
+func (*countingScanner) Kind() string    { return "package" }
+func (s *countingScanner) Scan(_ context.Context, l *claircore.Layer) ([]*claircore.Package, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanned[l.Hash.String()]++
+	return []*claircore.Package{{Name: l.Hash.String(), Version: "1"}}, nil
+}
+
+// LayerCoalescer reports every package as introduced in the layer it's found
+// in.
+type layerCoalescer struct{}
+
+func (layerCoalescer) Coalesce(_ context.Context, ls []*ccindexer.LayerArtifacts) (*claircore.IndexReport, error) {
+	ir := &claircore.IndexReport{
+		Packages:     map[string]*claircore.Package{},
+		Environments: map[string][]*claircore.Environment{},
+	}
+	for _, la := range ls {
+		for _, p := range la.Pkgs {
+			ir.Packages[p.ID] = p
+			ir.Environments[p.ID] = append(ir.Environments[p.ID], &claircore.Environment{
+				PackageDB:    "counting",
+				IntroducedIn: la.Hash,
+			})
+		}
+	}
+	return ir, nil
+}
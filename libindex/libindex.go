@@ -17,8 +17,10 @@ import (
 
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/alpine"
+	"github.com/quay/claircore/dotnet"
 	"github.com/quay/claircore/dpkg"
 	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/internal/lifecycle"
 	"github.com/quay/claircore/java"
 	"github.com/quay/claircore/pkg/omnimatcher"
 	"github.com/quay/claircore/python"
@@ -31,8 +33,19 @@ const versionMagic = "libindex number: 2\n"
 
 // LockSource abstracts over how locks are implemented.
 //
-// An online system needs distributed locks, offline use cases can use
-// process-local locks.
+// Libindex holds a lock named by the manifest digest for the duration of an
+// Index call, so that indexers sharing a Store don't duplicate work. An online
+// system needs distributed locks, offline use cases can use process-local
+// locks. Implementations are:
+//
+//   - NewLocalLockSource, for process-local locks.
+//   - github.com/quay/claircore/pkg/ctxlock, backed by Postgres advisory locks.
+//   - github.com/quay/claircore/pkg/redislock, backed by Redis.
+//
+// Lock must block until the lock is taken or the Context is canceled. Both
+// TryLock and Lock return a canceled Context if the lock isn't taken, and a
+// Context that's canceled if the lock is lost otherwise. The CancelFunc
+// releases the lock.
 type LockSource interface {
 	TryLock(context.Context, string) (context.Context, context.CancelFunc)
 	Lock(context.Context, string) (context.Context, context.CancelFunc)
@@ -57,12 +70,23 @@ type Libindex struct {
 	fa Arena
 	// vscnrs is a convenience object for holding a list of versioned scanners
 	vscnrs indexer.VersionedScanners
+	// Ecosystems are the configured Ecosystems, with their scanners filtered
+	// and versioned as the Options ask.
+	ecosystems []*indexer.Ecosystem
+	// Sched orders and limits concurrent Index calls.
+	sched *scheduler
+	// Files, if not nil, are the only files the configured scanners read from
+	// layers. See indexer.FileInterest.
+	files []string
+	// Ops tracks in-flight calls for Shutdown.
+	ops lifecycle.Tracker
 }
 
 // New creates a new instance of libindex.
 //
-// The passed http.Client will be used for fetching layers and any HTTP requests
-// made by scanners.
+// The passed http.Client will be used for any HTTP requests made by scanners,
+// unless Options.ClientFactory provides one, in which case it may be nil.
+// Layers are fetched with the client the FetchArena was constructed with.
 func New(ctx context.Context, opts *Options, cl *http.Client) (*Libindex, error) {
 	ctx = zlog.ContextWithValues(ctx, "component", "libindex/New")
 	// required
@@ -94,27 +118,63 @@ func New(ctx context.Context, opts *Options, cl *http.Client) (*Libindex, error)
 			rpm.NewEcosystem(ctx),
 			python.NewEcosystem(ctx),
 			java.NewEcosystem(ctx),
+			dotnet.NewEcosystem(ctx),
 			rhcc.NewEcosystem(ctx),
 		}
+		ps, err := indexer.PluginEcosystems(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts.Ecosystems = append(opts.Ecosystems, ps...)
+	}
+
+	// The Ecosystems are wrapped into new slices, so the Options can be
+	// reused.
+	ecosystems := opts.Ecosystems
+	if opts.PackageFilter != nil {
+		if opts.PackageFilterID == "" {
+			return nil, fmt.Errorf("field PackageFilterID must be set with PackageFilter")
+		}
+		ecosystems = indexer.FilterEcosystems(ecosystems, opts.PackageFilter, opts.PackageFilterID)
+	}
+	if _, ok := opts.Store.(indexer.LayerPersister); opts.Squash && !ok {
+		return nil, fmt.Errorf("field Squash requires a Store implementing indexer.LayerPersister")
+	}
+	if v := opts.ScannerConfigVersions; len(v.Package) != 0 || len(v.Dist) != 0 || len(v.Repo) != 0 {
+		ecosystems = indexer.VersionEcosystems(ecosystems, v)
 	}
 
 	// TODO(hank) If "airgap" is set, we should wrap the client and return
 	// errors on non-RFC1918 and non-RFC4193 addresses. As of go1.17, the net.IP
 	// type has a method for this purpose.
-	if cl == nil {
+	client := opts.client(ClientPurposeScanner, cl)
+	if client == nil {
 		return nil, errors.New("invalid *http.Client")
 	}
 
 	l := &Libindex{
 		Options: opts,
-		client:  cl,
+		client:  client,
 		store:   opts.Store,
 		locker:  opts.Locker,
 		fa:      opts.FetchArena,
+		sched:   newScheduler(opts.ManifestConcurrency, opts.LayerConcurrency),
+
+		ecosystems: ecosystems,
+	}
+	l.sched.maxQueue = opts.ManifestQueueLength
+	if opts.CleanOnInit {
+		c, ok := l.fa.(interface{ Clean(context.Context) error })
+		if !ok {
+			return nil, fmt.Errorf("CleanOnInit set, but FetchArena %T has no Clean method", l.fa)
+		}
+		if err := c.Clean(ctx); err != nil {
+			return nil, fmt.Errorf("failed to clean the fetch arena: %w", err)
+		}
 	}
 
 	// register any new scanners.
-	pscnrs, dscnrs, rscnrs, err := indexer.EcosystemsToScanners(ctx, opts.Ecosystems, opts.Airgap)
+	pscnrs, dscnrs, rscnrs, err := indexer.EcosystemsToScanners(ctx, l.ecosystems, opts.Airgap)
 	if err != nil {
 		return nil, err
 	}
@@ -133,10 +193,18 @@ func New(ctx context.Context, opts *Options, cl *http.Client) (*Libindex, error)
 
 	zlog.Info(ctx).Msg("registered configured scanners")
 	l.vscnrs = vscnrs
+	// Package verification, whiteouts, and squashing read files the scanners
+	// don't.
+	wholeLayers := len(opts.VerifyPackages) != 0 || opts.ApplyWhiteouts || opts.Squash
+	if fs, ok := indexer.ScannersFiles(pscnrs, dscnrs, rscnrs); ok && !wholeLayers {
+		l.files = fs
+	}
 	return l, nil
 }
 
 // Close releases held resources.
+//
+// Close doesn't wait for in-flight calls; see Shutdown.
 func (l *Libindex) Close(ctx context.Context) error {
 	l.locker.Close(ctx)
 	l.store.Close(ctx)
@@ -144,14 +212,79 @@ func (l *Libindex) Close(ctx context.Context) error {
 	return nil
 }
 
+// Shutdown stops the Libindex from accepting new calls, waits for in-flight
+// calls to finish, then releases held resources.
+//
+// If the Context is done before in-flight calls finish, they're canceled and
+// waited on. Resources are then released in dependency order: the Locker,
+// the Store, then the FetchArena. If the FetchArena has a Shutdown method,
+// it's used instead of Close. Calls made after Shutdown report ErrShutdown.
+func (l *Libindex) Shutdown(ctx context.Context) error {
+	ctx = zlog.ContextWithValues(ctx, "component", "libindex/Libindex.Shutdown")
+	err := l.ops.Shutdown(ctx)
+	if err != nil {
+		zlog.Warn(ctx).
+			Err(err).
+			Msg("canceled in-flight calls")
+	}
+	l.locker.Close(ctx)
+	l.store.Close(ctx)
+	// Use a fresh Context for the arena if the deadline has passed: all the
+	// calls using it have returned, so it shouldn't need to wait.
+	actx := ctx
+	if ctx.Err() != nil {
+		actx = context.Background()
+	}
+	var aerr error
+	if s, ok := l.fa.(interface{ Shutdown(context.Context) error }); ok {
+		aerr = s.Shutdown(actx)
+	} else {
+		aerr = l.fa.Close(actx)
+	}
+	if err == nil {
+		err = aerr
+	}
+	return err
+}
+
+// Begin registers a call for Shutdown to wait on.
+func (l *Libindex) begin(ctx context.Context) (context.Context, func(), error) {
+	ctx, done, ok := l.ops.Begin(ctx)
+	if !ok {
+		return ctx, done, fmt.Errorf("libindex: %w", ErrShutdown)
+	}
+	return ctx, done, nil
+}
+
 // Index performs a scan and index of each layer within the provided Manifest.
 //
 // If the index operation cannot start an error will be returned.
 // If an error occurs during scan the error will be propagated inside the IndexReport.
+// It's returned as well, and failures to fetch layers can be checked with
+// errors.Is against ErrNotFound, ErrUnauthorized, ErrChecksumMismatch,
+// ErrTooLarge, and ErrTransient to decide whether the index is worth retrying.
+//
+// If Options.ManifestConcurrency is set, Index may wait to start. A Context
+// returned by WithPriority can be used to move ahead of (or behind) other
+// waiting calls. If Options.ManifestQueueLength is set as well, Index reports
+// ErrQueueFull when too many calls are waiting.
+//
+// Index is checkpointed in the Store: if the process dies partway, indexing
+// the manifest again picks up from the last completed stage. Layers already
+// scanned aren't fetched or scanned again, and a manifest that was already
+// coalesced isn't coalesced again.
+//
+// A Context returned by indexer.WithProgress is told about layers as they're
+// fetched and scanned, before the IndexReport is ready.
 func (l *Libindex) Index(ctx context.Context, manifest *claircore.Manifest) (*claircore.IndexReport, error) {
 	ctx = zlog.ContextWithValues(ctx,
 		"component", "libindex/Libindex.Index",
 		"manifest", manifest.Hash.String())
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	zlog.Info(ctx).Msg("index request start")
 	defer zlog.Info(ctx).Msg("index request done")
 	c, err := l.ControllerFactory(ctx, l, l.Options)
@@ -159,8 +292,24 @@ func (l *Libindex) Index(ctx context.Context, manifest *claircore.Manifest) (*cl
 		return nil, fmt.Errorf("scanner factory failed to construct a scanner: %v", err)
 	}
 
+	// Wait for admission before taking the manifest lock, so that a manifest
+	// waiting in the queue doesn't hold a lock (and, for some LockSources, a
+	// database connection) that other indexers could be using.
+	p := priorityFrom(ctx)
+	start := time.Now()
+	leave, err := l.sched.Admit(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	zlog.Debug(ctx).
+		Stringer("priority", p).
+		Dur("wait", time.Since(start)).
+		Msg("admitted")
+
 	zlog.Debug(ctx).Msg("locking attempt")
 	lc, done := l.locker.Lock(ctx, manifest.Hash.String())
+	// Deferred after leave, so the lock is released first.
 	defer done()
 	// The process may have waited on the lock, so check that the context is
 	// still active.
@@ -168,10 +317,82 @@ func (l *Libindex) Index(ctx context.Context, manifest *claircore.Manifest) (*cl
 		return nil, err
 	}
 	zlog.Debug(ctx).Msg("locking OK")
+	if l.sched.slots != 0 {
+		lc = indexer.WithLayerSlots(lc, l.sched, manifest.Hash)
+	}
+	if l.files != nil {
+		lc = indexer.WithFilesOfInterest(lc, l.files)
+	}
 
 	return c.Index(lc, manifest)
 }
 
+// Prefetch fetches and verifies the layers of the manifest that Index would
+// need, without scanning them, so that a later Index of the manifest doesn't
+// wait on the network. This lets callers overlap fetching with deciding
+// whether and when to index the manifest.
+//
+// Prefetch doesn't wait on ManifestConcurrency or LayerConcurrency; the
+// arena's own limits, like WithArenaConcurrency, still apply. Layers already
+// scanned by every configured scanner aren't fetched. The FetchArena must
+// have a Prefetch method that keeps the layers around, as RemoteFetchArena
+// does when configured to retain them.
+func (l *Libindex) Prefetch(ctx context.Context, manifest *claircore.Manifest) error {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/Libindex.Prefetch",
+		"manifest", manifest.Hash.String())
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	p, ok := l.fa.(interface {
+		Prefetch(context.Context, []*claircore.Layer) error
+	})
+	if !ok {
+		return fmt.Errorf("libindex: FetchArena %T has no Prefetch method", l.fa)
+	}
+	ls, err := l.unscanned(ctx, manifest)
+	if err != nil {
+		return err
+	}
+	zlog.Debug(ctx).
+		Int("count", len(ls)).
+		Msg("prefetching layers")
+	if len(ls) == 0 {
+		return nil
+	}
+	return p.Prefetch(ctx, ls)
+}
+
+// Unscanned returns the layers of the manifest that Index would fetch.
+func (l *Libindex) unscanned(ctx context.Context, manifest *claircore.Manifest) ([]*claircore.Layer, error) {
+	ok, err := l.store.ManifestScanned(ctx, manifest.Hash, l.vscnrs)
+	switch {
+	case err != nil:
+		return nil, err
+	case ok:
+		return nil, nil
+	case len(l.VerifyPackages) != 0:
+		// Verification needs the whole image.
+		return manifest.Layers, nil
+	}
+	var out []*claircore.Layer
+	for _, layer := range manifest.Layers {
+		for _, s := range l.vscnrs {
+			ok, err := l.store.LayerScanned(ctx, layer.Hash, s)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				out = append(out, layer)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
 // State returns an opaque identifier identifying how the struct is currently
 // configured.
 //
@@ -188,39 +409,118 @@ func (l *Libindex) State(ctx context.Context) (string, error) {
 // Thus this state value can be used as a cue for clients to re-index their manifests
 // and obtain a new IndexReport.
 func (l *Libindex) setState(ctx context.Context, vscnrs indexer.VersionedScanners) error {
+	l.state = StateHash(vscnrs.Records())
+	return nil
+}
+
+// StateHash returns the opaque state identifier for a set of scanners.
+//
+// This is the value reported by State for an indexer configured with the
+// provided scanners, so it can be computed for the Scanners recorded in an
+// IndexReport and compared or used as an ETag.
+func StateHash(rs []claircore.ScannerRecord) string {
 	h := md5.New()
-	var ns []string
-	m := make(map[string][]byte)
-	for _, s := range vscnrs {
-		n := s.Name()
-		m[n] = []byte(n + s.Version() + s.Kind() + "\n")
+	// Sort a copy by name, as callers may have constructed the slice by hand.
+	rs = append([]claircore.ScannerRecord(nil), rs...)
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].Name < rs[j].Name })
+	io.WriteString(h, versionMagic)
+	for _, r := range rs {
 		// TODO(hank) Should this take into account configuration? E.g. If a
 		// scanner implements the configurable interface, should we expect that
 		// we can serialize the scanner's concrete type?
-		ns = append(ns, n)
+		io.WriteString(h, r.Name+r.Version+r.Kind+"\n")
 	}
-	if _, err := io.WriteString(h, versionMagic); err != nil {
-		return err
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ShouldReindex reports whether the stored IndexReport for the manifest was
+// produced by a different set of scanners than the ones currently configured.
+//
+// A manifest that hasn't been indexed, or whose IndexReport predates scanners
+// being recorded, should be reindexed.
+func (l *Libindex) ShouldReindex(ctx context.Context, hash claircore.Digest) (bool, error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/Libindex.ShouldReindex",
+		"manifest", hash.String())
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return false, err
 	}
-	sort.Strings(ns)
-	for _, n := range ns {
-		if _, err := h.Write(m[n]); err != nil {
-			return err
-		}
+	defer done()
+	ir, ok, err := l.store.IndexReport(ctx, hash)
+	switch {
+	case err != nil:
+		return false, err
+	case !ok:
+		zlog.Debug(ctx).Msg("no index report")
+		return true, nil
+	case len(ir.Scanners) == 0:
+		zlog.Debug(ctx).Msg("no scanners recorded")
+		return true, nil
 	}
-	l.state = hex.EncodeToString(h.Sum(nil))
-	return nil
+	got, want := StateHash(ir.Scanners), l.state
+	zlog.Debug(ctx).
+		Str("stored", got).
+		Str("configured", want).
+		Msg("comparing scanner sets")
+	return got != want, nil
 }
 
 // IndexReport retrieves an IndexReport for a particular manifest hash, if it exists.
 func (l *Libindex) IndexReport(ctx context.Context, hash claircore.Digest) (*claircore.IndexReport, bool, error) {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer done()
 	return l.store.IndexReport(ctx, hash)
 }
 
+// Compare reports the packages, distributions, and repositories added and
+// removed between the IndexReports for the manifests "a" and "b", with "a" as
+// the base. This answers "what changed in this rebuild" when "a" is the old
+// image and "b" the new one.
+//
+// Both manifests must have been successfully indexed; if not, the returned
+// error satisfies ErrNotIndexed.
+func (l *Libindex) Compare(ctx context.Context, a, b claircore.Digest) (*claircore.IndexDiff, error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/Libindex.Compare",
+		"a", a.String(),
+		"b", b.String())
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	var rs [2]*claircore.IndexReport
+	for i, h := range []claircore.Digest{a, b} {
+		ir, ok, err := l.store.IndexReport(ctx, h)
+		switch {
+		case err != nil:
+			return nil, err
+		case !ok, !ir.Success:
+			return nil, fmt.Errorf("libindex: manifest %s: %w", h, ErrNotIndexed)
+		}
+		rs[i] = ir
+	}
+	d := claircore.DiffIndexReports(rs[0], rs[1])
+	zlog.Debug(ctx).
+		Int("added", len(d.Added.Packages)).
+		Int("removed", len(d.Removed.Packages)).
+		Msg("compared packages")
+	return d, nil
+}
+
 // AffectedManifests retrieves a list of affected manifests when provided a list of vulnerabilities.
 func (l *Libindex) AffectedManifests(ctx context.Context, vulns []claircore.Vulnerability) (*claircore.AffectedManifests, error) {
 	sem := semaphore.NewWeighted(20)
 	ctx = zlog.ContextWithValues(ctx, "component", "libindex/Libindex.AffectedManifests")
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	om := omnimatcher.New(nil)
 
 	affected := claircore.NewAffectedManifests()
@@ -250,14 +550,46 @@ func (l *Libindex) AffectedManifests(ctx context.Context, vulns []claircore.Vuln
 	if err := errGrp.Wait(); err != nil {
 		return &affected, fmt.Errorf("received error retrieving affected manifests: %v", err)
 	}
+	if s, ok := l.store.(indexer.ImageIndexStore); ok && len(affected.VulnerableManifests) != 0 {
+		if err := addIndexes(ctx, s, &affected); err != nil {
+			return &affected, fmt.Errorf("received error retrieving affected image indexes: %v", err)
+		}
+	}
 	affected.Sort()
 	return &affected, nil
 }
 
+// AddIndexes records the image indexes containing each affected manifest.
+func addIndexes(ctx context.Context, s indexer.ImageIndexStore, affected *claircore.AffectedManifests) error {
+	ms := make([]claircore.Digest, 0, len(affected.VulnerableManifests))
+	for h := range affected.VulnerableManifests {
+		d, err := claircore.ParseDigest(h)
+		if err != nil {
+			return err
+		}
+		ms = append(ms, d)
+	}
+	idxs, err := s.ImageIndexes(ctx, ms)
+	if err != nil {
+		return err
+	}
+	for _, m := range ms {
+		affected.AddIndexes(m, idxs[m.String()]...)
+	}
+	return nil
+}
+
 // DeleteManifests removes manifests specified by the provided digests.
 //
-// Providing an unknown digest is not an error.
+// Providing an unknown digest is not an error. The postgres Store also removes
+// the layers, packages, distributions, and repositories that were only found
+// in the deleted manifests.
 func (l *Libindex) DeleteManifests(ctx context.Context, d ...claircore.Digest) ([]claircore.Digest, error) {
 	ctx = zlog.ContextWithValues(ctx, "component", "libindex/Libindex.DeleteManifests")
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	return l.store.DeleteManifests(ctx, d...)
 }
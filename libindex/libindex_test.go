@@ -3,7 +3,9 @@ package libindex
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"io"
+	"net/http"
 	"strconv"
 	"testing"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/quay/zlog"
 
 	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
 	"github.com/quay/claircore/pkg/omnimatcher"
 	indexer "github.com/quay/claircore/test/mock/indexer"
 )
@@ -142,3 +145,272 @@ func BenchmarkAffectedManifests(b *testing.B) {
 		}
 	}
 }
+
+type testLocker struct{}
+
+func (testLocker) TryLock(ctx context.Context, _ string) (context.Context, context.CancelFunc) {
+	return context.WithCancel(ctx)
+}
+func (testLocker) Lock(ctx context.Context, _ string) (context.Context, context.CancelFunc) {
+	return context.WithCancel(ctx)
+}
+func (testLocker) Close(context.Context) error { return nil }
+
+func TestClientFactory(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	def, fetch, scan := &http.Client{}, &http.Client{}, &http.Client{}
+	newStore := func(t *testing.T) indexer.Store {
+		ctrl := gomock.NewController(t)
+		s := indexer.NewMockStore(ctrl)
+		s.EXPECT().RegisterScanners(gomock.Any(), gomock.Any()).Return(nil)
+		return s
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		a := NewRemoteFetchArena(nil, t.TempDir())
+		opts := &Options{
+			Store:      newStore(t),
+			Locker:     testLocker{},
+			FetchArena: a,
+			Ecosystems: []*ccindexer.Ecosystem{},
+		}
+		l, err := New(ctx, opts, def)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := l.client, def; got != want {
+			t.Errorf("scanner client: got: %p, want: %p", got, want)
+		}
+		if got, want := a.wc, http.DefaultClient; got != want {
+			t.Errorf("fetch client: got: %p, want: %p", got, want)
+		}
+	})
+	t.Run("Factory", func(t *testing.T) {
+		// The arena is left with the client it was constructed with, and
+		// no client needs to be passed to New.
+		a := NewRemoteFetchArena(fetch, t.TempDir())
+		opts := &Options{
+			Store:      newStore(t),
+			Locker:     testLocker{},
+			FetchArena: a,
+			Ecosystems: []*ccindexer.Ecosystem{},
+			ClientFactory: func(p string) *http.Client {
+				if p == ClientPurposeScanner {
+					return scan
+				}
+				return nil
+			},
+		}
+		l, err := New(ctx, opts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := l.client, scan; got != want {
+			t.Errorf("scanner client: got: %p, want: %p", got, want)
+		}
+		if got, want := a.wc, fetch; got != want {
+			t.Errorf("fetch client: got: %p, want: %p", got, want)
+		}
+	})
+	t.Run("None", func(t *testing.T) {
+		opts := &Options{
+			Store:      indexer.NewMockStore(gomock.NewController(t)),
+			Locker:     testLocker{},
+			FetchArena: NewRemoteFetchArena(nil, t.TempDir()),
+			Ecosystems: []*ccindexer.Ecosystem{},
+			ClientFactory: func(string) *http.Client {
+				return nil
+			},
+		}
+		if _, err := New(ctx, opts, nil); err == nil {
+			t.Error("expected error without a scanner client")
+		}
+	})
+}
+
+// TestScanner is a VersionedScanner with a configurable version.
+type testScanner struct {
+	name, version string
+}
+
+func (s testScanner) Name() string    { return s.name }
+func (s testScanner) Version() string { return s.version }
+func (s testScanner) Kind() string    { return "package" }
+
+func TestShouldReindex(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	hash := digest("manifest")
+	configured := ccindexer.VersionedScanners{
+		testScanner{"b", "1"},
+		testScanner{"a", "1"},
+	}
+	tt := []struct {
+		name   string
+		stored *claircore.IndexReport
+		want   bool
+	}{
+		{
+			name: "Current",
+			stored: &claircore.IndexReport{
+				Scanners: ccindexer.VersionedScanners{testScanner{"a", "1"}, testScanner{"b", "1"}}.Records(),
+			},
+			want: false,
+		},
+		{
+			name: "VersionBump",
+			stored: &claircore.IndexReport{
+				Scanners: ccindexer.VersionedScanners{testScanner{"a", "1"}, testScanner{"b", "0"}}.Records(),
+			},
+			want: true,
+		},
+		{
+			name: "NewScanner",
+			stored: &claircore.IndexReport{
+				Scanners: ccindexer.VersionedScanners{testScanner{"a", "1"}}.Records(),
+			},
+			want: true,
+		},
+		{
+			name:   "Unrecorded",
+			stored: &claircore.IndexReport{},
+			want:   true,
+		},
+		{
+			name: "NotIndexed",
+			want: true,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			ctrl := gomock.NewController(t)
+			s := indexer.NewMockStore(ctrl)
+			s.EXPECT().IndexReport(gomock.Any(), hash).Return(tc.stored, tc.stored != nil, nil)
+			l := &Libindex{store: s}
+			if err := l.setState(ctx, configured); err != nil {
+				t.Fatal(err)
+			}
+			got, err := l.ShouldReindex(ctx, hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tc.want; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			if tc.stored == nil || tc.want {
+				return
+			}
+			if got, want := StateHash(tc.stored.Scanners), l.state; got != want {
+				t.Errorf("state: got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	a, b := digest("a"), digest("b")
+	reports := map[string]*claircore.IndexReport{
+		a.String(): {
+			Hash:    a,
+			Success: true,
+			Packages: map[string]*claircore.Package{
+				"1": {ID: "1", Name: "openssl", Version: "1.1.1k"},
+			},
+		},
+		b.String(): {
+			Hash:    b,
+			Success: true,
+			Packages: map[string]*claircore.Package{
+				"2": {ID: "2", Name: "openssl", Version: "1.1.1l"},
+			},
+		},
+	}
+	lookup := func(_ context.Context, h claircore.Digest) (*claircore.IndexReport, bool, error) {
+		ir, ok := reports[h.String()]
+		return ir, ok, nil
+	}
+
+	t.Run("Changed", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		ctrl := gomock.NewController(t)
+		s := indexer.NewMockStore(ctrl)
+		s.EXPECT().IndexReport(gomock.Any(), gomock.Any()).DoAndReturn(lookup).Times(2)
+		l := &Libindex{store: s}
+		d, err := l.Compare(ctx, a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Added.Packages) != 1 || d.Added.Packages[0].ID != "2" {
+			t.Errorf("added: %+v", d.Added.Packages)
+		}
+		if len(d.Removed.Packages) != 1 || d.Removed.Packages[0].ID != "1" {
+			t.Errorf("removed: %+v", d.Removed.Packages)
+		}
+	})
+	t.Run("NotIndexed", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		ctrl := gomock.NewController(t)
+		s := indexer.NewMockStore(ctrl)
+		s.EXPECT().IndexReport(gomock.Any(), gomock.Any()).DoAndReturn(lookup).Times(2)
+		l := &Libindex{store: s}
+		_, err := l.Compare(ctx, a, digest("missing"))
+		t.Log(err)
+		if !errors.Is(err, ErrNotIndexed) {
+			t.Errorf("got: %v, want: %v", err, ErrNotIndexed)
+		}
+	})
+	t.Run("Failed", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		ctrl := gomock.NewController(t)
+		s := indexer.NewMockStore(ctrl)
+		s.EXPECT().IndexReport(gomock.Any(), a).Return(&claircore.IndexReport{Hash: a}, true, nil)
+		l := &Libindex{store: s}
+		if _, err := l.Compare(ctx, a, b); !errors.Is(err, ErrNotIndexed) {
+			t.Errorf("got: %v, want: %v", err, ErrNotIndexed)
+		}
+	})
+}
+
+func TestPrefetch(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	tt := []struct {
+		name    string
+		scanned bool
+		// Layers is which layers have been scanned.
+		layers []bool
+		want   int64
+	}{
+		{name: "Scanned", scanned: true, want: 0},
+		{name: "Partial", layers: []bool{true, false}, want: 1},
+		{name: "New", layers: []bool{false, false}, want: 2},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			var reqs int64
+			cl, ls := serveBlobs(t, "application/x-tar", tarballs(t, 2, 1024), countRequests(&reqs))
+			ctrl := gomock.NewController(t)
+			s := indexer.NewMockStore(ctrl)
+			m := &claircore.Manifest{Hash: digest("prefetch"), Layers: fresh(ls)}
+			s.EXPECT().ManifestScanned(gomock.Any(), m.Hash, gomock.Any()).Return(tc.scanned, nil)
+			for i, ok := range tc.layers {
+				s.EXPECT().LayerScanned(gomock.Any(), ls[i].Hash, gomock.Any()).Return(ok, nil)
+			}
+			a := NewRemoteFetchArena(cl, t.TempDir(), WithRetainIdle())
+			defer a.Close(ctx)
+			l := &Libindex{
+				Options: &Options{},
+				store:   s,
+				fa:      a,
+				vscnrs:  ccindexer.VersionedScanners{indexer.NewMockPackageScanner(ctrl)},
+			}
+			if err := l.Prefetch(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := reqs, tc.want; got != want {
+				t.Errorf("requests: got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package libindex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/pkg/tarfs"
+)
+
+// DockerArchiveManifest is an entry in the "manifest.json" of an archive
+// written by "docker save".
+type dockerArchiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// IndexLocal indexes the image at "name", which is either an OCI image
+// layout, as a directory or a tar of one, or an archive written by
+// "docker save". This allows indexing images that were never pushed to a
+// registry, such as ones built in CI.
+//
+// The Manifest is constructed as described by LocalManifest, and indexed as
+// with Index. Layers are read from "name" by the Libindex's FetchArena, which
+// must be a RemoteFetchArena; it needn't be configured with WithLocalLayers.
+func (l *Libindex) IndexLocal(ctx context.Context, name, platform string) (*claircore.IndexReport, error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libindex/Libindex.IndexLocal",
+		"path", name)
+	if _, ok := l.fa.(*RemoteFetchArena); !ok {
+		return nil, fmt.Errorf("libindex: unable to index local images with FetchArena %T", l.fa)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, fmt.Errorf("libindex: unable to open local image: %w", err)
+	}
+	var fsys fs.FS
+	if fi.IsDir() {
+		fsys = os.DirFS(name)
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("libindex: unable to open local image: %w", err)
+		}
+		defer f.Close()
+		if fsys, err = tarfs.New(f); err != nil {
+			return nil, fmt.Errorf("libindex: unable to read local image: %w", err)
+		}
+	}
+	m, err := LocalManifest(fsys, platform)
+	if err != nil {
+		return nil, err
+	}
+	zlog.Debug(ctx).
+		Stringer("manifest", m.Hash).
+		Int("layers", len(m.Layers)).
+		Msg("read local image")
+	return l.Index(WithLocalFS(ctx, fsys), m)
+}
+
+// LocalManifest returns the Manifest for the image in "fsys", which is either
+// an OCI image layout or the contents of an archive written by "docker save".
+// The Manifest's Layers have "file" URIs naming their files in "fsys", so
+// they can be fetched by an arena configured with WithLocalLayers(fsys).
+//
+// For an OCI image layout, the Manifest's Hash is the digest of the image
+// manifest. If the layout holds an image index, the image for "platform" is
+// used, as with Resolve. An empty platform means "linux" and the
+// architecture the program is running on.
+//
+// An archive written by "docker save" holds no image manifest, so the
+// Manifest's Hash is the digest of the image configuration, known to Docker
+// as the image ID, and the Layers' Hashes are their DiffIDs. The archive
+// must hold exactly one image. Newer versions of Docker write an OCI image
+// layout as well, which is used instead.
+//
+// In both cases, the image configuration is read and checked against its
+// digest, and becomes the Manifest's Config.
+func LocalManifest(fsys fs.FS, platform string) (*claircore.Manifest, error) {
+	if platform == "" {
+		platform = "linux/" + runtime.GOARCH
+	}
+	_, err := fs.Stat(fsys, "index.json")
+	switch {
+	case err == nil:
+		return ociLayoutManifest(fsys, platform)
+	case errors.Is(err, fs.ErrNotExist):
+	default:
+		return nil, fmt.Errorf("libindex: unable to read local image: %w", err)
+	}
+	_, err = fs.Stat(fsys, "manifest.json")
+	switch {
+	case err == nil:
+		return dockerArchiveImage(fsys)
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, errors.New("libindex: not an OCI image layout or docker archive")
+	default:
+		return nil, fmt.Errorf("libindex: unable to read local image: %w", err)
+	}
+}
+
+// OCILayoutManifest returns the Manifest for the image for "platform" in the
+// OCI image layout.
+func ociLayoutManifest(fsys fs.FS, platform string) (*claircore.Manifest, error) {
+	b, err := readLocal(fsys, "index.json")
+	if err != nil {
+		return nil, err
+	}
+	var m imageManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("libindex: unable to decode index.json: %w", err)
+	}
+	var d claircore.Digest
+	// The layout's index is followed down to an image manifest, like
+	// Resolve does for a registry.
+	for depth := 0; ; depth++ {
+		if depth > 1 {
+			return nil, errors.New("libindex: nested image index")
+		}
+		var child *descriptor
+		switch {
+		case len(m.Manifests) == 0:
+			return nil, errors.New("libindex: image index has no manifests")
+		case len(m.Manifests) == 1 && m.Manifests[0].Platform == nil:
+			// Layouts commonly hold a single image, without saying what
+			// platform it's for.
+			child = &m.Manifests[0]
+		default:
+			if child = pickPlatform(&m, platform); child == nil {
+				return nil, fmt.Errorf("libindex: %w %q", errNoPlatform, platform)
+			}
+		}
+		if d, err = claircore.ParseDigest(child.Digest); err != nil {
+			return nil, fmt.Errorf("libindex: bad manifest digest: %w", err)
+		}
+		if b, err = readBlob(fsys, d); err != nil {
+			return nil, err
+		}
+		m = imageManifest{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("libindex: unable to decode manifest %v: %w", d, err)
+		}
+		if m.MediaType == "" {
+			m.MediaType = child.MediaType
+		}
+		if m.MediaType == "" && m.Manifests == nil {
+			m.MediaType = mediaTypeOCIManifest
+		}
+		if m.MediaType == mediaTypeOCIManifest || m.MediaType == mediaTypeDockerManifest {
+			break
+		}
+		if m.MediaType != mediaTypeOCIIndex && m.MediaType != mediaTypeDockerList {
+			return nil, fmt.Errorf("libindex: unsupported manifest type %q", m.MediaType)
+		}
+	}
+
+	out := &claircore.Manifest{
+		Hash:   d,
+		Layers: make([]*claircore.Layer, 0, len(m.Layers)),
+	}
+	if m.Config == nil {
+		return nil, fmt.Errorf("libindex: manifest %v has no config", d)
+	}
+	cd, err := claircore.ParseDigest(m.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("libindex: bad config digest: %w", err)
+	}
+	if out.Config, err = readConfig(fsys, blobName(cd), cd); err != nil {
+		return nil, err
+	}
+	out.OS = out.Config.OS
+	for _, ld := range m.Layers {
+		h, err := claircore.ParseDigest(ld.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("libindex: bad layer digest: %w", err)
+		}
+		l := &claircore.Layer{
+			Hash:      h,
+			URI:       "file:///" + blobName(h),
+			MediaType: ld.MediaType,
+			Size:      ld.Size,
+			Headers:   make(map[string][]string),
+		}
+		// Foreign layers aren't in the layout.
+		if len(ld.URLs) != 0 {
+			l.URI = ld.URLs[0]
+		}
+		out.Layers = append(out.Layers, l)
+	}
+	return out, nil
+}
+
+// DockerArchiveImage returns the Manifest for the single image in the
+// contents of an archive written by "docker save".
+func dockerArchiveImage(fsys fs.FS) (*claircore.Manifest, error) {
+	b, err := readLocal(fsys, "manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	var ms []dockerArchiveManifest
+	if err := json.Unmarshal(b, &ms); err != nil {
+		return nil, fmt.Errorf("libindex: unable to decode manifest.json: %w", err)
+	}
+	if len(ms) != 1 {
+		return nil, fmt.Errorf("libindex: docker archive holds %d images, not 1", len(ms))
+	}
+	dm := ms[0]
+	// The configuration is named for its digest, like
+	// "<hex>.json" or "blobs/sha256/<hex>".
+	hex := strings.TrimSuffix(path.Base(dm.Config), ".json")
+	cd, err := claircore.ParseDigest("sha256:" + hex)
+	if err != nil {
+		return nil, fmt.Errorf("libindex: unexpected config name %q: %w", dm.Config, err)
+	}
+	cfg, err := readConfig(fsys, path.Clean(dm.Config), cd)
+	if err != nil {
+		return nil, err
+	}
+	if got, want := len(cfg.RootFS.DiffIDs), len(dm.Layers); got != want {
+		return nil, fmt.Errorf("libindex: config has %d diff_ids for %d layers", got, want)
+	}
+	out := &claircore.Manifest{
+		Hash:   cd,
+		OS:     cfg.OS,
+		Config: cfg,
+		Layers: make([]*claircore.Layer, len(dm.Layers)),
+	}
+	for i, p := range dm.Layers {
+		// The layers are saved uncompressed, so their digests are their
+		// DiffIDs.
+		out.Layers[i] = &claircore.Layer{
+			Hash:    cfg.RootFS.DiffIDs[i],
+			URI:     "file:///" + path.Clean(p),
+			Headers: make(map[string][]string),
+		}
+	}
+	return out, nil
+}
+
+// BlobName returns the name of the blob with the digest in an OCI image
+// layout.
+func blobName(d claircore.Digest) string {
+	return path.Join("blobs", strings.Replace(d.String(), ":", "/", 1))
+}
+
+// ReadBlob reads the manifest or configuration blob with the digest from an
+// OCI image layout.
+func readBlob(fsys fs.FS, d claircore.Digest) ([]byte, error) {
+	return readVerified(fsys, blobName(d), d)
+}
+
+// ReadConfig reads and decodes the image configuration at "name".
+func readConfig(fsys fs.FS, name string, d claircore.Digest) (*claircore.ImageConfig, error) {
+	b, err := readVerified(fsys, name, d)
+	if err != nil {
+		return nil, err
+	}
+	var cfg claircore.ImageConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("libindex: unable to decode config %v: %w", d, err)
+	}
+	return &cfg, nil
+}
+
+// ReadVerified reads the named file, checking its contents against the
+// digest.
+func readVerified(fsys fs.FS, name string, d claircore.Digest) ([]byte, error) {
+	b, err := readLocal(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	h := d.Hash()
+	h.Write(b)
+	got, err := claircore.NewDigest(d.Algorithm(), h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	if got.String() != d.String() {
+		return nil, &errDigestMismatch{got: []string{got.String()}, want: []string{d.String()}}
+	}
+	return b, nil
+}
+
+// ReadLocal reads the named file, which must be no larger than a manifest
+// may be.
+func readLocal(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, &errLocal{name: name, inner: err}
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, maxManifestSize+1))
+	if err != nil {
+		return nil, &errLocal{name: name, inner: err}
+	}
+	if len(b) > maxManifestSize {
+		return nil, fmt.Errorf("libindex: %s: %w: larger than %d bytes", name, ErrTooLarge, maxManifestSize)
+	}
+	return b, nil
+}
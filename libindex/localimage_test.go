@@ -0,0 +1,247 @@
+package libindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/pkg/tarfs"
+)
+
+// Sha256Digest returns the sha256 digest of "b".
+func sha256Digest(t testing.TB, b []byte) claircore.Digest {
+	t.Helper()
+	sum := sha256.Sum256(b)
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// MustJSON returns "v" marshaled as JSON.
+func mustJSON(t testing.TB, v interface{}) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// OCILayout returns an OCI image layout holding an image index with a linux
+// image for each of "archs", each with a single layer holding "file" with the
+// architecture as its contents.
+func ociLayout(t testing.TB, archs ...string) fstest.MapFS {
+	t.Helper()
+	fsys := fstest.MapFS{
+		"oci-layout": &fstest.MapFile{Data: []byte(`{"imageLayoutVersion":"1.0.0"}`)},
+	}
+	blob := func(b []byte) descriptor {
+		d := sha256Digest(t, b)
+		fsys[blobName(d)] = &fstest.MapFile{Data: b}
+		return descriptor{Digest: d.String(), Size: int64(len(b))}
+	}
+	var idx imageManifest
+	idx.MediaType = mediaTypeOCIIndex
+	for _, arch := range archs {
+		raw := tarball(t, arch)
+		ld := blob(gzipTarball(t, arch))
+		ld.MediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+		var cfg claircore.ImageConfig
+		cfg.OS, cfg.Architecture = "linux", arch
+		cfg.RootFS.DiffIDs = []claircore.Digest{sha256Digest(t, raw)}
+		cd := blob(mustJSON(t, &cfg))
+		cd.MediaType = "application/vnd.oci.image.config.v1+json"
+		md := blob(mustJSON(t, &imageManifest{
+			MediaType: mediaTypeOCIManifest,
+			Config:    &cd,
+			Layers:    []descriptor{ld},
+		}))
+		md.MediaType = mediaTypeOCIManifest
+		md.Platform = &struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant,omitempty"`
+		}{OS: "linux", Architecture: arch}
+		idx.Manifests = append(idx.Manifests, md)
+	}
+	fsys["index.json"] = &fstest.MapFile{Data: mustJSON(t, &idx)}
+	return fsys
+}
+
+// DockerArchive returns the contents of an archive as written by "docker
+// save", holding an image with a single layer holding "file" with "contents".
+func dockerArchive(t testing.TB, contents string) fstest.MapFS {
+	t.Helper()
+	layer := tarball(t, contents)
+	var cfg claircore.ImageConfig
+	cfg.OS, cfg.Architecture = "linux", "amd64"
+	cfg.RootFS.DiffIDs = []claircore.Digest{sha256Digest(t, layer)}
+	b := mustJSON(t, &cfg)
+	cd := sha256Digest(t, b)
+	name := strings.TrimPrefix(cd.String(), "sha256:") + ".json"
+	return fstest.MapFS{
+		name:             &fstest.MapFile{Data: b},
+		"0123/layer.tar": &fstest.MapFile{Data: layer},
+		"0123/VERSION":   &fstest.MapFile{Data: []byte("1.0")},
+		"manifest.json": &fstest.MapFile{Data: mustJSON(t, []dockerArchiveManifest{{
+			Config:   name,
+			RepoTags: []string{"example:latest"},
+			Layers:   []string{"0123/layer.tar"},
+		}})},
+	}
+}
+
+// TarFS writes the contents of "fsys" to a tar file and returns it opened as
+// an fs.FS.
+func tarFS(t testing.TB, fsys fstest.MapFS) fs.FS {
+	t.Helper()
+	names := make([]string, 0, len(fsys))
+	for n := range fsys {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, n := range names {
+		b := fsys[n].Data
+		if err := tw.WriteHeader(&tar.Header{Name: n, Size: int64(len(b)), Mode: 0o644}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(b)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := tarfs.New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestLocalManifest(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	// Fetch realizes the manifest's layers from "fsys" and returns the
+	// contents of "file" in each.
+	fetch := func(t *testing.T, fsys fs.FS, m *claircore.Manifest) []string {
+		t.Helper()
+		ctx := WithLocalFS(zlog.Test(ctx, t), fsys)
+		a := NewRemoteFetchArena(nil, t.TempDir())
+		defer a.Close(ctx)
+		f := a.Realizer(ctx)
+		defer f.Close()
+		if err := f.Realize(ctx, m.Layers); err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, l := range m.Layers {
+			out = append(out, readLayer(t, l)["file"])
+		}
+		return out
+	}
+
+	t.Run("OCILayout", func(t *testing.T) {
+		fsys := ociLayout(t, "amd64", "arm64")
+		m, err := LocalManifest(fsys, "linux/arm64")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Config == nil || m.Config.Architecture != "arm64" {
+			t.Fatalf("wrong image: %+v", m.Config)
+		}
+		if got, want := m.OS, "linux"; got != want {
+			t.Errorf("os: got: %q, want: %q", got, want)
+		}
+		if _, err := fs.Stat(fsys, blobName(m.Hash)); err != nil {
+			t.Errorf("hash isn't a manifest in the layout: %v", err)
+		}
+		if got, want := fetch(t, fsys, m), []string{"arm64"}; !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+	t.Run("OCILayoutTar", func(t *testing.T) {
+		fsys := tarFS(t, ociLayout(t, "amd64"))
+		m, err := LocalManifest(fsys, "linux/amd64")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := fetch(t, fsys, m), []string{"amd64"}; !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+	t.Run("NoPlatform", func(t *testing.T) {
+		_, err := LocalManifest(ociLayout(t, "amd64"), "linux/s390x")
+		if !errors.Is(err, errNoPlatform) {
+			t.Errorf("got: %v, want: %v", err, errNoPlatform)
+		}
+	})
+	t.Run("Corrupt", func(t *testing.T) {
+		fsys := ociLayout(t, "amd64")
+		m, err := LocalManifest(fsys, "linux/amd64")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys[blobName(m.Hash)].Data = []byte(`{}`)
+		_, err = LocalManifest(fsys, "linux/amd64")
+		if !errors.Is(err, ErrDigestMismatch) {
+			t.Errorf("got: %v, want: %v", err, ErrDigestMismatch)
+		}
+	})
+	t.Run("DockerArchive", func(t *testing.T) {
+		fsys := tarFS(t, dockerArchive(t, "saved"))
+		m, err := LocalManifest(fsys, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Config == nil {
+			t.Fatal("no config")
+		}
+		if got, want := m.Layers[0].Hash.String(), m.Config.RootFS.DiffIDs[0].String(); got != want {
+			t.Errorf("layer hash: got: %q, want: %q", got, want)
+		}
+		if got, want := fetch(t, fsys, m), []string{"saved"}; !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+	t.Run("Unknown", func(t *testing.T) {
+		if _, err := LocalManifest(fstest.MapFS{}, ""); err == nil {
+			t.Error("expected error")
+		}
+	})
+	t.Run("Directory", func(t *testing.T) {
+		dir := t.TempDir()
+		for n, f := range ociLayout(t, "amd64") {
+			p := filepath.Join(dir, filepath.FromSlash(n))
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, f.Data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fsys := os.DirFS(dir)
+		m, err := LocalManifest(fsys, "linux/amd64")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := fetch(t, fsys, m), []string{"amd64"}; !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package libindex
+
+import (
+	"context"
+	"sync"
+)
+
+var _ LockSource = (*localLockSource)(nil)
+
+type localLockSource struct {
+	sync.Mutex
+	// M holds a channel for every held lock, closed when it's released.
+	m map[string]chan struct{}
+}
+
+// NewLocalLockSource provides locks backed by local concurrency primitives.
+//
+// Unlike a distributed LockSource, the locks are only exclusive within the
+// process.
+func NewLocalLockSource() *localLockSource {
+	return &localLockSource{
+		m: make(map[string]chan struct{}),
+	}
+}
+
+// Lock implements LockSource.
+//
+// If the Context is canceled while waiting for the lock, the returned Context
+// is as well.
+func (s *localLockSource) Lock(ctx context.Context, key string) (context.Context, context.CancelFunc) {
+	for {
+		s.Mutex.Lock()
+		ch, held := s.m[key]
+		if !held {
+			s.m[key] = make(chan struct{})
+			s.Mutex.Unlock()
+			c, f := context.WithCancel(ctx)
+			return c, s.cancelfunc(key, f)
+		}
+		s.Mutex.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			c, f := context.WithCancel(ctx)
+			f()
+			return c, f
+		}
+	}
+}
+
+// TryLock implements LockSource.
+func (s *localLockSource) TryLock(ctx context.Context, key string) (context.Context, context.CancelFunc) {
+	c, f := context.WithCancel(ctx)
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if _, held := s.m[key]; held {
+		f()
+		return c, f
+	}
+	s.m[key] = make(chan struct{})
+	return c, s.cancelfunc(key, f)
+}
+
+// Close implements LockSource.
+func (s *localLockSource) Close(_ context.Context) error {
+	return nil
+}
+
+// Cancelfunc returns a CancelFunc that calls "next" and then unlocks. It's
+// safe to call more than once.
+func (s *localLockSource) cancelfunc(key string, next context.CancelFunc) context.CancelFunc {
+	var once sync.Once
+	return func() {
+		next()
+		once.Do(func() {
+			s.Mutex.Lock()
+			defer s.Mutex.Unlock()
+			close(s.m[key])
+			delete(s.m, key)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package libindex
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quay/claircore/pkg/ctxlock"
+	"github.com/quay/claircore/pkg/redislock"
+)
+
+// The distributed implementations are usable as LockSources.
+var (
+	_ LockSource = (*ctxlock.Locker)(nil)
+	_ LockSource = (*redislock.Locker)(nil)
+)
+
+func TestLocalLockSource(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocalLockSource()
+	defer l.Close(ctx)
+
+	lc, done := l.Lock(ctx, "key")
+	if err := lc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if lc, _ := l.TryLock(ctx, "key"); lc.Err() == nil {
+		t.Error("lock acquired twice")
+	}
+	if lc, done := l.TryLock(ctx, "other"); lc.Err() != nil {
+		t.Error(lc.Err())
+	} else {
+		done()
+	}
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		lc, done := l.Lock(ctx, "key")
+		defer done()
+		if lc.Err() == nil {
+			t.Error("lock acquired twice")
+		}
+	})
+
+	got := make(chan error)
+	go func() {
+		lc, done := l.Lock(ctx, "key")
+		defer done()
+		got <- lc.Err()
+	}()
+	done()
+	done() // Unlocking again is harmless.
+	if lc.Err() == nil {
+		t.Error("context not canceled on unlock")
+	}
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for lock")
+	}
+}
@@ -1,6 +1,7 @@
 package libindex
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/quay/claircore/indexer"
@@ -11,6 +12,13 @@ const (
 	DefaultLayerScanConcurrency = 10
 )
 
+// These are the purpose strings libindex passes to Options.ClientFactory.
+const (
+	// ClientPurposeScanner is requested for scanners that make network
+	// requests.
+	ClientPurposeScanner = "scanner"
+)
+
 // Options are dependencies and options for constructing an instance of libindex
 type Options struct {
 	// Store is the interface used to persist and retrieve results of indexing.
@@ -22,11 +30,35 @@ type Options struct {
 	// of the filesystem while separate processes are dealing with layers, for example:
 	// you can reference count downloaded layer files to avoid racing.
 	FetchArena Arena
+	// CleanOnInit has New remove files left in the FetchArena's root by a
+	// previous process, so that an ephemeral arena starts out empty. The
+	// FetchArena must have a Clean method, as RemoteFetchArena does. Leave
+	// this unset if the root is expected to keep its contents between runs.
+	CleanOnInit bool
 	// ScanLockRetry specifies how often we should try to acquire a lock for scanning a
 	// given manifest if lock is taken.
 	ScanLockRetry time.Duration
 	// LayerScanConcurrency specifies the number of layers to be scanned in parallel.
+	//
+	// Each manifest gets a pool of this many workers, each running one
+	// scanner against one layer at a time. Scanners that implement
+	// indexer.SerialScanner are only run against one layer at a time
+	// regardless. If 0, DefaultLayerScanConcurrency is used.
 	LayerScanConcurrency int
+	// ManifestConcurrency, if non-zero, limits the number of manifests being
+	// indexed at once. Additional Index calls wait, ordered by the Priority
+	// set with WithPriority.
+	ManifestConcurrency int
+	// ManifestQueueLength, if non-zero, limits the number of Index calls
+	// waiting on ManifestConcurrency. When the queue is full, a call with a
+	// higher priority than the lowest waiting takes the place of the newest
+	// such call; whichever call loses out reports ErrQueueFull.
+	ManifestQueueLength int
+	// LayerConcurrency, if non-zero, limits the number of layers being
+	// fetched or scanned at once across all manifests. Slots are shared
+	// round-robin between manifests, so that a manifest with many layers
+	// doesn't hold up manifests with few.
+	LayerConcurrency int
 	// LayerFetchOpt is unused and kept here for backwards compatibility.
 	LayerFetchOpt interface{}
 	// NoLayerValidation controls whether layers are checked to actually be
@@ -38,6 +70,9 @@ type Options struct {
 	// if nil the default factory will be used. useful for testing purposes
 	ControllerFactory ControllerFactory
 	// Ecosystems a list of ecosystems to use which define which package databases and coalescing methods we use
+	//
+	// If nil, the in-tree ecosystems are used, along with those of any
+	// Plugins registered with indexer.Register.
 	Ecosystems []*indexer.Ecosystem
 	// Airgap should be set to disallow any scanners that mark themselves as
 	// making network calls.
@@ -51,4 +86,113 @@ type Options struct {
 	ScannerConfig struct {
 		Package, Dist, Repo map[string]func(interface{}) error
 	}
+	// ScannerConfigVersions holds versions for the configurations in
+	// ScannerConfig, keyed the same way. A scanner's entry is made part of
+	// its version, so it should change whenever the configuration changes
+	// in a way that changes what the scanner finds.
+	//
+	// A layer is scanned once per scanner name, kind, and version, and the
+	// results are kept in the Store for every manifest containing the layer,
+	// including ones indexed by other Libindex instances sharing the Store.
+	// Without a version for its configuration, a reconfigured scanner
+	// keeps reporting what it found with the old configuration.
+	ScannerConfigVersions indexer.ConfigVersions
+	// ClientFactory, if provided, is consulted for an *http.Client whenever
+	// libindex needs one. The argument is one of the ClientPurpose constants.
+	//
+	// If ClientFactory is nil or returns nil, the *http.Client passed to New
+	// is used.
+	ClientFactory func(purpose string) *http.Client
+	// VerifyPackages names OS packages whose files are checked against the
+	// digests recorded in the dpkg or RPM database. Files that were replaced
+	// or removed after installation are reported in the IndexReport's
+	// ModifiedFiles, attributed to the layer that changed them.
+	//
+	// Only files belonging to these packages are hashed, but enabling this
+	// means every layer of a manifest is fetched, even ones that have already
+	// been scanned. CriticalPackages is a reasonable starting point.
+	VerifyPackages []string
+	// ApplyWhiteouts, if set, has packages whose files were deleted or
+	// replaced in a later layer left out of the IndexReport, following the
+	// whiteout rules of the OCI image spec. This catches things like jars
+	// removed from an image, which are otherwise reported from the layer
+	// that added them. Packages read from a shared package database, like
+	// dpkg's, are only left out if the database itself was removed; their
+	// ecosystems already use the topmost copy of the database.
+	//
+	// Coalescers are given the merged filesystem of the image through
+	// indexer.ImageFS. Like VerifyPackages, enabling this means every layer
+	// of a manifest is fetched, even ones that have already been scanned.
+	ApplyWhiteouts bool
+	// Squash, if set, has the indexer build the image's merged filesystem,
+	// honoring whiteouts, and run the scanners once over it instead of over
+	// every layer. The IndexReport's IndexMode records which was done.
+	//
+	// This suits images where files are overwritten or removed across many
+	// layers, at the cost of layer-level results: scan results aren't shared
+	// between manifests, and everything is reported as introduced in a
+	// synthetic layer named by the digest of the merged filesystem. An image
+	// indexed in the other mode is indexed again.
+	//
+	// The Store must implement indexer.LayerPersister, to record the
+	// synthetic layer.
+	Squash bool
+	// PartialReports, if set, has an index that fails after layers were
+	// scanned report what was found anyway: the artifacts from every layer
+	// and scanner that finished are coalesced into the IndexReport, which is
+	// marked Partial. Index returns the report along with the error.
+	//
+	// Whether or not this is set, a failed IndexReport's Errors say what
+	// failed, naming the scanner and layer if a scanner was at fault. A
+	// partial report isn't reused: the manifest is indexed again on the next
+	// call to Index.
+	PartialReports bool
+	// ValidateDiffIDs, if set, has every layer's uncompressed contents
+	// checked against the DiffID listed for it in the image configuration,
+	// when the manifest has one. A layer that doesn't match fails the index
+	// with an error wrapping ErrDiffIDMismatch.
+	//
+	// This only applies to layers fetched by a Realizer that honors
+	// Layer.ExpectedDiffID, like the one from RemoteFetchArena.
+	ValidateDiffIDs bool
+	// ScannerTimeout, if non-zero, bounds how long a single scanner may take
+	// on a single layer. A scanner that runs past it fails on that layer
+	// with an error wrapping context.DeadlineExceeded, and is abandoned even
+	// if it doesn't stop on its own, so one hung scanner can't stall the
+	// whole index.
+	ScannerTimeout time.Duration
+	// ScannerFailure decides what a scanner failing on a layer does to the
+	// index: fail it, the default, or skip what the scanner would have found
+	// there and record the failure in the IndexReport's ScannerErrors.
+	ScannerFailure indexer.ScannerFailurePolicy
+	// PackageFilter, if set, is consulted for every package found in a
+	// layer, and packages it rejects are dropped before they're persisted.
+	// The number dropped is reported in the IndexReport's FilteredPackages.
+	// PackageRules provides a filter configured by glob patterns.
+	//
+	// PackageFilterID must be set along with it, and must change whenever
+	// the filter's behavior does: it's made part of every package
+	// scanner's version, so that layers are scanned again with the new
+	// filter instead of reusing results from the old one.
+	PackageFilter   indexer.PackageFilter
+	PackageFilterID string
+}
+
+// CriticalPackages is a small list of packages worth verifying in most
+// images, for use with Options.VerifyPackages.
+var CriticalPackages = []string{
+	// Debian and Ubuntu
+	"bash", "libc6", "libc-bin", "libssl1.1", "libssl3", "openssl",
+	// RHEL and Fedora
+	"glibc", "openssl-libs",
+}
+
+// client returns the *http.Client to use for the provided purpose.
+func (o *Options) client(purpose string, fallback *http.Client) *http.Client {
+	if o.ClientFactory != nil {
+		if c := o.ClientFactory(purpose); c != nil {
+			return c
+		}
+	}
+	return fallback
 }
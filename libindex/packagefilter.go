@@ -0,0 +1,130 @@
+package libindex
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/quay/claircore"
+)
+
+// PackageRule matches packages by patterns in the syntax of path.Match. An
+// empty pattern matches anything, so the zero PackageRule matches every
+// package.
+type PackageRule struct {
+	// Name is matched against the package's name.
+	Name string `json:"name,omitempty"`
+	// Repository is matched against the package's repository hint, which
+	// identifies the kind of repository the package came from, such as
+	// "rhcc" or "https://pypi.org/simple".
+	Repository string `json:"repository,omitempty"`
+	// Path is matched against the path of the package's database, such as
+	// "var/lib/dpkg/status" or "python:usr/lib/python3/site-packages". As
+	// "*" doesn't match "/", a pattern matching a leading directory matches
+	// everything beneath it: "python:opt" matches "python:opt/app/vendor".
+	Path string `json:"path,omitempty"`
+}
+
+// Match reports whether the rule matches the package.
+func (r *PackageRule) Match(p *claircore.Package) bool {
+	return globMatch(r.Name, p.Name) &&
+		globMatch(r.Repository, p.RepositoryHint) &&
+		pathMatch(r.Path, p.PackageDB)
+}
+
+// PathMatch is globMatch, also trying every leading directory of "s".
+func pathMatch(pat, s string) bool {
+	if globMatch(pat, s) {
+		return true
+	}
+	for i := strings.IndexByte(s, '/'); i != -1; {
+		if globMatch(pat, s[:i]) {
+			return true
+		}
+		j := strings.IndexByte(s[i+1:], '/')
+		if j == -1 {
+			break
+		}
+		i += j + 1
+	}
+	return false
+}
+
+// GlobMatch is path.Match, with an empty pattern matching anything.
+func globMatch(pat, s string) bool {
+	if pat == "" {
+		return true
+	}
+	ok, _ := path.Match(pat, s)
+	return ok
+}
+
+// PackageRules is a PackageFilter configured by lists of rules.
+//
+// A package is kept if it matches any Allow rule, or there are none, and
+// matches no Deny rule. Use it like so:
+//
+//	opts.PackageFilter = rules.Filter
+//	opts.PackageFilterID = rules.ID()
+type PackageRules struct {
+	Allow []PackageRule `json:"allow,omitempty"`
+	Deny  []PackageRule `json:"deny,omitempty"`
+}
+
+// Validate reports an error if any rule has a malformed pattern. Malformed
+// patterns never match.
+func (rs *PackageRules) Validate() error {
+	check := func(kind string, i int, r *PackageRule) error {
+		for _, pat := range []string{r.Name, r.Repository, r.Path} {
+			if _, err := path.Match(pat, ""); err != nil {
+				return fmt.Errorf("%s rule %d: bad pattern %q: %w", kind, i, pat, err)
+			}
+		}
+		return nil
+	}
+	for i := range rs.Allow {
+		if err := check("allow", i, &rs.Allow[i]); err != nil {
+			return err
+		}
+	}
+	for i := range rs.Deny {
+		if err := check("deny", i, &rs.Deny[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filter implements indexer.PackageFilter.
+func (rs *PackageRules) Filter(p *claircore.Package, _ *claircore.Layer) bool {
+	ok := len(rs.Allow) == 0
+	for i := range rs.Allow {
+		if rs.Allow[i].Match(p) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return false
+	}
+	for i := range rs.Deny {
+		if rs.Deny[i].Match(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// ID returns an identifier for the rules, suitable for
+// Options.PackageFilterID. It changes whenever the rules do.
+func (rs *PackageRules) ID() string {
+	b, err := json.Marshal(rs)
+	if err != nil {
+		panic(fmt.Sprintf("programmer error: unable to marshal rules: %v", err))
+	}
+	h := sha256.Sum256(b)
+	return "rules." + hex.EncodeToString(h[:6])
+}
@@ -0,0 +1,250 @@
+package libindex
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
+	indexer "github.com/quay/claircore/test/mock/indexer"
+)
+
+func TestPackageRules(t *testing.T) {
+	pkgs := map[string]*claircore.Package{
+		"bash":     {Name: "bash", PackageDB: "var/lib/dpkg/status"},
+		"libc6":    {Name: "libc6", PackageDB: "var/lib/dpkg/status"},
+		"requests": {Name: "requests", PackageDB: "python:usr/lib/python3/site-packages", RepositoryHint: "https://pypi.org/simple"},
+		"vendored": {Name: "urllib3", PackageDB: "python:opt/app/vendor", RepositoryHint: "https://pypi.org/simple"},
+		"log4j":    {Name: "log4j-core", PackageDB: "jar:opt/app/log4j-core.jar"},
+	}
+	tt := []struct {
+		name  string
+		rules PackageRules
+		want  []string
+	}{
+		{
+			name: "Empty",
+			want: []string{"bash", "libc6", "log4j", "requests", "vendored"},
+		},
+		{
+			name:  "DenyName",
+			rules: PackageRules{Deny: []PackageRule{{Name: "lib*"}}},
+			want:  []string{"bash", "log4j", "requests", "vendored"},
+		},
+		{
+			name:  "DenyPath",
+			rules: PackageRules{Deny: []PackageRule{{Path: "python:opt"}}},
+			want:  []string{"bash", "libc6", "log4j", "requests"},
+		},
+		{
+			name:  "AllowRepository",
+			rules: PackageRules{Allow: []PackageRule{{Repository: "https://pypi.org/*"}}},
+			want:  []string{"requests", "vendored"},
+		},
+		{
+			name: "AllowThenDeny",
+			rules: PackageRules{
+				Allow: []PackageRule{{Path: "python:*"}, {Path: "jar:*"}},
+				Deny:  []PackageRule{{Name: "urllib3", Path: "python:opt"}},
+			},
+			want: []string{"log4j", "requests"},
+		},
+		{
+			name:  "BadPattern",
+			rules: PackageRules{Deny: []PackageRule{{Name: "["}}},
+			want:  []string{"bash", "libc6", "log4j", "requests", "vendored"},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, k := range []string{"bash", "libc6", "log4j", "requests", "vendored"} {
+				if tc.rules.Filter(pkgs[k], nil) {
+					got = append(got, k)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("got: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("Validate", func(t *testing.T) {
+		if err := (&PackageRules{Deny: []PackageRule{{Name: "lib*"}}}).Validate(); err != nil {
+			t.Error(err)
+		}
+		err := (&PackageRules{Allow: []PackageRule{{}, {Path: "a[b"}}}).Validate()
+		t.Log(err)
+		if err == nil {
+			t.Error("expected error for malformed pattern")
+		}
+	})
+
+	t.Run("ID", func(t *testing.T) {
+		a := PackageRules{Deny: []PackageRule{{Name: "lib*"}}}
+		b := PackageRules{Deny: []PackageRule{{Name: "lib*"}}}
+		c := PackageRules{Deny: []PackageRule{{Name: "libc*"}}}
+		if got, want := a.ID(), b.ID(); got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+		if a.ID() == c.ID() {
+			t.Errorf("different rules, same ID: %q", a.ID())
+		}
+	})
+}
+
+// FilterTestScanner reports a fixed set of packages.
+type filterTestScanner struct{ pkgs []string }
+
+func (*filterTestScanner) Name() string    { return "filter-test" }
+func (*filterTestScanner) Version() string { return "1" }
+func (*filterTestScanner) Kind() string    { return "package" }
+func (s *filterTestScanner) Scan(context.Context, *claircore.Layer) ([]*claircore.Package, error) {
+	out := make([]*claircore.Package, len(s.pkgs))
+	for i, n := range s.pkgs {
+		out[i] = &claircore.Package{Name: n, Version: "1"}
+	}
+	return out, nil
+}
+
+// PortableFilterTestScanner is a filterTestScanner that's also portable and
+// configurable.
+type portableFilterTestScanner struct {
+	filterTestScanner
+	configured bool
+}
+
+func (*portableFilterTestScanner) Portable() {}
+func (s *portableFilterTestScanner) Configure(context.Context, ccindexer.ConfigDeserializer) error {
+	s.configured = true
+	return nil
+}
+
+func TestPackageFilter(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	rules := PackageRules{Deny: []PackageRule{{Name: "lib*"}}}
+	l := &claircore.Layer{Hash: claircore.MustParseDigest(`sha256:` + strings.Repeat("a", 64))}
+
+	t.Run("Scanner", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		inner := &portableFilterTestScanner{filterTestScanner: filterTestScanner{
+			pkgs: []string{"bash", "libc6", "libssl3", "openssl"},
+		}}
+		s := ccindexer.FilterPackageScanner(inner, rules.Filter, rules.ID())
+		if got, want := s.Version(), "1+filter."+rules.ID(); got != want {
+			t.Errorf("version: got: %q, want: %q", got, want)
+		}
+		if got, want := s.Name(), inner.Name(); got != want {
+			t.Errorf("name: got: %q, want: %q", got, want)
+		}
+		if _, ok := s.(ccindexer.PortableScanner); !ok {
+			t.Error("filtered scanner lost Portable")
+		}
+		cs, ok := s.(ccindexer.ConfigurableScanner)
+		if !ok {
+			t.Fatal("filtered scanner lost Configure")
+		}
+		if err := cs.Configure(ctx, nil); err != nil {
+			t.Error(err)
+		}
+		if !inner.configured {
+			t.Error("Configure not forwarded")
+		}
+
+		var dropped int
+		ps, err := s.Scan(ccindexer.WithFilteredFunc(ctx, func(n int) { dropped += n }), l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, p := range ps {
+			got = append(got, p.Name)
+		}
+		if got, want := strings.Join(got, ","), "bash,openssl"; got != want {
+			t.Errorf("packages: got: %q, want: %q", got, want)
+		}
+		if got, want := dropped, 2; got != want {
+			t.Errorf("dropped: got: %d, want: %d", got, want)
+		}
+
+		// Filtering again replaces the filter instead of stacking.
+		s = ccindexer.FilterPackageScanner(s, rules.Filter, "other")
+		if got, want := s.Version(), "1+filter.other"; got != want {
+			t.Errorf("version: got: %q, want: %q", got, want)
+		}
+	})
+
+	t.Run("New", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		ctrl := gomock.NewController(t)
+		store := indexer.NewMockStore(ctrl)
+		var registered ccindexer.VersionedScanners
+		store.EXPECT().RegisterScanners(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, vs ccindexer.VersionedScanners) error {
+				registered = vs
+				return nil
+			})
+		scnr := &filterTestScanner{pkgs: []string{"bash"}}
+		eco := &ccindexer.Ecosystem{
+			Name: "filter-test",
+			PackageScanners: func(context.Context) ([]ccindexer.PackageScanner, error) {
+				return []ccindexer.PackageScanner{scnr}, nil
+			},
+			DistributionScanners: func(context.Context) ([]ccindexer.DistributionScanner, error) { return nil, nil },
+			RepositoryScanners:   func(context.Context) ([]ccindexer.RepositoryScanner, error) { return nil, nil },
+		}
+		opts := &Options{
+			Store:         store,
+			Locker:        testLocker{},
+			FetchArena:    NewRemoteFetchArena(nil, t.TempDir()),
+			Ecosystems:    []*ccindexer.Ecosystem{eco},
+			PackageFilter: rules.Filter,
+		}
+		if _, err := New(ctx, opts, http.DefaultClient); err == nil {
+			t.Error("expected error for missing PackageFilterID")
+		}
+
+		opts.PackageFilterID = rules.ID()
+		lib, err := New(ctx, opts, http.DefaultClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(registered), 1; got != want {
+			t.Fatalf("registered: got: %d, want: %d", got, want)
+		}
+		if got, want := registered[0].Version(), "1+filter."+rules.ID(); got != want {
+			t.Errorf("registered version: got: %q, want: %q", got, want)
+		}
+		// The caller's Ecosystem is left alone.
+		ps, err := eco.PackageScanners(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := ps[0].Version(), "1"; got != want {
+			t.Errorf("original version: got: %q, want: %q", got, want)
+		}
+		// So are the Options, so they can be reused.
+		if got := opts.Ecosystems[0]; got != eco {
+			t.Errorf("Options' Ecosystems replaced: %v", got)
+		}
+
+		// A different filter changes the indexer state, so manifests are
+		// indexed again.
+		other := PackageRules{Deny: []PackageRule{{Name: "bash"}}}
+		store.EXPECT().RegisterScanners(gomock.Any(), gomock.Any()).Return(nil)
+		opts2 := *opts
+		opts2.PackageFilter, opts2.PackageFilterID = other.Filter, other.ID()
+		lib2, err := New(ctx, &opts2, http.DefaultClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lib.state == lib2.state {
+			t.Errorf("state unchanged by new filter: %q", lib.state)
+		}
+	})
+}
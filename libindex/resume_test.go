@@ -0,0 +1,242 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
+)
+
+// TestResume confirms a Libindex started after an earlier one died partway
+// through a manifest picks up from the last completed stage, instead of
+// fetching and scanning everything again.
+func TestResume(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	m := &claircore.Manifest{
+		Hash: digest("resume manifest"),
+		Layers: []*claircore.Layer{
+			{Hash: digest("resume layer 1")},
+			{Hash: digest("resume layer 2")},
+			{Hash: digest("resume layer 3")},
+		},
+	}
+	last := m.Layers[2].Hash.String()
+
+	// Coalesced counts Coalesce calls.
+	var coalesced int64
+	// Index runs a fresh Libindex, standing in for a new process, over the
+	// Store. It returns the report along with the layers the run fetched.
+	index := func(ctx context.Context, t *testing.T, s ccindexer.Store, scnr *crashScanner) (*claircore.IndexReport, map[string]int, error) {
+		t.Helper()
+		a := &countingArena{fetched: make(map[string]int)}
+		l, err := New(ctx, &Options{
+			Store:      s,
+			Locker:     testLocker{},
+			FetchArena: a,
+			// One layer at a time, so the layers before the last are done
+			// when it's scanned.
+			LayerScanConcurrency: 1,
+			Ecosystems: []*ccindexer.Ecosystem{{
+				Name: "resume",
+				PackageScanners: func(context.Context) ([]ccindexer.PackageScanner, error) {
+					return []ccindexer.PackageScanner{scnr}, nil
+				},
+				DistributionScanners: func(context.Context) ([]ccindexer.DistributionScanner, error) { return nil, nil },
+				RepositoryScanners:   func(context.Context) ([]ccindexer.RepositoryScanner, error) { return nil, nil },
+				Coalescer: func(context.Context) (ccindexer.Coalescer, error) {
+					return countingCoalescer{&coalesced}, nil
+				},
+			}},
+		}, http.DefaultClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close(ctx)
+		ir, err := l.Index(ctx, m)
+		return ir, a.fetched, err
+	}
+	check := func(t *testing.T, ir *claircore.IndexReport) {
+		t.Helper()
+		if !ir.Success {
+			t.Errorf("unsuccessful report: %q", ir.Err)
+		}
+		if got, want := len(ir.Packages), len(m.Layers); got != want {
+			t.Errorf("packages: got: %d, want: %d", got, want)
+		}
+	}
+
+	t.Run("Scanning", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		s := &crashStore{imageIndexStore: newImageIndexStore()}
+		// The process dies while scanning the last layer, after the others
+		// are done.
+		scnr := newCrashScanner()
+		scnr.crash = func(l *claircore.Layer) bool {
+			if l.Hash.String() != last {
+				return false
+			}
+			s.die()
+			return true
+		}
+		if _, _, err := index(ctx, t, s, scnr); err == nil {
+			t.Fatal("expected the first run to fail")
+		}
+		s.revive()
+
+		scnr = newCrashScanner()
+		ir, fetched, err := index(ctx, t, s, scnr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, ir)
+		for _, l := range m.Layers[:2] {
+			k := l.Hash.String()
+			if n := fetched[k]; n != 0 {
+				t.Errorf("layer %s fetched again", k)
+			}
+			if n := scnr.scanned[k]; n != 0 {
+				t.Errorf("layer %s scanned again", k)
+			}
+		}
+		if got, want := scnr.scanned[last], 1; got != want {
+			t.Errorf("interrupted layer scanned %d times, want %d", got, want)
+		}
+	})
+
+	t.Run("Coalesced", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		s := &crashStore{imageIndexStore: newImageIndexStore(), dieAtIndexManifest: true}
+		if _, _, err := index(ctx, t, s, newCrashScanner()); err == nil {
+			t.Fatal("expected the first run to fail")
+		}
+		s.revive()
+
+		scnr := newCrashScanner()
+		atomic.StoreInt64(&coalesced, 0)
+		ir, fetched, err := index(ctx, t, s, scnr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, ir)
+		if n := atomic.LoadInt64(&coalesced); n != 0 {
+			t.Errorf("coalesced again %d times", n)
+		}
+		if len(fetched) != 0 {
+			t.Errorf("layers fetched again: %v", fetched)
+		}
+		if len(scnr.scanned) != 0 {
+			t.Errorf("layers scanned again: %v", scnr.scanned)
+		}
+	})
+}
+
+// ErrCrashed is reported by a crashStore once the process it stands in for
+// has died.
+var errCrashed = errors.New("process died")
+
+// CrashStore is an imageIndexStore that stops accepting writes when told the
+// process died, so nothing after the crash makes it into the store.
+type crashStore struct {
+	*imageIndexStore
+
+	cmu  sync.Mutex
+	dead bool
+	// DieAtIndexManifest has the process die when the coalesced report is
+	// written out.
+	dieAtIndexManifest bool
+}
+
+func (s *crashStore) die() {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	s.dead = true
+}
+
+func (s *crashStore) revive() {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	s.dead = false
+	s.dieAtIndexManifest = false
+}
+
+func (s *crashStore) check() error {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	if s.dead {
+		return errCrashed
+	}
+	return nil
+}
+
+func (s *crashStore) SetLayerScanned(ctx context.Context, hash claircore.Digest, scnr ccindexer.VersionedScanner) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	return s.imageIndexStore.SetLayerScanned(ctx, hash, scnr)
+}
+
+func (s *crashStore) IndexPackages(ctx context.Context, pkgs []*claircore.Package, l *claircore.Layer, scnr ccindexer.VersionedScanner) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	return s.imageIndexStore.IndexPackages(ctx, pkgs, l, scnr)
+}
+
+func (s *crashStore) IndexManifest(ctx context.Context, ir *claircore.IndexReport) error {
+	s.cmu.Lock()
+	if s.dieAtIndexManifest {
+		s.dead = true
+	}
+	s.cmu.Unlock()
+	if err := s.check(); err != nil {
+		return err
+	}
+	return s.imageIndexStore.IndexManifest(ctx, ir)
+}
+
+func (s *crashStore) SetIndexReport(ctx context.Context, ir *claircore.IndexReport) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	return s.imageIndexStore.SetIndexReport(ctx, ir)
+}
+
+func (s *crashStore) SetIndexFinished(ctx context.Context, ir *claircore.IndexReport, scnrs ccindexer.VersionedScanners) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	return s.imageIndexStore.SetIndexFinished(ctx, ir, scnrs)
+}
+
+// CrashScanner is a countingScanner that fails for layers "crash" reports
+// true for.
+type crashScanner struct {
+	countingScanner
+	crash func(*claircore.Layer) bool
+}
+
+func newCrashScanner() *crashScanner {
+	return &crashScanner{countingScanner: countingScanner{scanned: make(map[string]int)}}
+}
+
+func (s *crashScanner) Scan(ctx context.Context, l *claircore.Layer) ([]*claircore.Package, error) {
+	if s.crash != nil && s.crash(l) {
+		return nil, errCrashed
+	}
+	return s.countingScanner.Scan(ctx, l)
+}
+
+// CountingCoalescer is a layerCoalescer that counts its calls.
+type countingCoalescer struct{ n *int64 }
+
+func (c countingCoalescer) Coalesce(ctx context.Context, ls []*ccindexer.LayerArtifacts) (*claircore.IndexReport, error) {
+	atomic.AddInt64(c.n, 1)
+	return layerCoalescer{}.Coalesce(ctx, ls)
+}
@@ -0,0 +1,351 @@
+package libindex
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+var (
+	manifestQueueDepth = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "claircore",
+			Subsystem: "indexer",
+			Name:      "manifest_queue_depth",
+			Help:      "Number of manifests waiting to start indexing.",
+		},
+	)
+	manifestWait = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "claircore",
+			Subsystem: "indexer",
+			Name:      "manifest_wait_seconds",
+			Help:      "Time manifests spent waiting to start indexing.",
+		},
+		[]string{"priority"},
+	)
+	manifestRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "claircore",
+			Subsystem: "indexer",
+			Name:      "manifest_rejected_total",
+			Help:      "Number of manifests turned away because the queue was full.",
+		},
+		[]string{"priority"},
+	)
+)
+
+// Priority is a hint for ordering Index calls that are waiting to start.
+//
+// It only has an effect when Options.ManifestConcurrency is set.
+type Priority int
+
+// These are the priorities understood by libindex. Manifests waiting with a
+// higher priority are started first; manifests with equal priority are started
+// in the order they arrived.
+const (
+	PriorityBatch       Priority = -1
+	PriorityNormal      Priority = 0
+	PriorityInteractive Priority = 1
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBatch:
+		return "batch"
+	case PriorityNormal:
+		return "normal"
+	case PriorityInteractive:
+		return "interactive"
+	}
+	if p > PriorityInteractive {
+		return "interactive"
+	}
+	return "batch"
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a Context that causes Index to use the provided
+// Priority.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFrom returns the Priority stored in the Context, or PriorityNormal.
+func priorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// Scheduler limits the number of manifests being indexed at once and shares
+// layer work fairly between them.
+//
+// Manifests are admitted in priority order. Layer slots are handed out
+// round-robin between the manifests waiting on them, so that a manifest with
+// many layers can't starve manifests with few.
+type scheduler struct {
+	mu sync.Mutex
+
+	// MaxActive is the number of manifests that may be indexed at once, or
+	// 0 for no limit.
+	maxActive int
+	active    int
+	seq       uint64
+	// Queue is kept sorted, highest priority first.
+	queue []*admitWaiter
+	// MaxQueue is the number of manifests that may wait, or 0 for no
+	// limit.
+	maxQueue int
+
+	// Slots is the number of concurrent layer operations, or 0 for no
+	// limit.
+	slots int
+	inUse int
+	// Ring is the list of manifests with waiters, in the order they're
+	// served.
+	ring    []string
+	next    int
+	waiters map[string][]*slotWaiter
+
+	// OnWait, if not nil, is called whenever a manifest or layer operation
+	// starts waiting. Tests use it to know arrivals are in line.
+	onWait func()
+}
+
+var _ indexer.LayerSlots = (*scheduler)(nil)
+
+type admitWaiter struct {
+	ch   chan struct{}
+	prio Priority
+	seq  uint64
+	ok   bool
+}
+
+type slotWaiter struct {
+	ch chan struct{}
+	ok bool
+}
+
+func newScheduler(manifests, slots int) *scheduler {
+	return &scheduler{
+		maxActive: manifests,
+		slots:     slots,
+		waiters:   make(map[string][]*slotWaiter),
+	}
+}
+
+// Admit blocks until a manifest with the provided priority may start indexing.
+// The returned function must be called once indexing is finished.
+//
+// If the queue is full, ErrQueueFull is reported, either right away or once
+// a manifest with a higher priority takes the waiting manifest's place.
+func (s *scheduler) Admit(ctx context.Context, p Priority) (func(), error) {
+	start := time.Now()
+	defer func() {
+		manifestWait.WithLabelValues(p.String()).Observe(time.Since(start).Seconds())
+	}()
+	s.mu.Lock()
+	if s.maxActive == 0 || (s.active < s.maxActive && len(s.queue) == 0) {
+		s.active++
+		s.mu.Unlock()
+		return s.leave, nil
+	}
+	if s.maxQueue > 0 && len(s.queue) >= s.maxQueue {
+		// The last waiter is the newest with the lowest priority. An
+		// arrival that outranks it takes its place; otherwise the arrival
+		// is turned away.
+		last := s.queue[len(s.queue)-1]
+		if last.prio >= p {
+			s.mu.Unlock()
+			manifestRejected.WithLabelValues(p.String()).Inc()
+			return nil, ErrQueueFull
+		}
+		s.queue = s.queue[:len(s.queue)-1]
+		manifestQueueDepth.Dec()
+		manifestRejected.WithLabelValues(last.prio.String()).Inc()
+		close(last.ch)
+	}
+	w := &admitWaiter{
+		ch:   make(chan struct{}),
+		prio: p,
+		seq:  s.seq,
+	}
+	s.seq++
+	i := sort.Search(len(s.queue), func(i int) bool {
+		q := s.queue[i]
+		return q.prio < w.prio || (q.prio == w.prio && q.seq > w.seq)
+	})
+	s.queue = append(s.queue, nil)
+	copy(s.queue[i+1:], s.queue[i:])
+	s.queue[i] = w
+	manifestQueueDepth.Inc()
+	s.mu.Unlock()
+	if s.onWait != nil {
+		s.onWait()
+	}
+
+	select {
+	case <-w.ch:
+		if !w.ok {
+			// Bumped from the queue by a higher priority arrival.
+			return nil, ErrQueueFull
+		}
+		return s.leave, nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.ok {
+		// Admitted while being canceled; pass it along.
+		s.active--
+		s.admit()
+		return nil, ctx.Err()
+	}
+	for i, q := range s.queue {
+		if q == w {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			manifestQueueDepth.Dec()
+			break
+		}
+	}
+	return nil, ctx.Err()
+}
+
+// Leave marks an admitted manifest as finished.
+func (s *scheduler) leave() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	s.admit()
+}
+
+// Admit starts as many waiting manifests as allowed.
+//
+// The caller must hold the lock.
+func (s *scheduler) admit() {
+	for len(s.queue) != 0 && s.active < s.maxActive {
+		w := s.queue[0]
+		s.queue = s.queue[1:]
+		manifestQueueDepth.Dec()
+		s.active++
+		w.ok = true
+		close(w.ch)
+	}
+}
+
+// Acquire implements indexer.LayerSlots.
+func (s *scheduler) Acquire(ctx context.Context, manifest claircore.Digest) (func(), error) {
+	if s.slots == 0 {
+		return func() {}, nil
+	}
+	k := manifest.String()
+	s.mu.Lock()
+	if s.inUse < s.slots && len(s.ring) == 0 {
+		s.inUse++
+		s.mu.Unlock()
+		return s.release, nil
+	}
+	w := &slotWaiter{ch: make(chan struct{})}
+	if len(s.waiters[k]) == 0 {
+		// Join the ring just behind the manifest that's up next, so that
+		// everyone already waiting gets a turn first.
+		i := s.next
+		s.ring = append(s.ring, "")
+		copy(s.ring[i+1:], s.ring[i:])
+		s.ring[i] = k
+		s.next = (i + 1) % len(s.ring)
+	}
+	s.waiters[k] = append(s.waiters[k], w)
+	s.dispatch()
+	s.mu.Unlock()
+	if s.onWait != nil {
+		s.onWait()
+	}
+
+	select {
+	case <-w.ch:
+		return s.release, nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.ok {
+		s.inUse--
+		s.dispatch()
+		return nil, ctx.Err()
+	}
+	ws := s.waiters[k]
+	for i, q := range ws {
+		if q == w {
+			ws = append(ws[:i], ws[i+1:]...)
+			break
+		}
+	}
+	s.waiters[k] = ws
+	if len(ws) == 0 {
+		s.drop(k)
+	}
+	return nil, ctx.Err()
+}
+
+// Release returns a layer slot.
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inUse--
+	s.dispatch()
+}
+
+// Dispatch hands out free slots, one manifest at a time.
+//
+// The caller must hold the lock.
+func (s *scheduler) dispatch() {
+	for s.inUse < s.slots && len(s.ring) != 0 {
+		if s.next >= len(s.ring) {
+			s.next = 0
+		}
+		k := s.ring[s.next]
+		ws := s.waiters[k]
+		w := ws[0]
+		ws = ws[1:]
+		s.waiters[k] = ws
+		s.inUse++
+		w.ok = true
+		close(w.ch)
+		if len(ws) == 0 {
+			s.drop(k)
+			continue
+		}
+		s.next++
+	}
+}
+
+// Drop removes the manifest from the ring.
+//
+// The caller must hold the lock.
+func (s *scheduler) drop(k string) {
+	delete(s.waiters, k)
+	for i, r := range s.ring {
+		if r != k {
+			continue
+		}
+		s.ring = append(s.ring[:i], s.ring[i+1:]...)
+		if i < s.next {
+			s.next--
+		}
+		break
+	}
+	if len(s.ring) == 0 {
+		s.next = 0
+	}
+}
@@ -0,0 +1,291 @@
+package libindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/indexer/controller"
+)
+
+// Index simulates indexing a manifest with "n" layers against the scheduler.
+// Each layer holds its slot until it receives from "work".
+func (s *scheduler) index(ctx context.Context, name string, n int, work <-chan struct{}) error {
+	leave, err := s.Admit(ctx, priorityFrom(ctx))
+	if err != nil {
+		return err
+	}
+	defer leave()
+	d := digest(name)
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := s.Acquire(ctx, d)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer release()
+			select {
+			case <-work:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		return err
+	}
+	return nil
+}
+
+// Waited returns a channel that receives every time something starts waiting
+// on the scheduler.
+func (s *scheduler) waited() <-chan struct{} {
+	ch := make(chan struct{}, 1024)
+	s.onWait = func() { ch <- struct{}{} }
+	return ch
+}
+
+func TestSchedulerFairness(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	const (
+		slots = 4
+		big   = 100
+		small = 3
+		count = 10
+	)
+	s := newScheduler(count+1, slots)
+	wait := s.waited()
+	work := make(chan struct{})
+
+	bigDone := make(chan error, 1)
+	go func() {
+		bigDone <- s.index(ctx, "big", big, work)
+	}()
+	// Wait for the big manifest to have all its layers in line.
+	for i := 0; i < big-slots; i++ {
+		<-wait
+	}
+	smallDone := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			smallDone <- s.index(ctx, fmt.Sprintf("small-%d", i), small, work)
+		}(i)
+	}
+	for i := 0; i < count*small; i++ {
+		<-wait
+	}
+
+	// Let layers finish one at a time, counting how many it takes for all
+	// the small manifests to be done.
+	var steps int
+	for done := 0; done < count; {
+		select {
+		case work <- struct{}{}:
+			steps++
+		case err := <-smallDone:
+			if err != nil {
+				t.Fatal(err)
+			}
+			done++
+		}
+	}
+	t.Logf("small manifests done after %d steps", steps)
+	// Without fairness, the small manifests would wait on all of the big
+	// manifest's waiting layers. Taking turns, each small manifest gets a
+	// slot about once per round of the manifests waiting.
+	if max := 2 * (count + 1) * small; steps > max {
+		t.Errorf("took %d steps, more than %d", steps, max)
+	}
+	for {
+		select {
+		case work <- struct{}{}:
+			continue
+		case err := <-bigDone:
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		break
+	}
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	s := newScheduler(1, 0)
+	leave, err := s.Admit(ctx, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait := s.waited()
+
+	var mu sync.Mutex
+	var order []Priority
+	var wg sync.WaitGroup
+	for _, p := range []Priority{PriorityBatch, PriorityNormal, PriorityInteractive, PriorityNormal} {
+		wg.Add(1)
+		go func(p Priority) {
+			defer wg.Done()
+			leave, err := s.Admit(ctx, p)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, p)
+			mu.Unlock()
+			leave()
+		}(p)
+		// Make sure arrival order is deterministic.
+		<-wait
+	}
+	leave()
+	wg.Wait()
+
+	want := []Priority{PriorityInteractive, PriorityNormal, PriorityNormal, PriorityBatch}
+	if got := order; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	s := newScheduler(1, 1)
+	leave, err := s.Admit(ctx, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leave()
+	release, err := s.Acquire(ctx, digest("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.Admit(cctx, PriorityNormal); err == nil {
+		t.Error("expected admit error, got nil")
+	}
+	if _, err := s.Acquire(cctx, digest("b")); err == nil {
+		t.Error("expected acquire error, got nil")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if got := len(s.queue); got != 0 {
+		t.Errorf("queue: got: %d, want: 0", got)
+	}
+	if got := len(s.ring); got != 0 {
+		t.Errorf("ring: got: %d, want: 0", got)
+	}
+}
+
+func TestSchedulerQueueFull(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	s := newScheduler(1, 0)
+	s.maxQueue = 1
+	wait := s.waited()
+	leave, err := s.Admit(ctx, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.queue)
+	}
+
+	bumped := make(chan error, 1)
+	go func() {
+		leave, err := s.Admit(ctx, PriorityBatch)
+		if err == nil {
+			leave()
+		}
+		bumped <- err
+	}()
+	<-wait
+	// An arrival that doesn't outrank the waiting manifest is turned away.
+	if _, err := s.Admit(ctx, PriorityBatch); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got: %v, want: %v", err, ErrQueueFull)
+	}
+	// A higher priority arrival takes the waiting manifest's place.
+	admitted := make(chan error, 1)
+	go func() {
+		leave, err := s.Admit(ctx, PriorityInteractive)
+		if err == nil {
+			leave()
+		}
+		admitted <- err
+	}()
+	if err := <-bumped; !errors.Is(err, ErrQueueFull) {
+		t.Errorf("bumped: got: %v, want: %v", err, ErrQueueFull)
+	}
+	<-wait
+	leave()
+	if err := <-admitted; err != nil {
+		t.Errorf("admitted: %v", err)
+	}
+	if got := queued(); got != 0 {
+		t.Errorf("queue: got: %d, want: 0", got)
+	}
+}
+
+// CountingLocker counts calls to Lock.
+type countingLocker struct {
+	testLocker
+	mu sync.Mutex
+	n  int
+}
+
+func (l *countingLocker) Lock(ctx context.Context, key string) (context.Context, context.CancelFunc) {
+	l.mu.Lock()
+	l.n++
+	l.mu.Unlock()
+	return l.testLocker.Lock(ctx, key)
+}
+
+// TestIndexQueuedUnlocked confirms a manifest waiting to be admitted doesn't
+// hold its lock.
+func TestIndexQueuedUnlocked(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	lk := &countingLocker{}
+	l := &Libindex{
+		Options: &Options{
+			ControllerFactory: func(context.Context, *Libindex, *Options) (*controller.Controller, error) {
+				return controller.New(&ccindexer.Opts{}), nil
+			},
+		},
+		locker: lk,
+		sched:  newScheduler(1, 0),
+	}
+	leave, err := l.sched.Admit(ctx, PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leave()
+
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := l.Index(tctx, &claircore.Manifest{Hash: digest("queued")}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got: %v, want: %v", err, context.DeadlineExceeded)
+	}
+	lk.mu.Lock()
+	defer lk.mu.Unlock()
+	if lk.n != 0 {
+		t.Errorf("lock taken while queued: %d times", lk.n)
+	}
+}
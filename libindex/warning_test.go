@@ -0,0 +1,129 @@
+package libindex
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	ccindexer "github.com/quay/claircore/indexer"
+)
+
+// TestSharedLayerWarnings confirms warnings reported while scanning a layer
+// are reported for every manifest containing it, not just the one it was
+// scanned for.
+func TestSharedLayerWarnings(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	shared := digest("shared layer")
+	first := &claircore.Manifest{
+		Hash: digest("first manifest"),
+		Layers: []*claircore.Layer{
+			{Hash: shared},
+			{Hash: digest("first layer")},
+		},
+	}
+	second := &claircore.Manifest{
+		Hash: digest("second manifest"),
+		Layers: []*claircore.Layer{
+			{Hash: shared},
+			{Hash: digest("second layer")},
+		},
+	}
+
+	s := &warningStore{
+		imageIndexStore: newImageIndexStore(),
+		warnings:        make(map[string][]claircore.IndexWarning),
+	}
+	scnr := &warningScanner{countingScanner{scanned: make(map[string]int)}}
+	opts := &Options{
+		Store:      s,
+		Locker:     testLocker{},
+		FetchArena: &countingArena{fetched: make(map[string]int)},
+		Ecosystems: []*ccindexer.Ecosystem{{
+			Name: "warning",
+			PackageScanners: func(context.Context) ([]ccindexer.PackageScanner, error) {
+				return []ccindexer.PackageScanner{scnr}, nil
+			},
+			DistributionScanners: func(context.Context) ([]ccindexer.DistributionScanner, error) { return nil, nil },
+			RepositoryScanners:   func(context.Context) ([]ccindexer.RepositoryScanner, error) { return nil, nil },
+			Coalescer: func(context.Context) (ccindexer.Coalescer, error) {
+				return layerCoalescer{}, nil
+			},
+		}},
+	}
+	l, err := New(ctx, opts, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close(ctx)
+
+	for _, m := range []*claircore.Manifest{first, second} {
+		ir, err := l.Index(ctx, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, w := range ir.Warnings {
+			got = append(got, w.Layer.String())
+		}
+		sort.Strings(got)
+		var want []string
+		for _, l := range m.Layers {
+			want = append(want, l.Hash.String())
+		}
+		sort.Strings(want)
+		if len(got) != len(want) {
+			t.Fatalf("%v: got: warnings for %v, want: %v", m.Hash, got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%v: got: warnings for %v, want: %v", m.Hash, got, want)
+				break
+			}
+		}
+	}
+	if got, want := scnr.scanned[shared.String()], 1; got != want {
+		t.Errorf("shared layer scanned %d times, want %d", got, want)
+	}
+}
+
+// WarningScanner is a countingScanner that warns about every layer.
+type warningScanner struct{ countingScanner }
+
+func (s *warningScanner) Scan(ctx context.Context, l *claircore.Layer) ([]*claircore.Package, error) {
+	ccindexer.Warn(ctx, "", "layer %v looks odd", l.Hash)
+	return s.countingScanner.Scan(ctx, l)
+}
+
+// WarningStore is an imageIndexStore implementing indexer.WarningStore.
+type warningStore struct {
+	*imageIndexStore
+
+	wmu      sync.Mutex
+	warnings map[string][]claircore.IndexWarning
+}
+
+var _ ccindexer.WarningStore = (*warningStore)(nil)
+
+func (s *warningStore) SetLayerWarnings(_ context.Context, l claircore.Digest, scnr ccindexer.VersionedScanner, ws []claircore.IndexWarning) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.warnings[l.String()+scnr.Name()] = append([]claircore.IndexWarning(nil), ws...)
+	return nil
+}
+
+func (s *warningStore) LayerWarnings(_ context.Context, ls []claircore.Digest, scnrs ccindexer.VersionedScanners) ([]claircore.IndexWarning, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	var out []claircore.IndexWarning
+	for _, l := range ls {
+		for _, scnr := range scnrs {
+			out = append(out, s.warnings[l.String()+scnr.Name()]...)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,194 @@
+// Package drivertest provides a golden-file harness for testing the Parse
+// method of Updaters.
+//
+// Tests using this package accept two flags:
+//
+//	-drivertest.update	rewrite golden files with the parsed output
+//	-drivertest.record	re-record fixtures from the live feed before parsing
+//
+// Recording requires network access and a usable Updater; it's expected to be
+// used by hand when an upstream feed changes shape.
+package drivertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/libvuln/driver"
+)
+
+var (
+	update = flag.Bool("drivertest.update", false, "rewrite golden files with the parsed output")
+	record = flag.Bool("drivertest.record", false, "re-record fixtures from the live feed (requires network access)")
+)
+
+// Golden describes a golden-file test of an Updater.
+//
+// The fixture is handed to the Updater's Parse method and the result is
+// compared to the vulnerabilities stored in the golden file. The order of the
+// returned vulnerabilities is not significant.
+type Golden struct {
+	// Updater is the Updater under test.
+	Updater driver.Updater
+	// Fixture is the path to a recorded copy of the Updater's feed, as
+	// returned by its Fetch method.
+	Fixture string
+	// Golden is the path to the expected output. If empty, the Fixture path
+	// with any extension replaced by ".golden.json" is used.
+	Golden string
+	// Client is used if the Updater needs configuring when recording. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (g *Golden) goldenPath() string {
+	if g.Golden != "" {
+		return g.Golden
+	}
+	p := g.Fixture
+	if ext := filepath.Ext(p); ext != "" {
+		p = strings.TrimSuffix(p, ext)
+	}
+	return p + ".golden.json"
+}
+
+// Run returns a function suitable for passing to (*testing.T).Run.
+func (g Golden) Run(ctx context.Context) func(*testing.T) {
+	return func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		if *record {
+			if err := g.record(ctx); err != nil {
+				t.Fatalf("recording fixture: %v", err)
+			}
+			t.Logf("recorded %q", g.Fixture)
+		}
+
+		f, err := os.Open(g.Fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := g.Updater.Parse(ctx, f)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		Normalize(got)
+
+		gp := g.goldenPath()
+		if *update {
+			if err := WriteGolden(gp, got); err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("wrote %q", gp)
+			return
+		}
+		want, err := ReadGolden(gp)
+		switch {
+		case errors.Is(err, nil):
+		case errors.Is(err, os.ErrNotExist):
+			t.Fatalf("golden file %q missing: run with -drivertest.update to create it", gp)
+		default:
+			t.Fatal(err)
+		}
+		if !cmp.Equal(want, got) {
+			t.Errorf("parsed output differs from %q (-want, +got):\n%s", gp, cmp.Diff(want, got))
+		}
+	}
+}
+
+// Record fetches the live feed and writes it to the fixture path.
+func (g *Golden) record(ctx context.Context) error {
+	if c, ok := g.Updater.(driver.Configurable); ok {
+		cl := g.Client
+		if cl == nil {
+			cl = http.DefaultClient
+		}
+		if err := c.Configure(ctx, func(interface{}) error { return nil }, cl); err != nil {
+			return err
+		}
+	}
+	rc, _, err := g.Updater.Fetch(ctx, "")
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := os.MkdirAll(filepath.Dir(g.Fixture), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(g.Fixture)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Normalize puts the vulnerabilities into a stable order and clears fields
+// that are assigned by a store rather than an Updater.
+func Normalize(vs []*claircore.Vulnerability) {
+	keys := make(map[*claircore.Vulnerability]string, len(vs))
+	for _, v := range vs {
+		v.ID = ""
+		b, err := json.Marshal(v)
+		if err != nil {
+			// Vulnerabilities that can't be serialized can't be stored,
+			// either. Fall back to something that at least sorts.
+			b = []byte(fmt.Sprintf("%+v", v))
+		}
+		keys[v] = string(b)
+	}
+	sort.SliceStable(vs, func(i, j int) bool {
+		return keys[vs[i]] < keys[vs[j]]
+	})
+}
+
+// ReadGolden reads and normalizes the vulnerabilities in the named file.
+func ReadGolden(name string) ([]*claircore.Vulnerability, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var vs []*claircore.Vulnerability
+	if err := json.Unmarshal(b, &vs); err != nil {
+		return nil, fmt.Errorf("drivertest: unable to decode %q: %w", name, err)
+	}
+	Normalize(vs)
+	return vs, nil
+}
+
+// WriteGolden writes the vulnerabilities to the named file, one per line for
+// readable diffs.
+func WriteGolden(name string, vs []*claircore.Vulnerability) error {
+	var buf bytes.Buffer
+	buf.WriteString("[\n")
+	for i, v := range vs {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		if i != len(vs)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("]\n")
+	return os.WriteFile(name, buf.Bytes(), 0o644)
+}
@@ -66,3 +66,17 @@ type VersionFilter interface {
 	// be completely normalized into a claircore.Version.
 	VersionAuthoritative() bool
 }
+
+// MatchDetailer is an additional interface that a Matcher can implement to
+// report how it matched a vulnerability, for inclusion in a
+// claircore.VulnerabilityReport.
+type MatchDetailer interface {
+	// VulnerableDetail is Vulnerable, additionally returning how the match
+	// was made when the package is vulnerable.
+	//
+	// The Controller fills in the MatchDetail's Vulnerability and Matcher
+	// fields. The Distribution and Repository fields should be set to the IDs
+	// of the record's Distribution or Repository if, and only if, the
+	// decision depended on them.
+	VulnerableDetail(ctx context.Context, record *claircore.IndexRecord, vuln *claircore.Vulnerability) (bool, *claircore.MatchDetail, error)
+}
@@ -23,6 +23,28 @@ type UpdateOperation struct {
 	Fingerprint Fingerprint `json:"fingerprint"`
 	Date        time.Time   `json:"date"`
 	Kind        UpdateKind  `json:"kind"`
+	// Provenance, if present, describes where the update's data came from.
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// Provenance describes the inputs of an UpdateOperation.
+type Provenance struct {
+	// UpdaterVersion is the version of the Updater's code, if known.
+	UpdaterVersion string `json:"updater_version,omitempty"`
+	// Sources are the documents fetched by the Updater, in the order the
+	// requests were made.
+	Sources []Source `json:"sources"`
+}
+
+// Source is a document fetched by an Updater.
+type Source struct {
+	// URL is the requested URL, with any credentials removed.
+	URL string `json:"url"`
+	// SHA256 is the hex encoded digest of the response body. It's empty if
+	// the Updater didn't read the whole body.
+	SHA256 string `json:"sha256,omitempty"`
+	// Fetched is when the response was received.
+	Fetched time.Time `json:"fetched"`
 }
 
 // UpdateDiff represents added or removed vulnerabilities between update operations
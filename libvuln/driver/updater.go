@@ -59,3 +59,12 @@ type ConfigUnmarshaler func(interface{}) error
 type Configurable interface {
 	Configure(context.Context, ConfigUnmarshaler, *http.Client) error
 }
+
+// Versioned is an interface that Updaters can implement to report the version
+// of their code. The version is recorded with every update operation.
+//
+// Updaters that don't implement it are recorded with the claircore module
+// version, if it's known.
+type Versioned interface {
+	Version() string
+}
@@ -0,0 +1,109 @@
+package libvuln
+
+import (
+	"context"
+	"sort"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/internal/matcher"
+	"github.com/quay/claircore/pkg/kmod"
+	"github.com/quay/claircore/pkg/pkgname"
+)
+
+// ExplainQuery selects what Explain reports on. Empty fields match anything.
+type ExplainQuery struct {
+	// Vulnerability is the name of a vulnerability, such as a CVE ID.
+	Vulnerability string `json:"vulnerability,omitempty"`
+	// Package is the name of a package. Names are compared after
+	// normalization for the package's ecosystem, where that's supported.
+	Package string `json:"package,omitempty"`
+}
+
+// Explanation is the decision path recorded by Explain.
+type Explanation struct {
+	Query ExplainQuery `json:"query"`
+	// Records is every IndexRecord that was considered.
+	Records []*claircore.IndexRecord `json:"records"`
+	// Decisions is what each configured Matcher did with the Records, in the
+	// order the matchers are configured. Only the vulnerabilities selected by
+	// the query are included.
+	Decisions []*MatchDecision `json:"decisions"`
+}
+
+// Explain runs an IndexReport through the configured matchers like Scan does,
+// but instead of a VulnerabilityReport, records why vulnerabilities did or
+// didn't match: which records each matcher was interested in, what the store
+// returned for them, and what was compared when a vulnerability was rejected.
+//
+// This is meant for support tooling answering "why isn't this vulnerability in
+// my report"; it runs the matchers one at a time.
+func (l *Libvuln) Explain(ctx context.Context, ir *claircore.IndexReport, q ExplainQuery) (*Explanation, error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libvuln/Libvuln.Explain",
+		"manifest", ir.Hash.String())
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	out := Explanation{
+		Query:     q,
+		Records:   []*claircore.IndexRecord{},
+		Decisions: make([]*MatchDecision, 0, len(l.matchers)),
+	}
+	for _, r := range ir.IndexRecords() {
+		if q.Package != "" && !samePackage(r, q.Package) {
+			continue
+		}
+		out.Records = append(out.Records, r)
+	}
+	sort.Slice(out.Records, func(i, j int) bool {
+		a, b := out.Records[i], out.Records[j]
+		if a.Package.ID != b.Package.ID {
+			return a.Package.ID < b.Package.ID
+		}
+		return repoID(a) < repoID(b)
+	})
+	for _, m := range l.matchers {
+		d, err := matcher.NewController(m, l.store).Explain(ctx, out.Records)
+		if err != nil {
+			return nil, err
+		}
+		if q.Vulnerability != "" {
+			d = d.Select(q.Vulnerability)
+		}
+		out.Decisions = append(out.Decisions, d)
+	}
+	zlog.Debug(ctx).
+		Int("records", len(out.Records)).
+		Msg("explained")
+	return &out, nil
+}
+
+// SamePackage reports whether the record's package has the provided name.
+func samePackage(r *claircore.IndexRecord, name string) bool {
+	if r.Package == nil {
+		return false
+	}
+	if r.Package.Name == name {
+		return true
+	}
+	if base, ok := kmod.BaseName(r.Package); ok && base == name {
+		return true
+	}
+	if r.Repository == nil || !pkgname.Supported(r.Repository.Name) {
+		return false
+	}
+	eco := r.Repository.Name
+	return pkgname.Normalize(eco, r.Package.Name) == pkgname.Normalize(eco, name)
+}
+
+func repoID(r *claircore.IndexRecord) string {
+	if r.Repository == nil {
+		return ""
+	}
+	return r.Repository.ID
+}
@@ -0,0 +1,203 @@
+package libvuln
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/datastore"
+	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/pkg/pkgname"
+)
+
+// NormalizingStore answers Get like the database does for language packages:
+// names are compared after normalization for the record's ecosystem.
+type normalizingStore struct {
+	datastore.MatcherStore
+	vulns []*claircore.Vulnerability
+}
+
+func (s *normalizingStore) Get(_ context.Context, rs []*claircore.IndexRecord, opts datastore.GetOpts) (map[string][]*claircore.Vulnerability, error) {
+	out := make(map[string][]*claircore.Vulnerability)
+	for _, r := range rs {
+		eco := r.Repository.Name
+		for _, v := range s.vulns {
+			if pkgname.Normalize(eco, v.Package.Name) != pkgname.Normalize(eco, r.Package.Name) {
+				continue
+			}
+			if opts.VersionFiltering && !(r.Package.Version < v.FixedInVersion) {
+				continue
+			}
+			out[r.Package.ID] = append(out[r.Package.ID], v)
+		}
+	}
+	return out, nil
+}
+
+// RepoMatcher is interested in packages from one repository, and considers a
+// package vulnerable if the names and repositories are identical and its
+// version sorts before the fixed version.
+type repoMatcher struct {
+	name, repo string
+}
+
+func (m *repoMatcher) Name() string { return m.name }
+
+func (m *repoMatcher) Filter(r *claircore.IndexRecord) bool {
+	return r.Repository != nil && r.Repository.Name == m.repo
+}
+
+func (*repoMatcher) Query() []driver.MatchConstraint {
+	return []driver.MatchConstraint{driver.PackageName, driver.RepositoryName}
+}
+
+func (*repoMatcher) Vulnerable(_ context.Context, r *claircore.IndexRecord, v *claircore.Vulnerability) (bool, error) {
+	if v.Package.Name != r.Package.Name {
+		return false, nil
+	}
+	if v.Repo != nil && v.Repo.Name != r.Repository.Name {
+		return false, nil
+	}
+	return r.Package.Version < v.FixedInVersion, nil
+}
+
+// FilteringMatcher is a repoMatcher that uses the database's version
+// filtering, but doesn't trust it.
+type filteringMatcher struct {
+	repoMatcher
+}
+
+func (*filteringMatcher) VersionFilter()             {}
+func (*filteringMatcher) VersionAuthoritative() bool { return false }
+
+func TestExplain(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	pypi := &claircore.Repository{ID: "1", Name: "pypi"}
+	ir := &claircore.IndexReport{
+		Packages: map[string]*claircore.Package{
+			"1": {ID: "1", Name: "requests", Version: "2.5"},
+			"2": {ID: "2", Name: "urllib3", Version: "1.0"},
+			"3": {ID: "3", Name: "Foo_Bar", Version: "1.0"},
+		},
+		Repositories: map[string]*claircore.Repository{"1": pypi},
+		Environments: map[string][]*claircore.Environment{
+			"1": {{PackageDB: "python", RepositoryIDs: []string{"1"}}},
+			"2": {{PackageDB: "python", RepositoryIDs: []string{"1"}}},
+			"3": {{PackageDB: "python", RepositoryIDs: []string{"1"}}},
+		},
+	}
+	advisories := []*claircore.Vulnerability{
+		{ID: "1", Name: "CVE-2022-0001", FixedInVersion: "2.0", Package: &claircore.Package{Name: "requests"}},
+		{ID: "2", Name: "CVE-2022-0002", FixedInVersion: "2.0", Package: &claircore.Package{Name: "urllib3"}, Repo: &claircore.Repository{Name: "conda"}},
+		{ID: "3", Name: "CVE-2022-0003", FixedInVersion: "2.0", Package: &claircore.Package{Name: "foo-bar"}},
+		{ID: "4", Name: "CVE-2022-0004", FixedInVersion: "3.0", Package: &claircore.Package{Name: "requests"}},
+	}
+	l := &Libvuln{
+		store: &normalizingStore{vulns: advisories},
+		matchers: []driver.Matcher{
+			&repoMatcher{name: "python", repo: "pypi"},
+			&filteringMatcher{repoMatcher{name: "python-filtering", repo: "pypi"}},
+			&repoMatcher{name: "npm", repo: "npm"},
+		},
+	}
+
+	// Explain returns the single rejection of the named vulnerability by the
+	// first matcher.
+	explain := func(t *testing.T, q ExplainQuery) (*Explanation, *FilteredVulnerability) {
+		t.Helper()
+		ex, err := l.Explain(zlog.Test(ctx, t), ir, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.MarshalIndent(ex, "", "\t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%s", b)
+		if got, want := len(ex.Decisions), len(l.matchers); got != want {
+			t.Fatalf("decisions: got: %d, want: %d", got, want)
+		}
+		d := ex.Decisions[0]
+		if len(d.Matched) != 0 {
+			t.Errorf("unexpected matches: %v", d.Matched)
+		}
+		if got, want := len(d.Filtered), 1; got != want {
+			t.Fatalf("filtered: got: %d, want: %d", got, want)
+		}
+		if npm := ex.Decisions[2]; npm.Interested {
+			t.Errorf("npm: unexpected interest: %+v", npm)
+		}
+		return ex, &d.Filtered[0]
+	}
+
+	t.Run("VersionTooNew", func(t *testing.T) {
+		ex, f := explain(t, ExplainQuery{Vulnerability: "CVE-2022-0001"})
+		if got, want := len(ex.Records), 3; got != want {
+			t.Errorf("records: got: %d, want: %d", got, want)
+		}
+		d := ex.Decisions[0]
+		if got, want := strings.Join(d.Constraints, ","), "PackageName,RepositoryName"; got != want {
+			t.Errorf("constraints: got: %q, want: %q", got, want)
+		}
+		if got, want := f.Comparison.Version, (FieldComparison{Record: "2.5", Vulnerability: "2.0"}); got != want {
+			t.Errorf("version: got: %+v, want: %+v", got, want)
+		}
+		if !strings.Contains(f.Reason, `version "2.5" is not affected`) {
+			t.Errorf("unexpected reason: %q", f.Reason)
+		}
+		// The filtering matcher never sees it: the database removes it.
+		fd := ex.Decisions[1]
+		if len(fd.Candidates) != 0 || len(fd.Filtered) != 0 {
+			t.Errorf("filtering: unexpected candidates: %+v", fd)
+		}
+		if got, want := len(fd.VersionFiltered), 1; got != want {
+			t.Fatalf("version filtered: got: %d, want: %d", got, want)
+		}
+		if r := fd.VersionFiltered[0].Reason; !strings.HasPrefix(r, "removed by database version filtering") {
+			t.Errorf("unexpected reason: %q", r)
+		}
+	})
+
+	t.Run("WrongRepository", func(t *testing.T) {
+		_, f := explain(t, ExplainQuery{Vulnerability: "CVE-2022-0002", Package: "urllib3"})
+		if got, want := f.PackageID, "2"; got != want {
+			t.Errorf("package: got: %q, want: %q", got, want)
+		}
+		if got, want := f.Comparison.Repository, (FieldComparison{Record: "pypi", Vulnerability: "conda"}); got != want {
+			t.Errorf("repository: got: %+v, want: %+v", got, want)
+		}
+		if !strings.Contains(f.Reason, `repository "pypi" does not match "conda"`) {
+			t.Errorf("unexpected reason: %q", f.Reason)
+		}
+	})
+
+	t.Run("NameNormalization", func(t *testing.T) {
+		ex, f := explain(t, ExplainQuery{Vulnerability: "CVE-2022-0003", Package: "foo.bar"})
+		if got, want := len(ex.Records), 1; got != want {
+			t.Fatalf("records: got: %d, want: %d", got, want)
+		}
+		want := FieldComparison{Record: "Foo_Bar", Vulnerability: "foo-bar", Normalized: true}
+		if got := f.Comparison.Package; got != want {
+			t.Errorf("package: got: %+v, want: %+v", got, want)
+		}
+		if !strings.Contains(f.Reason, "only after normalization") {
+			t.Errorf("unexpected reason: %q", f.Reason)
+		}
+	})
+
+	t.Run("Matched", func(t *testing.T) {
+		ex, err := l.Explain(zlog.Test(ctx, t), ir, ExplainQuery{Vulnerability: "CVE-2022-0004"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range ex.Decisions[:2] {
+			if len(d.Matched) != 1 || len(d.Filtered) != 0 || len(d.VersionFiltered) != 0 {
+				t.Errorf("%s: unexpected decision: %+v", d.Matcher, d)
+			}
+		}
+	})
+}
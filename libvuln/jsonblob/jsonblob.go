@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"sort"
 	"sync"
@@ -16,7 +17,10 @@ import (
 	"github.com/quay/claircore/libvuln/driver"
 )
 
-var _ datastore.Updater = (*Store)(nil)
+var (
+	_ datastore.Updater            = (*Store)(nil)
+	_ datastore.ProvenanceRecorder = (*Store)(nil)
+)
 
 // New constructs an empty Store.
 func New() (*Store, error) {
@@ -72,6 +76,7 @@ func (l *Loader) Next() bool {
 			l.next.Updater = l.de.Updater
 			l.next.Fingerprint = l.de.Fingerprint
 			l.next.Date = l.de.Date
+			l.next.Provenance = l.de.Provenance
 		}
 		l.next.Vuln = append(l.next.Vuln, l.de.Vuln)
 		l.de.Vuln = nil // Needed to ensure the Decoder allocates new backing memory.
@@ -137,6 +142,7 @@ type CommonEntry struct {
 	Updater     string
 	Fingerprint driver.Fingerprint
 	Date        time.Time
+	Provenance  *driver.Provenance `json:",omitempty"`
 }
 
 // DiskEntry is a single vulnerability. It's made from unpacking an Entry's
@@ -294,6 +300,24 @@ func (s *Store) RecordUpdaterStatus(ctx context.Context, updaterName string, upd
 	return nil
 }
 
+// RecordProvenance implements datastore.ProvenanceRecorder.
+func (s *Store) RecordProvenance(_ context.Context, ref uuid.UUID, p *driver.Provenance) error {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.entry[ref]
+	if !ok {
+		return fmt.Errorf("unknown update operation %v", ref)
+	}
+	e.Provenance = p
+	ops := s.ops[e.Updater]
+	for i := range ops {
+		if ops[i].Ref == ref {
+			ops[i].Provenance = p
+		}
+	}
+	return nil
+}
+
 // RecordUpdaterSetStatus is unimplemented
 func (s *Store) RecordUpdaterSetStatus(ctx context.Context, updaterSet string, updateTime time.Time) error {
 	return nil
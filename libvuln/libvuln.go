@@ -2,6 +2,7 @@ package libvuln
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/datastore"
+	"github.com/quay/claircore/internal/lifecycle"
 	"github.com/quay/claircore/internal/matcher"
 	"github.com/quay/claircore/libvuln/driver"
 	"github.com/quay/claircore/libvuln/updates"
@@ -34,6 +36,13 @@ type Libvuln struct {
 	enrichers       []driver.Enricher
 	updateRetention int
 	updaters        *updates.Manager
+	// stopBackground cancels the background updater loop, if running.
+	stopBackground context.CancelFunc
+	// ops tracks in-flight calls for Shutdown.
+	ops lifecycle.Tracker
+	// if set, matching is done with this store so that UpdateRefs are
+	// populated.
+	matchStore datastore.MatcherStore
 }
 
 // TODO (crozzy): Find a home for this and stop redefining it.
@@ -89,11 +98,15 @@ func New(ctx context.Context, opts *Options) (*Libvuln, error) {
 		updateRetention: opts.UpdateRetention,
 		enrichers:       opts.Enrichers,
 	}
+	l.matchStore = l.store
+	if opts.UpdateRefs {
+		l.matchStore = updateRefStore{l.store}
+	}
 
 	// create matchers based on the provided config.
 	var err error
 	l.matchers, err = matchers.NewMatchers(ctx,
-		opts.Client,
+		opts.client(ClientPurposeMatcher),
 		matchers.WithEnabled(opts.MatcherNames),
 		matchers.WithConfigs(opts.MatcherConfigs),
 		matchers.WithOutOfTree(opts.Matchers),
@@ -107,11 +120,12 @@ func New(ctx context.Context, opts *Options) (*Libvuln, error) {
 	l.updaters, err = updates.NewManager(ctx,
 		l.store,
 		l.locker,
-		opts.Client,
+		opts.client(ClientPurposeUpdater),
 		updates.WithBatchSize(opts.UpdateWorkers),
 		updates.WithInterval(opts.UpdateInterval),
 		updates.WithEnabled(opts.UpdaterSets),
 		updates.WithConfigs(opts.UpdaterConfigs),
+		updates.WithTransports(opts.UpdaterTransports),
 		updates.WithOutOfTree(opts.Updaters),
 		updates.WithGC(opts.UpdateRetention),
 	)
@@ -121,35 +135,113 @@ func New(ctx context.Context, opts *Options) (*Libvuln, error) {
 
 	// launch background updater
 	if !opts.DisableBackgroundUpdates {
-		go l.updaters.Start(ctx)
+		var bg context.Context
+		bg, l.stopBackground = context.WithCancel(ctx)
+		go l.updaters.Start(bg)
 	}
 
 	zlog.Info(ctx).Msg("libvuln initialized")
 	return l, nil
 }
 
+// ErrShutdown is returned by calls made after Shutdown.
+var ErrShutdown = errors.New("libvuln: shutting down")
+
+// Close releases held resources.
+//
+// Close doesn't wait for in-flight calls or updates; see Shutdown.
 func (l *Libvuln) Close(ctx context.Context) error {
 	l.locker.Close(ctx)
 	l.pool.Close()
 	return nil
 }
 
+// Shutdown stops the Libvuln from accepting new calls, waits for in-flight
+// calls and any background update run to finish, then releases held
+// resources.
+//
+// If the Context is done before then, the remaining work is canceled and
+// waited on. Background updates are stopped before the Locker and the
+// database pool are released. Calls made after Shutdown report ErrShutdown.
+func (l *Libvuln) Shutdown(ctx context.Context) error {
+	ctx = zlog.ContextWithValues(ctx, "component", "libvuln/Libvuln.Shutdown")
+	err := l.ops.Shutdown(ctx)
+	if err != nil {
+		zlog.Warn(ctx).
+			Err(err).
+			Msg("canceled in-flight calls")
+	}
+	if l.stopBackground != nil {
+		if serr := l.updaters.Stop(ctx); serr != nil {
+			zlog.Warn(ctx).
+				Err(serr).
+				Msg("canceled in-flight background update")
+			if err == nil {
+				err = serr
+			}
+		}
+		l.stopBackground()
+		// Wait for the loop to notice the cancellation.
+		l.updaters.Stop(context.Background())
+	}
+	l.locker.Close(ctx)
+	if l.pool != nil {
+		l.pool.Close()
+	}
+	return err
+}
+
+// Begin registers a call for Shutdown to wait on.
+func (l *Libvuln) begin(ctx context.Context) (context.Context, func(), error) {
+	ctx, done, ok := l.ops.Begin(ctx)
+	if !ok {
+		return ctx, done, ErrShutdown
+	}
+	return ctx, done, nil
+}
+
 // FetchUpdates runs configured updaters.
 func (l *Libvuln) FetchUpdates(ctx context.Context) error {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
 	return l.updaters.Run(ctx)
 }
 
 // Scan creates a VulnerabilityReport given a manifest's IndexReport.
 func (l *Libvuln) Scan(ctx context.Context, ir *claircore.IndexReport) (*claircore.VulnerabilityReport, error) {
-	if s, ok := l.store.(matcher.Store); ok {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if s, ok := l.matchStore.(matcher.Store); ok {
 		return matcher.EnrichedMatch(ctx, ir, l.matchers, l.enrichers, s)
 	}
-	return matcher.Match(ctx, ir, l.matchers, l.store)
+	return matcher.Match(ctx, ir, l.matchers, l.matchStore)
+}
+
+// UpdateRefStore asks for UpdateRefs in every Get call.
+type updateRefStore struct {
+	datastore.MatcherStore
+}
+
+// Get implements datastore.Vulnerability.
+func (s updateRefStore) Get(ctx context.Context, records []*claircore.IndexRecord, opts datastore.GetOpts) (map[string][]*claircore.Vulnerability, error) {
+	opts.UpdateRefs = true
+	return s.MatcherStore.Get(ctx, records, opts)
 }
 
 // UpdateOperations returns UpdateOperations in date descending order keyed by the
 // Updater name
 func (l *Libvuln) UpdateOperations(ctx context.Context, kind driver.UpdateKind, updaters ...string) (map[string][]driver.UpdateOperation, error) {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	return l.store.GetUpdateOperations(ctx, kind, updaters...)
 }
 
@@ -159,12 +251,22 @@ func (l *Libvuln) UpdateOperations(ctx context.Context, kind driver.UpdateKind,
 //
 // The number of UpdateOperations deleted is returned.
 func (l *Libvuln) DeleteUpdateOperations(ctx context.Context, ref ...uuid.UUID) (int64, error) {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
 	return l.store.DeleteUpdateOperations(ctx, ref...)
 }
 
 // UpdateDiff returns an UpdateDiff describing the changes between prev
 // and cur.
 func (l *Libvuln) UpdateDiff(ctx context.Context, prev, cur uuid.UUID) (*driver.UpdateDiff, error) {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	return l.store.GetUpdateDiff(ctx, prev, cur)
 }
 
@@ -173,6 +275,11 @@ func (l *Libvuln) UpdateDiff(ctx context.Context, prev, cur uuid.UUID) (*driver.
 //
 // These references are okay to expose externally.
 func (l *Libvuln) LatestUpdateOperations(ctx context.Context, kind driver.UpdateKind) (map[string][]driver.UpdateOperation, error) {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 	return l.store.GetLatestUpdateRefs(ctx, kind)
 }
 
@@ -181,6 +288,11 @@ func (l *Libvuln) LatestUpdateOperations(ctx context.Context, kind driver.Update
 // This can be used by clients to determine if a call to Scan is likely to
 // return new results.
 func (l *Libvuln) LatestUpdateOperation(ctx context.Context, kind driver.UpdateKind) (uuid.UUID, error) {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer done()
 	return l.store.GetLatestUpdateRef(ctx, kind)
 }
 
@@ -190,6 +302,11 @@ func (l *Libvuln) LatestUpdateOperation(ctx context.Context, kind driver.UpdateK
 // The returned int is the number of outstanding UpdateOperations not deleted due to throttling.
 // To run GC to completion use the GCFull method.
 func (l *Libvuln) GC(ctx context.Context) (int64, error) {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
 	if l.updateRetention == 0 {
 		return 0, fmt.Errorf("gc is disabled")
 	}
@@ -203,6 +320,11 @@ func (l *Libvuln) GC(ctx context.Context) (int64, error) {
 // GCFull may return an error accompanied by its other return value,
 // the number of oustanding update operations not deleted.
 func (l *Libvuln) GCFull(ctx context.Context) (int64, error) {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
 	if l.updateRetention == 0 {
 		return 0, fmt.Errorf("gc is disabled")
 	}
@@ -223,6 +345,11 @@ func (l *Libvuln) GCFull(ctx context.Context) (int64, error) {
 
 // Initialized reports whether the backing vulnerability store is initialized.
 func (l *Libvuln) Initialized(ctx context.Context) (bool, error) {
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer done()
 	return l.store.Initialized(ctx)
 }
 
@@ -3,6 +3,7 @@ package libvuln
 import (
 	"bytes"
 	"context"
+	"net/http"
 	"testing"
 
 	"github.com/rs/zerolog"
@@ -44,3 +45,61 @@ func (*TestMatcher) Query() []driver.MatchConstraint {
 func (*TestMatcher) Vulnerable(context.Context, *claircore.IndexRecord, *claircore.Vulnerability) (bool, error) {
 	return false, nil
 }
+
+func TestClientFactory(t *testing.T) {
+	def, upd, match := &http.Client{}, &http.Client{}, &http.Client{}
+	tt := []struct {
+		name    string
+		opts    Options
+		purpose string
+		want    *http.Client
+	}{
+		{
+			name:    "NoFactory",
+			opts:    Options{Client: def},
+			purpose: ClientPurposeUpdater,
+			want:    def,
+		},
+		{
+			name: "Updater",
+			opts: Options{Client: def, ClientFactory: func(p string) *http.Client {
+				switch p {
+				case ClientPurposeUpdater:
+					return upd
+				case ClientPurposeMatcher:
+					return match
+				}
+				return nil
+			}},
+			purpose: ClientPurposeUpdater,
+			want:    upd,
+		},
+		{
+			name: "Matcher",
+			opts: Options{Client: def, ClientFactory: func(p string) *http.Client {
+				switch p {
+				case ClientPurposeUpdater:
+					return upd
+				case ClientPurposeMatcher:
+					return match
+				}
+				return nil
+			}},
+			purpose: ClientPurposeMatcher,
+			want:    match,
+		},
+		{
+			name:    "Fallback",
+			opts:    Options{Client: def, ClientFactory: func(string) *http.Client { return nil }},
+			purpose: ClientPurposeMatcher,
+			want:    def,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.opts.client(tc.purpose); got != tc.want {
+				t.Errorf("got: %p, want: %p", got, tc.want)
+			}
+		})
+	}
+}
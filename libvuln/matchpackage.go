@@ -0,0 +1,109 @@
+package libvuln
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/internal/matcher"
+)
+
+// MatchDecision is the record of how a single configured Matcher handled a
+// package passed to ExplainPackage.
+type MatchDecision = matcher.Decision
+
+// FilteredVulnerability is a vulnerability a Matcher considered and rejected,
+// along with the reason.
+type FilteredVulnerability = matcher.Filtered
+
+// MatchComparison lays out the values compared when a vulnerability was
+// rejected.
+type MatchComparison = matcher.Comparison
+
+// FieldComparison is a pair of compared values in a MatchComparison.
+type FieldComparison = matcher.Field
+
+// MatchPackage runs a single package through the configured matchers and
+// returns the vulnerabilities reported for it, without assembling a
+// VulnerabilityReport.
+//
+// One IndexRecord is constructed for every provided Repository, or a single
+// one with no Repository if none are provided. The package need not have an
+// ID. Vulnerabilities reported by multiple matchers are only returned once.
+func (l *Libvuln) MatchPackage(ctx context.Context, pkg *claircore.Package, dist *claircore.Distribution, repos []*claircore.Repository) ([]*claircore.Vulnerability, error) {
+	vs, _, err := l.matchPackage(ctx, pkg, dist, repos)
+	return vs, err
+}
+
+// ExplainPackage is like MatchPackage, but additionally returns the decision
+// trail of every configured matcher, in the order the matchers are
+// configured.
+//
+// This is meant for debugging and support tooling; it runs the matchers one at
+// a time.
+func (l *Libvuln) ExplainPackage(ctx context.Context, pkg *claircore.Package, dist *claircore.Distribution, repos []*claircore.Repository) ([]*claircore.Vulnerability, []*MatchDecision, error) {
+	return l.matchPackage(ctx, pkg, dist, repos)
+}
+
+func (l *Libvuln) matchPackage(ctx context.Context, pkg *claircore.Package, dist *claircore.Distribution, repos []*claircore.Repository) ([]*claircore.Vulnerability, []*MatchDecision, error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libvuln/Libvuln.MatchPackage",
+		"package", pkg.Name)
+	ctx, done, err := l.begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	if len(repos) == 0 {
+		repos = []*claircore.Repository{nil}
+	}
+	recs := make([]*claircore.IndexRecord, len(repos))
+	// The store keys results by package ID, so every record needs its own
+	// copy of the package with a distinct ID. The package's own ID is used
+	// where that's possible; otherwise one is made up, and made-up IDs are
+	// mapped back to the package's in the decisions.
+	ids := make(map[string]string)
+	for i, r := range repos {
+		p := *pkg
+		if p.ID == "" || len(repos) > 1 {
+			p.ID = strconv.Itoa(i)
+			if pkg.ID != "" {
+				ids[p.ID] = pkg.ID
+			}
+		}
+		recs[i] = &claircore.IndexRecord{
+			Package:      &p,
+			Distribution: dist,
+			Repository:   r,
+		}
+	}
+
+	var out []*claircore.Vulnerability
+	ds := make([]*MatchDecision, 0, len(l.matchers))
+	seen := make(map[string]struct{})
+	for _, m := range l.matchers {
+		d, err := matcher.NewController(m, l.store).Explain(ctx, recs)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, id := range d.Records {
+			if orig, ok := ids[id]; ok {
+				d.Records[i] = orig
+			}
+		}
+		ds = append(ds, d)
+		for _, v := range d.Matched {
+			if _, ok := seen[v.ID]; ok {
+				continue
+			}
+			seen[v.ID] = struct{}{}
+			out = append(out, v)
+		}
+	}
+	zlog.Debug(ctx).
+		Int("vulnerabilities", len(out)).
+		Msg("matched package")
+	return out, ds, nil
+}
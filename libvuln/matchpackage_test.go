@@ -0,0 +1,187 @@
+package libvuln
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/datastore"
+	"github.com/quay/claircore/libvuln/driver"
+)
+
+// AdvisoryStore is a store that only answers Get, from a fixed list of
+// advisories keyed by package name.
+type advisoryStore struct {
+	datastore.MatcherStore
+	vulns []*claircore.Vulnerability
+}
+
+func (s *advisoryStore) Get(_ context.Context, rs []*claircore.IndexRecord, opts datastore.GetOpts) (map[string][]*claircore.Vulnerability, error) {
+	out := make(map[string][]*claircore.Vulnerability)
+	for _, r := range rs {
+		for _, v := range s.vulns {
+			if v.Package.Name != r.Package.Name {
+				continue
+			}
+			if opts.VersionFiltering && !(r.Package.Version < v.FixedInVersion) {
+				continue
+			}
+			out[r.Package.ID] = append(out[r.Package.ID], v)
+		}
+	}
+	return out, nil
+}
+
+// DistMatcher is interested in packages from one distribution, and considers
+// a package vulnerable if its version sorts before the fixed version.
+type distMatcher struct {
+	name, dist string
+}
+
+func (m *distMatcher) Name() string { return m.name }
+
+func (m *distMatcher) Filter(r *claircore.IndexRecord) bool {
+	return r.Distribution != nil && r.Distribution.DID == m.dist
+}
+
+func (*distMatcher) Query() []driver.MatchConstraint { return nil }
+
+func (*distMatcher) Vulnerable(_ context.Context, r *claircore.IndexRecord, v *claircore.Vulnerability) (bool, error) {
+	return r.Package.Version < v.FixedInVersion, nil
+}
+
+// AuthoritativeMatcher trusts the store's version filtering.
+type authoritativeMatcher struct {
+	distMatcher
+}
+
+func (*authoritativeMatcher) VersionFilter()             {}
+func (*authoritativeMatcher) VersionAuthoritative() bool { return true }
+
+func (*authoritativeMatcher) Vulnerable(context.Context, *claircore.IndexRecord, *claircore.Vulnerability) (bool, error) {
+	panic("Vulnerable called on authoritative matcher")
+}
+
+func TestMatchPackage(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	advisories := []*claircore.Vulnerability{
+		{ID: "1", Name: "CVE-2021-0001", FixedInVersion: "1.1.1l", Package: &claircore.Package{Name: "openssl"}},
+		{ID: "2", Name: "CVE-2021-0002", FixedInVersion: "1.1.1j", Package: &claircore.Package{Name: "openssl"}},
+		{ID: "3", Name: "CVE-2021-0003", FixedInVersion: "1.1.1m", Package: &claircore.Package{Name: "openssl"}},
+		{ID: "4", Name: "CVE-2021-0004", FixedInVersion: "9.9", Package: &claircore.Package{Name: "zlib"}},
+	}
+	l := &Libvuln{
+		store: &advisoryStore{vulns: advisories},
+		matchers: []driver.Matcher{
+			&distMatcher{name: "debian", dist: "debian"},
+			&distMatcher{name: "alpine", dist: "alpine"},
+			&authoritativeMatcher{distMatcher{name: "debian-authoritative", dist: "debian"}},
+		},
+	}
+	pkg := &claircore.Package{Name: "openssl", Version: "1.1.1k"}
+	dist := &claircore.Distribution{DID: "debian"}
+	byID := cmp.Transformer("IDs", func(vs []*claircore.Vulnerability) []string {
+		ids := make([]string, len(vs))
+		for i, v := range vs {
+			ids[i] = v.ID
+		}
+		sort.Strings(ids)
+		return ids
+	})
+
+	t.Run("Match", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		got, err := l.MatchPackage(ctx, pkg, dist, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []*claircore.Vulnerability{advisories[0], advisories[2]}
+		if !cmp.Equal(got, want, byID) {
+			t.Error(cmp.Diff(got, want, byID))
+		}
+		if pkg.ID != "" {
+			t.Error("passed package modified")
+		}
+	})
+
+	t.Run("Explain", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		repos := []*claircore.Repository{{Name: "main"}, {Name: "security"}}
+		_, ds, err := l.ExplainPackage(ctx, pkg, dist, repos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(ds), len(l.matchers); got != want {
+			t.Fatalf("decisions: got: %d, want: %d", got, want)
+		}
+		for _, d := range ds {
+			t.Logf("%+v", d)
+		}
+
+		deb := ds[0]
+		if !deb.Interested || deb.VersionFiltering || deb.Authoritative {
+			t.Errorf("debian: unexpected decision: %+v", deb)
+		}
+		// Each candidate appears once per repository.
+		if got, want := len(deb.Candidates), 6; got != want {
+			t.Errorf("debian candidates: got: %d, want: %d", got, want)
+		}
+		if got, want := len(deb.Filtered), 2; got != want {
+			t.Fatalf("debian filtered: got: %d, want: %d", got, want)
+		}
+		for _, f := range deb.Filtered {
+			if f.Vulnerability.ID != "2" || f.Reason == "" {
+				t.Errorf("debian: unexpected filtered vulnerability: %+v", f)
+			}
+		}
+
+		if alp := ds[1]; alp.Interested || len(alp.Candidates) != 0 || len(alp.Matched) != 0 {
+			t.Errorf("alpine: unexpected decision: %+v", alp)
+		}
+
+		auth := ds[2]
+		if !auth.Interested || !auth.VersionFiltering || !auth.Authoritative {
+			t.Errorf("authoritative: unexpected decision: %+v", auth)
+		}
+		if len(auth.Filtered) != 0 {
+			t.Errorf("authoritative: unexpected filtered vulnerabilities: %+v", auth.Filtered)
+		}
+		want := []*claircore.Vulnerability{advisories[0], advisories[0], advisories[2], advisories[2]}
+		if !cmp.Equal(auth.Matched, want, byID) {
+			t.Error(cmp.Diff(auth.Matched, want, byID))
+		}
+	})
+	t.Run("PackageID", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		pkg := *pkg
+		pkg.ID = "42"
+		for _, repos := range [][]*claircore.Repository{
+			nil,
+			{{Name: "main"}, {Name: "security"}},
+		} {
+			got, ds, err := l.ExplainPackage(ctx, &pkg, dist, repos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []*claircore.Vulnerability{advisories[0], advisories[2]}
+			if !cmp.Equal(got, want, byID) {
+				t.Error(cmp.Diff(got, want, byID))
+			}
+			if pkg.ID != "42" {
+				t.Error("passed package modified")
+			}
+			for _, id := range ds[0].Records {
+				if id != pkg.ID {
+					t.Errorf("record reported with ID %q, want %q", id, pkg.ID)
+				}
+			}
+			if got, want := len(ds[0].Records), len(repos); want > 0 && got != want {
+				t.Errorf("records: got: %d, want: %d", got, want)
+			}
+		}
+	})
+}
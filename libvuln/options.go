@@ -6,6 +6,7 @@ import (
 
 	"github.com/quay/claircore/datastore"
 	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/libvuln/updates"
 )
 
 const (
@@ -15,6 +16,16 @@ const (
 	DefaultUpdateRetention = 2
 )
 
+// These are the purpose strings libvuln passes to Options.ClientFactory.
+const (
+	// ClientPurposeUpdater is requested for updaters downloading security
+	// databases.
+	ClientPurposeUpdater = "updater"
+	// ClientPurposeMatcher is requested for matchers, including remote
+	// matchers that make RPC calls.
+	ClientPurposeMatcher = "matcher"
+)
+
 type Options struct {
 	// Store is the interface used to persist and retrieve vulnerabilites
 	// for of matching.
@@ -67,6 +78,7 @@ type Options struct {
 	// "suse"
 	// "ubuntu"
 	// "crda" - remotematcher calls hosted api via RPC.
+	// "nvd" - matches CPEs against NVD feeds; only used if feeds are configured.
 	MatcherNames []string
 
 	// Config holds configuration blocks for MatcherFactories and Matchers,
@@ -98,10 +110,38 @@ type Options struct {
 	// run updaters.
 	DisableBackgroundUpdates bool
 
+	// If set to true, each Vulnerability in a VulnerabilityReport has its
+	// UpdateRef populated with the update operation it came from, so it can
+	// be traced back to the operation's provenance. This makes reports
+	// larger and matching somewhat slower.
+	UpdateRefs bool
+
 	// UpdaterConfigs is a map of functions for configuration of Updaters.
 	UpdaterConfigs map[string]driver.ConfigUnmarshaler
 
-	// Client is an http.Client for use by all updaters. If unset,
-	// http.DefaultClient will be used.
+	// UpdaterTransports configures dedicated HTTP clients, keyed by updater
+	// or updater set name, for feeds needing a proxy, private CA, client
+	// certificate, or extra headers. These clients are derived from the
+	// updater client and don't affect any other use.
+	UpdaterTransports map[string]updates.TransportConfig
+
+	// Client is an http.Client for use by all updaters and matchers. If
+	// unset, http.DefaultClient will be used.
 	Client *http.Client
+
+	// ClientFactory, if provided, is consulted for an *http.Client for each
+	// purpose. The argument is one of the ClientPurpose constants.
+	//
+	// If ClientFactory is nil or returns nil, Client is used.
+	ClientFactory func(purpose string) *http.Client
+}
+
+// Client returns the *http.Client to use for the provided purpose.
+func (o *Options) client(purpose string) *http.Client {
+	if o.ClientFactory != nil {
+		if c := o.ClientFactory(purpose); c != nil {
+			return c
+		}
+	}
+	return o.Client
 }
@@ -0,0 +1,105 @@
+package libvuln
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/libvuln/driver"
+)
+
+// DetailMatcher is a repoMatcher that reports its matches as range matches
+// made in the context of the record's repository.
+type detailMatcher struct {
+	repoMatcher
+}
+
+var _ driver.MatchDetailer = (*detailMatcher)(nil)
+
+func (m *detailMatcher) VulnerableDetail(ctx context.Context, r *claircore.IndexRecord, v *claircore.Vulnerability) (bool, *claircore.MatchDetail, error) {
+	ok, err := m.Vulnerable(ctx, r, v)
+	if !ok || err != nil {
+		return ok, nil, err
+	}
+	return true, &claircore.MatchDetail{
+		Repository: r.Repository.ID,
+		Method:     claircore.MatchRange,
+	}, nil
+}
+
+func TestScanMatchDetails(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	layer := claircore.MustParseDigest(`sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef`)
+	ir := &claircore.IndexReport{
+		Hash: claircore.MustParseDigest(`sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad`),
+		Packages: map[string]*claircore.Package{
+			"1": {ID: "1", Name: "requests", Version: "1.0"},
+			"2": {ID: "2", Name: "urllib3", Version: "1.0"},
+		},
+		Distributions: map[string]*claircore.Distribution{
+			"1": {ID: "1", DID: "rhel", VersionID: "9"},
+		},
+		Repositories: map[string]*claircore.Repository{
+			"1": {ID: "1", Name: "pypi"},
+		},
+		Environments: map[string][]*claircore.Environment{
+			"1": {{PackageDB: "python", DistributionID: "1", RepositoryIDs: []string{"1"}, IntroducedIn: layer}},
+			"2": {{PackageDB: "python", DistributionID: "1", RepositoryIDs: []string{"1"}, IntroducedIn: layer}},
+		},
+	}
+	advisories := []*claircore.Vulnerability{
+		{ID: "1", Name: "CVE-2022-0001", FixedInVersion: "2.0", Package: &claircore.Package{Name: "requests"}},
+		{ID: "2", Name: "CVE-2022-0002", FixedInVersion: "0.5", Package: &claircore.Package{Name: "urllib3"}},
+	}
+	store := &normalizingStore{vulns: advisories}
+	l := &Libvuln{
+		store:      store,
+		matchStore: store,
+		matchers: []driver.Matcher{
+			&repoMatcher{name: "plain", repo: "pypi"},
+			&detailMatcher{repoMatcher{name: "detailed", repo: "pypi"}},
+		},
+	}
+
+	vr, err := l.Scan(ctx, ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The report survives a round trip.
+	b, err := json.Marshal(vr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got claircore.VulnerabilityReport
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, ds := range got.MatchDetails {
+		sort.Slice(ds, func(i, j int) bool { return ds[i].Matcher < ds[j].Matcher })
+	}
+	want := map[string][]*claircore.MatchDetail{
+		"1": {
+			{
+				Vulnerability: "1",
+				Matcher:       "detailed",
+				Repository:    "1",
+				Method:        claircore.MatchRange,
+			},
+			{
+				Vulnerability: "1",
+				Matcher:       "plain",
+				Distribution:  "1",
+				Repository:    "1",
+				Method:        claircore.MatchFixedVersion,
+			},
+		},
+	}
+	if !cmp.Equal(got.MatchDetails, want) {
+		t.Error(cmp.Diff(got.MatchDetails, want))
+	}
+}
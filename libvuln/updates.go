@@ -51,6 +51,11 @@ Update:
 		if err != nil {
 			return err
 		}
+		if e.Provenance != nil {
+			if err := s.RecordProvenance(ctx, ref, e.Provenance); err != nil {
+				return err
+			}
+		}
 		zlog.Info(ctx).
 			Str("updater", e.Updater).
 			Str("ref", ref.String()).
@@ -0,0 +1,146 @@
+package updates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/datastore"
+	"github.com/quay/claircore/libvuln/driver"
+)
+
+// FeedSummary is a tally of the vulnerabilities in a feed.
+type FeedSummary struct {
+	// Total is the number of vulnerabilities.
+	Total int `json:"total"`
+	// Severity is keyed by normalized severity.
+	Severity map[string]int `json:"severity"`
+	// Release is keyed by the distribution's pretty name or, failing that,
+	// the repository name.
+	Release map[string]int `json:"release"`
+}
+
+// Summarize tallies the provided vulnerabilities.
+func Summarize(vs []*claircore.Vulnerability) FeedSummary {
+	s := FeedSummary{
+		Total:    len(vs),
+		Severity: make(map[string]int),
+		Release:  make(map[string]int),
+	}
+	for _, v := range vs {
+		s.Severity[v.NormalizedSeverity.String()]++
+		s.Release[releaseKey(v)]++
+	}
+	return s
+}
+
+// ReleaseKey returns the key used for the "release" tally of a vulnerability.
+func releaseKey(v *claircore.Vulnerability) string {
+	switch {
+	case v.Dist != nil && v.Dist.PrettyName != "":
+		return v.Dist.PrettyName
+	case v.Repo != nil && v.Repo.Name != "":
+		return v.Repo.Name
+	}
+	return ""
+}
+
+// Drift describes how a live feed compares to the most recent update
+// operation for the same Updater.
+type Drift struct {
+	// Updater is the name of the Updater examined.
+	Updater string `json:"updater"`
+	// Previous is the most recent update operation. If the Updater has never
+	// run, this is the zero value.
+	Previous driver.UpdateOperation `json:"previous"`
+	// Stored is the summary of the vulnerabilities recorded by the Previous
+	// update operation.
+	Stored FeedSummary `json:"stored"`
+	// Live is the summary of the vulnerabilities parsed from the feed as it
+	// currently exists.
+	Live FeedSummary `json:"live"`
+}
+
+// Shrunk reports whether any severity or release has fewer vulnerabilities in
+// the live feed than were stored previously.
+//
+// A feed changing shape upstream usually shows up as a parser emitting fewer
+// vulnerabilities rather than failing outright.
+func (d *Drift) Shrunk() bool {
+	if d.Live.Total < d.Stored.Total {
+		return true
+	}
+	for k, n := range d.Stored.Severity {
+		if d.Live.Severity[k] < n {
+			return true
+		}
+	}
+	for k, n := range d.Stored.Release {
+		if d.Live.Release[k] < n {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckDrift fetches and parses the live feed for the provided Updater and
+// compares it to the most recent update operation in the store.
+//
+// The Updater should be configured before being passed to CheckDrift. Nothing
+// is written to the store.
+func CheckDrift(ctx context.Context, store datastore.Updater, u driver.Updater) (*Drift, error) {
+	name := u.Name()
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "libvuln/updates/CheckDrift",
+		"updater", name)
+	d := Drift{Updater: name}
+
+	ops, err := store.GetUpdateOperations(ctx, driver.VulnerabilityKind, name)
+	if err != nil {
+		return nil, fmt.Errorf("updates: unable to get update operations: %w", err)
+	}
+	// Update operations are returned newest first.
+	if prev := ops[name]; len(prev) != 0 {
+		d.Previous = prev[0]
+		diff, err := store.GetUpdateDiff(ctx, uuid.Nil, d.Previous.Ref)
+		if err != nil {
+			return nil, fmt.Errorf("updates: unable to get stored vulnerabilities: %w", err)
+		}
+		vs := make([]*claircore.Vulnerability, len(diff.Added))
+		for i := range diff.Added {
+			vs[i] = &diff.Added[i]
+		}
+		d.Stored = Summarize(vs)
+	} else {
+		d.Stored = Summarize(nil)
+		zlog.Debug(ctx).Msg("no previous update operation")
+	}
+
+	// Always fetch with an empty fingerprint: the point is to look at the
+	// feed as it exists right now.
+	rc, _, err := u.Fetch(ctx, "")
+	switch {
+	case errors.Is(err, nil):
+	case errors.Is(err, driver.Unchanged):
+		return nil, fmt.Errorf("updates: updater %q reported unchanged for empty fingerprint", name)
+	default:
+		return nil, fmt.Errorf("updates: unable to fetch: %w", err)
+	}
+	defer rc.Close()
+	vs, err := u.Parse(ctx, rc)
+	if err != nil {
+		return nil, fmt.Errorf("updates: unable to parse: %w", err)
+	}
+	d.Live = Summarize(vs)
+
+	zlog.Info(ctx).
+		Int("stored", d.Stored.Total).
+		Int("live", d.Live.Total).
+		Bool("shrunk", d.Shrunk()).
+		Msg("drift check complete")
+	return &d, nil
+}
@@ -0,0 +1,108 @@
+package updates
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/datastore"
+	"github.com/quay/claircore/libvuln/driver"
+)
+
+// DriftStore implements just enough of datastore.Updater for CheckDrift.
+type driftStore struct {
+	datastore.Updater
+	ops    map[string][]driver.UpdateOperation
+	stored []claircore.Vulnerability
+}
+
+func (s *driftStore) GetUpdateOperations(_ context.Context, _ driver.UpdateKind, names ...string) (map[string][]driver.UpdateOperation, error) {
+	return s.ops, nil
+}
+
+func (s *driftStore) GetUpdateDiff(_ context.Context, prev, cur uuid.UUID) (*driver.UpdateDiff, error) {
+	return &driver.UpdateDiff{Added: s.stored}, nil
+}
+
+// DriftUpdater returns a fixed set of vulnerabilities.
+type driftUpdater []*claircore.Vulnerability
+
+func (driftUpdater) Name() string { return "drift" }
+
+func (driftUpdater) Fetch(context.Context, driver.Fingerprint) (io.ReadCloser, driver.Fingerprint, error) {
+	return io.NopCloser(strings.NewReader("")), "", nil
+}
+
+func (u driftUpdater) Parse(context.Context, io.ReadCloser) ([]*claircore.Vulnerability, error) {
+	return u, nil
+}
+
+func TestCheckDrift(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	a := &claircore.Distribution{PrettyName: "A"}
+	b := &claircore.Distribution{PrettyName: "B"}
+	stored := []claircore.Vulnerability{
+		{Name: "1", NormalizedSeverity: claircore.High, Dist: a},
+		{Name: "2", NormalizedSeverity: claircore.Low, Dist: a},
+		{Name: "3", NormalizedSeverity: claircore.Low, Dist: b},
+	}
+
+	t.Run("Shrunk", func(t *testing.T) {
+		s := &driftStore{
+			ops: map[string][]driver.UpdateOperation{
+				"drift": {{Ref: uuid.New(), Updater: "drift"}},
+			},
+			stored: stored,
+		}
+		// Same total, but all of release "B" has gone missing.
+		u := driftUpdater{
+			{Name: "1", NormalizedSeverity: claircore.High, Dist: a},
+			{Name: "2", NormalizedSeverity: claircore.Low, Dist: a},
+			{Name: "4", NormalizedSeverity: claircore.Low, Dist: a},
+		}
+		d, err := CheckDrift(ctx, s, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := FeedSummary{
+			Total:    3,
+			Severity: map[string]int{"High": 1, "Low": 2},
+			Release:  map[string]int{"A": 3},
+		}
+		if got := d.Live; !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+		want.Release = map[string]int{"A": 2, "B": 1}
+		if got := d.Stored; !cmp.Equal(got, want) {
+			t.Error(cmp.Diff(got, want))
+		}
+		if !d.Shrunk() {
+			t.Error("expected drift to be reported")
+		}
+	})
+	t.Run("NoPrevious", func(t *testing.T) {
+		s := &driftStore{}
+		u := driftUpdater{
+			{Name: "1", NormalizedSeverity: claircore.High, Dist: a},
+		}
+		d, err := CheckDrift(ctx, s, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := d.Stored.Total, 0; got != want {
+			t.Errorf("got: %d, want: %d", got, want)
+		}
+		if got, want := d.Live.Total, 1; got != want {
+			t.Errorf("got: %d, want: %d", got, want)
+		}
+		if d.Shrunk() {
+			t.Error("unexpected drift reported")
+		}
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -55,9 +56,20 @@ type Manager struct {
 	// update operations to keep.
 	updateRetention int
 
+	// transports to construct dedicated clients from, keyed by updater or
+	// factory name.
+	transports map[string]TransportConfig
+	// clients constructed from transports.
+	clients map[string]*http.Client
+
 	locks  LockSource
 	client *http.Client
 	store  datastore.Updater
+
+	// stop and done are set when Start is called, to coordinate with Stop.
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
 }
 
 // NewManager will return a manager ready to have its Start or Run methods called.
@@ -83,7 +95,34 @@ func NewManager(ctx context.Context, store datastore.Updater, locks LockSource,
 		return nil, errors.New("update retention cannot be 1")
 	}
 
-	err := updater.Configure(ctx, m.factories, m.configs, m.client)
+	if m.client == nil {
+		return nil, errors.New("passed invalid *http.Client")
+	}
+	m.clients = make(map[string]*http.Client, len(m.transports))
+	for name, tc := range m.transports {
+		c, err := tc.client(m.client)
+		if err != nil {
+			return nil, fmt.Errorf("updater %q: invalid transport configuration: %w", name, err)
+		}
+		m.clients[name] = recordingClient(c)
+	}
+	m.client = recordingClient(m.client)
+
+	// Factories with a dedicated client are configured one at a time, the
+	// rest share the Manager's client.
+	shared := make(map[string]driver.UpdaterSetFactory, len(m.factories))
+	for name, f := range m.factories {
+		c, ok := m.clients[name]
+		if !ok {
+			shared[name] = f
+			continue
+		}
+		err := updater.Configure(ctx, map[string]driver.UpdaterSetFactory{name: f}, m.configs, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure updater set factory: %w", err)
+		}
+	}
+	err := updater.Configure(ctx, shared, m.configs, m.client)
 	if err != nil {
 		return nil, fmt.Errorf("failed to configure updater set factory: %w", err)
 	}
@@ -91,6 +130,30 @@ func NewManager(ctx context.Context, store datastore.Updater, locks LockSource,
 	return m, nil
 }
 
+// Configure configures the updaters that implement driver.Configurable,
+// returning the ones that should be run.
+func (m *Manager) configure(ctx context.Context, updaters []driver.Updater) []driver.Updater {
+	toRun := make([]driver.Updater, 0, len(updaters))
+	for _, u := range updaters {
+		if f, ok := u.(driver.Configurable); ok {
+			name := u.Name()
+			cfg := m.configs[name]
+			if cfg == nil {
+				cfg = noopConfig
+			}
+			if err := f.Configure(ctx, cfg, m.clientFor(name)); err != nil {
+				zlog.Warn(ctx).
+					Err(err).
+					Str("updater", name).
+					Msg("failed configuring updater, excluding from current run")
+				continue
+			}
+		}
+		toRun = append(toRun, u)
+	}
+	return toRun
+}
+
 // Start will run updaters at the given interval.
 //
 // Start is designed to be ran as a goroutine. Cancel the provided Context
@@ -103,6 +166,11 @@ func (m *Manager) Start(ctx context.Context) error {
 	if m.interval == 0 {
 		return fmt.Errorf("manager must be configured with an interval to start")
 	}
+	m.mu.Lock()
+	stop, done := make(chan struct{}), make(chan struct{})
+	m.stop, m.done = stop, done
+	m.mu.Unlock()
+	defer close(done)
 
 	// perform the initial run
 	zlog.Info(ctx).Msg("starting initial updates")
@@ -119,6 +187,9 @@ func (m *Manager) Start(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case <-stop:
+			zlog.Info(ctx).Msg("stopping background updates")
+			return nil
 		case <-t.C:
 			err := m.Run(ctx)
 			if err != nil {
@@ -128,6 +199,34 @@ func (m *Manager) Start(ctx context.Context) error {
 	}
 }
 
+// Stop stops the background updates begun by Start and waits for Start to
+// return.
+//
+// An in-flight run is allowed to finish. If the Context is done first, Stop
+// returns the Context's error; the caller should then cancel the Context
+// passed to Start. Stop returns immediately if Start was never called.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	stop, done := m.stop, m.done
+	if stop != nil {
+		select {
+		case <-stop:
+		default:
+			close(stop)
+		}
+	}
+	m.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Run constructs updaters from factories, configures them and runs them
 // in batches.
 //
@@ -168,25 +267,7 @@ func (m *Manager) Run(ctx context.Context) error {
 		updaters = append(updaters, set.Updaters()...)
 	}
 
-	// configure updaters
-	toRun := make([]driver.Updater, 0, len(updaters))
-	for _, u := range updaters {
-		if f, ok := u.(driver.Configurable); ok {
-			name := u.Name()
-			cfg := m.configs[name]
-			if cfg == nil {
-				cfg = noopConfig
-			}
-			if err := f.Configure(ctx, cfg, m.client); err != nil {
-				zlog.Warn(ctx).
-					Err(err).
-					Str("updater", name).
-					Msg("failed configuring updater, excluding from current run")
-				continue
-			}
-		}
-		toRun = append(toRun, u)
-	}
+	toRun := m.configure(ctx, updaters)
 
 	zlog.Info(ctx).
 		Int("total", len(toRun)).
@@ -322,6 +403,9 @@ func (m *Manager) driveUpdater(ctx context.Context, u driver.Updater) (err error
 		prevFP = s[0].Fingerprint
 	}
 
+	// Any requests made with this Context are recorded as the update's
+	// provenance.
+	ctx, rec := withRecorder(ctx)
 	var vulnDB io.ReadCloser
 	switch {
 	case euOK:
@@ -370,6 +454,16 @@ func (m *Manager) driveUpdater(ctx context.Context, u driver.Updater) (err error
 	zlog.Info(ctx).
 		Str("ref", ref.String()).
 		Msg("successful update")
+	if pr, ok := m.store.(datastore.ProvenanceRecorder); ok {
+		// The update is already committed, so a failure here isn't an
+		// updater failure.
+		if err := pr.RecordProvenance(ctx, ref, rec.provenance(updaterVersion(u))); err != nil {
+			zlog.Error(ctx).
+				Err(err).
+				Str("ref", ref.String()).
+				Msg("unable to record provenance")
+		}
+	}
 	return nil
 }
 
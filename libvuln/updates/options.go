@@ -63,6 +63,16 @@ func WithConfigs(cfgs Configs) ManagerOption {
 	}
 }
 
+// WithTransports configures dedicated HTTP clients for the named updaters or
+// updater set factories. Each client is derived from the Manager's client.
+//
+// Invalid configurations cause NewManager to return an error.
+func WithTransports(ts map[string]TransportConfig) ManagerOption {
+	return func(m *Manager) {
+		m.transports = ts
+	}
+}
+
 // WithOutOfTree allows callers to provide their own out-of-tree
 // updaters.
 //
@@ -0,0 +1,152 @@
+package updates
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/quay/claircore/libvuln/driver"
+)
+
+// Recorder collects the documents fetched during a single update.
+type recorder struct {
+	mu      sync.Mutex
+	sources []driver.Source
+}
+
+type recorderKey struct{}
+
+// WithRecorder returns a Context that causes requests made with it through a
+// provenanceTransport to be recorded in the returned recorder.
+func withRecorder(ctx context.Context) (context.Context, *recorder) {
+	r := &recorder{}
+	return context.WithValue(ctx, recorderKey{}, r), r
+}
+
+func recorderFrom(ctx context.Context) *recorder {
+	r, _ := ctx.Value(recorderKey{}).(*recorder)
+	return r
+}
+
+// Add records a new source and returns its index.
+func (r *recorder) add(u string, t time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources = append(r.sources, driver.Source{URL: u, Fetched: t})
+	return len(r.sources) - 1
+}
+
+// SetDigest fills in the digest of the source at index "i".
+func (r *recorder) setDigest(i int, sum string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources[i].SHA256 = sum
+}
+
+// Provenance reports everything recorded so far.
+func (r *recorder) provenance(version string) *driver.Provenance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &driver.Provenance{
+		UpdaterVersion: version,
+		Sources:        append([]driver.Source{}, r.sources...),
+	}
+}
+
+// ProvenanceTransport records successful responses to requests made with a
+// Context from withRecorder. Responses are hashed as they're read.
+type provenanceTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *provenanceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(r)
+	rec := recorderFrom(r.Context())
+	switch {
+	case err != nil, rec == nil, r.Method == http.MethodHead:
+		return res, err
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return res, err
+	}
+	u := *r.URL
+	u.User = nil
+	i := rec.add(u.String(), time.Now())
+	res.Body = &hashingBody{
+		ReadCloser: res.Body,
+		h:          sha256.New(),
+		done:       func(sum string) { rec.setDigest(i, sum) },
+	}
+	return res, nil
+}
+
+// HashingBody calls "done" with the digest of the body once it's been read to
+// EOF.
+type hashingBody struct {
+	io.ReadCloser
+	h    hash.Hash
+	done func(string)
+}
+
+// Read implements io.Reader.
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.done == nil {
+		return n, err
+	}
+	b.h.Write(p[:n])
+	if err == io.EOF {
+		b.done(hex.EncodeToString(b.h.Sum(nil)))
+		b.done = nil
+	}
+	return n, err
+}
+
+// RecordingClient returns a copy of the client that records requests for
+// provenance.
+func recordingClient(c *http.Client) *http.Client {
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	out := *c
+	out.Transport = &provenanceTransport{next: next}
+	return &out
+}
+
+// UpdaterVersion reports the code version for the updater.
+func updaterVersion(u driver.Updater) string {
+	if v, ok := u.(driver.Versioned); ok {
+		return v.Version()
+	}
+	return moduleVersion
+}
+
+// ModuleVersion is the version of claircore in the running binary, if it can
+// be determined.
+var moduleVersion = func() string {
+	const path = `github.com/quay/claircore`
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if bi.Main.Path == path && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+	for _, m := range bi.Deps {
+		if m.Path != path {
+			continue
+		}
+		if m.Replace != nil {
+			return m.Replace.Version
+		}
+		return m.Version
+	}
+	return ""
+}()
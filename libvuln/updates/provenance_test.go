@@ -0,0 +1,128 @@
+package updates
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/libvuln/jsonblob"
+)
+
+// ProvUpdater fetches every URL it's given, concatenating the bodies.
+type provUpdater struct {
+	client *http.Client
+	urls   []string
+}
+
+func (*provUpdater) Name() string    { return "provenance" }
+func (*provUpdater) Version() string { return "v1.2.3" }
+
+func (u *provUpdater) Configure(_ context.Context, _ driver.ConfigUnmarshaler, c *http.Client) error {
+	u.client = c
+	return nil
+}
+
+func (u *provUpdater) Fetch(ctx context.Context, _ driver.Fingerprint) (io.ReadCloser, driver.Fingerprint, error) {
+	var buf bytes.Buffer
+	for _, s := range u.urls {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		res, err := u.client.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		_, err = io.Copy(&buf, res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return io.NopCloser(&buf), "", nil
+}
+
+func (*provUpdater) Parse(context.Context, io.ReadCloser) ([]*claircore.Vulnerability, error) {
+	return []*claircore.Vulnerability{{Name: "test", Package: &claircore.Package{Name: "test"}}}, nil
+}
+
+func TestProvenance(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	docs := map[string]string{
+		"/a": "first document",
+		"/b": "second document",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := docs[r.URL.Path]
+		if !ok {
+			// Not recorded, as it's not a source document.
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, d)
+	}))
+	t.Cleanup(srv.Close)
+	withAuth, err := url.Parse(srv.URL + "/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withAuth.User = url.UserPassword("user", "secret")
+
+	store, err := jsonblob.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &provUpdater{urls: []string{srv.URL + "/a", srv.URL + "/missing", withAuth.String()}}
+	m, err := NewManager(ctx, store, NewLocalLockSource(), &http.Client{},
+		WithFactories(map[string]driver.UpdaterSetFactory{}),
+		WithOutOfTree([]driver.Updater{u}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := store.GetUpdateOperations(ctx, driver.VulnerabilityKind, u.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(ops[u.Name()]), 1; got != want {
+		t.Fatalf("got: %d update operations, want: %d", got, want)
+	}
+	p := ops[u.Name()][0].Provenance
+	if p == nil {
+		t.Fatal("no provenance recorded")
+	}
+	t.Logf("%+v", p)
+	if got, want := p.UpdaterVersion, "v1.2.3"; got != want {
+		t.Errorf("version: got: %q, want: %q", got, want)
+	}
+	want := []string{srv.URL + "/a", srv.URL + "/b"}
+	if got := len(p.Sources); got != len(want) {
+		t.Fatalf("got: %d sources, want: %d", got, len(want))
+	}
+	for i, s := range p.Sources {
+		if got, want := s.URL, want[i]; got != want {
+			t.Errorf("source %d: url: got: %q, want: %q", i, got, want)
+		}
+		sum := sha256.Sum256([]byte(docs[fmt.Sprintf("/%c", 'a'+i)]))
+		if got, want := s.SHA256, hex.EncodeToString(sum[:]); got != want {
+			t.Errorf("source %d: sha256: got: %q, want: %q", i, got, want)
+		}
+		if s.Fetched.IsZero() {
+			t.Errorf("source %d: missing fetch time", i)
+		}
+	}
+}
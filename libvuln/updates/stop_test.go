@@ -0,0 +1,52 @@
+package updates
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/libvuln/jsonblob"
+)
+
+func TestStop(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	store, err := jsonblob.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(ctx, store, NewLocalLockSource(), &http.Client{},
+		WithFactories(map[string]driver.UpdaterSetFactory{}),
+		WithInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stop before Start is a no-op.
+	if err := m.Stop(ctx); err != nil {
+		t.Error(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- m.Start(ctx) }()
+	// Wait for Start to get going.
+	for {
+		m.mu.Lock()
+		started := m.done != nil
+		m.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := m.Stop(sctx); err != nil {
+		t.Error(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("unexpected error from Start: %v", err)
+	}
+}
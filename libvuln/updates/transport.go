@@ -0,0 +1,121 @@
+package updates
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// TransportConfig describes a dedicated HTTP transport for an updater or
+// updater set factory, for feeds that need to go through a proxy, trust a
+// private CA, present a client certificate, or send extra headers.
+//
+// Only updaters and factories implementing driver.Configurable are handed
+// the resulting client.
+type TransportConfig struct {
+	// Proxy, if set, is the URL of the proxy to use for all requests. Any
+	// credentials should be included in the URL's userinfo.
+	Proxy string
+	// RootCAs, if set, is a PEM bundle of certificates to trust in addition
+	// to the system pool.
+	RootCAs []byte
+	// Certificate and Key, if set, are a PEM encoded client certificate and
+	// private key to present to servers.
+	Certificate []byte
+	Key         []byte
+	// Header is added to every outgoing request. Values already present on
+	// a request are not overwritten.
+	Header http.Header
+}
+
+// Client constructs an *http.Client using the configuration, based on the
+// provided client. The provided client is not modified.
+func (tc *TransportConfig) client(base *http.Client) (*http.Client, error) {
+	var t *http.Transport
+	switch bt := base.Transport.(type) {
+	case nil:
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		t = bt.Clone()
+	default:
+		return nil, fmt.Errorf("unable to derive transport from %T", base.Transport)
+	}
+	if tc.Proxy != "" {
+		u, err := url.Parse(tc.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if tc.RootCAs != nil || tc.Certificate != nil || tc.Key != nil {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+	}
+	if tc.RootCAs != nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(tc.RootCAs) {
+			return nil, errors.New("no certificates found in CA bundle")
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	switch {
+	case tc.Certificate == nil && tc.Key == nil:
+	case tc.Certificate == nil || tc.Key == nil:
+		return nil, errors.New("client certificate and key must be provided together")
+	default:
+		cert, err := tls.X509KeyPair(tc.Certificate, tc.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	c := *base
+	c.Transport = t
+	if len(tc.Header) != 0 {
+		h := make(http.Header, len(tc.Header))
+		for k, vs := range tc.Header {
+			for _, v := range vs {
+				h.Add(k, v)
+			}
+		}
+		c.Transport = &headerTransport{next: t, header: h}
+	}
+	return &c, nil
+}
+
+// HeaderTransport adds headers to requests before handing them off.
+type headerTransport struct {
+	next   http.RoundTripper
+	header http.Header
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *headerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	for k, vs := range t.header {
+		if _, ok := r.Header[k]; ok {
+			continue
+		}
+		r.Header[k] = append([]string(nil), vs...)
+	}
+	return t.next.RoundTrip(r)
+}
+
+// ClientFor returns the client to hand to the named updater or factory.
+func (m *Manager) clientFor(name string) *http.Client {
+	if c, ok := m.clients[name]; ok {
+		return c
+	}
+	return m.client
+}
@@ -0,0 +1,180 @@
+package updates
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/libvuln/driver"
+)
+
+// NewCAServer starts a TLS server with a certificate issued by a freshly
+// minted CA, and returns the CA certificate in PEM form.
+func newCAServer(t *testing.T, h http.Handler) (*httptest.Server, []byte) {
+	t.Helper()
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: t.Name() + " CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(h)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+}
+
+// ClientUpdater records the client it's configured with.
+type clientUpdater struct {
+	name   string
+	client *http.Client
+}
+
+func (u *clientUpdater) Name() string { return u.name }
+
+func (u *clientUpdater) Configure(_ context.Context, _ driver.ConfigUnmarshaler, c *http.Client) error {
+	u.client = c
+	return nil
+}
+
+func (*clientUpdater) Fetch(context.Context, driver.Fingerprint) (io.ReadCloser, driver.Fingerprint, error) {
+	return io.NopCloser(strings.NewReader("")), "", nil
+}
+
+func (*clientUpdater) Parse(context.Context, io.ReadCloser) ([]*claircore.Vulnerability, error) {
+	return nil, nil
+}
+
+func TestTransports(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	feed := make(chan string, 1)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case feed <- r.Header.Get("x-feed"):
+		default:
+		}
+	})
+	srvA, caA := newCAServer(t, h)
+	srvB, caB := newCAServer(t, h)
+
+	t.Run("Dedicated", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		m, err := NewManager(ctx, nil, nil, &http.Client{},
+			WithFactories(map[string]driver.UpdaterSetFactory{}),
+			WithTransports(map[string]TransportConfig{
+				"a": {RootCAs: caA, Header: http.Header{"x-feed": {"a"}}},
+				"b": {RootCAs: caB},
+			}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, b, c := &clientUpdater{name: "a"}, &clientUpdater{name: "b"}, &clientUpdater{name: "c"}
+		if got, want := len(m.configure(ctx, []driver.Updater{a, b, c})), 3; got != want {
+			t.Fatalf("configured: got: %d, want: %d", got, want)
+		}
+
+		tt := []struct {
+			u    *clientUpdater
+			srv  *httptest.Server
+			ok   bool
+			feed string
+		}{
+			{u: a, srv: srvA, ok: true, feed: "a"},
+			{u: a, srv: srvB},
+			{u: b, srv: srvA},
+			{u: b, srv: srvB, ok: true},
+			{u: c, srv: srvA},
+			{u: c, srv: srvB},
+		}
+		for _, tc := range tt {
+			res, err := tc.u.client.Get(tc.srv.URL)
+			t.Logf("%s → %s: %v", tc.u.name, tc.srv.URL, err)
+			if got, want := err == nil, tc.ok; got != want {
+				t.Errorf("%s → %s: got success: %v, want: %v", tc.u.name, tc.srv.URL, got, want)
+			}
+			if err != nil {
+				continue
+			}
+			res.Body.Close()
+			if got, want := <-feed, tc.feed; got != want {
+				t.Errorf("%s: header: got: %q, want: %q", tc.u.name, got, want)
+			}
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		tt := map[string]TransportConfig{
+			"BadBundle":   {RootCAs: []byte("not a certificate")},
+			"MissingKey":  {Certificate: caA},
+			"BadKeyPair":  {Certificate: caA, Key: caB},
+			"BadProxyURL": {Proxy: "http://[::1"},
+		}
+		for name, cfg := range tt {
+			_, err := NewManager(ctx, nil, nil, &http.Client{},
+				WithFactories(map[string]driver.UpdaterSetFactory{}),
+				WithTransports(map[string]TransportConfig{name: cfg}))
+			t.Logf("%s: %v", name, err)
+			if err == nil {
+				t.Errorf("%s: expected error", name)
+				continue
+			}
+			if !strings.Contains(err.Error(), `"`+name+`"`) {
+				t.Errorf("%s: error doesn't name the updater: %v", name, err)
+			}
+		}
+	})
+}
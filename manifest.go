@@ -8,4 +8,14 @@ type Manifest struct {
 	Hash Digest `json:"hash"`
 	// an array of filesystem layers indexed in the same order as the cooresponding image
 	Layers []*Layer `json:"layers"`
+	// OS, if set, is the operating system the image is for, using the values
+	// of the "os" field of an OCI image configuration. It's used for any
+	// Layer without an OS of its own.
+	OS string `json:"os,omitempty"`
+	// Config, if set, is the image's parsed configuration. Details from it
+	// are reported in the IndexReport's Image field.
+	Config *ImageConfig `json:"config,omitempty"`
+	// ConfigRef, if set, is where to fetch the image's configuration from.
+	// It's only consulted if Config is unset.
+	ConfigRef *ConfigRef `json:"config_ref,omitempty"`
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/quay/claircore/debian"
 	"github.com/quay/claircore/libvuln/driver"
 	"github.com/quay/claircore/matchers/registry"
+	"github.com/quay/claircore/nvd"
 	"github.com/quay/claircore/oracle"
 	"github.com/quay/claircore/photon"
 	"github.com/quay/claircore/python"
@@ -56,6 +57,7 @@ var defaultMatchers = []driver.Matcher{
 
 func inner(ctx context.Context) error {
 	registry.Register("crda", &crda.Factory{})
+	registry.Register("nvd", &nvd.Factory{})
 
 	for _, m := range defaultMatchers {
 		mf := driver.MatcherStatic(m)
@@ -0,0 +1,172 @@
+// Package nvd provides a matcher that applies the configurations from NVD CVE
+// feeds to the CPEs recorded in an IndexReport.
+package nvd
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/quay/claircore/pkg/cpe"
+)
+
+// Configurations is the "configurations" object of a CVE item in an NVD JSON
+// 1.1 feed. It describes the software, and the platforms it must be running
+// on, that are affected by the CVE.
+type Configurations struct {
+	DataVersion string `json:"CVE_data_version"`
+	// Nodes are OR'd together.
+	Nodes []Node `json:"nodes"`
+}
+
+// Node is a node in the tree of a configuration.
+type Node struct {
+	// Operator is "AND" or "OR", and applies to the Children and CPEMatch
+	// entries together.
+	Operator string     `json:"operator"`
+	Negate   bool       `json:"negate,omitempty"`
+	Children []Node     `json:"children,omitempty"`
+	CPEMatch []CPEMatch `json:"cpe_match,omitempty"`
+}
+
+// CPEMatch is a CPE match expression, optionally with a range of versions.
+type CPEMatch struct {
+	// Vulnerable reports whether the matched software is the vulnerable
+	// software, as opposed to a platform it runs on.
+	Vulnerable bool   `json:"vulnerable"`
+	CPE23URI   string `json:"cpe23Uri"`
+	// The version range fields apply to the version attribute of the
+	// matched CPE. Any that are set must all hold.
+	VersionStartIncluding string `json:"versionStartIncluding,omitempty"`
+	VersionStartExcluding string `json:"versionStartExcluding,omitempty"`
+	VersionEndIncluding   string `json:"versionEndIncluding,omitempty"`
+	VersionEndExcluding   string `json:"versionEndExcluding,omitempty"`
+
+	// Name is the unbound CPE23URI, and ok reports whether it's valid.
+	name cpe.WFN
+	ok   bool
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+//
+// The CPE is unbound once, when the CPEMatch is decoded. An invalid CPE
+// doesn't cause an error, but never matches.
+func (m *CPEMatch) UnmarshalJSON(b []byte) error {
+	type plain CPEMatch
+	if err := json.Unmarshal(b, (*plain)(m)); err != nil {
+		return err
+	}
+	m.name, m.ok = unbind(m.CPE23URI)
+	return nil
+}
+
+func unbind(s string) (cpe.WFN, bool) {
+	w, err := cpe.Unbind(s)
+	return w, err == nil
+}
+
+// Ranged reports whether the CPEMatch has a version range.
+func (m *CPEMatch) ranged() bool {
+	return m.VersionStartIncluding != "" || m.VersionStartExcluding != "" ||
+		m.VersionEndIncluding != "" || m.VersionEndExcluding != ""
+}
+
+// Matches reports whether the CPEMatch applies to the named software.
+//
+// If the CPEMatch has a version range, the name must have a version.
+func (m *CPEMatch) Matches(w cpe.WFN) bool {
+	n, ok := m.name, m.ok
+	if !ok && m.CPE23URI != "" {
+		// Constructed in code, rather than decoded.
+		n, ok = unbind(m.CPE23URI)
+	}
+	if !ok || !cpe.Match(n, w) {
+		return false
+	}
+	if !m.ranged() {
+		return true
+	}
+	v := w.Attr[cpe.Version]
+	if v.Kind != cpe.ValueSet {
+		return false
+	}
+	ver := unquote(v.V)
+	switch {
+	case m.VersionStartIncluding != "" && compareVersion(ver, m.VersionStartIncluding) < 0:
+		return false
+	case m.VersionStartExcluding != "" && compareVersion(ver, m.VersionStartExcluding) <= 0:
+		return false
+	case m.VersionEndIncluding != "" && compareVersion(ver, m.VersionEndIncluding) > 0:
+		return false
+	case m.VersionEndExcluding != "" && compareVersion(ver, m.VersionEndExcluding) >= 0:
+		return false
+	}
+	return true
+}
+
+// Affects reports whether the configurations apply to the software named by
+// "pkg", running on the platforms named by "env".
+//
+// The configurations only apply if a CPEMatch marked as vulnerable matches
+// "pkg": the names in "env" can only satisfy platform conditions. If the
+// matching CPEMatch has an exclusive upper bound, it's reported as the fixed
+// version.
+func (c *Configurations) Affects(pkg cpe.WFN, env ...cpe.WFN) (fixed string, ok bool) {
+	for i := range c.Nodes {
+		if r := c.Nodes[i].eval(pkg, env); r.ok && r.hit {
+			return r.fixed, true
+		}
+	}
+	return "", false
+}
+
+// Result is the result of evaluating a Node.
+type result struct {
+	// Ok reports whether the node holds.
+	ok bool
+	// Hit reports whether a vulnerable CPEMatch matched the package.
+	hit   bool
+	fixed string
+}
+
+func (n *Node) eval(pkg cpe.WFN, env []cpe.WFN) (r result) {
+	and := strings.EqualFold(n.Operator, "AND")
+	r.ok = and
+	add := func(p result) {
+		if p.ok && p.hit && !r.hit {
+			r.hit, r.fixed = true, p.fixed
+		}
+		if and {
+			r.ok = r.ok && p.ok
+		} else {
+			r.ok = r.ok || p.ok
+		}
+	}
+	for i := range n.Children {
+		add(n.Children[i].eval(pkg, env))
+	}
+	for i := range n.CPEMatch {
+		add(n.CPEMatch[i].eval(pkg, env))
+	}
+	if len(n.Children) == 0 && len(n.CPEMatch) == 0 {
+		r.ok = false
+	}
+	if n.Negate {
+		return result{ok: !r.ok}
+	}
+	if !r.ok {
+		r.hit, r.fixed = false, ""
+	}
+	return r
+}
+
+func (m *CPEMatch) eval(pkg cpe.WFN, env []cpe.WFN) result {
+	if m.Matches(pkg) {
+		return result{ok: true, hit: m.Vulnerable, fixed: m.VersionEndExcluding}
+	}
+	for _, w := range env {
+		if m.Matches(w) {
+			return result{ok: true}
+		}
+	}
+	return result{}
+}
@@ -0,0 +1,138 @@
+package nvd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/quay/claircore/pkg/cpe"
+)
+
+func TestCPEMatch(t *testing.T) {
+	// These are cpe_match entries as they appear in the feeds, with the
+	// different combinations of range bounds.
+	const (
+		startIncEndExc = `{"vulnerable":true,"cpe23Uri":"cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*","versionStartIncluding":"2.13.0","versionEndExcluding":"2.15.0","cpe_name":[]}`
+		startIncEndInc = `{"vulnerable":true,"cpe23Uri":"cpe:2.3:a:openssl:openssl:*:*:*:*:*:*:*:*","versionStartIncluding":"1.1.1","versionEndIncluding":"1.1.1k","cpe_name":[]}`
+		startExcEndExc = `{"vulnerable":true,"cpe23Uri":"cpe:2.3:a:apache:tomcat:*:*:*:*:*:*:*:*","versionStartExcluding":"9.0.0","versionEndExcluding":"9.0.31","cpe_name":[]}`
+		startExcEndInc = `{"vulnerable":true,"cpe23Uri":"cpe:2.3:a:apache:tomcat:*:*:*:*:*:*:*:*","versionStartExcluding":"8.5.0","versionEndIncluding":"8.5.50","cpe_name":[]}`
+		endExc         = `{"vulnerable":true,"cpe23Uri":"cpe:2.3:a:lodash:lodash:*:*:*:*:*:node.js:*:*","versionEndExcluding":"4.17.21","cpe_name":[]}`
+		exact          = `{"vulnerable":true,"cpe23Uri":"cpe:2.3:a:apache:log4j:2.0:beta9:*:*:*:*:*:*","cpe_name":[]}`
+	)
+	tt := []struct {
+		Match string
+		Name  string
+		Want  bool
+	}{
+		{startIncEndExc, `cpe:2.3:a:apache:log4j:2.13.0:*:*:*:*:*:*:*`, true},
+		{startIncEndExc, `cpe:2.3:a:apache:log4j:2.14.1:*:*:*:*:*:*:*`, true},
+		{startIncEndExc, `cpe:2.3:a:apache:log4j:2.15.0:*:*:*:*:*:*:*`, false},
+		{startIncEndExc, `cpe:2.3:a:apache:log4j:2.12.9:*:*:*:*:*:*:*`, false},
+		{startIncEndExc, `cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*`, false},
+
+		{startIncEndInc, `cpe:2.3:a:openssl:openssl:1.1.1:*:*:*:*:*:*:*`, true},
+		{startIncEndInc, `cpe:2.3:a:openssl:openssl:1.1.1k:*:*:*:*:*:*:*`, true},
+		{startIncEndInc, `cpe:2.3:a:openssl:openssl:1.1.1l:*:*:*:*:*:*:*`, false},
+		{startIncEndInc, `cpe:2.3:a:openssl:openssl:1.1.0l:*:*:*:*:*:*:*`, false},
+
+		{startExcEndExc, `cpe:2.3:a:apache:tomcat:9.0.0:*:*:*:*:*:*:*`, false},
+		{startExcEndExc, `cpe:2.3:a:apache:tomcat:9.0.1:*:*:*:*:*:*:*`, true},
+		{startExcEndExc, `cpe:2.3:a:apache:tomcat:9.0.30:*:*:*:*:*:*:*`, true},
+		{startExcEndExc, `cpe:2.3:a:apache:tomcat:9.0.31:*:*:*:*:*:*:*`, false},
+
+		{startExcEndInc, `cpe:2.3:a:apache:tomcat:8.5.0:*:*:*:*:*:*:*`, false},
+		{startExcEndInc, `cpe:2.3:a:apache:tomcat:8.5.50:*:*:*:*:*:*:*`, true},
+		{startExcEndInc, `cpe:2.3:a:apache:tomcat:8.5.51:*:*:*:*:*:*:*`, false},
+
+		{endExc, `cpe:2.3:a:lodash:lodash:4.17.20:*:*:*:*:node.js:*:*`, true},
+		{endExc, `cpe:2.3:a:lodash:lodash:0.1:*:*:*:*:node.js:*:*`, true},
+		{endExc, `cpe:2.3:a:lodash:lodash:4.17.21:*:*:*:*:node.js:*:*`, false},
+		{endExc, `cpe:2.3:a:lodash:lodash:4.17.20:*:*:*:*:*:*:*`, false},
+
+		{exact, `cpe:2.3:a:apache:log4j:2.0:beta9:*:*:*:*:*:*`, true},
+		{exact, `cpe:2.3:a:apache:log4j:2.0:rc1:*:*:*:*:*:*`, false},
+		{exact, `cpe:2.3:a:apache:log4j:2.0:*:*:*:*:*:*:*`, false},
+	}
+	for _, tc := range tt {
+		var m CPEMatch
+		if err := json.Unmarshal([]byte(tc.Match), &m); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := m.Matches(cpe.MustUnbind(tc.Name)), tc.Want; got != want {
+			t.Errorf("%s\n\t%s: got: %v, want: %v", tc.Match, tc.Name, got, want)
+		}
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		var m CPEMatch
+		if err := json.Unmarshal([]byte(`{"vulnerable":true,"cpe23Uri":"cpe:2.3:a:bad vendor:x:*:*:*:*:*:*:*:*"}`), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Matches(cpe.MustUnbind(`cpe:2.3:a:x:x:1:*:*:*:*:*:*:*`)) {
+			t.Error("invalid CPE matched")
+		}
+	})
+}
+
+func TestAffects(t *testing.T) {
+	// This is shaped like the feed entries for software that's only affected
+	// on certain platforms.
+	const cfg = `{"CVE_data_version":"4.0","nodes":[{"operator":"AND","children":[
+{"operator":"OR","children":[],"cpe_match":[
+  {"vulnerable":true,"cpe23Uri":"cpe:2.3:a:samba:samba:*:*:*:*:*:*:*:*","versionStartIncluding":"4.0.0","versionEndExcluding":"4.10.18","cpe_name":[]}]},
+{"operator":"OR","children":[],"cpe_match":[
+  {"vulnerable":false,"cpe23Uri":"cpe:2.3:o:redhat:enterprise_linux:7.0:*:*:*:*:*:*:*","cpe_name":[]},
+  {"vulnerable":false,"cpe23Uri":"cpe:2.3:o:redhat:enterprise_linux:8.0:*:*:*:*:*:*:*","cpe_name":[]}]}
+],"cpe_match":[]}]}`
+	var c Configurations
+	if err := json.Unmarshal([]byte(cfg), &c); err != nil {
+		t.Fatal(err)
+	}
+	samba := cpe.MustUnbind(`cpe:2.3:a:samba:samba:4.10.4:*:*:*:*:*:*:*`)
+	fixed := cpe.MustUnbind(`cpe:2.3:a:samba:samba:4.10.18:*:*:*:*:*:*:*`)
+	rhel8 := cpe.MustUnbind(`cpe:2.3:o:redhat:enterprise_linux:8.0:*:*:*:*:*:*:*`)
+	deb := cpe.MustUnbind(`cpe:2.3:o:debian:debian_linux:11.0:*:*:*:*:*:*:*`)
+	tt := []struct {
+		Name string
+		Pkg  cpe.WFN
+		Env  []cpe.WFN
+		Want bool
+	}{
+		{"Platform", samba, []cpe.WFN{rhel8}, true},
+		{"OtherPlatform", samba, []cpe.WFN{deb}, false},
+		{"NoPlatform", samba, nil, false},
+		{"Fixed", fixed, []cpe.WFN{rhel8}, false},
+		// The platform alone never matches.
+		{"OnlyPlatform", rhel8, []cpe.WFN{rhel8}, false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.Name, func(t *testing.T) {
+			v, ok := c.Affects(tc.Pkg, tc.Env...)
+			if ok != tc.Want {
+				t.Errorf("got: %v, want: %v", ok, tc.Want)
+			}
+			if ok && v != "4.10.18" {
+				t.Errorf("fixed: got: %q, want: %q", v, "4.10.18")
+			}
+		})
+	}
+
+	t.Run("Negate", func(t *testing.T) {
+		c := Configurations{Nodes: []Node{{
+			Operator: "AND",
+			Children: []Node{
+				{Operator: "OR", CPEMatch: []CPEMatch{
+					{Vulnerable: true, CPE23URI: `cpe:2.3:a:samba:samba:*:*:*:*:*:*:*:*`},
+				}},
+				{Operator: "OR", Negate: true, CPEMatch: []CPEMatch{
+					{CPE23URI: `cpe:2.3:o:redhat:enterprise_linux:*:*:*:*:*:*:*:*`},
+				}},
+			},
+		}}}
+		if _, ok := c.Affects(samba, rhel8); ok {
+			t.Error("negated platform matched")
+		}
+		if _, ok := c.Affects(samba, deb); !ok {
+			t.Error("other platform didn't match")
+		}
+	})
+}
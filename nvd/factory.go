@@ -0,0 +1,99 @@
+package nvd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore/libvuln/driver"
+)
+
+var (
+	_ driver.MatcherFactory      = (*Factory)(nil)
+	_ driver.MatcherConfigurable = (*Factory)(nil)
+)
+
+// Factory creates a Matcher from the configured feeds.
+//
+// The Matcher is opt-in: without any feeds configured, no Matcher is
+// created.
+type Factory struct {
+	client *http.Client
+	feeds  []string
+}
+
+// Config is the configuration accepted by the Factory.
+type Config struct {
+	// Feeds is a list of NVD JSON 1.1 feeds, such as
+	// "https://nvd.nist.gov/feeds/json/cve/1.1/nvdcve-1.1-2021.json.gz".
+	// Entries that aren't http or https URLs are treated as file paths.
+	Feeds []string `json:"feeds" yaml:"feeds"`
+}
+
+// Configure implements driver.MatcherConfigurable.
+func (f *Factory) Configure(ctx context.Context, cfg driver.MatcherConfigUnmarshaler, c *http.Client) error {
+	ctx = zlog.ContextWithValues(ctx, "component", "nvd/Factory.Configure")
+	var fc Config
+	if err := cfg(&fc); err != nil {
+		return err
+	}
+	f.client = c
+	f.feeds = fc.Feeds
+	zlog.Info(ctx).
+		Strs("feeds", f.feeds).
+		Msg("configured feeds")
+	return nil
+}
+
+// Matcher implements driver.MatcherFactory.
+func (f *Factory) Matcher(ctx context.Context) ([]driver.Matcher, error) {
+	ctx = zlog.ContextWithValues(ctx, "component", "nvd/Factory.Matcher")
+	if len(f.feeds) == 0 {
+		zlog.Info(ctx).
+			Msg("no feeds configured, skipping")
+		return nil, nil
+	}
+	rs := make([]io.Reader, 0, len(f.feeds))
+	for _, feed := range f.feeds {
+		rc, err := f.open(ctx, feed)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		rs = append(rs, rc)
+	}
+	m, err := NewMatcher(ctx, rs...)
+	if err != nil {
+		return nil, err
+	}
+	return []driver.Matcher{m}, nil
+}
+
+// Open returns the contents of a configured feed.
+func (f *Factory) open(ctx context.Context, feed string) (io.ReadCloser, error) {
+	u, err := url.Parse(feed)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return os.Open(feed)
+	}
+	if f.client == nil {
+		return nil, fmt.Errorf("nvd: no http client for feed %q", feed)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("nvd: unexpected response for feed %q: %s", feed, res.Status)
+	}
+	return res.Body, nil
+}
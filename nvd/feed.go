@@ -0,0 +1,200 @@
+package nvd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/pkg/cpe"
+)
+
+// Feed is the envelope of an NVD JSON 1.1 feed.
+type feed struct {
+	Items []item `json:"CVE_Items"`
+}
+
+// Item is a CVE item in an NVD JSON 1.1 feed, with only the parts the
+// matcher needs.
+type item struct {
+	CVE struct {
+		Meta struct {
+			ID string `json:"ID"`
+		} `json:"CVE_data_meta"`
+		Description struct {
+			Data []struct {
+				Lang  string `json:"lang"`
+				Value string `json:"value"`
+			} `json:"description_data"`
+		} `json:"description"`
+	} `json:"cve"`
+	Configurations Configurations `json:"configurations"`
+	Impact         struct {
+		V3 struct {
+			CVSS struct {
+				BaseSeverity string `json:"baseSeverity"`
+			} `json:"cvssV3"`
+		} `json:"baseMetricV3"`
+		V2 struct {
+			Severity string `json:"severity"`
+		} `json:"baseMetricV2"`
+	} `json:"impact"`
+	Published string `json:"publishedDate"`
+}
+
+// PublishedFormat is the layout of the "publishedDate" member.
+const publishedFormat = `2006-01-02T15:04Z`
+
+// Vulnerability returns the Vulnerability described by the item, with the
+// details that don't depend on the affected package filled in.
+func (i *item) vulnerability() *claircore.Vulnerability {
+	id := i.CVE.Meta.ID
+	v := &claircore.Vulnerability{
+		ID:      id,
+		Updater: name,
+		Name:    id,
+		Links:   "https://nvd.nist.gov/vuln/detail/" + id,
+	}
+	for _, d := range i.CVE.Description.Data {
+		if d.Lang == "en" {
+			v.Description = d.Value
+			break
+		}
+	}
+	if t, err := time.Parse(publishedFormat, i.Published); err == nil {
+		v.Issued = t
+	}
+	v.Severity = i.Impact.V3.CVSS.BaseSeverity
+	if v.Severity == "" {
+		v.Severity = i.Impact.V2.Severity
+	}
+	v.NormalizedSeverity = normalizeSeverity(v.Severity)
+	return v
+}
+
+func normalizeSeverity(s string) claircore.Severity {
+	switch strings.ToUpper(s) {
+	case "NONE":
+		return claircore.Negligible
+	case "LOW":
+		return claircore.Low
+	case "MEDIUM":
+		return claircore.Medium
+	case "HIGH":
+		return claircore.High
+	case "CRITICAL":
+		return claircore.Critical
+	}
+	return claircore.Unknown
+}
+
+// Index holds CVE items, indexed by the vendor and product of their
+// vulnerable CPEs.
+type index struct {
+	items []*item
+	// ByProduct is a map of "vendor:product" to items with a vulnerable
+	// CPEMatch for that product.
+	byProduct map[string][]*item
+	// Wild is the items with a vulnerable CPEMatch with a wildcard or ANY
+	// vendor or product. These have to be checked for every package.
+	wild []*item
+}
+
+func newIndex() *index {
+	return &index{byProduct: make(map[string][]*item)}
+}
+
+// Add decodes a feed, which may be gzip compressed, and adds its items.
+func (x *index) add(r io.Reader) error {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(2); err == nil && bytes.Equal(b, []byte{0x1f, 0x8b}) {
+		z, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("nvd: unable to read feed: %w", err)
+		}
+		defer z.Close()
+		r = z
+	} else {
+		r = br
+	}
+	var f feed
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return fmt.Errorf("nvd: unable to decode feed: %w", err)
+	}
+	for i := range f.Items {
+		it := &f.Items[i]
+		keys, wild := products(&it.Configurations)
+		if len(keys) == 0 && !wild {
+			continue
+		}
+		x.items = append(x.items, it)
+		if wild {
+			x.wild = append(x.wild, it)
+			continue
+		}
+		for k := range keys {
+			x.byProduct[k] = append(x.byProduct[k], it)
+		}
+	}
+	return nil
+}
+
+// Candidates returns the items that may apply to the package named by "w".
+func (x *index) candidates(w cpe.WFN) []*item {
+	k, ok := productKey(w)
+	if !ok {
+		return x.wild
+	}
+	c := x.byProduct[k]
+	if len(x.wild) == 0 {
+		return c
+	}
+	out := make([]*item, 0, len(c)+len(x.wild))
+	out = append(out, c...)
+	return append(out, x.wild...)
+}
+
+// Products reports the keys of the vulnerable CPEMatches in the
+// configurations, and whether any can't be keyed.
+func products(c *Configurations) (map[string]struct{}, bool) {
+	keys := make(map[string]struct{})
+	wild := false
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for i := range n.Children {
+			walk(&n.Children[i])
+		}
+		for i := range n.CPEMatch {
+			m := &n.CPEMatch[i]
+			if !m.Vulnerable || !m.ok {
+				continue
+			}
+			k, ok := productKey(m.name)
+			if !ok {
+				wild = true
+				continue
+			}
+			keys[k] = struct{}{}
+		}
+	}
+	for i := range c.Nodes {
+		walk(&c.Nodes[i])
+	}
+	return keys, wild
+}
+
+// ProductKey returns the index key for a CPE, if it has a vendor and product
+// without wildcards.
+func productKey(w cpe.WFN) (string, bool) {
+	v, p := w.Attr[cpe.Vendor], w.Attr[cpe.Product]
+	if v.Kind != cpe.ValueSet || p.Kind != cpe.ValueSet ||
+		strings.ContainsAny(v.V+p.V, "*?") {
+		return "", false
+	}
+	return strings.ToLower(v.V + ":" + p.V), true
+}
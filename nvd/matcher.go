@@ -0,0 +1,134 @@
+package nvd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/pkg/cpe"
+)
+
+var (
+	_ driver.Matcher       = (*Matcher)(nil)
+	_ driver.RemoteMatcher = (*Matcher)(nil)
+)
+
+// Name is the name of the matcher, and what's reported as the updater of the
+// vulnerabilities it returns.
+const name = "nvd"
+
+// Matcher applies the configurations of CVEs in NVD JSON 1.1 feeds to the
+// CPEs recorded in IndexRecords.
+//
+// Only records whose Package has a CPE are considered. The Distribution and
+// Repository CPEs are used to satisfy the platform half of configurations
+// such as "application X running on operating system Y", but never cause a
+// match on their own.
+//
+// Matching by CPE is prone to false positives, as CPEs are coarse and
+// assigned inconsistently, so this matcher isn't used unless configured.
+// The feeds are held in memory and don't go through the vulnerability store.
+type Matcher struct {
+	idx *index
+}
+
+// NewMatcher returns a Matcher using the CVEs in the provided feeds, which may
+// be gzip compressed.
+func NewMatcher(ctx context.Context, feeds ...io.Reader) (*Matcher, error) {
+	ctx = zlog.ContextWithValues(ctx, "component", "nvd/NewMatcher")
+	m := &Matcher{idx: newIndex()}
+	for i, f := range feeds {
+		if err := m.idx.add(f); err != nil {
+			return nil, fmt.Errorf("feed %d: %w", i, err)
+		}
+	}
+	zlog.Debug(ctx).
+		Int("items", len(m.idx.items)).
+		Msg("loaded feeds")
+	return m, nil
+}
+
+// Name implements driver.Matcher.
+func (*Matcher) Name() string { return name }
+
+// Filter implements driver.Matcher.
+func (*Matcher) Filter(record *claircore.IndexRecord) bool {
+	return record.Package != nil && record.Package.CPE.Valid() == nil
+}
+
+// Query implements driver.Matcher.
+//
+// The store isn't queried, so there are no constraints.
+func (*Matcher) Query() []driver.MatchConstraint {
+	return []driver.MatchConstraint{}
+}
+
+// Vulnerable implements driver.Matcher.
+//
+// The vulnerability must be one returned by this Matcher.
+func (m *Matcher) Vulnerable(ctx context.Context, record *claircore.IndexRecord, vuln *claircore.Vulnerability) (bool, error) {
+	if vuln.Updater != name {
+		return false, nil
+	}
+	pkg, env := names(record)
+	for _, it := range m.idx.candidates(pkg) {
+		if it.CVE.Meta.ID != vuln.Name {
+			continue
+		}
+		_, ok := it.Configurations.Affects(pkg, env...)
+		return ok, nil
+	}
+	return false, nil
+}
+
+// QueryRemoteMatcher implements driver.RemoteMatcher.
+func (m *Matcher) QueryRemoteMatcher(ctx context.Context, records []*claircore.IndexRecord) (map[string][]*claircore.Vulnerability, error) {
+	ctx = zlog.ContextWithValues(ctx, "component", "nvd/Matcher.QueryRemoteMatcher")
+	out := make(map[string][]*claircore.Vulnerability)
+	for _, r := range records {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !m.Filter(r) {
+			continue
+		}
+		pkg, env := names(r)
+		for _, it := range m.idx.candidates(pkg) {
+			fixed, ok := it.Configurations.Affects(pkg, env...)
+			if !ok {
+				continue
+			}
+			v := it.vulnerability()
+			v.Package = r.Package
+			v.Dist = r.Distribution
+			v.Repo = r.Repository
+			v.FixedInVersion = fixed
+			out[r.Package.ID] = append(out[r.Package.ID], v)
+		}
+	}
+	zlog.Debug(ctx).
+		Int("packages", len(out)).
+		Msg("matched")
+	return out, nil
+}
+
+// Names returns the CPEs to evaluate configurations against for a record.
+//
+// If the Package's CPE has no version, the Package's version is used.
+func names(r *claircore.IndexRecord) (pkg cpe.WFN, env []cpe.WFN) {
+	pkg = r.Package.CPE
+	if v := &pkg.Attr[cpe.Version]; (v.Kind == cpe.ValueUnset || v.Kind == cpe.ValueAny) && r.Package.Version != "" {
+		v.Kind, v.V = cpe.ValueSet, quote(r.Package.Version)
+	}
+	if r.Distribution != nil && r.Distribution.CPE.Valid() == nil {
+		env = append(env, r.Distribution.CPE)
+	}
+	if r.Repository != nil && r.Repository.CPE.Valid() == nil {
+		env = append(env, r.Repository.CPE)
+	}
+	return pkg, env
+}
@@ -0,0 +1,192 @@
+package nvd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/pkg/cpe"
+)
+
+func TestMatcher(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	b, err := os.ReadFile(filepath.Join("testdata", "feed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Load the same feed twice, once compressed, to check both are read.
+	m, err := NewMatcher(ctx, bytes.NewReader(b), &gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rhel8 := &claircore.Repository{
+		ID:   "1",
+		Name: "rhel-8-for-x86_64-baseos-rpms",
+		CPE:  cpe.MustUnbind(`cpe:/o:redhat:enterprise_linux:8.0::baseos`),
+	}
+	fedora := &claircore.Distribution{
+		ID:  "1",
+		DID: "fedora",
+		CPE: cpe.MustUnbind(`cpe:/o:fedoraproject:fedora:34`),
+	}
+	record := func(id, name, version, c string) *claircore.IndexRecord {
+		p := &claircore.Package{ID: id, Name: name, Version: version}
+		if c != "" {
+			p.CPE = cpe.MustUnbind(c)
+		}
+		return &claircore.IndexRecord{Package: p}
+	}
+	rs := []*claircore.IndexRecord{
+		record("log4j", "log4j-core", "2.14.1", `cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*`),
+		record("log4j-beta", "log4j-core", "", `cpe:2.3:a:apache:log4j:2.0:beta9:*:*:*:*:*:*`),
+		record("log4j-fixed", "log4j-core", "2.12.2", `cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*`),
+		record("log4j-nocpe", "log4j-core", "2.14.1", ""),
+		record("httpd", "httpd", "2.4.49", `cpe:2.3:a:apache:http_server:*:*:*:*:*:*:*:*`),
+		record("httpd-fedora", "httpd", "2.4.48", `cpe:2.3:a:apache:http_server:*:*:*:*:*:*:*:*`),
+		record("openssl", "openssl", "1.1.1k", `cpe:2.3:a:openssl:openssl:*:*:*:*:*:*:*:*`),
+		record("openssl-fixed", "openssl", "1.1.1l", `cpe:2.3:a:openssl:openssl:*:*:*:*:*:*:*:*`),
+		record("samba-rhel", "samba", "4.10.4", `cpe:2.3:a:samba:samba:*:*:*:*:*:*:*:*`),
+		record("samba", "samba", "4.10.4", `cpe:2.3:a:samba:samba:*:*:*:*:*:*:*:*`),
+	}
+	rs[5].Distribution = fedora
+	rs[8].Repository = rhel8
+
+	var interested []*claircore.IndexRecord
+	for _, r := range rs {
+		if m.Filter(r) {
+			interested = append(interested, r)
+		}
+	}
+	if got, want := len(interested), len(rs)-1; got != want {
+		t.Errorf("interested: got: %d, want: %d", got, want)
+	}
+	res, err := m.QueryRemoteMatcher(ctx, interested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type found struct {
+		Name, Fixed string
+		Severity    claircore.Severity
+	}
+	got := make(map[string][]found)
+	for id, vs := range res {
+		for _, v := range vs {
+			got[id] = append(got[id], found{v.Name, v.FixedInVersion, v.NormalizedSeverity})
+			if v.Package.ID != id {
+				t.Errorf("%s: wrong package: %q", v.Name, v.Package.ID)
+			}
+			for _, r := range rs {
+				if r.Package.ID != id {
+					continue
+				}
+				ok, err := m.Vulnerable(ctx, r, v)
+				if err != nil {
+					t.Error(err)
+				}
+				if !ok {
+					t.Errorf("%s: %s: Vulnerable disagrees", id, v.Name)
+				}
+			}
+		}
+		sort.Slice(got[id], func(i, j int) bool { return got[id][i].Name < got[id][j].Name })
+	}
+	// Each match is reported twice, as the feed is loaded twice.
+	want := map[string][]found{
+		"log4j": {
+			{"CVE-2021-44228", "2.15.0", claircore.Critical},
+			{"CVE-2021-44228", "2.15.0", claircore.Critical},
+		},
+		"log4j-beta": {
+			{"CVE-2021-44228", "", claircore.Critical},
+			{"CVE-2021-44228", "", claircore.Critical},
+		},
+		"httpd": {
+			{"CVE-2021-41773", "", claircore.High},
+			{"CVE-2021-41773", "", claircore.High},
+		},
+		"openssl": {
+			{"CVE-2021-3711", "", claircore.Critical},
+			{"CVE-2021-3711", "", claircore.Critical},
+		},
+		"samba-rhel": {
+			{"CVE-2020-1472", "4.10.18", claircore.Critical},
+			{"CVE-2020-1472", "4.10.18", claircore.Critical},
+		},
+	}
+	if !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+	if v := res["log4j"][0]; v.Links != "https://nvd.nist.gov/vuln/detail/CVE-2021-44228" || v.Issued.IsZero() || v.Description == "" {
+		t.Errorf("missing details: %+v", v)
+	}
+}
+
+func TestFactory(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	t.Run("Unconfigured", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		var f Factory
+		ms, err := f.Matcher(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ms) != 0 {
+			t.Errorf("got: %v, want no matchers", ms)
+		}
+	})
+
+	t.Run("Feeds", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		var f Factory
+		cfg := func(v interface{}) error {
+			c := v.(*Config)
+			c.Feeds = []string{
+				srv.URL + "/feed.json",
+				filepath.Join("testdata", "feed.json"),
+			}
+			return nil
+		}
+		if err := f.Configure(ctx, driver.MatcherConfigUnmarshaler(cfg), srv.Client()); err != nil {
+			t.Fatal(err)
+		}
+		ms, err := f.Matcher(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ms) != 1 {
+			t.Fatalf("got: %d matchers, want: 1", len(ms))
+		}
+		if got, want := len(ms[0].(*Matcher).idx.items), 8; got != want {
+			t.Errorf("items: got: %d, want: %d", got, want)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		ctx := zlog.Test(ctx, t)
+		f := Factory{client: srv.Client(), feeds: []string{srv.URL + "/missing.json"}}
+		if _, err := f.Matcher(ctx); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package nvd
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CompareVersion compares two version strings as found in NVD data, returning
+// -1, 0, or 1.
+//
+// There's no versioning scheme that NVD data adheres to, so this does a
+// best-effort comparison: versions are split into runs of digits and runs of
+// letters, ignoring separators. Runs of digits compare numerically and runs
+// of letters compare lexically, case-insensitively. A version with a letter
+// run where the other has a digit run sorts first, so "1.0rc1" is before
+// "1.0.1". When one version is a prefix of the other, the longer one sorts
+// last unless its next run is a pre-release marker, such as "1.0-beta"
+// sorting before "1.0".
+func compareVersion(a, b string) int {
+	as, bs := segments(a), segments(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := compareSegment(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(as) > len(bs):
+		if prerelease(as[len(bs)]) {
+			return -1
+		}
+		return 1
+	case len(as) < len(bs):
+		if prerelease(bs[len(as)]) {
+			return 1
+		}
+		return -1
+	}
+	return 0
+}
+
+// Segments splits a version into runs of digits and runs of letters.
+func segments(v string) []string {
+	var out []string
+	v = strings.ToLower(v)
+	start := -1
+	for i, r := range v {
+		if start != -1 && !sameClass(rune(v[start]), r) {
+			out = append(out, v[start:i])
+			start = -1
+		}
+		if start == -1 && (unicode.IsDigit(r) || unicode.IsLetter(r)) {
+			start = i
+		}
+	}
+	if start != -1 {
+		out = append(out, v[start:])
+	}
+	return out
+}
+
+func sameClass(a, b rune) bool {
+	switch {
+	case unicode.IsDigit(a):
+		return unicode.IsDigit(b)
+	case unicode.IsLetter(a):
+		return unicode.IsLetter(b)
+	}
+	return false
+}
+
+func compareSegment(a, b string) int {
+	ad, bd := unicode.IsDigit(rune(a[0])), unicode.IsDigit(rune(b[0]))
+	switch {
+	case ad && bd:
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+	case ad:
+		return 1
+	case bd:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// Prerelease reports whether a segment marks a pre-release version.
+func prerelease(s string) bool {
+	switch s {
+	case "a", "alpha", "b", "beta", "dev", "m", "milestone", "pre", "preview", "rc":
+		return true
+	}
+	return false
+}
+
+// Unquote removes the quoting from a CPE attribute value.
+func unquote(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Quote quotes a string for use as a CPE attribute value.
+func quote(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) * 2)
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		default:
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,39 @@
+package nvd
+
+import "testing"
+
+func TestCompareVersion(t *testing.T) {
+	tt := []struct {
+		A, B string
+		Want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.0", -1},
+		{"2.4", "2.12.2", -1},
+		{"2.12.2", "2.4", 1},
+		{"1.1.1k", "1.1.1", 1},
+		{"1.1.1k", "1.1.1l", -1},
+		{"1.1.1k", "1.1.1K", 0},
+		{"2.15.0-rc1", "2.15.0", -1},
+		{"2.15.0", "2.15.0-rc1", 1},
+		{"2.0-beta9", "2.0-rc1", -1},
+		{"1.0rc1", "1.0.1", -1},
+		{"4.10.018", "4.10.18", 0},
+		{"10.0.17763.1", "10.0.17763", 1},
+		{"1.2.3", "1_2_3", 0},
+	}
+	for _, tc := range tt {
+		if got, want := compareVersion(tc.A, tc.B), tc.Want; got != want {
+			t.Errorf("%q <=> %q: got: %d, want: %d", tc.A, tc.B, got, want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{"1.2.3", "2.0-beta9", "1:2.3~rc1", "1_2"} {
+		q := quote(s)
+		if got := unquote(q); got != s {
+			t.Errorf("%q: got: %q (quoted: %q)", s, got, q)
+		}
+	}
+}
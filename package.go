@@ -29,6 +29,23 @@ type Package struct {
 	Arch string `json:"arch,omitempty"`
 	// CPE name for package
 	CPE cpe.WFN `json:"cpe,omitempty"`
+	// KernelModule is set for packages of out-of-tree kernel modules, and
+	// separates the driver from the kernel the package is built for.
+	KernelModule *KernelModule `json:"kernel_module,omitempty"`
+}
+
+// KernelModule is a kernel module package split into the driver and the kernel
+// it's built for.
+type KernelModule struct {
+	// Name is the package name without any kernel or driver version, e.g.
+	// "kmod-nvidia".
+	Name string `json:"name"`
+	// Version is the package's version with any kernel removed: the version of
+	// the driver.
+	Version string `json:"version"`
+	// Kernel is the kernel release the package is built for, if the name or
+	// version records one.
+	Kernel string `json:"kernel,omitempty"`
 }
 
 const (
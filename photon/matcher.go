@@ -7,6 +7,7 @@ import (
 
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/pkg/kmod"
 )
 
 // Matcher implements driver.Matcher.
@@ -36,7 +37,12 @@ func (*Matcher) Query() []driver.MatchConstraint {
 
 // Vulnerable implements driver.Matcher.
 func (*Matcher) Vulnerable(ctx context.Context, record *claircore.IndexRecord, vuln *claircore.Vulnerability) (bool, error) {
-	pkgVer, vulnVer := version.NewVersion(record.Package.Version), version.NewVersion(vuln.Package.Version)
+	target := vuln.Package.Version
+	if vuln.FixedInVersion != "" {
+		target = vuln.FixedInVersion
+	}
+	pkgVer := version.NewVersion(kmod.MatchVersion(record.Package, target))
+	vulnVer := version.NewVersion(vuln.Package.Version)
 	// Assume the vulnerability record we have is for the last known vulnerable
 	// version, so greater versions aren't vulnerable.
 	cmp := func(i int) bool { return i != version.GREATER }
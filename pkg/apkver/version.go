@@ -0,0 +1,207 @@
+// Package apkver implements version comparison for Alpine's apk package
+// manager.
+//
+// The algorithm is a direct port of apk-tools' src/version.c. Versions have
+// the form:
+//
+//	{digit}{.digit}...{letter}{_suffix{#}}...{-r#}
+//
+// Pre-release suffixes sort before the bare version and post-release suffixes
+// after it:
+//
+//	_alpha < _beta < _pre < _rc < (none) < _cvs < _svn < _git < _hg < _p
+package apkver
+
+// Token is the kind of the next element of a version string.
+type token int
+
+// These are ordered: a later token type can't be followed by an earlier one,
+// with a few exceptions handled in next.
+const (
+	tokenInvalid token = iota - 1
+	tokenDigitOrZero
+	tokenDigit
+	tokenLetter
+	tokenSuffix
+	tokenSuffixNo
+	tokenRevisionNo
+	tokenEnd
+)
+
+var (
+	preSuffixes  = []string{"alpha", "beta", "pre", "rc"}
+	postSuffixes = []string{"cvs", "svn", "git", "hg", "p"}
+)
+
+// Scanner walks the tokens of a version string.
+type scanner struct {
+	s string
+	t token
+}
+
+func newScanner(s string) scanner {
+	return scanner{s: s, t: tokenDigit}
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isLower(c byte) bool { return c >= 'a' && c <= 'z' }
+
+// Next determines the type of the following token, consuming any separator.
+func (v *scanner) next() {
+	n := tokenInvalid
+	switch {
+	case len(v.s) == 0 || v.s[0] == 0:
+		n = tokenEnd
+	case (v.t == tokenDigit || v.t == tokenDigitOrZero) && isLower(v.s[0]):
+		n = tokenLetter
+	case v.t == tokenLetter && isDigit(v.s[0]):
+		n = tokenDigit
+	case v.t == tokenSuffix && isDigit(v.s[0]):
+		n = tokenSuffixNo
+	default:
+		switch v.s[0] {
+		case '.':
+			n = tokenDigitOrZero
+		case '_':
+			n = tokenSuffix
+		case '-':
+			if len(v.s) > 1 && v.s[1] == 'r' {
+				n = tokenRevisionNo
+				v.s = v.s[1:]
+			}
+		}
+		v.s = v.s[1:]
+	}
+	if n < v.t {
+		switch {
+		case n == tokenDigitOrZero && v.t == tokenDigit:
+		case n == tokenSuffix && v.t == tokenSuffixNo:
+		case n == tokenDigit && v.t == tokenLetter:
+		default:
+			n = tokenInvalid
+		}
+	}
+	v.t = n
+}
+
+// Token consumes the current token and returns its value, then advances to
+// the next token.
+func (v *scanner) token() int {
+	if len(v.s) == 0 {
+		v.t = tokenEnd
+		return 0
+	}
+	val, i, nt := 0, 0, tokenInvalid
+	switch v.t {
+	case tokenDigitOrZero:
+		// Leading zero digits get a special treatment.
+		if v.s[0] == '0' {
+			for i < len(v.s) && v.s[i] == '0' {
+				i++
+			}
+			nt = tokenDigit
+			val = -i
+			break
+		}
+		fallthrough
+	case tokenDigit, tokenSuffixNo, tokenRevisionNo:
+		for i < len(v.s) && isDigit(v.s[i]) {
+			val *= 10
+			val += int(v.s[i] - '0')
+			i++
+		}
+	case tokenLetter:
+		val = int(v.s[0])
+		i = 1
+	case tokenSuffix:
+		if n, ok := suffix(v.s, preSuffixes); ok {
+			val, i = n-len(preSuffixes), len(preSuffixes[n])
+			break
+		}
+		if n, ok := suffix(v.s, postSuffixes); ok {
+			val, i = n, len(postSuffixes[n])
+			break
+		}
+		// Invalid suffix.
+		fallthrough
+	default:
+		v.t = tokenInvalid
+		return -1
+	}
+	v.s = v.s[i:]
+	switch {
+	case len(v.s) == 0:
+		v.t = tokenEnd
+	case nt != tokenInvalid:
+		v.t = nt
+	default:
+		v.next()
+	}
+	return val
+}
+
+// Suffix reports the index of the first member of "ss" that "s" starts with.
+func suffix(s string, ss []string) (int, bool) {
+	for i, x := range ss {
+		if len(x) <= len(s) && s[:len(x)] == x {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Valid reports whether "v" is a well-formed apk version.
+func Valid(v string) bool {
+	s := newScanner(v)
+	for s.t != tokenEnd && s.t != tokenInvalid {
+		s.token()
+	}
+	return s.t == tokenEnd
+}
+
+// Compare returns an integer comparing two apk versions: -1 if a < b, 0 if
+// a == b, and 1 if a > b.
+//
+// Like apk, Compare doesn't reject invalid versions: they're compared up to
+// the first invalid element. Use Valid to check versions beforehand if that's
+// a concern.
+func Compare(a, b string) int {
+	as, bs := newScanner(a), newScanner(b)
+	var av, bv int
+	for as.t == bs.t && as.t != tokenEnd && as.t != tokenInvalid && av == bv {
+		av = as.token()
+		bv = bs.token()
+	}
+
+	// Value of this token differs?
+	switch {
+	case av < bv:
+		return -1
+	case av > bv:
+		return 1
+	}
+	// Both have tokenEnd or tokenInvalid next?
+	if as.t == bs.t {
+		return 0
+	}
+	// Leading version components and their values are equal, now the
+	// non-terminating version is greater unless it's a suffix indicating
+	// pre-release.
+	if as.t == tokenSuffix {
+		if t := as; t.token() < 0 {
+			return -1
+		}
+	}
+	if bs.t == tokenSuffix {
+		if t := bs; t.token() < 0 {
+			return 1
+		}
+	}
+	switch {
+	case as.t > bs.t:
+		return -1
+	case as.t < bs.t:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package apkver
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestCompare runs the test vectors from apk-tools' test/version.data.
+//
+// Each line is "a op b", optionally followed by a comment.
+func TestCompare(t *testing.T) {
+	f, err := os.Open("testdata/version.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	ct := 0
+	for s.Scan() {
+		l := s.Text()
+		if i := strings.IndexByte(l, '#'); i != -1 {
+			l = l[:i]
+		}
+		fs := strings.Fields(l)
+		if len(fs) == 0 {
+			continue
+		}
+		if len(fs) != 3 {
+			t.Fatalf("malformed line: %q", s.Text())
+		}
+		a, op, b := fs[0], fs[1], fs[2]
+		var want int
+		switch op {
+		case "<":
+			want = -1
+		case "=":
+			want = 0
+		case ">":
+			want = 1
+		default:
+			t.Fatalf("unknown operator: %q", op)
+		}
+		ct++
+		if got := Compare(a, b); got != want {
+			t.Errorf("%s %s %s: got %d", a, op, b, got)
+		}
+		// The comparison should be antisymmetric.
+		if got := Compare(b, a); got != -want {
+			t.Errorf("%s %s %s (reversed): got %d", a, op, b, got)
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("ran %d cases", ct)
+}
+
+func TestValid(t *testing.T) {
+	tt := []struct {
+		In    string
+		Valid bool
+	}{
+		{In: "1.2.3", Valid: true},
+		{In: "1.2.3_alpha1", Valid: true},
+		{In: "1.2.3-r1", Valid: true},
+		{In: "0.1.0_alpha_pre2", Valid: true},
+		{In: "1.0b", Valid: true},
+		{In: "1.1.1k-r0", Valid: true},
+		{In: "2.35-r17", Valid: true},
+		{In: "1.0_p1-r2", Valid: true},
+		{In: "1.0bc", Valid: false},
+		{In: "1.0!", Valid: false},
+		{In: "1.0_foo", Valid: false},
+		{In: "1.0-1", Valid: false},
+		{In: "1.0-r1.2", Valid: false},
+	}
+	for _, tc := range tt {
+		if got, want := Valid(tc.In), tc.Valid; got != want {
+			t.Errorf("%q: got: %v, want: %v", tc.In, got, want)
+		}
+	}
+}
+
+func TestSuffixOrder(t *testing.T) {
+	order := []string{
+		"1.1_alpha", "1.1_beta", "1.1_pre", "1.1_rc",
+		"1.1", "1.1-r1",
+		"1.1_cvs", "1.1_svn", "1.1_git", "1.1_hg", "1.1_p", "1.1_p1-r1",
+		"1.1a", "1.1a-r1",
+		"1.2_alpha",
+	}
+	for i := 1; i < len(order); i++ {
+		a, b := order[i-1], order[i]
+		if got := Compare(a, b); got != -1 {
+			t.Errorf("%s < %s: got %d", a, b, got)
+		}
+	}
+}
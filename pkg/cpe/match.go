@@ -0,0 +1,181 @@
+package cpe
+
+import "strings"
+
+// Relation is the relation between a source and target attribute value, as
+// defined in the CPE Name Matching specification:
+// https://nvlpubs.nist.gov/nistpubs/Legacy/IR/nistir7696.pdf
+type Relation uint
+
+//go:generate stringer -type Relation
+
+// These are the possible attribute relations.
+const (
+	// Disjoint means the values have nothing in common.
+	Disjoint Relation = iota
+	// Subset means the source value is a subset of the target value.
+	Subset
+	// Superset means the source value is a superset of the target value.
+	Superset
+	// Equal means the values are the same.
+	Equal
+	// Undefined means the relation can't be determined, which happens when
+	// the target contains wildcards.
+	Undefined
+)
+
+// Compare does the attribute-wise comparison of the source and target
+// WFNs. The source is usually a pattern, such as a CPE from advisory data,
+// and the target is a name, such as the CPE of an installed package.
+//
+// Unset attributes are treated as ANY.
+func Compare(src, tgt WFN) (r [NumAttr]Relation) {
+	for i := 0; i < NumAttr; i++ {
+		r[i] = compareValues(&src.Attr[i], &tgt.Attr[i])
+	}
+	return r
+}
+
+// Match reports whether the source WFN matches the target WFN: that is,
+// whether every attribute of the source is a superset of, or equal to, the
+// corresponding attribute of the target.
+func Match(src, tgt WFN) bool {
+	for _, r := range Compare(src, tgt) {
+		if r != Superset && r != Equal {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareValues implements the attribute comparison table from the
+// specification.
+func compareValues(src, tgt *Value) Relation {
+	sk, tk := src.Kind, tgt.Kind
+	if sk == ValueUnset {
+		sk = ValueAny
+	}
+	if tk == ValueUnset {
+		tk = ValueAny
+	}
+	if tk == ValueSet && hasWildcard(tgt.V) {
+		return Undefined
+	}
+	switch sk {
+	case ValueAny:
+		if tk == ValueAny {
+			return Equal
+		}
+		return Superset
+	case ValueNA:
+		switch tk {
+		case ValueAny:
+			return Subset
+		case ValueNA:
+			return Equal
+		}
+		return Disjoint
+	}
+	switch tk {
+	case ValueAny:
+		return Subset
+	case ValueNA:
+		return Disjoint
+	}
+	s, t := strings.ToLower(src.V), strings.ToLower(tgt.V)
+	switch {
+	case s == t:
+		return Equal
+	case hasWildcard(s) && matchWildcard(s, t):
+		return Superset
+	}
+	return Disjoint
+}
+
+// HasWildcard reports whether the value has an unquoted "*" or "?".
+func hasWildcard(v string) bool {
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '\\':
+			i++
+		case '*', '?':
+			return true
+		}
+	}
+	return false
+}
+
+// MatchWildcard reports whether the target value is matched by the source
+// value. Wildcards only appear at the start and end of a value, by
+// construction: a leading or trailing "*" matches any number of characters,
+// and a run of "?" matches up to that many characters.
+func matchWildcard(src, tgt string) bool {
+	// Begin and end are the number of characters the leading and trailing
+	// wildcards allow, or -1 for any number.
+	begin, end := 0, 0
+	switch {
+	case strings.HasPrefix(src, "*"):
+		src, begin = src[1:], -1
+	default:
+		for strings.HasPrefix(src, "?") {
+			src = src[1:]
+			begin++
+		}
+	}
+	switch {
+	case strings.HasSuffix(src, "*") && !escaped(src, len(src)-1):
+		src, end = src[:len(src)-1], -1
+	default:
+		for strings.HasSuffix(src, "?") && !escaped(src, len(src)-1) {
+			src = src[:len(src)-1]
+			end++
+		}
+	}
+	// Work in terms of characters, where a quoted character is a single
+	// character.
+	body, chars := split(src), split(tgt)
+	for i := 0; i+len(body) <= len(chars); i++ {
+		if begin != -1 && i > begin {
+			break
+		}
+		if left := len(chars) - i - len(body); end != -1 && left > end {
+			continue
+		}
+		if equalChars(body, chars[i:i+len(body)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Escaped reports whether the byte at "i" is quoted.
+func escaped(s string, i int) bool {
+	n := 0
+	for i--; i >= 0 && s[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// Split breaks a value into characters, keeping quoted characters together.
+func split(s string) []string {
+	out := make([]string, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			out = append(out, s[i:i+2])
+			i++
+			continue
+		}
+		out = append(out, s[i:i+1])
+	}
+	return out
+}
+
+func equalChars(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package cpe
+
+import "testing"
+
+func TestCompareValues(t *testing.T) {
+	anyV := Value{Kind: ValueAny}
+	naV := Value{Kind: ValueNA}
+	set := func(s string) Value { return Value{Kind: ValueSet, V: s} }
+	tt := []struct {
+		Src, Tgt Value
+		Want     Relation
+	}{
+		{anyV, anyV, Equal},
+		{anyV, naV, Superset},
+		{anyV, set("foo"), Superset},
+		{Value{}, set("foo"), Superset},
+		{naV, anyV, Subset},
+		{naV, naV, Equal},
+		{naV, set("foo"), Disjoint},
+		{set("foo"), anyV, Subset},
+		{set("foo"), Value{}, Subset},
+		{set("foo"), naV, Disjoint},
+		{set("foo"), set("foo"), Equal},
+		{set("Foo"), set("fOO"), Equal},
+		{set("foo"), set("bar"), Disjoint},
+		{set("foo"), set("fo*"), Undefined},
+		{anyV, set(`8\.?`), Undefined},
+
+		{set(`8\.*`), set(`8\.0\.6001`), Superset},
+		{set(`8\.*`), set(`9\.0`), Disjoint},
+		{set(`*soft*`), set(`microsoft_office`), Superset},
+		{set(`*soft`), set(`microsoft_office`), Disjoint},
+		{set(`*\.0`), set(`8\.0`), Superset},
+		{set(`8\.??`), set(`8\.0`), Superset},
+		{set(`8\.??`), set(`8\.01`), Superset},
+		{set(`8\.??`), set(`8\.012`), Disjoint},
+		{set(`?0`), set(`10`), Superset},
+		{set(`?0`), set(`110`), Disjoint},
+		{set(`foo\*`), set(`foo\*`), Equal},
+		{set(`foo\*`), set(`foobar`), Disjoint},
+	}
+	for _, tc := range tt {
+		src, tgt := tc.Src, tc.Tgt
+		if got, want := compareValues(&src, &tgt), tc.Want; got != want {
+			t.Errorf("%v, %v: got: %v, want: %v", src.String(), tgt.String(), got, want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tt := []struct {
+		Src, Tgt string
+		Want     bool
+	}{
+		{
+			Src:  `cpe:2.3:a:apache:http_server:*:*:*:*:*:*:*:*`,
+			Tgt:  `cpe:2.3:a:apache:http_server:2.4.49:*:*:*:*:*:*:*`,
+			Want: true,
+		},
+		{
+			Src:  `cpe:2.3:a:apache:http_server:2.4.49:*:*:*:*:*:*:*`,
+			Tgt:  `cpe:2.3:a:apache:http_server:2.4.49:*:*:*:*:*:*:*`,
+			Want: true,
+		},
+		{
+			Src:  `cpe:2.3:a:apache:http_server:2.4.49:*:*:*:*:*:*:*`,
+			Tgt:  `cpe:2.3:a:apache:http_server:*:*:*:*:*:*:*:*`,
+			Want: false,
+		},
+		{
+			Src:  `cpe:2.3:a:apache:http_server:2.4.49:*:*:*:*:*:*:*`,
+			Tgt:  `cpe:2.3:a:apache:tomcat:2.4.49:*:*:*:*:*:*:*`,
+			Want: false,
+		},
+		{
+			Src:  `cpe:2.3:o:redhat:enterprise_linux:8.*:*:*:*:*:*:*:*`,
+			Tgt:  `cpe:/o:redhat:enterprise_linux:8.4::baseos`,
+			Want: true,
+		},
+		{
+			Src:  `cpe:2.3:a:microsoft:.net_framework:4.8:-:*:*:*:*:*:*`,
+			Tgt:  `cpe:2.3:a:microsoft:.net_framework:4.8:sp1:*:*:*:*:*:*`,
+			Want: false,
+		},
+		{
+			Src:  `cpe:2.3:a:microsoft:.net_framework:4.8:-:*:*:*:*:*:*`,
+			Tgt:  `cpe:2.3:a:microsoft:.net_framework:4.8:-:*:*:*:*:*:*`,
+			Want: true,
+		},
+	}
+	for _, tc := range tt {
+		if got, want := Match(MustUnbind(tc.Src), MustUnbind(tc.Tgt)), tc.Want; got != want {
+			t.Errorf("%s ⊇ %s: got: %v, want: %v", tc.Src, tc.Tgt, got, want)
+		}
+	}
+}
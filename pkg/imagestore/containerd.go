@@ -0,0 +1,184 @@
+package imagestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// DefaultContainerdRoot is where containerd keeps its content store by
+// default.
+const DefaultContainerdRoot = "/var/lib/containerd/io.containerd.content.v1.content"
+
+// ContainerdStore is containerd's content store.
+//
+// The content store holds blobs named by their digest. Image names are kept
+// in containerd's metadata database instead, which isn't read, so every
+// image manifest in the store whose contents are all present is reported,
+// without Names. Manifests for other platforms, pulled as part of an image
+// index, are left out, as their layers aren't in the store.
+type ContainerdStore struct {
+	fsys fs.FS
+}
+
+var _ Store = (*ContainerdStore)(nil)
+
+// Containerd returns the ContainerdStore in the directory "root", which is
+// the "io.containerd.content.v1.content" directory in containerd's root
+// directory. If "root" is empty, DefaultContainerdRoot is used.
+func Containerd(root string) (*ContainerdStore, error) {
+	if root == "" {
+		root = DefaultContainerdRoot
+	}
+	fi, err := os.Stat(filepath.Join(root, "blobs"))
+	if err != nil {
+		return nil, fmt.Errorf("imagestore: not a containerd content store: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("imagestore: not a containerd content store: %q", root)
+	}
+	return &ContainerdStore{fsys: os.DirFS(root)}, nil
+}
+
+// FS implements Store.
+func (s *ContainerdStore) FS() fs.FS {
+	return s.fsys
+}
+
+// Images implements Store.
+//
+// Images are ordered by the digest of their manifest.
+func (s *ContainerdStore) Images(ctx context.Context) ([]Image, error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "imagestore/ContainerdStore.Images")
+	algos, err := fs.ReadDir(s.fsys, "blobs")
+	if err != nil {
+		return nil, fmt.Errorf("imagestore: unable to read content store: %w", err)
+	}
+	var out []Image
+	for _, algo := range algos {
+		if !algo.IsDir() {
+			continue
+		}
+		dir := path.Join("blobs", algo.Name())
+		ents, err := fs.ReadDir(s.fsys, dir)
+		if err != nil {
+			return nil, fmt.Errorf("imagestore: unable to read content store: %w", err)
+		}
+		for _, ent := range ents {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			d, err := claircore.ParseDigest(algo.Name() + ":" + ent.Name())
+			if err != nil || !ent.Type().IsRegular() {
+				// Not a blob, like an in-progress ingest.
+				continue
+			}
+			m, err := s.manifest(d)
+			switch {
+			case err == nil:
+			case errors.Is(err, errNotManifest), errors.Is(err, fs.ErrNotExist):
+				// Not a manifest, or removed since the directory was read.
+				continue
+			case errors.Is(err, errIncomplete):
+				zlog.Debug(ctx).
+					Err(err).
+					Stringer("manifest", d).
+					Msg("skipping incomplete image")
+				continue
+			default:
+				return nil, err
+			}
+			out = append(out, Image{Manifest: m})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Manifest.Hash.String() < out[j].Manifest.Hash.String()
+	})
+	return out, nil
+}
+
+// ErrNotManifest is reported by manifest for blobs that aren't image
+// manifests.
+var errNotManifest = errors.New("imagestore: not an image manifest")
+
+// Manifest returns the Manifest for the image manifest blob with the digest.
+func (s *ContainerdStore) manifest(d claircore.Digest) (*claircore.Manifest, error) {
+	name := contentBlob(d)
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Don't bother reading anything that doesn't look like JSON, like
+	// layers.
+	var b [1]byte
+	_, err = io.ReadFull(f, b[:])
+	f.Close()
+	if fi.Size() > maxManifestSize || err != nil || b[0] != '{' {
+		return nil, errNotManifest
+	}
+	raw, err := readVerified(s.fsys, name, d)
+	if err != nil {
+		return nil, err
+	}
+	var im imageManifest
+	if err := json.Unmarshal(raw, &im); err != nil || !im.isImage() {
+		return nil, errNotManifest
+	}
+
+	cd, err := claircore.ParseDigest(im.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("imagestore: manifest %v: bad config digest: %w", d, err)
+	}
+	if _, err := fs.Stat(s.fsys, contentBlob(cd)); err != nil {
+		return nil, fmt.Errorf("%w: manifest %v: config: %v", errIncomplete, d, err)
+	}
+	out := &claircore.Manifest{
+		Hash:   d,
+		Layers: make([]*claircore.Layer, 0, len(im.Layers)),
+	}
+	for _, ld := range im.Layers {
+		h, err := claircore.ParseDigest(ld.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("imagestore: manifest %v: bad layer digest: %w", d, err)
+		}
+		if _, err := fs.Stat(s.fsys, contentBlob(h)); err != nil {
+			return nil, fmt.Errorf("%w: manifest %v: layer: %v", errIncomplete, d, err)
+		}
+		out.Layers = append(out.Layers, &claircore.Layer{
+			Hash:      h,
+			URI:       "file:///" + contentBlob(h),
+			MediaType: ld.MediaType,
+			Size:      ld.Size,
+			Headers:   make(map[string][]string),
+		})
+	}
+	if out.Config, err = readConfig(s.fsys, contentBlob(cd), cd); err != nil {
+		return nil, err
+	}
+	out.OS = out.Config.OS
+	return out, nil
+}
+
+// ContentBlob returns the name of the blob with the digest in a content
+// store.
+func contentBlob(d claircore.Digest) string {
+	return path.Join("blobs", strings.Replace(d.String(), ":", "/", 1))
+}
@@ -0,0 +1,167 @@
+package imagestore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/libindex"
+)
+
+// Digest returns the sha256 digest of "b".
+func digest(t testing.TB, b []byte) claircore.Digest {
+	t.Helper()
+	sum := sha256.Sum256(b)
+	d, err := claircore.NewDigest("sha256", sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// MustJSON returns "v" marshaled as JSON.
+func mustJSON(t testing.TB, v interface{}) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// WriteFile writes "b" to "name", creating its directory.
+func writeFile(t testing.TB, name string, b []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Tarball returns a tar holding "file" with "contents".
+func tarball(t testing.TB, contents string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Size: int64(len(contents)), Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(tw, contents)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// Config returns an image configuration for an image with the layers.
+func config(t testing.TB, layers ...[]byte) []byte {
+	t.Helper()
+	var cfg claircore.ImageConfig
+	cfg.OS, cfg.Architecture = "linux", "amd64"
+	for _, l := range layers {
+		cfg.RootFS.DiffIDs = append(cfg.RootFS.DiffIDs, digest(t, l))
+	}
+	return mustJSON(t, &cfg)
+}
+
+// ReadLayers realizes the Layers from "fsys" and returns the contents of
+// "file" in each.
+func readLayers(ctx context.Context, t *testing.T, s Store, ls []*claircore.Layer) []string {
+	t.Helper()
+	ctx = libindex.WithLocalFS(ctx, s.FS())
+	a := libindex.NewRemoteFetchArena(nil, t.TempDir())
+	defer a.Close(ctx)
+	f := a.Realizer(ctx)
+	defer f.Close()
+	if err := f.Realize(ctx, ls); err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, l := range ls {
+		bs, err := l.Files("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, bs["file"].String())
+	}
+	return out
+}
+
+func TestContainerd(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	root := t.TempDir()
+	blob := func(b []byte) descriptor {
+		d := digest(t, b)
+		writeFile(t, filepath.Join(root, filepath.FromSlash(contentBlob(d))), b)
+		return descriptor{Digest: d.String(), Size: int64(len(b))}
+	}
+	var z bytes.Buffer
+	zw := gzip.NewWriter(&z)
+	raw := tarball(t, "from containerd")
+	zw.Write(raw)
+	zw.Close()
+	ld := blob(z.Bytes())
+	ld.MediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+	cd := blob(config(t, raw))
+	cd.MediaType = mediaTypeOCIConfig
+	md := blob(mustJSON(t, &imageManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        &cd,
+		Layers:        []descriptor{ld},
+	}))
+	// A manifest for another platform, without its layer.
+	missing := descriptor{
+		MediaType: ld.MediaType,
+		Digest:    digest(t, []byte("missing")).String(),
+	}
+	blob(mustJSON(t, &imageManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        &cd,
+		Layers:        []descriptor{missing},
+	}))
+	// An index, which isn't an image.
+	blob([]byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`))
+
+	s, err := Containerd(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgs, err := s.Images(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(imgs), 1; got != want {
+		t.Fatalf("images: got: %d, want: %d", got, want)
+	}
+	m := imgs[0].Manifest
+	if got, want := m.Hash.String(), md.Digest; got != want {
+		t.Errorf("manifest: got: %q, want: %q", got, want)
+	}
+	if got, want := m.OS, "linux"; got != want {
+		t.Errorf("os: got: %q, want: %q", got, want)
+	}
+	if got, want := readLayers(ctx, t, s, m.Layers), []string{"from containerd"}; !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+
+	t.Run("NotAStore", func(t *testing.T) {
+		if _, err := Containerd(t.TempDir()); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
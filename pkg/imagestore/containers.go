@@ -0,0 +1,305 @@
+package imagestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+)
+
+// DefaultContainersRoot is where containers/storage keeps images by default
+// when run as root. Rootless podman uses "~/.local/share/containers/storage".
+const DefaultContainersRoot = "/var/lib/containers/storage"
+
+// ContainersStore is a containers/storage image store, as used by podman,
+// Buildah, and CRI-O.
+//
+// Layers are stored unpacked, so their tars are put back together as
+// they're read. Layers are identified by their uncompressed digests, like
+// image configurations' DiffIDs, as that's what can be checked. An image's
+// Manifest's Hash is the digest of its manifest, if it has one, or of its
+// configuration otherwise.
+//
+// Only the "overlay" and "vfs" storage drivers are supported.
+type ContainersStore struct {
+	fsys *containersFS
+}
+
+var _ Store = (*ContainersStore)(nil)
+
+// ContainersStorage returns the ContainersStore in the directory "root",
+// which is the "graphroot" in containers/storage's configuration. If "root"
+// is empty, DefaultContainersRoot is used.
+func ContainersStorage(root string) (*ContainersStore, error) {
+	if root == "" {
+		root = DefaultContainersRoot
+	}
+	for _, d := range []string{"overlay", "vfs"} {
+		_, err := os.Stat(filepath.Join(root, d+"-images", "images.json"))
+		switch {
+		case err == nil:
+			return &ContainersStore{fsys: &containersFS{root: root, driver: d}}, nil
+		case errors.Is(err, fs.ErrNotExist):
+		default:
+			return nil, fmt.Errorf("imagestore: unable to read containers storage: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("imagestore: no supported containers storage in %q", root)
+}
+
+// FS implements Store.
+//
+// The FS only contains the store's layers, as "layers/<id>".
+func (s *ContainersStore) FS() fs.FS {
+	return s.fsys
+}
+
+// StorageImage is an entry in "images.json".
+type storageImage struct {
+	ID           string   `json:"id"`
+	Names        []string `json:"names,omitempty"`
+	TopLayer     string   `json:"layer,omitempty"`
+	BigDataNames []string `json:"big-data-names,omitempty"`
+}
+
+// StorageLayer is an entry in "layers.json".
+type storageLayer struct {
+	ID         string `json:"id"`
+	Parent     string `json:"parent,omitempty"`
+	DiffDigest string `json:"diff-digest,omitempty"`
+	DiffSize   int64  `json:"diff-size,omitempty"`
+}
+
+// Images implements Store.
+//
+// Images are in the order the store lists them.
+func (s *ContainersStore) Images(ctx context.Context) ([]Image, error) {
+	ctx = zlog.ContextWithValues(ctx,
+		"component", "imagestore/ContainersStore.Images",
+		"driver", s.fsys.driver)
+	var imgs []storageImage
+	if err := s.fsys.readJSON("images", "images.json", &imgs); err != nil {
+		return nil, err
+	}
+	layers, err := s.fsys.layers()
+	if err != nil {
+		return nil, err
+	}
+	var out []Image
+	for i := range imgs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		img := &imgs[i]
+		m, err := s.manifest(img, layers)
+		switch {
+		case err == nil:
+		case errors.Is(err, errIncomplete):
+			zlog.Debug(ctx).
+				Err(err).
+				Str("image", img.ID).
+				Msg("skipping incomplete image")
+			continue
+		default:
+			return nil, err
+		}
+		out = append(out, Image{Names: img.Names, Manifest: m})
+	}
+	return out, nil
+}
+
+// Manifest returns the Manifest for the image.
+func (s *ContainersStore) manifest(img *storageImage, layers map[string]*storageLayer) (*claircore.Manifest, error) {
+	if !validID(img.ID) {
+		return nil, fmt.Errorf("imagestore: bad image id %q", img.ID)
+	}
+	has := make(map[string]bool, len(img.BigDataNames))
+	for _, n := range img.BigDataNames {
+		has[n] = true
+	}
+	out := &claircore.Manifest{}
+	// Images are named for their configuration, unless told otherwise.
+	cd, err := claircore.ParseDigest("sha256:" + img.ID)
+	if err != nil {
+		return nil, fmt.Errorf("imagestore: bad image id %q: %w", img.ID, err)
+	}
+	out.Hash = cd
+	if has["manifest"] {
+		b, err := s.fsys.bigData(img.ID, "manifest")
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(b)
+		if out.Hash, err = claircore.NewDigest("sha256", sum[:]); err != nil {
+			return nil, err
+		}
+		var im imageManifest
+		if err := json.Unmarshal(b, &im); err != nil {
+			return nil, fmt.Errorf("imagestore: image %s: unable to decode manifest: %w", img.ID, err)
+		}
+		if im.Config != nil {
+			if cd, err = claircore.ParseDigest(im.Config.Digest); err != nil {
+				return nil, fmt.Errorf("imagestore: image %s: bad config digest: %w", img.ID, err)
+			}
+		}
+	}
+	if !has[cd.String()] {
+		return nil, fmt.Errorf("%w: image %s: no config", errIncomplete, img.ID)
+	}
+	out.Config, err = readConfig(s.fsys.images(), path.Join(img.ID, bigDataName(cd.String())), cd)
+	if err != nil {
+		return nil, err
+	}
+	out.OS = out.Config.OS
+
+	// Layers are listed from the top down.
+	for id := img.TopLayer; id != ""; {
+		l, ok := layers[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: image %s: missing layer %s", errIncomplete, img.ID, id)
+		}
+		h, err := claircore.ParseDigest(l.DiffDigest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: image %s: layer %s has no digest", errIncomplete, img.ID, id)
+		}
+		out.Layers = append(out.Layers, &claircore.Layer{
+			Hash:    h,
+			URI:     "file:///layers/" + id,
+			Headers: make(map[string][]string),
+		})
+		id = l.Parent
+	}
+	for i, j := 0, len(out.Layers)-1; i < j; i, j = i+1, j-1 {
+		out.Layers[i], out.Layers[j] = out.Layers[j], out.Layers[i]
+	}
+	return out, nil
+}
+
+// ContainersFS is an fs.FS of the layers in a containers/storage store, as
+// "layers/<id>".
+type containersFS struct {
+	root   string
+	driver string
+}
+
+// Open implements fs.FS.
+func (f *containersFS) Open(name string) (fs.File, error) {
+	id := strings.TrimPrefix(name, "layers/")
+	if !fs.ValidPath(name) || id == name || !validID(id) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	layers, err := f.layers()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	l, ok := layers[id]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	var dir string
+	switch f.driver {
+	case "overlay":
+		dir = filepath.Join(f.root, "overlay", id, "diff")
+	case "vfs":
+		dir = filepath.Join(f.root, "vfs", "dir", id)
+	}
+	r, err := newTarSplitReader(filepath.Join(f.root, f.driver+"-layers", id+".tar-split.gz"), dir)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &layerFile{tarSplitReader: r, name: id, size: l.DiffSize}, nil
+}
+
+// Layers returns the store's layers, by ID.
+func (f *containersFS) layers() (map[string]*storageLayer, error) {
+	var ls []storageLayer
+	if err := f.readJSON("layers", "layers.json", &ls); err != nil {
+		return nil, err
+	}
+	out := make(map[string]*storageLayer, len(ls))
+	for i := range ls {
+		out[ls[i].ID] = &ls[i]
+	}
+	return out, nil
+}
+
+// ReadJSON decodes the named file in the driver's directory of "kind".
+func (f *containersFS) readJSON(kind, name string, v interface{}) error {
+	b, err := os.ReadFile(filepath.Join(f.root, f.driver+"-"+kind, name))
+	if err != nil {
+		return fmt.Errorf("imagestore: unable to read containers storage: %w", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("imagestore: unable to decode %s: %w", name, err)
+	}
+	return nil
+}
+
+// Images returns an fs.FS of the driver's image directory.
+func (f *containersFS) images() fs.FS {
+	return os.DirFS(filepath.Join(f.root, f.driver+"-images"))
+}
+
+// BigData reads the image's data stored under "key", like its manifest.
+func (f *containersFS) bigData(id, key string) ([]byte, error) {
+	b, err := fs.ReadFile(f.images(), path.Join(id, bigDataName(key)))
+	if err != nil {
+		return nil, fmt.Errorf("imagestore: image %s: unable to read %s: %w", id, key, err)
+	}
+	return b, nil
+}
+
+// BigDataName returns the name of the file an image's data stored under
+// "key" is kept in. Keys made of anything other than lowercase letters,
+// digits, and dots are encoded, as containers/storage does.
+func bigDataName(key string) string {
+	for _, c := range key {
+		if c != '.' && (c < '0' || c > '9') && (c < 'a' || c > 'z') {
+			return "=" + base64.StdEncoding.EncodeToString([]byte(key))
+		}
+	}
+	return key
+}
+
+// ValidID reports whether "id" is usable as a file name.
+func validID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+// LayerFile is a layer's tar, put back together.
+type layerFile struct {
+	*tarSplitReader
+	name string
+	size int64
+}
+
+var _ fs.File = (*layerFile)(nil)
+
+// Stat implements fs.File.
+func (f *layerFile) Stat() (fs.FileInfo, error) {
+	return layerInfo{f}, nil
+}
+
+// LayerInfo implements fs.FileInfo for a layerFile.
+type layerInfo struct {
+	f *layerFile
+}
+
+func (i layerInfo) Name() string       { return i.f.name }
+func (i layerInfo) Size() int64        { return i.f.size }
+func (i layerInfo) Mode() fs.FileMode  { return 0o444 }
+func (i layerInfo) ModTime() time.Time { return time.Time{} }
+func (i layerInfo) IsDir() bool        { return false }
+func (i layerInfo) Sys() interface{}   { return nil }
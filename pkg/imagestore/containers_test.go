@@ -0,0 +1,161 @@
+package imagestore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quay/zlog"
+)
+
+// StoreLayer writes a layer holding "files" into the containers/storage
+// store at "root", unpacked with a tar-split file as containers/storage does,
+// and returns the layer's tar.
+func storeLayer(t testing.TB, root, driver, id string, files map[string]string) []byte {
+	t.Helper()
+	dir := filepath.Join(root, "overlay", id, "diff")
+	if driver == "vfs" {
+		dir = filepath.Join(root, "vfs", "dir", id)
+	}
+	var buf bytes.Buffer
+	var split bytes.Buffer
+	enc := json.NewEncoder(&split)
+	var prev int
+	segment := func() {
+		if buf.Len() == prev {
+			return
+		}
+		enc.Encode(&tarSplitEntry{Type: tarSplitSegment, Payload: append([]byte(nil), buf.Bytes()[prev:]...)})
+		prev = buf.Len()
+	}
+	tw := tar.NewWriter(&buf)
+	names := make([]string, 0, len(files))
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		c := files[n]
+		h := &tar.Header{Name: n, Size: int64(len(c)), Mode: 0o644}
+		if strings.HasSuffix(n, "/") {
+			h.Typeflag, h.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		segment()
+		enc.Encode(&tarSplitEntry{Type: tarSplitFile, Name: n, Size: h.Size})
+		if h.Typeflag != tar.TypeDir {
+			writeFile(t, filepath.Join(dir, filepath.FromSlash(n)), []byte(c))
+		}
+		io.WriteString(tw, c)
+		prev = buf.Len()
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segment()
+	var z bytes.Buffer
+	zw := gzip.NewWriter(&z)
+	zw.Write(split.Bytes())
+	zw.Close()
+	writeFile(t, filepath.Join(root, driver+"-layers", id+".tar-split.gz"), z.Bytes())
+	return buf.Bytes()
+}
+
+func TestContainersStorage(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	for _, driver := range []string{"overlay", "vfs"} {
+		t.Run(driver, func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			root := t.TempDir()
+			base := storeLayer(t, root, driver, "base", map[string]string{"etc/": "", "etc/empty": "", "etc/os-release": "ID=test\n", "file": "base layer"})
+			top := storeLayer(t, root, driver, "top", map[string]string{"file": "top layer"})
+			layers := []storageLayer{
+				{ID: "base", DiffDigest: digest(t, base).String(), DiffSize: int64(len(base))},
+				{ID: "top", Parent: "base", DiffDigest: digest(t, top).String(), DiffSize: int64(len(top))},
+				// A layer belonging to a container.
+				{ID: "container", Parent: "top"},
+			}
+			writeFile(t, filepath.Join(root, driver+"-layers", "layers.json"), mustJSON(t, layers))
+
+			cfg := config(t, base, top)
+			cd := digest(t, cfg)
+			id := strings.TrimPrefix(cd.String(), "sha256:")
+			man := mustJSON(t, &imageManifest{
+				SchemaVersion: 2,
+				MediaType:     mediaTypeOCIManifest,
+				Config:        &descriptor{MediaType: mediaTypeOCIConfig, Digest: cd.String()},
+			})
+			imgDir := filepath.Join(root, driver+"-images", id)
+			writeFile(t, filepath.Join(imgDir, bigDataName(cd.String())), cfg)
+			writeFile(t, filepath.Join(imgDir, "manifest"), man)
+			imgs := []storageImage{
+				{
+					ID:           id,
+					Names:        []string{"localhost/test:latest"},
+					TopLayer:     "top",
+					BigDataNames: []string{cd.String(), "manifest"},
+				},
+				// An image whose configuration went missing.
+				{ID: strings.Repeat("0", 64), TopLayer: "top"},
+			}
+			writeFile(t, filepath.Join(root, driver+"-images", "images.json"), mustJSON(t, imgs))
+
+			s, err := ContainersStorage(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found, err := s.Images(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != 1 {
+				t.Fatalf("images: got: %d, want: 1", len(found))
+			}
+			if got, want := found[0].Names, imgs[0].Names; !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+			m := found[0].Manifest
+			if got, want := m.Hash.String(), digest(t, man).String(); got != want {
+				t.Errorf("manifest: got: %q, want: %q", got, want)
+			}
+			var got, want []string
+			for i, l := range m.Layers {
+				got = append(got, l.Hash.String())
+				want = append(want, m.Config.RootFS.DiffIDs[i].String())
+			}
+			if !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+			// The layers are put back together exactly, or they'd fail to
+			// verify.
+			if got, want := readLayers(ctx, t, s, m.Layers), []string{"base layer", "top layer"}; !cmp.Equal(got, want) {
+				t.Error(cmp.Diff(got, want))
+			}
+		})
+	}
+
+	t.Run("NoStore", func(t *testing.T) {
+		if _, err := ContainersStorage(t.TempDir()); err == nil {
+			t.Error("expected error")
+		}
+	})
+	t.Run("BigDataName", func(t *testing.T) {
+		if got, want := bigDataName("manifest"), "manifest"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+		if got := bigDataName("sha256:abc"); !strings.HasPrefix(got, "=") || path.Base(got) != got {
+			t.Errorf("got: %q", got)
+		}
+	})
+}
@@ -0,0 +1,179 @@
+// Package imagestore finds images in the image stores of container runtimes
+// on the local host, so they can be indexed without fetching them from a
+// registry.
+//
+// Two kinds of store are supported:
+//
+//   - containerd's content store, as used by containerd, nerdctl, and
+//     Kubernetes nodes running containerd. See Containerd.
+//   - containers/storage, as used by podman, Buildah, and CRI-O. See
+//     ContainersStorage.
+//
+// Stores are read directly from disk, so the process needs permission to
+// read them, and the runtime needn't be running. Images found are described
+// by Manifests whose Layers have "file" URIs naming files in the store's FS;
+// Index hands them to a Libindex with libindex.WithLocalFS.
+package imagestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/quay/zlog"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/libindex"
+)
+
+// Store is a local image store.
+type Store interface {
+	// Images returns the images in the store.
+	Images(ctx context.Context) ([]Image, error)
+	// FS returns the fs.FS the Layers of the Images' Manifests are read
+	// from.
+	FS() fs.FS
+}
+
+// Image is an image found in a Store.
+type Image struct {
+	// Names are the names the image is known by, like
+	// "quay.io/projectquay/clair:4.4.0", if the store records them.
+	Names []string
+	// Manifest is the image's Manifest, ready to index. Its Config is
+	// always set.
+	Manifest *claircore.Manifest
+}
+
+// Index indexes every image in the store with the Libindex. Images are
+// indexed one after another, so layers they share are only scanned once.
+// Each IndexReport's Hash is that of the Image's Manifest.
+//
+// As with Libindex.Index, an error encountered while indexing an image is
+// reported in its IndexReport. An error is only returned if the store can't
+// be read or an index can't start.
+func Index(ctx context.Context, l *libindex.Libindex, s Store) ([]*claircore.IndexReport, error) {
+	imgs, err := s.Images(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = libindex.WithLocalFS(ctx, s.FS())
+	out := make([]*claircore.IndexReport, len(imgs))
+	for i, img := range imgs {
+		zlog.Debug(ctx).
+			Stringer("manifest", img.Manifest.Hash).
+			Strs("names", img.Names).
+			Msg("indexing local image")
+		ir, err := l.Index(ctx, img.Manifest)
+		if err != nil {
+			return nil, fmt.Errorf("imagestore: unable to index %v: %w", img.Manifest.Hash, err)
+		}
+		out[i] = ir
+	}
+	return out, nil
+}
+
+// Media types of manifests and configurations.
+const (
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCIConfig      = "application/vnd.oci.image.config.v1+json"
+	mediaTypeDockerConfig   = "application/vnd.docker.container.image.v1+json"
+)
+
+// MaxManifestSize bounds the size of a manifest or configuration.
+const maxManifestSize = 4 << 20
+
+// Descriptor is an OCI content descriptor.
+type descriptor struct {
+	MediaType string   `json:"mediaType"`
+	Digest    string   `json:"digest"`
+	Size      int64    `json:"size"`
+	URLs      []string `json:"urls,omitempty"`
+}
+
+// ImageManifest is an OCI image manifest, or its Docker equivalent.
+type imageManifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        *descriptor  `json:"config"`
+	Layers        []descriptor `json:"layers"`
+}
+
+// IsImage reports whether the manifest describes a container image, as
+// opposed to an image index or another kind of artifact, like an
+// attestation.
+func (m *imageManifest) isImage() bool {
+	switch m.MediaType {
+	case mediaTypeOCIManifest, mediaTypeDockerManifest:
+	case "":
+		// An OCI manifest may leave out its media type.
+		if m.SchemaVersion != 2 {
+			return false
+		}
+	default:
+		return false
+	}
+	if m.Config == nil || m.Layers == nil {
+		return false
+	}
+	switch m.Config.MediaType {
+	case mediaTypeOCIConfig, mediaTypeDockerConfig:
+	default:
+		return false
+	}
+	for _, l := range m.Layers {
+		if !strings.Contains(l.MediaType, ".layer.") && !strings.Contains(l.MediaType, ".rootfs.") {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadVerified reads the named file from "fsys", checking its contents
+// against the digest.
+func readVerified(fsys fs.FS, name string, d claircore.Digest) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxManifestSize {
+		return nil, fmt.Errorf("imagestore: %s: larger than %d bytes", name, maxManifestSize)
+	}
+	h := d.Hash()
+	h.Write(b)
+	got, err := claircore.NewDigest(d.Algorithm(), h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	if got.String() != d.String() {
+		return nil, fmt.Errorf("imagestore: %s: %w: got %v, want %v", name, libindex.ErrDigestMismatch, got, d)
+	}
+	return b, nil
+}
+
+// ReadConfig reads and decodes the image configuration at "name".
+func readConfig(fsys fs.FS, name string, d claircore.Digest) (*claircore.ImageConfig, error) {
+	b, err := readVerified(fsys, name, d)
+	if err != nil {
+		return nil, err
+	}
+	var cfg claircore.ImageConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("imagestore: unable to decode config %v: %w", d, err)
+	}
+	return &cfg, nil
+}
+
+// ErrIncomplete is reported for images whose contents aren't all in the
+// store, like the images for other platforms in an image index.
+var errIncomplete = errors.New("imagestore: image incomplete")
@@ -0,0 +1,165 @@
+package imagestore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Containers/storage unpacks layers into directories, keeping the parts of
+// the original tar stream that aren't file contents in a "tar-split" file
+// alongside. Putting the two back together recreates the layer's
+// uncompressed tar exactly, so it can be checked against its digest.
+//
+// A tar-split file is a gzipped stream of JSON objects, one per line, each
+// either a segment of the tar stream to copy verbatim, such as a header and
+// the padding before it, or a file whose contents are to be read from the
+// directory.
+
+// Types of tarSplitEntry.
+const (
+	tarSplitFile    = 1
+	tarSplitSegment = 2
+)
+
+// TarSplitEntry is an entry in a tar-split file.
+type tarSplitEntry struct {
+	Type    int    `json:"type"`
+	Name    string `json:"name,omitempty"`
+	NameRaw []byte `json:"name_raw,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	// Payload is the segment's contents for tarSplitSegment entries, and a
+	// checksum of the file's contents for tarSplitFile entries.
+	Payload []byte `json:"payload"`
+}
+
+// TarSplitReader reads the tar stream described by a tar-split file, with
+// file contents from a directory.
+type tarSplitReader struct {
+	dir  string
+	z    *gzip.Reader
+	dec  *json.Decoder
+	tf   *os.File
+	cur  io.Reader
+	file *os.File
+	err  error
+}
+
+// NewTarSplitReader returns a tarSplitReader for the tar-split file
+// "name" and the directory "dir".
+func newTarSplitReader(name, dir string) (*tarSplitReader, error) {
+	tf, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	z, err := gzip.NewReader(bufio.NewReader(tf))
+	if err != nil {
+		tf.Close()
+		return nil, fmt.Errorf("imagestore: %s: %w", name, err)
+	}
+	return &tarSplitReader{
+		dir: dir,
+		z:   z,
+		dec: json.NewDecoder(z),
+		tf:  tf,
+	}, nil
+}
+
+// Read implements io.Reader.
+func (r *tarSplitReader) Read(p []byte) (int, error) {
+	for r.err == nil {
+		if r.cur != nil {
+			n, err := r.cur.Read(p)
+			if errors.Is(err, io.EOF) {
+				r.closeFile()
+				r.cur = nil
+				err = nil
+			}
+			if n != 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		r.err = r.next()
+	}
+	return 0, r.err
+}
+
+// Next sets up the reader for the next entry.
+func (r *tarSplitReader) next() error {
+	var e tarSplitEntry
+	if err := r.dec.Decode(&e); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return fmt.Errorf("imagestore: bad tar-split entry: %w", err)
+	}
+	switch e.Type {
+	case tarSplitSegment:
+		r.cur = bytes.NewReader(e.Payload)
+	case tarSplitFile:
+		if e.Size == 0 {
+			return nil
+		}
+		name := e.Name
+		if e.NameRaw != nil {
+			name = string(e.NameRaw)
+		}
+		f, err := os.Open(filepath.Join(r.dir, filepath.FromSlash(path.Clean("/"+name))))
+		if err != nil {
+			return fmt.Errorf("imagestore: layer file %q: %w", name, err)
+		}
+		r.file = f
+		r.cur = &exactReader{r: f, n: e.Size, name: name}
+	default:
+		return fmt.Errorf("imagestore: unknown tar-split entry type %d", e.Type)
+	}
+	return nil
+}
+
+func (r *tarSplitReader) closeFile() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+// Close implements io.Closer.
+func (r *tarSplitReader) Close() error {
+	r.closeFile()
+	r.z.Close()
+	return r.tf.Close()
+}
+
+// ExactReader reads exactly "n" bytes from "r", reporting an error if it
+// comes up short.
+type exactReader struct {
+	r    io.Reader
+	n    int64
+	name string
+}
+
+func (r *exactReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.r.Read(p)
+	r.n -= int64(n)
+	if errors.Is(err, io.EOF) {
+		if r.n > 0 {
+			return n, fmt.Errorf("imagestore: layer file %q: %w", r.name, io.ErrUnexpectedEOF)
+		}
+		err = nil
+	}
+	return n, err
+}
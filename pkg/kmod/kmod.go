@@ -0,0 +1,150 @@
+// Package kmod recognizes packages of out-of-tree kernel modules and splits
+// the kernel they're built for out of their names and versions.
+//
+// Driver packages are built against a specific kernel, and most packaging
+// schemes record that kernel somewhere in the package's NVR:
+//
+//   - NVIDIA's precompiled packages put the driver version and the kernel in
+//     the name: "kmod-nvidia-535.104.05-5.14.0-284.30.1".
+//   - akmods, as used by RPM Fusion, build packages named for the kernel
+//     release: "kmod-nvidia-5.14.0-284.30.1.el9_2.x86_64".
+//   - Intel's out-of-tree drivers append the kernel to the upstream version
+//     with underscores: "kmod-ice" at "1.9.11_5.14.0_70.13.1-1".
+//
+// Other module packages, such as ELRepo's "kmod-wireguard" or DKMS packages
+// like "kmod-nvidia-latest-dkms" and "wireguard-dkms", carry no kernel, but
+// are still recognized.
+//
+// Advisories for these drivers are keyed on the driver alone, so the kernel
+// portion has to be removed before names or versions can be compared. The
+// package scanner calls Annotate to record the split in the Package's
+// KernelModule when indexing, and the matchers compare the versions returned
+// by MatchVersion instead of the Package's Version.
+package kmod
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/quay/claircore"
+)
+
+// Kernel matches a kernel release, with optional dist tag and architecture:
+// "5.14.0-284.30.1" or "5.14.0-284.30.1.el9_2.x86_64".
+const kernel = `\d+\.\d+\.\d+-\d+(?:\.\d+)*(?:\.[a-z][0-9a-z_]*)*`
+
+var (
+	// NVIDIA precompiled: kmod-<driver>-<driver version>-<kernel>.
+	nameDriverKernel = regexp.MustCompile(`^(kmod-.+?)-\d+(?:\.\d+)+-(` + kernel + `)$`)
+	// Akmods: kmod-<driver>-<kernel>.
+	nameKernel = regexp.MustCompile(`^(kmod-.+?)-(` + kernel + `)$`)
+	// Intel: <driver version>_<kernel, with "_" for "-">.
+	versionKernel = regexp.MustCompile(`^([^_]+)_(\d+\.\d+\.\d+_\d+(?:\.\d+)*(?:\.[a-z][0-9a-z_]*)*)$`)
+)
+
+// Parse reports whether the package with the provided name and rpm EVR is a
+// kernel module package, and if so splits the kernel out of it.
+//
+// An empty version is allowed, in which case only the name is split.
+func Parse(name, version string) (claircore.KernelModule, bool) {
+	if !isModule(name) {
+		return claircore.KernelModule{}, false
+	}
+	m := claircore.KernelModule{Name: name, Version: version}
+	if ms := nameDriverKernel.FindStringSubmatch(name); ms != nil {
+		m.Name, m.Kernel = ms[1], ms[2]
+	} else if ms := nameKernel.FindStringSubmatch(name); ms != nil {
+		m.Name, m.Kernel = ms[1], ms[2]
+	}
+	if m.Kernel != "" || version == "" {
+		return m, true
+	}
+	epoch, ver, rel := splitEVR(version)
+	if ms := versionKernel.FindStringSubmatch(ver); ms != nil {
+		m.Kernel = strings.Replace(ms[2], "_", "-", 1)
+		m.Version = joinEVR(epoch, ms[1], rel)
+	}
+	return m, true
+}
+
+// IsModule reports whether the name follows one of the naming schemes for
+// kernel module packages.
+func isModule(name string) bool {
+	switch name {
+	case "kmod-libs", "kmod-devel":
+		// Subpackages of kmod, the module tools.
+		return false
+	}
+	switch {
+	case strings.HasPrefix(name, "kmod-"),
+		strings.HasPrefix(name, "akmod-"),
+		strings.HasSuffix(name, "-kmod"),
+		strings.HasSuffix(name, "-dkms"):
+		return true
+	}
+	return false
+}
+
+// Annotate sets the Package's KernelModule if it's a kernel module package.
+func Annotate(p *claircore.Package) {
+	if m, ok := Parse(p.Name, p.Version); ok {
+		p.KernelModule = &m
+	}
+}
+
+// BaseName returns the name of the package with any kernel or driver version
+// removed, and reports whether it differs from the package's name.
+//
+// This is the name advisories for the driver are expected to use.
+func BaseName(p *claircore.Package) (string, bool) {
+	m := p.KernelModule
+	if m == nil || m.Name == p.Name {
+		return p.Name, false
+	}
+	return m.Name, true
+}
+
+// MatchVersion returns the version of the package to compare against
+// "target", a version from an advisory.
+//
+// If the package is a kernel module whose version records a kernel and the
+// target doesn't, the advisory is about the driver, so the driver's version is
+// returned. Otherwise, the package's version is returned unchanged.
+func MatchVersion(p *claircore.Package, target string) string {
+	m := p.KernelModule
+	if m == nil || m.Version == p.Version {
+		return p.Version
+	}
+	if _, v, _ := splitEVR(target); versionKernel.MatchString(v) {
+		return p.Version
+	}
+	return m.Version
+}
+
+// SplitEVR splits an rpm EVR into its parts. The epoch and release may be
+// empty.
+func splitEVR(evr string) (epoch, version, release string) {
+	if i := strings.IndexByte(evr, ':'); i != -1 {
+		epoch, evr = evr[:i], evr[i+1:]
+	}
+	version = evr
+	if i := strings.LastIndexByte(evr, '-'); i != -1 {
+		version, release = evr[:i], evr[i+1:]
+	}
+	return epoch, version, release
+}
+
+// JoinEVR is the inverse of splitEVR.
+func joinEVR(epoch, version, release string) string {
+	var b strings.Builder
+	if epoch != "" {
+		b.WriteString(epoch)
+		b.WriteByte(':')
+	}
+	b.WriteString(version)
+	if release != "" {
+		b.WriteByte('-')
+		b.WriteString(release)
+	}
+	return b.String()
+}
@@ -0,0 +1,149 @@
+package kmod
+
+import (
+	"testing"
+
+	"github.com/quay/claircore"
+)
+
+func TestParse(t *testing.T) {
+	tt := []struct {
+		name, version string
+		ok            bool
+		want          claircore.KernelModule
+	}{
+		// NVIDIA precompiled drivers.
+		{
+			name: "kmod-nvidia-535.104.05-5.14.0-284.30.1", version: "3:535.104.05-3.el9_2", ok: true,
+			want: claircore.KernelModule{Name: "kmod-nvidia", Version: "3:535.104.05-3.el9_2", Kernel: "5.14.0-284.30.1"},
+		},
+		{
+			name: "kmod-nvidia-525.125.06-4.18.0-477.10.1", version: "3:525.125.06-1.el8_8", ok: true,
+			want: claircore.KernelModule{Name: "kmod-nvidia", Version: "3:525.125.06-1.el8_8", Kernel: "4.18.0-477.10.1"},
+		},
+		// NVIDIA's DKMS packages.
+		{
+			name: "kmod-nvidia-latest-dkms", version: "3:535.104.05-1.el9", ok: true,
+			want: claircore.KernelModule{Name: "kmod-nvidia-latest-dkms", Version: "3:535.104.05-1.el9"},
+		},
+		{
+			name: "kmod-nvidia-open-dkms", version: "3:535.104.05-1.el9", ok: true,
+			want: claircore.KernelModule{Name: "kmod-nvidia-open-dkms", Version: "3:535.104.05-1.el9"},
+		},
+		// RPM Fusion akmods.
+		{
+			name: "kmod-nvidia-6.4.15-200.fc38.x86_64", version: "3:535.104.05-1.fc38", ok: true,
+			want: claircore.KernelModule{Name: "kmod-nvidia", Version: "3:535.104.05-1.fc38", Kernel: "6.4.15-200.fc38.x86_64"},
+		},
+		{
+			name: "akmod-nvidia", version: "3:535.104.05-1.fc38", ok: true,
+			want: claircore.KernelModule{Name: "akmod-nvidia", Version: "3:535.104.05-1.fc38"},
+		},
+		// WireGuard, from ELRepo and EPEL.
+		{
+			name: "kmod-wireguard", version: "1.0.20220627-4.el8_7.elrepo", ok: true,
+			want: claircore.KernelModule{Name: "kmod-wireguard", Version: "1.0.20220627-4.el8_7.elrepo"},
+		},
+		{
+			name: "kmod-wireguard-5.14.0-162.6.1.el9_1.x86_64", version: "1.0.20220627-1.el9", ok: true,
+			want: claircore.KernelModule{Name: "kmod-wireguard", Version: "1.0.20220627-1.el9", Kernel: "5.14.0-162.6.1.el9_1.x86_64"},
+		},
+		{
+			name: "wireguard-dkms", version: "1:1.0.20210606-1.el8", ok: true,
+			want: claircore.KernelModule{Name: "wireguard-dkms", Version: "1:1.0.20210606-1.el8"},
+		},
+		// Intel out-of-tree drivers.
+		{
+			name: "kmod-ice", version: "1.9.11_5.14.0_70.13.1-1", ok: true,
+			want: claircore.KernelModule{Name: "kmod-ice", Version: "1.9.11-1", Kernel: "5.14.0-70.13.1"},
+		},
+		{
+			name: "kmod-i40e", version: "2.22.18_4.18.0_425.3.1-1", ok: true,
+			want: claircore.KernelModule{Name: "kmod-i40e", Version: "2.22.18-1", Kernel: "4.18.0-425.3.1"},
+		},
+		// Name only.
+		{
+			name: "kmod-nvidia-535.104.05-5.14.0-284.30.1", ok: true,
+			want: claircore.KernelModule{Name: "kmod-nvidia", Kernel: "5.14.0-284.30.1"},
+		},
+		// Not modules.
+		{name: "kmod", version: "28-7.el9"},
+		{name: "kmod-libs", version: "28-7.el9"},
+		{name: "kernel-modules", version: "5.14.0-284.30.1.el9_2"},
+		{name: "nvidia-driver", version: "3:535.104.05-1.el9"},
+		{name: "wireguard-tools", version: "1.0.20210914-2.el9"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := Parse(tc.name, tc.version)
+			if ok != tc.ok {
+				t.Fatalf("ok: got: %v, want: %v", ok, tc.ok)
+			}
+			if got != tc.want {
+				t.Errorf("got: %+v, want: %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	p := &claircore.Package{Name: "kmod-ice", Version: "1.9.11_5.14.0_70.13.1-1"}
+	Annotate(p)
+	want := &claircore.KernelModule{Name: "kmod-ice", Version: "1.9.11-1", Kernel: "5.14.0-70.13.1"}
+	if got := p.KernelModule; got == nil || *got != *want {
+		t.Errorf("got: %+v, want: %+v", got, want)
+	}
+
+	p = &claircore.Package{Name: "kmod-libs", Version: "28-7.el9"}
+	Annotate(p)
+	if got := p.KernelModule; got != nil {
+		t.Errorf("got: %+v, want: <nil>", got)
+	}
+}
+
+func TestBaseName(t *testing.T) {
+	tt := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"kmod-nvidia-535.104.05-5.14.0-284.30.1", "kmod-nvidia", true},
+		{"kmod-wireguard-5.14.0-162.6.1.el9_1.x86_64", "kmod-wireguard", true},
+		{"kmod-wireguard", "kmod-wireguard", false},
+		{"openssl", "openssl", false},
+	}
+	for _, tc := range tt {
+		p := &claircore.Package{Name: tc.in}
+		Annotate(p)
+		got, ok := BaseName(p)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%s: got: (%q, %v), want: (%q, %v)", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+	// Without the split recorded at index time, the name is used as-is.
+	if got, ok := BaseName(&claircore.Package{Name: tt[0].in}); got != tt[0].in || ok {
+		t.Errorf("unannotated: got: (%q, %v)", got, ok)
+	}
+}
+
+func TestMatchVersion(t *testing.T) {
+	tt := []struct {
+		name, version, target, want string
+	}{
+		// Advisory for the driver.
+		{"kmod-ice", "1.9.11_5.14.0_70.13.1-1", "1.10.1.1-1", "1.9.11-1"},
+		{"kmod-ice", "1:1.9.11_5.14.0_70.13.1-1", "1:1.9.12", "1:1.9.11-1"},
+		// Advisory for a specific build.
+		{"kmod-ice", "1.9.11_5.14.0_70.13.1-1", "1.9.11_5.14.0_70.17.1-1", "1.9.11_5.14.0_70.13.1-1"},
+		// Nothing to remove.
+		{"kmod-nvidia-535.104.05-5.14.0-284.30.1", "3:535.104.05-3.el9_2", "3:535.129.03-1.el9", "3:535.104.05-3.el9_2"},
+		{"wireguard-dkms", "1:1.0.20210606-1.el8", "1:1.0.20210914-1.el8", "1:1.0.20210606-1.el8"},
+		{"openssl", "1:3.0.7-6.el9_2", "1:3.0.7-16.el9_2", "1:3.0.7-6.el9_2"},
+	}
+	for _, tc := range tt {
+		p := &claircore.Package{Name: tc.name, Version: tc.version}
+		Annotate(p)
+		if got := MatchVersion(p, tc.target); got != tc.want {
+			t.Errorf("%s %s vs %s: got: %q, want: %q", tc.name, tc.version, tc.target, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Package pkgname normalizes package names according to the rules of the
+// ecosystem they come from.
+//
+// Ecosystems are identified by the Repository name both indexers and updaters
+// use for them: "pypi", "npm", and "maven". Names from other ecosystems are
+// returned unchanged.
+package pkgname
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Known ecosystems.
+const (
+	PyPI  = "pypi"
+	NPM   = "npm"
+	Maven = "maven"
+)
+
+// Supported reports whether Normalize does anything for the ecosystem.
+func Supported(ecosystem string) bool {
+	switch ecosystem {
+	case PyPI, NPM, Maven:
+		return true
+	}
+	return false
+}
+
+// Normalize returns the normalized form of the package name for the
+// ecosystem.
+//
+//   - PyPI names are normalized per PEP 503: lowercased, with runs of "-",
+//     "_", and "." replaced by a single "-".
+//   - npm names are lowercased. A scope, if present, is kept.
+//   - Maven names are expected in "group:artifact" form; a "group/artifact"
+//     name is rewritten to that form. Maven coordinates are case sensitive,
+//     so case is preserved.
+//
+// Surrounding whitespace is removed for all supported ecosystems.
+func Normalize(ecosystem, name string) string {
+	switch ecosystem {
+	case PyPI:
+		return strings.ToLower(pep503.ReplaceAllLiteralString(strings.TrimSpace(name), "-"))
+	case NPM:
+		return strings.ToLower(strings.TrimSpace(name))
+	case Maven:
+		name = strings.TrimSpace(name)
+		if !strings.Contains(name, ":") {
+			if i := strings.LastIndexByte(name, '/'); i != -1 {
+				name = name[:i] + ":" + name[i+1:]
+			}
+		}
+		return name
+	}
+	return name
+}
+
+var pep503 = regexp.MustCompile(`[-_.]+`)
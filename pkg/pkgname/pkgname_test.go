@@ -0,0 +1,28 @@
+package pkgname
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tt := []struct {
+		ecosystem, in, want string
+	}{
+		{PyPI, "requests", "requests"},
+		{PyPI, "Flask_SQLAlchemy", "flask-sqlalchemy"},
+		{PyPI, "zope.interface", "zope-interface"},
+		{PyPI, "some__odd-._name", "some-odd-name"},
+		{PyPI, " PyYAML ", "pyyaml"},
+		{NPM, "Lodash", "lodash"},
+		{NPM, "@Babel/Core", "@babel/core"},
+		{NPM, "left_pad", "left_pad"},
+		{Maven, "org.apache.logging.log4j:log4j-core", "org.apache.logging.log4j:log4j-core"},
+		{Maven, "org.apache.logging.log4j/log4j-core", "org.apache.logging.log4j:log4j-core"},
+		{Maven, "com.Example:Thing", "com.Example:Thing"},
+		{"", "Flask_SQLAlchemy", "Flask_SQLAlchemy"},
+		{"rhel", "Python3_Libs", "Python3_Libs"},
+	}
+	for _, tc := range tt {
+		if got := Normalize(tc.ecosystem, tc.in); got != tc.want {
+			t.Errorf("%s %q: got: %q, want: %q", tc.ecosystem, tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Package redislock provides a locking mechanism based on context
+// cancellation, backed by Redis.
+//
+// Locks are Redis keys holding a random token, set with an expiry that's
+// extended for as long as the lock is held. Contexts derived from a Locker are
+// canceled when the lock can't be extended, such as when Redis is unreachable
+// for longer than the expiry, or when a parent context is canceled.
+//
+// This is a single-instance algorithm: it's only as available as the Redis
+// server (or primary) holding the keys.
+package redislock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/quay/zlog"
+)
+
+// Client is the part of a Redis client a Locker needs: running a Lua script.
+//
+// The scripts used only return integers, which should be reported as int64.
+// With github.com/go-redis/redis, a Client can be written as:
+//
+//	func (c client) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+//		return c.Client.Eval(ctx, script, keys, args...).Result()
+//	}
+type Client interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// These are the scripts run to manage a lock. KEYS[1] is the lock's key,
+// ARGV[1] the holder's token, and ARGV[2] the expiry in milliseconds.
+const (
+	acquireScript = `if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then return 1 end return 0`
+	extendScript  = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+	releaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+)
+
+// DefaultTTL is the expiry used for locks if New is passed zero.
+const DefaultTTL = 30 * time.Second
+
+// Prefix is prepended to the names of keys used for locks.
+const Prefix = "claircore:lock:"
+
+// These are some error values used throughout.
+var (
+	errExiting    = errors.New("redislock: exiting")
+	errLockFail   = errors.New("redislock: lock acquisition failed")
+	errDoubleLock = errors.New("redislock: lock already held")
+)
+
+// Locker provides context-scoped locks.
+type Locker struct {
+	c   Client
+	ttl time.Duration
+
+	mu sync.Mutex
+	// Cur tracks current, outstanding locks, keyed by name.
+	cur map[string]*lock
+	// Closed is set once Close has been called.
+	closed bool
+}
+
+// New creates a Locker using the provided client. Locks expire in Redis after
+// "ttl" if not extended; if zero, DefaultTTL is used.
+//
+// Close must be called to release held locks.
+func New(c Client, ttl time.Duration) *Locker {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	return &Locker{
+		c:   c,
+		ttl: ttl,
+		cur: make(map[string]*lock),
+	}
+}
+
+// Lock is a held lock.
+type lock struct {
+	key, token string
+	// Cancel cancels the Context returned for the lock.
+	cancel context.CancelFunc
+	// Release is closed to ask for the lock to be released.
+	release chan struct{}
+	once    sync.Once
+	// Done is closed once the lock has been released.
+	done chan struct{}
+}
+
+// TryLock attempts to lock on the provided key.
+//
+// If unsuccessful, an already-cancelled Context will be returned.
+//
+// If successful, the returned Context will be parented to the passed-in Context
+// and canceled if the lock is lost.
+func (l *Locker) TryLock(parent context.Context, key string) (context.Context, context.CancelFunc) {
+	child, done := context.WithCancel(parent)
+	lk, err := l.try(parent, key, done)
+	if err != nil {
+		zlog.Debug(parent).
+			Err(err).
+			Str("key", key).
+			Msg("lock failed")
+		done()
+		return child, done
+	}
+	return child, lk.unlock
+}
+
+// Lock attempts to obtain the named lock until it succeeds or the passed
+// Context is cancelled.
+func (l *Locker) Lock(parent context.Context, key string) (context.Context, context.CancelFunc) {
+	child, done := context.WithCancel(parent)
+	for wait := time.Duration(100 * time.Millisecond); ; backoff(&wait) {
+		lk, err := l.try(parent, key, done)
+		switch {
+		case errors.Is(err, nil):
+			return child, lk.unlock
+		case errors.Is(err, errExiting):
+			done()
+			return child, done
+		case errors.Is(err, errLockFail) || errors.Is(err, errDoubleLock):
+		default:
+			zlog.Info(parent).
+				Err(err).
+				Str("key", key).
+				Msg("lock failed")
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-parent.Done():
+			t.Stop()
+			done()
+			return child, done
+		case <-t.C:
+		}
+	}
+}
+
+// Close releases all held locks, canceling their Contexts, and causes any
+// further attempts to lock to fail.
+func (l *Locker) Close(_ context.Context) error {
+	l.mu.Lock()
+	l.closed = true
+	held := make([]*lock, 0, len(l.cur))
+	for _, lk := range l.cur {
+		held = append(held, lk)
+	}
+	l.mu.Unlock()
+	for _, lk := range held {
+		lk.unlock()
+	}
+	return nil
+}
+
+// Backoff implements a doubling backoff, capped at 5 seconds.
+func backoff(w *time.Duration) {
+	const max = 5 * time.Second
+	(*w) *= 2
+	if *w > max {
+		*w = max
+	}
+}
+
+// Try attempts to take the lock and reports an error if unsuccessful. If
+// successful, the returned lock is kept alive until "cf" is called or the
+// lock is lost, and calls "cf" when released.
+func (l *Locker) try(ctx context.Context, key string, cf context.CancelFunc) (*lock, error) {
+	// If we waited for the lock and the parent context is gone, return.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	lk := &lock{
+		key:     Prefix + key,
+		token:   hex.EncodeToString(b[:]),
+		cancel:  cf,
+		release: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	// Reserve the key, so the round trip to Redis isn't made under the mutex.
+	// A Close in the meantime waits on the reservation like a held lock.
+	l.mu.Lock()
+	switch {
+	case l.closed:
+		l.mu.Unlock()
+		return nil, errExiting
+	case l.cur[key] != nil:
+		l.mu.Unlock()
+		return nil, errDoubleLock
+	}
+	l.cur[key] = lk
+	l.mu.Unlock()
+
+	acquired := time.Now()
+	ok, err := l.eval(ctx, acquireScript, lk)
+	switch {
+	case err == nil && !ok:
+		err = errLockFail
+	case err == nil:
+		// Hold releases the lock if it was asked to during the acquisition.
+		go l.hold(ctx, key, lk, acquired)
+		return lk, nil
+	}
+	l.mu.Lock()
+	delete(l.cur, key)
+	l.mu.Unlock()
+	close(lk.done)
+	return nil, err
+}
+
+// Unlock cancels the lock's Context and waits for it to be released.
+func (lk *lock) unlock() {
+	lk.cancel()
+	lk.once.Do(func() { close(lk.release) })
+	<-lk.done
+}
+
+// Hold extends the lock until it's unlocked, the parent Context is canceled,
+// or the lock can't be extended, then releases it.
+func (l *Locker) hold(ctx context.Context, key string, lk *lock, acquired time.Time) {
+	defer close(lk.done)
+	defer func() {
+		l.mu.Lock()
+		delete(l.cur, key)
+		l.mu.Unlock()
+	}()
+	defer lk.cancel()
+	t := time.NewTicker(l.ttl / 3)
+	defer t.Stop()
+	// Last is when the most recent successful extension was sent; the key
+	// expires no later than a TTL after it. The lock is given up a third of
+	// the TTL before then, so this process stops working under the lock
+	// before another can take it.
+	last := acquired
+	giveUp := func() time.Time { return last.Add(l.ttl - l.ttl/3) }
+Hold:
+	for {
+		select {
+		case <-ctx.Done():
+			break Hold
+		case <-lk.release:
+			break Hold
+		case <-t.C:
+		}
+		sent := time.Now()
+		deadline := sent.Add(l.ttl / 3)
+		if g := giveUp(); g.Before(deadline) {
+			deadline = g
+		}
+		tctx, done := context.WithDeadline(ctx, deadline)
+		ok, err := l.eval(tctx, extendScript, lk)
+		done()
+		switch {
+		case err == nil && ok:
+			last = sent
+		case err == nil:
+			zlog.Warn(ctx).
+				Str("key", key).
+				Msg("lock lost")
+			return
+		case ctx.Err() != nil:
+			break Hold
+		case !time.Now().Before(giveUp()):
+			zlog.Warn(ctx).
+				Err(err).
+				Str("key", key).
+				Msg("unable to extend lock, giving up")
+			return
+		default:
+			zlog.Info(ctx).
+				Err(err).
+				Str("key", key).
+				Msg("unable to extend lock")
+		}
+	}
+
+	// Time-box the release, as the parent context may be gone.
+	rctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+	if _, err := l.eval(rctx, releaseScript, lk); err != nil {
+		zlog.Info(ctx).
+			Err(err).
+			Str("key", key).
+			Msg("unable to release lock, waiting on expiry")
+	}
+}
+
+// Eval runs one of the scripts for the lock, reporting whether it returned a
+// non-zero integer.
+func (l *Locker) eval(ctx context.Context, script string, lk *lock) (bool, error) {
+	res, err := l.c.Eval(ctx, script, []string{lk.key}, lk.token, l.ttl.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	n, ok := res.(int64)
+	if !ok {
+		return false, fmt.Errorf("redislock: unexpected script result: %T", res)
+	}
+	return n != 0, nil
+}
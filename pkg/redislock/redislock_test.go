@@ -0,0 +1,252 @@
+package redislock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quay/zlog"
+)
+
+// FakeRedis runs the Locker's scripts against an in-memory map.
+type fakeRedis struct {
+	sync.Mutex
+	m    map[string]entry
+	fail bool
+	// Delay, if set, is called with the key before every script is run.
+	delay func(key string)
+}
+
+type entry struct {
+	token  string
+	expiry time.Time
+}
+
+func newFake() *fakeRedis {
+	return &fakeRedis{m: make(map[string]entry)}
+}
+
+func (f *fakeRedis) Eval(_ context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	if f.delay != nil {
+		f.delay(keys[0])
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.fail {
+		return nil, errors.New("connection refused")
+	}
+	k, tok := keys[0], args[0].(string)
+	ttl := time.Duration(args[1].(int64)) * time.Millisecond
+	e, ok := f.m[k]
+	if ok && time.Now().After(e.expiry) {
+		delete(f.m, k)
+		ok = false
+	}
+	switch script {
+	case acquireScript:
+		if ok {
+			return int64(0), nil
+		}
+		f.m[k] = entry{token: tok, expiry: time.Now().Add(ttl)}
+	case extendScript:
+		if !ok || e.token != tok {
+			return int64(0), nil
+		}
+		f.m[k] = entry{token: tok, expiry: time.Now().Add(ttl)}
+	case releaseScript:
+		if !ok || e.token != tok {
+			return int64(0), nil
+		}
+		delete(f.m, k)
+	default:
+		panic("unknown script")
+	}
+	return int64(1), nil
+}
+
+// Steal replaces the holder of the key.
+func (f *fakeRedis) steal(key string) {
+	f.Lock()
+	defer f.Unlock()
+	f.m[Prefix+key] = entry{token: "thief", expiry: time.Now().Add(time.Hour)}
+}
+
+func (f *fakeRedis) held() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.m)
+}
+
+func TestContested(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	f := newFake()
+	a, b := New(f, time.Second), New(f, time.Second)
+	defer a.Close(ctx)
+	defer b.Close(ctx)
+
+	lc, done := a.TryLock(ctx, "key")
+	if err := lc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if lc, _ := b.TryLock(ctx, "key"); lc.Err() == nil {
+		t.Fatal("lock acquired twice")
+	}
+	if lc, _ := a.TryLock(ctx, "key"); lc.Err() == nil {
+		t.Fatal("lock acquired twice by the same Locker")
+	}
+
+	got := make(chan error)
+	go func() {
+		lc, done := b.Lock(ctx, "key")
+		err := lc.Err()
+		done()
+		got <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	done()
+	if lc.Err() == nil {
+		t.Error("context not canceled on unlock")
+	}
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for lock")
+	}
+	if n := f.held(); n != 0 {
+		t.Errorf("keys left in redis: %d", n)
+	}
+}
+
+func TestLockCanceled(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	f := newFake()
+	l := New(f, time.Second)
+	defer l.Close(ctx)
+	_, done := l.TryLock(ctx, "key")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	lc, unlock := l.Lock(ctx, "key")
+	defer unlock()
+	if lc.Err() == nil {
+		t.Error("lock acquired twice")
+	}
+}
+
+func TestLost(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	t.Run("Stolen", func(t *testing.T) {
+		f := newFake()
+		l := New(f, 30*time.Millisecond)
+		defer l.Close(ctx)
+		lc, done := l.TryLock(ctx, "key")
+		defer done()
+		if err := lc.Err(); err != nil {
+			t.Fatal(err)
+		}
+		f.steal("key")
+		select {
+		case <-lc.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("context not canceled")
+		}
+	})
+	t.Run("Unreachable", func(t *testing.T) {
+		f := newFake()
+		l := New(f, 90*time.Millisecond)
+		defer l.Close(ctx)
+		lc, done := l.TryLock(ctx, "key")
+		defer done()
+		if err := lc.Err(); err != nil {
+			t.Fatal(err)
+		}
+		f.Lock()
+		f.fail = true
+		f.Unlock()
+		select {
+		case <-lc.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("context not canceled")
+		}
+		// The lock must be given up while the key is still held, so that
+		// no other process can have taken it yet.
+		f.Lock()
+		exp := f.m[Prefix+"key"].expiry
+		f.Unlock()
+		if now := time.Now(); !now.Before(exp) {
+			t.Errorf("context canceled %v after the key expired", now.Sub(exp))
+		}
+	})
+}
+
+func TestClose(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	f := newFake()
+	l := New(f, time.Second)
+	lc, done := l.TryLock(ctx, "key")
+	defer done()
+	if err := lc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if lc.Err() == nil {
+		t.Error("context not canceled on Close")
+	}
+	if n := f.held(); n != 0 {
+		t.Errorf("keys left in redis: %d", n)
+	}
+	if lc, _ := l.Lock(ctx, "other"); lc.Err() == nil {
+		t.Error("lock acquired after Close")
+	}
+}
+
+// TestSlowAcquire confirms a slow round trip for one key doesn't hold up
+// locking others.
+func TestSlowAcquire(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	f := newFake()
+	slow := make(chan struct{})
+	f.delay = func(key string) {
+		if key == Prefix+"slow" {
+			<-slow
+		}
+	}
+	l := New(f, time.Second)
+	defer l.Close(ctx)
+
+	got := make(chan error)
+	go func() {
+		lc, done := l.TryLock(ctx, "slow")
+		err := lc.Err()
+		done()
+		got <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	fast := make(chan error, 1)
+	go func() {
+		lc, done := l.TryLock(ctx, "fast")
+		err := lc.Err()
+		done()
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("lock on another key held up")
+	}
+	close(slow)
+	if err := <-got; err != nil {
+		t.Error(err)
+	}
+}
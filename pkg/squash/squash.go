@@ -0,0 +1,288 @@
+// Package squash implements a merged view of a container image's layers,
+// applying the whiteouts described by the OCI image spec.
+package squash
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/pkg/tarfs"
+)
+
+// Whiteout prefixes, as described by the OCI image spec.
+const (
+	whPrefix = `.wh.`
+	whOpaque = `.wh..wh..opq`
+)
+
+// Status is the result of looking up a path in an FS.
+type Status int
+
+// These are the Statuses Lookup reports.
+const (
+	// Missing means no layer contains the path.
+	Missing Status = iota
+	// Present means the path exists in the merged view.
+	Present
+	// Removed means a layer removed the path, or a parent directory, with
+	// a whiteout.
+	Removed
+)
+
+// FS is the merged view of a stack of layer filesystems, lowest first.
+//
+// A path is present if the topmost layer with an opinion about it contains
+// it. A layer has an opinion about a path if it contains it, whites it out
+// or a parent directory out, or marks a parent directory opaque. Whiteout
+// entries themselves aren't visible.
+//
+// FS implements fs.FS, fs.StatFS, and fs.ReadDirFS.
+type FS struct {
+	layers []fs.FS
+	rc     []io.Closer
+}
+
+var (
+	_ fs.FS        = (*FS)(nil)
+	_ fs.StatFS    = (*FS)(nil)
+	_ fs.ReadDirFS = (*FS)(nil)
+)
+
+// New returns an FS over the provided layer filesystems, lowest first.
+func New(layers ...fs.FS) *FS {
+	return &FS{layers: layers}
+}
+
+// Open returns an FS over the provided Layers, which must be fetched and in
+// image order. The returned FS must be closed to release the layers' readers.
+func Open(ls []*claircore.Layer) (*FS, error) {
+	sq := FS{layers: make([]fs.FS, 0, len(ls))}
+	for _, l := range ls {
+		r, err := l.Reader()
+		if err != nil {
+			sq.Close()
+			return nil, fmt.Errorf("squash: layer %v: %w", l.Hash, err)
+		}
+		sq.rc = append(sq.rc, r)
+		sys, err := tarfs.New(r)
+		if err != nil {
+			sq.Close()
+			return nil, fmt.Errorf("squash: layer %v: %w", l.Hash, err)
+		}
+		sq.layers = append(sq.layers, sys)
+	}
+	return &sq, nil
+}
+
+// Close releases the layer readers held by an FS returned from Open. It's a
+// no-op for an FS returned from New.
+func (f *FS) Close() error {
+	var errs []error
+	for _, c := range f.rc {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	f.rc = nil
+	if len(errs) != 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Layers reports the number of layers.
+func (f *FS) Layers() int {
+	return len(f.layers)
+}
+
+// Layer returns the filesystem of the layer at index "i".
+func (f *FS) Layer(i int) fs.FS {
+	return f.layers[i]
+}
+
+// Lookup finds the topmost layer with an opinion about the path, and what
+// that opinion is. The layer is -1 if the Status is Missing.
+func (f *FS) Lookup(name string) (int, Status) {
+	for i := len(f.layers) - 1; i >= 0; i-- {
+		if st := opinion(f.layers[i], name); st != Missing {
+			return i, st
+		}
+	}
+	return -1, Missing
+}
+
+// Opinion reports what the layer says about the path: Present if it contains
+// it, Removed if it whites it out, and Missing if it says nothing.
+func opinion(sys fs.FS, name string) Status {
+	if exists(sys, name) {
+		return Present
+	}
+	for c := name; c != "." && c != "/"; c = path.Dir(c) {
+		d, b := path.Split(c)
+		if exists(sys, path.Join(d, whPrefix+b)) {
+			return Removed
+		}
+		// An opaque parent hides everything below it from lower layers.
+		if d != "" && exists(sys, path.Join(d, whOpaque)) {
+			return Removed
+		}
+	}
+	return Missing
+}
+
+func exists(sys fs.FS, p string) bool {
+	_, err := fs.Stat(sys, p)
+	return err == nil
+}
+
+// Open implements fs.FS.
+//
+// Directories are opened in the topmost layer containing them; use ReadDir
+// to list their merged contents.
+func (f *FS) Open(name string) (fs.File, error) {
+	i, err := f.find("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.layers[i].Open(name)
+}
+
+// Stat implements fs.StatFS.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	i, err := f.find("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(f.layers[i], name)
+}
+
+// Find returns the layer the path is present in, or an *fs.PathError.
+func (f *FS) find(op, name string) (int, error) {
+	if !fs.ValidPath(name) {
+		return -1, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		if len(f.layers) == 0 {
+			return -1, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		return len(f.layers) - 1, nil
+	}
+	i, st := f.Lookup(name)
+	if st != Present {
+		return -1, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return i, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+//
+// The entries are merged from every layer down to the first that replaces
+// the directory, marks it opaque, or whites it out, with entries in upper
+// layers shadowing ones in lower layers.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	top, err := f.find("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool) // true if the name is visible
+	var out []fs.DirEntry
+	for i := top; i >= 0; i-- {
+		sys := f.layers[i]
+		fi, err := fs.Stat(sys, name)
+		if err != nil {
+			// Lower layers only contribute if this layer doesn't remove
+			// the directory.
+			if name != "." && opinion(sys, name) == Removed {
+				break
+			}
+			continue
+		}
+		if !fi.IsDir() {
+			// Replaced by a non-directory: nothing below shows through.
+			break
+		}
+		ents, err := fs.ReadDir(sys, name)
+		if err != nil {
+			return nil, err
+		}
+		opaque := false
+		for _, e := range ents {
+			n := e.Name()
+			switch {
+			case n == whOpaque:
+				opaque = true
+			case strings.HasPrefix(n, whPrefix):
+				n = strings.TrimPrefix(n, whPrefix)
+				if _, ok := seen[n]; !ok {
+					seen[n] = false
+				}
+			default:
+				if _, ok := seen[n]; !ok {
+					seen[n] = true
+					out = append(out, e)
+				}
+			}
+		}
+		if opaque {
+			break
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+// WriteTar writes the merged view as a tar archive, in lexical order, without
+// any whiteout entries.
+//
+// Entries keep the headers they have in the layer providing them. Hard links
+// are written as-is, so one pointing at a file removed from the merged view
+// dangles.
+func (f *FS) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := fs.WalkDir(f, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == "." {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var h tar.Header
+		if th, ok := fi.Sys().(*tar.Header); ok {
+			h = *th
+		} else {
+			th, err := tar.FileInfoHeader(fi, "")
+			if err != nil {
+				return err
+			}
+			h = *th
+		}
+		h.Name = p
+		if d.IsDir() {
+			h.Name += "/"
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			return nil
+		}
+		rc, err := f.Open(p)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(tw, rc)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("squash: %w", err)
+	}
+	return tw.Close()
+}
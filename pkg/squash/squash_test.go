@@ -0,0 +1,147 @@
+package squash
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func file(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
+func layers() *FS {
+	return New(
+		fstest.MapFS{
+			"etc/os-release":     file("0"),
+			"app/lib/a.jar":      file("0"),
+			"app/lib/b.jar":      file("0"),
+			"opt/old/x":          file("0"),
+			"var/cache/apk/idx":  file("0"),
+			"usr/share/doc/keep": file("0"),
+		},
+		fstest.MapFS{
+			"app/lib/.wh.a.jar":       file(""),
+			"app/lib/c.jar":           file("1"),
+			"opt/old/.wh..wh..opq":    file(""),
+			"opt/old/y":               file("1"),
+			"var/cache/.wh.apk":       file(""),
+			"usr/share/doc/keep":      file("1"),
+			"usr/share/doc/.wh.other": file(""),
+		},
+	)
+}
+
+func TestLookup(t *testing.T) {
+	sq := layers()
+	tt := []struct {
+		name  string
+		layer int
+		st    Status
+	}{
+		{"etc/os-release", 0, Present},
+		{"app/lib/a.jar", 1, Removed},
+		{"app/lib/b.jar", 0, Present},
+		{"app/lib/c.jar", 1, Present},
+		{"opt/old/x", 1, Removed},
+		{"opt/old/y", 1, Present},
+		{"var/cache/apk/idx", 1, Removed},
+		{"var/cache/apk", 1, Removed},
+		{"usr/share/doc/keep", 1, Present},
+		{"nope", -1, Missing},
+	}
+	for _, tc := range tt {
+		i, st := sq.Lookup(tc.name)
+		if i != tc.layer || st != tc.st {
+			t.Errorf("%s: got: (%d, %v), want: (%d, %v)", tc.name, i, st, tc.layer, tc.st)
+		}
+	}
+}
+
+func TestFS(t *testing.T) {
+	sq := layers()
+	b, err := fs.ReadFile(sq, "usr/share/doc/keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "1"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if _, err := fs.ReadFile(sq, "app/lib/a.jar"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got: %v, want: %v", err, fs.ErrNotExist)
+	}
+
+	for dir, want := range map[string]string{
+		"app/lib":   "b.jar c.jar",
+		"opt/old":   "y",
+		"var/cache": "",
+		".":         "app etc opt usr var",
+	} {
+		ents, err := fs.ReadDir(sq, dir)
+		if err != nil {
+			t.Errorf("%s: %v", dir, err)
+			continue
+		}
+		var names []string
+		for _, e := range ents {
+			names = append(names, e.Name())
+		}
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("%s: got: %q, want: %q", dir, got, want)
+		}
+	}
+
+	var walked []string
+	err = fs.WalkDir(sq, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			walked = append(walked, p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "app/lib/b.jar app/lib/c.jar etc/os-release opt/old/y usr/share/doc/keep"
+	if got := strings.Join(walked, " "); got != want {
+		t.Errorf("walk: got: %q, want: %q", got, want)
+	}
+}
+
+func TestWriteTar(t *testing.T) {
+	var buf bytes.Buffer
+	if err := layers().WriteTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	tr := tar.NewReader(&buf)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, h.Name+"="+string(b))
+	}
+	want := strings.Join([]string{
+		"app/=", "app/lib/=", "app/lib/b.jar=0", "app/lib/c.jar=1",
+		"etc/=", "etc/os-release=0",
+		"opt/=", "opt/old/=", "opt/old/y=1",
+		"usr/=", "usr/share/=", "usr/share/doc/=", "usr/share/doc/keep=1",
+		"var/=", "var/cache/=",
+	}, " ")
+	if got := strings.Join(got, " "); got != want {
+		t.Errorf("got: %s\nwant: %s", got, want)
+	}
+}
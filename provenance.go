@@ -0,0 +1,78 @@
+package claircore
+
+import (
+	"sort"
+	"strings"
+)
+
+// Provenance is where in an image a package was found.
+type Provenance struct {
+	// the layer that introduced the package
+	Layer Digest `json:"layer"`
+	// the kind of package database, if the scanner recorded one, such as
+	// "sqlite" or "jar"
+	Kind string `json:"kind,omitempty"`
+	// the path of the package database, or of the file the package was
+	// found in, relative to the root of the layer
+	Path string `json:"path"`
+}
+
+// PackageDBKinds are the prefixes scanners use to note the kind of a package
+// database in Environment.PackageDB.
+var packageDBKinds = []string{
+	"bdb", "sqlite", // rpm
+	"jar", "maven", "file", // java
+	"python",
+}
+
+// ParsePackageDB splits an Environment's PackageDB into the kind of the
+// database, if noted, and its path.
+func parsePackageDB(db string) (kind, path string) {
+	for _, k := range packageDBKinds {
+		if strings.HasPrefix(db, k+":") {
+			return k, db[len(k)+1:]
+		}
+	}
+	return "", db
+}
+
+// Provenance reports every place in the image the package with the provided
+// ID was found, in the order of the Environments recording them. A package
+// installed in several places, or by several layers, has several entries.
+func (report *IndexReport) Provenance(pkgID string) []Provenance {
+	envs := report.Environments[pkgID]
+	out := make([]Provenance, 0, len(envs))
+	for _, env := range envs {
+		if env == nil {
+			continue
+		}
+		out = append(out, env.Provenance())
+	}
+	return out
+}
+
+// Provenance reports where the Environment says its package was found.
+func (env *Environment) Provenance() Provenance {
+	kind, path := parsePackageDB(env.PackageDB)
+	return Provenance{
+		Layer: env.IntroducedIn,
+		Kind:  kind,
+		Path:  path,
+	}
+}
+
+// PackagesIn reports the IDs of the packages introduced by the layer, sorted.
+func (report *IndexReport) PackagesIn(layer Digest) []string {
+	var out []string
+	l := layer.String()
+	for id, envs := range report.Environments {
+		for _, env := range envs {
+			if env != nil && env.IntroducedIn.String() == l {
+				out = append(out, id)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
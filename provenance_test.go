@@ -0,0 +1,49 @@
+package claircore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProvenance(t *testing.T) {
+	a := MustParseDigest("sha256:" + strings.Repeat("a", 64))
+	b := MustParseDigest("sha256:" + strings.Repeat("b", 64))
+	r := IndexReport{
+		Environments: map[string][]*Environment{
+			"1": {{PackageDB: "var/lib/dpkg/status", IntroducedIn: a}},
+			"2": {
+				{PackageDB: "jar:opt/app/lib/x.jar", IntroducedIn: a},
+				{PackageDB: "sqlite:var/lib/rpm", IntroducedIn: b},
+			},
+		},
+	}
+
+	// Digests have unexported fields.
+	opt := cmp.Comparer(func(a, b Digest) bool { return a.String() == b.String() })
+
+	got := r.Provenance("2")
+	want := []Provenance{
+		{Layer: a, Kind: "jar", Path: "opt/app/lib/x.jar"},
+		{Layer: b, Kind: "sqlite", Path: "var/lib/rpm"},
+	}
+	if !cmp.Equal(got, want, opt) {
+		t.Error(cmp.Diff(got, want, opt))
+	}
+	got = r.Provenance("1")
+	want = []Provenance{{Layer: a, Path: "var/lib/dpkg/status"}}
+	if !cmp.Equal(got, want, opt) {
+		t.Error(cmp.Diff(got, want, opt))
+	}
+	if got := r.Provenance("3"); len(got) != 0 {
+		t.Errorf("unexpected provenance: %v", got)
+	}
+
+	if got, want := r.PackagesIn(a), []string{"1", "2"}; !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+	if got, want := r.PackagesIn(b), []string{"2"}; !cmp.Equal(got, want) {
+		t.Error(cmp.Diff(got, want))
+	}
+}
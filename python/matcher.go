@@ -9,7 +9,8 @@ import (
 )
 
 var (
-	_ driver.Matcher = (*Matcher)(nil)
+	_ driver.Matcher       = (*Matcher)(nil)
+	_ driver.MatchDetailer = (*Matcher)(nil)
 )
 
 // Matcher attempts to correlate discovered python packages with reported
@@ -30,25 +31,35 @@ func (*Matcher) Query() []driver.MatchConstraint {
 }
 
 // Vulnerable implements driver.Matcher.
-func (*Matcher) Vulnerable(ctx context.Context, record *claircore.IndexRecord, vuln *claircore.Vulnerability) (bool, error) {
+func (m *Matcher) Vulnerable(ctx context.Context, record *claircore.IndexRecord, vuln *claircore.Vulnerability) (bool, error) {
+	ok, _, err := m.VulnerableDetail(ctx, record, vuln)
+	return ok, err
+}
+
+// VulnerableDetail implements driver.MatchDetailer.
+//
+// Vulnerabilities record the affected versions as a set of specifiers, so
+// every match is a range match, independent of any distribution or
+// repository.
+func (*Matcher) VulnerableDetail(ctx context.Context, record *claircore.IndexRecord, vuln *claircore.Vulnerability) (bool, *claircore.MatchDetail, error) {
 	// if the vuln is not associated with any package,
 	// return not vulnerable.
 	if vuln.Package == nil {
-		return false, nil
+		return false, nil, nil
 	}
 
 	v, err := pep440.Parse(record.Package.Version)
 	if err != nil {
-		return false, nil
+		return false, nil, nil
 	}
 
 	spec, err := pep440.NewSpecifiers(vuln.Package.Version)
 	if err != nil {
-		return false, nil
+		return false, nil, nil
 	}
 
 	if spec.Check(v) {
-		return true, nil
+		return true, &claircore.MatchDetail{Method: claircore.MatchRange}, nil
 	}
-	return false, nil
+	return false, nil, nil
 }
@@ -6,6 +6,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/textproto"
@@ -24,6 +25,7 @@ import (
 var (
 	_ indexer.VersionedScanner = (*Scanner)(nil)
 	_ indexer.PackageScanner   = (*Scanner)(nil)
+	_ indexer.PortableScanner  = (*Scanner)(nil)
 )
 
 // Scanner implements the scanner.PackageScanner interface.
@@ -38,11 +40,14 @@ type Scanner struct{}
 func (*Scanner) Name() string { return "python" }
 
 // Version implements scanner.VersionedScanner.
-func (*Scanner) Version() string { return "0.1.0" }
+func (*Scanner) Version() string { return "0.1.1" }
 
 // Kind implements scanner.VersionedScanner.
 func (*Scanner) Kind() string { return "package" }
 
+// Portable implements indexer.PortableScanner.
+func (*Scanner) Portable() {}
+
 // Scan attempts to find wheel or egg info directories and record the package
 // information there.
 //
@@ -89,6 +94,7 @@ func (ps *Scanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*clairco
 				Err(err).
 				Str("path", n).
 				Msg("unable to read metadata, skipping")
+			indexer.Warn(ctx, n, "unable to read metadata: %v", err)
 			continue
 		}
 		v, err := pep440.Parse(hdr.Get("Version"))
@@ -97,6 +103,7 @@ func (ps *Scanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*clairco
 				Err(err).
 				Str("path", n).
 				Msg("couldn't parse the version, skipping")
+			indexer.Warn(ctx, n, "unable to parse version %q: %v", hdr.Get("Version"), err)
 			continue
 		}
 		ret = append(ret, &claircore.Package{
@@ -113,12 +120,43 @@ func (ps *Scanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*clairco
 	return ret, nil
 }
 
+// MaxCandidates is the most metadata files findDeliciousEgg will report for a
+// single layer. Past this, the layer is assumed to be pathological.
+const maxCandidates = 8192
+
+// SkipDirs are directory names that never contain installed packages, but may
+// contain things that look like them: pip's wheel cache, for example.
+var skipDirs = map[string]struct{}{
+	".cache":      {},
+	"__pycache__": {},
+	".git":        {},
+}
+
+// SkipRoots are top-level directories that are usually mount points for
+// pseudo-filesystems, and have no business being in a layer.
+var skipRoots = map[string]struct{}{
+	"proc": {},
+	"sys":  {},
+	"dev":  {},
+}
+
 // FindDeliciousEgg finds eggs and wheels.
+//
+// The whole layer is searched, so that virtualenvs and other installations
+// outside the usual prefixes (e.g. "/opt/app/venv") are found.
 func findDeliciousEgg(ctx context.Context, sys fs.FS) (out []string, err error) {
-	return out, fs.WalkDir(sys, ".", func(p string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(sys, ".", func(p string, d fs.DirEntry, err error) error {
 		switch {
 		case err != nil:
 			return err
+		case d.IsDir():
+			if _, ok := skipDirs[d.Name()]; ok {
+				return fs.SkipDir
+			}
+			if _, ok := skipRoots[p]; ok {
+				return fs.SkipDir
+			}
+			return nil
 		case !d.Type().IsRegular():
 			// Should we chase symlinks with the correct name?
 			return nil
@@ -129,7 +167,21 @@ func findDeliciousEgg(ctx context.Context, sys fs.FS) (out []string, err error)
 		default:
 			return nil
 		}
+		if len(out) == maxCandidates {
+			return errTooMany
+		}
 		out = append(out, p)
 		return nil
 	})
+	if errors.Is(err, errTooMany) {
+		zlog.Warn(ctx).
+			Int("limit", maxCandidates).
+			Msg("too many candidate packages, ignoring the rest")
+		indexer.Warn(ctx, "", "more than %d python packages found, ignoring the rest", maxCandidates)
+		err = nil
+	}
+	return out, err
 }
+
+// ErrTooMany is used to stop findDeliciousEgg's walk early.
+var errTooMany = errors.New("too many candidates")
@@ -592,6 +592,66 @@ func TestScanLocal(t *testing.T) {
 			want:      nil,
 			layerPath: "testdata/layer-with-bad-version.tar",
 		},
+		{
+			// Has a virtualenv in /opt/app/venv and an egg in /srv, plus
+			// metadata in pip's cache and under /proc that should be
+			// ignored.
+			name: "nonstandard prefixes",
+			want: []*claircore.Package{
+				{
+					Name:           "flask",
+					Version:        "1.1.2",
+					Kind:           claircore.BINARY,
+					PackageDB:      "python:opt/app/venv/lib/python3.9/site-packages",
+					RepositoryHint: "https://pypi.org/simple",
+					NormalizedVersion: claircore.Version{
+						Kind: "pep440",
+						V:    [...]int32{0, 1, 1, 2, 0, 0, 0, 0, 0, 0},
+					},
+				},
+				{
+					Name:           "requests",
+					Version:        "2.25.1",
+					Kind:           claircore.BINARY,
+					PackageDB:      "python:opt/app/venv/lib/python3.9/site-packages",
+					RepositoryHint: "https://pypi.org/simple",
+					NormalizedVersion: claircore.Version{
+						Kind: "pep440",
+						V:    [...]int32{0, 2, 25, 1, 0, 0, 0, 0, 0, 0},
+					},
+				},
+				{
+					Name:           "six",
+					Version:        "1.15.0",
+					Kind:           claircore.BINARY,
+					PackageDB:      "python:srv/legacy/lib/python2.7/site-packages",
+					RepositoryHint: "https://pypi.org/simple",
+					NormalizedVersion: claircore.Version{
+						Kind: "pep440",
+						V:    [...]int32{0, 1, 15, 0, 0, 0, 0, 0, 0, 0},
+					},
+				},
+			},
+			layerPath: "testdata/layer-with-venv.tar",
+		},
+		{
+			// Synthetic Windows layer: the filesystem is under "Files".
+			name: "windows layout",
+			want: []*claircore.Package{
+				{
+					Name:           "requests",
+					Version:        "2.25.1",
+					Kind:           claircore.BINARY,
+					PackageDB:      "python:Files/Python39/Lib/site-packages",
+					RepositoryHint: "https://pypi.org/simple",
+					NormalizedVersion: claircore.Version{
+						Kind: "pep440",
+						V:    [...]int32{0, 2, 25, 1, 0, 0, 0, 0, 0, 0},
+					},
+				},
+			},
+			layerPath: "testdata/layer-windows.tar",
+		},
 	}
 	for _, tt := range table {
 		t.Run(tt.name, func(t *testing.T) {
@@ -17,6 +17,7 @@ import (
 var (
 	_ indexer.VersionedScanner = (*Scanner)(nil)
 	_ indexer.PackageScanner   = (*Scanner)(nil)
+	_ indexer.PortableScanner  = (*RepoScanner)(nil)
 
 	Repository = claircore.Repository{
 		Name: "pypi",
@@ -35,6 +36,9 @@ func (*RepoScanner) Version() string { return "0.0.1" }
 // Kind implements scanner.VersionedScanner.
 func (*RepoScanner) Kind() string { return "repository" }
 
+// Portable implements indexer.PortableScanner.
+func (*RepoScanner) Portable() {}
+
 // Scan attempts to find wheel or egg info directories and record the package
 // information there.
 //
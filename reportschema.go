@@ -0,0 +1,119 @@
+package claircore
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// These are the versions of the JSON forms of IndexReport and
+// VulnerabilityReport. Reports are always encoded at the current version.
+//
+// A version is incremented whenever a change to a report's JSON form would
+// change the meaning of a previously encoded report: a field being renamed,
+// removed, or given a different type. Adding a field doesn't need a new
+// version. The JSON Schema for each version is published in the docs
+// directory.
+//
+// Reports encoded at an older version, including unversioned reports from
+// before versioning (version 0), are upgraded when decoded, so that reports
+// kept by clients stay readable. Reports encoded at a newer version are
+// decoded as well as possible: fields this version doesn't know of are
+// dropped, and the SchemaVersion field reports the version that was read.
+const (
+	IndexReportVersion         = 1
+	VulnerabilityReportVersion = 1
+)
+
+// ReportUpgrade rewrites the top-level fields of an encoded report from one
+// version to the next.
+type reportUpgrade func(map[string]json.RawMessage) error
+
+// IndexReportUpgrades upgrades an IndexReport from the version of the index
+// to the next version. There must be one for every version before
+// IndexReportVersion.
+var indexReportUpgrades = [IndexReportVersion]reportUpgrade{
+	// Version 1 only added the schema_version field.
+	0: nil,
+}
+
+// VulnerabilityReportUpgrades is like indexReportUpgrades, for
+// VulnerabilityReports.
+var vulnerabilityReportUpgrades = [VulnerabilityReportVersion]reportUpgrade{
+	// Version 1 only added the schema_version field.
+	0: nil,
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r IndexReport) MarshalJSON() ([]byte, error) {
+	type report IndexReport
+	r.SchemaVersion = IndexReportVersion
+	return json.Marshal(report(r))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+//
+// Reports encoded at an older version are upgraded to IndexReportVersion.
+func (r *IndexReport) UnmarshalJSON(b []byte) error {
+	type report IndexReport
+	b, err := upgradeReport(b, indexReportUpgrades[:])
+	if err != nil {
+		return fmt.Errorf("claircore: upgrading IndexReport: %w", err)
+	}
+	return json.Unmarshal(b, (*report)(r))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r VulnerabilityReport) MarshalJSON() ([]byte, error) {
+	type report VulnerabilityReport
+	r.SchemaVersion = VulnerabilityReportVersion
+	return json.Marshal(report(r))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+//
+// Reports encoded at an older version are upgraded to
+// VulnerabilityReportVersion.
+func (r *VulnerabilityReport) UnmarshalJSON(b []byte) error {
+	type report VulnerabilityReport
+	b, err := upgradeReport(b, vulnerabilityReportUpgrades[:])
+	if err != nil {
+		return fmt.Errorf("claircore: upgrading VulnerabilityReport: %w", err)
+	}
+	return json.Unmarshal(b, (*report)(r))
+}
+
+// UpgradeReport runs the encoded report through the upgrades from its version
+// on. Reports at the current version or newer are returned as-is.
+func upgradeReport(b []byte, ups []reportUpgrade) ([]byte, error) {
+	var hdr struct {
+		Version *int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(b, &hdr); err != nil {
+		// Let the real decode report it.
+		return b, nil
+	}
+	v := 0
+	if hdr.Version != nil {
+		v = *hdr.Version
+	}
+	if v < 0 {
+		return nil, fmt.Errorf("invalid schema version %d", v)
+	}
+	if v >= len(ups) {
+		return b, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil || obj == nil {
+		return b, nil
+	}
+	for ; v < len(ups); v++ {
+		if ups[v] == nil {
+			continue
+		}
+		if err := ups[v](obj); err != nil {
+			return nil, fmt.Errorf("version %d: %w", v, err)
+		}
+	}
+	obj["schema_version"] = json.RawMessage(fmt.Sprint(len(ups)))
+	return json.Marshal(obj)
+}
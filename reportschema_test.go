@@ -0,0 +1,250 @@
+package claircore
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quay/claircore/pkg/cpe"
+)
+
+func TestReportVersion(t *testing.T) {
+	t.Run("Encode", func(t *testing.T) {
+		b, err := json.Marshal(&IndexReport{State: "IndexFinished"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			V int `json:"schema_version"`
+		}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.V != IndexReportVersion {
+			t.Errorf("got: %d, want: %d", got.V, IndexReportVersion)
+		}
+		// Encoding a value works the same.
+		b, err = json.Marshal(VulnerabilityReport{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.V != VulnerabilityReportVersion {
+			t.Errorf("got: %d, want: %d", got.V, VulnerabilityReportVersion)
+		}
+	})
+	t.Run("Unversioned", func(t *testing.T) {
+		const in = `{"manifest_hash":"sha256:` + zeroHex + `","state":"IndexFinished","success":true,` +
+			`"packages":{"1":{"id":"1","name":"bash","version":"5.1"}}}`
+		var r IndexReport
+		if err := json.Unmarshal([]byte(in), &r); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := r.SchemaVersion, IndexReportVersion; got != want {
+			t.Errorf("version: got: %d, want: %d", got, want)
+		}
+		if !r.Success || r.Packages["1"].Name != "bash" {
+			t.Errorf("unexpected report: %+v", r)
+		}
+	})
+	t.Run("Newer", func(t *testing.T) {
+		const in = `{"schema_version":99,"manifest_hash":"sha256:` + zeroHex + `","vulnerabilities":{},"from_the_future":true}`
+		var r VulnerabilityReport
+		if err := json.Unmarshal([]byte(in), &r); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := r.SchemaVersion, 99; got != want {
+			t.Errorf("version: got: %d, want: %d", got, want)
+		}
+	})
+	t.Run("Upgrade", func(t *testing.T) {
+		// A stand-in for a real upgrade, renaming a field.
+		ups := []reportUpgrade{func(m map[string]json.RawMessage) error {
+			m["new"] = m["old"]
+			delete(m, "old")
+			return nil
+		}}
+		b, err := upgradeReport([]byte(`{"old":1}`), ups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(b), `{"new":1,"schema_version":1}`; got != want {
+			t.Errorf("got: %s, want: %s", got, want)
+		}
+	})
+}
+
+const zeroHex = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// TestReportSchema checks that every field in the JSON form of fully
+// populated reports is described by the published schemas, so that changes
+// to the reports can't go by unnoticed.
+func TestReportSchema(t *testing.T) {
+	d := MustParseDigest("sha256:" + zeroHex)
+	wfn := cpe.MustUnbind("cpe:2.3:o:example:os:1:*:*:*:*:*:*:*")
+	now := time.Now()
+	pkg := &Package{
+		ID: "1", Name: "bash", Version: "5.1", Kind: BINARY, Module: "m", Arch: "x86_64", CPE: wfn,
+		NormalizedVersion: Version{Kind: "semver", V: [10]int32{1}},
+		Source:            &Package{ID: "2", Name: "bash", Version: "5.1", Kind: SOURCE},
+	}
+	dist := &Distribution{
+		ID: "1", DID: "os", Name: "OS", Version: "1", VersionCodeName: "one", VersionID: "1",
+		Arch: "x86_64", CPE: wfn, PrettyName: "OS 1",
+	}
+	repo := &Repository{ID: "1", Name: "main", Key: "k", URI: "https://example.com", CPE: wfn}
+	env := []*Environment{{PackageDB: "var/lib/dpkg/status", IntroducedIn: d, DistributionID: "1", RepositoryIDs: []string{"1"}}}
+
+	ir := IndexReport{
+		Hash:             d,
+		State:            "IndexFinished",
+		Packages:         map[string]*Package{"1": pkg},
+		Distributions:    map[string]*Distribution{"1": dist},
+		Repositories:     map[string]*Repository{"1": repo},
+		Environments:     map[string][]*Environment{"1": env},
+		Success:          true,
+		Err:              "err",
+		Scanners:         []ScannerRecord{{Name: "s", Version: "1", Kind: "package"}},
+		Warnings:         []IndexWarning{{Scanner: "s", Layer: d, Path: "p", Message: "m"}},
+		ScannerErrors:    []ScannerError{{Scanner: "s", Kind: "package", Layer: d, Err: "e"}},
+		FilteredPackages: 1,
+		ModifiedFiles: []ModifiedFile{{
+			Package: "bash", PackageDB: "db", Path: "p", Layer: d, Expected: "e", Actual: "a", Removed: true,
+		}},
+		Image: &ImageMetadata{
+			Architecture: "amd64", OS: "linux", Variant: "v", Created: &now,
+			Labels: map[string]string{"a": "b"}, LabelsTruncated: true,
+		},
+		IndexMode: IndexSquashed,
+		Errors:    []IndexError{{State: "ScanLayers", Scanner: "s", Kind: "package", Layer: &d, Err: "e"}, {State: "FetchLayers", Err: "e"}},
+		Partial:   true,
+	}
+	vr := VulnerabilityReport{
+		Hash:          d,
+		Packages:      ir.Packages,
+		Distributions: ir.Distributions,
+		Repositories:  ir.Repositories,
+		Environments:  ir.Environments,
+		Vulnerabilities: map[string]*Vulnerability{"1": {
+			ID: "1", Updater: "u", Name: "CVE-0", Description: "d", Issued: now, Links: "l",
+			Severity: "High", NormalizedSeverity: High, Package: pkg, Dist: dist, Repo: repo,
+			FixedInVersion: "5.2", Range: &Range{Lower: pkg.NormalizedVersion, Upper: pkg.NormalizedVersion},
+			ArchOperation: OpEquals, UpdateRef: "ref",
+		}},
+		PackageVulnerabilities: map[string][]string{"1": {"1"}},
+		Enrichments:            map[string][]json.RawMessage{"e": {json.RawMessage(`{"x":1}`)}},
+		MatchDetails: map[string][]*MatchDetail{"1": {{
+			Vulnerability: "1", Matcher: "m", Distribution: "1", Repository: "1", Method: MatchRange,
+		}}},
+	}
+
+	for _, tc := range []struct {
+		file   string
+		report interface{}
+	}{
+		{"index_report.v1.json", &ir},
+		{"vulnerability_report.v1.json", &vr},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			b, err := json.Marshal(tc.report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v interface{}
+			if err := json.Unmarshal(b, &v); err != nil {
+				t.Fatal(err)
+			}
+			s := schemaSet{t: t, docs: make(map[string]map[string]interface{})}
+			root := s.load(tc.file)
+			var missing []string
+			s.walk(tc.file, root, v, "", &missing)
+			sort.Strings(missing)
+			for _, m := range missing {
+				t.Errorf("field not in schema: %s", m)
+			}
+		})
+	}
+}
+
+// SchemaSet is just enough of a JSON Schema reader to find the properties
+// the published schemas describe.
+type schemaSet struct {
+	t    *testing.T
+	docs map[string]map[string]interface{}
+}
+
+func (s *schemaSet) load(file string) map[string]interface{} {
+	if d, ok := s.docs[file]; ok {
+		return d
+	}
+	b, err := os.ReadFile(filepath.Join("docs", "reference", "schema", file))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	var d map[string]interface{}
+	if err := json.Unmarshal(b, &d); err != nil {
+		s.t.Fatalf("%s: %v", file, err)
+	}
+	s.docs[file] = d
+	return d
+}
+
+// Resolve follows a schema's "$ref", if any, returning the file the result
+// is in.
+func (s *schemaSet) resolve(file string, sch map[string]interface{}) (string, map[string]interface{}) {
+	for {
+		ref, ok := sch["$ref"].(string)
+		if !ok {
+			return file, sch
+		}
+		i := strings.IndexByte(ref, '#')
+		if ref[:i] != "" {
+			file = ref[:i]
+		}
+		sch = s.load(file)
+		for _, p := range strings.Split(strings.TrimPrefix(ref[i+1:], "/"), "/") {
+			sch = sch[p].(map[string]interface{})
+		}
+	}
+}
+
+func (s *schemaSet) walk(file string, sch map[string]interface{}, v interface{}, path string, missing *[]string) {
+	file, sch = s.resolve(file, sch)
+	if alts, ok := sch["oneOf"].([]interface{}); ok {
+		for _, a := range alts {
+			if a := a.(map[string]interface{}); a["type"] != "null" {
+				s.walk(file, a, v, path, missing)
+			}
+		}
+		return
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		props, _ := sch["properties"].(map[string]interface{})
+		addl, _ := sch["additionalProperties"].(map[string]interface{})
+		for k, fv := range v {
+			switch {
+			case props != nil && props[k] != nil:
+				s.walk(file, props[k].(map[string]interface{}), fv, path+"."+k, missing)
+			case addl != nil:
+				s.walk(file, addl, fv, path+"[]", missing)
+			default:
+				*missing = append(*missing, path+"."+k)
+			}
+		}
+	case []interface{}:
+		items, ok := sch["items"].(map[string]interface{})
+		if !ok {
+			return
+		}
+		for _, iv := range v {
+			s.walk(file, items, iv, path+"[]", missing)
+		}
+	}
+}
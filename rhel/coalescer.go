@@ -82,12 +82,14 @@ func (c *Coalescer) Coalesce(ctx context.Context, artifacts []*indexer.LayerArti
 				if currDist != nil {
 					distID = currDist.ID
 				}
-				if _, ok := dbs[pkg.PackageDB]; !ok {
+				db, ok := dbs[pkg.PackageDB]
+				if !ok {
 					packages := map[string]*claircore.Package{}
 					environments := map[string]*claircore.Environment{}
-					dbs[pkg.PackageDB] = &packageDatabase{packages, environments}
+					db = &packageDatabase{packages, environments}
+					dbs[pkg.PackageDB] = db
 				}
-				if _, ok := dbs[pkg.PackageDB].packages[pkg.ID]; !ok {
+				if _, ok := db.packages[pkg.ID]; !ok {
 					environment := &claircore.Environment{
 						PackageDB:      pkg.PackageDB,
 						IntroducedIn:   layerArtifacts.Hash,
@@ -96,8 +98,8 @@ func (c *Coalescer) Coalesce(ctx context.Context, artifacts []*indexer.LayerArti
 					for _, repo := range layerArtifacts.Repos {
 						environment.RepositoryIDs = append(environment.RepositoryIDs, repo.ID)
 					}
-					dbs[pkg.PackageDB].packages[pkg.ID] = pkg
-					dbs[pkg.PackageDB].environments[pkg.ID] = environment
+					db.packages[pkg.ID] = pkg
+					db.environments[pkg.ID] = environment
 				}
 			}
 		}
@@ -113,6 +115,30 @@ func (c *Coalescer) Coalesce(ctx context.Context, artifacts []*indexer.LayerArti
 	// from list of packages
 	// If a package is available in all layers it means that it should be added
 	// to list of packages and associate an environment for it.
+	//
+	// Only the last layer with any packages decides this: a package found in
+	// a layer before it is kept only if it's still in that layer's databases,
+	// and every package found in it or after it is kept.
+	type dbPackage struct {
+		id, db string
+	}
+	last := -1
+	for i, a := range artifacts {
+		if len(a.Pkgs) != 0 {
+			last = i
+		}
+	}
+	var final map[dbPackage]struct{}
+	if last != -1 {
+		final = make(map[dbPackage]struct{}, len(artifacts[last].Pkgs))
+		for _, pkg := range artifacts[last].Pkgs {
+			final[dbPackage{pkg.ID, pkg.PackageDB}] = struct{}{}
+		}
+		if len(c.ir.Packages) == 0 {
+			c.ir.Packages = make(map[string]*claircore.Package, len(final))
+			c.ir.Environments = make(map[string][]*claircore.Environment, len(final))
+		}
+	}
 	for i := 0; i < len(artifacts); i++ {
 		currentLayerArtifacts := artifacts[i]
 		if len(currentLayerArtifacts.Pkgs) == 0 {
@@ -123,20 +149,9 @@ func (c *Coalescer) Coalesce(ctx context.Context, artifacts []*indexer.LayerArti
 				// the package was already processed in previous layers
 				continue
 			}
-			// for each package let's find out if it is also available in other layers dbs
 			found := true
-			for j := i + 1; j < len(artifacts); j++ {
-				nextLayerArtifacts := artifacts[j]
-				if len(nextLayerArtifacts.Pkgs) == 0 {
-					continue
-				}
-				found = false
-				for _, nextPkg := range nextLayerArtifacts.Pkgs {
-					if currentPkg.ID == nextPkg.ID && currentPkg.PackageDB == nextPkg.PackageDB {
-						found = true
-						break
-					}
-				}
+			if i < last {
+				_, found = final[dbPackage{currentPkg.ID, currentPkg.PackageDB}]
 			}
 			if found {
 				c.ir.Packages[currentPkg.ID] = currentPkg
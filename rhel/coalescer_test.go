@@ -2,9 +2,11 @@ package rhel
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/quay/zlog"
 
 	"github.com/quay/claircore"
@@ -339,3 +341,121 @@ func TestCoalescerRemovedPackage(t *testing.T) {
 		t.Fatalf("Package %v was removed, but it is still available in environment", pkg1)
 	}
 }
+
+// TestCoalescerWide checks the Coalescer against a straightforward
+// implementation that looks for every package in every later layer.
+func TestCoalescerWide(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	for _, sz := range []struct{ layers, pkgs int }{
+		{1, 10},
+		{3, 50},
+		{40, 2000},
+	} {
+		t.Run(fmt.Sprintf("%dx%d", sz.layers, sz.pkgs), func(t *testing.T) {
+			ctx := zlog.Test(ctx, t)
+			artifacts := test.GenWideArtifacts(t, sz.layers, sz.pkgs)
+			got, err := NewCoalescer().Coalesce(ctx, artifacts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := referenceCoalesce(artifacts)
+			opt := cmp.AllowUnexported(claircore.Digest{})
+			if !cmp.Equal(got, want, opt) {
+				t.Error(cmp.Diff(got, want, opt))
+			}
+			if len(got.Packages) == 0 {
+				t.Error("no packages")
+			}
+		})
+	}
+}
+
+// ReferenceCoalesce decides which packages are in the image by checking every
+// later layer with packages, as the Coalescer once did.
+func referenceCoalesce(artifacts []*indexer.LayerArtifacts) *claircore.IndexReport {
+	ir := NewCoalescer().ir
+	for _, a := range artifacts {
+		for _, repo := range a.Repos {
+			ir.Repositories[repo.ID] = repo
+		}
+	}
+	var currDist *claircore.Distribution
+	for _, a := range artifacts {
+		if len(a.Dist) != 0 {
+			currDist = a.Dist[0]
+			ir.Distributions[currDist.ID] = currDist
+			break
+		}
+	}
+	envs := map[string]map[string]*claircore.Environment{}
+	for _, a := range artifacts {
+		if len(a.Dist) != 0 {
+			currDist = a.Dist[0]
+			ir.Distributions[currDist.ID] = currDist
+		}
+		for _, pkg := range a.Pkgs {
+			if envs[pkg.PackageDB] == nil {
+				envs[pkg.PackageDB] = map[string]*claircore.Environment{}
+			}
+			if _, ok := envs[pkg.PackageDB][pkg.ID]; ok {
+				continue
+			}
+			env := &claircore.Environment{
+				PackageDB:    pkg.PackageDB,
+				IntroducedIn: a.Hash,
+			}
+			if currDist != nil {
+				env.DistributionID = currDist.ID
+			}
+			for _, repo := range a.Repos {
+				env.RepositoryIDs = append(env.RepositoryIDs, repo.ID)
+			}
+			envs[pkg.PackageDB][pkg.ID] = env
+		}
+	}
+	for i, a := range artifacts {
+		for _, pkg := range a.Pkgs {
+			if _, ok := ir.Packages[pkg.ID]; ok {
+				continue
+			}
+			found := true
+			for _, next := range artifacts[i+1:] {
+				if len(next.Pkgs) == 0 {
+					continue
+				}
+				found = false
+				for _, np := range next.Pkgs {
+					if pkg.ID == np.ID && pkg.PackageDB == np.PackageDB {
+						found = true
+						break
+					}
+				}
+			}
+			if found {
+				ir.Packages[pkg.ID] = pkg
+				ir.Environments[pkg.ID] = append(ir.Environments[pkg.ID], envs[pkg.PackageDB][pkg.ID])
+			}
+		}
+	}
+	return ir
+}
+
+func BenchmarkCoalescer(b *testing.B) {
+	ctx := zlog.Test(context.Background(), b)
+	for _, sz := range []struct{ layers, pkgs int }{
+		{30, 4000},
+		{300, 40000},
+	} {
+		b.Run(fmt.Sprintf("%dx%d", sz.layers, sz.pkgs), func(b *testing.B) {
+			ctx := zlog.Test(ctx, b)
+			artifacts := test.GenWideArtifacts(b, sz.layers, sz.pkgs)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := NewCoalescer().Coalesce(ctx, artifacts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
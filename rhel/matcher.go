@@ -7,13 +7,17 @@ import (
 
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/libvuln/driver"
+	"github.com/quay/claircore/pkg/kmod"
 )
 
 // Matcher implements driver.Matcher.
 type Matcher struct {
 }
 
-var _ driver.Matcher = (*Matcher)(nil)
+var (
+	_ driver.Matcher       = (*Matcher)(nil)
+	_ driver.MatchDetailer = (*Matcher)(nil)
+)
 
 // Name implements driver.Matcher.
 func (*Matcher) Name() string {
@@ -35,19 +39,43 @@ func (*Matcher) Query() []driver.MatchConstraint {
 
 // Vulnerable implements driver.Matcher.
 func (m *Matcher) Vulnerable(ctx context.Context, record *claircore.IndexRecord, vuln *claircore.Vulnerability) (bool, error) {
-	pkgVer, vulnVer := version.NewVersion(record.Package.Version), version.NewVersion(vuln.Package.Version)
+	ok, _, err := m.VulnerableDetail(ctx, record, vuln)
+	return ok, err
+}
+
+// VulnerableDetail implements driver.MatchDetailer.
+//
+// Vulnerabilities are found by repository, so that's the context reported. The
+// repository may have come from the image's content sets or, failing that,
+// from its CPEs.
+func (m *Matcher) VulnerableDetail(ctx context.Context, record *claircore.IndexRecord, vuln *claircore.Vulnerability) (bool, *claircore.MatchDetail, error) {
+	target := vuln.Package.Version
+	if vuln.FixedInVersion != "" {
+		target = vuln.FixedInVersion
+	}
+	pkgVer := version.NewVersion(kmod.MatchVersion(record.Package, target))
+	vulnVer := version.NewVersion(vuln.Package.Version)
 	// Assume the vulnerability record we have is for the last known vulnerable
 	// version, so greater versions aren't vulnerable.
 	cmp := func(i int) bool { return i != version.GREATER }
+	method := claircore.MatchUnfixed
 	// But if it's explicitly marked as a fixed-in version, it's only vulnerable
 	// if less than that version.
 	if vuln.FixedInVersion != "" {
 		vulnVer = version.NewVersion(vuln.FixedInVersion)
 		cmp = func(i int) bool { return i == version.LESS }
+		method = claircore.MatchFixedVersion
 	} else {
 		// If a vulnerability doesn't have FixedInVersion, assume it is unfixed.
 		vulnVer = version.NewVersion("65535:0")
 	}
 	// compare version and architecture
-	return cmp(pkgVer.Compare(vulnVer)) && vuln.ArchOperation.Cmp(record.Package.Arch, vuln.Package.Arch), nil
+	if !cmp(pkgVer.Compare(vulnVer)) || !vuln.ArchOperation.Cmp(record.Package.Arch, vuln.Package.Arch) {
+		return false, nil, nil
+	}
+	d := &claircore.MatchDetail{Method: method}
+	if record.Repository != nil {
+		d.Repository = record.Repository.ID
+	}
+	return true, d, nil
 }
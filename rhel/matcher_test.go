@@ -143,3 +143,53 @@ func TestVulnerable(t *testing.T) {
 		}
 	}
 }
+
+func TestVulnerableKernelModule(t *testing.T) {
+	ice := &claircore.IndexRecord{
+		Package: &claircore.Package{
+			Name:    "kmod-ice",
+			Version: "1.9.11_5.14.0_70.13.1-1",
+			KernelModule: &claircore.KernelModule{
+				Name:    "kmod-ice",
+				Version: "1.9.11-1",
+				Kernel:  "5.14.0-70.13.1",
+			},
+		},
+	}
+	nvidia := &claircore.IndexRecord{
+		Package: &claircore.Package{
+			Name:    "kmod-nvidia-535.104.05-5.14.0-284.30.1",
+			Version: "3:535.104.05-3.el9_2",
+			KernelModule: &claircore.KernelModule{
+				Name:    "kmod-nvidia",
+				Version: "3:535.104.05-3.el9_2",
+				Kernel:  "5.14.0-284.30.1",
+			},
+		},
+	}
+	fixed := func(v string) *claircore.Vulnerability {
+		return &claircore.Vulnerability{
+			Package:        &claircore.Package{},
+			FixedInVersion: v,
+		}
+	}
+	testCases := []vulnerableTestCase{
+		// Compared whole, "1.9.11_5.14.0..." sorts after "1.9.11.1".
+		{ir: ice, v: fixed("1.9.11.1-1"), want: true, name: "ice driver fixed later"},
+		{ir: ice, v: fixed("1.9.11-1"), want: false, name: "ice driver fixed"},
+		{ir: ice, v: fixed("1.9.11_5.14.0_70.17.1-1"), want: true, name: "ice build fixed later"},
+		{ir: nvidia, v: fixed("3:535.129.03-1.el9"), want: true, name: "nvidia driver fixed later"},
+		{ir: nvidia, v: fixed("3:535.54.03-1.el9"), want: false, name: "nvidia driver fixed"},
+	}
+
+	m := &Matcher{}
+	for _, tc := range testCases {
+		got, err := m.Vulnerable(nil, tc.ir, tc.v)
+		if err != nil {
+			t.Error(err)
+		}
+		if tc.want != got {
+			t.Errorf("%q failed: want %t, got %t", tc.name, tc.want, got)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 
 	"github.com/quay/goval-parser/oval"
 	"github.com/quay/zlog"
+
+	"github.com/quay/claircore/libvuln/driver/drivertest"
 )
 
 func TestParse(t *testing.T) {
@@ -47,6 +49,21 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseGolden(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	u, err := NewUpdater(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := drivertest.Golden{
+		Updater: u,
+		Fixture: "testdata/com.redhat.rhsa-20201980.xml",
+	}
+	t.Run(g.Fixture, g.Run(ctx))
+}
+
 // Here's a giant restructured struct for reference and tests.
 var ovalDef = oval.Definition{XMLName: xml.Name{Space: "http://oval.mitre.org/XMLSchema/oval-definitions-5", Local: "definition"},
 	ID:    "oval:com.redhat.rhsa:def:20100401",
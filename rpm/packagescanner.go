@@ -19,6 +19,7 @@ import (
 
 	"github.com/quay/claircore"
 	"github.com/quay/claircore/indexer"
+	"github.com/quay/claircore/pkg/kmod"
 	"github.com/quay/claircore/pkg/tarfs"
 	"github.com/quay/claircore/rpm/sqlite"
 )
@@ -26,7 +27,7 @@ import (
 const (
 	pkgName    = "rpm"
 	pkgKind    = "package"
-	pkgVersion = "5"
+	pkgVersion = "6"
 )
 
 var (
@@ -225,6 +226,9 @@ func (ps *Scanner) Scan(ctx context.Context, layer *claircore.Layer) ([]*clairco
 			panic("programmer error")
 		}
 	}
+	for _, p := range pkgs {
+		kmod.Annotate(p)
+	}
 
 	return pkgs, nil
 }
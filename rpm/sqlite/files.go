@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+)
+
+// PackageFiles is the set of files recorded in a package header.
+type PackageFiles struct {
+	Name string
+	// DigestAlgo is the RPM hash algorithm used for every digest in Files.
+	DigestAlgo int
+	Files      []File
+}
+
+// File is a regular file recorded in a package header.
+type File struct {
+	// Path is absolute.
+	Path string
+	// Digest is hex encoded.
+	Digest string
+}
+
+// Files reports the regular files recorded for the named packages.
+//
+// Config and ghost files are omitted, as they're not expected to match the
+// packaged contents.
+func (db *RPMDB) Files(ctx context.Context, names ...string) ([]PackageFiles, error) {
+	want := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		want[n] = struct{}{}
+	}
+	hs, err := db.loadHeaders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []PackageFiles
+	for _, k := range hs.key {
+		h := hs.header[k]
+		var name string
+		for i := range h.Infos {
+			e := &h.Infos[i]
+			if e.Tag != tagName {
+				continue
+			}
+			v, err := h.ReadData(ctx, e)
+			if err != nil {
+				return nil, err
+			}
+			name = v.(string)
+			break
+		}
+		if _, ok := want[name]; !ok {
+			continue
+		}
+		pf, err := readFiles(ctx, h)
+		if err != nil {
+			return nil, fmt.Errorf("rpm/sqlite: package %q: %w", name, err)
+		}
+		pf.Name = name
+		out = append(out, pf)
+	}
+	return out, nil
+}
+
+// ReadFiles pulls the file list out of a header.
+func readFiles(ctx context.Context, h *header) (PackageFiles, error) {
+	// If there's no algorithm recorded, it's the historical default of MD5.
+	pf := PackageFiles{DigestAlgo: 1}
+	var (
+		base, dir, digest []string
+		idx, flags        []int32
+		mode              []int16
+	)
+	for i := range h.Infos {
+		e := &h.Infos[i]
+		if _, ok := fileTags[e.Tag]; !ok {
+			continue
+		}
+		v, err := h.ReadData(ctx, e)
+		if err != nil {
+			return pf, err
+		}
+		switch e.Tag {
+		case tagBasenames:
+			base = v.([]string)
+		case tagDirnames:
+			dir = v.([]string)
+		case tagDirindexes:
+			idx = v.([]int32)
+		case tagFileDigests:
+			digest = v.([]string)
+		case tagFileDigestAlgo:
+			pf.DigestAlgo = int(v.([]int32)[0])
+		case tagFileFlags:
+			flags = v.([]int32)
+		case tagFileModes:
+			mode = v.([]int16)
+		}
+	}
+	n := len(base)
+	if len(idx) != n || len(digest) != n || len(flags) != n || len(mode) != n {
+		return pf, fmt.Errorf("header botch: mismatched file tag counts")
+	}
+	const (
+		fileConfig = 1 << 0
+		fileGhost  = 1 << 6
+		modeType   = 0o170000
+		modeReg    = 0o100000
+	)
+	for i := 0; i < n; i++ {
+		switch {
+		case uint16(mode[i])&modeType != modeReg:
+		case flags[i]&(fileConfig|fileGhost) != 0:
+		case digest[i] == "":
+		case int(idx[i]) < 0 || int(idx[i]) >= len(dir):
+			return pf, fmt.Errorf("header botch: dirindex %d out of range", idx[i])
+		default:
+			pf.Files = append(pf.Files, File{
+				Path:   dir[idx[i]] + base[i],
+				Digest: digest[i],
+			})
+		}
+	}
+	return pf, nil
+}
+
+var fileTags = map[tag]struct{}{
+	tagBasenames:      {},
+	tagDirnames:       {},
+	tagDirindexes:     {},
+	tagFileDigests:    {},
+	tagFileDigestAlgo: {},
+	tagFileFlags:      {},
+	tagFileModes:      {},
+}
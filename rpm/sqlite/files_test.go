@@ -0,0 +1,68 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quay/zlog"
+)
+
+func TestFiles(t *testing.T) {
+	ctx := zlog.Test(context.Background(), t)
+	db, err := Open(`testdata/rpmdb.sqlite`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	got, err := db.Files(ctx, "bash", "setup", "not-installed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d packages, want 2", len(got))
+	}
+	tt := map[string]struct {
+		count int
+		path  string
+		want  string
+	}{
+		"bash": {
+			count: 120,
+			path:  "/usr/bin/bash",
+			want:  "a13e2cf37749ccd21613e9e691332d51fbc17baf3b815585482c62db4b524dfa",
+		},
+		"setup": {
+			// Almost everything in "setup" is a config file, including
+			// /etc/shells, so it should be left out.
+			count: 5,
+			path:  "/etc/shells",
+		},
+	}
+	for _, pf := range got {
+		tc, ok := tt[pf.Name]
+		if !ok {
+			t.Errorf("unexpected package %q", pf.Name)
+			continue
+		}
+		if got, want := pf.DigestAlgo, 8; got != want {
+			t.Errorf("%s: digest algorithm: got: %d, want: %d", pf.Name, got, want)
+		}
+		if got, want := len(pf.Files), tc.count; got != want {
+			t.Errorf("%s: files: got: %d, want: %d", pf.Name, got, want)
+		}
+		var found string
+		for _, f := range pf.Files {
+			if f.Path == tc.path {
+				found = f.Digest
+			}
+		}
+		if found != tc.want {
+			t.Errorf("%s: %s: got: %q, want: %q", pf.Name, tc.path, found, tc.want)
+		}
+	}
+}
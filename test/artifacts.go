@@ -0,0 +1,87 @@
+package test
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/quay/claircore"
+	"github.com/quay/claircore/indexer"
+)
+
+// GenWideArtifacts creates artifacts for a synthetic manifest with the given
+// number of layers, introducing roughly "pkgs" packages over its history.
+//
+// Like a real image, two of every three layers modify the package databases
+// and report their full contents, upgrading and removing some of the packages
+// from earlier layers. The remaining layers report no packages. The first
+// layer reports a distribution and repository, and the distribution is
+// upgraded halfway through. The output is the same for the same arguments,
+// except for the layer digests.
+func GenWideArtifacts(t testing.TB, layers, pkgs int) []*indexer.LayerArtifacts {
+	rng := rand.New(rand.NewSource(int64(layers)<<32 | int64(pkgs)))
+	dists := GenUniqueDistributions(3)
+	repos := GenUniqueRepositories(2)
+	modifying := layers - layers/3
+	if modifying == 0 {
+		modifying = 1
+	}
+	perLayer := pkgs / modifying
+	if perLayer == 0 {
+		perLayer = 1
+	}
+
+	var id int
+	newPackage := func(name int) *claircore.Package {
+		id++
+		db := "var/lib/rpm"
+		if name%7 == 0 {
+			db = "usr/lib/sysimage/rpm"
+		}
+		return &claircore.Package{
+			ID:        strconv.Itoa(id),
+			Name:      fmt.Sprintf("package-%d", name),
+			Version:   fmt.Sprintf("version-%d", id),
+			Kind:      claircore.BINARY,
+			PackageDB: db,
+		}
+	}
+
+	var live []*claircore.Package
+	var names int
+	out := make([]*indexer.LayerArtifacts, layers)
+	for i := range out {
+		la := &indexer.LayerArtifacts{
+			Hash: RandomSHA256Digest(t),
+		}
+		switch i {
+		case 0:
+			la.Dist = dists[1:2]
+			la.Repos = repos
+		case layers / 2:
+			la.Dist = dists[2:3]
+		}
+		out[i] = la
+		if i%3 == 2 {
+			continue
+		}
+		for n := len(live) / 100; n > 0; n-- {
+			j := rng.Intn(len(live))
+			name, _ := strconv.Atoi(strings.TrimPrefix(live[j].Name, "package-"))
+			live[j] = newPackage(name)
+		}
+		for n := len(live) / 200; n > 0; n-- {
+			j := rng.Intn(len(live))
+			live = append(live[:j], live[j+1:]...)
+		}
+		for n := 0; n < perLayer; n++ {
+			live = append(live, newPackage(names))
+			names++
+		}
+		la.Pkgs = make([]*claircore.Package, len(live))
+		copy(la.Pkgs, live)
+	}
+	return out
+}
@@ -29,6 +29,7 @@ const (
 var (
 	_ indexer.DistributionScanner = (*DistributionScanner)(nil)
 	_ indexer.VersionedScanner    = (*DistributionScanner)(nil)
+	_ indexer.FileInterest        = (*DistributionScanner)(nil)
 )
 
 // DistributionScanner implements [indexer.DistributionScanner] looking for Ubuntu distributions.
@@ -43,6 +44,11 @@ func (*DistributionScanner) Version() string { return scannerVersion }
 // Kind implements [scanner.VersionedScanner].
 func (*DistributionScanner) Kind() string { return scannerKind }
 
+// FilesOfInterest implements [indexer.FileInterest].
+func (*DistributionScanner) FilesOfInterest() []string {
+	return []string{lsbReleasePath, osReleasePath}
+}
+
 // Scan implements [indexer.DistributionScanner].
 func (ds *DistributionScanner) Scan(ctx context.Context, l *claircore.Layer) ([]*claircore.Distribution, error) {
 	defer trace.StartRegion(ctx, "Scanner.Scan").End()
@@ -0,0 +1,23 @@
+package ubuntu
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quay/claircore/libvuln/driver/drivertest"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mkDist("22.04", "jammy")
+	g := drivertest.Golden{
+		Updater: &updater{
+			url:  "https://security-metadata.canonical.com/oval/com.ubuntu.jammy.cve.oval.xml",
+			name: "jammy",
+			id:   "22.04",
+		},
+		Fixture: "testdata/com.ubuntu.jammy.cve.oval.xml",
+	}
+	t.Run(g.Fixture, g.Run(ctx))
+}
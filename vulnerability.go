@@ -38,6 +38,10 @@ type Vulnerability struct {
 	// ArchOperation indicates how the affected Package's "arch" should be
 	// compared.
 	ArchOperation ArchOp `json:"arch_op,omitempty"`
+	// UpdateRef is the reference of the update operation this vulnerability
+	// was loaded by. It's only populated if requested, as it's not needed
+	// for matching.
+	UpdateRef string `json:"update_ref,omitempty"`
 }
 
 // CheckVulnernableFunc takes a vulnerability and an indexRecord and checks if the record is
@@ -4,7 +4,12 @@ import "encoding/json"
 
 // VulnerabilityReport provides a report of packages and their
 // associated vulnerabilities.
+//
+// See VulnerabilityReportVersion for how the JSON form is versioned.
 type VulnerabilityReport struct {
+	// the version of the JSON form; set to VulnerabilityReportVersion when
+	// encoded
+	SchemaVersion int `json:"schema_version"`
 	// the manifest hash this vulnerability report is describing
 	Hash Digest `json:"manifest_hash"`
 	// all discovered packages in this manifest keyed by package id
@@ -21,4 +26,40 @@ type VulnerabilityReport struct {
 	PackageVulnerabilities map[string][]string `json:"package_vulnerabilities"`
 	// a map of enrichments keyed by a type.
 	Enrichments map[string][]json.RawMessage `json:"enrichments"`
+	// a lookup table associating package ids with details of how each of the
+	// package's vulnerabilities was matched. keyed by package id
+	MatchDetails map[string][]*MatchDetail `json:"match_details,omitempty"`
 }
+
+// MatchDetail records how a vulnerability was matched to a package.
+type MatchDetail struct {
+	// the id of the matched vulnerability
+	Vulnerability string `json:"vulnerability_id"`
+	// the name of the matcher that reported the match
+	Matcher string `json:"matcher"`
+	// the id of the distribution the match was made in the context of, if
+	// the decision used one
+	Distribution string `json:"distribution_id,omitempty"`
+	// the id of the repository the match was made in the context of, if the
+	// decision used one
+	Repository string `json:"repository_id,omitempty"`
+	// how the package's version was compared to the vulnerability
+	Method MatchMethod `json:"method,omitempty"`
+}
+
+// MatchMethod describes how a package's version was found to be affected by
+// a vulnerability.
+type MatchMethod string
+
+// These are the known MatchMethods.
+const (
+	// The version was found in the vulnerability's range of affected
+	// versions.
+	MatchRange MatchMethod = "range"
+	// The version was compared to the vulnerability's fixed version.
+	MatchFixedVersion MatchMethod = "fixed_version"
+	// The vulnerability has no fix, so every version is affected.
+	MatchUnfixed MatchMethod = "unfixed"
+	// The match was made by a remote service.
+	MatchRemote MatchMethod = "remote"
+)